@@ -0,0 +1,154 @@
+package chunkbench
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
+)
+
+// rawSampleSize is the size of an uncompressed sample: an int64 timestamp
+// and a float64 value
+const rawSampleSize = 16
+
+// Result is the measurement of a dataset
+type Result struct {
+	// Ratio is the size of the raw samples over the size of the chunk
+	// encoding them, header and checksum included
+	Ratio float64 `json:"ratio"`
+
+	// Samples encoded and decoded per second; unset unless measured
+	EncodeSamplesPerSec float64 `json:"encodeSamplesPerSec,omitempty"`
+	DecodeSamplesPerSec float64 `json:"decodeSamplesPerSec,omitempty"`
+}
+
+// Baselines are the results measurements are compared with, by dataset
+type Baselines map[string]Result
+
+// Tolerance is how far below its baseline a result may fall, as a
+// fraction of the baseline
+type Tolerance struct {
+	Ratio      float64
+	Throughput float64
+}
+
+// DefaultTolerance allows for rounding in the compression ratio, and for
+// noise and slower machines in throughput
+var DefaultTolerance = Tolerance{Ratio: 0.01, Throughput: 0.5}
+
+// Encode returns the chunk of samples, as a block writes it
+func Encode(samples []series.Sample) (*storage.Chunk, error) {
+	chunk := storage.NewChunk()
+	if err := chunk.Append(samples); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// Decode returns the samples of chunk
+func Decode(chunk *storage.Chunk) ([]series.Sample, error) {
+	it, err := chunk.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]series.Sample, 0, chunk.NumSamples)
+	for it.Next() {
+		sample, err := it.At()
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+	return samples, it.Err()
+}
+
+// Measure encodes ds and returns its compression ratio, and its encoding
+// and decoding throughput if throughput is set. Throughput is measured
+// with testing.Benchmark, which takes a second or so per dataset.
+func Measure(ds Dataset, throughput bool) (Result, error) {
+	chunk, err := Encode(ds.Samples)
+	if err != nil {
+		return Result{}, fmt.Errorf("dataset %s: %w", ds.Name, err)
+	}
+	decoded, err := Decode(chunk)
+	if err != nil {
+		return Result{}, fmt.Errorf("dataset %s: %w", ds.Name, err)
+	}
+	if len(decoded) != len(ds.Samples) {
+		return Result{}, fmt.Errorf("dataset %s: decoded %d samples, encoded %d", ds.Name, len(decoded), len(ds.Samples))
+	}
+
+	result := Result{Ratio: float64(len(ds.Samples)*rawSampleSize) / float64(chunk.Size())}
+	if !throughput {
+		return result, nil
+	}
+
+	encode := testing.Benchmark(func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := Encode(ds.Samples); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	decode := testing.Benchmark(func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := Decode(chunk); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	result.EncodeSamplesPerSec = samplesPerSec(encode, len(ds.Samples))
+	result.DecodeSamplesPerSec = samplesPerSec(decode, len(ds.Samples))
+	return result, nil
+}
+
+// samplesPerSec returns the throughput of a benchmark handling n samples
+// per iteration
+func samplesPerSec(r testing.BenchmarkResult, n int) float64 {
+	if r.T <= 0 {
+		return 0
+	}
+	return float64(r.N) * float64(n) / r.T.Seconds()
+}
+
+// Compare returns the regressions of got from want, the result and
+// baseline of a dataset. Throughput is only compared when both have it.
+func Compare(name string, got, want Result, tol Tolerance) []string {
+	var regressions []string
+	if got.Ratio < want.Ratio*(1-tol.Ratio) {
+		regressions = append(regressions, fmt.Sprintf("%s: compression ratio %.2f, baseline %.2f", name, got.Ratio, want.Ratio))
+	}
+	check := func(what string, got, want float64) {
+		if got > 0 && want > 0 && got < want*(1-tol.Throughput) {
+			regressions = append(regressions, fmt.Sprintf("%s: %s %.0f samples/s, baseline %.0f", name, what, got, want))
+		}
+	}
+	check("encoding", got.EncodeSamplesPerSec, want.EncodeSamplesPerSec)
+	check("decoding", got.DecodeSamplesPerSec, want.DecodeSamplesPerSec)
+	return regressions
+}
+
+// ReadBaselines reads the baselines in the JSON file at path
+func ReadBaselines(path string) (Baselines, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read baselines: %w", err)
+	}
+	var baselines Baselines
+	if err := json.Unmarshal(data, &baselines); err != nil {
+		return nil, fmt.Errorf("failed to parse baselines %s: %w", path, err)
+	}
+	return baselines, nil
+}
+
+// WriteFile writes the baselines to path as JSON, sorted by dataset name
+func (b Baselines) WriteFile(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,123 @@
+package chunkbench
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	update     = flag.Bool("update", false, "rewrite testdata/baselines.json with the current results")
+	throughput = flag.Bool("throughput", false, "also measure and compare encoding and decoding throughput")
+)
+
+// baselinesFile holds the golden results of the datasets
+var baselinesFile = filepath.Join("testdata", "baselines.json")
+
+func TestBaselines(t *testing.T) {
+	baselines, err := ReadBaselines(baselinesFile)
+	if err != nil && !*update {
+		t.Fatal(err)
+	}
+
+	measured := make(Baselines)
+	for _, ds := range Datasets() {
+		got, err := Measure(ds, *throughput)
+		if err != nil {
+			t.Fatal(err)
+		}
+		measured[ds.Name] = got
+		if *throughput {
+			t.Logf("%s: ratio %.2f, encoding %.0f samples/s, decoding %.0f samples/s",
+				ds.Name, got.Ratio, got.EncodeSamplesPerSec, got.DecodeSamplesPerSec)
+		} else {
+			t.Logf("%s: ratio %.2f", ds.Name, got.Ratio)
+		}
+		if *update {
+			continue
+		}
+
+		want, ok := baselines[ds.Name]
+		if !ok {
+			t.Errorf("%s: no baseline; run go test -update", ds.Name)
+			continue
+		}
+		for _, regression := range Compare(ds.Name, got, want, DefaultTolerance) {
+			t.Error(regression)
+		}
+	}
+
+	if *update {
+		// Throughput baselines are kept unless measured
+		for name, result := range measured {
+			if old, ok := baselines[name]; ok && !*throughput {
+				result.EncodeSamplesPerSec = old.EncodeSamplesPerSec
+				result.DecodeSamplesPerSec = old.DecodeSamplesPerSec
+				measured[name] = result
+			}
+		}
+		if err := measured.WriteFile(baselinesFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDatasetsReproducible(t *testing.T) {
+	first, second := Datasets(), Datasets()
+	for i, ds := range first {
+		if len(ds.Samples) != DatasetSamples {
+			t.Errorf("%s: %d samples, want %d", ds.Name, len(ds.Samples), DatasetSamples)
+		}
+		for j, sample := range ds.Samples {
+			if j > 0 && sample.Timestamp <= ds.Samples[j-1].Timestamp {
+				t.Fatalf("%s: sample %d at %d not after %d", ds.Name, j, sample.Timestamp, ds.Samples[j-1].Timestamp)
+			}
+			if sample != second[i].Samples[j] {
+				t.Fatalf("%s: sample %d differs between calls", ds.Name, j)
+			}
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	want := Result{Ratio: 10, EncodeSamplesPerSec: 1e6, DecodeSamplesPerSec: 1e7}
+	tol := Tolerance{Ratio: 0.1, Throughput: 0.5}
+
+	if regressions := Compare("x", Result{Ratio: 9.5}, want, tol); len(regressions) != 0 {
+		t.Errorf("regressions within tolerance, without throughput: %v", regressions)
+	}
+	if regressions := Compare("x", Result{Ratio: 8.9, EncodeSamplesPerSec: 4e5, DecodeSamplesPerSec: 1e7}, want, tol); len(regressions) != 2 {
+		t.Errorf("regressions = %v, want the ratio and encoding", regressions)
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	for _, ds := range Datasets() {
+		b.Run(ds.Name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := Encode(ds.Samples); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(b.N*len(ds.Samples))/b.Elapsed().Seconds(), "samples/sec")
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, ds := range Datasets() {
+		b.Run(ds.Name, func(b *testing.B) {
+			chunk, err := Encode(ds.Samples)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := Decode(chunk); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(b.N*len(ds.Samples))/b.Elapsed().Seconds(), "samples/sec")
+		})
+	}
+}
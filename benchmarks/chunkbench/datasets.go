@@ -0,0 +1,124 @@
+// Package chunkbench measures how well chunk encoding compresses canned
+// datasets shaped like real series, and how fast it encodes and decodes
+// them. Its tests compare the results with golden baselines in testdata,
+// so a change to the encoding can't silently give up the compression
+// ratios the documentation advertises.
+//
+// The datasets are generated from fixed seeds, so every run encodes the
+// same samples and compression ratios are exactly reproducible.
+// Throughput depends on the machine and is only compared on request.
+package chunkbench
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+const (
+	// DatasetSamples is the number of samples in each dataset: six hours
+	// of a series scraped every 15 seconds
+	DatasetSamples = 1440
+
+	// datasetStart is the timestamp of the first sample of each dataset
+	datasetStart = int64(1_700_000_000_000)
+
+	// scrapeInterval is the interval between samples in milliseconds
+	scrapeInterval = int64(15_000)
+)
+
+// Dataset is a named series of samples to encode
+type Dataset struct {
+	Name    string
+	Samples []series.Sample
+}
+
+// Datasets returns the canned datasets, in a fixed order:
+//   - counter: a request counter scraped with a little jitter, growing by
+//     about 100 requests a scrape and reset once, as on a restart
+//   - gauge: a random walk rounded to two decimals, such as CPU usage
+//   - sparse: a value that rarely changes, scraped with long gaps, such as
+//     a batch job's last success time
+//   - flapping: a 0/1 health check that goes up and down
+func Datasets() []Dataset {
+	return []Dataset{
+		{Name: "counter", Samples: counter(rand.New(rand.NewSource(1)))},
+		{Name: "gauge", Samples: gauge(rand.New(rand.NewSource(2)))},
+		{Name: "sparse", Samples: sparse(rand.New(rand.NewSource(3)))},
+		{Name: "flapping", Samples: flapping(rand.New(rand.NewSource(4)))},
+	}
+}
+
+// nextScrape returns the timestamp of the scrape after ts; one in ten is
+// up to 5ms late or early
+func nextScrape(r *rand.Rand, ts int64) int64 {
+	ts += scrapeInterval
+	if r.Intn(10) == 0 {
+		ts += r.Int63n(11) - 5
+	}
+	return ts
+}
+
+func counter(r *rand.Rand) []series.Sample {
+	samples := make([]series.Sample, DatasetSamples)
+	ts, value := datasetStart, 0.0
+	for i := range samples {
+		if i > 0 {
+			ts = nextScrape(r, ts)
+			value += float64(90 + r.Intn(21))
+		}
+		if i == DatasetSamples/2 {
+			value = 0
+		}
+		samples[i] = series.Sample{Timestamp: ts, Value: value}
+	}
+	return samples
+}
+
+func gauge(r *rand.Rand) []series.Sample {
+	samples := make([]series.Sample, DatasetSamples)
+	ts, value := datasetStart, 50.0
+	for i := range samples {
+		if i > 0 {
+			ts += scrapeInterval
+			value = math.Min(100, math.Max(0, value+r.NormFloat64()))
+		}
+		samples[i] = series.Sample{Timestamp: ts, Value: math.Round(value*100) / 100}
+	}
+	return samples
+}
+
+func sparse(r *rand.Rand) []series.Sample {
+	samples := make([]series.Sample, DatasetSamples)
+	ts, value := datasetStart, float64(datasetStart/1000)
+	for i := range samples {
+		if i > 0 {
+			ts += scrapeInterval
+			if r.Intn(20) == 0 {
+				// Missed scrapes
+				ts += scrapeInterval * (1 + r.Int63n(40))
+			}
+			if r.Intn(100) == 0 {
+				value = float64(ts / 1000)
+			}
+		}
+		samples[i] = series.Sample{Timestamp: ts, Value: value}
+	}
+	return samples
+}
+
+func flapping(r *rand.Rand) []series.Sample {
+	samples := make([]series.Sample, DatasetSamples)
+	ts, value := datasetStart, 1.0
+	for i := range samples {
+		if i > 0 {
+			ts += scrapeInterval
+			if r.Intn(10) == 0 {
+				value = 1 - value
+			}
+		}
+		samples[i] = series.Sample{Timestamp: ts, Value: value}
+	}
+	return samples
+}
@@ -2,6 +2,7 @@ package benchmarks
 
 import (
 	"fmt"
+	"sync/atomic"
 	"testing"
 
 	"github.com/therealutkarshpriyadarshi/time/pkg/index"
@@ -40,6 +41,27 @@ func BenchmarkInvertedIndex_Add_VaryingLabels(b *testing.B) {
 	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "series/sec")
 }
 
+// BenchmarkInvertedIndex_Add_Parallel benchmarks concurrent series creation,
+// as happens when many scrape targets are ingested at once.
+func BenchmarkInvertedIndex_Add_Parallel(b *testing.B) {
+	idx := index.NewInvertedIndex()
+	var nextID atomic.Uint64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			id := nextID.Add(1)
+			labels := map[string]string{
+				"__name__": fmt.Sprintf("metric%d", id%50),
+				"instance": fmt.Sprintf("host%d", id%1000),
+				"job":      "node",
+			}
+			idx.Add(series.SeriesID(id), labels)
+		}
+	})
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "series/sec")
+}
+
 // BenchmarkInvertedIndex_Lookup_Equal benchmarks exact match queries.
 func BenchmarkInvertedIndex_Lookup_Equal(b *testing.B) {
 	idx := index.NewInvertedIndex()
@@ -134,6 +156,39 @@ func BenchmarkInvertedIndex_Lookup_Complex(b *testing.B) {
 	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "queries/sec")
 }
 
+// BenchmarkInvertedIndex_Lookup_NotEqual benchmarks a lone negative matcher,
+// which has to start from the set of all series. This must not scale with
+// the number of posting lists in the index.
+func BenchmarkInvertedIndex_Lookup_NotEqual(b *testing.B) {
+	idx := index.NewInvertedIndex()
+
+	// Populate index with 100k series and many distinct posting lists
+	for i := 1; i <= 100000; i++ {
+		labels := map[string]string{
+			"host":     fmt.Sprintf("server%d", i%1000),
+			"metric":   fmt.Sprintf("metric%d", i%100),
+			"instance": fmt.Sprintf("instance%d", i),
+		}
+		idx.Add(series.SeriesID(i), labels)
+	}
+
+	matchers := index.Matchers{
+		index.MustNewMatcher(index.MatchNotEqual, "host", "server500"),
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result, err := idx.Lookup(matchers)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if result.GetCardinality() == 0 {
+			b.Fatal("no results")
+		}
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "queries/sec")
+}
+
 // BenchmarkInvertedIndex_Lookup_10M benchmarks lookup on 10 million series.
 func BenchmarkInvertedIndex_Lookup_10M(b *testing.B) {
 	if testing.Short() {
@@ -280,7 +335,7 @@ func BenchmarkRegistry_Get(b *testing.B) {
 	registry := series.NewRegistry(series.RegistryConfig{})
 	s := series.NewSeries(map[string]string{"host": "server1"})
 	registry.GetOrCreate(s)
-	hash := s.Hash()
+	hash := s.Hash
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -2,6 +2,7 @@ package benchmarks
 
 import (
 	"fmt"
+	"sync/atomic"
 	"testing"
 
 	"github.com/therealutkarshpriyadarshi/time/pkg/series"
@@ -146,6 +147,38 @@ func BenchmarkMemTableQueryTimeRange(b *testing.B) {
 	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "queries/sec")
 }
 
+// BenchmarkMemTableQueryOutOfOrder measures query performance when inserts
+// interleave, so every query has to sort its result
+func BenchmarkMemTableQueryOutOfOrder(b *testing.B) {
+	mt := storage.NewMemTable()
+	s := series.NewSeries(map[string]string{
+		"__name__": "cpu_usage",
+		"host":     "server1",
+	})
+
+	// Insert 1000 samples as two interleaved batches
+	for offset := 1; offset >= 0; offset-- {
+		samples := make([]series.Sample, 500)
+		for i := range samples {
+			samples[i] = series.Sample{
+				Timestamp: int64((2*i + offset) * 1000),
+				Value:     float64(2*i + offset),
+			}
+		}
+		mt.Insert(s, samples)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := mt.Query(s.Hash, 0, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "queries/sec")
+}
+
 // BenchmarkMemTableConcurrentInsert measures concurrent insert performance
 func BenchmarkMemTableConcurrentInsert(b *testing.B) {
 	mt := storage.NewMemTable()
@@ -171,6 +204,36 @@ func BenchmarkMemTableConcurrentInsert(b *testing.B) {
 	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "inserts/sec")
 }
 
+// BenchmarkMemTableConcurrentInsertShards measures parallel ingestion of many
+// series with a single shard versus the default lock striping.
+func BenchmarkMemTableConcurrentInsertShards(b *testing.B) {
+	for _, shards := range []int{1, storage.DefaultMemTableShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			mt := storage.NewShardedMemTable(1<<40, shards)
+
+			seriesList := make([]*series.Series, 1000)
+			for i := range seriesList {
+				seriesList[i] = series.NewSeries(map[string]string{
+					"__name__": "cpu_usage",
+					"host":     fmt.Sprintf("server%d", i),
+				})
+			}
+			samples := []series.Sample{{Timestamp: 1000, Value: 0.85}}
+
+			var next atomic.Uint64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := next.Add(1)
+					mt.Insert(seriesList[i%uint64(len(seriesList))], samples)
+				}
+			})
+
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "inserts/sec")
+		})
+	}
+}
+
 // BenchmarkMemTableConcurrentRead measures concurrent read performance
 func BenchmarkMemTableConcurrentRead(b *testing.B) {
 	mt := storage.NewMemTable()
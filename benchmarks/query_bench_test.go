@@ -1,7 +1,10 @@
 package benchmarks
 
 import (
+	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/therealutkarshpriyadarshi/time/pkg/index"
 	"github.com/therealutkarshpriyadarshi/time/pkg/query"
@@ -366,6 +369,108 @@ func BenchmarkQueryEngine_Aggregate_GroupBy(b *testing.B) {
 	}
 }
 
+func BenchmarkQueryEngine_Aggregate_Large(b *testing.B) {
+	db := setupBenchDB(b)
+	defer db.Close()
+
+	// Insert 100 series with 10000 samples each, flushed to a block
+	for seriesIdx := 0; seriesIdx < 100; seriesIdx++ {
+		s := series.NewSeries(map[string]string{
+			"__name__": "http_requests",
+			"host":     fmt.Sprintf("server%d", seriesIdx),
+			"region":   []string{"us-east", "us-west", "eu-west"}[seriesIdx%3],
+		})
+
+		samples := make([]series.Sample, 10000)
+		for i := range samples {
+			samples[i] = series.Sample{
+				Timestamp: int64(i * 1000),
+				Value:     float64(i % 100),
+			}
+		}
+
+		if err := db.Insert(s, samples); err != nil {
+			b.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		b.Fatalf("failed to flush: %v", err)
+	}
+
+	qe := query.NewQueryEngine(db)
+
+	aq := &query.AggregationQuery{
+		Query: &query.Query{
+			MinTime: 0,
+			MaxTime: 10000000,
+		},
+		Function: query.Sum,
+		Step:     60000, // 1 minute buckets
+		GroupBy:  []string{"region"},
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result, err := qe.Aggregate(aq)
+		if err != nil {
+			b.Fatalf("aggregation failed: %v", err)
+		}
+		if len(result.Series) != 3 {
+			b.Fatalf("got %d groups, want 3", len(result.Series))
+		}
+	}
+}
+
+func BenchmarkQueryEngine_Sharded_30Days(b *testing.B) {
+	db := setupBenchDB(b)
+	defer db.Close()
+
+	// A few series with a sample a minute over 30 days, one block per day:
+	// too few series for parallel series reads alone to use every core
+	day := 24 * time.Hour.Milliseconds()
+	start := time.Now().Add(-30 * 24 * time.Hour).UnixMilli()
+	for d := int64(0); d < 30; d++ {
+		for seriesIdx := 0; seriesIdx < 4; seriesIdx++ {
+			s := series.NewSeries(map[string]string{
+				"__name__": "cpu_usage",
+				"host":     fmt.Sprintf("server%d", seriesIdx),
+			})
+			samples := make([]series.Sample, 1440)
+			for i := range samples {
+				samples[i] = series.Sample{
+					Timestamp: start + d*day + int64(i)*60000,
+					Value:     float64(i % 100),
+				}
+			}
+			if err := db.Insert(s, samples); err != nil {
+				b.Fatalf("failed to insert: %v", err)
+			}
+		}
+		if err := db.TriggerFlush(context.Background()); err != nil {
+			b.Fatalf("failed to flush: %v", err)
+		}
+	}
+
+	q := &query.Query{MinTime: start, MaxTime: start + 30*day}
+	for _, shards := range []int{1, 8} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			qe := query.NewQueryEngine(db)
+			qe.SetSharding(shards, 0)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				result, err := qe.ExecQuery(q)
+				if err != nil {
+					b.Fatalf("query failed: %v", err)
+				}
+				if len(result.Series) != 4 {
+					b.Fatalf("got %d series, want 4", len(result.Series))
+				}
+			}
+		})
+	}
+}
+
 func BenchmarkQueryEngine_WithMatchers(b *testing.B) {
 	db := setupBenchDB(b)
 	defer db.Close()
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+)
+
+// envPrefix prefixes the environment variables that can set start flags
+const envPrefix = "TSDB_"
+
+// envName returns the environment variable for a flag, e.g. TSDB_DATA_DIR
+// for --data-dir
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// applyEnv sets every flag not given on the command line from its
+// environment variable, if set. Command-line flags take precedence. It
+// returns the names of the flags set from the environment.
+func applyEnv(flags *pflag.FlagSet) (map[string]bool, error) {
+	fromEnv := make(map[string]bool)
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed {
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid %s: %w", envName(f.Name), setErr)
+			return
+		}
+		fromEnv[f.Name] = true
+	})
+	return fromEnv, err
+}
+
+// printConfig writes the effective value of every flag and where it came
+// from: the default, the command line or the environment
+func printConfig(w io.Writer, flags *pflag.FlagSet, fromEnv map[string]bool) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "OPTION\tVALUE\tSOURCE")
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Name == "help" || f.Name == "print-config" {
+			return
+		}
+		source := "default"
+		switch {
+		case fromEnv[f.Name]:
+			source = envName(f.Name)
+		case f.Changed:
+			source = "flag"
+		}
+		fmt.Fprintf(tw, "--%s\t%s\t%s\n", f.Name, f.Value.String(), source)
+	})
+	return tw.Flush()
+}
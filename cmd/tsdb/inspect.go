@@ -6,14 +6,20 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"text/tabwriter"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/therealutkarshpriyadarshi/time/pkg/api"
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
 )
 
 var (
-	inspectAddr string
+	inspectAddr       string
+	inspectDataDir    string
+	inspectBlockMatch string
+	inspectWAL        bool
 )
 
 var inspectCmd = &cobra.Command{
@@ -32,7 +38,14 @@ Examples:
   tsdb inspect label-values host
 
   # View server health
-  tsdb inspect health`,
+  tsdb inspect health
+
+  # List the blocks of a data directory and their labels
+  tsdb inspect blocks --data-dir=./data --match='{source="backfill"}'
+
+  # List the WAL segments, the last fsync and the replay backlog
+  tsdb inspect --wal`,
+	RunE: runInspect,
 }
 
 var inspectStatusCmd = &cobra.Command{
@@ -60,13 +73,116 @@ var inspectHealthCmd = &cobra.Command{
 	RunE:  runInspectHealth,
 }
 
+var inspectBlocksCmd = &cobra.Command{
+	Use:   "blocks",
+	Short: "List the blocks of a data directory with their labels",
+	Long: `List the blocks of a data directory with their time range, size and
+the labels recorded in their meta: compaction level, source (flush,
+compaction or backfill), tenant and the version that wrote them.
+
+The data directory is read directly, so the server does not need to be
+running.`,
+	RunE: runInspectBlocks,
+}
+
 func init() {
 	inspectCmd.PersistentFlags().StringVar(&inspectAddr, "addr", "http://localhost:8080", "TSDB server address")
+	inspectCmd.Flags().BoolVar(&inspectWAL, "wal", false, "List the WAL segments, the last fsync and the replay backlog")
 
 	inspectCmd.AddCommand(inspectStatusCmd)
 	inspectCmd.AddCommand(inspectLabelsCmd)
 	inspectCmd.AddCommand(inspectLabelValuesCmd)
 	inspectCmd.AddCommand(inspectHealthCmd)
+	inspectCmd.AddCommand(inspectBlocksCmd)
+
+	inspectBlocksCmd.Flags().StringVar(&inspectDataDir, "data-dir", "./data", "Data directory path")
+	inspectBlocksCmd.Flags().StringVar(&inspectBlockMatch, "match", "", "Only list blocks whose labels match, e.g. '{tenant=\"a\"}'")
+}
+
+func runInspect(cmd *cobra.Command, args []string) error {
+	if !inspectWAL {
+		return cmd.Help()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	url := inspectAddr + "/api/v1/status/wal"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var walResp api.WALStatusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&walResp); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if walResp.Status != "success" || walResp.Data == nil {
+		return fmt.Errorf("request failed: %s", walResp.Status)
+	}
+	data := walResp.Data
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintln(out, "WAL Status:")
+	fmt.Fprintln(out, "=============")
+	fmt.Fprintf(out, "Segments:            %d\n", len(data.Segments))
+	fmt.Fprintf(out, "Size:                %d bytes (%.2f MB)\n", data.Size, float64(data.Size)/(1024*1024))
+	fmt.Fprintf(out, "Entries:             %d\n", data.Entries)
+	if data.OldestEntry > 0 {
+		oldest := time.UnixMilli(data.OldestEntry)
+		fmt.Fprintf(out, "Oldest Entry:        %s (%s ago)\n", oldest.Format(time.RFC3339), time.Since(oldest).Round(time.Second))
+	} else {
+		fmt.Fprintf(out, "Oldest Entry:        None\n")
+	}
+	if data.ReplayEstimateSeconds > 0 {
+		fmt.Fprintf(out, "Replay Estimate:     %s\n", secondsDuration(data.ReplayEstimateSeconds))
+	} else {
+		fmt.Fprintf(out, "Replay Estimate:     Unknown\n")
+	}
+	if data.LastSyncTime > 0 {
+		fmt.Fprintf(out, "Last Fsync:          %s (%s ago)\n", secondsDuration(data.LastSyncSeconds),
+			time.Since(time.UnixMilli(data.LastSyncTime)).Round(time.Second))
+	} else {
+		fmt.Fprintf(out, "Last Fsync:          Never\n")
+	}
+	fmt.Fprintln(out)
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEGMENT\tULID\tSIZE\tENTRIES\tMIN TIME\tMAX TIME")
+	for _, seg := range data.Segments {
+		name := seg.Name
+		if seg.Current {
+			name += " (current)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\n",
+			name, seg.ULID, seg.Size, seg.Entries, formatMillis(seg.MinTime), formatMillis(seg.MaxTime))
+	}
+	return tw.Flush()
+}
+
+// secondsDuration converts fractional seconds to a duration for printing
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Microsecond)
+}
+
+// formatMillis formats a Unix millisecond timestamp, "-" if unset
+func formatMillis(ms int64) string {
+	if ms == 0 {
+		return "-"
+	}
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339)
 }
 
 func runInspectStatus(cmd *cobra.Command, args []string) error {
@@ -216,12 +332,65 @@ func runInspectHealth(cmd *cobra.Command, args []string) error {
 	}
 	defer resp.Body.Close()
 
+	bodyBytes, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode == http.StatusOK {
+		var health api.HealthResponse
+		if err := json.Unmarshal(bodyBytes, &health); err == nil && health.Status != string(storage.HealthOK) {
+			fmt.Printf("⚠ TSDB is degraded (%s): %s\n", health.Status, health.Message)
+			return nil
+		}
 		fmt.Println("✓ TSDB is healthy")
 		return nil
 	}
 
-	bodyBytes, _ := io.ReadAll(resp.Body)
 	fmt.Printf("✗ TSDB is unhealthy (status: %d, body: %s)\n", resp.StatusCode, string(bodyBytes))
 	return fmt.Errorf("health check failed")
 }
+
+func runInspectBlocks(cmd *cobra.Command, args []string) error {
+	var matchers index.Matchers
+	if inspectBlockMatch != "" {
+		var err error
+		if matchers, err = index.ParseMatchers(inspectBlockMatch); err != nil {
+			return fmt.Errorf("invalid --match %q: %w", inspectBlockMatch, err)
+		}
+	}
+
+	reader := storage.NewBlockReader(inspectDataDir)
+	if err := reader.LoadBlocks(); err != nil {
+		return err
+	}
+	blocks := reader.Blocks()
+	defer func() {
+		for _, block := range blocks {
+			block.Close()
+		}
+	}()
+
+	tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "BLOCK\tLEVEL\tMIN TIME\tMAX TIME\tSERIES\tSIZE\tLABELS")
+	for _, block := range blocks {
+		if !matchesAll(matchers, block.Labels) {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%d\t%d\t%s\n",
+			block.ULID,
+			block.Level,
+			time.UnixMilli(block.MinTime).UTC().Format(time.RFC3339),
+			time.UnixMilli(block.MaxTime).UTC().Format(time.RFC3339),
+			block.NumSeries,
+			block.Size(),
+			formatLabels(block.Labels))
+	}
+	return tw.Flush()
+}
+
+// matchesAll reports whether labels satisfy every matcher
+func matchesAll(matchers index.Matchers, labels map[string]string) bool {
+	for _, m := range matchers {
+		if !m.MatchesLabels(labels) {
+			return false
+		}
+	}
+	return true
+}
@@ -5,6 +5,8 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
 )
 
 var (
@@ -14,9 +16,32 @@ var (
 )
 
 func main() {
+	storage.Version = version
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode maps an error to the process exit status by its kind, so
+// scripts can tell failures apart, e.g. to retry while the database is
+// unavailable
+func exitCode(err error) int {
+	switch tsdberrors.KindOf(err) {
+	case tsdberrors.Invalid:
+		return 2
+	case tsdberrors.NotFound:
+		return 3
+	case tsdberrors.Conflict:
+		return 4
+	case tsdberrors.ResourceExhausted:
+		return 5
+	case tsdberrors.Unavailable:
+		return 6
+	case tsdberrors.Corruption:
+		return 7
+	default:
+		return 1
 	}
 }
 
@@ -39,4 +64,7 @@ func init() {
 	rootCmd.AddCommand(writeCmd)
 	rootCmd.AddCommand(queryCmd)
 	rootCmd.AddCommand(inspectCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(restoreCmd)
+	rootCmd.AddCommand(dumpCmd)
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
+)
+
+var (
+	migrateDataDir   string
+	migrateBackupDir string
+	migrateDryRun    bool
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade on-disk blocks to the current format",
+	Long: `Upgrade all blocks in the data directory to the current on-disk format.
+
+Blocks are upgraded in place. Every file that is rewritten (meta.json and
+index) is first copied to the backup directory; chunk files are never
+modified. Blocks already in the current format are skipped, so the command
+is safe to re-run. Stop the server before migrating.
+
+Examples:
+  # Show which blocks would be upgraded
+  tsdb migrate --data-dir=./data --dry-run
+
+  # Upgrade blocks, keeping backups in ./data-backup
+  tsdb migrate --data-dir=./data --backup-dir=./data-backup`,
+	RunE: runMigrate,
+}
+
+func init() {
+	migrateCmd.Flags().StringVar(&migrateDataDir, "data-dir", "./data", "Data directory path")
+	migrateCmd.Flags().StringVar(&migrateBackupDir, "backup-dir", "", "Backup directory (default: <data-dir>/migrate-backup-<timestamp>)")
+	migrateCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "Report blocks needing migration without changing them")
+}
+
+func runMigrate(cmd *cobra.Command, args []string) error {
+	backupDir := migrateBackupDir
+	if backupDir == "" {
+		backupDir = filepath.Join(migrateDataDir, "migrate-backup-"+time.Now().UTC().Format("20060102T150405Z"))
+	}
+
+	results, err := storage.MigrateDataDir(migrateDataDir, storage.MigrateOptions{
+		BackupDir: backupDir,
+		DryRun:    migrateDryRun,
+	})
+
+	migrated := 0
+	for _, r := range results {
+		status := "up to date"
+		if r.Migrated {
+			migrated++
+			status = fmt.Sprintf("v%d -> v%d", r.FromVersion, storage.BlockVersion)
+			if migrateDryRun {
+				status = "needs migration (" + status + ")"
+			}
+		}
+		fmt.Printf("%s  %s\n", r.Block, status)
+		if r.Note != "" {
+			fmt.Printf("  note: %s\n", r.Note)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case migrateDryRun:
+		fmt.Printf("\n%d of %d blocks need migration\n", migrated, len(results))
+	case migrated > 0:
+		fmt.Printf("\nMigrated %d of %d blocks (backup: %s)\n", migrated, len(results), backupDir)
+	default:
+		fmt.Printf("\nAll %d blocks are up to date\n", len(results))
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
+)
+
+var (
+	restoreDataDir string
+	restoreDryRun  bool
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <backup>",
+	Short: "Restore blocks from a backup into a data directory",
+	Long: `Copy the blocks of a backup into the data directory.
+
+The backup is a directory or a tar archive downloaded from the backup API;
+use "-" to read an archive from stdin. Samples in the backup's WAL are
+restored as one more block. Metric metadata is not restored.
+
+Each block is staged under a temporary name and moved into place once
+complete, so the server may keep running. A block whose ULID is already
+taken is skipped if the existing block is identical, and restored under a
+new ULID otherwise. A block overlapping existing blocks is marked for
+vertical compaction, which merges it with them; until then queries merge
+the overlapping samples. Blocks older than the server's retention period
+are deleted by the next retention run unless held.
+
+Examples:
+  # Show what would be restored
+  tsdb restore backup.tar --data-dir=./data --dry-run
+
+  # Restore an unpacked backup
+  tsdb restore ./backup --data-dir=./data
+
+  # Restore straight from the server
+  curl -s -X POST http://localhost:8080/api/v1/admin/backups  # note the ID
+  curl -s http://localhost:8080/api/v1/admin/backups/<id> | tsdb restore - --data-dir=./data`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRestore,
+}
+
+func init() {
+	restoreCmd.Flags().StringVar(&restoreDataDir, "data-dir", "./data", "Data directory path")
+	restoreCmd.Flags().BoolVar(&restoreDryRun, "dry-run", false, "Report what would be restored without changing anything")
+}
+
+func runRestore(cmd *cobra.Command, args []string) error {
+	opts := storage.RestoreOptions{DryRun: restoreDryRun}
+
+	var results []storage.RestoreResult
+	var err error
+	switch src := args[0]; {
+	case src == "-":
+		results, err = storage.RestoreArchive(restoreDataDir, os.Stdin, opts)
+	default:
+		info, statErr := os.Stat(src)
+		if statErr != nil {
+			return fmt.Errorf("failed to open backup: %w", statErr)
+		}
+		if info.IsDir() {
+			results, err = storage.RestoreBackup(restoreDataDir, src, opts)
+			break
+		}
+		f, openErr := os.Open(src)
+		if openErr != nil {
+			return fmt.Errorf("failed to open backup: %w", openErr)
+		}
+		defer f.Close()
+		results, err = storage.RestoreArchive(restoreDataDir, f, opts)
+	}
+
+	restored, overlapping := 0, 0
+	for _, r := range results {
+		status := "already present"
+		if !r.Skipped {
+			restored++
+			status = "restored"
+			if restoreDryRun {
+				status = "would be restored"
+			}
+			switch {
+			case r.Renamed && r.RestoredAs == "":
+				status += " under a new ULID (ULID taken)"
+			case r.Renamed:
+				status += " as " + r.RestoredAs + " (ULID taken)"
+			case r.RestoredAs != r.Block && r.RestoredAs != "":
+				status += " as " + r.RestoredAs
+			}
+		}
+		fmt.Printf("%s  [%d, %d]  %s\n", r.Block, r.MinTime, r.MaxTime, status)
+		if len(r.Overlaps) > 0 {
+			overlapping++
+			fmt.Printf("  overlaps %s; marked for vertical compaction\n", strings.Join(r.Overlaps, ", "))
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case restoreDryRun:
+		fmt.Printf("\n%d of %d blocks would be restored, %d overlapping\n", restored, len(results), overlapping)
+	default:
+		fmt.Printf("\nRestored %d of %d blocks into %s, %d overlapping\n", restored, len(results), restoreDataDir, overlapping)
+	}
+	return nil
+}
@@ -1,27 +1,109 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/therealutkarshpriyadarshi/time/pkg/api"
+	"github.com/therealutkarshpriyadarshi/time/pkg/cdc"
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/observability"
+	"github.com/therealutkarshpriyadarshi/time/pkg/query"
+	"github.com/therealutkarshpriyadarshi/time/pkg/rollup"
 	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
+	"github.com/therealutkarshpriyadarshi/time/pkg/wal"
 )
 
 var (
 	listenAddr         string
 	dataDir            string
+	coldDir            string
+	coldAfter          string
+	blockScanInterval  string
 	retention          string
+	trashGracePeriod   string
 	enableCompaction   bool
 	enableRetention    bool
 	flushInterval      string
 	compactionInterval string
+	enableRollups      bool
+	cdcKafkaBrokers    []string
+	cdcKafkaTopic      string
+	duplicatePolicy    string
+	outOfOrderWindow   string
+	seriesRateLimit    int64
+	rateLimitWindow    string
+	rateLimitAction    string
+	labelPostingsLimit int64
+	labelLimitAction   string
+	rejectBeforeRetain bool
+	maxFutureSkew      time.Duration
+	queryMaxSeries     int
+	queryMaxSamples    int
+	queryLimitMode     string
+	queryConcurrency   int
+	queryShards        int
+	queryShardMinRange time.Duration
+	queryLookback      time.Duration
+	maxMemTableSpan    string
+	maxWALSize         int64
+	minFreeDisk        int64
+	emergencyRetention bool
+	diskCheckInterval  string
+	memTableSize       int64
+	memTableShards     int
+	walSegmentSize     int64
+	maxBlockBytes      int64
+	maxBlockSeries     int
+	backgroundIORate   int64
+	backgroundIOPause  time.Duration
+	maxLabelNames      int
+	maxLabelNameLength int
+	maxLabelValueLen   int
+	maxLabelsSize      int
+	logLevel           string
+	logFormat          string
+	printConfigOnly    bool
+	shutdownTimeout    time.Duration
+	slowFlush          time.Duration
+	slowWALSync        time.Duration
+	slowCompaction     time.Duration
+	backupIdleTimeout  time.Duration
+	httpReadTimeout    time.Duration
+	httpWriteTimeout   time.Duration
+	httpIdleTimeout    time.Duration
+	httpMaxConns       int
+	replaySkipFlushed  bool
+	retryBlockReads    bool
+	relabelConfig      string
+	enableAuditLog     bool
+	auditLogPath       string
+	queryLogPath       string
+	queryLogMaxBytes   int64
+	queryLogMaxFiles   int
+	tenant             string
+	blockRetention     []string
+	retentionBudgets   []string
+	reducePrecision    []string
+	compactionLevels   string
+	asyncWrites        bool
+	writeQueueSize     int
+	writeQueueWorkers  int
+	writeQueueOverflow string
+	spillOnFull        bool
+	ingestStatsCap     int
 )
 
 var startCmd = &cobra.Command{
@@ -32,55 +114,139 @@ var startCmd = &cobra.Command{
 The server will listen on the specified address and serve the HTTP API
 for writing and querying time-series data.
 
+Every flag can also be set through an environment variable named after
+it, prefixed with TSDB_: --data-dir is TSDB_DATA_DIR and --max-wal-size is
+TSDB_MAX_WAL_SIZE. Flags given on the command line take precedence.
+
 Example:
-  tsdb start --listen=:8080 --data-dir=./data --retention=30d`,
+  tsdb start --listen=:8080 --data-dir=./data --retention=30d
+  TSDB_RETENTION=7d tsdb start --print-config`,
 	RunE: runStart,
 }
 
 func init() {
 	startCmd.Flags().StringVar(&listenAddr, "listen", ":8080", "HTTP listen address")
 	startCmd.Flags().StringVar(&dataDir, "data-dir", "./data", "Data directory path")
+	startCmd.Flags().StringVar(&coldDir, "cold-dir", "", "Directory old blocks are moved to, e.g. on cheaper disks (empty disables)")
+	startCmd.Flags().StringVar(&coldAfter, "cold-after", "7d", "Age of the newest sample in a block before it is moved to --cold-dir")
+	startCmd.Flags().StringVar(&blockScanInterval, "block-scan-interval", storage.DefaultBlockScanInterval.String(), "How often to look for blocks added to the data directories by other tools (0 serves them unverified at once)")
 	startCmd.Flags().StringVar(&retention, "retention", "30d", "Data retention period (e.g., 30d, 7d, 24h)")
+	startCmd.Flags().StringVar(&trashGracePeriod, "trash-grace-period", "24h", "How long blocks deleted by retention are kept in the trash directory before they are deleted for good (0 deletes them at once)")
 	startCmd.Flags().BoolVar(&enableCompaction, "enable-compaction", true, "Enable background compaction")
 	startCmd.Flags().BoolVar(&enableRetention, "enable-retention", true, "Enable retention policy")
 	startCmd.Flags().StringVar(&flushInterval, "flush-interval", "30s", "MemTable flush interval")
 	startCmd.Flags().StringVar(&compactionInterval, "compaction-interval", "10m", "Compaction check interval")
+	startCmd.Flags().BoolVar(&enableRollups, "enable-rollups", true, "Evaluate rollup rules (continuous queries)")
+	startCmd.Flags().StringSliceVar(&cdcKafkaBrokers, "cdc-kafka-brokers", nil, "Kafka brokers to stream accepted samples to (host:port, comma separated)")
+	startCmd.Flags().StringVar(&cdcKafkaTopic, "cdc-kafka-topic", "tsdb-samples", "Kafka topic for streamed samples")
+	startCmd.Flags().StringVar(&duplicatePolicy, "duplicate-policy", "overwrite", "Handling of samples for existing timestamps: overwrite, keep-first or reject")
+	startCmd.Flags().StringVar(&outOfOrderWindow, "out-of-order-window", "0s", "Reject samples further than this behind their series' newest sample (0 accepts any)")
+	startCmd.Flags().Int64Var(&seriesRateLimit, "series-rate-limit", 0, "Maximum samples a single series may write per --series-rate-limit-window (0 for no limit)")
+	startCmd.Flags().StringVar(&rateLimitWindow, "series-rate-limit-window", "1m", "Window the series rate limit counts samples over")
+	startCmd.Flags().StringVar(&rateLimitAction, "series-rate-limit-action", "drop", "Handling of samples over the series rate limit: drop or aggregate")
+	startCmd.Flags().Int64Var(&labelPostingsLimit, "label-postings-limit", 0, "Maximum bytes of head index postings a single label name may hold (0 for no limit)")
+	startCmd.Flags().StringVar(&labelLimitAction, "label-postings-limit-action", "reject", "Handling of new series carrying a label over the postings limit: reject or warn")
+	startCmd.Flags().BoolVar(&rejectBeforeRetain, "reject-before-retention", false, "Reject samples older than the retention period")
+	startCmd.Flags().DurationVar(&maxFutureSkew, "max-future-skew", 0, "Reject samples further than this ahead of the clock (0 accepts any)")
+	startCmd.Flags().IntVar(&queryMaxSeries, "query-max-series", 0, "Maximum series a query may select (0 for no limit)")
+	startCmd.Flags().IntVar(&queryMaxSamples, "query-max-samples", 0, "Maximum samples a query may select (0 for no limit)")
+	startCmd.Flags().StringVar(&queryLimitMode, "query-limit-mode", "error", "Queries over a limit: error or truncate")
+	startCmd.Flags().IntVar(&queryConcurrency, "query-concurrency", 0, "Series a query reads in parallel (0 for one per CPU)")
+	startCmd.Flags().IntVar(&queryShards, "query-shards", 1, "Split long queries into this many time ranges read concurrently (1 disables)")
+	startCmd.Flags().DurationVar(&queryShardMinRange, "query-shard-min-range", query.DefaultShardMinRange, "Shortest query range split by --query-shards")
+	startCmd.Flags().DurationVar(&queryLookback, "query-lookback", storage.DefaultLatestLookback, "How far back instant queries, absent() and latest=true look for a series' samples")
+	startCmd.Flags().StringVar(&maxMemTableSpan, "max-memtable-span", "2h", "Flush the MemTable once its samples span more than this (0 disables)")
+	startCmd.Flags().Int64Var(&maxWALSize, "max-wal-size", storage.DefaultMaxWALSize, "Flush the MemTable once the WAL exceeds this many bytes (0 disables)")
+	startCmd.Flags().Int64Var(&minFreeDisk, "min-free-disk", 0, "Turn read-only when free bytes under the data directory drop below this (0 disables)")
+	startCmd.Flags().BoolVar(&emergencyRetention, "emergency-retention", false, "Delete the oldest blocks when free disk space drops below --min-free-disk")
+	startCmd.Flags().StringVar(&diskCheckInterval, "disk-check-interval", storage.DefaultDiskCheckInterval.String(), "How often to check free disk space when --min-free-disk is set")
+	startCmd.Flags().Int64Var(&memTableSize, "memtable-size", storage.DefaultMaxSize, "MemTable size in bytes")
+	startCmd.Flags().BoolVar(&spillOnFull, "spill-on-full", false, "Spill writes to disk when the MemTable is full during a flush, instead of rejecting them")
+	startCmd.Flags().IntVar(&ingestStatsCap, "ingest-stats-capacity", storage.DefaultIngestStatsCapacity, "Metric name and tenant pairs tracked by the ingest statistics (0 disables them)")
+	startCmd.Flags().IntVar(&memTableShards, "memtable-shards", storage.DefaultMemTableShards, "Number of lock-striped MemTable shards")
+	startCmd.Flags().Int64Var(&walSegmentSize, "wal-segment-size", wal.DefaultSegmentSize, "WAL segment size in bytes")
+	startCmd.Flags().Int64Var(&maxBlockBytes, "compaction-max-block-bytes", 0, "Maximum size of a compacted block in bytes (0 for no limit)")
+	startCmd.Flags().IntVar(&maxBlockSeries, "compaction-max-block-series", 0, "Maximum series in a compacted block (0 for no limit)")
+	startCmd.Flags().Int64Var(&backgroundIORate, "background-io-rate", 0, "Bytes per second compaction and retention may read and write (0 for no limit)")
+	startCmd.Flags().DurationVar(&backgroundIOPause, "background-io-pause-latency", 0, "Pause compaction and retention I/O after a query slower than this (0 to disable)")
+	startCmd.Flags().IntVar(&maxLabelNames, "max-label-names", storage.DefaultMaxLabelNamesPerSeries, "Maximum labels per series (0 for no limit)")
+	startCmd.Flags().IntVar(&maxLabelNameLength, "max-label-name-length", storage.DefaultMaxLabelNameLength, "Maximum label name length in bytes (0 for no limit)")
+	startCmd.Flags().IntVar(&maxLabelValueLen, "max-label-value-length", storage.DefaultMaxLabelValueLength, "Maximum label value length in bytes (0 for no limit)")
+	startCmd.Flags().IntVar(&maxLabelsSize, "max-labels-size", storage.DefaultMaxLabelsSize, "Maximum combined size of a series' labels in bytes (0 for no limit)")
+	startCmd.Flags().StringVar(&logLevel, "log-level", "info", "Log level: debug, info, warn or error")
+	startCmd.Flags().StringVar(&logFormat, "log-format", "text", "Log format: text or json")
+	startCmd.Flags().BoolVar(&printConfigOnly, "print-config", false, "Print the effective configuration and exit")
+	startCmd.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "Time allowed for a graceful shutdown before exiting anyway")
+	startCmd.Flags().DurationVar(&slowFlush, "slow-flush-threshold", storage.DefaultSlowFlushThreshold, "Log flushes taking longer than this (0 disables)")
+	startCmd.Flags().DurationVar(&slowWALSync, "slow-wal-sync-threshold", storage.DefaultSlowWALSyncThreshold, "Log WAL syncs taking longer than this (0 disables)")
+	startCmd.Flags().DurationVar(&slowCompaction, "slow-compaction-threshold", storage.DefaultSlowCompactionThreshold, "Log compaction merges taking longer than this (0 disables)")
+	startCmd.Flags().DurationVar(&backupIdleTimeout, "backup-idle-timeout", api.DefaultBackupIdleTimeout, "Release backups not downloaded for this long")
+	startCmd.Flags().DurationVar(&httpReadTimeout, "http-read-timeout", api.DefaultReadTimeout, "Time allowed to read a request (0 disables)")
+	startCmd.Flags().DurationVar(&httpWriteTimeout, "http-write-timeout", api.DefaultWriteTimeout, "Time allowed to answer a request, including running its query (0 disables)")
+	startCmd.Flags().DurationVar(&httpIdleTimeout, "http-idle-timeout", api.DefaultIdleTimeout, "Close keep-alive connections idle for this long (0 disables)")
+	startCmd.Flags().IntVar(&httpMaxConns, "http-max-connections", 0, "Answer 503 to requests past this many open connections (0 for no limit)")
+	startCmd.Flags().BoolVar(&replaySkipFlushed, "replay-skip-flushed", false, "On startup, skip WAL samples at or before the newest block's end (out-of-order samples behind it are lost)")
+	startCmd.Flags().StringVar(&relabelConfig, "relabel-config", "", "JSON file of metric_relabel_configs applied to written series")
+	startCmd.Flags().BoolVar(&enableAuditLog, "enable-audit-log", true, "Record admin operations in an append-only audit log")
+	startCmd.Flags().StringVar(&auditLogPath, "audit-log", "", "Audit log path (default: audit.log in the data directory)")
+	startCmd.Flags().StringVar(&queryLogPath, "query-log", "", "Record every query in this file (disabled if empty)")
+	startCmd.Flags().Int64Var(&queryLogMaxBytes, "query-log-max-bytes", api.DefaultQueryLogMaxBytes, "Size in bytes past which the query log is rotated (0 never rotates)")
+	startCmd.Flags().IntVar(&queryLogMaxFiles, "query-log-max-files", api.DefaultQueryLogMaxFiles, "Rotated query logs kept")
+	startCmd.Flags().BoolVar(&retryBlockReads, "retry-block-reads", false, "Retry a failed block read once before a query skips the block's data")
+	startCmd.Flags().StringVar(&tenant, "tenant", "", "Tenant label recorded in the meta of every block written")
+	startCmd.Flags().StringArrayVar(&blockRetention, "block-retention", nil, "Retention for blocks matching label matchers, e.g. '{source=\"backfill\"}=7d' (repeatable; first match wins, 0 keeps forever)")
+	startCmd.Flags().BoolVar(&asyncWrites, "async-writes", false, "Queue validated writes for background workers and answer 202 without waiting for the WAL")
+	startCmd.Flags().IntVar(&writeQueueSize, "write-queue-size", 1000, "Write requests the async write queue holds")
+	startCmd.Flags().IntVar(&writeQueueWorkers, "write-queue-workers", 1, "Goroutines writing queued requests")
+	startCmd.Flags().StringVar(&writeQueueOverflow, "write-queue-overflow", "block", "Writes arriving at a full queue: block or reject (503)")
+	startCmd.Flags().StringArrayVar(&retentionBudgets, "retention-budget", nil, "Series and sample budget for blocks matching label matchers, e.g. '{tenant=\"a\"}=series:100000,samples:1000000000' (repeatable)")
+	startCmd.Flags().StringVar(&compactionLevels, "compaction-levels", "12h:3,7d:3", "Duration and fan-in of each compaction level above level 0, e.g. '12h:3,7d:3' (fan-in is the fewest blocks merged into the level)")
+	startCmd.Flags().StringArrayVar(&reducePrecision, "reduce-precision", nil, "Round values of series matching label matchers to N significant digits once compacted data is older than an age, e.g. '{__name__=~\"node_.*\"}=30d:3' (repeatable; first match wins)")
 }
 
 func runStart(cmd *cobra.Command, args []string) error {
-	log.Printf("Starting TSDB server...")
-	log.Printf("  Listen address: %s", listenAddr)
-	log.Printf("  Data directory: %s", dataDir)
-	log.Printf("  Retention: %s", retention)
-	log.Printf("  Compaction: %v", enableCompaction)
-
-	// Parse durations
-	retentionDuration, err := parseDuration(retention)
+	fromEnv, err := applyEnv(cmd.Flags())
 	if err != nil {
-		return fmt.Errorf("invalid retention: %w", err)
+		return err
 	}
 
-	flushIntervalDuration, err := time.ParseDuration(flushInterval)
+	opts, err := startOptions()
 	if err != nil {
-		return fmt.Errorf("invalid flush interval: %w", err)
+		return err
+	}
+	if printConfigOnly {
+		return printConfig(cmd.OutOrStdout(), cmd.Flags(), fromEnv)
 	}
 
-	compactionIntervalDuration, err := time.ParseDuration(compactionInterval)
-	if err != nil {
-		return fmt.Errorf("invalid compaction interval: %w", err)
+	level := observability.LogLevel(logLevel)
+	observability.SetDefaultLogger(observability.NewLogger(level, logFormat == "json"))
+
+	log.Printf("Starting TSDB server...")
+	log.Printf("  Listen address: %s", listenAddr)
+	log.Printf("  Data directory: %s", dataDir)
+	if coldDir != "" {
+		log.Printf("  Cold directory: %s (after %s)", coldDir, coldAfter)
 	}
+	log.Printf("  Retention: %s", retention)
+	log.Printf("  Compaction: %v", enableCompaction)
 
-	// Create TSDB options
-	opts := storage.DefaultOptions(dataDir)
-	opts.RetentionPeriod = retentionDuration
-	opts.EnableCompaction = enableCompaction
-	opts.EnableRetention = enableRetention
-	opts.FlushInterval = flushIntervalDuration
-	opts.CompactionInterval = compactionIntervalDuration
+	// Answer health and status checks while the WAL is replayed
+	startup := api.NewStartupServer(listenAddr)
+	opts.ReplayProgress = startup.SetReplayProgress
+	go func() {
+		if err := startup.Start(); err != nil {
+			log.Printf("Startup server error: %v", err)
+		}
+	}()
 
 	// Open TSDB
 	log.Printf("Opening TSDB at %s...", dataDir)
 	db, err := storage.Open(opts)
+	startupCtx, cancelStartup := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := startup.Shutdown(startupCtx); err != nil {
+		log.Printf("Error stopping startup server: %v", err)
+	}
+	cancelStartup()
 	if err != nil {
 		return fmt.Errorf("failed to open TSDB: %w", err)
 	}
@@ -88,8 +254,83 @@ func runStart(cmd *cobra.Command, args []string) error {
 
 	log.Printf("TSDB opened successfully")
 
+	// Stream accepted samples from the WAL to Kafka
+	var streamer *cdc.Streamer
+	if len(cdcKafkaBrokers) > 0 {
+		sink, err := cdc.NewKafkaSink(cdc.DefaultKafkaOptions(cdcKafkaBrokers, cdcKafkaTopic))
+		if err != nil {
+			return fmt.Errorf("failed to create Kafka sink: %w", err)
+		}
+		streamer, err = cdc.NewStreamer(sink, cdc.DefaultOptions(dataDir, "kafka"))
+		if err != nil {
+			return fmt.Errorf("failed to start CDC: %w", err)
+		}
+		log.Printf("Streaming samples to Kafka topic %s", cdcKafkaTopic)
+		go streamer.Run()
+	}
+
 	// Create API server
-	server := api.NewServer(db, listenAddr)
+	server := api.NewServer(db, listenAddr,
+		api.WithTimeouts(httpReadTimeout, httpWriteTimeout, httpIdleTimeout),
+		api.WithMaxConnections(httpMaxConns))
+	server.SetQueryLimits(query.Limits{
+		MaxSeries:  queryMaxSeries,
+		MaxSamples: queryMaxSamples,
+		Truncate:   queryLimitMode == "truncate",
+	})
+	server.SetQueryConcurrency(queryConcurrency)
+	server.SetQuerySharding(queryShards, queryShardMinRange)
+	server.SetQueryLookback(queryLookback)
+	server.SetBackupIdleTimeout(backupIdleTimeout)
+	server.SetBuildInfo(api.BuildInfo{Version: version, Commit: commit, BuildDate: date})
+	if asyncWrites {
+		err := server.EnableAsyncWrites(api.WriteQueueOptions{
+			Size:     writeQueueSize,
+			Workers:  writeQueueWorkers,
+			Overflow: api.OverflowPolicy(writeQueueOverflow),
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("Async writes enabled: queue of %d requests, %d workers", writeQueueSize, writeQueueWorkers)
+	}
+
+	// Record admin operations
+	if enableAuditLog {
+		path := auditLogPath
+		if path == "" {
+			path = filepath.Join(dataDir, api.AuditLogFile)
+		}
+		audit, err := api.OpenAuditLog(path)
+		if err != nil {
+			return err
+		}
+		defer audit.Close()
+		server.SetAuditLog(audit)
+	}
+
+	// Record queries
+	if queryLogPath != "" {
+		queryLog, err := api.OpenQueryLog(queryLogPath, queryLogMaxBytes, queryLogMaxFiles)
+		if err != nil {
+			return err
+		}
+		defer queryLog.Close()
+		server.SetQueryLog(queryLog)
+		log.Printf("Query log enabled: %s", queryLogPath)
+	}
+
+	// Start rollup rules
+	var rollups *rollup.Manager
+	if enableRollups {
+		rollups = rollup.NewManager(db, rollup.DefaultOptions(dataDir))
+		if err := rollups.Load(); err != nil {
+			return fmt.Errorf("failed to load rollup rules: %w", err)
+		}
+		go rollups.Run()
+		defer rollups.Stop()
+		server.SetRollupManager(rollups)
+	}
 
 	// Start server in a goroutine
 	serverErr := make(chan error, 1)
@@ -111,24 +352,403 @@ func runStart(cmd *cobra.Command, args []string) error {
 		log.Printf("Received signal %s, shutting down...", sig)
 	}
 
-	// Graceful shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// A second signal abandons the graceful shutdown. Anything not yet
+	// flushed is still in the WAL and replayed on the next start.
+	go func() {
+		sig := <-sigChan
+		log.Printf("Received signal %s during shutdown, exiting immediately", sig)
+		os.Exit(1)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
-	log.Printf("Shutting down HTTP server...")
-	if err := server.Shutdown(ctx); err != nil {
-		log.Printf("HTTP server shutdown error: %v", err)
+	if err := shutdown(ctx, server, rollups, streamer, db); err != nil {
+		log.Printf("Shutdown incomplete: %v", err)
+		os.Exit(1)
 	}
 
-	log.Printf("Closing TSDB...")
-	if err := db.Close(); err != nil {
-		log.Printf("TSDB close error: %v", err)
+	log.Printf("Shutdown complete")
+	return nil
+}
+
+// shutdownPhase is one step of a graceful shutdown
+type shutdownPhase struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// shutdown stops the server in order: no new writes are accepted and
+// queued async writes are written, the head is flushed while compaction and retention still run, background
+// maintenance is stopped, the WAL is synced and the TSDB closed. A failing
+// phase is logged and the next one runs; if ctx expires the remaining
+// phases are abandoned.
+func shutdown(ctx context.Context, server *api.Server, rollups *rollup.Manager, streamer *cdc.Streamer, db *storage.TSDB) error {
+	phases := []shutdownPhase{{"stopping HTTP server", server.Shutdown}}
+	if rollups != nil {
+		phases = append(phases, shutdownPhase{"stopping rollup rules", func(context.Context) error { return rollups.Stop() }})
+	}
+	phases = append(phases,
+		shutdownPhase{"flushing head", func(ctx context.Context) error {
+			err := db.TriggerFlush(ctx)
+			if errors.Is(err, storage.ErrReadOnly) {
+				log.Printf("  read-only, leaving unflushed samples in the WAL")
+				return nil
+			}
+			return err
+		}},
+		shutdownPhase{"stopping compaction and retention", func(context.Context) error {
+			db.StopMaintenance()
+			return nil
+		}},
+		shutdownPhase{"syncing WAL", func(context.Context) error { return db.SyncWAL() }},
+	)
+	if streamer != nil {
+		phases = append(phases, shutdownPhase{"stopping CDC streamer", func(context.Context) error { return streamer.Stop() }})
 	}
+	phases = append(phases, shutdownPhase{"closing TSDB", func(context.Context) error { return db.Close() }})
 
-	log.Printf("Shutdown complete")
+	for _, phase := range phases {
+		log.Printf("Shutdown: %s...", phase.name)
+		start := time.Now()
+
+		done := make(chan error, 1)
+		go func() { done <- phase.run(ctx) }()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Printf("Shutdown: %s failed after %s: %v", phase.name, time.Since(start).Round(time.Millisecond), err)
+				continue
+			}
+			log.Printf("Shutdown: %s done in %s", phase.name, time.Since(start).Round(time.Millisecond))
+		case <-ctx.Done():
+			return fmt.Errorf("timed out %s; unflushed samples will be replayed from the WAL", phase.name)
+		}
+	}
 	return nil
 }
 
+// startOptions builds and validates the TSDB options from the start flags
+func startOptions() (*storage.Options, error) {
+	retentionDuration, err := parseDuration(retention)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retention: %w", err)
+	}
+
+	trashGracePeriodDuration, err := parseDuration(trashGracePeriod)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trash grace period: %w", err)
+	}
+
+	coldAfterDuration, err := parseDuration(coldAfter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cold storage age: %w", err)
+	}
+
+	blockScanIntervalDuration, err := time.ParseDuration(blockScanInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid block scan interval: %w", err)
+	}
+
+	flushIntervalDuration, err := time.ParseDuration(flushInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid flush interval: %w", err)
+	}
+
+	compactionIntervalDuration, err := time.ParseDuration(compactionInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compaction interval: %w", err)
+	}
+
+	duplicates, err := storage.ParseDuplicatePolicy(duplicatePolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	outOfOrderWindowDuration, err := time.ParseDuration(outOfOrderWindow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid out-of-order window: %w", err)
+	}
+
+	rateLimitWindowDuration, err := time.ParseDuration(rateLimitWindow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid series rate limit window: %w", err)
+	}
+
+	rateLimitActionName, err := storage.ParseRateLimitAction(rateLimitAction)
+	if err != nil {
+		return nil, err
+	}
+
+	labelLimitActionName, err := storage.ParseLabelLimitAction(labelLimitAction)
+	if err != nil {
+		return nil, err
+	}
+
+	maxMemTableSpanDuration, err := time.ParseDuration(maxMemTableSpan)
+	if err != nil {
+		return nil, fmt.Errorf("invalid max memtable span: %w", err)
+	}
+
+	diskCheckIntervalDuration, err := time.ParseDuration(diskCheckInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid disk check interval: %w", err)
+	}
+
+	if queryLimitMode != "error" && queryLimitMode != "truncate" {
+		return nil, fmt.Errorf("invalid query limit mode %q: must be error or truncate", queryLimitMode)
+	}
+	if queryMaxSeries < 0 || queryMaxSamples < 0 {
+		return nil, fmt.Errorf("query limits must not be negative")
+	}
+	if queryConcurrency < 0 {
+		return nil, fmt.Errorf("query concurrency must not be negative")
+	}
+	if queryShards < 1 || queryShardMinRange <= 0 {
+		return nil, fmt.Errorf("query shards and shard minimum range must be positive")
+	}
+	if queryLookback <= 0 {
+		return nil, fmt.Errorf("query lookback must be positive")
+	}
+	if backupIdleTimeout <= 0 {
+		return nil, fmt.Errorf("backup idle timeout must be positive")
+	}
+	if httpReadTimeout < 0 || httpWriteTimeout < 0 || httpIdleTimeout < 0 || httpMaxConns < 0 {
+		return nil, fmt.Errorf("HTTP timeouts and max connections must not be negative")
+	}
+	if _, err := api.ParseOverflowPolicy(writeQueueOverflow); err != nil {
+		return nil, err
+	}
+	if writeQueueSize <= 0 || writeQueueWorkers <= 0 {
+		return nil, fmt.Errorf("write queue size and workers must be positive")
+	}
+
+	switch observability.LogLevel(logLevel) {
+	case observability.LogLevelDebug, observability.LogLevelInfo, observability.LogLevelWarn, observability.LogLevelError:
+	default:
+		return nil, fmt.Errorf("invalid log level %q: must be debug, info, warn or error", logLevel)
+	}
+	if logFormat != "text" && logFormat != "json" {
+		return nil, fmt.Errorf("invalid log format %q: must be text or json", logFormat)
+	}
+
+	opts := storage.DefaultOptions(dataDir)
+	opts.RetentionPeriod = retentionDuration
+	opts.EnableCompaction = enableCompaction
+	opts.EnableRetention = enableRetention
+	opts.TrashGracePeriod = trashGracePeriodDuration
+	opts.ColdDir = coldDir
+	opts.ColdAfter = coldAfterDuration
+	opts.BlockScanInterval = blockScanIntervalDuration
+	opts.FlushInterval = flushIntervalDuration
+	opts.CompactionInterval = compactionIntervalDuration
+	opts.MaxMemTableSpan = maxMemTableSpanDuration
+	opts.MaxWALSize = maxWALSize
+	opts.MinFreeDiskBytes = minFreeDisk
+	opts.DiskCheckInterval = diskCheckIntervalDuration
+	opts.EmergencyRetention = emergencyRetention
+	opts.MemTableSize = memTableSize
+	opts.MemTableShards = memTableShards
+	opts.SpillOnFull = spillOnFull
+	opts.IngestStatsCapacity = ingestStatsCap
+	opts.WALOptions = &wal.Options{SegmentSize: walSegmentSize}
+	opts.CompactionMaxBlockBytes = maxBlockBytes
+	opts.CompactionMaxBlockSeries = maxBlockSeries
+	opts.BackgroundIOBytesPerSecond = backgroundIORate
+	opts.BackgroundIOPauseLatency = backgroundIOPause
+	opts.SlowFlushThreshold = slowFlush
+	opts.SlowWALSyncThreshold = slowWALSync
+	opts.SlowCompactionThreshold = slowCompaction
+	opts.ReplaySkipFlushed = replaySkipFlushed
+	opts.RetryBlockReads = retryBlockReads
+	opts.RejectBeforeRetention = rejectBeforeRetain
+	opts.MaxFutureSkew = maxFutureSkew
+	opts.Validation = &storage.ValidationOptions{
+		MaxLabelNamesPerSeries: maxLabelNames,
+		MaxLabelNameLength:     maxLabelNameLength,
+		MaxLabelValueLength:    maxLabelValueLen,
+		MaxLabelsSize:          maxLabelsSize,
+	}
+	opts.SamplePolicy = storage.SamplePolicy{
+		Duplicates:       duplicates,
+		OutOfOrderWindow: outOfOrderWindowDuration,
+	}
+	opts.SeriesRateLimit = storage.SeriesRateLimit{
+		MaxSamples: seriesRateLimit,
+		Window:     rateLimitWindowDuration,
+		Action:     rateLimitActionName,
+	}
+	opts.LabelPostingsLimit = storage.LabelPostingsLimit{
+		MaxBytes: labelPostingsLimit,
+		Action:   labelLimitActionName,
+	}
+	opts.Tenant = tenant
+	opts.BlockRetention, err = parseBlockRetention(blockRetention)
+	if err != nil {
+		return nil, err
+	}
+	opts.RetentionBudgets, err = parseRetentionBudgets(retentionBudgets)
+	if err != nil {
+		return nil, err
+	}
+	opts.PrecisionRules, err = parsePrecisionRules(reducePrecision)
+	if err != nil {
+		return nil, err
+	}
+	opts.CompactionLevels, err = parseCompactionLevels(compactionLevels)
+	if err != nil {
+		return nil, err
+	}
+	if relabelConfig != "" {
+		opts.Relabel, err = loadRelabelConfig(relabelConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// parseBlockRetention parses --block-retention rules of the form
+// '{matchers}=duration'
+func parseBlockRetention(rules []string) ([]storage.BlockRetentionRule, error) {
+	var parsed []storage.BlockRetentionRule
+	for _, rule := range rules {
+		end := strings.LastIndex(rule, "}")
+		if end < 0 || !strings.HasPrefix(rule[end+1:], "=") {
+			return nil, fmt.Errorf("invalid block retention %q: must be {matchers}=duration", rule)
+		}
+		matchers, err := index.ParseMatchers(rule[:end+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid block retention %q: %w", rule, err)
+		}
+		maxAge, err := parseDuration(rule[end+2:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid block retention %q: %w", rule, err)
+		}
+		parsed = append(parsed, storage.BlockRetentionRule{Matchers: matchers, MaxAge: maxAge})
+	}
+	return parsed, nil
+}
+
+// parsePrecisionRules parses --reduce-precision rules of the form
+// '{matchers}=age:digits'
+func parsePrecisionRules(rules []string) ([]storage.PrecisionRule, error) {
+	var parsed []storage.PrecisionRule
+	for _, rule := range rules {
+		end := strings.LastIndex(rule, "}")
+		age, digits, ok := strings.Cut(rule[end+1:], ":")
+		if end < 0 || !strings.HasPrefix(age, "=") || !ok {
+			return nil, fmt.Errorf("invalid precision rule %q: must be {matchers}=age:digits", rule)
+		}
+		matchers, err := index.ParseMatchers(rule[:end+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid precision rule %q: %w", rule, err)
+		}
+		minAge, err := parseDuration(age[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid precision rule %q: %w", rule, err)
+		}
+		n, err := strconv.Atoi(digits)
+		if err != nil {
+			return nil, fmt.Errorf("invalid precision rule %q: digits must be a number", rule)
+		}
+		parsed = append(parsed, storage.PrecisionRule{Matchers: matchers, MinAge: minAge, Digits: n})
+	}
+	return parsed, nil
+}
+
+// parseCompactionLevels parses --compaction-levels, a comma-separated
+// list of 'duration:fan-in' levels
+func parseCompactionLevels(value string) ([]storage.CompactionLevelConfig, error) {
+	var levels []storage.CompactionLevelConfig
+	for _, level := range strings.Split(value, ",") {
+		duration, fanIn, ok := strings.Cut(strings.TrimSpace(level), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid compaction level %q: must be duration:fan-in", level)
+		}
+		d, err := parseDuration(duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid compaction level %q: %w", level, err)
+		}
+		n, err := strconv.Atoi(fanIn)
+		if err != nil {
+			return nil, fmt.Errorf("invalid compaction level %q: fan-in must be a number", level)
+		}
+		levels = append(levels, storage.CompactionLevelConfig{Duration: d, MinBlocks: n})
+	}
+	return levels, nil
+}
+
+// parseRetentionBudgets parses --retention-budget values of the form
+// '{block matchers}{series matchers}=series:N,samples:N', where the series
+// matchers and either limit may be left out
+func parseRetentionBudgets(values []string) ([]storage.RetentionBudget, error) {
+	var budgets []storage.RetentionBudget
+	for _, value := range values {
+		end := strings.LastIndex(value, "}")
+		if end < 0 || !strings.HasPrefix(value[end+1:], "=") {
+			return nil, fmt.Errorf("invalid retention budget %q: must be {matchers}=series:N,samples:N", value)
+		}
+
+		var budget storage.RetentionBudget
+		selectors := value[:end+1]
+		blockSelector, seriesSelector := selectors, ""
+		if i := strings.Index(selectors, "}{"); i >= 0 {
+			blockSelector, seriesSelector = selectors[:i+1], selectors[i+1:]
+		}
+		var err error
+		if budget.BlockMatchers, err = index.ParseMatchers(blockSelector); err != nil {
+			return nil, fmt.Errorf("invalid retention budget %q: %w", value, err)
+		}
+		if seriesSelector != "" {
+			if budget.SeriesMatchers, err = index.ParseMatchers(seriesSelector); err != nil {
+				return nil, fmt.Errorf("invalid retention budget %q: %w", value, err)
+			}
+		}
+
+		for _, limit := range strings.Split(value[end+2:], ",") {
+			name, n, ok := strings.Cut(limit, ":")
+			limitValue, err := strconv.ParseInt(n, 10, 64)
+			if !ok || err != nil {
+				return nil, fmt.Errorf("invalid retention budget %q: bad limit %q", value, limit)
+			}
+			switch name {
+			case "series":
+				budget.MaxSeries = limitValue
+			case "samples":
+				budget.MaxSamples = limitValue
+			default:
+				return nil, fmt.Errorf("invalid retention budget %q: unknown limit %q, must be series or samples", value, name)
+			}
+		}
+		budgets = append(budgets, budget)
+	}
+	return budgets, nil
+}
+
+// loadRelabelConfig reads the relabel rules from a JSON file of the form
+// {"metric_relabel_configs": [{"source_labels": [...], "action": ...}]}
+func loadRelabelConfig(path string) ([]storage.RelabelConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read relabel config: %w", err)
+	}
+
+	var config struct {
+		MetricRelabelConfigs []storage.RelabelConfig `json:"metric_relabel_configs"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("invalid relabel config %s: %w", path, err)
+	}
+	return config.MetricRelabelConfigs, nil
+}
+
 // parseDuration parses a duration string with support for days
 func parseDuration(s string) (time.Duration, error) {
 	// Check for days suffix
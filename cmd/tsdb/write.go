@@ -2,42 +2,71 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/therealutkarshpriyadarshi/time/pkg/client"
+	"github.com/therealutkarshpriyadarshi/time/pkg/ingest"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
 )
 
 var (
-	writeAddr string
-	writeTime string
+	writeAddr      string
+	writeTime      string
+	writeFiles     []string
+	writeFormat    string
+	writeBatchSize int
 )
 
 var writeCmd = &cobra.Command{
-	Use:   "write [query] [value]",
-	Short: "Write a metric to the TSDB",
-	Long: `Write a time-series metric to the TSDB.
+	Use:   "write [query value]",
+	Short: "Write metrics to the TSDB",
+	Long: `Write time-series metrics to the TSDB.
 
-The query should be in the format: metric_name{label1="value1",label2="value2"}
+Given a query and a value, a single sample is written. The query should be
+in the format: metric_name{label1="value1",label2="value2"}
 The value should be a floating-point number.
 
+Without arguments, samples are streamed from the files given with --file,
+or from stdin, in the Prometheus exposition format or the Influx line
+protocol, and written in batches. Samples without a timestamp get --time.
+A summary is printed to stderr once the input is exhausted.
+
 Examples:
   tsdb write 'cpu_usage{host="server1"}' 0.85
   tsdb write 'memory_usage{host="server1",region="us-west"}' 1024.5
-  tsdb write --addr=http://localhost:8080 'disk_usage{host="server2"}' 2048.0`,
-	Args: cobra.ExactArgs(2),
+  tsdb write --addr=http://localhost:8080 'disk_usage{host="server2"}' 2048.0
+  curl -s http://localhost:9100/metrics | tsdb write
+  tsdb write --format=influx --file=cpu.txt --file=mem.txt`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 0 && len(args) != 2 {
+			return fmt.Errorf("expected a query and a value, or no arguments to read from stdin or --file")
+		}
+		return nil
+	},
 	RunE: runWrite,
 }
 
 func init() {
 	writeCmd.Flags().StringVar(&writeAddr, "addr", "http://localhost:8080", "TSDB server address")
 	writeCmd.Flags().StringVar(&writeTime, "time", "", "Timestamp (default: now)")
+	writeCmd.Flags().StringArrayVarP(&writeFiles, "file", "f", nil, "File to read samples from, - for stdin (repeatable; default: stdin)")
+	writeCmd.Flags().StringVar(&writeFormat, "format", "prometheus", "Input format: prometheus or influx")
+	writeCmd.Flags().IntVar(&writeBatchSize, "batch-size", 1000, "Samples per write request")
 }
 
 func runWrite(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return runWriteStream(cmd)
+	}
+
 	query := args[0]
 	valueStr := args[1]
 
@@ -84,6 +113,114 @@ func runWrite(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// writeProgress counts what a streamed write has read and written
+type writeProgress struct {
+	lines   int
+	samples int
+	skipped int // Non-finite values, which the write API cannot carry
+	series  map[uint64]struct{}
+	start   time.Time
+}
+
+// summary describes the progress so far
+func (p *writeProgress) summary() string {
+	elapsed := time.Since(p.start)
+	rate := float64(p.samples) / max(elapsed.Seconds(), 0.001)
+	msg := fmt.Sprintf("%d samples of %d series from %d lines in %s (%.0f samples/s)",
+		p.samples, len(p.series), p.lines, elapsed.Round(time.Millisecond), rate)
+	if p.skipped > 0 {
+		msg += fmt.Sprintf(", %d NaN or infinite samples skipped", p.skipped)
+	}
+	return msg
+}
+
+// runWriteStream writes the samples read from --file or stdin in batches
+func runWriteStream(cmd *cobra.Command) error {
+	format, err := ingest.ParseFormat(writeFormat)
+	if err != nil {
+		return err
+	}
+	if writeBatchSize <= 0 {
+		return fmt.Errorf("batch size must be positive")
+	}
+
+	timestamp := time.Now()
+	if writeTime != "" {
+		if timestamp, err = parseTimestamp(writeTime); err != nil {
+			return fmt.Errorf("invalid timestamp: %w", err)
+		}
+	}
+
+	files := writeFiles
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	ctx := context.Background()
+	batcher := client.NewClient(writeAddr).NewBatcher(client.BatchOptions{MaxBatchSize: writeBatchSize})
+	progress := &writeProgress{series: make(map[uint64]struct{}), start: time.Now()}
+
+	for _, file := range files {
+		if err := writeFile(ctx, cmd, batcher, file, format, timestamp.UnixMilli(), progress); err != nil {
+			batcher.Close(ctx)
+			fmt.Fprintf(cmd.ErrOrStderr(), "Read %s before failing\n", progress.summary())
+			return err
+		}
+	}
+
+	if err := batcher.Close(ctx); err != nil {
+		return fmt.Errorf("write failed: %w", err)
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "Wrote %s\n", progress.summary())
+	return nil
+}
+
+// writeFile streams the samples of one input file into the batcher
+func writeFile(ctx context.Context, cmd *cobra.Command, batcher *client.Batcher, file string, format ingest.Format, defaultTimestamp int64, progress *writeProgress) error {
+	var r io.Reader = cmd.InOrStdin()
+	name := "stdin"
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r, name = f, file
+	}
+
+	parser, err := ingest.NewParser(r, format, defaultTimestamp)
+	if err != nil {
+		return err
+	}
+	defer func() { progress.lines += parser.Line() }()
+
+	for {
+		sample, err := parser.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+			progress.skipped++
+			continue
+		}
+
+		err = batcher.Add(ctx, client.Metric{
+			Labels:    sample.Labels,
+			Timestamp: time.UnixMilli(sample.Timestamp),
+			Value:     sample.Value,
+		})
+		if err != nil {
+			return fmt.Errorf("write failed: %w", err)
+		}
+		progress.samples++
+		progress.series[series.NewSeries(sample.Labels).Hash] = struct{}{}
+	}
+}
+
 // parseQuery parses a query string into labels
 // Format: metric_name{label1="value1",label2="value2"}
 func parseQuery(query string) (map[string]string, error) {
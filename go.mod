@@ -5,12 +5,12 @@ go 1.24.7
 require (
 	github.com/RoaringBitmap/roaring v1.9.4
 	github.com/oklog/ulid/v2 v2.1.1
+	github.com/spf13/cobra v1.10.1
+	github.com/spf13/pflag v1.0.9
 )
 
 require (
 	github.com/bits-and-blooms/bitset v1.12.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/mschoch/smat v0.2.0 // indirect
-	github.com/spf13/cobra v1.10.1 // indirect
-	github.com/spf13/pflag v1.0.9 // indirect
 )
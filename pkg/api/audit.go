@@ -0,0 +1,197 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// AuditLogFile is the name of the audit log in the data directory
+	AuditLogFile = "audit.log"
+
+	// auditRecentEntries is how many entries an AuditLog keeps in memory
+	// for /api/v1/admin/audit; the file keeps them all
+	auditRecentEntries = 1000
+
+	// defaultAuditLimit is how many entries /api/v1/admin/audit returns
+	// when no limit is given
+	defaultAuditLimit = 100
+)
+
+// AuditEntry records an admin operation: who called it, when, on what, and
+// whether it succeeded.
+type AuditEntry struct {
+	Time         int64             `json:"time"`                   // Unix milliseconds
+	Action       string            `json:"action"`                 // e.g. compact, pause_retention, add_hold
+	Client       string            `json:"client"`                 // Remote address of the caller
+	ForwardedFor string            `json:"forwardedFor,omitempty"` // X-Forwarded-For set by a proxy
+	User         string            `json:"user,omitempty"`         // X-Forwarded-User set by an authenticating proxy
+	Params       map[string]string `json:"params,omitempty"`       // Selectors and arguments of the operation
+	Error        string            `json:"error,omitempty"`        // Empty if the operation succeeded
+}
+
+// AuditLog is an append-only file of admin operations, one JSON entry per
+// line. Each entry is synced before Record returns.
+type AuditLog struct {
+	mu     sync.Mutex
+	file   *os.File
+	recent []AuditEntry // The latest entries, oldest first
+}
+
+// OpenAuditLog opens the audit log at path, creating it if needed, and
+// loads its latest entries. A torn last line, left by a crash mid-write, is
+// skipped.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	a := &AuditLog{file: file}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		a.remember(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	// End a torn line so the next entry starts on a line of its own
+	info, err := file.Stat()
+	if err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err = file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			_, err = file.Write([]byte{'\n'})
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to repair audit log: %w", err)
+	}
+	return a, nil
+}
+
+// Record appends an entry to the log.
+func (a *AuditLog) Record(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	if err := a.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+	a.remember(entry)
+	return nil
+}
+
+// remember keeps entry among the recent ones
+func (a *AuditLog) remember(entry AuditEntry) {
+	if len(a.recent) == auditRecentEntries {
+		copy(a.recent, a.recent[1:])
+		a.recent = a.recent[:len(a.recent)-1]
+	}
+	a.recent = append(a.recent, entry)
+}
+
+// Recent returns up to n of the latest entries, newest first.
+func (a *AuditLog) Recent(n int) []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n = min(n, len(a.recent))
+	entries := make([]AuditEntry, n)
+	for i := range entries {
+		entries[i] = a.recent[len(a.recent)-1-i]
+	}
+	return entries
+}
+
+// Close closes the log file.
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+// SetAuditLog records admin operations in a; nil disables auditing.
+func (s *Server) SetAuditLog(a *AuditLog) {
+	s.audit = a
+}
+
+// recordAudit records an admin operation made by r with its outcome. A
+// failure to record is logged; the operation has already happened.
+func (s *Server) recordAudit(r *http.Request, action string, params map[string]string, opErr error) {
+	if s.audit == nil {
+		return
+	}
+
+	entry := AuditEntry{
+		Time:         time.Now().UnixMilli(),
+		Action:       action,
+		Client:       r.RemoteAddr,
+		ForwardedFor: r.Header.Get("X-Forwarded-For"),
+		User:         r.Header.Get("X-Forwarded-User"),
+	}
+	for name, value := range params {
+		if value == "" {
+			continue
+		}
+		if entry.Params == nil {
+			entry.Params = make(map[string]string, len(params))
+		}
+		entry.Params[name] = value
+	}
+	if opErr != nil {
+		entry.Error = opErr.Error()
+	}
+	if err := s.audit.Record(entry); err != nil {
+		log.Printf("Failed to record %s in the audit log: %v", action, err)
+	}
+}
+
+// handleAudit returns the latest audited admin operations, newest first;
+// limit caps how many (default 100).
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.audit == nil {
+		s.writeError(w, ErrorUnavailable, "audit log is not enabled")
+		return
+	}
+
+	limit := defaultAuditLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			s.writeError(w, ErrorBadData, fmt.Sprintf("invalid limit %q: must be a positive integer", limitStr))
+			return
+		}
+		limit = n
+	}
+	s.writeJSONResponse(w, AuditResponse{Status: "success", Data: s.audit.Recent(limit)}, http.StatusOK)
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
+)
+
+// DefaultBackupIdleTimeout is how long a backup is kept without being
+// downloaded before it is released
+const DefaultBackupIdleTimeout = time.Hour
+
+// backupsPath is the prefix of the backup endpoints
+const backupsPath = "/api/v1/admin/backups"
+
+// backupSession is a backup kept open between requests, so a download can
+// be resumed with a Range request against the same snapshot
+type backupSession struct {
+	backup    *storage.Backup
+	downloads int // Requests streaming the backup; it doesn't expire meanwhile
+	expiresAt time.Time
+	timer     *time.Timer
+}
+
+// SetBackupIdleTimeout sets how long an idle backup is kept before its
+// blocks and WAL segments are released.
+func (s *Server) SetBackupIdleTimeout(d time.Duration) {
+	s.backupsMu.Lock()
+	defer s.backupsMu.Unlock()
+	s.backupIdle = d
+}
+
+// handleBackups creates (POST) and lists (GET) backups.
+//
+// A backup is a consistent snapshot of the database, downloaded as a tar
+// archive from /api/v1/admin/backups/<id>. It holds its blocks until it is
+// deleted or has been idle for the backup idle timeout.
+func (s *Server) handleBackups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		backup, err := s.db.Backup()
+		if err != nil {
+			s.recordAudit(r, "create_backup", nil, err)
+			s.writeError(w, classifyError(err), fmt.Sprintf("Failed to create backup: %v", err))
+			return
+		}
+		s.recordAudit(r, "create_backup", map[string]string{"backup": backup.ID}, nil)
+
+		s.backupsMu.Lock()
+		session := &backupSession{backup: backup}
+		s.backups[backup.ID] = session
+		s.scheduleBackupExpiry(backup.ID, session)
+		info := newBackupInfo(session)
+		s.backupsMu.Unlock()
+
+		log.Printf("Created backup %s: %d blocks, %d bytes", backup.ID, backup.Blocks, backup.Size())
+		s.writeJSONResponse(w, BackupResponse{Status: "success", Data: &info}, http.StatusOK)
+
+	case http.MethodGet:
+		s.backupsMu.Lock()
+		data := make([]BackupInfo, 0, len(s.backups))
+		for _, session := range s.backups {
+			data = append(data, newBackupInfo(session))
+		}
+		s.backupsMu.Unlock()
+
+		sort.Slice(data, func(i, j int) bool { return data[i].ID < data[j].ID })
+		s.writeJSONResponse(w, BackupsResponse{Status: "success", Data: data}, http.StatusOK)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleBackup downloads (GET) or deletes (DELETE) the backup named in the
+// path. Downloads honor Range headers, so an interrupted download can be
+// resumed; the backup's ID is its ETag.
+func (s *Server) handleBackup(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, backupsPath+"/")
+	if id == "" || strings.Contains(id, "/") {
+		s.writeError(w, ErrorBadData, "backup ID is required")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		session := s.acquireBackup(id)
+		if session == nil {
+			s.writeError(w, ErrorBadData, fmt.Sprintf("backup %s not found", id))
+			return
+		}
+		defer s.releaseBackup(id, session)
+
+		// Archives can take far longer than the server's write timeout
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+		backup := session.backup
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="tsdb-backup-%s.tar"`, backup.ID))
+		w.Header().Set("ETag", `"`+backup.ID+`"`)
+		http.ServeContent(w, r, "", backup.CreatedAt, io.NewSectionReader(backup, 0, backup.Size()))
+
+	case http.MethodDelete:
+		var err error
+		if !s.removeBackup(id) {
+			err = fmt.Errorf("backup %s not found", id)
+		}
+		s.recordAudit(r, "delete_backup", map[string]string{"backup": id}, err)
+		if err != nil {
+			s.writeError(w, ErrorBadData, err.Error())
+			return
+		}
+		s.writeJSONResponse(w, AdminResponse{Status: "success"}, http.StatusOK)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// acquireBackup returns the session of backup id, keeping it from expiring
+// until releaseBackup is called. Returns nil if there is no such backup.
+func (s *Server) acquireBackup(id string) *backupSession {
+	s.backupsMu.Lock()
+	defer s.backupsMu.Unlock()
+
+	session := s.backups[id]
+	if session == nil {
+		return nil
+	}
+	session.downloads++
+	session.timer.Stop()
+	return session
+}
+
+// releaseBackup ends a download of backup id, restarting its idle timeout
+// once no download is left
+func (s *Server) releaseBackup(id string, session *backupSession) {
+	s.backupsMu.Lock()
+	defer s.backupsMu.Unlock()
+
+	session.downloads--
+	if session.downloads == 0 && s.backups[id] == session {
+		s.scheduleBackupExpiry(id, session)
+	}
+}
+
+// scheduleBackupExpiry releases backup id once it has been idle for the
+// backup idle timeout. s.backupsMu must be held.
+func (s *Server) scheduleBackupExpiry(id string, session *backupSession) {
+	session.expiresAt = time.Now().Add(s.backupIdle)
+	if session.timer != nil {
+		session.timer.Reset(s.backupIdle)
+		return
+	}
+	session.timer = time.AfterFunc(s.backupIdle, func() {
+		s.backupsMu.Lock()
+		expired := s.backups[id] == session && session.downloads == 0 && !time.Now().Before(session.expiresAt)
+		if expired {
+			delete(s.backups, id)
+		}
+		s.backupsMu.Unlock()
+
+		if expired {
+			log.Printf("Backup %s expired", id)
+			closeBackup(session.backup)
+		}
+	})
+}
+
+// removeBackup deletes backup id, reporting false if there is no such
+// backup. Downloads in progress fail.
+func (s *Server) removeBackup(id string) bool {
+	s.backupsMu.Lock()
+	session := s.backups[id]
+	if session != nil {
+		delete(s.backups, id)
+		session.timer.Stop()
+	}
+	s.backupsMu.Unlock()
+
+	if session == nil {
+		return false
+	}
+	closeBackup(session.backup)
+	return true
+}
+
+// closeBackups releases every backup, on shutdown
+func (s *Server) closeBackups() {
+	s.backupsMu.Lock()
+	sessions := s.backups
+	s.backups = make(map[string]*backupSession)
+	s.backupsMu.Unlock()
+
+	for _, session := range sessions {
+		session.timer.Stop()
+		closeBackup(session.backup)
+	}
+}
+
+// closeBackup releases a backup, logging failures
+func closeBackup(backup *storage.Backup) {
+	if err := backup.Close(); err != nil {
+		log.Printf("Error closing backup %s: %v", backup.ID, err)
+	}
+}
+
+// newBackupInfo describes a backup session. s.backupsMu must be held.
+func newBackupInfo(session *backupSession) BackupInfo {
+	info := BackupInfo{
+		ID:        session.backup.ID,
+		CreatedAt: session.backup.CreatedAt.UnixMilli(),
+		Size:      session.backup.Size(),
+		Blocks:    session.backup.Blocks,
+		Downloads: session.downloads,
+	}
+	if session.downloads == 0 {
+		info.ExpiresAt = session.expiresAt.UnixMilli()
+	}
+	return info
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"runtime"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
+)
+
+// BuildInfo identifies the build of the server.
+type BuildInfo struct {
+	Version   string
+	Commit    string
+	BuildDate string
+}
+
+// SetBuildInfo sets the build reported by /api/v1/status/buildinfo.
+// Without it only storage.Version is reported.
+func (s *Server) SetBuildInfo(info BuildInfo) {
+	s.buildInfo = info
+}
+
+// handleBuildInfo reports the build, the features enabled and the storage
+// format versions, for tooling managing a fleet of servers.
+func (s *Server) handleBuildInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	info := s.buildInfo
+	if info.Version == "" {
+		info.Version = storage.Version
+	}
+	features := s.db.Features()
+	formats := storage.CurrentFormatVersions()
+
+	s.writeJSONResponse(w, BuildInfoResponse{
+		Status: "success",
+		Data: &BuildInfoData{
+			Version:   info.Version,
+			Commit:    info.Commit,
+			BuildDate: info.BuildDate,
+			GoVersion: runtime.Version(),
+			Features: map[string]bool{
+				"compaction":   features.Compaction,
+				"retention":    features.Retention,
+				"multiTenancy": features.Tenant != "",
+				"diskWatchdog": features.DiskWatchdog,
+				"rollups":      s.rollups != nil,
+				"asyncWrites":  s.writeQueue != nil,
+				"auditLog":     s.audit != nil,
+				"queryLog":     s.queryLog != nil,
+			},
+			Tenant: features.Tenant,
+			Formats: FormatVersions{
+				Block:    formats.Block,
+				MinBlock: formats.MinBlock,
+				Index:    formats.Index,
+				WAL:      formats.WAL,
+			},
+		},
+	}, http.StatusOK)
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// connLimiter counts the server's open connections against a cap
+type connLimiter struct {
+	max  int64 // 0 means unlimited
+	open atomic.Int64
+}
+
+// refusedConnKey marks the context of connections opened past the cap
+type refusedConnKey struct{}
+
+// connContext is the server's ConnContext hook, counting each new
+// connection and marking those past the cap
+func (l *connLimiter) connContext(ctx context.Context, _ net.Conn) context.Context {
+	if l.open.Add(1) > l.max && l.max > 0 {
+		return context.WithValue(ctx, refusedConnKey{}, true)
+	}
+	return ctx
+}
+
+// connState is the server's ConnState hook, uncounting connections as
+// they close
+func (l *connLimiter) connState(_ net.Conn, state http.ConnState) {
+	if state == http.StateClosed || state == http.StateHijacked {
+		l.open.Add(-1)
+	}
+}
+
+// limitConnections answers requests on connections opened past the cap
+// with 503, closing the connection so the client retries on a new one.
+// Liveness checks are always answered: an overloaded server is still
+// alive.
+func (s *Server) limitConnections(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if refused, _ := r.Context().Value(refusedConnKey{}).(bool); refused && r.URL.Path != "/-/healthy" {
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Retry-After", "1")
+			s.writeError(w, ErrorUnavailable, "too many open connections")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMaxConnections(t *testing.T) {
+	_, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	server := NewServer(db, ":0", WithMaxConnections(1))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.server.Serve(ln)
+	defer server.server.Close()
+	url := "http://" + ln.Addr().String()
+
+	// Separate transports, so each client holds a connection of its own
+	get := func(transport *http.Transport, path string) int {
+		t.Helper()
+		resp, err := (&http.Client{Transport: transport}).Get(url + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	first, second := &http.Transport{}, &http.Transport{}
+	defer second.CloseIdleConnections()
+
+	if code := get(first, "/api/v1/status/tsdb"); code != http.StatusOK {
+		t.Fatalf("first connection status = %d, want %d", code, http.StatusOK)
+	}
+	if code := get(second, "/api/v1/status/tsdb"); code != http.StatusServiceUnavailable {
+		t.Errorf("connection past the cap status = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	if code := get(second, "/-/healthy"); code != http.StatusOK {
+		t.Errorf("liveness check past the cap status = %d, want %d", code, http.StatusOK)
+	}
+
+	// Once the first connection closes, there is room again
+	first.CloseIdleConnections()
+	deadline := time.Now().Add(5 * time.Second)
+	for get(second, "/api/v1/status/tsdb") != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("connections still refused after the first one closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWithTimeouts(t *testing.T) {
+	_, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	server := NewServer(db, ":0")
+	if server.server.WriteTimeout != DefaultWriteTimeout {
+		t.Errorf("default write timeout = %s, want %s", server.server.WriteTimeout, DefaultWriteTimeout)
+	}
+
+	server = NewServer(db, ":0", WithTimeouts(time.Minute, 0, time.Hour))
+	if server.server.ReadTimeout != time.Minute || server.server.WriteTimeout != 0 || server.server.IdleTimeout != time.Hour {
+		t.Errorf("timeouts = %s/%s/%s, want 1m/0s/1h",
+			server.server.ReadTimeout, server.server.WriteTimeout, server.server.IdleTimeout)
+	}
+}
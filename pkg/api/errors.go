@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+)
+
+// ErrorType classifies API errors, matching the Prometheus API errorType field.
+type ErrorType string
+
+const (
+	// ErrorBadData means the request was malformed; retrying it won't help
+	ErrorBadData ErrorType = "bad_data"
+
+	// ErrorTimeout means the request ran out of time
+	ErrorTimeout ErrorType = "timeout"
+
+	// ErrorInternal means an unexpected server-side failure
+	ErrorInternal ErrorType = "internal"
+
+	// ErrorUnavailable means the server can't serve the request right now,
+	// e.g. while shutting down or when ingestion is backed up
+	ErrorUnavailable ErrorType = "unavailable"
+)
+
+// retryAfterSeconds is the Retry-After hint sent with unavailable errors
+const retryAfterSeconds = "5"
+
+// StatusCode returns the HTTP status code for the error type.
+func (t ErrorType) StatusCode() int {
+	switch t {
+	case ErrorBadData:
+		return http.StatusBadRequest
+	case ErrorTimeout, ErrorUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// classifyError maps storage and query errors to an error type by their
+// kind.
+func classifyError(err error) ErrorType {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return ErrorTimeout
+	}
+	switch tsdberrors.KindOf(err) {
+	case tsdberrors.Unavailable:
+		return ErrorUnavailable
+	case tsdberrors.Invalid, tsdberrors.NotFound, tsdberrors.Conflict, tsdberrors.ResourceExhausted:
+		return ErrorBadData
+	default:
+		return ErrorInternal
+	}
+}
+
+// setErrorHeaders marks transient errors as uncacheable, so proxies and
+// dashboards don't keep serving a failure after it clears, and tells
+// clients when to retry unavailable requests. bad_data responses are
+// deterministic and left cacheable.
+func setErrorHeaders(w http.ResponseWriter, errType ErrorType) {
+	if errType == ErrorBadData {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if errType == ErrorUnavailable {
+		w.Header().Set("Retry-After", retryAfterSeconds)
+	}
+}
+
+// writeError writes a Prometheus-style JSON error response.
+func (s *Server) writeError(w http.ResponseWriter, errType ErrorType, errMsg string) {
+	setErrorHeaders(w, errType)
+	response := QueryResponse{
+		Status:    "error",
+		ErrorType: errType,
+		Error:     errMsg,
+	}
+	s.writeJSONResponse(w, response, errType.StatusCode())
+}
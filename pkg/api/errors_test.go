@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+	"github.com/therealutkarshpriyadarshi/time/pkg/query"
+	"github.com/therealutkarshpriyadarshi/time/pkg/rollup"
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
+	"github.com/therealutkarshpriyadarshi/time/pkg/wal"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ErrorType
+	}{
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), ErrorTimeout},
+		{storage.ErrClosed, ErrorUnavailable},
+		{fmt.Errorf("tsdb: memtable insert failed: %w", storage.ErrMemTableFull), ErrorUnavailable},
+		{&storage.ValidationError{Err: storage.ErrInvalidLabelName, Detail: "bad"}, ErrorBadData},
+		{storage.ErrInvalidSample, ErrorBadData},
+		{fmt.Errorf("%w: series up, timestamp 1000", storage.ErrDuplicateSample), ErrorBadData},
+		{fmt.Errorf("%w: truncated", storage.ErrInvalidToken), ErrorBadData},
+		{fmt.Errorf("%w: limit is 10", query.ErrTooManySamples), ErrorBadData},
+		{fmt.Errorf("rule cpu: %w", rollup.ErrRuleNotFound), ErrorBadData},
+		{fmt.Errorf("append: %w", wal.ErrClosed), ErrorUnavailable},
+		{fmt.Errorf("%w: chunk 000001: bad checksum", storage.ErrBlockCorrupt), ErrorInternal},
+		{tsdberrors.WithKind(errors.New("no such backup"), tsdberrors.NotFound), ErrorBadData},
+		{errors.New("disk on fire"), ErrorInternal},
+	}
+
+	for _, tt := range tests {
+		if got := classifyError(tt.err); got != tt.want {
+			t.Errorf("classifyError(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	get := func(path string) (*httptest.ResponseRecorder, QueryResponse) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+		var resp QueryResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return w, resp
+	}
+
+	// Malformed matchers are the client's fault
+	w, resp := get("/api/v1/query?query=" + url.QueryEscape(`{host=~"("}`))
+	if w.Code != http.StatusBadRequest || resp.ErrorType != ErrorBadData {
+		t.Errorf("bad matcher: status %d errorType %q, want 400 bad_data", w.Code, resp.ErrorType)
+	}
+	if w.Header().Get("Cache-Control") != "" {
+		t.Errorf("bad_data response should stay cacheable, got Cache-Control %q", w.Header().Get("Cache-Control"))
+	}
+
+	// A closed database is temporarily unavailable, not an internal error
+	db.Close()
+	w, resp = get("/api/v1/labels")
+	if w.Code != http.StatusServiceUnavailable || resp.ErrorType != ErrorUnavailable {
+		t.Errorf("closed db: status %d errorType %q, want 503 unavailable", w.Code, resp.ErrorType)
+	}
+	if w.Header().Get("Cache-Control") != "no-store" || w.Header().Get("Retry-After") == "" {
+		t.Errorf("unavailable response headers = %v, want no-store and Retry-After", w.Header())
+	}
+}
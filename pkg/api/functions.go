@@ -0,0 +1,122 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/query"
+)
+
+// Functions the query endpoints evaluate around a selector
+const (
+	funcAbsent         = "absent"           // absent(<selector>)
+	funcAbsentOverTime = "absent_over_time" // absent_over_time(<selector>[<range>])
+	funcVector         = "vector"           // vector(<number>)
+
+	// rate, increase, delta and derivative(<selector>[<range>]), see
+	// query.RangeFunc
+)
+
+// queryExpr is a parsed query parameter: a selector, possibly wrapped in
+// one of the functions above
+type queryExpr struct {
+	function string // Empty for a plain selector
+	matchers index.Matchers
+	offset   int64   // Milliseconds, see query.Query.Offset
+	rangeMs  int64   // The range of absent_over_time and the range functions
+	scalar   float64 // The argument of vector
+}
+
+// parseQueryExpr parses a query parameter.
+// Examples:
+//
+//	{__name__="cpu_usage"} offset 1d
+//	absent({__name__="up",job="api"})
+//	absent_over_time({__name__="up",job="api"}[10m])
+//	rate({__name__="http_requests_total",job="api"}[5m])
+//	vector(0)
+func parseQueryExpr(queryStr string) (*queryExpr, error) {
+	queryStr = strings.TrimSpace(queryStr)
+	open := strings.Index(queryStr, "(")
+	if open < 0 || strings.HasPrefix(queryStr, "{") {
+		matchers, offset, err := parseSelector(queryStr)
+		if err != nil {
+			return nil, err
+		}
+		return &queryExpr{matchers: matchers, offset: offset}, nil
+	}
+
+	if !strings.HasSuffix(queryStr, ")") {
+		return nil, fmt.Errorf("missing closing parenthesis")
+	}
+	expr := &queryExpr{function: strings.TrimSpace(queryStr[:open])}
+	arg := strings.TrimSpace(queryStr[open+1 : len(queryStr)-1])
+
+	switch expr.function {
+	case funcVector:
+		v, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, fmt.Errorf("vector() wants a number, got %q", arg)
+		}
+		expr.scalar = v
+		return expr, nil
+
+	case funcAbsent:
+		matchers, offset, err := parseSelector(arg)
+		if err != nil {
+			return nil, fmt.Errorf("absent(): %w", err)
+		}
+		expr.matchers, expr.offset = matchers, offset
+		return expr, nil
+
+	case funcAbsentOverTime, string(query.RangeRate), string(query.RangeIncrease),
+		string(query.RangeDelta), string(query.RangeDerivative):
+		if err := expr.parseRangeSelector(arg); err != nil {
+			return nil, fmt.Errorf("%s(): %w", expr.function, err)
+		}
+		return expr, nil
+
+	default:
+		return nil, fmt.Errorf("unknown function %q (want absent, absent_over_time, rate, increase, delta, derivative or vector)", expr.function)
+	}
+}
+
+// parseRangeSelector sets the matchers, offset and range of arg, a range
+// selector: the range follows the selector, {...}[10m] offset 1d
+func (e *queryExpr) parseRangeSelector(arg string) error {
+	start := strings.LastIndex(arg, "}[")
+	end := strings.LastIndex(arg, "]")
+	if start < 0 || end < start {
+		return fmt.Errorf("wants a range selector, e.g. {job=\"api\"}[10m]")
+	}
+	rangeMs, err := parseDuration(arg[start+2 : end])
+	if err != nil {
+		return fmt.Errorf("invalid range: %w", err)
+	}
+	if rangeMs <= 0 {
+		return fmt.Errorf("range must be positive")
+	}
+	matchers, offset, err := parseSelector(arg[:start+1] + arg[end+1:])
+	if err != nil {
+		return err
+	}
+	e.matchers, e.offset, e.rangeMs = matchers, offset, rangeMs
+	return nil
+}
+
+// exec evaluates the expression for q, whose matchers and offset it has
+// set
+func (e *queryExpr) exec(engine *query.QueryEngine, q *query.Query) (*query.QueryResult, error) {
+	switch e.function {
+	case funcAbsent, funcAbsentOverTime:
+		return engine.Absent(q, e.rangeMs)
+	case funcVector:
+		return engine.Vector(q, e.scalar)
+	case string(query.RangeRate), string(query.RangeIncrease), string(query.RangeDelta), string(query.RangeDerivative):
+		return engine.EvalRange(q, query.RangeFunc(e.function), e.rangeMs)
+	default:
+		return engine.ExecQuery(q)
+	}
+}
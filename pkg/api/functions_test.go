@@ -0,0 +1,152 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestParseQueryExpr(t *testing.T) {
+	tests := []struct {
+		query    string
+		function string
+		matchers int
+		offset   int64
+		rangeMs  int64
+		wantErr  bool
+	}{
+		{query: `{__name__="up"} offset 1m`, matchers: 1, offset: 60000},
+		{query: `{job=~"(api|web)"}`, matchers: 1},
+		{query: `absent({__name__="up",job="api"})`, function: funcAbsent, matchers: 2},
+		{query: `absent_over_time({__name__="up"}[10m] offset 1h)`, function: funcAbsentOverTime, matchers: 1, offset: 3600000, rangeMs: 600000},
+		{query: `vector(0)`, function: funcVector},
+		{query: `absent_over_time({__name__="up"})`, wantErr: true},
+		{query: `absent_over_time({__name__="up"}[0s])`, wantErr: true},
+		{query: `absent({__name__="up"}`, wantErr: true},
+		{query: `vector(zero)`, wantErr: true},
+		{query: `rate({__name__="http_requests_total",job="api"}[5m] offset 1m)`, function: "rate", matchers: 2, offset: 60000, rangeMs: 300000},
+		{query: `derivative({__name__="temp"}[1m])`, function: "derivative", matchers: 1, rangeMs: 60000},
+		{query: `increase({__name__="up"})`, wantErr: true},
+		{query: `irate({__name__="up"}[5m])`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		expr, err := parseQueryExpr(tt.query)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseQueryExpr(%q) expected error", tt.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseQueryExpr(%q) unexpected error: %v", tt.query, err)
+			continue
+		}
+		if expr.function != tt.function || len(expr.matchers) != tt.matchers || expr.offset != tt.offset || expr.rangeMs != tt.rangeMs {
+			t.Errorf("parseQueryExpr(%q) = %+v", tt.query, expr)
+		}
+	}
+}
+
+func TestHandleQueryAbsent(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	s := series.NewSeries(map[string]string{"__name__": "up", "job": "api"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 1}}); err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+
+	query := func(q, at string) []QueryResult {
+		params := url.Values{"query": {q}, "time": {at}}
+		w := httptest.NewRecorder()
+		server.handleQuery(w, httptest.NewRequest(http.MethodGet, "/api/v1/query?"+params.Encode(), nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body: %s", q, w.Code, w.Body.String())
+		}
+		var resp QueryResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp.Data.Result
+	}
+
+	if result := query(`absent_over_time({__name__="up",job="api"}[5s])`, "6"); len(result) != 0 {
+		t.Errorf("series reported absent while present: %+v", result)
+	}
+	result := query(`absent_over_time({__name__="up",job="api"}[5s])`, "8")
+	if len(result) != 1 || result[0].Metric["job"] != "api" || result[0].Value[1] != "1.000000" {
+		t.Errorf("got %+v, want {job=\"api\"} absent", result)
+	}
+	if result := query(`vector(0)`, "8"); len(result) != 1 || result[0].Value[1] != "0.000000" {
+		t.Errorf("vector(0) = %+v", result)
+	}
+}
+
+func TestHandleQueryRangeRate(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	// 1 request/s, the counter reset to 0 just after 50s
+	s := series.NewSeries(map[string]string{"__name__": "http_requests_total", "job": "api"})
+	var samples []series.Sample
+	for i := int64(0); i <= 120; i += 10 {
+		v := i
+		if i >= 60 {
+			v -= 50
+		}
+		samples = append(samples, series.Sample{Timestamp: i * 1000, Value: float64(v)})
+	}
+	if err := db.Insert(s, samples); err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+
+	queryRange := func(q string) []QueryResult {
+		params := url.Values{"query": {q}, "start": {"30"}, "end": {"120"}, "step": {"30s"}}
+		w := httptest.NewRecorder()
+		server.handleQueryRange(w, httptest.NewRequest(http.MethodGet, "/api/v1/query_range?"+params.Encode(), nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body: %s", q, w.Code, w.Body.String())
+		}
+		var resp QueryResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp.Data.Result
+	}
+
+	result := queryRange(`rate({__name__="http_requests_total",job="api"}[30s])`)
+	if len(result) != 1 || len(result[0].Values) != 4 {
+		t.Fatalf("got %+v, want one series with 4 steps", result)
+	}
+	if _, ok := result[0].Metric["__name__"]; ok || result[0].Metric["job"] != "api" {
+		t.Errorf("labels = %v, want job without the metric name", result[0].Metric)
+	}
+	for _, v := range result[0].Values {
+		if v[1] != "1.000000" {
+			t.Errorf("rate at %v = %v, want 1 across the reset", v[0], v[1])
+		}
+	}
+
+	// The reset makes delta negative at 60s
+	result = queryRange(`delta({__name__="http_requests_total",job="api"}[30s])`)
+	if len(result) != 1 || result[0].Values[1][1] != "-20.000000" {
+		t.Errorf("delta = %+v, want -20 at 60s", result)
+	}
+
+	// Without their names, two metrics of the job can't be told apart
+	failures := series.NewSeries(map[string]string{"__name__": "http_errors_total", "job": "api"})
+	if err := db.Insert(failures, samples); err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	params := url.Values{"query": {`rate({job="api"}[30s])`}, "start": {"30"}, "end": {"120"}, "step": {"30s"}}
+	w := httptest.NewRecorder()
+	server.handleQueryRange(w, httptest.NewRequest(http.MethodGet, "/api/v1/query_range?"+params.Encode(), nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("rate over two metrics of a job: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
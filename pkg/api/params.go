@@ -0,0 +1,196 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseTime parses a time parameter into Unix milliseconds. As in the
+// Prometheus API, it accepts
+//   - numbers, as Unix seconds (e.g. "1640000000" or "1640000000.5", as sent by Grafana)
+//   - RFC3339 timestamps (e.g. "2021-12-20T11:33:20Z")
+func parseTime(s string) (int64, error) {
+	if ms, ok, err := parseSeconds(s); ok {
+		if err != nil {
+			return 0, fmt.Errorf("timestamp %q out of range", s)
+		}
+		return ms, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.UnixMilli(), nil
+	}
+
+	return 0, fmt.Errorf("cannot parse %q as a timestamp (want Unix seconds or RFC3339)", s)
+}
+
+// parseSeconds parses a number of seconds, such as "15" or "1640000000.5",
+// into milliseconds. It reports false if s is not a number.
+func parseSeconds(s string) (int64, bool, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if secs > math.MaxInt64/1000 || secs < math.MinInt64/1000 {
+			return 0, true, fmt.Errorf("%q out of range", s)
+		}
+		return secs * 1000, true, nil
+	}
+
+	// ParseFloat also takes "Inf" and "NaN", which aren't numbers here
+	if !strings.ContainsAny(s, ".eE") || strings.ContainsAny(s, "nN") {
+		return 0, false, nil
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, nil
+	}
+	if math.IsNaN(secs) || math.IsInf(secs, 0) || math.Abs(secs) > math.MaxInt64/1000 {
+		return 0, true, fmt.Errorf("%q out of range", s)
+	}
+	return int64(math.Round(secs * 1000)), true, nil
+}
+
+// durationUnits are the Prometheus duration units and their length in milliseconds
+var durationUnits = []struct {
+	suffix string
+	millis int64
+}{
+	// "ms" must be tried before "m" and "s"
+	{"ms", 1},
+	{"s", 1000},
+	{"m", 60 * 1000},
+	{"h", 60 * 60 * 1000},
+	{"d", 24 * 60 * 60 * 1000},
+	{"w", 7 * 24 * 60 * 60 * 1000},
+	{"y", 365 * 24 * 60 * 60 * 1000},
+}
+
+// parseDuration parses a duration parameter such as step into milliseconds.
+// As in the Prometheus API, it accepts
+//   - numbers, as seconds (e.g. "15" or "15.5", as sent by Grafana)
+//   - Prometheus durations, combining units from ms, s, m, h, d, w and y (e.g. "30s", "1h30m")
+func parseDuration(s string) (int64, error) {
+	if ms, ok, err := parseSeconds(s); ok {
+		if err != nil {
+			return 0, fmt.Errorf("duration %q out of range", s)
+		}
+		return ms, nil
+	}
+
+	if s == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+
+	var total int64
+	rest := s
+	for rest != "" {
+		// Leading digits
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("cannot parse %q as a duration", s)
+		}
+		n, err := strconv.ParseInt(rest[:i], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot parse %q as a duration: %w", s, err)
+		}
+		rest = rest[i:]
+
+		// Unit
+		matched := false
+		for _, unit := range durationUnits {
+			if strings.HasPrefix(rest, unit.suffix) {
+				if n > (math.MaxInt64-total)/unit.millis {
+					return 0, fmt.Errorf("duration %q out of range", s)
+				}
+				total += n * unit.millis
+				rest = rest[len(unit.suffix):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return 0, fmt.Errorf("cannot parse %q as a duration: unknown or missing unit", s)
+		}
+	}
+
+	return total, nil
+}
+
+// Timestamp precisions of the write endpoint's precision parameter
+const (
+	PrecisionSeconds = "s"
+	PrecisionMillis  = "ms"
+	PrecisionMicros  = "us"
+	PrecisionNanos   = "ns"
+	PrecisionAuto    = "auto" // Each timestamp's unit is told by its magnitude
+
+	// PrecisionUnchecked takes timestamps as milliseconds without checking
+	// them, for clients writing historical data from before 1973
+	PrecisionUnchecked = "unchecked"
+)
+
+// precisionUnits are the timestamp precisions, coarsest first, with the
+// smallest plausible timestamp in each: 1973-03-03, the smallest with 12
+// digits in milliseconds. Each unit's range ends where the next begins,
+// around the year 5138, so any plausible timestamp has exactly one unit.
+var precisionUnits = []struct {
+	name     string
+	min      int64
+	toMillis func(int64) int64
+}{
+	{PrecisionSeconds, 1e8, func(ts int64) int64 { return ts * 1000 }},
+	{PrecisionMillis, 1e11, func(ts int64) int64 { return ts }},
+	{PrecisionMicros, 1e14, func(ts int64) int64 { return ts / 1e3 }},
+	{PrecisionNanos, 1e17, func(ts int64) int64 { return ts / 1e6 }},
+}
+
+// parsePrecision checks a precision parameter; empty means milliseconds
+func parsePrecision(s string) (string, error) {
+	switch s {
+	case "":
+		return PrecisionMillis, nil
+	case PrecisionSeconds, PrecisionMillis, PrecisionMicros, PrecisionNanos, PrecisionAuto, PrecisionUnchecked:
+		return s, nil
+	default:
+		return "", fmt.Errorf("Invalid precision parameter: %q (want s, ms, us, ns, auto or unchecked)", s)
+	}
+}
+
+// precisionOf returns the unit a timestamp's magnitude suggests, or "" if
+// it is implausible in every unit
+func precisionOf(ts int64) string {
+	unit := ""
+	for _, u := range precisionUnits {
+		if ts >= u.min {
+			unit = u.name
+		}
+	}
+	return unit
+}
+
+// timestampMillis converts a timestamp of the given precision to Unix
+// milliseconds, truncating finer ones. PrecisionUnchecked takes it as
+// milliseconds as is; any other precision fails for a timestamp outside
+// the plausible range of its unit, naming the unit it looks like.
+func timestampMillis(ts int64, precision string) (int64, error) {
+	if precision == PrecisionUnchecked {
+		return ts, nil
+	}
+	detected := precisionOf(ts)
+	if detected == "" {
+		return 0, fmt.Errorf("implausible timestamp %d: before 1973 in any unit", ts)
+	}
+	if precision != PrecisionAuto && precision != detected {
+		return 0, fmt.Errorf("implausible timestamp %d in %s: it looks like %s", ts, precision, detected)
+	}
+	for _, u := range precisionUnits {
+		if u.name == detected {
+			return u.toMillis(ts), nil
+		}
+	}
+	return ts, nil
+}
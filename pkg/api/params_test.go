@@ -0,0 +1,187 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "1640000000", want: 1640000000000},
+		{input: "0", want: 0},
+		{input: "-1", want: -1000},
+		{input: "1640000000.5", want: 1640000000500},
+		{input: "1640000000.123", want: 1640000000123},
+		{input: "1.64e9", want: 1640000000000},
+		{input: "2021-12-20T11:33:20Z", want: 1640000000000},
+		{input: "2021-12-20T12:33:20.250+01:00", want: 1640000000250},
+		{input: "yesterday", wantErr: true},
+		{input: "1e300", wantErr: true},
+		{input: "9223372036854776", wantErr: true},
+		{input: "NaN", wantErr: true},
+		{input: "Inf", wantErr: true},
+		{input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTime(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTime(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("parseTime(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "60", want: 60000},
+		{input: "15.5", want: 15500},
+		{input: "30s", want: 30000},
+		{input: "500ms", want: 500},
+		{input: "5m", want: 300000},
+		{input: "1h30m", want: 5400000},
+		{input: "1d", want: 86400000},
+		{input: "2w", want: 1209600000},
+		{input: "1y", want: 31536000000},
+		{input: "1m30s250ms", want: 90250},
+		{input: "", wantErr: true},
+		{input: "5", want: 5000},
+		{input: "0.25", want: 250},
+		{input: "s", wantErr: true},
+		{input: "5x", wantErr: true},
+		{input: "1.5m", wantErr: true},
+		{input: "99999999999999y", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseDuration(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDuration(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("parseDuration(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestTimestampMillis(t *testing.T) {
+	const ms = int64(1700000000123)
+	tests := []struct {
+		ts        int64
+		precision string
+		want      int64
+		wantErr   bool
+	}{
+		{ts: 1000, precision: PrecisionUnchecked, want: 1000},
+		{ts: -1, precision: PrecisionUnchecked, want: -1},
+		{ts: 1700000000, precision: PrecisionSeconds, want: 1700000000000},
+		{ts: ms, precision: PrecisionMillis, want: ms},
+		{ts: ms*1000 + 456, precision: PrecisionMicros, want: ms},
+		{ts: ms*1000000 + 456789, precision: PrecisionNanos, want: ms},
+		{ts: 1700000000, precision: PrecisionAuto, want: 1700000000000},
+		{ts: ms, precision: PrecisionAuto, want: ms},
+		{ts: ms * 1000, precision: PrecisionAuto, want: ms},
+		{ts: ms * 1000000, precision: PrecisionAuto, want: ms},
+		{ts: 1700000000, precision: PrecisionMillis, wantErr: true},
+		{ts: ms, precision: PrecisionSeconds, wantErr: true},
+		{ts: ms * 1000000, precision: PrecisionMicros, wantErr: true},
+		{ts: 1000, precision: PrecisionAuto, wantErr: true},
+		{ts: -1, precision: PrecisionMillis, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := timestampMillis(tt.ts, tt.precision)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("timestampMillis(%d, %q) error = %v, wantErr %v", tt.ts, tt.precision, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("timestampMillis(%d, %q) = %d, want %d", tt.ts, tt.precision, got, tt.want)
+		}
+	}
+}
+
+func TestHandleQueryRangePrometheusParams(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	tests := []struct {
+		name       string
+		start, end string
+		step       string
+		wantStatus int
+	}{
+		{"float seconds and duration step", "1640000000.000", "1640000060.5", "15s", http.StatusOK},
+		{"rfc3339 and float step", "2021-12-20T11:33:20Z", "2021-12-20T12:33:20Z", "30.0", http.StatusOK},
+		{"zero step", "0", "5000", "0s", http.StatusBadRequest},
+		{"end before start", "5000", "0", "1s", http.StatusBadRequest},
+		{"bad step", "0", "5000", "fast", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := url.Values{}
+			params.Set("query", `{__name__="cpu_usage"}`)
+			params.Set("start", tt.start)
+			params.Set("end", tt.end)
+			params.Set("step", tt.step)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/query_range?"+params.Encode(), nil)
+			w := httptest.NewRecorder()
+			server.handleQueryRange(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		query      string
+		matchers   int
+		wantOffset int64
+		wantErr    bool
+	}{
+		{`{__name__="cpu_usage"}`, 1, 0, false},
+		{`{__name__="cpu_usage",host="a"} offset 1w`, 2, 7 * 24 * 60 * 60 * 1000, false},
+		{` {}  offset  1h30m `, 0, 90 * 60 * 1000, false},
+		{`{__name__="cpu_usage"} offset`, 0, 0, true},
+		{`{__name__="cpu_usage"} offset -1d`, 0, 0, true},
+		{`{__name__="cpu_usage"} offset soon`, 0, 0, true},
+		{`{__name__="cpu_usage"} shift 1d`, 0, 0, true},
+		{`cpu_usage offset 1d`, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		matchers, offset, err := parseSelector(tt.query)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSelector(%q) expected error", tt.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSelector(%q) unexpected error: %v", tt.query, err)
+			continue
+		}
+		if len(matchers) != tt.matchers || offset != tt.wantOffset {
+			t.Errorf("parseSelector(%q) = %d matchers, offset %d; want %d, %d", tt.query, len(matchers), offset, tt.matchers, tt.wantOffset)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/query"
+)
+
+const (
+	// DefaultQueryLogMaxBytes is the size past which the query log is rotated
+	DefaultQueryLogMaxBytes = 100 << 20
+
+	// DefaultQueryLogMaxFiles is how many rotated query logs are kept
+	DefaultQueryLogMaxFiles = 5
+
+	// queryLogRecentEntries is how many entries a QueryLog keeps in memory
+	// for /api/v1/admin/query_log
+	queryLogRecentEntries = 1000
+)
+
+// QueryLogEntry records a query: what it asked for, who asked, how long it
+// took, how much it returned and whether it failed.
+type QueryLogEntry struct {
+	Time            int64   `json:"time"`     // Unix milliseconds when the query started
+	Endpoint        string  `json:"endpoint"` // query or query_range
+	Query           string  `json:"query"`
+	Start           int64   `json:"start"`          // Unix milliseconds
+	End             int64   `json:"end"`            // Unix milliseconds
+	Step            int64   `json:"step,omitempty"` // Milliseconds; unset for instant queries
+	DurationSeconds float64 `json:"durationSeconds"`
+	Series          int     `json:"series"`  // Series returned
+	Samples         int     `json:"samples"` // Samples returned
+	Client          string  `json:"client"`
+	ForwardedFor    string  `json:"forwardedFor,omitempty"`
+	User            string  `json:"user,omitempty"`
+	Error           string  `json:"error,omitempty"` // Empty if the query succeeded
+}
+
+// QueryLog appends queries to a file, one JSON entry per line. Once the
+// file grows past maxBytes it is renamed to path.1, shifting older files up
+// to path.maxFiles, and a new one started. Entries aren't synced: a crash
+// may lose the latest.
+type QueryLog struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	size     int64
+	maxBytes int64
+	maxFiles int
+	recent   []QueryLogEntry // The latest entries, oldest first
+}
+
+// OpenQueryLog opens the query log at path, creating it if needed. A
+// maxBytes of 0 never rotates it.
+func OpenQueryLog(path string, maxBytes int64, maxFiles int) (*QueryLog, error) {
+	if maxBytes < 0 || maxFiles < 0 {
+		return nil, fmt.Errorf("query log size and file count cannot be negative")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create query log directory: %w", err)
+	}
+	l := &QueryLog{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the file at l.path for appending
+func (l *QueryLog) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open query log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open query log: %w", err)
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// Record appends an entry to the log, rotating it first if the entry
+// would take it past its size.
+func (l *QueryLog) Record(entry QueryLogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.remember(entry)
+	if l.maxBytes > 0 && l.size > 0 && l.size+int64(len(data)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write query log: %w", err)
+	}
+	return nil
+}
+
+// rotate moves the current file to path.1, shifting older ones up and
+// dropping the one past maxFiles, and starts a new file. l.mu must be held.
+func (l *QueryLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close query log: %w", err)
+	}
+	rotated := func(n int) string { return l.path + "." + strconv.Itoa(n) }
+
+	if err := os.Remove(rotated(l.maxFiles)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove old query log: %w", err)
+	}
+	for n := l.maxFiles - 1; n >= 1; n-- {
+		if err := os.Rename(rotated(n), rotated(n+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate query log: %w", err)
+		}
+	}
+	if l.maxFiles > 0 {
+		if err := os.Rename(l.path, rotated(1)); err != nil {
+			return fmt.Errorf("failed to rotate query log: %w", err)
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return fmt.Errorf("failed to rotate query log: %w", err)
+	}
+	return l.open()
+}
+
+// remember keeps entry among the recent ones
+func (l *QueryLog) remember(entry QueryLogEntry) {
+	if len(l.recent) == queryLogRecentEntries {
+		copy(l.recent, l.recent[1:])
+		l.recent = l.recent[:len(l.recent)-1]
+	}
+	l.recent = append(l.recent, entry)
+}
+
+// Recent returns up to n of the latest entries, newest first.
+func (l *QueryLog) Recent(n int) []QueryLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n = min(n, len(l.recent))
+	entries := make([]QueryLogEntry, n)
+	for i := range entries {
+		entries[i] = l.recent[len(l.recent)-1-i]
+	}
+	return entries
+}
+
+// Close closes the log file.
+func (l *QueryLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// SetQueryLog records queries in l; nil disables the query log.
+func (s *Server) SetQueryLog(l *QueryLog) {
+	s.queryLog = l
+}
+
+// recordQuery records a query made by r as queryStr, run as q from start,
+// with its result or error. A failure to record is logged.
+func (s *Server) recordQuery(r *http.Request, endpoint, queryStr string, q *query.Query, start time.Time,
+	result *query.QueryResult, queryErr error) {
+	if s.queryLog == nil {
+		return
+	}
+
+	entry := QueryLogEntry{
+		Time:            start.UnixMilli(),
+		Endpoint:        endpoint,
+		Query:           queryStr,
+		Start:           q.MinTime,
+		End:             q.MaxTime,
+		Step:            q.Step,
+		DurationSeconds: time.Since(start).Seconds(),
+		Client:          r.RemoteAddr,
+		ForwardedFor:    r.Header.Get("X-Forwarded-For"),
+		User:            r.Header.Get("X-Forwarded-User"),
+	}
+	if result != nil {
+		entry.Series = len(result.Series)
+		for _, ts := range result.Series {
+			entry.Samples += len(ts.Samples)
+		}
+	}
+	if queryErr != nil {
+		entry.Error = queryErr.Error()
+	}
+	if err := s.queryLog.Record(entry); err != nil {
+		log.Printf("Failed to record a query in the query log: %v", err)
+	}
+}
+
+// handleQueryLog returns the latest logged queries, newest first; limit
+// caps how many (default 100).
+func (s *Server) handleQueryLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.queryLog == nil {
+		s.writeError(w, ErrorUnavailable, "query log is not enabled")
+		return
+	}
+
+	limit := defaultAuditLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			s.writeError(w, ErrorBadData, fmt.Sprintf("invalid limit %q: must be a positive integer", limitStr))
+			return
+		}
+		limit = n
+	}
+	s.writeJSONResponse(w, QueryLogResponse{Status: "success", Data: s.queryLog.Recent(limit)}, http.StatusOK)
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestQueryLog(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "a"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "query.log")
+	queryLog, err := OpenQueryLog(path, 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to open query log: %v", err)
+	}
+	defer queryLog.Close()
+	server.SetQueryLog(queryLog)
+
+	for _, target := range []string{
+		`/api/v1/query_range?query={__name__="cpu_usage"}&start=0&end=3&step=1s`,
+		`/api/v1/query?query={__name__="cpu_usage"}&time=3`,
+		`/api/v1/query?query=absent({__name__="missing"})&time=3`,
+	} {
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d, want %d", target, w.Code, http.StatusOK)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/admin/query_log?limit=2", nil))
+	var resp QueryLogResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Data) != 2 {
+		t.Fatalf("got %d entries, want 2", len(resp.Data))
+	}
+	absent := resp.Data[0]
+	if absent.Endpoint != "query" || absent.Query != `absent({__name__="missing"})` || absent.Series != 1 || absent.Error != "" {
+		t.Errorf("latest entry is %+v, want the absent query returning a series", absent)
+	}
+
+	// Every executed query is in the file, oldest first
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []QueryLogEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry QueryLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("query log holds %d entries, want 3", len(entries))
+	}
+	rng := entries[0]
+	if rng.Endpoint != "query_range" || rng.Start != 0 || rng.End != 3000 || rng.Step != 1000 ||
+		rng.Series != 1 || rng.Samples != 2 || rng.Client == "" {
+		t.Errorf("first entry is %+v, want the range query with its result", rng)
+	}
+}
+
+func TestQueryLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "query.log")
+	queryLog, err := OpenQueryLog(path, 200, 2)
+	if err != nil {
+		t.Fatalf("Failed to open query log: %v", err)
+	}
+	defer queryLog.Close()
+
+	// Each entry is over half the size, so every entry starts a new file
+	for i := range 5 {
+		if err := queryLog.Record(QueryLogEntry{Time: int64(i), Query: `{__name__="cpu_usage"}`}); err != nil {
+			t.Fatalf("Failed to record: %v", err)
+		}
+	}
+
+	want := map[string]int64{path: 4, path + ".1": 3, path + ".2": 2}
+	for file, time := range want {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", file, err)
+		}
+		var entry QueryLogEntry
+		if err := json.Unmarshal(data, &entry); err != nil || entry.Time != time {
+			t.Errorf("%s holds %q, want the entry at %d alone", filepath.Base(file), data, time)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 kept past the file limit", path)
+	}
+	if got := queryLog.Recent(10); len(got) != 5 || got[0].Time != 4 {
+		t.Errorf("recent entries %+v, want all 5 newest first", got)
+	}
+}
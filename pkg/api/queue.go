@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+)
+
+var (
+	// ErrWriteQueueFull indicates an async write found the ingest queue
+	// full under the reject overflow policy
+	ErrWriteQueueFull = tsdberrors.New(tsdberrors.Unavailable, "write queue is full")
+
+	// ErrWriteQueueClosed indicates an async write after shutdown began
+	ErrWriteQueueClosed = tsdberrors.New(tsdberrors.Unavailable, "write queue is closed")
+)
+
+// OverflowPolicy decides what an async write does when the ingest queue
+// is full
+type OverflowPolicy string
+
+const (
+	// OverflowBlock waits for room in the queue for as long as the request
+	// lasts
+	OverflowBlock OverflowPolicy = "block"
+
+	// OverflowReject fails the write with 503 so the client retries later
+	OverflowReject OverflowPolicy = "reject"
+)
+
+// ParseOverflowPolicy parses an overflow policy name
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(s); p {
+	case OverflowBlock, OverflowReject:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown overflow policy %q (want block or reject)", s)
+	}
+}
+
+// WriteQueueOptions configures async writes
+type WriteQueueOptions struct {
+	// Size is how many write requests the queue holds
+	Size int
+
+	// Workers is how many goroutines write queued requests; 0 means 1
+	Workers int
+
+	// Overflow is what a write does when the queue is full; empty means
+	// OverflowBlock
+	Overflow OverflowPolicy
+}
+
+// writeQueue is a bounded queue of validated write requests, written to
+// the TSDB by background workers
+type writeQueue struct {
+	overflow OverflowPolicy
+	requests chan WriteRequest
+	workers  sync.WaitGroup
+
+	// mu keeps the channel from being closed while a write is being queued
+	mu     sync.RWMutex
+	closed bool
+
+	enqueued      atomic.Int64 // Requests queued
+	rejected      atomic.Int64 // Requests turned away because the queue was full
+	failed        atomic.Int64 // Queued requests not fully written
+	failedSamples atomic.Int64 // Samples of queued requests not written
+}
+
+// EnableAsyncWrites makes /api/v1/write validate requests and queue them
+// for background workers, answering 202 Accepted without waiting for the
+// WAL. A queued write that fails is logged and counted in the status; the
+// client is not told. Shutdown writes the requests still queued.
+func (s *Server) EnableAsyncWrites(opts WriteQueueOptions) error {
+	if opts.Size <= 0 {
+		return fmt.Errorf("write queue size must be positive, got %d", opts.Size)
+	}
+	if opts.Workers < 0 {
+		return fmt.Errorf("write queue workers must not be negative, got %d", opts.Workers)
+	}
+	if opts.Overflow == "" {
+		opts.Overflow = OverflowBlock
+	}
+	if _, err := ParseOverflowPolicy(string(opts.Overflow)); err != nil {
+		return err
+	}
+
+	q := &writeQueue{
+		overflow: opts.Overflow,
+		requests: make(chan WriteRequest, opts.Size),
+	}
+	for range max(opts.Workers, 1) {
+		q.workers.Add(1)
+		go s.writeWorker(q)
+	}
+	s.writeQueue = q
+	return nil
+}
+
+// writeWorker writes queued requests until the queue is closed and drained
+func (s *Server) writeWorker(q *writeQueue) {
+	defer q.workers.Done()
+	for req := range q.requests {
+		result := s.write(req)
+		if result.errType == "" {
+			continue
+		}
+		q.failed.Add(1)
+		q.failedSamples.Add(int64(result.rejected))
+		log.Printf("Async write: %d of %d samples rejected: %v", result.rejected, result.total, result.err)
+	}
+}
+
+// enqueueWrite validates req and queues it, answering 202 Accepted. An
+// invalid request is rejected as a whole, as a synchronous write would be.
+func (s *Server) enqueueWrite(w http.ResponseWriter, r *http.Request, req WriteRequest) {
+	result := newWriteResult(req)
+	for _, ts := range req.Timeseries {
+		result.add(s.validateTimeSeries(ts))
+	}
+	if result.failed {
+		result.rollBack()
+		result.errType = ErrorBadData
+		s.writeWriteResponse(w, result)
+		return
+	}
+
+	if err := s.writeQueue.push(r.Context(), req); err != nil {
+		result.fail(err)
+		s.writeWriteResponse(w, result)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// push queues req, applying the overflow policy if the queue is full
+func (q *writeQueue) push(ctx context.Context, req WriteRequest) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrWriteQueueClosed
+	}
+
+	select {
+	case q.requests <- req:
+		q.enqueued.Add(1)
+		return nil
+	default:
+	}
+	if q.overflow == OverflowReject {
+		q.rejected.Add(1)
+		return ErrWriteQueueFull
+	}
+	select {
+	case q.requests <- req:
+		q.enqueued.Add(1)
+		return nil
+	case <-ctx.Done():
+		q.rejected.Add(1)
+		return ctx.Err()
+	}
+}
+
+// close stops accepting writes and waits for the workers to write the
+// requests still queued, or for ctx to end
+func (q *writeQueue) close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.requests)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d queued writes not written: %w", len(q.requests), ctx.Err())
+	}
+}
+
+// status returns the queue's depth and counters
+func (q *writeQueue) status() *WriteQueueStatus {
+	return &WriteQueueStatus{
+		Depth:         len(q.requests),
+		Capacity:      cap(q.requests),
+		Overflow:      string(q.overflow),
+		Enqueued:      q.enqueued.Load(),
+		Rejected:      q.rejected.Load(),
+		Failed:        q.failed.Load(),
+		FailedSamples: q.failedSamples.Load(),
+	}
+}
+
+// writeMetrics writes the queue's depth and counters in the Prometheus
+// text format
+func (q *writeQueue) writeMetrics(w http.ResponseWriter) {
+	st := q.status()
+	for _, m := range []struct {
+		name, help, kind string
+		value            int64
+	}{
+		{"tsdb_write_queue_depth", "Write requests waiting in the async write queue", "gauge", int64(st.Depth)},
+		{"tsdb_write_queue_capacity", "Write requests the async write queue holds", "gauge", int64(st.Capacity)},
+		{"tsdb_write_queue_enqueued_total", "Write requests queued", "counter", st.Enqueued},
+		{"tsdb_write_queue_rejected_total", "Write requests turned away because the queue was full", "counter", st.Rejected},
+		{"tsdb_write_queue_failed_total", "Queued write requests not fully written", "counter", st.Failed},
+		{"tsdb_write_queue_failed_samples_total", "Samples of queued write requests not written", "counter", st.FailedSamples},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n\n", m.name, m.help, m.name, m.kind, m.name, m.value)
+	}
+}
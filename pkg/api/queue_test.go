@@ -0,0 +1,94 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestAsyncWrites(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	if err := server.EnableAsyncWrites(WriteQueueOptions{Size: 10, Workers: 2}); err != nil {
+		t.Fatalf("EnableAsyncWrites failed: %v", err)
+	}
+
+	write := func(name string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(WriteRequest{
+			Timeseries: []TimeSeries{{
+				Labels:  []Label{{Name: "__name__", Value: name}},
+				Samples: []Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}},
+			}},
+		})
+		if err != nil {
+			t.Fatalf("Failed to marshal request: %v", err)
+		}
+		w := httptest.NewRecorder()
+		server.handleWrite(w, httptest.NewRequest(http.MethodPost, "/api/v1/write?precision=unchecked", bytes.NewReader(body)))
+		return w
+	}
+
+	if w := write("queued_metric"); w.Code != http.StatusAccepted {
+		t.Fatalf("handleWrite() status = %d, want %d", w.Code, http.StatusAccepted)
+	}
+
+	// Invalid requests are still rejected up front
+	w := write("bad-name")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid write status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	var resp WriteResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Rejected != 2 || resp.Reasons[ReasonInvalidLabels] != 2 {
+		t.Errorf("response = %+v, want 2 samples with invalid labels", resp)
+	}
+
+	// Shutdown writes what is still queued
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	s := series.NewSeries(map[string]string{"__name__": "queued_metric"})
+	if samples, _ := db.Query(s.Hash, 0, 3000); len(samples) != 2 {
+		t.Errorf("queued write stored %d samples, want 2", len(samples))
+	}
+	if w := write("late_metric"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("write after shutdown status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	st := server.writeQueue.status()
+	if st.Enqueued != 1 || st.Depth != 0 || st.Failed != 0 {
+		t.Errorf("queue status = %+v, want 1 request enqueued and written", st)
+	}
+}
+
+func TestWriteQueueOverflow(t *testing.T) {
+	// Without workers the queue stays full after one request
+	for _, policy := range []OverflowPolicy{OverflowReject, OverflowBlock} {
+		q := &writeQueue{overflow: policy, requests: make(chan WriteRequest, 1)}
+		if err := q.push(context.Background(), WriteRequest{}); err != nil {
+			t.Fatalf("%s: first push failed: %v", policy, err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := q.push(ctx, WriteRequest{})
+		if policy == OverflowReject && !errors.Is(err, ErrWriteQueueFull) {
+			t.Errorf("reject: got %v, want ErrWriteQueueFull", err)
+		}
+		if policy == OverflowBlock && !errors.Is(err, context.Canceled) {
+			t.Errorf("block: got %v, want the request's context error", err)
+		}
+		if st := q.status(); st.Rejected != 1 || st.Depth != 1 {
+			t.Errorf("%s: status = %+v, want 1 rejected and 1 queued", policy, st)
+		}
+	}
+}
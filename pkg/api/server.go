@@ -8,10 +8,15 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/observability"
 	"github.com/therealutkarshpriyadarshi/time/pkg/query"
+	"github.com/therealutkarshpriyadarshi/time/pkg/rollup"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
 	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
 )
 
@@ -22,25 +27,105 @@ type Server struct {
 	mux    *http.ServeMux
 	server *http.Server
 	addr   string
+
+	// rollups serves /api/v1/rollups; nil when rollups are not enabled
+	rollups *rollup.Manager
+
+	// limits are the default query limits; requests may only lower them
+	limits query.Limits
+
+	// lookback is the default of the lookback parameter
+	lookback time.Duration
+
+	// watchDone is closed on shutdown to end streaming watches
+	watchDone    chan struct{}
+	shutdownOnce sync.Once
+
+	// backups are kept open for download until deleted or idle for
+	// backupIdle
+	backupsMu  sync.Mutex
+	backups    map[string]*backupSession
+	backupIdle time.Duration
+
+	// audit records admin operations; nil when auditing is disabled
+	audit *AuditLog
+
+	// queryLog records queries; nil when the query log is disabled
+	queryLog *QueryLog
+
+	// writeQueue holds async writes; nil when writes are synchronous
+	writeQueue *writeQueue
+
+	// conns counts open connections against WithMaxConnections
+	conns connLimiter
+
+	// shuttingDown fails readiness checks once Shutdown is called
+	shuttingDown atomic.Bool
+
+	// buildInfo is reported by /api/v1/status/buildinfo
+	buildInfo BuildInfo
+}
+
+// Default timeouts of the HTTP server
+const (
+	DefaultReadTimeout  = 30 * time.Second
+	DefaultWriteTimeout = 30 * time.Second
+	DefaultIdleTimeout  = 120 * time.Second
+)
+
+// statusIndexLabels is the number of label names the status reports the
+// head index memory of
+const statusIndexLabels = 10
+
+// ServerOption configures the HTTP server of NewServer.
+type ServerOption func(*Server)
+
+// WithTimeouts sets how long the server waits to read a request, to write
+// a response, and for the next request on an idle keep-alive connection.
+// The write timeout bounds how long a query may run. 0 disables a timeout.
+func WithTimeouts(read, write, idle time.Duration) ServerOption {
+	return func(s *Server) {
+		s.server.ReadTimeout = read
+		s.server.WriteTimeout = write
+		s.server.IdleTimeout = idle
+	}
+}
+
+// WithMaxConnections caps the connections served at once. Requests on
+// connections past the cap are answered 503 and the connection closed.
+// 0 means unlimited.
+func WithMaxConnections(n int) ServerOption {
+	return func(s *Server) {
+		s.conns.max = int64(n)
+	}
 }
 
 // NewServer creates a new API server.
-func NewServer(db *storage.TSDB, addr string) *Server {
+func NewServer(db *storage.TSDB, addr string, opts ...ServerOption) *Server {
 	s := &Server{
-		db:     db,
-		engine: query.NewQueryEngine(db),
-		mux:    http.NewServeMux(),
-		addr:   addr,
+		db:         db,
+		engine:     query.NewQueryEngine(db),
+		mux:        http.NewServeMux(),
+		addr:       addr,
+		watchDone:  make(chan struct{}),
+		backups:    make(map[string]*backupSession),
+		backupIdle: DefaultBackupIdleTimeout,
+		lookback:   storage.DefaultLatestLookback,
 	}
 
 	s.registerRoutes()
 
 	s.server = &http.Server{
 		Addr:         addr,
-		Handler:      s.mux,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		Handler:      s.limitConnections(s.mux),
+		ReadTimeout:  DefaultReadTimeout,
+		WriteTimeout: DefaultWriteTimeout,
+		IdleTimeout:  DefaultIdleTimeout,
+		ConnContext:  s.conns.connContext,
+		ConnState:    s.conns.connState,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 
 	return s
@@ -59,15 +144,76 @@ func (s *Server) registerRoutes() {
 	s.mux.HandleFunc("/api/v1/labels", s.handleLabels)
 	s.mux.HandleFunc("/api/v1/label/", s.handleLabelValues)
 	s.mux.HandleFunc("/api/v1/series", s.handleSeries)
+	s.mux.HandleFunc("/api/v1/metadata", s.handleMetadata)
+
+	// Streaming endpoints
+	s.mux.HandleFunc("/api/v1/watch", s.handleWatch)
 
 	// Admin endpoints
 	s.mux.HandleFunc("/api/v1/status/tsdb", s.handleStatus)
+	s.mux.HandleFunc("/api/v1/status/buildinfo", s.handleBuildInfo)
+	s.mux.HandleFunc("/api/v1/status/ingest", s.handleIngestStats)
+	s.mux.HandleFunc("/api/v1/status/cardinality", s.handleCardinality)
+	s.mux.HandleFunc("/api/v1/status/wal", s.handleWALStatus)
+	s.mux.HandleFunc("/metrics", s.handleMetrics)
+	s.mux.HandleFunc("/api/v1/admin/flush", s.handleFlush)
+	s.mux.HandleFunc("/api/v1/admin/compact", s.handleCompact)
+	s.mux.HandleFunc("/api/v1/admin/compaction/plan", s.handleCompactionPlan)
+	s.mux.HandleFunc("/api/v1/admin/compaction/pause", s.handlePauseCompaction)
+	s.mux.HandleFunc("/api/v1/admin/compaction/resume", s.handleResumeCompaction)
+	s.mux.HandleFunc("/api/v1/admin/retention/plan", s.handleRetentionPlan)
+	s.mux.HandleFunc("/api/v1/admin/retention/pause", s.handlePauseRetention)
+	s.mux.HandleFunc("/api/v1/admin/retention/resume", s.handleResumeRetention)
+	s.mux.HandleFunc("/api/v1/admin/holds", s.handleHolds)
+	s.mux.HandleFunc(backupsPath, s.handleBackups)
+	s.mux.HandleFunc(backupsPath+"/", s.handleBackup)
+	s.mux.HandleFunc("/api/v1/rollups", s.handleRollups)
+	s.mux.HandleFunc("/api/v1/admin/audit", s.handleAudit)
+	s.mux.HandleFunc("/api/v1/admin/query_log", s.handleQueryLog)
 
 	// Health endpoints
 	s.mux.HandleFunc("/-/healthy", s.handleHealthy)
 	s.mux.HandleFunc("/-/ready", s.handleReady)
 }
 
+// SetRollupManager enables the rollup rule endpoints.
+func (s *Server) SetRollupManager(m *rollup.Manager) {
+	s.rollups = m
+}
+
+// SetQueryLimits sets the default series and sample limits applied to
+// queries.
+func (s *Server) SetQueryLimits(limits query.Limits) {
+	s.limits = limits
+}
+
+// SetQueryLookback sets how far back queries evaluated at a single time
+// look for samples, unless a request's lookback parameter overrides it; 0
+// restores the default, storage.DefaultLatestLookback.
+func (s *Server) SetQueryLookback(d time.Duration) {
+	if d <= 0 {
+		d = storage.DefaultLatestLookback
+	}
+	s.lookback = d
+}
+
+// SetQueryConcurrency sets how many series a query reads in parallel; 0
+// means one per CPU.
+func (s *Server) SetQueryConcurrency(n int) {
+	s.engine.SetConcurrency(n)
+}
+
+// SetQuerySharding splits queries spanning at least minRange into up to
+// shards sub-ranges read concurrently; shards <= 1 disables sharding.
+func (s *Server) SetQuerySharding(shards int, minRange time.Duration) {
+	s.engine.SetSharding(shards, minRange)
+}
+
+// Handler returns the HTTP handler serving the API routes.
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
 // Start starts the HTTP server.
 func (s *Server) Start() error {
 	log.Printf("Starting API server on %s", s.addr)
@@ -77,31 +223,66 @@ func (s *Server) Start() error {
 // Shutdown gracefully shuts down the server.
 func (s *Server) Shutdown(ctx context.Context) error {
 	log.Printf("Shutting down API server")
-	return s.server.Shutdown(ctx)
+	s.shuttingDown.Store(true)
+	// Streaming watches never finish on their own
+	s.shutdownOnce.Do(func() { close(s.watchDone) })
+	// Neither would downloads of large backups; fail them
+	s.closeBackups()
+	if err := s.server.Shutdown(ctx); err != nil {
+		return err
+	}
+	// Write what was accepted before the TSDB is flushed and closed
+	if s.writeQueue != nil {
+		return s.writeQueue.close(ctx)
+	}
+	return nil
 }
 
-// handleWrite handles the Prometheus remote write endpoint.
+// handleWrite handles the Prometheus remote write endpoint. Timestamps
+// are Unix milliseconds unless the precision parameter says otherwise, and
+// implausible ones are rejected unless it is "unchecked".
 func (s *Server) handleWrite(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	precision, err := parsePrecision(r.URL.Query().Get("precision"))
+	if err != nil {
+		s.writeError(w, ErrorBadData, err.Error())
+		return
+	}
+
 	var req WriteRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		s.writeError(w, ErrorBadData, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if err := convertTimestamps(&req, precision); err != nil {
+		s.writeError(w, ErrorBadData, err.Error())
 		return
 	}
 
-	// Insert each time series
-	for _, ts := range req.Timeseries {
-		s, samples := ts.ToSeriesSamples()
-		if err := s.db.Insert(s, samples); err != nil {
-			http.Error(w, fmt.Sprintf("Insert failed: %v", err), http.StatusInternalServerError)
+	// Store metric metadata before samples so types are known up front
+	for _, md := range req.Metadata {
+		if md.MetricFamilyName == "" {
+			s.writeError(w, ErrorBadData, "metadata entry is missing metricFamilyName")
+			return
+		}
+		if err := s.db.SetMetadata(md.MetricFamilyName, md.ToMetricMetadata()); err != nil {
+			s.writeError(w, classifyError(err), fmt.Sprintf("Metadata update failed: %v", err))
 			return
 		}
 	}
 
+	if s.writeQueue != nil {
+		s.enqueueWrite(w, r, req)
+		return
+	}
+	if result := s.write(req); result.errType != "" {
+		s.writeWriteResponse(w, result)
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -116,51 +297,101 @@ func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
 	timeStr := r.URL.Query().Get("time")
 
 	if queryStr == "" {
-		s.writeErrorResponse(w, "query parameter is required", http.StatusBadRequest)
+		s.writeError(w, ErrorBadData, "query parameter is required")
 		return
 	}
 
 	// Parse time parameter (default to now)
 	queryTime := time.Now().UnixMilli()
 	if timeStr != "" {
-		t, err := strconv.ParseInt(timeStr, 10, 64)
+		t, err := parseTime(timeStr)
 		if err != nil {
-			s.writeErrorResponse(w, fmt.Sprintf("Invalid time parameter: %v", err), http.StatusBadRequest)
+			s.writeError(w, ErrorBadData, fmt.Sprintf("Invalid time parameter: %v", err))
 			return
 		}
 		queryTime = t
 	}
 
-	// Parse matchers from query string
-	matchers, err := parseMatchers(queryStr)
+	// Parse matchers, offset and function from query string
+	expr, err := parseQueryExpr(queryStr)
+	if err != nil {
+		s.writeError(w, ErrorBadData, fmt.Sprintf("Invalid query: %v", err))
+		return
+	}
+
+	limits, err := s.parseLimits(r)
+	if err != nil {
+		s.writeError(w, ErrorBadData, err.Error())
+		return
+	}
+
+	withHash, err := parseSeriesHash(r)
+	if err != nil {
+		s.writeError(w, ErrorBadData, err.Error())
+		return
+	}
+
+	lookback, err := s.parseLookback(r)
+	if err != nil {
+		s.writeError(w, ErrorBadData, err.Error())
+		return
+	}
+
+	if latestStr := r.URL.Query().Get("latest"); latestStr != "" {
+		latest, err := strconv.ParseBool(latestStr)
+		if err != nil {
+			s.writeError(w, ErrorBadData, fmt.Sprintf("Invalid latest parameter %q: must be true or false", latestStr))
+			return
+		}
+		if latest {
+			s.handleLatest(w, r, queryStr, expr, queryTime, lookback, limits, withHash)
+			return
+		}
+	}
+
+	blockMatchers, err := parseBlockMatchers(r)
 	if err != nil {
-		s.writeErrorResponse(w, fmt.Sprintf("Invalid query: %v", err), http.StatusBadRequest)
+		s.writeError(w, ErrorBadData, err.Error())
+		return
+	}
+
+	trace, explain, err := parseTrace(r)
+	if err != nil {
+		s.writeError(w, ErrorBadData, err.Error())
 		return
 	}
 
 	// Execute query
 	q := &query.Query{
-		Matchers: matchers,
-		MinTime:  queryTime,
-		MaxTime:  queryTime,
-		Step:     0,
+		Matchers:      expr.matchers,
+		MinTime:       queryTime,
+		MaxTime:       queryTime,
+		Step:          0,
+		Offset:        expr.offset,
+		Lookback:      lookback.Milliseconds(),
+		Limits:        limits,
+		BlockMatchers: blockMatchers,
+		Trace:         trace,
 	}
 
-	results, err := s.engine.ExecQuery(q)
+	started := time.Now()
+	results, err := expr.exec(s.engine, q)
+	s.recordQuery(r, "query", queryStr, q, started, results, err)
 	if err != nil {
-		s.writeErrorResponse(w, fmt.Sprintf("Query failed: %v", err), http.StatusInternalServerError)
+		s.writeError(w, classifyError(err), fmt.Sprintf("Query failed: %v", err))
 		return
 	}
 
 	// Convert to API response format (instant query returns single value per series)
-	queryResults := make([]QueryResult, 0, len(results))
-	for _, result := range results {
-		// For instant query, find the sample closest to queryTime
+	queryResults := make([]QueryResult, 0, len(results.Series))
+	for _, result := range results.Series {
+		// The newest sample within the lookback
 		if len(result.Samples) > 0 {
-			sample := result.Samples[len(result.Samples)-1] // Take latest sample
+			sample := result.Samples[len(result.Samples)-1]
 			queryResults = append(queryResults, QueryResult{
-				Metric: result.Labels,
-				Value:  []interface{}{sample.Timestamp, fmt.Sprintf("%f", sample.Value)},
+				Metric:     result.Labels,
+				SeriesHash: seriesHash(withHash, result.Labels),
+				Value:      []interface{}{sample.Timestamp, fmt.Sprintf("%f", sample.Value)},
 			})
 		}
 	}
@@ -171,11 +402,70 @@ func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
 			ResultType: "vector",
 			Result:     queryResults,
 		},
+		Warnings: results.Warnings,
+		Explain:  explainTrace(trace, explain),
 	}
 
 	s.writeJSONResponse(w, response, http.StatusOK)
 }
 
+// handleLatest serves an instant query with latest=true: the newest
+// sample of each matching series at or before queryTime, and its age,
+// read from the head rather than range-scanning blocks. Samples older
+// than the lookback are stale and their series left out. Only plain
+// selectors are supported.
+func (s *Server) handleLatest(w http.ResponseWriter, r *http.Request, queryStr string, expr *queryExpr,
+	queryTime int64, lookback time.Duration, limits query.Limits, withHash bool) {
+	if expr.function != "" || expr.offset != 0 {
+		s.writeError(w, ErrorBadData, "latest=true only supports series selectors without functions or offset")
+		return
+	}
+
+	q := &query.Query{Matchers: expr.matchers, MinTime: queryTime, MaxTime: queryTime, Lookback: lookback.Milliseconds(), Limits: limits}
+	started := time.Now()
+	latest, err := s.db.Latest(expr.matchers, queryTime, lookback)
+
+	var warnings []string
+	if err == nil && limits.MaxSeries > 0 && len(latest) > limits.MaxSeries {
+		if !limits.Truncate {
+			err = fmt.Errorf("%w: more than %d series matched", query.ErrTooManySeries, limits.MaxSeries)
+		} else {
+			latest = latest[:limits.MaxSeries]
+			warnings = append(warnings, fmt.Sprintf("result truncated to %d matching series", limits.MaxSeries))
+		}
+	}
+
+	var logged *query.QueryResult
+	if err == nil {
+		logged = &query.QueryResult{Series: make([]query.TimeSeries, len(latest))}
+		for i, l := range latest {
+			logged.Series[i] = query.TimeSeries{Labels: l.Series.Labels, Samples: []series.Sample{l.Sample}}
+		}
+	}
+	s.recordQuery(r, "query", queryStr, q, started, logged, err)
+	if err != nil {
+		s.writeError(w, classifyError(err), fmt.Sprintf("Query failed: %v", err))
+		return
+	}
+
+	results := make([]QueryResult, 0, len(latest))
+	for _, l := range latest {
+		age := queryTime - l.Sample.Timestamp
+		results = append(results, QueryResult{
+			Metric:     l.Series.Labels,
+			SeriesHash: seriesHash(withHash, l.Series.Labels),
+			Value:      []interface{}{l.Sample.Timestamp, fmt.Sprintf("%f", l.Sample.Value)},
+			Age:        &age,
+		})
+	}
+
+	s.writeJSONResponse(w, QueryResponse{
+		Status:   "success",
+		Data:     &QueryData{ResultType: "vector", Result: results},
+		Warnings: warnings,
+	}, http.StatusOK)
+}
+
 // handleQueryRange handles range query requests.
 func (s *Server) handleQueryRange(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -189,62 +479,109 @@ func (s *Server) handleQueryRange(w http.ResponseWriter, r *http.Request) {
 	stepStr := r.URL.Query().Get("step")
 
 	if queryStr == "" || startStr == "" || endStr == "" {
-		s.writeErrorResponse(w, "query, start, and end parameters are required", http.StatusBadRequest)
+		s.writeError(w, ErrorBadData, "query, start, and end parameters are required")
 		return
 	}
 
-	start, err := strconv.ParseInt(startStr, 10, 64)
+	start, err := parseTime(startStr)
 	if err != nil {
-		s.writeErrorResponse(w, fmt.Sprintf("Invalid start parameter: %v", err), http.StatusBadRequest)
+		s.writeError(w, ErrorBadData, fmt.Sprintf("Invalid start parameter: %v", err))
 		return
 	}
 
-	end, err := strconv.ParseInt(endStr, 10, 64)
+	end, err := parseTime(endStr)
 	if err != nil {
-		s.writeErrorResponse(w, fmt.Sprintf("Invalid end parameter: %v", err), http.StatusBadRequest)
+		s.writeError(w, ErrorBadData, fmt.Sprintf("Invalid end parameter: %v", err))
 		return
 	}
 
 	step := int64(60000) // Default 1 minute
 	if stepStr != "" {
-		step, err = strconv.ParseInt(stepStr, 10, 64)
+		step, err = parseDuration(stepStr)
 		if err != nil {
-			s.writeErrorResponse(w, fmt.Sprintf("Invalid step parameter: %v", err), http.StatusBadRequest)
+			s.writeError(w, ErrorBadData, fmt.Sprintf("Invalid step parameter: %v", err))
 			return
 		}
+		if step <= 0 {
+			s.writeError(w, ErrorBadData, "Invalid step parameter: step must be positive")
+			return
+		}
+	}
+
+	if end < start {
+		s.writeError(w, ErrorBadData, "end must not be before start")
+		return
+	}
+
+	// Parse matchers, offset and function from query string
+	expr, err := parseQueryExpr(queryStr)
+	if err != nil {
+		s.writeError(w, ErrorBadData, fmt.Sprintf("Invalid query: %v", err))
+		return
+	}
+
+	limits, err := s.parseLimits(r)
+	if err != nil {
+		s.writeError(w, ErrorBadData, err.Error())
+		return
+	}
+
+	blockMatchers, err := parseBlockMatchers(r)
+	if err != nil {
+		s.writeError(w, ErrorBadData, err.Error())
+		return
+	}
+
+	withHash, err := parseSeriesHash(r)
+	if err != nil {
+		s.writeError(w, ErrorBadData, err.Error())
+		return
+	}
+
+	lookback, err := s.parseLookback(r)
+	if err != nil {
+		s.writeError(w, ErrorBadData, err.Error())
+		return
 	}
 
-	// Parse matchers from query string
-	matchers, err := parseMatchers(queryStr)
+	trace, explain, err := parseTrace(r)
 	if err != nil {
-		s.writeErrorResponse(w, fmt.Sprintf("Invalid query: %v", err), http.StatusBadRequest)
+		s.writeError(w, ErrorBadData, err.Error())
 		return
 	}
 
 	// Execute query
 	q := &query.Query{
-		Matchers: matchers,
-		MinTime:  start,
-		MaxTime:  end,
-		Step:     step,
+		Matchers:      expr.matchers,
+		MinTime:       start,
+		MaxTime:       end,
+		Step:          step,
+		Offset:        expr.offset,
+		Lookback:      lookback.Milliseconds(),
+		Limits:        limits,
+		BlockMatchers: blockMatchers,
+		Trace:         trace,
 	}
 
-	results, err := s.engine.ExecQuery(q)
+	started := time.Now()
+	results, err := expr.exec(s.engine, q)
+	s.recordQuery(r, "query_range", queryStr, q, started, results, err)
 	if err != nil {
-		s.writeErrorResponse(w, fmt.Sprintf("Query failed: %v", err), http.StatusInternalServerError)
+		s.writeError(w, classifyError(err), fmt.Sprintf("Query failed: %v", err))
 		return
 	}
 
 	// Convert to API response format
-	queryResults := make([]QueryResult, 0, len(results))
-	for _, result := range results {
+	queryResults := make([]QueryResult, 0, len(results.Series))
+	for _, result := range results.Series {
 		values := make([][]interface{}, 0, len(result.Samples))
 		for _, sample := range result.Samples {
 			values = append(values, []interface{}{sample.Timestamp, fmt.Sprintf("%f", sample.Value)})
 		}
 		queryResults = append(queryResults, QueryResult{
-			Metric: result.Labels,
-			Values: values,
+			Metric:     result.Labels,
+			SeriesHash: seriesHash(withHash, result.Labels),
+			Values:     values,
 		})
 	}
 
@@ -254,33 +591,50 @@ func (s *Server) handleQueryRange(w http.ResponseWriter, r *http.Request) {
 			ResultType: "matrix",
 			Result:     queryResults,
 		},
+		Warnings: results.Warnings,
+		Explain:  explainTrace(trace, explain),
 	}
 
 	s.writeJSONResponse(w, response, http.StatusOK)
 }
 
-// handleLabels returns all label names.
+// handleLabels returns all label names, or those of the series matching
+// any of the optional match[] selectors.
 func (s *Server) handleLabels(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	labels, err := s.db.GetAllLabels()
+	opts, err := parseListOptions(r)
+	if err != nil {
+		s.writeError(w, ErrorBadData, err.Error())
+		return
+	}
+
+	matcherSets, err := parseMatcherSets(r)
+	if err != nil {
+		s.writeError(w, ErrorBadData, err.Error())
+		return
+	}
+
+	labels, next, err := s.db.ListLabelNames(opts, matcherSets...)
 	if err != nil {
-		s.writeErrorResponse(w, fmt.Sprintf("Failed to get labels: %v", err), http.StatusInternalServerError)
+		s.writeError(w, classifyError(err), fmt.Sprintf("Failed to get labels: %v", err))
 		return
 	}
 
 	response := LabelsResponse{
-		Status: "success",
-		Data:   labels,
+		Status:    "success",
+		Data:      labels,
+		NextToken: next,
 	}
 
 	s.writeJSONResponse(w, response, http.StatusOK)
 }
 
-// handleLabelValues returns all values for a specific label.
+// handleLabelValues returns all values for a specific label, or those
+// among the series matching any of the optional match[] selectors.
 func (s *Server) handleLabelValues(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -293,19 +647,32 @@ func (s *Server) handleLabelValues(w http.ResponseWriter, r *http.Request) {
 	labelName := strings.TrimSuffix(path, "/values")
 
 	if labelName == "" {
-		s.writeErrorResponse(w, "label name is required", http.StatusBadRequest)
+		s.writeError(w, ErrorBadData, "label name is required")
+		return
+	}
+
+	opts, err := parseListOptions(r)
+	if err != nil {
+		s.writeError(w, ErrorBadData, err.Error())
 		return
 	}
 
-	values, err := s.db.GetLabelValues(labelName)
+	matcherSets, err := parseMatcherSets(r)
 	if err != nil {
-		s.writeErrorResponse(w, fmt.Sprintf("Failed to get label values: %v", err), http.StatusInternalServerError)
+		s.writeError(w, ErrorBadData, err.Error())
+		return
+	}
+
+	values, next, err := s.db.ListLabelValues(labelName, opts, matcherSets...)
+	if err != nil {
+		s.writeError(w, classifyError(err), fmt.Sprintf("Failed to get label values: %v", err))
 		return
 	}
 
 	response := LabelValuesResponse{
-		Status: "success",
-		Data:   values,
+		Status:    "success",
+		Data:      values,
+		NextToken: next,
 	}
 
 	s.writeJSONResponse(w, response, http.StatusOK)
@@ -318,35 +685,79 @@ func (s *Server) handleSeries(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get match[] parameters
-	matches := r.URL.Query()["match[]"]
-	if len(matches) == 0 {
-		s.writeErrorResponse(w, "at least one match[] parameter is required", http.StatusBadRequest)
+	if len(r.URL.Query()["match[]"]) == 0 {
+		s.writeError(w, ErrorBadData, "at least one match[] parameter is required")
 		return
 	}
 
-	allSeries := make([]map[string]string, 0)
+	opts, err := parseListOptions(r)
+	if err != nil {
+		s.writeError(w, ErrorBadData, err.Error())
+		return
+	}
 
-	// For each matcher, get matching series
-	for _, match := range matches {
-		matchers, err := parseMatchers(match)
-		if err != nil {
-			s.writeErrorResponse(w, fmt.Sprintf("Invalid matcher: %v", err), http.StatusBadRequest)
-			return
-		}
+	// max_series caps the union of all match[] selectors
+	limits, err := s.parseLimits(r)
+	if err != nil {
+		s.writeError(w, ErrorBadData, err.Error())
+		return
+	}
+	opts.MaxSeries = limits.MaxSeries
 
-		series, err := s.db.GetSeries(matchers)
-		if err != nil {
-			s.writeErrorResponse(w, fmt.Sprintf("Failed to get series: %v", err), http.StatusInternalServerError)
+	matcherSets, err := parseMatcherSets(r)
+	if err != nil {
+		s.writeError(w, ErrorBadData, err.Error())
+		return
+	}
+
+	// Series matching several match[] selectors are returned once
+	allSeries, next, err := s.db.ListSeries(opts, matcherSets...)
+	if err != nil {
+		s.writeError(w, classifyError(err), fmt.Sprintf("Failed to get series: %v", err))
+		return
+	}
+
+	response := SeriesResponse{
+		Status:    "success",
+		Data:      allSeries,
+		NextToken: next,
+	}
+
+	s.writeJSONResponse(w, response, http.StatusOK)
+}
+
+// handleMetadata returns metadata (type, help, unit) for metrics.
+// Supports the optional "metric" and "limit" query parameters.
+func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l < 0 {
+			s.writeError(w, ErrorBadData, fmt.Sprintf("Invalid limit parameter: %q", limitStr))
 			return
 		}
+		limit = l
+	}
 
-		allSeries = append(allSeries, series...)
+	data := make(map[string][]MetadataEntry)
+	if metric := r.URL.Query().Get("metric"); metric != "" {
+		if md, ok := s.db.GetMetadata(metric); ok {
+			data[metric] = []MetadataEntry{newMetadataEntry(md)}
+		}
+	} else {
+		for name, md := range s.db.ListMetadata(limit) {
+			data[name] = []MetadataEntry{newMetadataEntry(md)}
+		}
 	}
 
-	response := SeriesResponse{
+	response := MetadataResponse{
 		Status: "success",
-		Data:   allSeries,
+		Data:   data,
 	}
 
 	s.writeJSONResponse(w, response, http.StatusOK)
@@ -360,6 +771,7 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	stats := s.db.GetStatsSnapshot()
+	minTime, _ := s.db.MinTime()
 
 	response := StatusResponse{
 		Status: "success",
@@ -370,26 +782,546 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 			LastFlushTime:      stats.LastFlushTime,
 			WALSize:            stats.WALSize,
 			ActiveMemTableSize: stats.ActiveMemTableSize,
+			MinTime:            minTime,
+			PurgedBefore:       s.db.PurgedBefore(),
+			QuarantinedBlocks:  stats.QuarantinedBlocks,
+			AdoptedBlocks:      stats.AdoptedBlocks,
+			CorruptBlockReads:  stats.CorruptBlockReads,
+
+			DuplicatesOverwritten: stats.DuplicatesOverwritten,
+			DuplicatesDropped:     stats.DuplicatesDropped,
+			DuplicatesRejected:    stats.DuplicatesRejected,
+			OutOfOrderSamples:     stats.OutOfOrderSamples,
+			OutOfOrderRejected:    stats.OutOfOrderRejected,
+
+			RateLimitedDropped:    stats.RateLimitedDropped,
+			RateLimitedAggregated: stats.RateLimitedAggregated,
+
+			LabelLimitRejected: stats.LabelLimitRejected,
+
+			TooOldRejected: stats.TooOldRejected,
+			TooNewRejected: stats.TooNewRejected,
+
+			RelabelDropped: stats.RelabelDropped,
+			HashCollisions: stats.HashCollisions,
+
+			SpilledSamples: stats.SpilledSamples,
+			SpillPending:   stats.SpillPending,
+
+			DiskFreeBytes:          stats.DiskFreeBytes,
+			DiskTotalBytes:         stats.DiskTotalBytes,
+			EmergencyBlocksDeleted: stats.EmergencyBlocksDeleted,
+			ReadOnly:               stats.ReadOnly,
+
+			CompactionPaused: stats.CompactionPaused,
+			RetentionPaused:  stats.RetentionPaused,
+
+			BackgroundIOBytes:       stats.BackgroundIOBytes,
+			BackgroundIOWaitSeconds: stats.BackgroundIOWait.Seconds(),
+
+			FlushDuration:      newDurationSummary(stats.FlushDuration),
+			WALSyncDuration:    newDurationSummary(stats.WALSyncDuration),
+			CompactionDuration: newDurationSummary(stats.CompactionDuration),
+
+			Replay: newReplayStatus(stats.Replay),
+
+			SeriesChurn: newSeriesChurnStatus(stats.SeriesChurn),
+
+			IndexLabels: newLabelMemoryStatus(s.db.LargestLabels(statusIndexLabels)),
 		},
 	}
+	if s.writeQueue != nil {
+		response.Data.WriteQueue = s.writeQueue.status()
+	}
 
 	s.writeJSONResponse(w, response, http.StatusOK)
 }
 
-// handleHealthy returns 200 if the server is healthy.
+// handleWALStatus lists the WAL segments with their sizes and entry time
+// ranges, the last fsync and an estimate of the time to replay the WAL.
+func (s *Server) handleWALStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats, err := s.db.WALStats()
+	if err != nil {
+		s.writeError(w, classifyError(err), fmt.Sprintf("Failed to read WAL statistics: %v", err))
+		return
+	}
+	s.writeJSONResponse(w, WALStatusResponse{
+		Status: "success",
+		Data:   newWALStatusData(stats),
+	}, http.StatusOK)
+}
+
+// handleCardinality breaks the series matching the optional match
+// selector down by the values of their labels, from the indexes alone.
+// start and end bound the series like /api/v1/series, and limit caps the
+// values listed per label (default 10, 0 for all).
+func (s *Server) handleCardinality(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	opts, err := parseListOptions(r)
+	if err != nil {
+		s.writeError(w, ErrorBadData, err.Error())
+		return
+	}
+	if r.URL.Query().Get("limit") == "" {
+		opts.Limit = storage.DefaultCardinalityValues
+	}
+
+	var matchers index.Matchers
+	if match := r.URL.Query().Get("match"); match != "" {
+		if matchers, err = parseMatchers(match); err != nil {
+			s.writeError(w, ErrorBadData, fmt.Sprintf("Invalid matcher: %v", err))
+			return
+		}
+	}
+
+	report, err := s.db.Cardinality(matchers, opts)
+	if err != nil {
+		s.writeError(w, classifyError(err), fmt.Sprintf("Failed to compute cardinality: %v", err))
+		return
+	}
+	labels := make([]LabelCardinality, len(report.Labels))
+	for i, label := range report.Labels {
+		values := make([]LabelValueCount, len(label.Values))
+		for j, v := range label.Values {
+			values[j] = LabelValueCount{Value: v.Value, Series: v.Series}
+		}
+		labels[i] = LabelCardinality{
+			Name:           label.Name,
+			Series:         label.Series,
+			DistinctValues: label.DistinctValues,
+			Values:         values,
+		}
+	}
+	s.writeJSONResponse(w, CardinalityResponse{
+		Status: "success",
+		Data: &CardinalityData{
+			Series:  report.Series,
+			Samples: report.Samples,
+			Labels:  labels,
+		},
+	}, http.StatusOK)
+}
+
+// handleIngestStats ranks the metric names and tenants writing the most.
+// sort orders them by samples (the default), rejected or series, and
+// limit caps how many are returned (default 100).
+func (s *Server) handleIngestStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := defaultAuditLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			s.writeError(w, ErrorBadData, fmt.Sprintf("invalid limit %q: must be a positive integer", limitStr))
+			return
+		}
+		limit = n
+	}
+	sort := storage.IngestSortSamples
+	if sortStr := r.URL.Query().Get("sort"); sortStr != "" {
+		sort = storage.IngestSort(sortStr)
+	}
+
+	report, err := s.db.IngestStats(limit, sort)
+	if err != nil {
+		s.writeError(w, classifyError(err), err.Error())
+		return
+	}
+	writers := make([]IngestWriterStats, len(report.Writers))
+	for i, stats := range report.Writers {
+		writers[i] = IngestWriterStats{
+			Metric:   stats.Metric,
+			Tenant:   stats.Tenant,
+			Accepted: stats.Accepted,
+			Rejected: stats.Rejected,
+			Series:   stats.Series,
+			Error:    stats.Error,
+		}
+	}
+	s.writeJSONResponse(w, IngestStatsResponse{
+		Status: "success",
+		Data: &IngestStatsData{
+			Tracked:  report.Tracked,
+			Capacity: report.Capacity,
+			Writers:  writers,
+		},
+	}, http.StatusOK)
+}
+
+// handleMetrics exposes the TSDB's operational metrics in the Prometheus
+// text format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Refresh gauges that are only tracked in the TSDB's own stats
+	metrics := s.db.Metrics()
+	stats := s.db.GetStatsSnapshot()
+	metrics.SetWALSize(stats.WALSize)
+	metrics.SetHeadSeries(stats.TotalSeries)
+	metrics.SetHeadSize(stats.ActiveMemTableSize)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := observability.WritePrometheusMetrics(w, metrics); err != nil {
+		log.Printf("Error writing metrics: %v", err)
+	}
+	if s.writeQueue != nil {
+		s.writeQueue.writeMetrics(w)
+	}
+}
+
+// handleCompactionPlan returns the merges the next compaction cycle would
+// perform, without running them.
+func (s *Server) handleCompactionPlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	plan, err := s.db.CompactionPlan()
+	if err != nil {
+		s.writeError(w, classifyError(err), err.Error())
+		return
+	}
+
+	data := &CompactionPlanData{Merges: make([]PlannedMerge, 0, len(plan.Merges))}
+	for _, merge := range plan.Merges {
+		planned := PlannedMerge{
+			FromLevel:    int(merge.FromLevel),
+			ToLevel:      int(merge.ToLevel),
+			Vertical:     merge.Vertical,
+			MinTime:      merge.MinTime,
+			MaxTime:      merge.MaxTime,
+			Sources:      merge.Sources,
+			SourceBlocks: newBlockSummaries(merge.SourceBlocks),
+			Outputs:      make([]PlannedBlock, 0, len(merge.Outputs)),
+		}
+		for _, output := range merge.Outputs {
+			planned.Outputs = append(planned.Outputs, PlannedBlock{
+				NumSeries:      output.NumSeries,
+				EstimatedBytes: output.EstimatedBytes,
+			})
+		}
+		data.Merges = append(data.Merges, planned)
+	}
+
+	s.writeJSONResponse(w, CompactionPlanResponse{Status: "success", Data: data}, http.StatusOK)
+}
+
+// handleRetentionPlan returns the blocks the next retention cleanup would
+// delete, without deleting them.
+func (s *Server) handleRetentionPlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report, err := s.db.RetentionPlan()
+	if err != nil {
+		s.writeError(w, classifyError(err), err.Error())
+		return
+	}
+
+	data := &RetentionPlanData{
+		Enabled:          report.PolicyEnabled,
+		MaxAgeSeconds:    report.PolicyMaxAge.Seconds(),
+		Cutoff:           report.CutoffTime,
+		TotalBlocks:      report.TotalBlocks,
+		TotalBytes:       report.TotalDataSize,
+		ReclaimableBytes: report.ReclaimableSize,
+		Blocks:           newBlockSummaries(report.EligibleBlocks),
+		Budgets:          newBudgetUsages(report.Budgets),
+	}
+	s.writeJSONResponse(w, RetentionPlanResponse{Status: "success", Data: data}, http.StatusOK)
+}
+
+// handleFlush flushes the in-memory head to a block and responds once the
+// flush has completed.
+func (s *Server) handleFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := s.db.TriggerFlush(r.Context())
+	s.recordAudit(r, "flush", nil, err)
+	if err != nil {
+		s.writeError(w, classifyError(err), err.Error())
+		return
+	}
+	s.writeJSONResponse(w, AdminResponse{Status: "success"}, http.StatusOK)
+}
+
+// handleCompact runs a compaction cycle and responds once it has
+// completed.
+func (s *Server) handleCompact(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := s.db.TriggerCompaction(r.Context())
+	s.recordAudit(r, "compact", nil, err)
+	if err != nil {
+		s.writeError(w, classifyError(err), err.Error())
+		return
+	}
+	s.writeJSONResponse(w, AdminResponse{Status: "success"}, http.StatusOK)
+}
+
+// handlePauseCompaction pauses background compaction.
+func (s *Server) handlePauseCompaction(w http.ResponseWriter, r *http.Request) {
+	s.runAdminAction(w, r, "pause_compaction", s.db.PauseCompaction)
+}
+
+// handleResumeCompaction resumes background compaction.
+func (s *Server) handleResumeCompaction(w http.ResponseWriter, r *http.Request) {
+	s.runAdminAction(w, r, "resume_compaction", s.db.ResumeCompaction)
+}
+
+// handlePauseRetention pauses background retention cleanups.
+func (s *Server) handlePauseRetention(w http.ResponseWriter, r *http.Request) {
+	s.runAdminAction(w, r, "pause_retention", s.db.PauseRetention)
+}
+
+// handleResumeRetention resumes background retention cleanups.
+func (s *Server) handleResumeRetention(w http.ResponseWriter, r *http.Request) {
+	s.runAdminAction(w, r, "resume_retention", s.db.ResumeRetention)
+}
+
+// runAdminAction runs a POST-only admin action that returns no data,
+// recording it in the audit log as name.
+func (s *Server) runAdminAction(w http.ResponseWriter, r *http.Request, name string, action func() error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := action()
+	s.recordAudit(r, name, nil, err)
+	if err != nil {
+		s.writeError(w, classifyError(err), err.Error())
+		return
+	}
+	s.writeJSONResponse(w, AdminResponse{Status: "success"}, http.StatusOK)
+}
+
+// handleHolds lists (GET), places (POST) and lifts (DELETE) holds that
+// protect blocks from retention and compaction. POST and DELETE select
+// blocks with repeated block=<ULID> parameters and/or a start/end range;
+// POST also takes a reason.
+func (s *Server) handleHolds(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		holds, err := s.db.BlockHolds()
+		if err != nil {
+			s.writeError(w, classifyError(err), err.Error())
+			return
+		}
+
+		data := make([]BlockHold, 0, len(holds))
+		for _, hold := range holds {
+			data = append(data, BlockHold{
+				Block:     hold.Block,
+				MinTime:   hold.MinTime,
+				MaxTime:   hold.MaxTime,
+				Reason:    hold.Reason,
+				CreatedAt: hold.CreatedAt,
+			})
+		}
+		s.writeJSONResponse(w, HoldsResponse{Status: "success", Data: data}, http.StatusOK)
+
+	case http.MethodPost, http.MethodDelete:
+		if err := r.ParseForm(); err != nil {
+			s.writeError(w, ErrorBadData, fmt.Sprintf("invalid parameters: %v", err))
+			return
+		}
+
+		sel := storage.BlockSelector{ULIDs: r.Form["block"]}
+		var err error
+		if startStr := r.Form.Get("start"); startStr != "" {
+			if sel.MinTime, err = parseTime(startStr); err != nil {
+				s.writeError(w, ErrorBadData, fmt.Sprintf("invalid start: %v", err))
+				return
+			}
+		}
+		if endStr := r.Form.Get("end"); endStr != "" {
+			if sel.MaxTime, err = parseTime(endStr); err != nil {
+				s.writeError(w, ErrorBadData, fmt.Sprintf("invalid end: %v", err))
+				return
+			}
+		}
+
+		var ids []string
+		action := "add_hold"
+		if r.Method == http.MethodPost {
+			ids, err = s.db.ProtectBlocks(sel, r.Form.Get("reason"))
+		} else {
+			action = "remove_hold"
+			ids, err = s.db.UnprotectBlocks(sel)
+		}
+		s.recordAudit(r, action, map[string]string{
+			"block":  strings.Join(sel.ULIDs, ","),
+			"start":  r.Form.Get("start"),
+			"end":    r.Form.Get("end"),
+			"reason": r.Form.Get("reason"),
+		}, err)
+		if err != nil {
+			s.writeError(w, classifyError(err), err.Error())
+			return
+		}
+		if ids == nil {
+			ids = []string{}
+		}
+		s.writeJSONResponse(w, HoldChangeResponse{Status: "success", Data: ids}, http.StatusOK)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRollups lists (GET), adds (POST, JSON rule body) and removes
+// (DELETE, name parameter) rollup rules.
+func (s *Server) handleRollups(w http.ResponseWriter, r *http.Request) {
+	if s.rollups == nil {
+		s.writeError(w, ErrorUnavailable, "rollups are not enabled")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rules := s.rollups.Rules()
+		data := make([]RollupRule, 0, len(rules))
+		for _, rule := range rules {
+			var lastEvaluation int64
+			if !rule.LastEvaluation.IsZero() {
+				lastEvaluation = rule.LastEvaluation.UnixMilli()
+			}
+			data = append(data, RollupRule{
+				Rule:           rule.Rule,
+				NextWindow:     rule.NextWindow,
+				LastEvaluation: lastEvaluation,
+				LastError:      rule.LastError,
+				SamplesWritten: rule.SamplesWritten,
+			})
+		}
+		s.writeJSONResponse(w, RollupsResponse{Status: "success", Data: data}, http.StatusOK)
+
+	case http.MethodPost:
+		var rule rollup.Rule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			s.writeError(w, ErrorBadData, fmt.Sprintf("invalid request body: %v", err))
+			return
+		}
+		err := s.rollups.AddRule(rule)
+		s.recordAudit(r, "add_rollup", map[string]string{
+			"name":     rule.Name,
+			"source":   rule.Source,
+			"function": string(rule.Function),
+			"interval": rule.Interval,
+		}, err)
+		if err != nil {
+			s.writeError(w, classifyError(err), err.Error())
+			return
+		}
+		s.writeJSONResponse(w, RollupsResponse{Status: "success", Data: []RollupRule{{Rule: rule}}}, http.StatusOK)
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			s.writeError(w, ErrorBadData, "missing name parameter")
+			return
+		}
+		err := s.rollups.RemoveRule(name)
+		s.recordAudit(r, "remove_rollup", map[string]string{"name": name}, err)
+		if err != nil {
+			s.writeError(w, classifyError(err), err.Error())
+			return
+		}
+		s.writeJSONResponse(w, RollupsResponse{Status: "success", Data: []RollupRule{}}, http.StatusOK)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleHealthy returns 200 while the TSDB works, if degraded: a read-only
+// TSDB is still healthy, since restarting it frees no disk space. It
+// returns 503 once flushes or WAL appends keep failing, or the TSDB is
+// closed.
 func (s *Server) handleHealthy(w http.ResponseWriter, r *http.Request) {
-	response := HealthResponse{
-		Status:  "healthy",
-		Message: "TSDB is operational",
+	health := s.db.Health()
+	response := newHealthResponse(health)
+	switch health.Status {
+	case storage.HealthOK:
+		response.Message = "TSDB is operational"
+	case storage.HealthReadOnly:
+		response.Message = "TSDB is read-only: free disk space is below the minimum"
+	case storage.HealthFailing:
+		response.Message = "TSDB storage is failing"
+	case storage.HealthClosed:
+		response.Message = "TSDB is closed"
 	}
-	s.writeJSONResponse(w, response, http.StatusOK)
+	s.writeHealth(w, response, health.Status != storage.HealthFailing && health.Status != storage.HealthClosed)
 }
 
-// handleReady returns 200 if the server is ready to accept requests.
+// handleReady returns 200 if the server is ready to serve requests: the
+// TSDB is open and working, if read-only, and the server isn't shutting
+// down. A read-only TSDB still serves queries.
 func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
-	response := HealthResponse{
-		Status:  "ready",
-		Message: "TSDB is ready to serve requests",
+	health := s.db.Health()
+	response := newHealthResponse(health)
+	switch {
+	case s.shuttingDown.Load():
+		response.Status, response.Message = "shutting_down", "TSDB is shutting down"
+		s.writeHealth(w, response, false)
+		return
+	case health.Status == storage.HealthOK:
+		response.Status, response.Message = "ready", "TSDB is ready to serve requests"
+	case health.Status == storage.HealthReadOnly:
+		response.Message = "TSDB is serving queries but refusing writes: free disk space is below the minimum"
+	case health.Status == storage.HealthFailing:
+		response.Message = "TSDB storage is failing"
+	case health.Status == storage.HealthClosed:
+		response.Message = "TSDB is closed"
+	}
+	s.writeHealth(w, response, health.Status != storage.HealthFailing && health.Status != storage.HealthClosed)
+}
+
+// newHealthResponse returns the response to a health check of a TSDB in
+// health, without a message
+func newHealthResponse(health storage.Health) HealthResponse {
+	return HealthResponse{
+		Status:         string(health.Status),
+		LastFlush:      health.LastFlushTime,
+		FlushFailures:  health.FlushFailures,
+		LastFlushError: health.LastFlushError,
+		WALFailures:    health.WALFailures,
+		LastWALError:   health.LastWALError,
+	}
+}
+
+// writeHealth writes a health check response, 200 if ok and 503 otherwise
+func (s *Server) writeHealth(w http.ResponseWriter, response HealthResponse, ok bool) {
+	w.Header().Set("Cache-Control", "no-store")
+	if !ok {
+		setErrorHeaders(w, ErrorUnavailable)
+		s.writeJSONResponse(w, response, http.StatusServiceUnavailable)
+		return
 	}
 	s.writeJSONResponse(w, response, http.StatusOK)
 }
@@ -403,76 +1335,213 @@ func (s *Server) writeJSONResponse(w http.ResponseWriter, data interface{}, stat
 	}
 }
 
-// writeErrorResponse writes an error response.
-func (s *Server) writeErrorResponse(w http.ResponseWriter, errMsg string, statusCode int) {
-	response := QueryResponse{
-		Status: "error",
-		Error:  errMsg,
+// parseLimits parses the max_series, max_samples and limit_mode parameters
+// and merges them into the server's default query limits
+func (s *Server) parseLimits(r *http.Request) (query.Limits, error) {
+	params := r.URL.Query()
+	requested := query.Limits{Truncate: s.limits.Truncate}
+
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{
+		{"max_series", &requested.MaxSeries},
+		{"max_samples", &requested.MaxSamples},
+	} {
+		if v := params.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return query.Limits{}, fmt.Errorf("Invalid %s parameter: must be a non-negative integer", p.name)
+			}
+			*p.dst = n
+		}
 	}
-	s.writeJSONResponse(w, response, statusCode)
+
+	switch mode := params.Get("limit_mode"); mode {
+	case "":
+	case "error":
+		requested.Truncate = false
+	case "truncate":
+		requested.Truncate = true
+	default:
+		return query.Limits{}, fmt.Errorf("Invalid limit_mode parameter %q: must be error or truncate", mode)
+	}
+
+	return s.limits.Merge(requested), nil
+}
+
+// parseListOptions parses the limit, start, end and token parameters shared
+// by the label and series endpoints
+func parseListOptions(r *http.Request) (storage.ListOptions, error) {
+	var opts storage.ListOptions
+	params := r.URL.Query()
+
+	if limitStr := params.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 0 {
+			return opts, fmt.Errorf("Invalid limit parameter: %q", limitStr)
+		}
+		opts.Limit = limit
+	}
+
+	if startStr := params.Get("start"); startStr != "" {
+		start, err := parseTime(startStr)
+		if err != nil {
+			return opts, fmt.Errorf("Invalid start parameter: %v", err)
+		}
+		opts.MinTime = start
+	}
+
+	if endStr := params.Get("end"); endStr != "" {
+		end, err := parseTime(endStr)
+		if err != nil {
+			return opts, fmt.Errorf("Invalid end parameter: %v", err)
+		}
+		opts.MaxTime = end
+	}
+
+	if opts.MaxTime != 0 && opts.MinTime > opts.MaxTime {
+		return opts, fmt.Errorf("start must not be after end")
+	}
+
+	opts.Token = params.Get("token")
+	return opts, nil
+}
+
+// parseMatcherSets parses the match[] selectors of the label and series
+// endpoints, one matcher set each
+func parseMatcherSets(r *http.Request) ([]index.Matchers, error) {
+	matches := r.URL.Query()["match[]"]
+	matcherSets := make([]index.Matchers, 0, len(matches))
+	for _, match := range matches {
+		matchers, err := parseMatchers(match)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid matcher: %v", err)
+		}
+		matcherSets = append(matcherSets, matchers)
+	}
+	return matcherSets, nil
 }
 
 // parseMatchers parses a query string into label matchers.
 // Example: {__name__="cpu_usage",host="server1"}
-// This is a simplified parser for the basic format.
 func parseMatchers(queryStr string) (index.Matchers, error) {
+	return index.ParseMatchers(queryStr)
+}
+
+// parseSelector parses a query string into label matchers and the offset,
+// in milliseconds, of an optional trailing offset modifier.
+// Example: {__name__="cpu_usage",host="server1"} offset 1w
+func parseSelector(queryStr string) (index.Matchers, int64, error) {
 	queryStr = strings.TrimSpace(queryStr)
+	end := strings.LastIndex(queryStr, "}")
+	if end < 0 {
+		matchers, err := parseMatchers(queryStr)
+		return matchers, 0, err
+	}
+
+	matchers, err := parseMatchers(queryStr[:end+1])
+	if err != nil {
+		return nil, 0, err
+	}
+	modifier := strings.TrimSpace(queryStr[end+1:])
+	if modifier == "" {
+		return matchers, 0, nil
+	}
+
+	fields := strings.Fields(modifier)
+	if len(fields) != 2 || fields[0] != "offset" {
+		return nil, 0, fmt.Errorf("unexpected %q after selector (want offset <duration>)", modifier)
+	}
+	offset, err := parseDuration(fields[1])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid offset: %w", err)
+	}
+	if offset < 0 {
+		return nil, 0, fmt.Errorf("offset must not be negative")
+	}
+	return matchers, offset, nil
+}
 
-	// Simple parsing: expect format {label="value",label2="value2"}
-	if !strings.HasPrefix(queryStr, "{") || !strings.HasSuffix(queryStr, "}") {
-		return nil, fmt.Errorf("query must be in format {label=\"value\",...}")
+// parseBlockMatchers parses the optional block_match parameter, which
+// restricts a query to blocks whose labels match, e.g. {tenant="a"}
+func parseBlockMatchers(r *http.Request) (index.Matchers, error) {
+	v := r.URL.Query().Get("block_match")
+	if v == "" {
+		return nil, nil
 	}
+	matchers, err := index.ParseMatchers(v)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid block_match parameter: %v", err)
+	}
+	return matchers, nil
+}
 
-	// Remove braces
-	queryStr = strings.TrimPrefix(queryStr, "{")
-	queryStr = strings.TrimSuffix(queryStr, "}")
+// parseSeriesHash parses the optional series_hash parameter, which adds
+// the label hash of each series to query results for debugging
+func parseSeriesHash(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("series_hash")
+	if v == "" {
+		return false, nil
+	}
+	withHash, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("Invalid series_hash parameter %q: must be true or false", v)
+	}
+	return withHash, nil
+}
 
-	if queryStr == "" {
-		// Empty matcher matches all series
-		return index.Matchers{}, nil
-	}
-
-	// Split by comma
-	parts := strings.Split(queryStr, ",")
-	matchers := make(index.Matchers, 0, len(parts))
-
-	for _, part := range parts {
-		part = strings.TrimSpace(part)
-
-		// Parse label="value" or label!="value" or label=~"regex" or label!~"regex"
-		var matchType index.MatchType
-		var labelName, labelValue string
-
-		if strings.Contains(part, "=~") {
-			matchType = index.MatchRegexp
-			sides := strings.SplitN(part, "=~", 2)
-			labelName = strings.TrimSpace(sides[0])
-			labelValue = strings.Trim(strings.TrimSpace(sides[1]), "\"")
-		} else if strings.Contains(part, "!~") {
-			matchType = index.MatchNotRegexp
-			sides := strings.SplitN(part, "!~", 2)
-			labelName = strings.TrimSpace(sides[0])
-			labelValue = strings.Trim(strings.TrimSpace(sides[1]), "\"")
-		} else if strings.Contains(part, "!=") {
-			matchType = index.MatchNotEqual
-			sides := strings.SplitN(part, "!=", 2)
-			labelName = strings.TrimSpace(sides[0])
-			labelValue = strings.Trim(strings.TrimSpace(sides[1]), "\"")
-		} else if strings.Contains(part, "=") {
-			matchType = index.MatchEqual
-			sides := strings.SplitN(part, "=", 2)
-			labelName = strings.TrimSpace(sides[0])
-			labelValue = strings.Trim(strings.TrimSpace(sides[1]), "\"")
-		} else {
-			return nil, fmt.Errorf("invalid matcher format: %s", part)
-		}
+// parseTrace returns the trace recording the storage reads of the query
+// made by r, and whether the optional explain parameter asks for it in the
+// response. The trace is the one attached to the request context with
+// storage.ContextWithTrace, a new one if only explain asks for it, or nil.
+func parseTrace(r *http.Request) (*storage.Trace, bool, error) {
+	trace := storage.TraceFromContext(r.Context())
+	v := r.URL.Query().Get("explain")
+	if v == "" {
+		return trace, false, nil
+	}
+	explain, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, false, fmt.Errorf("Invalid explain parameter %q: must be true or false", v)
+	}
+	if explain && trace == nil {
+		trace = storage.NewTrace()
+	}
+	return trace, explain, nil
+}
 
-		matchers = append(matchers, &index.LabelMatcher{
-			Name:  labelName,
-			Value: labelValue,
-			Type:  matchType,
-		})
+// explainTrace returns the summary of trace for the response, or nil
+// unless explain is set
+func explainTrace(trace *storage.Trace, explain bool) *storage.TraceSummary {
+	if !explain {
+		return nil
 	}
+	summary := trace.Summary()
+	return &summary
+}
 
-	return matchers, nil
+// seriesHash returns the label hash of a result series in decimal, as
+// block metadata keys series, or "" unless requested. A JSON number can't
+// hold every uint64 exactly.
+func seriesHash(withHash bool, labels map[string]string) string {
+	if !withHash {
+		return ""
+	}
+	return strconv.FormatUint(series.NewSeries(labels).Hash, 10)
+}
+
+// parseLookback parses the optional lookback parameter, how far back a
+// query evaluated at a single time looks for samples, defaulting to the
+// server's
+func (s *Server) parseLookback(r *http.Request) (time.Duration, error) {
+	v := r.URL.Query().Get("lookback")
+	if v == "" {
+		return s.lookback, nil
+	}
+	ms, err := parseDuration(v)
+	if err != nil || ms <= 0 {
+		return 0, fmt.Errorf("Invalid lookback parameter %q: must be a positive duration", v)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
 }
@@ -1,15 +1,25 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/query"
+	"github.com/therealutkarshpriyadarshi/time/pkg/rollup"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
 	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
 )
 
@@ -110,7 +120,7 @@ func TestHandleWrite(t *testing.T) {
 				t.Fatalf("Failed to marshal request: %v", err)
 			}
 
-			req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body))
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/write?precision=unchecked", bytes.NewReader(body))
 			req.Header.Set("Content-Type", "application/json")
 
 			w := httptest.NewRecorder()
@@ -137,6 +147,238 @@ func TestHandleWriteInvalidMethod(t *testing.T) {
 	}
 }
 
+func TestHandleWriteInvalidLabels(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	request := WriteRequest{
+		Timeseries: []TimeSeries{
+			{
+				Labels: []Label{
+					{Name: "__name__", Value: "cpu_usage"},
+					{Name: "host name", Value: "server1"},
+				},
+				Samples: []Sample{{Timestamp: 1000, Value: 0.75}},
+			},
+		},
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	server.handleWrite(w, httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body)))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("handleWrite() status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandleWriteAtomic(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	// The second series is invalid, so the first must not be written either
+	request := WriteRequest{
+		Timeseries: []TimeSeries{
+			{
+				Labels:  []Label{{Name: "__name__", Value: "atomic_metric"}},
+				Samples: []Sample{{Timestamp: 1000, Value: 1}},
+			},
+			{
+				Labels:  []Label{{Name: "__name__", Value: "bad-name"}},
+				Samples: []Sample{{Timestamp: 1000, Value: 2}},
+			},
+		},
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	server.handleWrite(w, httptest.NewRequest(http.MethodPost, "/api/v1/write?precision=unchecked", bytes.NewReader(body)))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("handleWrite() status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	var resp WriteResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Accepted != 0 || resp.Rejected != 2 || resp.Reasons[ReasonInvalidLabels] != 1 || resp.Reasons[ReasonNotWritten] != 1 {
+		t.Errorf("response = %+v, want 1 sample with invalid labels and 1 not written", resp)
+	}
+	if len(resp.Series) != 1 || resp.Series[0].Labels["__name__"] != "bad-name" {
+		t.Errorf("response series = %+v, want only the invalid series", resp.Series)
+	}
+
+	s := series.NewSeries(map[string]string{"__name__": "atomic_metric"})
+	if samples, _ := db.Query(s.Hash, 0, 0); len(samples) != 0 {
+		t.Errorf("failed request wrote %d samples", len(samples))
+	}
+}
+
+func TestHandleWritePrecision(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	write := func(precision string, timestamps ...int64) *httptest.ResponseRecorder {
+		samples := make([]Sample, len(timestamps))
+		for i, ts := range timestamps {
+			samples[i] = Sample{Timestamp: ts, Value: float64(i)}
+		}
+		body, err := json.Marshal(WriteRequest{Timeseries: []TimeSeries{{
+			Labels:  []Label{{Name: "__name__", Value: "precision_metric"}},
+			Samples: samples,
+		}}})
+		if err != nil {
+			t.Fatalf("Failed to marshal request: %v", err)
+		}
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/write?precision="+precision, bytes.NewReader(body)))
+		return w
+	}
+
+	if w := write("s", 1700000000); w.Code != http.StatusNoContent {
+		t.Fatalf("write in seconds status = %d: %s", w.Code, w.Body)
+	}
+	if w := write("auto", 1700000010, 1700000020000, 1700000030000000000); w.Code != http.StatusNoContent {
+		t.Fatalf("write of detected units status = %d: %s", w.Code, w.Body)
+	}
+
+	// Seconds sent as milliseconds would land in 1970
+	w := write("ms", 1700000040)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "looks like s") {
+		t.Errorf("write of seconds as ms status = %d: %s; want a bad_data error", w.Code, w.Body)
+	}
+	if w := write("", 1700000040); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "looks like s") {
+		t.Errorf("write of seconds without a precision status = %d: %s; want a bad_data error", w.Code, w.Body)
+	}
+	if w := write("minutes", 1700000040); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid precision status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	s := series.NewSeries(map[string]string{"__name__": "precision_metric"})
+	samples, _ := db.Query(s.Hash, 0, math.MaxInt64)
+	want := []int64{1700000000000, 1700000010000, 1700000020000, 1700000030000}
+	if len(samples) != len(want) {
+		t.Fatalf("got %v, want timestamps %v", samples, want)
+	}
+	for i, sample := range samples {
+		if sample.Timestamp != want[i] {
+			t.Errorf("sample %d at %d, want %d", i, sample.Timestamp, want[i])
+		}
+	}
+}
+
+func TestHandleWriteRejectedByPolicy(t *testing.T) {
+	opts := storage.DefaultOptions(t.TempDir())
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	opts.SamplePolicy.Duplicates = storage.DuplicateReject
+	db, err := storage.Open(opts)
+	if err != nil {
+		t.Fatalf("Failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+	server := NewServer(db, ":0")
+
+	dup := series.NewSeries(map[string]string{"__name__": "dup_metric"})
+	if err := db.Insert(dup, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	request := WriteRequest{
+		Timeseries: []TimeSeries{
+			{
+				Labels:  []Label{{Name: "__name__", Value: "ok_metric"}},
+				Samples: []Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}},
+			},
+			{
+				Labels:  []Label{{Name: "__name__", Value: "dup_metric"}},
+				Samples: []Sample{{Timestamp: 1000, Value: 2}},
+			},
+		},
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	server.handleWrite(w, httptest.NewRequest(http.MethodPost, "/api/v1/write?precision=unchecked", bytes.NewReader(body)))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("handleWrite() status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	var resp WriteResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.ErrorType != ErrorBadData || resp.Accepted != 0 || resp.Rejected != 3 {
+		t.Errorf("response = %+v, want all 3 samples rejected as bad data", resp)
+	}
+	if resp.Reasons[ReasonDuplicate] != 1 || resp.Reasons[ReasonNotWritten] != 2 {
+		t.Errorf("reasons = %v, want 1 duplicate and 2 not written", resp.Reasons)
+	}
+	if len(resp.Series) != 1 || resp.Series[0].Labels["__name__"] != "dup_metric" || resp.Series[0].Reason != ReasonDuplicate {
+		t.Errorf("response series = %+v, want dup_metric rejected as a duplicate", resp.Series)
+	}
+}
+
+func TestHandleWriteOutOfBounds(t *testing.T) {
+	opts := storage.DefaultOptions(t.TempDir())
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	opts.MaxFutureSkew = time.Minute
+	db, err := storage.Open(opts)
+	if err != nil {
+		t.Fatalf("Failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+	server := NewServer(db, ":0")
+
+	now := time.Now().UnixMilli()
+	future := now + time.Hour.Milliseconds()
+	request := WriteRequest{
+		Timeseries: []TimeSeries{{
+			Labels:  []Label{{Name: "__name__", Value: "skewed_metric"}},
+			Samples: []Sample{{Timestamp: now, Value: 1}, {Timestamp: future, Value: 2}},
+		}},
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	server.handleWrite(w, httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body)))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("handleWrite() status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	var resp WriteResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Accepted != 1 || resp.Rejected != 1 || resp.Reasons[ReasonTooNew] != 1 {
+		t.Errorf("response = %+v, want 1 sample accepted and 1 rejected as too new", resp)
+	}
+	if len(resp.Series) != 1 || resp.Series[0].Accepted != 1 || resp.Series[0].Reason != ReasonTooNew {
+		t.Errorf("response series = %+v, want skewed_metric with its sample too new", resp.Series)
+	}
+
+	// The sample within the bounds is written
+	s := series.NewSeries(map[string]string{"__name__": "skewed_metric"})
+	if samples, _ := db.Query(s.Hash, 0, future); len(samples) != 1 || samples[0].Timestamp != now {
+		t.Errorf("stored %v, want only the sample at %d", samples, now)
+	}
+}
+
 func TestHandleQueryRange(t *testing.T) {
 	server, db, cleanup := setupTestServer(t)
 	defer cleanup()
@@ -180,29 +422,29 @@ func TestHandleQueryRange(t *testing.T) {
 			name:       "valid query range",
 			query:      `{__name__="test_metric",host="server1"}`,
 			start:      "0",
-			end:        "5000",
-			step:       "1000",
+			end:        "5",
+			step:       "1",
 			wantStatus: http.StatusOK,
 		},
 		{
 			name:       "missing query parameter",
 			query:      "",
 			start:      "0",
-			end:        "5000",
+			end:        "5",
 			wantStatus: http.StatusBadRequest,
 		},
 		{
 			name:       "missing start parameter",
 			query:      `{__name__="test_metric"}`,
 			start:      "",
-			end:        "5000",
+			end:        "5",
 			wantStatus: http.StatusBadRequest,
 		},
 		{
 			name:       "invalid start parameter",
 			query:      `{__name__="test_metric"}`,
 			start:      "invalid",
-			end:        "5000",
+			end:        "5",
 			wantStatus: http.StatusBadRequest,
 		},
 	}
@@ -382,6 +624,140 @@ func TestHandleLabelValues(t *testing.T) {
 	}
 }
 
+func TestHandleLabelsMatch(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for _, labels := range []map[string]string{
+		{"__name__": "cpu_usage", "host": "server1", "cpu": "0"},
+		{"__name__": "cpu_usage", "host": "server2", "cpu": "1"},
+		{"__name__": "mem_usage", "host": "server3", "numa": "0"},
+	} {
+		if err := db.Insert(series.NewSeries(labels), []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+			t.Fatalf("Failed to insert test data: %v", err)
+		}
+	}
+
+	get := func(path string) (int, []string) {
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp LabelsResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return w.Code, resp.Data
+	}
+
+	cpu := url.QueryEscape(`{__name__="cpu_usage"}`)
+	if code, names := get("/api/v1/labels?match[]=" + cpu); code != http.StatusOK || !reflect.DeepEqual(names, []string{"__name__", "cpu", "host"}) {
+		t.Errorf("labels matching cpu_usage = %d %v, want [__name__ cpu host]", code, names)
+	}
+	if code, values := get("/api/v1/label/host/values?match[]=" + cpu); code != http.StatusOK || !reflect.DeepEqual(values, []string{"server1", "server2"}) {
+		t.Errorf("host values matching cpu_usage = %d %v, want [server1 server2]", code, values)
+	}
+
+	// Several selectors are unioned, and combine with start and end
+	numa := url.QueryEscape(`{numa="0"}`)
+	path := "/api/v1/label/host/values?start=0&end=2&match[]=" + url.QueryEscape(`{host="server1"}`) + "&match[]=" + numa
+	if code, values := get(path); code != http.StatusOK || !reflect.DeepEqual(values, []string{"server1", "server3"}) {
+		t.Errorf("host values of two selectors = %d %v, want [server1 server3]", code, values)
+	}
+
+	if code, _ := get("/api/v1/labels?match[]=" + url.QueryEscape(`{host=`)); code != http.StatusBadRequest {
+		t.Errorf("invalid match[] status = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestHandleMetadata(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	writeReq := WriteRequest{
+		Timeseries: []TimeSeries{
+			{
+				Labels:  []Label{{Name: "__name__", Value: "http_requests_total"}},
+				Samples: []Sample{{Timestamp: 1000, Value: 1.0}},
+			},
+			{
+				Labels:  []Label{{Name: "__name__", Value: "temperature"}},
+				Samples: []Sample{{Timestamp: 1000, Value: 21.5}},
+			},
+		},
+		Metadata: []MetricMetadata{
+			{MetricFamilyName: "temperature", Type: "gauge", Help: "Room temperature", Unit: "celsius"},
+		},
+	}
+
+	body, err := json.Marshal(writeReq)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	server.handleWrite(w, httptest.NewRequest(http.MethodPost, "/api/v1/write?precision=unchecked", bytes.NewReader(body)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("handleWrite() status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	tests := []struct {
+		name      string
+		url       string
+		wantNames []string
+	}{
+		{"all metrics", "/api/v1/metadata", []string{"http_requests_total", "temperature"}},
+		{"single metric", "/api/v1/metadata?metric=temperature", []string{"temperature"}},
+		{"limit", "/api/v1/metadata?limit=1", []string{"http_requests_total"}},
+		{"unknown metric", "/api/v1/metadata?metric=missing", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			server.handleMetadata(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("handleMetadata() status = %d, want %d", w.Code, http.StatusOK)
+			}
+
+			var resp MetadataResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+
+			if len(resp.Data) != len(tt.wantNames) {
+				t.Fatalf("got %d metrics, want %d", len(resp.Data), len(tt.wantNames))
+			}
+			for _, name := range tt.wantNames {
+				if len(resp.Data[name]) != 1 {
+					t.Errorf("missing metadata for %s", name)
+				}
+			}
+		})
+	}
+
+	w = httptest.NewRecorder()
+	server.handleMetadata(w, httptest.NewRequest(http.MethodGet, "/api/v1/metadata?metric=temperature", nil))
+	var resp MetadataResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if got := resp.Data["temperature"][0]; got.Type != "gauge" || got.Unit != "celsius" || got.Help != "Room temperature" {
+		t.Errorf("unexpected temperature metadata: %+v", got)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleMetadata(w, httptest.NewRequest(http.MethodGet, "/api/v1/metadata?metric=http_requests_total", nil))
+	resp = MetadataResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if got := resp.Data["http_requests_total"][0]; got.Type != "counter" {
+		t.Errorf("expected inferred counter type, got %q", got.Type)
+	}
+}
+
 func TestHandleStatus(t *testing.T) {
 	server, _, cleanup := setupTestServer(t)
 	defer cleanup()
@@ -405,7 +781,58 @@ func TestHandleStatus(t *testing.T) {
 	}
 
 	if resp.Data == nil {
-		t.Error("Response data is nil")
+		t.Fatal("Response data is nil")
+	}
+	if !resp.Data.Replay.Done {
+		t.Errorf("replay not reported done: %+v", resp.Data.Replay)
+	}
+	if len(resp.Data.SeriesChurn) != len(storage.ChurnWindows) {
+		t.Errorf("series churn reported for %d windows, want %d", len(resp.Data.SeriesChurn), len(storage.ChurnWindows))
+	}
+}
+
+func TestStartupServer(t *testing.T) {
+	startup := NewStartupServer(":0")
+	startup.SetReplayProgress(storage.ReplayProgress{SegmentsDone: 2, SegmentsTotal: 5, EntriesApplied: 100})
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		startup.server.Handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	if w := serve(http.MethodGet, "/-/healthy"); w.Code != http.StatusOK {
+		t.Errorf("healthy status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := serve(http.MethodGet, "/-/ready"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("ready status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	w := serve(http.MethodGet, "/api/v1/status/tsdb")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var resp StartupStatusResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Data == nil || !resp.Data.Starting || resp.Data.Replay.SegmentsDone != 2 || resp.Data.Replay.SegmentsTotal != 5 || resp.Data.Replay.EntriesApplied != 100 {
+		t.Errorf("unexpected startup status: %+v", resp.Data)
+	}
+
+	w = serve(http.MethodGet, "/api/v1/query?query=up")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("query during startup: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Shutting down before or after Start frees the address
+	done := make(chan error, 1)
+	go func() { done <- startup.Start() }()
+	if err := startup.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Start returned %v after Shutdown, want nil", err)
 	}
 }
 
@@ -455,6 +882,45 @@ func TestHandleReady(t *testing.T) {
 	}
 }
 
+func TestHealthDegraded(t *testing.T) {
+	// Below the free space minimum from the start
+	opts := storage.DefaultOptions(t.TempDir())
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	opts.MinFreeDiskBytes = math.MaxInt64
+	db, err := storage.Open(opts)
+	if err != nil {
+		t.Fatalf("Failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+	server := NewServer(db, ":0")
+
+	check := func(path string, wantCode int, wantStatus string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp HealthResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if w.Code != wantCode || resp.Status != wantStatus {
+			t.Errorf("GET %s = %d %q, want %d %q", path, w.Code, resp.Status, wantCode, wantStatus)
+		}
+	}
+
+	// Read-only still serves queries
+	check("/-/healthy", http.StatusOK, "read_only")
+	check("/-/ready", http.StatusOK, "read_only")
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	check("/-/ready", http.StatusServiceUnavailable, "shutting_down")
+
+	db.Close()
+	check("/-/healthy", http.StatusServiceUnavailable, "closed")
+}
+
 func TestParseMatchers(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -543,3 +1009,998 @@ func TestServerShutdown(t *testing.T) {
 		t.Logf("Shutdown returned error (expected for test): %v", err)
 	}
 }
+
+func TestHandleBuildInfo(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+	server.SetBuildInfo(BuildInfo{Version: "1.2.3", Commit: "abc123", BuildDate: "2024-01-01T00:00:00Z"})
+
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/status/buildinfo", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var resp BuildInfoResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	data := resp.Data
+	if data == nil || data.Version != "1.2.3" || data.Commit != "abc123" || data.GoVersion == "" {
+		t.Fatalf("build info = %+v, want the build set", data)
+	}
+	// setupTestServer disables compaction and retention
+	want := map[string]bool{
+		"compaction": false, "retention": false, "multiTenancy": false, "diskWatchdog": false,
+		"rollups": false, "asyncWrites": false, "auditLog": false, "queryLog": false,
+	}
+	if !reflect.DeepEqual(data.Features, want) {
+		t.Errorf("features = %v, want %v", data.Features, want)
+	}
+	if data.Formats.Block != storage.BlockVersion || data.Formats.Index != index.IndexFormatVersion {
+		t.Errorf("formats = %+v, want block %d and index %d", data.Formats, storage.BlockVersion, index.IndexFormatVersion)
+	}
+}
+
+func TestHandleIngestStats(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for _, host := range []string{"a", "b"} {
+		s := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": host})
+		if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+	s := series.NewSeries(map[string]string{"__name__": "mem_usage", "tenant": "team-a"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 1}, {Timestamp: 3000, Value: 1}}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	w := get("/api/v1/status/ingest?sort=series&limit=1")
+	var resp IngestStatsResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Data == nil || resp.Data.Tracked != 2 || len(resp.Data.Writers) != 1 {
+		t.Fatalf("response = %+v, want 1 of 2 writers", resp.Data)
+	}
+	if got, want := resp.Data.Writers[0], (IngestWriterStats{Metric: "cpu_usage", Accepted: 2, Series: 2}); got != want {
+		t.Errorf("writer = %+v, want %+v", got, want)
+	}
+
+	for _, target := range []string{"/api/v1/status/ingest?sort=bytes", "/api/v1/status/ingest?limit=0"} {
+		if w := get(target); w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s status = %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestHandleCardinality(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for i, host := range []string{"a", "a", "b"} {
+		s := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": host, "core": fmt.Sprint(i)})
+		if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 1}}); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+	s := series.NewSeries(map[string]string{"__name__": "mem_usage", "host": "a"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	w := get(`/api/v1/status/cardinality?match={__name__="cpu_usage"}&limit=1`)
+	var resp CardinalityResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Data == nil || resp.Data.Series != 3 || resp.Data.Samples != 6 {
+		t.Fatalf("response = %+v, want 3 series of 6 samples", resp.Data)
+	}
+	// __name__ is fixed by the selector; core has the most values
+	want := []LabelCardinality{
+		{Name: "core", Series: 3, DistinctValues: 3, Values: []LabelValueCount{{Value: "0", Series: 1}}},
+		{Name: "host", Series: 3, DistinctValues: 2, Values: []LabelValueCount{{Value: "a", Series: 2}}},
+	}
+	if !reflect.DeepEqual(resp.Data.Labels, want) {
+		t.Errorf("labels = %+v, want %+v", resp.Data.Labels, want)
+	}
+
+	if w := get(`/api/v1/status/cardinality?match={host=~"(`); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid matcher status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandleWALStatus(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	for _, ts := range []int64{1000, 2000} {
+		if err := db.Insert(s, []series.Sample{{Timestamp: ts, Value: 1}}); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/status/wal", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var resp WALStatusResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	data := resp.Data
+	if data == nil || len(data.Segments) == 0 || data.Entries != 2 || data.OldestEntry == 0 || data.LastSyncTime == 0 {
+		t.Fatalf("response = %+v, want 2 entries and a sync", data)
+	}
+	current := data.Segments[len(data.Segments)-1]
+	if !current.Current || current.ULID == "" || current.Size == 0 || current.MaxTime < data.OldestEntry {
+		t.Errorf("current segment = %+v", current)
+	}
+}
+
+func TestHandleQueryLatest(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for host, ts := range map[string]int64{"a": 9000, "b": 1000} {
+		s := series.NewSeries(map[string]string{"__name__": "up", "host": host})
+		if err := db.Insert(s, []series.Sample{{Timestamp: ts, Value: 1}}); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	// b is older than the lookback
+	w := get(`/api/v1/query?query={__name__="up"}&latest=true&time=10&lookback=5s`)
+	var resp QueryResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Data == nil || len(resp.Data.Result) != 1 {
+		t.Fatalf("response = %+v, want 1 series", resp.Data)
+	}
+	result := resp.Data.Result[0]
+	if result.Metric["host"] != "a" || result.Value[0] != float64(9000) || result.Age == nil || *result.Age != 1000 {
+		t.Errorf("result = %+v, want host a at 9000 with age 1000", result)
+	}
+
+	for _, target := range []string{
+		`/api/v1/query?query={__name__="up"}&latest=true&time=10&max_series=1`,
+		`/api/v1/query?query=absent({__name__="up"})&latest=true`,
+		`/api/v1/query?query={__name__="up"}&latest=maybe`,
+		`/api/v1/query?query={__name__="up"}&latest=true&lookback=-1s`,
+	} {
+		if w := get(target); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestHandleQueryLookback(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	s := series.NewSeries(map[string]string{"__name__": "backup_size"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	query := func(target string) []QueryResult {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		var resp QueryResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Data == nil {
+			t.Fatalf("%s: status %d, %v", target, w.Code, err)
+		}
+		return resp.Data.Result
+	}
+
+	// Within the default lookback of 5m
+	if got := query(`/api/v1/query?query={__name__="backup_size"}&time=60`); len(got) != 1 {
+		t.Errorf("got %+v, want the sample at 1000", got)
+	}
+	if got := query(`/api/v1/query?query={__name__="backup_size"}&time=60&lookback=30s`); len(got) != 0 {
+		t.Errorf("got %+v with a 30s lookback, want nothing", got)
+	}
+
+	server.SetQueryLookback(20 * time.Minute)
+	if got := query(`/api/v1/query?query={__name__="backup_size"}&time=900`); len(got) != 1 {
+		t.Errorf("got %+v with a 20m default lookback, want the sample at 1000", got)
+	}
+	if got := query(`/api/v1/query?query=absent({__name__="backup_size"})&time=900`); len(got) != 0 {
+		t.Errorf("absent() = %+v within the lookback, want nothing", got)
+	}
+	if got := query(`/api/v1/query_range?query=absent({__name__="backup_size"})&start=1&end=1201&step=600&lookback=5m`); len(got) != 1 || len(got[0].Values) != 2 {
+		t.Errorf("absent() over a range = %+v, want absent at the last two steps", got)
+	}
+
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, `/api/v1/query?query={__name__="backup_size"}&lookback=-1m`, nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d for a negative lookback", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandleQuerySeriesHash(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	labels := map[string]string{"zone": "eu", "__name__": "up", "job": "api", "host": "a"}
+	if err := db.Insert(series.NewSeries(labels), []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	want := fmt.Sprintf(`"metric":{"__name__":"up","host":"a","job":"api","zone":"eu"},"seriesHash":"%d"`,
+		series.NewSeries(labels).Hash)
+	for _, target := range []string{
+		`/api/v1/query?query={__name__="up"}&time=1&series_hash=true`,
+		`/api/v1/query?query={__name__="up"}&time=1&latest=true&series_hash=true`,
+		`/api/v1/query_range?query={__name__="up"}&start=0&end=2&series_hash=true`,
+	} {
+		w := get(target)
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("%s: body = %s, want sorted labels and series hash", target, w.Body.String())
+		}
+	}
+
+	if w := get(`/api/v1/query?query={__name__="up"}&time=1`); strings.Contains(w.Body.String(), "seriesHash") {
+		t.Errorf("series hash returned without series_hash=true: %s", w.Body.String())
+	}
+	if w := get(`/api/v1/query?query={__name__="up"}&series_hash=maybe`); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d for an invalid series_hash", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandleQueryExplain(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	s := series.NewSeries(map[string]string{"__name__": "up", "job": "api"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	get := func(req *http.Request) QueryResponse {
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body: %s", req.URL, w.Code, w.Body.String())
+		}
+		var resp QueryResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	for _, target := range []string{
+		`/api/v1/query?query={__name__="up"}&time=1&explain=true`,
+		`/api/v1/query_range?query={__name__="up"}&start=0&end=2&explain=true`,
+	} {
+		resp := get(httptest.NewRequest(http.MethodGet, target, nil))
+		if resp.Explain == nil || len(resp.Explain.Blocks) != 1 || resp.Explain.ChunksDecoded != 1 {
+			t.Errorf("%s: explain = %+v, want the flushed block's chunk", target, resp.Explain)
+		}
+	}
+
+	// A trace attached to the request context records the query without
+	// explaining it
+	trace := storage.NewTrace()
+	req := httptest.NewRequest(http.MethodGet, `/api/v1/query?query={__name__="up"}&time=1`, nil)
+	if resp := get(req.WithContext(storage.ContextWithTrace(req.Context(), trace))); resp.Explain != nil {
+		t.Errorf("explain returned without explain=true: %+v", resp.Explain)
+	}
+	if summary := trace.Summary(); summary.ChunksDecoded != 1 {
+		t.Errorf("context trace = %+v, want the flushed block's chunk", summary)
+	}
+
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, `/api/v1/query?query={__name__="up"}&explain=maybe`, nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d for an invalid explain", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandleStatusMinTime(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 5000, Value: 1}, {Timestamp: 3000, Value: 2}}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/status/tsdb", nil)
+	w := httptest.NewRecorder()
+	server.handleStatus(w, req)
+
+	var resp StatusResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Data == nil || resp.Data.MinTime != 3000 {
+		t.Errorf("minTime = %+v, want 3000", resp.Data)
+	}
+	if resp.Data != nil && resp.Data.PurgedBefore != 0 {
+		t.Errorf("purgedBefore = %d, want 0 with retention disabled", resp.Data.PurgedBefore)
+	}
+	if resp.Data != nil && (len(resp.Data.IndexLabels) != 1 || resp.Data.IndexLabels[0].Name != "__name__" || resp.Data.IndexLabels[0].Bytes <= 0) {
+		t.Errorf("indexLabels = %+v, want the memory of __name__", resp.Data.IndexLabels)
+	}
+}
+
+func TestHandleSeriesPagination(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for i := 0; i < 5; i++ {
+		s := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": fmt.Sprintf("server%d", i)})
+		if err := db.Insert(s, []series.Sample{{Timestamp: int64(1000 * (i + 1)), Value: 1}}); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+
+	fetch := func(query string) SeriesResponse {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/series?"+query, nil)
+		w := httptest.NewRecorder()
+		server.handleSeries(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("handleSeries(%s) status = %d, body %s", query, w.Code, w.Body.String())
+		}
+		var resp SeriesResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	match := url.QueryEscape(`{__name__="cpu_usage"}`)
+	first := fetch("match[]=" + match + "&limit=3")
+	if len(first.Data) != 3 || first.NextToken == "" {
+		t.Fatalf("first page: %d series, nextToken %q", len(first.Data), first.NextToken)
+	}
+	second := fetch("match[]=" + match + "&limit=3&token=" + first.NextToken)
+	if len(second.Data) != 2 || second.NextToken != "" {
+		t.Fatalf("second page: %d series, nextToken %q", len(second.Data), second.NextToken)
+	}
+	if second.Data[0]["host"] != "server3" {
+		t.Errorf("second page starts at %v, want server3", second.Data[0])
+	}
+
+	// Duplicate selectors don't duplicate series; start/end bound by sample time
+	bounded := fetch("match[]=" + match + "&match[]=" + match + "&start=2&end=3")
+	if len(bounded.Data) != 2 {
+		t.Errorf("expected 2 series in [2000, 3000], got %d", len(bounded.Data))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/series?match[]="+match+"&token=!!", nil)
+	w := httptest.NewRecorder()
+	server.handleSeries(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid token status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// max_series caps the union of the selectors, whatever the page size
+	host := url.QueryEscape(`{host="server0"}`)
+	if resp := fetch("match[]=" + match + "&match[]=" + host + "&max_series=5&limit=2"); len(resp.Data) != 2 {
+		t.Errorf("expected a page of 2 series within max_series, got %d", len(resp.Data))
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/series?match[]="+match+"&max_series=4&limit=2", nil)
+	w = httptest.NewRecorder()
+	server.handleSeries(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("over max_series status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandleWatch(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	match := url.QueryEscape(`{__name__="cpu_usage"}`)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/v1/watch?match[]="+match, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("watch request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	if line, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(line, ": watching") {
+		t.Fatalf("expected watching comment, got %q (%v)", line, err)
+	}
+
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "server1"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 0.5}}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	var event, data string
+	for data == "" {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+
+	if event != "samples" {
+		t.Errorf("event = %q, want samples", event)
+	}
+	var result QueryResult
+	if err := json.Unmarshal([]byte(data), &result); err != nil {
+		t.Fatalf("failed to decode event data %q: %v", data, err)
+	}
+	if result.Metric["host"] != "server1" || len(result.Values) != 1 {
+		t.Errorf("unexpected event %+v", result)
+	}
+}
+
+func TestHandleWatchRequiresMatch(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/watch", nil)
+	w := httptest.NewRecorder()
+	server.handleWatch(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("handleWatch() status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandleFlushAndCompact(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	s := series.NewSeries(map[string]string{"__name__": "admin_flush_test"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/flush", nil)
+	w := httptest.NewRecorder()
+	server.handleFlush(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if stats := db.GetStatsSnapshot(); stats.FlushCount != 1 {
+		t.Errorf("Expected the flush to have completed, flush count is %d", stats.FlushCount)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/admin/flush", nil)
+	w = httptest.NewRecorder()
+	server.handleFlush(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for GET, got %d", w.Code)
+	}
+
+	// Compaction is disabled on the default test server
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/admin/compact", nil)
+	w = httptest.NewRecorder()
+	server.handleCompact(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 with compaction disabled, got %d", w.Code)
+	}
+}
+
+func TestHandlePauseResume(t *testing.T) {
+	opts := storage.DefaultOptions(t.TempDir())
+	opts.CompactionInterval = time.Hour
+	db, err := storage.Open(opts)
+	if err != nil {
+		t.Fatalf("Failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+	server := NewServer(db, ":0")
+
+	status := func() *StatusData {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/status/tsdb", nil)
+		w := httptest.NewRecorder()
+		server.handleStatus(w, req)
+
+		var response StatusResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return response.Data
+	}
+
+	for _, tc := range []struct {
+		handler          http.HandlerFunc
+		compactionPaused bool
+		retentionPaused  bool
+	}{
+		{server.handlePauseCompaction, true, false},
+		{server.handlePauseRetention, true, true},
+		{server.handleResumeCompaction, false, true},
+		{server.handleResumeRetention, false, false},
+	} {
+		w := httptest.NewRecorder()
+		tc.handler(w, httptest.NewRequest(http.MethodPost, "/", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		data := status()
+		if data.CompactionPaused != tc.compactionPaused || data.RetentionPaused != tc.retentionPaused {
+			t.Errorf("Expected compactionPaused=%v retentionPaused=%v, got %v %v",
+				tc.compactionPaused, tc.retentionPaused, data.CompactionPaused, data.RetentionPaused)
+		}
+	}
+
+	// Compaction and retention are disabled on the default test server
+	disabled, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	w := httptest.NewRecorder()
+	disabled.handlePauseRetention(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 with retention disabled, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	disabled.handlePauseCompaction(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for GET, got %d", w.Code)
+	}
+}
+
+func TestHandleCompactionPlan(t *testing.T) {
+	// Compaction is disabled on the default test server
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/compaction/plan", nil)
+	w := httptest.NewRecorder()
+	server.handleCompactionPlan(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 with compaction disabled, got %d", w.Code)
+	}
+
+	opts := storage.DefaultOptions(t.TempDir())
+	opts.EnableRetention = false
+	opts.CompactionInterval = time.Hour
+	db, err := storage.Open(opts)
+	if err != nil {
+		t.Fatalf("Failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	w = httptest.NewRecorder()
+	NewServer(db, ":0").handleCompactionPlan(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response CompactionPlanResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Status != "success" || response.Data == nil {
+		t.Fatalf("Unexpected response: %+v", response)
+	}
+	if len(response.Data.Merges) != 0 {
+		t.Errorf("Expected no merges for an empty database, got %d", len(response.Data.Merges))
+	}
+}
+
+func TestHandleRetentionPlan(t *testing.T) {
+	// Retention is disabled on the default test server
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/retention/plan", nil)
+	w := httptest.NewRecorder()
+	server.handleRetentionPlan(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 with retention disabled, got %d", w.Code)
+	}
+
+	dataDir := t.TempDir()
+	opts := storage.DefaultOptions(dataDir)
+	opts.CompactionInterval = time.Hour
+	opts.RetentionPeriod = 24 * time.Hour
+	db, err := storage.Open(opts)
+	if err != nil {
+		t.Fatalf("Failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+	if err := db.PauseCompaction(); err != nil {
+		t.Fatalf("PauseCompaction failed: %v", err)
+	}
+	if err := db.PauseRetention(); err != nil {
+		t.Fatalf("PauseRetention failed: %v", err)
+	}
+
+	// One block past the retention period, one within it
+	now := time.Now().UnixMilli()
+	var expired string
+	for _, minTime := range []int64{now - 48*time.Hour.Milliseconds(), now - time.Hour.Milliseconds()} {
+		block, err := storage.NewBlock(minTime, minTime+time.Minute.Milliseconds())
+		if err != nil {
+			t.Fatalf("NewBlock failed: %v", err)
+		}
+		s := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+		if err := block.AddSeries(s, []series.Sample{{Timestamp: minTime, Value: 1}}); err != nil {
+			t.Fatalf("AddSeries failed: %v", err)
+		}
+		if err := block.Persist(dataDir); err != nil {
+			t.Fatalf("Persist failed: %v", err)
+		}
+		if expired == "" {
+			expired = block.ULID.String()
+		}
+	}
+	if _, err := db.ScanBlocks(); err != nil {
+		t.Fatalf("ScanBlocks failed: %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	NewServer(db, ":0").handleRetentionPlan(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response RetentionPlanResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	data := response.Data
+	if response.Status != "success" || data == nil {
+		t.Fatalf("Unexpected response: %+v", response)
+	}
+	if !data.Enabled || data.MaxAgeSeconds != (24*time.Hour).Seconds() || data.TotalBlocks != 2 {
+		t.Errorf("Unexpected plan: %+v", data)
+	}
+	if len(data.Blocks) != 1 || data.Blocks[0].Block != expired || data.Blocks[0].Bytes <= 0 {
+		t.Fatalf("Expected only block %s to be planned for deletion, got %+v", expired, data.Blocks)
+	}
+	if data.ReclaimableBytes != data.Blocks[0].Bytes {
+		t.Errorf("Expected %d reclaimable bytes, got %d", data.Blocks[0].Bytes, data.ReclaimableBytes)
+	}
+}
+
+func TestHandleHolds(t *testing.T) {
+	dataDir := t.TempDir()
+	block, err := storage.NewBlock(1000, 2000)
+	if err != nil {
+		t.Fatalf("NewBlock failed: %v", err)
+	}
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	if err := block.AddSeries(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("AddSeries failed: %v", err)
+	}
+	if err := block.Persist(dataDir); err != nil {
+		t.Fatalf("Persist failed: %v", err)
+	}
+
+	opts := storage.DefaultOptions(dataDir)
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	db, err := storage.Open(opts)
+	if err != nil {
+		t.Fatalf("Failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+	server := NewServer(db, ":0")
+
+	do := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.handleHolds(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/v1/admin/holds?start=0&end=5&reason=incident")
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST: expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var change HoldChangeResponse
+	json.NewDecoder(w.Body).Decode(&change)
+	if len(change.Data) != 1 {
+		t.Fatalf("POST: expected 1 protected block, got %v", change.Data)
+	}
+
+	w = do(http.MethodGet, "/api/v1/admin/holds")
+	var holds HoldsResponse
+	json.NewDecoder(w.Body).Decode(&holds)
+	if len(holds.Data) != 1 || holds.Data[0].Block != change.Data[0] || holds.Data[0].Reason != "incident" {
+		t.Errorf("GET: unexpected holds %+v", holds.Data)
+	}
+
+	w = do(http.MethodDelete, "/api/v1/admin/holds?block="+change.Data[0])
+	if w.Code != http.StatusOK {
+		t.Fatalf("DELETE: expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = do(http.MethodPost, "/api/v1/admin/holds")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("POST without selector: expected status 400, got %d", w.Code)
+	}
+}
+
+func TestHandleRollups(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.handleRollups(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	w := do(http.MethodGet, "/api/v1/rollups", "")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET without manager: expected status 503, got %d", w.Code)
+	}
+
+	server.SetRollupManager(rollup.NewManager(db, rollup.DefaultOptions(t.TempDir())))
+
+	rule := `{"name":"cpu_usage:avg5m","source":"cpu_usage","function":"avg","by":["host"],"interval":"5m"}`
+	w = do(http.MethodPost, "/api/v1/rollups", rule)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST: expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w = do(http.MethodPost, "/api/v1/rollups", rule)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("duplicate POST: expected status 400, got %d", w.Code)
+	}
+	w = do(http.MethodPost, "/api/v1/rollups", `{"name":"x","source":"cpu_usage","function":"median","interval":"5m"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid POST: expected status 400, got %d", w.Code)
+	}
+
+	w = do(http.MethodGet, "/api/v1/rollups", "")
+	var rollups RollupsResponse
+	json.NewDecoder(w.Body).Decode(&rollups)
+	if len(rollups.Data) != 1 || rollups.Data[0].Name != "cpu_usage:avg5m" || rollups.Data[0].Interval != "5m" {
+		t.Errorf("GET: unexpected rules %+v", rollups.Data)
+	}
+
+	w = do(http.MethodDelete, "/api/v1/rollups?name=cpu_usage:avg5m", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("DELETE: expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w = do(http.MethodDelete, "/api/v1/rollups?name=cpu_usage:avg5m", "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("second DELETE: expected status 400, got %d", w.Code)
+	}
+}
+
+func TestHandleQueryLimits(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for _, host := range []string{"a", "b", "c"} {
+		s := series.NewSeries(map[string]string{"__name__": "test_metric", "host": host})
+		if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+			t.Fatalf("Failed to insert test data: %v", err)
+		}
+	}
+	server.SetQueryLimits(query.Limits{MaxSeries: 2})
+
+	tests := []struct {
+		name       string
+		params     string
+		wantStatus int
+		wantSeries int
+	}{
+		{"server default applies", "", http.StatusBadRequest, 0},
+		{"request cannot raise the default", "&max_series=10", http.StatusBadRequest, 0},
+		{"truncate", "&limit_mode=truncate", http.StatusOK, 2},
+		{"request lowers the default", "&max_series=1&limit_mode=truncate", http.StatusOK, 1},
+		{"sample limit", "&max_samples=1", http.StatusBadRequest, 0},
+		{"invalid limit", "&max_series=-1", http.StatusBadRequest, 0},
+		{"invalid mode", "&limit_mode=drop", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "/api/v1/query_range?query=" + url.QueryEscape(`{__name__="test_metric"}`) +
+				"&start=0&end=5&step=1" + tt.params
+			w := httptest.NewRecorder()
+			server.handleQueryRange(w, httptest.NewRequest(http.MethodGet, target, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var resp QueryResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(resp.Data.Result) != tt.wantSeries {
+				t.Errorf("Expected %d series, got %d", tt.wantSeries, len(resp.Data.Result))
+			}
+			if len(resp.Warnings) != 1 {
+				t.Errorf("Expected a truncation warning, got %v", resp.Warnings)
+			}
+		})
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	s := series.NewSeries(map[string]string{"__name__": "metrics_test"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	server.handleMetrics(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"tsdb_flushes_total 1",
+		"tsdb_flush_duration_seconds_count 1",
+		`tsdb_wal_sync_duration_seconds{quantile="0.99"}`,
+		"tsdb_compaction_duration_seconds_count 0",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+}
+
+func TestHandleBackups(t *testing.T) {
+	server, db, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	do := func(method, target string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/v1/admin/backups", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST: expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var created BackupResponse
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	info := created.Data
+	if info == nil || info.ID == "" || info.Blocks != 1 || info.Size <= 0 || info.ExpiresAt == 0 {
+		t.Fatalf("Unexpected backup: %+v", info)
+	}
+
+	w = do(http.MethodGet, "/api/v1/admin/backups", nil)
+	var list BackupsResponse
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list.Data) != 1 || list.Data[0].ID != info.ID {
+		t.Fatalf("Unexpected backup list: %+v", list.Data)
+	}
+
+	target := "/api/v1/admin/backups/" + info.ID
+	w = do(http.MethodGet, target, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET: expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	archive := w.Body.Bytes()
+	if int64(len(archive)) != info.Size || w.Header().Get("Content-Type") != "application/x-tar" {
+		t.Fatalf("Downloaded %d bytes of type %q, want %d", len(archive), w.Header().Get("Content-Type"), info.Size)
+	}
+
+	// Resume a download against the same snapshot
+	w = do(http.MethodGet, target, http.Header{
+		"Range":    {"bytes=1000-"},
+		"If-Range": {w.Header().Get("ETag")},
+	})
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("Range GET: expected status 206, got %d", w.Code)
+	}
+	if !bytes.Equal(w.Body.Bytes(), archive[1000:]) {
+		t.Error("Range GET returned different content")
+	}
+
+	w = do(http.MethodDelete, target, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("DELETE: expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w = do(http.MethodGet, target, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("GET after DELETE: expected status 400, got %d", w.Code)
+	}
+
+	// Idle backups are released
+	server.SetBackupIdleTimeout(10 * time.Millisecond)
+	w = do(http.MethodPost, "/api/v1/admin/backups", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST: expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w = do(http.MethodGet, "/api/v1/admin/backups", nil)
+		list = BackupsResponse{}
+		json.NewDecoder(w.Body).Decode(&list)
+		if len(list.Data) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Idle backup was not released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
+)
+
+// StartupServer answers on the API address while the database is opened,
+// before the API server can: health checks pass, readiness checks fail,
+// and the status endpoint reports WAL replay progress. Every other request
+// is answered as unavailable.
+type StartupServer struct {
+	server   *http.Server
+	progress atomic.Pointer[storage.ReplayProgress]
+}
+
+// NewStartupServer creates a startup server listening on addr.
+func NewStartupServer(addr string) *StartupServer {
+	s := &StartupServer{}
+	s.progress.Store(&storage.ReplayProgress{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/-/healthy", s.handleHealthy)
+	mux.HandleFunc("/-/ready", s.handleReady)
+	mux.HandleFunc("/api/v1/status/tsdb", s.handleStatus)
+	mux.HandleFunc("/", s.handleUnavailable)
+
+	s.server = &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+	return s
+}
+
+// SetReplayProgress records WAL replay progress; it is meant to be set as
+// storage.Options.ReplayProgress.
+func (s *StartupServer) SetReplayProgress(p storage.ReplayProgress) {
+	s.progress.Store(&p)
+}
+
+// Start serves until Shutdown is called, then returns nil.
+func (s *StartupServer) Start() error {
+	log.Printf("Serving startup status on %s", s.server.Addr)
+	if err := s.server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops the startup server, freeing the address for the API
+// server.
+func (s *StartupServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// handleHealthy returns 200: the process is up, if not ready yet.
+func (s *StartupServer) handleHealthy(w http.ResponseWriter, r *http.Request) {
+	writeStartupJSON(w, HealthResponse{Status: "healthy", Message: "TSDB is starting"}, http.StatusOK)
+}
+
+// handleReady returns 503 until the database is open.
+func (s *StartupServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	setErrorHeaders(w, ErrorUnavailable)
+	writeStartupJSON(w, HealthResponse{Status: "starting", Message: "TSDB is replaying its WAL"}, http.StatusServiceUnavailable)
+}
+
+// handleStatus reports WAL replay progress.
+func (s *StartupServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeStartupJSON(w, StartupStatusResponse{
+		Status: "success",
+		Data: &StartupStatusData{
+			Starting: true,
+			Replay:   newReplayStatus(*s.progress.Load()),
+		},
+	}, http.StatusOK)
+}
+
+// handleUnavailable answers every other endpoint until the database is open.
+func (s *StartupServer) handleUnavailable(w http.ResponseWriter, r *http.Request) {
+	setErrorHeaders(w, ErrorUnavailable)
+	writeStartupJSON(w, QueryResponse{
+		Status:    "error",
+		ErrorType: ErrorUnavailable,
+		Error:     "TSDB is starting: replaying its WAL",
+	}, ErrorUnavailable.StatusCode())
+}
+
+// writeStartupJSON writes a JSON response.
+func writeStartupJSON(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
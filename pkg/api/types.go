@@ -1,12 +1,23 @@
 package api
 
 import (
+	"github.com/therealutkarshpriyadarshi/time/pkg/rollup"
 	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
 )
 
 // WriteRequest represents a Prometheus-compatible remote write request.
 type WriteRequest struct {
-	Timeseries []TimeSeries `json:"timeseries"`
+	Timeseries []TimeSeries     `json:"timeseries"`
+	Metadata   []MetricMetadata `json:"metadata,omitempty"`
+}
+
+// MetricMetadata describes a metric family sent alongside a write request.
+type MetricMetadata struct {
+	MetricFamilyName string `json:"metricFamilyName"`
+	Type             string `json:"type"` // counter, gauge, histogram, summary or unknown
+	Help             string `json:"help,omitempty"`
+	Unit             string `json:"unit,omitempty"`
 }
 
 // TimeSeries represents a series with labels and samples.
@@ -27,6 +38,28 @@ type Sample struct {
 	Value     float64 `json:"value"`
 }
 
+// WriteResponse reports a write that was not fully stored: how many
+// samples were accepted and rejected, why, and which series were affected.
+// A fully stored write gets an empty 204 response instead.
+type WriteResponse struct {
+	Status    string              `json:"status"`
+	ErrorType ErrorType           `json:"errorType,omitempty"`
+	Error     string              `json:"error,omitempty"`
+	Accepted  int                 `json:"accepted"`          // Samples stored
+	Rejected  int                 `json:"rejected"`          // Samples not stored
+	Reasons   map[string]int      `json:"reasons,omitempty"` // Rejected samples by reason
+	Series    []SeriesWriteResult `json:"series,omitempty"`  // The first series with rejected samples
+}
+
+// SeriesWriteResult is the outcome of a write for one series.
+type SeriesWriteResult struct {
+	Labels   map[string]string `json:"labels"`
+	Accepted int               `json:"accepted"`
+	Rejected int               `json:"rejected"`
+	Reason   string            `json:"reason,omitempty"` // Reason of the first rejected sample
+	Error    string            `json:"error,omitempty"`
+}
+
 // QueryRequest represents a query request.
 type QueryRequest struct {
 	Query string `json:"query"` // Label matchers string, e.g., {__name__="cpu_usage",host="server1"}
@@ -43,9 +76,14 @@ type QueryRangeRequest struct {
 
 // QueryResponse represents the response to a query.
 type QueryResponse struct {
-	Status string     `json:"status"`
-	Data   *QueryData `json:"data,omitempty"`
-	Error  string     `json:"error,omitempty"`
+	Status    string     `json:"status"`
+	Data      *QueryData `json:"data,omitempty"`
+	ErrorType ErrorType  `json:"errorType,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Warnings  []string   `json:"warnings,omitempty"`
+
+	// With explain=true: the blocks and chunks the query read
+	Explain *storage.TraceSummary `json:"explain,omitempty"`
 }
 
 // QueryData contains the query result data.
@@ -54,39 +92,65 @@ type QueryData struct {
 	Result     []QueryResult `json:"result"`
 }
 
-// QueryResult represents a single time series result.
+// QueryResult represents a single time series result. Metric is encoded
+// with its labels sorted by name, so identical results encode identically.
 type QueryResult struct {
-	Metric map[string]string `json:"metric"`
-	Values [][]interface{}   `json:"values,omitempty"` // For range queries: [[timestamp, "value"], ...]
-	Value  []interface{}     `json:"value,omitempty"`  // For instant queries: [timestamp, "value"]
+	Metric     map[string]string `json:"metric"`
+	SeriesHash string            `json:"seriesHash,omitempty"` // With series_hash=true: the label hash, in decimal
+	Values     [][]interface{}   `json:"values,omitempty"`     // For range queries: [[timestamp, "value"], ...]
+	Value      []interface{}     `json:"value,omitempty"`      // For instant queries: [timestamp, "value"]
+	Age        *int64            `json:"age,omitempty"`        // With latest=true: milliseconds from the sample to the query time
 }
 
 // LabelsResponse represents the response to a labels query.
 type LabelsResponse struct {
-	Status string   `json:"status"`
-	Data   []string `json:"data,omitempty"`
-	Error  string   `json:"error,omitempty"`
+	Status    string    `json:"status"`
+	Data      []string  `json:"data,omitempty"`
+	ErrorType ErrorType `json:"errorType,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	NextToken string    `json:"nextToken,omitempty"` // Pass as token to fetch the next page
 }
 
 // LabelValuesResponse represents the response to a label values query.
 type LabelValuesResponse struct {
-	Status string   `json:"status"`
-	Data   []string `json:"data,omitempty"`
-	Error  string   `json:"error,omitempty"`
+	Status    string    `json:"status"`
+	Data      []string  `json:"data,omitempty"`
+	ErrorType ErrorType `json:"errorType,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	NextToken string    `json:"nextToken,omitempty"` // Pass as token to fetch the next page
 }
 
 // SeriesResponse represents the response to a series query.
 type SeriesResponse struct {
-	Status string              `json:"status"`
-	Data   []map[string]string `json:"data,omitempty"`
-	Error  string              `json:"error,omitempty"`
+	Status    string              `json:"status"`
+	Data      []map[string]string `json:"data,omitempty"`
+	ErrorType ErrorType           `json:"errorType,omitempty"`
+	Error     string              `json:"error,omitempty"`
+	NextToken string              `json:"nextToken,omitempty"` // Pass as token to fetch the next page
+}
+
+// MetadataResponse represents the response to a metadata query.
+// Data maps metric names to their metadata, mirroring the Prometheus API.
+type MetadataResponse struct {
+	Status    string                     `json:"status"`
+	Data      map[string][]MetadataEntry `json:"data,omitempty"`
+	ErrorType ErrorType                  `json:"errorType,omitempty"`
+	Error     string                     `json:"error,omitempty"`
+}
+
+// MetadataEntry is the metadata of a single metric.
+type MetadataEntry struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
 }
 
 // StatusResponse represents the response to a status/tsdb query.
 type StatusResponse struct {
-	Status string      `json:"status"`
-	Data   *StatusData `json:"data,omitempty"`
-	Error  string      `json:"error,omitempty"`
+	Status    string      `json:"status"`
+	Data      *StatusData `json:"data,omitempty"`
+	ErrorType ErrorType   `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
 }
 
 // StatusData contains TSDB status information.
@@ -97,12 +161,542 @@ type StatusData struct {
 	LastFlushTime      int64 `json:"lastFlushTime"`
 	WALSize            int64 `json:"walSize"`
 	ActiveMemTableSize int64 `json:"activeMemTableSize"`
+	MinTime            int64 `json:"minTime,omitempty"`      // Oldest retained timestamp (Unix ms); omitted when empty
+	PurgedBefore       int64 `json:"purgedBefore,omitempty"` // Data before this time (Unix ms) has been removed by retention
+	QuarantinedBlocks  int64 `json:"quarantinedBlocks"`      // Corrupt blocks moved to quarantine on open or by a block scan
+	AdoptedBlocks      int64 `json:"adoptedBlocks"`          // Blocks added by other tools and found by a block scan
+	CorruptBlockReads  int64 `json:"corruptBlockReads"`      // Block reads that failed and were skipped by queries
+
+	// Samples handled by the duplicate and out-of-order policy
+	DuplicatesOverwritten int64 `json:"duplicatesOverwritten"`
+	DuplicatesDropped     int64 `json:"duplicatesDropped"`
+	DuplicatesRejected    int64 `json:"duplicatesRejected"`
+	OutOfOrderSamples     int64 `json:"outOfOrderSamples"`
+	OutOfOrderRejected    int64 `json:"outOfOrderRejected"`
+
+	// Samples over the per-series rate limit
+	RateLimitedDropped    int64 `json:"rateLimitedDropped"`
+	RateLimitedAggregated int64 `json:"rateLimitedAggregated"`
+
+	// New series rejected for a label over its postings limit
+	LabelLimitRejected int64 `json:"labelLimitRejected"`
+
+	// Samples outside the timestamp bounds
+	TooOldRejected int64 `json:"tooOldRejected"`
+	TooNewRejected int64 `json:"tooNewRejected"`
+
+	// Samples of series dropped by relabel rules
+	RelabelDropped int64 `json:"relabelDropped"`
+
+	// Series found sharing a hash with another label set
+	HashCollisions int64 `json:"hashCollisions"`
+
+	// Samples spilled to disk while the MemTable was full
+	SpilledSamples int64 `json:"spilledSamples"`
+	SpillPending   int64 `json:"spillPending"` // Not merged by a flush yet
+
+	// Disk watchdog; readOnly is set while free space is below the minimum
+	DiskFreeBytes          int64 `json:"diskFreeBytes,omitempty"`
+	DiskTotalBytes         int64 `json:"diskTotalBytes,omitempty"`
+	EmergencyBlocksDeleted int64 `json:"emergencyBlocksDeleted"`
+	ReadOnly               bool  `json:"readOnly"`
+
+	// Background maintenance paused through the admin API
+	CompactionPaused bool `json:"compactionPaused"`
+	RetentionPaused  bool `json:"retentionPaused"`
+
+	// Compaction and retention I/O, and the time it was held by the
+	// background I/O limits
+	BackgroundIOBytes       int64   `json:"backgroundIOBytes"`
+	BackgroundIOWaitSeconds float64 `json:"backgroundIOWaitSeconds"`
+
+	// Durations of recent flushes, WAL syncs and compaction merges
+	FlushDuration      DurationSummary `json:"flushDuration"`
+	WALSyncDuration    DurationSummary `json:"walSyncDuration"`
+	CompactionDuration DurationSummary `json:"compactionDuration"`
+
+	// WAL replay on startup
+	Replay ReplayStatus `json:"replay"`
+
+	// Async write queue; omitted when writes are synchronous
+	WriteQueue *WriteQueueStatus `json:"writeQueue,omitempty"`
+
+	// Metric names creating and ending the most head series, per window
+	SeriesChurn []SeriesChurnStatus `json:"seriesChurn"`
+
+	// Label names whose posting lists hold the most head index memory
+	IndexLabels []LabelMemoryStatus `json:"indexLabels"`
+}
+
+// LabelMemoryStatus reports the head index memory of a label name.
+type LabelMemoryStatus struct {
+	Name      string `json:"name"`
+	Bytes     int64  `json:"bytes"`
+	OverLimit bool   `json:"overLimit"` // At or over the label postings limit
+}
+
+// newLabelMemoryStatus converts storage label memory.
+func newLabelMemoryStatus(labels []storage.LabelMemory) []LabelMemoryStatus {
+	result := make([]LabelMemoryStatus, len(labels))
+	for i, l := range labels {
+		result[i] = LabelMemoryStatus{Name: l.Name, Bytes: l.Bytes, OverLimit: l.OverLimit}
+	}
+	return result
+}
+
+// SeriesChurnStatus reports the metric names churning the most series over
+// a window.
+type SeriesChurnStatus struct {
+	WindowSeconds float64             `json:"windowSeconds"`
+	Metrics       []MetricChurnStatus `json:"metrics"`
+}
+
+// MetricChurnStatus reports the series churn of a metric name.
+type MetricChurnStatus struct {
+	Metric           string  `json:"metric"`
+	Created          int64   `json:"created"`
+	Ended            int64   `json:"ended"`
+	CreatedPerSecond float64 `json:"createdPerSecond"`
+	EndedPerSecond   float64 `json:"endedPerSecond"`
+}
+
+// newSeriesChurnStatus converts storage series churn.
+func newSeriesChurnStatus(churn []storage.SeriesChurn) []SeriesChurnStatus {
+	result := make([]SeriesChurnStatus, 0, len(churn))
+	for _, c := range churn {
+		seconds := c.Window.Seconds()
+		status := SeriesChurnStatus{
+			WindowSeconds: seconds,
+			Metrics:       make([]MetricChurnStatus, 0, len(c.Metrics)),
+		}
+		for _, m := range c.Metrics {
+			status.Metrics = append(status.Metrics, MetricChurnStatus{
+				Metric:           m.Metric,
+				Created:          m.Created,
+				Ended:            m.Ended,
+				CreatedPerSecond: float64(m.Created) / seconds,
+				EndedPerSecond:   float64(m.Ended) / seconds,
+			})
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+// WriteQueueStatus reports the async write queue.
+type WriteQueueStatus struct {
+	Depth         int    `json:"depth"`    // Requests waiting to be written
+	Capacity      int    `json:"capacity"` // Requests the queue holds
+	Overflow      string `json:"overflow"` // block or reject
+	Enqueued      int64  `json:"enqueued"`
+	Rejected      int64  `json:"rejected"`      // Turned away because the queue was full
+	Failed        int64  `json:"failed"`        // Queued requests not fully written
+	FailedSamples int64  `json:"failedSamples"` // Samples of queued requests not written
+}
+
+// ReplayStatus reports the progress of WAL replay on startup.
+type ReplayStatus struct {
+	SegmentsDone   int     `json:"segmentsDone"`
+	SegmentsTotal  int     `json:"segmentsTotal"`
+	EntriesApplied int64   `json:"entriesApplied"`
+	SamplesSkipped int64   `json:"samplesSkipped"`
+	SkippedThrough int64   `json:"skippedThrough,omitempty"` // Samples at or before this time (Unix ms) were skipped as flushed
+	ElapsedSeconds float64 `json:"elapsedSeconds"`
+	Done           bool    `json:"done"`
+}
+
+// newReplayStatus converts storage replay progress.
+func newReplayStatus(p storage.ReplayProgress) ReplayStatus {
+	return ReplayStatus{
+		SegmentsDone:   p.SegmentsDone,
+		SegmentsTotal:  p.SegmentsTotal,
+		EntriesApplied: p.EntriesApplied,
+		SamplesSkipped: p.SamplesSkipped,
+		SkippedThrough: p.SkippedThrough,
+		ElapsedSeconds: p.Elapsed.Seconds(),
+		Done:           p.Done,
+	}
+}
+
+// IngestStatsResponse represents the response to a status/ingest query.
+type IngestStatsResponse struct {
+	Status string           `json:"status"`
+	Data   *IngestStatsData `json:"data,omitempty"`
+}
+
+// IngestStatsData ranks the heaviest writers by metric name and tenant.
+type IngestStatsData struct {
+	Tracked  int                 `json:"tracked"`  // Metric name and tenant pairs tracked
+	Capacity int                 `json:"capacity"` // Most pairs tracked at once
+	Writers  []IngestWriterStats `json:"writers"`
+}
+
+// IngestWriterStats are the writes of one metric name and tenant since
+// startup.
+type IngestWriterStats struct {
+	Metric   string `json:"metric"`
+	Tenant   string `json:"tenant,omitempty"`
+	Accepted int64  `json:"accepted"`
+	Rejected int64  `json:"rejected"`
+	Series   int64  `json:"series"`
+	Error    int64  `json:"error,omitempty"` // Most accepted+rejected may overcount
+}
+
+// WALStatusResponse represents the response to a status/wal query.
+type WALStatusResponse struct {
+	Status string         `json:"status"`
+	Data   *WALStatusData `json:"data,omitempty"`
+}
+
+// WALStatusData describes the WAL segments and the last fsync.
+type WALStatusData struct {
+	Segments    []WALSegmentStatus `json:"segments"`
+	Size        int64              `json:"size"`
+	Entries     int                `json:"entries"`
+	OldestEntry int64              `json:"oldestEntry,omitempty"` // Unix ms
+
+	// Estimated replay time at the rate of the replay on startup
+	ReplayEstimateSeconds float64 `json:"replayEstimateSeconds"`
+
+	LastSyncSeconds float64 `json:"lastSyncSeconds"`
+	LastSyncTime    int64   `json:"lastSyncTime,omitempty"` // Unix ms
+}
+
+// WALSegmentStatus describes a WAL segment.
+type WALSegmentStatus struct {
+	Name    string `json:"name"`
+	ULID    string `json:"ulid"`
+	Current bool   `json:"current"`
+	Size    int64  `json:"size"`
+	Entries int    `json:"entries"`
+	Created int64  `json:"created"`           // Unix ms
+	MinTime int64  `json:"minTime,omitempty"` // Oldest entry, Unix ms
+	MaxTime int64  `json:"maxTime,omitempty"` // Newest entry, Unix ms
+}
+
+// newWALStatusData converts storage WAL statistics.
+func newWALStatusData(stats storage.WALStats) *WALStatusData {
+	data := &WALStatusData{
+		Segments:              make([]WALSegmentStatus, len(stats.Segments)),
+		Size:                  stats.Size,
+		Entries:               stats.Entries,
+		OldestEntry:           stats.OldestEntry,
+		ReplayEstimateSeconds: stats.ReplayEstimate.Seconds(),
+		LastSyncSeconds:       stats.LastSync.Seconds(),
+	}
+	if !stats.LastSyncTime.IsZero() {
+		data.LastSyncTime = stats.LastSyncTime.UnixMilli()
+	}
+	for i, seg := range stats.Segments {
+		data.Segments[i] = WALSegmentStatus{
+			Name:    seg.Name,
+			ULID:    seg.Meta.ULID,
+			Current: seg.Current,
+			Size:    seg.Meta.Size,
+			Entries: seg.Meta.Entries,
+			Created: seg.Meta.Created,
+			MinTime: seg.Meta.MinTime,
+			MaxTime: seg.Meta.MaxTime,
+		}
+	}
+	return data
+}
+
+// CardinalityResponse represents the response to a status/cardinality
+// query.
+type CardinalityResponse struct {
+	Status string           `json:"status"`
+	Data   *CardinalityData `json:"data,omitempty"`
+}
+
+// CardinalityData breaks the series matching a selector down by label
+// value.
+type CardinalityData struct {
+	Series  int64              `json:"series"`
+	Samples int64              `json:"samples"` // Estimated for blocks
+	Labels  []LabelCardinality `json:"labels"`
+}
+
+// LabelCardinality is how a label's values spread over the matching
+// series.
+type LabelCardinality struct {
+	Name           string            `json:"name"`
+	Series         int64             `json:"series"`
+	DistinctValues int               `json:"distinctValues"`
+	Values         []LabelValueCount `json:"values"`
+}
+
+// LabelValueCount is the number of matching series with a label value.
+type LabelValueCount struct {
+	Value  string `json:"value"`
+	Series int64  `json:"series"`
+}
+
+// BuildInfoResponse represents the response to a status/buildinfo query.
+type BuildInfoResponse struct {
+	Status string         `json:"status"`
+	Data   *BuildInfoData `json:"data,omitempty"`
+}
+
+// BuildInfoData describes the build of the server and what it has enabled.
+type BuildInfoData struct {
+	Version   string          `json:"version"`
+	Commit    string          `json:"commit,omitempty"`
+	BuildDate string          `json:"buildDate,omitempty"`
+	GoVersion string          `json:"goVersion"`
+	Features  map[string]bool `json:"features"`
+	Tenant    string          `json:"tenant,omitempty"` // Set when multi-tenancy is enabled
+	Formats   FormatVersions  `json:"formats"`
+}
+
+// FormatVersions are the storage format versions a server writes, and the
+// oldest block format it reads.
+type FormatVersions struct {
+	Block    int    `json:"block"`
+	MinBlock int    `json:"minBlock"`
+	Index    uint32 `json:"index"`
+	WAL      int    `json:"wal"`
+}
+
+// StartupStatusResponse is the status reported while the database opens.
+type StartupStatusResponse struct {
+	Status string             `json:"status"`
+	Data   *StartupStatusData `json:"data,omitempty"`
+}
+
+// StartupStatusData reports WAL replay progress while the database opens.
+type StartupStatusData struct {
+	Starting bool         `json:"starting"`
+	Replay   ReplayStatus `json:"replay"`
+}
+
+// DurationSummary summarizes the durations of an operation, in seconds.
+type DurationSummary struct {
+	Count int64   `json:"count"`
+	P50   float64 `json:"p50"`
+	P99   float64 `json:"p99"`
+}
+
+// newDurationSummary converts storage duration statistics.
+func newDurationSummary(stats storage.DurationStats) DurationSummary {
+	return DurationSummary{
+		Count: stats.Count,
+		P50:   stats.P50.Seconds(),
+		P99:   stats.P99.Seconds(),
+	}
+}
+
+// AdminResponse represents the response to an admin action that returns
+// no data.
+type AdminResponse struct {
+	Status    string    `json:"status"`
+	ErrorType ErrorType `json:"errorType,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// CompactionPlanResponse represents the response to a compaction plan query.
+type CompactionPlanResponse struct {
+	Status    string              `json:"status"`
+	Data      *CompactionPlanData `json:"data,omitempty"`
+	ErrorType ErrorType           `json:"errorType,omitempty"`
+	Error     string              `json:"error,omitempty"`
+}
+
+// CompactionPlanData lists the merges the next compaction cycle would perform.
+type CompactionPlanData struct {
+	Merges []PlannedMerge `json:"merges"`
+}
+
+// PlannedMerge is a group of source blocks merged into one or more output blocks.
+type PlannedMerge struct {
+	FromLevel    int            `json:"fromLevel"`
+	ToLevel      int            `json:"toLevel"`
+	Vertical     bool           `json:"vertical"` // Merges overlapping blocks; levels are unset
+	MinTime      int64          `json:"minTime"`
+	MaxTime      int64          `json:"maxTime"`
+	Sources      []string       `json:"sources"`
+	SourceBlocks []BlockSummary `json:"sourceBlocks"`
+	Outputs      []PlannedBlock `json:"outputs"`
+}
+
+// BlockSummary is a block's time range, size and meta labels.
+type BlockSummary struct {
+	Block   string            `json:"block"`
+	MinTime int64             `json:"minTime"`
+	MaxTime int64             `json:"maxTime"`
+	Bytes   int64             `json:"bytes"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// newBlockSummaries converts storage block summaries.
+func newBlockSummaries(blocks []storage.BlockSummary) []BlockSummary {
+	summaries := make([]BlockSummary, 0, len(blocks))
+	for _, block := range blocks {
+		summaries = append(summaries, BlockSummary{
+			Block:   block.ULID,
+			MinTime: block.MinTime,
+			MaxTime: block.MaxTime,
+			Bytes:   block.Size,
+			Labels:  block.Labels,
+		})
+	}
+	return summaries
+}
+
+// RetentionPlanResponse represents the response to a retention plan query.
+type RetentionPlanResponse struct {
+	Status    string             `json:"status"`
+	Data      *RetentionPlanData `json:"data,omitempty"`
+	ErrorType ErrorType          `json:"errorType,omitempty"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// RetentionPlanData lists the blocks the next retention cleanup would delete.
+type RetentionPlanData struct {
+	Enabled          bool           `json:"enabled"`
+	MaxAgeSeconds    float64        `json:"maxAgeSeconds"`
+	Cutoff           int64          `json:"cutoff"` // Blocks ending before this time (Unix ms) are deleted
+	TotalBlocks      int            `json:"totalBlocks"`
+	TotalBytes       int64          `json:"totalBytes"`
+	ReclaimableBytes int64          `json:"reclaimableBytes"`
+	Blocks           []BlockSummary `json:"blocks"`
+	Budgets          []BudgetUsage  `json:"budgets,omitempty"`
+}
+
+// BudgetUsage is a retention budget's usage and the blocks over it, which
+// the next cleanup deletes, or rewrites without the budget's series.
+type BudgetUsage struct {
+	Blocks     string         `json:"blocks"`           // Block label matchers
+	Series     string         `json:"series,omitempty"` // Series matchers, if the budget counts only some series
+	MaxSeries  int64          `json:"maxSeries,omitempty"`
+	MaxSamples int64          `json:"maxSamples,omitempty"`
+	NumSeries  int64          `json:"numSeries"`
+	NumSamples int64          `json:"numSamples"`
+	OverBudget []BlockSummary `json:"overBudget"`
+}
+
+// newBudgetUsages converts storage retention budget usages.
+func newBudgetUsages(usages []storage.RetentionBudgetUsage) []BudgetUsage {
+	var result []BudgetUsage
+	for _, usage := range usages {
+		u := BudgetUsage{
+			Blocks:     usage.Budget.BlockMatchers.String(),
+			MaxSeries:  usage.Budget.MaxSeries,
+			MaxSamples: usage.Budget.MaxSamples,
+			NumSeries:  usage.Series,
+			NumSamples: usage.Samples,
+			OverBudget: newBlockSummaries(usage.OverBudget),
+		}
+		if len(usage.Budget.SeriesMatchers) > 0 {
+			u.Series = usage.Budget.SeriesMatchers.String()
+		}
+		result = append(result, u)
+	}
+	return result
+}
+
+// PlannedBlock is one output block of a planned merge.
+type PlannedBlock struct {
+	NumSeries      int   `json:"numSeries"`
+	EstimatedBytes int64 `json:"estimatedBytes"`
+}
+
+// BackupResponse represents the response to creating a backup.
+type BackupResponse struct {
+	Status    string      `json:"status"`
+	Data      *BackupInfo `json:"data,omitempty"`
+	ErrorType ErrorType   `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// BackupsResponse represents the response listing backups.
+type BackupsResponse struct {
+	Status    string       `json:"status"`
+	Data      []BackupInfo `json:"data"`
+	ErrorType ErrorType    `json:"errorType,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// BackupInfo describes a backup available for download.
+type BackupInfo struct {
+	ID        string `json:"id"`
+	CreatedAt int64  `json:"createdAt"` // Unix milliseconds
+	Size      int64  `json:"size"`      // Archive size in bytes
+	Blocks    int    `json:"blocks"`
+	Downloads int    `json:"downloads"`           // Downloads in progress
+	ExpiresAt int64  `json:"expiresAt,omitempty"` // Unix milliseconds; unset while downloading
+}
+
+// HoldsResponse represents the response to a block hold listing.
+type HoldsResponse struct {
+	Status    string      `json:"status"`
+	Data      []BlockHold `json:"data"`
+	ErrorType ErrorType   `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// BlockHold is a block protected from retention and compaction.
+type BlockHold struct {
+	Block     string `json:"block"`
+	MinTime   int64  `json:"minTime"`
+	MaxTime   int64  `json:"maxTime"`
+	Reason    string `json:"reason,omitempty"`
+	CreatedAt int64  `json:"createdAt,omitempty"`
+}
+
+// HoldChangeResponse represents the response to placing or lifting holds.
+// Data lists the IDs of the affected blocks.
+type HoldChangeResponse struct {
+	Status    string    `json:"status"`
+	Data      []string  `json:"data"`
+	ErrorType ErrorType `json:"errorType,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// AuditResponse is the response of the audit log endpoint.
+type AuditResponse struct {
+	Status    string       `json:"status"`
+	Data      []AuditEntry `json:"data,omitempty"`
+	ErrorType ErrorType    `json:"errorType,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// QueryLogResponse represents the response listing logged queries.
+type QueryLogResponse struct {
+	Status    string          `json:"status"`
+	Data      []QueryLogEntry `json:"data,omitempty"`
+	ErrorType ErrorType       `json:"errorType,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// RollupsResponse represents the response listing rollup rules.
+type RollupsResponse struct {
+	Status    string       `json:"status"`
+	Data      []RollupRule `json:"data"`
+	ErrorType ErrorType    `json:"errorType,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// RollupRule is a rollup rule with its evaluation state.
+type RollupRule struct {
+	rollup.Rule
+	NextWindow     int64  `json:"nextWindow"`
+	LastEvaluation int64  `json:"lastEvaluation,omitempty"`
+	LastError      string `json:"lastError,omitempty"`
+	SamplesWritten int64  `json:"samplesWritten"`
 }
 
 // HealthResponse represents the response to a health check.
 type HealthResponse struct {
 	Status  string `json:"status"`
 	Message string `json:"message,omitempty"`
+
+	// State of the storage; unset by the startup server
+	LastFlush      int64  `json:"lastFlush,omitempty"`     // Unix milliseconds of the last successful flush
+	FlushFailures  int64  `json:"flushFailures,omitempty"` // Flushes failed in a row
+	LastFlushError string `json:"lastFlushError,omitempty"`
+	WALFailures    int64  `json:"walFailures,omitempty"` // WAL appends failed in a row
+	LastWALError   string `json:"lastWALError,omitempty"`
 }
 
 // ToSeriesSamples converts API types to internal series and samples.
@@ -127,3 +721,21 @@ func (ts *TimeSeries) ToSeriesSamples() (*series.Series, []series.Sample) {
 
 	return s, samples
 }
+
+// ToMetricMetadata converts API metadata to the storage representation.
+func (md *MetricMetadata) ToMetricMetadata() storage.MetricMetadata {
+	return storage.MetricMetadata{
+		Type: storage.ParseMetricType(md.Type),
+		Help: md.Help,
+		Unit: md.Unit,
+	}
+}
+
+// newMetadataEntry converts storage metadata to the API representation.
+func newMetadataEntry(md storage.MetricMetadata) MetadataEntry {
+	return MetadataEntry{
+		Type: string(md.Type),
+		Help: md.Help,
+		Unit: md.Unit,
+	}
+}
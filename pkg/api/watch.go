@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+)
+
+// watchHeartbeatInterval is how often an idle watch stream sends a keepalive
+// comment, so proxies don't close it
+const watchHeartbeatInterval = 15 * time.Second
+
+// handleWatch streams samples for series matching the match[] selectors as
+// they are ingested, as server-sent events. Each "samples" event carries
+// one series in the same shape as a range query result. A "dropped" event
+// reports the total number of updates lost because the client fell behind.
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	matches := r.URL.Query()["match[]"]
+	if len(matches) == 0 {
+		s.writeError(w, ErrorBadData, "at least one match[] parameter is required")
+		return
+	}
+
+	matcherSets := make([]index.Matchers, 0, len(matches))
+	for _, match := range matches {
+		matchers, err := parseMatchers(match)
+		if err != nil {
+			s.writeError(w, ErrorBadData, fmt.Sprintf("Invalid matcher: %v", err))
+			return
+		}
+		matcherSets = append(matcherSets, matchers)
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeError(w, ErrorInternal, "streaming not supported")
+		return
+	}
+
+	sub, err := s.db.Watch(0, matcherSets...)
+	if err != nil {
+		s.writeError(w, classifyError(err), fmt.Sprintf("Failed to watch: %v", err))
+		return
+	}
+	defer sub.Close()
+
+	// Watches are long-lived; lift the server's write timeout for this request
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// Tell the client the subscription is live
+	fmt.Fprint(w, ": watching\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(watchHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	var reportedDrops int64
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-s.watchDone:
+			fmt.Fprint(w, "event: end\ndata: {}\n\n")
+			flusher.Flush()
+			return
+
+		case update, ok := <-sub.Updates():
+			if !ok {
+				// Database closed
+				fmt.Fprint(w, "event: end\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+
+			values := make([][]interface{}, 0, len(update.Samples))
+			for _, sample := range update.Samples {
+				values = append(values, []interface{}{sample.Timestamp, fmt.Sprintf("%f", sample.Value)})
+			}
+			payload, err := json.Marshal(QueryResult{Metric: update.Labels, Values: values})
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: samples\ndata: %s\n\n", payload); err != nil {
+				return
+			}
+
+			if dropped := sub.Dropped(); dropped > reportedDrops {
+				fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", dropped)
+				reportedDrops = dropped
+			}
+			flusher.Flush()
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
+)
+
+// Reasons a write rejects samples for, as reported in WriteResponse
+const (
+	ReasonTooOld        = "too_old"        // Older than the retention horizon
+	ReasonTooNew        = "too_new"        // Too far in the future
+	ReasonOutOfOrder    = "out_of_order"   // Older than the out-of-order window allows
+	ReasonDuplicate     = "duplicate"      // Timestamp already written with another value
+	ReasonInvalidLabels = "invalid_labels" // Labels failed validation
+	ReasonInvalidSample = "invalid_sample" // E.g. a series without samples
+	ReasonNotWritten    = "not_written"    // Valid, but the write failed as a whole
+)
+
+// maxSeriesReported caps the series listed in a write response
+const maxSeriesReported = 100
+
+// rejectReason returns the reason err rejects samples for
+func rejectReason(err error) string {
+	switch {
+	case errors.Is(err, storage.ErrSampleTooOld):
+		return ReasonTooOld
+	case errors.Is(err, storage.ErrSampleTooNew):
+		return ReasonTooNew
+	case errors.Is(err, storage.ErrOutOfOrderSample):
+		return ReasonOutOfOrder
+	case errors.Is(err, storage.ErrDuplicateSample):
+		return ReasonDuplicate
+	case storage.IsValidationError(err):
+		return ReasonInvalidLabels
+	case errors.Is(err, storage.ErrInvalidSample):
+		return ReasonInvalidSample
+	default:
+		return ReasonNotWritten
+	}
+}
+
+// convertTimestamps converts the sample timestamps of req from precision
+// to Unix milliseconds in place. It fails on the first implausible
+// timestamp, naming its series, so a client sending the wrong unit has
+// nothing written rather than data far in the past or future.
+func convertTimestamps(req *WriteRequest, precision string) error {
+	if precision == PrecisionUnchecked {
+		return nil
+	}
+	for i := range req.Timeseries {
+		ts := &req.Timeseries[i]
+		for j := range ts.Samples {
+			ms, err := timestampMillis(ts.Samples[j].Timestamp, precision)
+			if err != nil {
+				series, _ := ts.ToSeriesSamples()
+				return fmt.Errorf("series %s: %w", series, err)
+			}
+			ts.Samples[j].Timestamp = ms
+		}
+	}
+	return nil
+}
+
+// appendTimeSeries adds a write request time series to an appender.
+// Samples outside the timestamp bounds are skipped and counted in the
+// result; any other error rejects the whole series and is returned.
+func appendTimeSeries(app storage.Appender, ts TimeSeries) (SeriesWriteResult, error) {
+	series, samples := ts.ToSeriesSamples()
+	result := SeriesWriteResult{Labels: series.Labels}
+	reject := func(n int, err error) {
+		result.Rejected += n
+		if result.Reason == "" {
+			result.Reason = rejectReason(err)
+			result.Error = err.Error()
+		}
+	}
+
+	if len(samples) == 0 {
+		reject(0, storage.ErrInvalidSample)
+		return result, storage.ErrInvalidSample
+	}
+	for _, sample := range samples {
+		err := app.Append(series.Labels, sample.Timestamp, sample.Value)
+		if errors.Is(err, storage.ErrSampleTooOld) || errors.Is(err, storage.ErrSampleTooNew) {
+			reject(1, err)
+			continue
+		}
+		if err != nil {
+			result.Accepted = 0
+			result.Rejected = 0
+			reject(len(samples), err)
+			return result, err
+		}
+		result.Accepted++
+	}
+	return result, nil
+}
+
+// write writes the time series of req in one transaction, so a failed
+// request leaves no partial data behind. Samples outside the timestamp
+// bounds are the exception: they are left out and reported once the rest
+// is written.
+func (s *Server) write(req WriteRequest) *writeResult {
+	app := s.db.Appender()
+	result := newWriteResult(req)
+	for _, ts := range req.Timeseries {
+		series, err := appendTimeSeries(app, ts)
+		if err != nil && classifyError(err) != ErrorBadData {
+			app.Rollback()
+			result.fail(err)
+			return result
+		}
+		result.add(series, err)
+	}
+
+	if result.failed {
+		app.Rollback()
+		result.rollBack()
+		result.errType = ErrorBadData
+		return result
+	}
+	if err := app.Commit(); err != nil {
+		if classifyError(err) == ErrorBadData {
+			result.rejectCommit(err)
+		} else {
+			result.fail(err)
+		}
+		return result
+	}
+	if result.rejected > 0 {
+		result.errType = ErrorBadData
+	}
+	return result
+}
+
+// validateTimeSeries checks a write request time series without writing
+// it, for writes that are queued. Its samples are counted as accepted if
+// it is valid.
+func (s *Server) validateTimeSeries(ts TimeSeries) (SeriesWriteResult, error) {
+	series, samples := ts.ToSeriesSamples()
+	result := SeriesWriteResult{Labels: series.Labels, Accepted: len(samples)}
+	err := s.db.ValidateSeries(series.Labels)
+	if err == nil && len(samples) == 0 {
+		err = storage.ErrInvalidSample
+	}
+	if err != nil {
+		result.Accepted = 0
+		result.Rejected = len(samples)
+		result.Reason = rejectReason(err)
+		result.Error = err.Error()
+	}
+	return result, err
+}
+
+// writeResult accumulates the outcome of a write request
+type writeResult struct {
+	total    int // Samples in the request
+	accepted int
+	rejected int
+	reasons  map[string]int
+	series   []SeriesWriteResult
+	failed   bool      // Whether a series failed the write as a whole
+	err      error     // The error reported in the response
+	errType  ErrorType // Empty if the write was fully stored
+}
+
+// newWriteResult returns an empty result for req
+func newWriteResult(req WriteRequest) *writeResult {
+	r := &writeResult{reasons: make(map[string]int)}
+	for _, ts := range req.Timeseries {
+		r.total += len(ts.Samples)
+	}
+	return r
+}
+
+// add records the outcome of appending one series; err is the error that
+// rejected the whole series, if any
+func (r *writeResult) add(s SeriesWriteResult, err error) {
+	r.accepted += s.Accepted
+	r.rejected += s.Rejected
+	if s.Reason == "" {
+		return
+	}
+	if s.Rejected > 0 {
+		r.reasons[s.Reason] += s.Rejected
+	}
+	if err != nil && !r.failed {
+		r.failed = true
+		r.err = err
+	}
+	if r.err == nil {
+		r.err = errors.New(s.Error)
+	}
+	if len(r.series) < maxSeriesReported {
+		r.series = append(r.series, s)
+	}
+}
+
+// rollBack records that the samples accepted so far were not written after
+// all
+func (r *writeResult) rollBack() {
+	if r.accepted > 0 {
+		r.reasons[ReasonNotWritten] += r.accepted
+	}
+	r.rejected += r.accepted
+	r.accepted = 0
+	for i := range r.series {
+		r.series[i].Rejected += r.series[i].Accepted
+		r.series[i].Accepted = 0
+	}
+}
+
+// fail records that err failed the write, leaving every sample unwritten
+func (r *writeResult) fail(err error) {
+	r.rollBack()
+	if unseen := r.total - r.rejected; unseen > 0 {
+		r.reasons[ReasonNotWritten] += unseen
+		r.rejected = r.total
+	}
+	r.err = err
+	r.errType = classifyError(err)
+}
+
+// rejectCommit records the series a commit rejected with err; the rest of
+// the commit was not written either
+func (r *writeResult) rejectCommit(err error) {
+	r.rollBack()
+	r.errType = ErrorBadData
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+
+	r.err = nil
+	for _, e := range errs {
+		var se *storage.SeriesError
+		if !errors.As(e, &se) {
+			continue
+		}
+		reason := rejectReason(se.Err)
+		r.reasons[ReasonNotWritten] -= se.Samples
+		r.reasons[reason] += se.Samples
+		if r.err == nil {
+			r.err = se
+		}
+		r.listCommitError(se, reason)
+	}
+	if r.reasons[ReasonNotWritten] == 0 {
+		delete(r.reasons, ReasonNotWritten)
+	}
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// listCommitError lists a series a commit rejected, or updates its entry if
+// the series is already listed for samples out of the timestamp bounds
+func (r *writeResult) listCommitError(se *storage.SeriesError, reason string) {
+	for i := range r.series {
+		if maps.Equal(r.series[i].Labels, se.Series.Labels) {
+			r.series[i].Reason = reason
+			r.series[i].Error = se.Err.Error()
+			return
+		}
+	}
+	if len(r.series) < maxSeriesReported {
+		r.series = append(r.series, SeriesWriteResult{
+			Labels:   se.Series.Labels,
+			Rejected: se.Samples,
+			Reason:   reason,
+			Error:    se.Err.Error(),
+		})
+	}
+}
+
+// writeWriteResponse reports a write that was not fully stored. Remote
+// write clients retry on 5xx and drop on 4xx, so only server-side failures
+// get a 5xx status.
+func (s *Server) writeWriteResponse(w http.ResponseWriter, r *writeResult) {
+	setErrorHeaders(w, r.errType)
+	s.writeJSONResponse(w, WriteResponse{
+		Status:    "error",
+		ErrorType: r.errType,
+		Error:     fmt.Sprintf("%d of %d samples rejected: %v", r.rejected, r.total, r.err),
+		Accepted:  r.accepted,
+		Rejected:  r.rejected,
+		Reasons:   r.reasons,
+		Series:    r.series,
+	}, r.errType.StatusCode())
+}
@@ -0,0 +1,294 @@
+// Package cdc streams accepted samples out of the write-ahead log to
+// external systems (change data capture). A Streamer tails the WAL and
+// delivers batches of records to a Sink, checkpointing its WAL position
+// after every acknowledged batch, so delivery is at-least-once and survives
+// restarts without clients having to write to two places.
+package cdc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
+	"github.com/therealutkarshpriyadarshi/time/pkg/wal"
+)
+
+const (
+	// CheckpointDir is the directory in the data directory holding sink checkpoints
+	CheckpointDir = "cdc"
+
+	// DefaultBatchSize is the maximum number of records per Send
+	DefaultBatchSize = 500
+
+	// DefaultPollInterval is how often the WAL is checked for new entries
+	// once the streamer has caught up
+	DefaultPollInterval = time.Second
+
+	// DefaultRetryBackoff is the wait before resending a failed batch
+	DefaultRetryBackoff = 5 * time.Second
+)
+
+// Record is one accepted write: samples for a single series
+type Record struct {
+	Labels  map[string]string
+	Samples []series.Sample
+}
+
+// Sink receives records streamed from the WAL. A streamer calls it from a
+// single goroutine.
+type Sink interface {
+	// Send delivers a batch of records. A nil error acknowledges the whole
+	// batch; on error the same batch is sent again, so sinks may see
+	// duplicates.
+	Send(records []Record) error
+
+	// Close releases the sink's resources
+	Close() error
+}
+
+// Options configures a Streamer
+type Options struct {
+	// WALDir is the WAL directory to tail
+	WALDir string
+
+	// CheckpointPath stores the WAL position of the last acknowledged batch.
+	// Without a checkpoint, streaming starts at the current end of the WAL.
+	CheckpointPath string
+
+	BatchSize    int
+	PollInterval time.Duration
+	RetryBackoff time.Duration
+}
+
+// DefaultOptions returns options for streaming the WAL of a data directory.
+// name identifies the sink's checkpoint.
+func DefaultOptions(dataDir, name string) *Options {
+	return &Options{
+		WALDir:         filepath.Join(dataDir, storage.DefaultWALDir),
+		CheckpointPath: filepath.Join(dataDir, CheckpointDir, name+".json"),
+		BatchSize:      DefaultBatchSize,
+		PollInterval:   DefaultPollInterval,
+		RetryBackoff:   DefaultRetryBackoff,
+	}
+}
+
+// Stats tracks streamer statistics
+type Stats struct {
+	Records    atomic.Int64
+	Samples    atomic.Int64
+	Batches    atomic.Int64
+	SendErrors atomic.Int64
+
+	// Gaps counts WAL segments that were truncated before being streamed
+	Gaps atomic.Int64
+}
+
+// Streamer tails the WAL and delivers its samples to a sink
+type Streamer struct {
+	sink   Sink
+	tailer *wal.Tailer
+
+	checkpointPath string
+	batchSize      int
+	pollInterval   time.Duration
+	retryBackoff   time.Duration
+
+	// pending is a batch read from the WAL but not yet acknowledged, and
+	// pendingPos the WAL position just after it
+	pending    []Record
+	pendingPos wal.Position
+
+	stats   Stats
+	running atomic.Bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	// runMu is held while Run is active, so Stop can wait for it
+	runMu sync.Mutex
+}
+
+// NewStreamer creates a streamer delivering to sink, resuming from the
+// checkpoint if one exists
+func NewStreamer(sink Sink, opts *Options) (*Streamer, error) {
+	if sink == nil {
+		return nil, fmt.Errorf("cdc: sink cannot be nil")
+	}
+	if opts == nil || opts.WALDir == "" {
+		return nil, fmt.Errorf("cdc: WAL directory is required")
+	}
+
+	pos, err := loadCheckpoint(opts.CheckpointPath)
+	if errors.Is(err, os.ErrNotExist) {
+		pos, err = wal.EndPosition(opts.WALDir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cdc: %w", err)
+	}
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Streamer{
+		sink:           sink,
+		tailer:         wal.NewTailer(opts.WALDir, pos),
+		checkpointPath: opts.CheckpointPath,
+		batchSize:      batchSize,
+		pollInterval:   opts.PollInterval,
+		retryBackoff:   opts.RetryBackoff,
+		ctx:            ctx,
+		cancel:         cancel,
+	}, nil
+}
+
+// Run streams until Stop is called
+func (s *Streamer) Run() error {
+	if s.running.Swap(true) {
+		return fmt.Errorf("cdc: streamer already running")
+	}
+	defer s.running.Store(false)
+
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	for s.ctx.Err() == nil {
+		n, err := s.Poll()
+		wait := time.Duration(0)
+		switch {
+		case err != nil:
+			fmt.Printf("cdc: %v\n", err)
+			wait = s.retryBackoff
+		case n == 0:
+			wait = s.pollInterval
+		}
+
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-s.ctx.Done():
+			}
+		}
+	}
+	return nil
+}
+
+// Stop stops the streamer, waiting for an in-flight batch, and closes the
+// sink and the WAL tailer
+func (s *Streamer) Stop() error {
+	s.cancel()
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	s.tailer.Close()
+	return s.sink.Close()
+}
+
+// Poll reads up to one batch of new WAL entries, sends it and checkpoints
+// the position. It returns the number of records delivered. A batch that
+// fails to send is kept and retried by the next call.
+func (s *Streamer) Poll() (int, error) {
+	if len(s.pending) == 0 {
+		if err := s.readBatch(); err != nil {
+			return 0, err
+		}
+		if len(s.pending) == 0 {
+			return 0, nil
+		}
+	}
+
+	if err := s.sink.Send(s.pending); err != nil {
+		s.stats.SendErrors.Add(1)
+		return 0, fmt.Errorf("send failed: %w", err)
+	}
+
+	n := len(s.pending)
+	s.stats.Records.Add(int64(n))
+	s.stats.Batches.Add(1)
+	for _, record := range s.pending {
+		s.stats.Samples.Add(int64(len(record.Samples)))
+	}
+	s.pending = nil
+
+	if err := saveCheckpoint(s.checkpointPath, s.pendingPos); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// readBatch fills the pending batch from the WAL
+func (s *Streamer) readBatch() error {
+	for len(s.pending) < s.batchSize {
+		entry, err := s.tailer.Next()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, wal.ErrSegmentGap) {
+			s.stats.Gaps.Add(1)
+			fmt.Printf("cdc: %v; samples were skipped\n", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		s.pending = append(s.pending, Record{Labels: entry.Series.Labels, Samples: entry.Samples})
+		s.pendingPos = s.tailer.Position()
+	}
+	return nil
+}
+
+// GetStats returns streamer statistics
+func (s *Streamer) GetStats() *Stats {
+	return &s.stats
+}
+
+// loadCheckpoint reads a saved WAL position
+func loadCheckpoint(path string) (wal.Position, error) {
+	var pos wal.Position
+	if path == "" {
+		return pos, os.ErrNotExist
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return pos, err
+	}
+	if err := json.Unmarshal(data, &pos); err != nil {
+		return pos, fmt.Errorf("failed to parse checkpoint: %w", err)
+	}
+	return pos, nil
+}
+
+// saveCheckpoint atomically writes a WAL position
+func saveCheckpoint(path string, pos wal.Position) error {
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+
+	data, err := json.Marshal(pos)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to rename checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package cdc
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+	"github.com/therealutkarshpriyadarshi/time/pkg/wal"
+)
+
+// memorySink collects records, failing while fail is set
+type memorySink struct {
+	records []Record
+	fail    bool
+}
+
+func (m *memorySink) Send(records []Record) error {
+	if m.fail {
+		return errors.New("sink unavailable")
+	}
+	m.records = append(m.records, records...)
+	return nil
+}
+
+func (m *memorySink) Close() error { return nil }
+
+func TestStreamer(t *testing.T) {
+	dir := t.TempDir()
+	walDir := filepath.Join(dir, "wal")
+
+	w, err := wal.Open(walDir, nil)
+	if err != nil {
+		t.Fatalf("failed to open WAL: %v", err)
+	}
+	defer w.Close()
+
+	appendValue := func(host string, v float64) {
+		s := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": host})
+		if err := w.Append(s, []series.Sample{{Timestamp: 1000, Value: v}}); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+
+	// Written before the streamer existed, so not streamed
+	appendValue("old", 0)
+
+	opts := &Options{WALDir: walDir, CheckpointPath: filepath.Join(dir, CheckpointDir, "test.json"), BatchSize: 2}
+	sink := &memorySink{}
+	streamer, err := NewStreamer(sink, opts)
+	if err != nil {
+		t.Fatalf("NewStreamer failed: %v", err)
+	}
+
+	appendValue("a", 1)
+	appendValue("b", 2)
+	appendValue("c", 3)
+
+	// Batches are capped at BatchSize
+	if n, err := streamer.Poll(); err != nil || n != 2 {
+		t.Fatalf("Poll = %d, %v; want 2 records", n, err)
+	}
+
+	// A failed batch is kept and resent
+	sink.fail = true
+	if _, err := streamer.Poll(); err == nil {
+		t.Fatal("Poll succeeded with a failing sink")
+	}
+	appendValue("d", 4)
+	sink.fail = false
+	if n, err := streamer.Poll(); err != nil || n != 1 {
+		t.Fatalf("Poll = %d, %v; want the retried record", n, err)
+	}
+
+	var hosts []string
+	for _, record := range sink.records {
+		hosts = append(hosts, record.Labels["host"])
+	}
+	if len(hosts) != 3 || hosts[0] != "a" || hosts[2] != "c" {
+		t.Errorf("streamed hosts %v, want [a b c]", hosts)
+	}
+	if got := streamer.GetStats().SendErrors.Load(); got != 1 {
+		t.Errorf("SendErrors = %d, want 1", got)
+	}
+
+	// A new streamer resumes from the checkpoint
+	resumedSink := &memorySink{}
+	resumed, err := NewStreamer(resumedSink, opts)
+	if err != nil {
+		t.Fatalf("NewStreamer failed: %v", err)
+	}
+	if n, err := resumed.Poll(); err != nil || n != 1 || resumedSink.records[0].Labels["host"] != "d" {
+		t.Errorf("resumed Poll = %d, %v, %v; want host d", n, err, resumedSink.records)
+	}
+	if n, err := resumed.Poll(); err != nil || n != 0 {
+		t.Errorf("Poll after catching up = %d, %v; want 0", n, err)
+	}
+}
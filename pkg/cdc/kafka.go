@@ -0,0 +1,319 @@
+package cdc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+const (
+	// DefaultKafkaClientID identifies the producer to the brokers
+	DefaultKafkaClientID = "tsdb-cdc"
+
+	// DefaultKafkaTimeout bounds broker round trips and the produce timeout
+	DefaultKafkaTimeout = 10 * time.Second
+)
+
+// Kafka API keys and versions used by the producer. Produce v3 is the
+// first version taking v2 record batches (Kafka 0.11+).
+const (
+	kafkaAPIProduce      = 0
+	kafkaAPIMetadata     = 3
+	kafkaProduceVersion  = 3
+	kafkaMetadataVersion = 0
+
+	kafkaErrNone           = 0
+	kafkaNoTransactionalID = -1
+)
+
+// KafkaOptions configures a Kafka sink
+type KafkaOptions struct {
+	// Brokers are bootstrap broker addresses (host:port)
+	Brokers []string
+
+	// Topic receives one message per record
+	Topic string
+
+	ClientID string
+
+	// RequiredAcks is 1 to wait for the partition leader or -1 for all
+	// in-sync replicas
+	RequiredAcks int16
+
+	Timeout time.Duration
+}
+
+// DefaultKafkaOptions returns default options for producing to topic
+func DefaultKafkaOptions(brokers []string, topic string) *KafkaOptions {
+	return &KafkaOptions{
+		Brokers:      brokers,
+		Topic:        topic,
+		ClientID:     DefaultKafkaClientID,
+		RequiredAcks: 1,
+		Timeout:      DefaultKafkaTimeout,
+	}
+}
+
+// KafkaSink produces records to a Kafka topic. Each record becomes one
+// message keyed by its series, so a series always lands on the same
+// partition and keeps its order. The value is JSON shaped like a range
+// query result:
+//
+//	{"metric":{"__name__":"cpu_usage","host":"a"},"values":[[1640000000000,"0.75"]]}
+//
+// The sink speaks the Kafka protocol directly (Metadata v0, Produce v3,
+// uncompressed record batches) without TLS or SASL.
+type KafkaSink struct {
+	opts *KafkaOptions
+
+	// Cached metadata: the topic's partition IDs in order, each partition's
+	// leader, and broker addresses by node ID
+	partitions []int32
+	leaders    map[int32]int32
+	brokers    map[int32]string
+	conns      map[int32]*kafkaConn
+}
+
+// kafkaMessage is the JSON value of a produced message
+type kafkaMessage struct {
+	Metric map[string]string `json:"metric"`
+	Values [][2]interface{}  `json:"values"`
+}
+
+// NewKafkaSink creates a Kafka sink. Brokers are contacted on the first Send.
+func NewKafkaSink(opts *KafkaOptions) (*KafkaSink, error) {
+	if opts == nil || len(opts.Brokers) == 0 {
+		return nil, fmt.Errorf("kafka: at least one broker is required")
+	}
+	if opts.Topic == "" {
+		return nil, fmt.Errorf("kafka: topic is required")
+	}
+	if opts.RequiredAcks != 1 && opts.RequiredAcks != -1 {
+		return nil, fmt.Errorf("kafka: required acks must be 1 or -1, got %d", opts.RequiredAcks)
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = DefaultKafkaClientID
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultKafkaTimeout
+	}
+	return &KafkaSink{opts: opts, conns: make(map[int32]*kafkaConn)}, nil
+}
+
+// Send produces one message per record and waits for the acks. Any
+// failure drops the cached metadata and connections, so the next attempt
+// rediscovers partition leaders.
+func (k *KafkaSink) Send(records []Record) error {
+	if err := k.send(records); err != nil {
+		k.reset()
+		return err
+	}
+	return nil
+}
+
+func (k *KafkaSink) send(records []Record) error {
+	if k.partitions == nil {
+		if err := k.refreshMetadata(); err != nil {
+			return err
+		}
+	}
+
+	// Group messages by leader, then partition
+	now := time.Now().UnixMilli()
+	batches := make(map[int32]map[int32][]kafkaRecord)
+	for _, record := range records {
+		s := series.NewSeries(record.Labels)
+		value, err := encodeKafkaValue(record)
+		if err != nil {
+			return err
+		}
+
+		partition := k.partitions[s.Hash%uint64(len(k.partitions))]
+		leader := k.leaders[partition]
+		if batches[leader] == nil {
+			batches[leader] = make(map[int32][]kafkaRecord)
+		}
+		batches[leader][partition] = append(batches[leader][partition], kafkaRecord{
+			key:   []byte(s.String()),
+			value: value,
+		})
+	}
+
+	for leader, partitions := range batches {
+		if err := k.produce(leader, partitions, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes broker connections
+func (k *KafkaSink) Close() error {
+	k.reset()
+	return nil
+}
+
+// reset drops cached metadata and closes connections
+func (k *KafkaSink) reset() {
+	for _, conn := range k.conns {
+		conn.Close()
+	}
+	k.conns = make(map[int32]*kafkaConn)
+	k.partitions = nil
+	k.leaders = nil
+	k.brokers = nil
+}
+
+// refreshMetadata asks the bootstrap brokers for the topic's partition leaders
+func (k *KafkaSink) refreshMetadata() error {
+	var errs []error
+	for _, addr := range k.opts.Brokers {
+		err := k.fetchMetadata(addr)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("kafka: metadata request failed: %w", errors.Join(errs...))
+}
+
+func (k *KafkaSink) fetchMetadata(addr string) error {
+	conn, err := dialKafka(addr, k.opts)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var req kafkaEncoder
+	req.int32(1)
+	req.string(k.opts.Topic)
+
+	resp, err := conn.roundTrip(kafkaAPIMetadata, kafkaMetadataVersion, req.buf)
+	if err != nil {
+		return err
+	}
+
+	d := kafkaDecoder{buf: resp}
+	brokers := make(map[int32]string)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		nodeID := d.int32()
+		host := d.string()
+		port := d.int32()
+		brokers[nodeID] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+
+	var partitions []int32
+	leaders := make(map[int32]int32)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		topicErr := d.int16()
+		topic := d.string()
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			partitionErr := d.int16()
+			partition := d.int32()
+			leader := d.int32()
+			d.skipInt32Array() // replicas
+			d.skipInt32Array() // isr
+			if topic == k.opts.Topic && topicErr == kafkaErrNone && partitionErr == kafkaErrNone {
+				partitions = append(partitions, partition)
+				leaders[partition] = leader
+			}
+		}
+		if d.err == nil && topic == k.opts.Topic && topicErr != kafkaErrNone {
+			return fmt.Errorf("kafka: topic %s: error code %d", topic, topicErr)
+		}
+	}
+	if d.err != nil {
+		return fmt.Errorf("kafka: malformed metadata response: %w", d.err)
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("kafka: topic %s has no available partitions", k.opts.Topic)
+	}
+
+	// Partition IDs are normally dense, but sort them to keep the mapping
+	// from series to partition stable regardless of response order
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	k.partitions = partitions
+	k.leaders = leaders
+	k.brokers = brokers
+	return nil
+}
+
+// produce sends one Produce request to a leader and checks every partition's result
+func (k *KafkaSink) produce(leader int32, partitions map[int32][]kafkaRecord, now int64) error {
+	conn, err := k.conn(leader)
+	if err != nil {
+		return err
+	}
+
+	var req kafkaEncoder
+	req.int16(kafkaNoTransactionalID)
+	req.int16(k.opts.RequiredAcks)
+	req.int32(int32(k.opts.Timeout.Milliseconds()))
+	req.int32(1)
+	req.string(k.opts.Topic)
+	req.int32(int32(len(partitions)))
+	for partition, records := range partitions {
+		req.int32(partition)
+		batch := appendRecordBatch(nil, records, now)
+		req.int32(int32(len(batch)))
+		req.buf = append(req.buf, batch...)
+	}
+
+	resp, err := conn.roundTrip(kafkaAPIProduce, kafkaProduceVersion, req.buf)
+	if err != nil {
+		return err
+	}
+
+	d := kafkaDecoder{buf: resp}
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		d.string() // topic
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			partition := d.int32()
+			code := d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+			if d.err == nil && code != kafkaErrNone {
+				return fmt.Errorf("kafka: produce to %s/%d failed: error code %d", k.opts.Topic, partition, code)
+			}
+		}
+	}
+	if d.err != nil {
+		return fmt.Errorf("kafka: malformed produce response: %w", d.err)
+	}
+	return nil
+}
+
+// conn returns a connection to a broker, dialing it if needed
+func (k *KafkaSink) conn(nodeID int32) (*kafkaConn, error) {
+	if conn, ok := k.conns[nodeID]; ok {
+		return conn, nil
+	}
+	addr, ok := k.brokers[nodeID]
+	if !ok {
+		return nil, fmt.Errorf("kafka: unknown broker %d", nodeID)
+	}
+	conn, err := dialKafka(addr, k.opts)
+	if err != nil {
+		return nil, err
+	}
+	k.conns[nodeID] = conn
+	return conn, nil
+}
+
+// encodeKafkaValue encodes a record as a message value
+func encodeKafkaValue(record Record) ([]byte, error) {
+	msg := kafkaMessage{
+		Metric: record.Labels,
+		Values: make([][2]interface{}, len(record.Samples)),
+	}
+	for i, sample := range record.Samples {
+		msg.Values[i] = [2]interface{}{sample.Timestamp, strconv.FormatFloat(sample.Value, 'f', -1, 64)}
+	}
+	return json.Marshal(msg)
+}
@@ -0,0 +1,221 @@
+package cdc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// kafkaRecordBatchMagic is the record batch format version (v2)
+	kafkaRecordBatchMagic = 2
+
+	// kafkaMaxResponseSize guards against reading garbage as a huge size
+	kafkaMaxResponseSize = 64 << 20
+)
+
+// castagnoliTable computes the CRC-32C checksums of record batches
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaRecord is a message to produce
+type kafkaRecord struct {
+	key   []byte
+	value []byte
+}
+
+// kafkaConn is a connection to one broker. Requests are sent one at a
+// time, so responses arrive in order.
+type kafkaConn struct {
+	conn          net.Conn
+	reader        *bufio.Reader
+	clientID      string
+	timeout       time.Duration
+	correlationID int32
+}
+
+// dialKafka connects to a broker
+func dialKafka(addr string, opts *KafkaOptions) (*kafkaConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, opts.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("kafka: failed to connect to %s: %w", addr, err)
+	}
+	return &kafkaConn{
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		clientID: opts.ClientID,
+		timeout:  opts.Timeout,
+	}, nil
+}
+
+// roundTrip sends a request and returns the response body after the
+// correlation ID
+func (c *kafkaConn) roundTrip(apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	c.correlationID++
+
+	var req kafkaEncoder
+	req.int32(0) // size, filled below
+	req.int16(apiKey)
+	req.int16(apiVersion)
+	req.int32(c.correlationID)
+	req.string(c.clientID)
+	req.buf = append(req.buf, body...)
+	binary.BigEndian.PutUint32(req.buf, uint32(len(req.buf)-4))
+
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := c.conn.Write(req.buf); err != nil {
+		return nil, fmt.Errorf("kafka: failed to send request: %w", err)
+	}
+
+	var header [8]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return nil, fmt.Errorf("kafka: failed to read response: %w", err)
+	}
+	size := int32(binary.BigEndian.Uint32(header[:4]))
+	if size < 4 || size > kafkaMaxResponseSize {
+		return nil, fmt.Errorf("kafka: invalid response size %d", size)
+	}
+	if id := int32(binary.BigEndian.Uint32(header[4:])); id != c.correlationID {
+		return nil, fmt.Errorf("kafka: response correlation ID %d, want %d", id, c.correlationID)
+	}
+
+	resp := make([]byte, size-4)
+	if _, err := io.ReadFull(c.reader, resp); err != nil {
+		return nil, fmt.Errorf("kafka: failed to read response: %w", err)
+	}
+	return resp, nil
+}
+
+// Close closes the connection
+func (c *kafkaConn) Close() error {
+	return c.conn.Close()
+}
+
+// kafkaEncoder appends big-endian protocol primitives
+type kafkaEncoder struct {
+	buf []byte
+}
+
+func (e *kafkaEncoder) int16(v int16) {
+	e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v))
+}
+
+func (e *kafkaEncoder) int32(v int32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v))
+}
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// kafkaDecoder reads protocol primitives, remembering the first error so
+// callers can check once at the end
+type kafkaDecoder struct {
+	buf []byte
+	off int
+	err error
+}
+
+// next returns the next n bytes, or nil once the buffer is exhausted
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || d.off+n > len(d.buf) {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := d.buf[d.off : d.off+n]
+	d.off += n
+	return b
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+// arrayLen reads an array length, treating null arrays as empty
+func (d *kafkaDecoder) arrayLen() int {
+	n := d.int32()
+	if n < 0 || d.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+func (d *kafkaDecoder) skipInt32Array() {
+	d.next(4 * d.arrayLen())
+}
+
+// appendRecordBatch appends an uncompressed v2 record batch holding records,
+// all stamped with timestamp
+func appendRecordBatch(dst []byte, records []kafkaRecord, timestamp int64) []byte {
+	start := len(dst)
+	dst = binary.BigEndian.AppendUint64(dst, 0)          // base offset
+	dst = binary.BigEndian.AppendUint32(dst, 0)          // batch length, filled below
+	dst = binary.BigEndian.AppendUint32(dst, 0xffffffff) // partition leader epoch (-1)
+	dst = append(dst, kafkaRecordBatchMagic)             // magic
+	crcOffset := len(dst)
+	dst = binary.BigEndian.AppendUint32(dst, 0)                      // CRC, filled below
+	dst = binary.BigEndian.AppendUint16(dst, 0)                      // attributes: no compression
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(records)-1)) // last offset delta
+	dst = binary.BigEndian.AppendUint64(dst, uint64(timestamp))      // first timestamp
+	dst = binary.BigEndian.AppendUint64(dst, uint64(timestamp))      // max timestamp
+	dst = binary.BigEndian.AppendUint64(dst, 0xffffffffffffffff)     // producer ID (-1)
+	dst = binary.BigEndian.AppendUint16(dst, 0xffff)                 // producer epoch (-1)
+	dst = binary.BigEndian.AppendUint32(dst, 0xffffffff)             // base sequence (-1)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(records)))
+
+	var record []byte
+	for i, r := range records {
+		record = record[:0]
+		record = append(record, 0)                     // attributes
+		record = binary.AppendVarint(record, 0)        // timestamp delta
+		record = binary.AppendVarint(record, int64(i)) // offset delta
+		record = binary.AppendVarint(record, int64(len(r.key)))
+		record = append(record, r.key...)
+		record = binary.AppendVarint(record, int64(len(r.value)))
+		record = append(record, r.value...)
+		record = binary.AppendVarint(record, 0) // headers
+
+		dst = binary.AppendVarint(dst, int64(len(record)))
+		dst = append(dst, record...)
+	}
+
+	// The length counts from the partition leader epoch; the CRC covers
+	// everything after itself
+	binary.BigEndian.PutUint32(dst[start+8:], uint32(len(dst)-start-12))
+	binary.BigEndian.PutUint32(dst[crcOffset:], crc32.Checksum(dst[crcOffset+4:], castagnoliTable))
+	return dst
+}
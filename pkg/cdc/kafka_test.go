@@ -0,0 +1,263 @@
+package cdc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// fakeBroker is a single-node Kafka cluster serving Metadata v0 and
+// Produce v3 for one topic, recording produced messages
+type fakeBroker struct {
+	t          *testing.T
+	listener   net.Listener
+	topic      string
+	partitions int32
+
+	mu       sync.Mutex
+	messages map[int32][]kafkaRecord
+	acks     []int16
+}
+
+func newFakeBroker(t *testing.T, topic string, partitions int32) *fakeBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	b := &fakeBroker{t: t, listener: listener, topic: topic, partitions: partitions, messages: make(map[int32][]kafkaRecord)}
+	go b.serve()
+	t.Cleanup(func() { listener.Close() })
+	return b
+}
+
+func (b *fakeBroker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *fakeBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(r, req); err != nil {
+			return
+		}
+
+		d := kafkaDecoder{buf: req}
+		apiKey, apiVersion, correlationID := d.int16(), d.int16(), d.int32()
+		d.string() // client ID
+
+		var resp kafkaEncoder
+		resp.int32(0)
+		resp.int32(correlationID)
+		switch {
+		case apiKey == kafkaAPIMetadata && apiVersion == 0:
+			b.metadata(&resp)
+		case apiKey == kafkaAPIProduce && apiVersion == 3:
+			b.produce(&d, &resp)
+		default:
+			b.t.Errorf("unexpected request: api key %d version %d", apiKey, apiVersion)
+			return
+		}
+		binary.BigEndian.PutUint32(resp.buf, uint32(len(resp.buf)-4))
+		if _, err := conn.Write(resp.buf); err != nil {
+			return
+		}
+	}
+}
+
+func (b *fakeBroker) metadata(resp *kafkaEncoder) {
+	host, port, _ := net.SplitHostPort(b.listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	resp.int32(1) // brokers
+	resp.int32(7)
+	resp.string(host)
+	resp.int32(int32(portNum))
+
+	resp.int32(1) // topics
+	resp.int16(0)
+	resp.string(b.topic)
+	resp.int32(b.partitions)
+	for p := int32(0); p < b.partitions; p++ {
+		resp.int16(0)
+		resp.int32(p)
+		resp.int32(7) // leader
+		resp.int32(1) // replicas
+		resp.int32(7)
+		resp.int32(1) // isr
+		resp.int32(7)
+	}
+}
+
+func (b *fakeBroker) produce(d *kafkaDecoder, resp *kafkaEncoder) {
+	d.string() // transactional ID
+	acks := d.int16()
+	d.int32() // timeout
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.acks = append(b.acks, acks)
+
+	resp.int32(1)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		topic := d.string()
+		resp.string(topic)
+		numPartitions := d.arrayLen()
+		resp.int32(int32(numPartitions))
+		for j := 0; j < numPartitions; j++ {
+			partition := d.int32()
+			batch := d.next(int(d.int32()))
+			records, err := decodeRecordBatch(batch)
+			if err != nil {
+				b.t.Errorf("partition %d: %v", partition, err)
+			}
+			b.messages[partition] = append(b.messages[partition], records...)
+
+			resp.int32(partition)
+			resp.int16(0)
+			resp.buf = binary.BigEndian.AppendUint64(resp.buf, 0)
+			resp.buf = binary.BigEndian.AppendUint64(resp.buf, 0)
+		}
+	}
+	resp.int32(0) // throttle time
+}
+
+// decodeRecordBatch parses an uncompressed v2 record batch, checking its CRC
+func decodeRecordBatch(batch []byte) ([]kafkaRecord, error) {
+	if len(batch) < 61 || batch[16] != kafkaRecordBatchMagic {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if int(binary.BigEndian.Uint32(batch[8:])) != len(batch)-12 {
+		return nil, strconv.ErrSyntax
+	}
+	if binary.BigEndian.Uint32(batch[17:]) != crc32.Checksum(batch[21:], castagnoliTable) {
+		return nil, strconv.ErrRange
+	}
+
+	count := int(binary.BigEndian.Uint32(batch[57:]))
+	rest := batch[61:]
+	readVarint := func() int64 {
+		v, n := binary.Varint(rest)
+		rest = rest[n:]
+		return v
+	}
+
+	var records []kafkaRecord
+	for i := 0; i < count; i++ {
+		readVarint() // length
+		rest = rest[1:]
+		readVarint() // timestamp delta
+		if delta := readVarint(); delta != int64(i) {
+			return nil, strconv.ErrSyntax
+		}
+		keyLen := readVarint()
+		key := rest[:keyLen]
+		rest = rest[keyLen:]
+		valueLen := readVarint()
+		value := rest[:valueLen]
+		rest = rest[valueLen:]
+		readVarint() // headers
+		records = append(records, kafkaRecord{key: key, value: value})
+	}
+	return records, nil
+}
+
+func TestKafkaSink(t *testing.T) {
+	broker := newFakeBroker(t, "metrics", 3)
+
+	opts := DefaultKafkaOptions([]string{broker.listener.Addr().String()}, "metrics")
+	opts.RequiredAcks = -1
+	sink, err := NewKafkaSink(opts)
+	if err != nil {
+		t.Fatalf("NewKafkaSink failed: %v", err)
+	}
+	defer sink.Close()
+
+	var records []Record
+	for _, host := range []string{"a", "b", "c", "d", "e", "f"} {
+		records = append(records, Record{
+			Labels:  map[string]string{"__name__": "cpu_usage", "host": host},
+			Samples: []series.Sample{{Timestamp: 1000, Value: 0.5}, {Timestamp: 2000, Value: 0.75}},
+		})
+	}
+	for i := 0; i < 2; i++ {
+		if err := sink.Send(records); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	if len(broker.acks) == 0 || broker.acks[0] != -1 {
+		t.Errorf("produce acks = %v, want -1", broker.acks)
+	}
+
+	total := 0
+	for partition, messages := range broker.messages {
+		for _, msg := range messages {
+			total++
+
+			var value struct {
+				Metric map[string]string `json:"metric"`
+				Values [][2]interface{}  `json:"values"`
+			}
+			if err := json.Unmarshal(msg.value, &value); err != nil {
+				t.Fatalf("invalid message value %s: %v", msg.value, err)
+			}
+			s := series.NewSeries(value.Metric)
+			if string(msg.key) != s.String() {
+				t.Errorf("key = %s, want %s", msg.key, s.String())
+			}
+			if want := int32(s.Hash % 3); partition != want {
+				t.Errorf("series %s on partition %d, want %d", s, partition, want)
+			}
+			if len(value.Values) != 2 || value.Values[1][0] != 2000.0 || value.Values[1][1] != "0.75" {
+				t.Errorf("values = %v", value.Values)
+			}
+		}
+	}
+	if total != 2*len(records) {
+		t.Errorf("broker received %d messages, want %d", total, 2*len(records))
+	}
+}
+
+func TestKafkaSinkUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	sink, err := NewKafkaSink(DefaultKafkaOptions([]string{addr}, "metrics"))
+	if err != nil {
+		t.Fatalf("NewKafkaSink failed: %v", err)
+	}
+	if err := sink.Send([]Record{{Labels: map[string]string{"__name__": "up"}}}); err == nil {
+		t.Error("Send to an unreachable broker succeeded")
+	}
+
+	if _, err := NewKafkaSink(DefaultKafkaOptions(nil, "metrics")); err == nil {
+		t.Error("NewKafkaSink without brokers succeeded")
+	}
+}
@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BatchOptions configures a Batcher.
+type BatchOptions struct {
+	// MaxBatchSize is the number of buffered metrics that triggers a write
+	MaxBatchSize int
+
+	// FlushInterval is how often buffered metrics are written in the
+	// background; 0 disables background flushing
+	FlushInterval time.Duration
+
+	// OnError is called with the error and the number of dropped metrics
+	// when a background flush fails
+	OnError func(err error, dropped int)
+}
+
+// DefaultBatchOptions returns default batching options.
+func DefaultBatchOptions() BatchOptions {
+	return BatchOptions{
+		MaxBatchSize:  1000,
+		FlushInterval: 5 * time.Second,
+	}
+}
+
+// Batcher buffers metrics and writes them in batches. It is safe for
+// concurrent use.
+type Batcher struct {
+	client *Client
+	opts   BatchOptions
+
+	mu      sync.Mutex
+	pending []Metric
+
+	// flushMu serializes writes so batches arrive in order
+	flushMu sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewBatcher creates a batcher writing through the client. Close must be
+// called to flush the remaining metrics and stop background flushing.
+func (c *Client) NewBatcher(opts BatchOptions) *Batcher {
+	if opts.MaxBatchSize <= 0 {
+		opts.MaxBatchSize = DefaultBatchOptions().MaxBatchSize
+	}
+
+	b := &Batcher{
+		client: c,
+		opts:   opts,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	if opts.FlushInterval > 0 {
+		go b.loop()
+	} else {
+		close(b.done)
+	}
+	return b
+}
+
+// Add buffers metrics, writing a batch synchronously once MaxBatchSize
+// metrics are buffered.
+func (b *Batcher) Add(ctx context.Context, metrics ...Metric) error {
+	b.mu.Lock()
+	b.pending = append(b.pending, metrics...)
+	full := len(b.pending) >= b.opts.MaxBatchSize
+	b.mu.Unlock()
+
+	if full {
+		return b.Flush(ctx)
+	}
+	return nil
+}
+
+// Flush writes all buffered metrics. Metrics of a failed write are
+// dropped; the error reports how many.
+func (b *Batcher) Flush(ctx context.Context) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	for {
+		b.mu.Lock()
+		n := min(len(b.pending), b.opts.MaxBatchSize)
+		batch := b.pending[:n:n]
+		b.pending = b.pending[n:]
+		if len(b.pending) == 0 {
+			b.pending = nil
+		}
+		b.mu.Unlock()
+
+		if n == 0 {
+			return nil
+		}
+		if err := b.client.Write(ctx, batch); err != nil {
+			return &BatchError{Err: err, Dropped: n}
+		}
+	}
+}
+
+// Close stops background flushing and writes the remaining metrics.
+func (b *Batcher) Close(ctx context.Context) error {
+	b.once.Do(func() { close(b.stop) })
+	<-b.done
+	return b.Flush(ctx)
+}
+
+// loop flushes buffered metrics every FlushInterval
+func (b *Batcher) loop() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.Flush(context.Background()); err != nil && b.opts.OnError != nil {
+				b.opts.OnError(err, err.(*BatchError).Dropped)
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// BatchError is returned when a batch write fails.
+type BatchError struct {
+	Err     error
+	Dropped int
+}
+
+// Error implements the error interface
+func (e *BatchError) Error() string {
+	return "batch write failed: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying write error
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
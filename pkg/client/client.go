@@ -1,13 +1,12 @@
 package client
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"time"
 
@@ -19,6 +18,7 @@ type Client struct {
 	baseURL    string
 	httpClient *http.Client
 	userAgent  string
+	retry      RetryPolicy
 }
 
 // Option is a function that configures a Client.
@@ -45,6 +45,13 @@ func WithTimeout(timeout time.Duration) Option {
 	}
 }
 
+// WithRetry sets the retry policy for failed requests.
+func WithRetry(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
 // NewClient creates a new TSDB client.
 func NewClient(addr string, opts ...Option) *Client {
 	c := &Client{
@@ -53,6 +60,7 @@ func NewClient(addr string, opts ...Option) *Client {
 			Timeout: 30 * time.Second,
 		},
 		userAgent: "tsdb-go-client/1.0",
+		retry:     DefaultRetryPolicy(),
 	}
 
 	for _, opt := range opts {
@@ -135,59 +143,19 @@ func (c *Client) Write(ctx context.Context, metrics []Metric) error {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	// Send request
-	url := c.baseURL + "/api/v1/write"
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("User-Agent", c.userAgent)
-
-	resp, err := c.httpClient.Do(httpReq)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusNoContent {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(bodyBytes))
-	}
-
-	return nil
+	_, err = c.do(ctx, http.MethodPost, "/api/v1/write", nil, body, http.StatusNoContent)
+	return err
 }
 
 // Query executes an instant query.
 func (c *Client) Query(ctx context.Context, query string, ts time.Time) ([]QueryResult, error) {
 	params := url.Values{}
 	params.Set("query", query)
-	params.Set("time", strconv.FormatInt(ts.UnixMilli(), 10))
-
-	url := c.baseURL + "/api/v1/query?" + params.Encode()
-
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	httpReq.Header.Set("User-Agent", c.userAgent)
-
-	resp, err := c.httpClient.Do(httpReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(bodyBytes))
-	}
+	params.Set("time", formatTime(ts))
 
 	var apiResp api.QueryResponse
-	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	if err := c.get(ctx, "/api/v1/query", params, &apiResp); err != nil {
+		return nil, err
 	}
 
 	if apiResp.Status != "success" {
@@ -223,33 +191,13 @@ func (c *Client) Query(ctx context.Context, query string, ts time.Time) ([]Query
 func (c *Client) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) ([]QueryResult, error) {
 	params := url.Values{}
 	params.Set("query", query)
-	params.Set("start", strconv.FormatInt(start.UnixMilli(), 10))
-	params.Set("end", strconv.FormatInt(end.UnixMilli(), 10))
-	params.Set("step", strconv.FormatInt(step.Milliseconds(), 10))
-
-	url := c.baseURL + "/api/v1/query_range?" + params.Encode()
-
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	httpReq.Header.Set("User-Agent", c.userAgent)
-
-	resp, err := c.httpClient.Do(httpReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(bodyBytes))
-	}
+	params.Set("start", formatTime(start))
+	params.Set("end", formatTime(end))
+	params.Set("step", formatSeconds(step.Milliseconds()))
 
 	var apiResp api.QueryResponse
-	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	if err := c.get(ctx, "/api/v1/query_range", params, &apiResp); err != nil {
+		return nil, err
 	}
 
 	if apiResp.Status != "success" {
@@ -284,29 +232,9 @@ func (c *Client) QueryRange(ctx context.Context, query string, start, end time.T
 
 // Labels returns all unique label names.
 func (c *Client) Labels(ctx context.Context) ([]string, error) {
-	url := c.baseURL + "/api/v1/labels"
-
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	httpReq.Header.Set("User-Agent", c.userAgent)
-
-	resp, err := c.httpClient.Do(httpReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(bodyBytes))
-	}
-
 	var apiResp api.LabelsResponse
-	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	if err := c.get(ctx, "/api/v1/labels", nil, &apiResp); err != nil {
+		return nil, err
 	}
 
 	if apiResp.Status != "success" {
@@ -318,36 +246,50 @@ func (c *Client) Labels(ctx context.Context) ([]string, error) {
 
 // LabelValues returns all values for a specific label.
 func (c *Client) LabelValues(ctx context.Context, labelName string) ([]string, error) {
-	url := fmt.Sprintf("%s/api/v1/label/%s/values", c.baseURL, labelName)
+	var apiResp api.LabelValuesResponse
+	if err := c.get(ctx, "/api/v1/label/"+url.PathEscape(labelName)+"/values", nil, &apiResp); err != nil {
+		return nil, err
+	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	if apiResp.Status != "success" {
+		return nil, fmt.Errorf("request failed: %s", apiResp.Error)
 	}
 
-	httpReq.Header.Set("User-Agent", c.userAgent)
+	return apiResp.Data, nil
+}
 
-	resp, err := c.httpClient.Do(httpReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+// Series returns the label sets of series matching any of the selectors,
+// following pagination until all pages are read. Zero start or end times
+// leave that side of the range open.
+func (c *Client) Series(ctx context.Context, matches []string, start, end time.Time) ([]map[string]string, error) {
+	params := url.Values{}
+	for _, match := range matches {
+		params.Add("match[]", match)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(bodyBytes))
+	if !start.IsZero() {
+		params.Set("start", formatTime(start))
 	}
-
-	var apiResp api.LabelValuesResponse
-	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	if !end.IsZero() {
+		params.Set("end", formatTime(end))
 	}
 
-	if apiResp.Status != "success" {
-		return nil, fmt.Errorf("request failed: %s", apiResp.Error)
-	}
+	var all []map[string]string
+	for {
+		var apiResp api.SeriesResponse
+		if err := c.get(ctx, "/api/v1/series", params, &apiResp); err != nil {
+			return nil, err
+		}
 
-	return apiResp.Data, nil
+		if apiResp.Status != "success" {
+			return nil, fmt.Errorf("request failed: %s", apiResp.Error)
+		}
+
+		all = append(all, apiResp.Data...)
+		if apiResp.NextToken == "" {
+			return all, nil
+		}
+		params.Set("token", apiResp.NextToken)
+	}
 }
 
 // Health checks if the TSDB is healthy.
@@ -370,11 +312,28 @@ func (c *Client) Health(ctx context.Context) (bool, error) {
 	return resp.StatusCode == http.StatusOK, nil
 }
 
+// formatTime formats a time parameter as Unix seconds, to the millisecond.
+func formatTime(t time.Time) string {
+	return formatSeconds(t.UnixMilli())
+}
+
+// formatSeconds formats milliseconds as a number of seconds, the unit the
+// API reads bare numbers in.
+func formatSeconds(ms int64) string {
+	return strconv.FormatFloat(float64(ms)/1000, 'f', -1, 64)
+}
+
 // labelsKey creates a unique key from labels for grouping.
 func labelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
 	key := ""
-	for name, value := range labels {
-		key += name + "=" + value + ","
+	for _, name := range names {
+		key += name + "=" + labels[name] + ","
 	}
 	return key
 }
@@ -2,13 +2,16 @@ package client
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/therealutkarshpriyadarshi/time/pkg/api"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
 	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
 )
 
@@ -34,7 +37,7 @@ func setupTestServerWithClient(t *testing.T) (*Client, *storage.TSDB, func()) {
 	server := api.NewServer(db, ":0")
 
 	// Create test HTTP server
-	httpServer := httptest.NewServer(server.mux)
+	httpServer := httptest.NewServer(server.Handler())
 
 	// Create client
 	client := NewClient(httpServer.URL)
@@ -428,3 +431,177 @@ func TestClientWriteGrouping(t *testing.T) {
 		t.Errorf("Expected at least 2 samples, got %d", len(results[0].Samples))
 	}
 }
+
+func TestClientSeries(t *testing.T) {
+	client, _, cleanup := setupTestServerWithClient(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	now := time.Now()
+	metrics := []Metric{
+		{Labels: map[string]string{"__name__": "cpu_usage", "host": "server1"}, Timestamp: now, Value: 1},
+		{Labels: map[string]string{"__name__": "cpu_usage", "host": "server2"}, Timestamp: now, Value: 2},
+		{Labels: map[string]string{"__name__": "mem_usage", "host": "server1"}, Timestamp: now, Value: 3},
+	}
+	if err := client.Write(ctx, metrics); err != nil {
+		t.Fatalf("Failed to write test data: %v", err)
+	}
+
+	series, err := client.Series(ctx, []string{`{__name__="cpu_usage"}`}, now.Add(-time.Minute), now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Series() error = %v", err)
+	}
+	if len(series) != 2 {
+		t.Fatalf("Expected 2 series, got %d: %v", len(series), series)
+	}
+	for _, s := range series {
+		if s["__name__"] != "cpu_usage" {
+			t.Errorf("Unexpected series %v", s)
+		}
+	}
+
+	if _, err := client.Series(ctx, nil, time.Time{}, time.Time{}); err == nil {
+		t.Error("Series() without matchers should fail")
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	policy := RetryPolicy{MaxRetries: 3, MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	client := NewClient(server.URL, WithRetry(policy))
+
+	metrics := []Metric{{Labels: map[string]string{"__name__": "up"}, Timestamp: time.Now(), Value: 1}}
+	if err := client.Write(context.Background(), metrics); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("Expected 3 attempts, got %d", n)
+	}
+
+	// Bad requests are not retried
+	attempts.Store(0)
+	badServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"invalid query"}`))
+	}))
+	defer badServer.Close()
+
+	client = NewClient(badServer.URL, WithRetry(policy))
+	_, err := client.Query(context.Background(), "up", time.Now())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.Type != api.ErrorBadData || apiErr.Message != "invalid query" {
+		t.Errorf("Unexpected error %+v", apiErr)
+	}
+	if n := attempts.Load(); n != 1 {
+		t.Errorf("Expected 1 attempt, got %d", n)
+	}
+
+	// Retries give up when the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	client = NewClient(server.URL, WithRetry(RetryPolicy{MaxRetries: 100, MinBackoff: time.Second, MaxBackoff: time.Second}))
+	attempts.Store(-100)
+	start := time.Now()
+	if err := client.Write(ctx, metrics); err == nil {
+		t.Error("Write() should fail once the context is done")
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("Write() kept retrying after the context was done")
+	}
+}
+
+func TestBatcher(t *testing.T) {
+	client, db, cleanup := setupTestServerWithClient(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	batcher := client.NewBatcher(BatchOptions{MaxBatchSize: 3})
+
+	now := time.Now().Truncate(time.Millisecond)
+	add := func(i int) {
+		m := Metric{
+			Labels:    map[string]string{"__name__": "batched", "host": "server1"},
+			Timestamp: now.Add(time.Duration(i) * time.Second),
+			Value:     float64(i),
+		}
+		if err := batcher.Add(ctx, m); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	hash := series.NewSeries(map[string]string{"__name__": "batched", "host": "server1"}).Hash
+	count := func() int {
+		samples, err := db.Query(hash, now.UnixMilli(), now.Add(time.Hour).UnixMilli())
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		return len(samples)
+	}
+
+	add(0)
+	add(1)
+	if n := count(); n != 0 {
+		t.Fatalf("Expected no samples before the batch is full, got %d", n)
+	}
+	add(2)
+	if n := count(); n != 3 {
+		t.Fatalf("Expected 3 samples after a full batch, got %d", n)
+	}
+
+	add(3)
+	if err := batcher.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if n := count(); n != 4 {
+		t.Errorf("Expected 4 samples after Close, got %d", n)
+	}
+}
+
+func TestBatcherBackgroundFlush(t *testing.T) {
+	var writes atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writes.Add(1)
+		http.Error(w, "bad", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	errs := make(chan int, 1)
+	client := NewClient(server.URL)
+	batcher := client.NewBatcher(BatchOptions{
+		MaxBatchSize:  100,
+		FlushInterval: 10 * time.Millisecond,
+		OnError:       func(err error, dropped int) { errs <- dropped },
+	})
+	defer batcher.Close(context.Background())
+
+	metric := Metric{Labels: map[string]string{"__name__": "up"}, Timestamp: time.Now(), Value: 1}
+	if err := batcher.Add(context.Background(), metric, metric); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	select {
+	case dropped := <-errs:
+		if dropped != 2 {
+			t.Errorf("Expected 2 dropped metrics, got %d", dropped)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Background flush did not report the error")
+	}
+	if writes.Load() != 1 {
+		t.Errorf("Expected 1 write, got %d", writes.Load())
+	}
+}
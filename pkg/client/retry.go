@@ -0,0 +1,187 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/api"
+)
+
+// RetryPolicy controls how failed requests are retried. Network errors,
+// 429 and 5xx responses are retried; other responses are returned as is.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt; 0
+	// disables retries
+	MaxRetries int
+
+	// MinBackoff is the wait before the first retry; it doubles on every
+	// retry up to MaxBackoff, with jitter
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy returns the default retry policy
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: 3,
+		MinBackoff: 100 * time.Millisecond,
+		MaxBackoff: 5 * time.Second,
+	}
+}
+
+// backoff returns the wait before the given retry (0-based), with jitter
+// in [d/2, d)
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.MinBackoff
+	for i := 0; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// APIError is returned when the server answers with an unexpected status.
+type APIError struct {
+	StatusCode int
+
+	// Type is the Prometheus-style error type, if the server sent one
+	Type api.ErrorType
+
+	// Message is the server's error message, or the raw response body
+	Message string
+
+	// retryAfter is the server's Retry-After hint
+	retryAfter time.Duration
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	if e.Type != "" {
+		return fmt.Sprintf("unexpected status code: %d (%s): %s", e.StatusCode, e.Type, e.Message)
+	}
+	return fmt.Sprintf("unexpected status code: %d, body: %s", e.StatusCode, e.Message)
+}
+
+// Temporary reports whether retrying the request may succeed
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests ||
+		(e.StatusCode >= 500 && e.StatusCode != http.StatusNotImplemented)
+}
+
+// newAPIError builds an APIError from a response
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode, Message: string(bytes.TrimSpace(body))}
+
+	var errResp struct {
+		ErrorType api.ErrorType `json:"errorType"`
+		Error     string        `json:"error"`
+	}
+	if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+		apiErr.Type = errResp.ErrorType
+		apiErr.Message = errResp.Error
+	}
+
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		apiErr.retryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
+
+// get sends a GET request and decodes the JSON response into v
+func (c *Client) get(ctx context.Context, path string, params url.Values, v interface{}) error {
+	body, err := c.do(ctx, http.MethodGet, path, params, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// do sends a request, retrying transient failures according to the retry
+// policy, and returns the body of a response with status wantStatus
+func (c *Client) do(ctx context.Context, method, path string, params url.Values, body []byte, wantStatus int) ([]byte, error) {
+	target := c.baseURL + path
+	if len(params) > 0 {
+		target += "?" + params.Encode()
+	}
+
+	for retry := 0; ; retry++ {
+		respBody, err := c.doOnce(ctx, method, target, body, wantStatus)
+		if err == nil {
+			return respBody, nil
+		}
+		if retry >= c.retry.MaxRetries || ctx.Err() != nil {
+			return nil, err
+		}
+
+		wait := c.retry.backoff(retry)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			if !apiErr.Temporary() {
+				return nil, err
+			}
+			if apiErr.retryAfter > wait {
+				wait = min(apiErr.retryAfter, c.retry.MaxBackoff)
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		}
+	}
+}
+
+// doOnce sends a single request
+func (c *Client) doOnce(ctx context.Context, method, target string, body []byte, wantStatus int) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	httpReq.Header.Set("User-Agent", c.userAgent)
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// A server with async writes answers a queued write with 202
+	queued := wantStatus == http.StatusNoContent && resp.StatusCode == http.StatusAccepted
+	if resp.StatusCode != wantStatus && !queued {
+		return nil, newAPIError(resp, respBody)
+	}
+	return respBody, nil
+}
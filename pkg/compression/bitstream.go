@@ -68,7 +68,13 @@ func (bw *BitWriter) Flush() error {
 
 // flush writes the current buffer to the writer
 func (bw *BitWriter) flush() error {
-	_, err := bw.w.Write([]byte{bw.buf})
+	var err error
+	if byteWriter, ok := bw.w.(io.ByteWriter); ok {
+		// Avoids allocating a slice per byte
+		err = byteWriter.WriteByte(bw.buf)
+	} else {
+		_, err = bw.w.Write([]byte{bw.buf})
+	}
 	if err != nil {
 		return err
 	}
@@ -84,6 +90,11 @@ func (bw *BitWriter) BitsWritten() uint64 {
 	return bw.total
 }
 
+// Reset discards any buffered bits and switches to writing to w
+func (bw *BitWriter) Reset(w io.Writer) {
+	*bw = BitWriter{w: w}
+}
+
 // BitReader provides bit-level reading capabilities for decompression algorithms.
 type BitReader struct {
 	data  []byte
@@ -121,13 +132,24 @@ func (br *BitReader) ReadBits(n uint8) (uint64, error) {
 		return 0, fmt.Errorf("cannot read more than 64 bits at once")
 	}
 
+	// Take as many bits as possible from each byte
 	var value uint64
-	for i := uint8(0); i < n; i++ {
-		bit, err := br.ReadBit()
-		if err != nil {
-			return 0, err
+	for n > 0 {
+		if br.pos >= len(br.data) {
+			return 0, io.EOF
+		}
+		avail := 8 - br.count
+		take := min(avail, n)
+		bits := (br.data[br.pos] >> (avail - take)) & (0xFF >> (8 - take))
+		value = (value << take) | uint64(bits)
+
+		n -= take
+		br.count += take
+		br.total += uint64(take)
+		if br.count == 8 {
+			br.pos++
+			br.count = 0
 		}
-		value = (value << 1) | uint64(bit)
 	}
 
 	return value, nil
@@ -337,6 +337,41 @@ func TestValueCompressionRatio(t *testing.T) {
 	}
 }
 
+// TestEncoderReset tests that reset encoders encode like new ones
+func TestEncoderReset(t *testing.T) {
+	tsEncoder := NewTimestampEncoder()
+	valEncoder := NewValueEncoder()
+	for _, n := range []int{100, 10} {
+		tsEncoder.Reset()
+		valEncoder.Reset()
+		freshTS := NewTimestampEncoder()
+		freshVals := NewValueEncoder()
+		for i := 0; i < n; i++ {
+			for _, enc := range []*TimestampEncoder{tsEncoder, freshTS} {
+				if err := enc.Encode(int64(1000 + i*15)); err != nil {
+					t.Fatalf("Encode failed: %v", err)
+				}
+			}
+			for _, enc := range []*ValueEncoder{valEncoder, freshVals} {
+				if err := enc.Encode(float64(n*i) / 3); err != nil {
+					t.Fatalf("Encode failed: %v", err)
+				}
+			}
+		}
+
+		got, _ := tsEncoder.Finish()
+		want, _ := freshTS.Finish()
+		if !bytes.Equal(got, want) || tsEncoder.Count() != n {
+			t.Errorf("%d timestamps: reset encoder wrote %x, want %x", n, got, want)
+		}
+		got, _ = valEncoder.Finish()
+		want, _ = freshVals.Finish()
+		if !bytes.Equal(got, want) || valEncoder.Count() != n {
+			t.Errorf("%d values: reset encoder wrote %x, want %x", n, got, want)
+		}
+	}
+}
+
 // TestLeadingTrailingZeros tests the helper functions
 func TestLeadingTrailingZeros(t *testing.T) {
 	tests := []struct {
@@ -116,6 +116,15 @@ func (e *TimestampEncoder) Finish() ([]byte, error) {
 	return nil, fmt.Errorf("unexpected writer type")
 }
 
+// Reset clears the encoder to encode a new series of timestamps, reusing
+// its buffer. Bytes returned by Finish are overwritten.
+func (e *TimestampEncoder) Reset() {
+	buf := e.bw.w.(*bytes.Buffer)
+	buf.Reset()
+	e.bw.Reset(buf)
+	*e = TimestampEncoder{bw: e.bw}
+}
+
 // Count returns the number of timestamps encoded
 func (e *TimestampEncoder) Count() int {
 	return e.count
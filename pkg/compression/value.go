@@ -128,6 +128,15 @@ func (e *ValueEncoder) Finish() ([]byte, error) {
 	return nil, fmt.Errorf("unexpected writer type")
 }
 
+// Reset clears the encoder to encode a new series of values, reusing its
+// buffer. Bytes returned by Finish are overwritten.
+func (e *ValueEncoder) Reset() {
+	buf := e.bw.w.(*bytes.Buffer)
+	buf.Reset()
+	e.bw.Reset(buf)
+	*e = ValueEncoder{bw: e.bw}
+}
+
 // Count returns the number of values encoded
 func (e *ValueEncoder) Count() int {
 	return e.count
@@ -0,0 +1,132 @@
+// Package errors classifies TSDB errors by kind, so callers such as the
+// API server and the CLI can react to a failure without matching its
+// message.
+//
+// Every package defines its sentinel errors with New, giving each a Kind.
+// Errors wrapping a sentinel, e.g. with fmt.Errorf and %w, keep its kind:
+//
+//	if errors.Is(err, tsdberrors.NotFound) { ... }
+//	switch tsdberrors.KindOf(err) { ... }
+//
+// The sentinels themselves still work with errors.Is as before.
+package errors
+
+import (
+	"errors"
+)
+
+// Kind is the class of an error. A Kind is itself an error, so it can be
+// the target of errors.Is.
+type Kind uint8
+
+const (
+	// Unknown is the kind of errors outside the taxonomy
+	Unknown Kind = iota
+
+	// Invalid means the request or its data is malformed; retrying it
+	// unchanged won't help
+	Invalid
+
+	// NotFound means the requested block, rule or data doesn't exist
+	NotFound
+
+	// Conflict means the request clashes with existing state, e.g. a
+	// duplicate sample or rule
+	Conflict
+
+	// ResourceExhausted means the request exceeds a configured limit
+	ResourceExhausted
+
+	// Unavailable means the database can't serve the request right now,
+	// e.g. while closed or read-only; it may succeed later
+	Unavailable
+
+	// Corruption means data on disk failed to decode or verify
+	Corruption
+)
+
+// String returns the kind's error code, e.g. "not_found"
+func (k Kind) String() string {
+	switch k {
+	case Invalid:
+		return "invalid"
+	case NotFound:
+		return "not_found"
+	case Conflict:
+		return "conflict"
+	case ResourceExhausted:
+		return "resource_exhausted"
+	case Unavailable:
+		return "unavailable"
+	case Corruption:
+		return "corruption"
+	default:
+		return "unknown"
+	}
+}
+
+// Error implements the error interface
+func (k Kind) Error() string {
+	return k.String()
+}
+
+// kindError is an error classified by kind: a sentinel made by New, or an
+// error given a kind by WithKind
+type kindError struct {
+	kind Kind
+	msg  string
+	err  error // Wrapped error, if made by WithKind
+}
+
+// New returns a sentinel error of the given kind
+func New(kind Kind, msg string) error {
+	return &kindError{kind: kind, msg: msg}
+}
+
+// WithKind returns err classified as kind, keeping its message and chain.
+// It returns nil if err is nil.
+func WithKind(err error, kind Kind) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind: kind, err: err}
+}
+
+// Error implements the error interface
+func (e *kindError) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return e.msg
+}
+
+// Unwrap returns the error given a kind by WithKind
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+// Kind returns the error's kind
+func (e *kindError) Kind() Kind {
+	return e.kind
+}
+
+// Is reports whether target is the error's kind
+func (e *kindError) Is(target error) bool {
+	k, ok := target.(Kind)
+	return ok && k != Unknown && k == e.kind
+}
+
+// KindOf returns the kind of the outermost classified error in err's
+// chain, or Unknown if there is none
+func KindOf(err error) Kind {
+	var k interface{ Kind() Kind }
+	if errors.As(err, &k) {
+		return k.Kind()
+	}
+	return Unknown
+}
+
+// Code returns the error code of err's kind, e.g. "not_found"
+func Code(err error) string {
+	return KindOf(err).String()
+}
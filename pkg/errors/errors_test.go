@@ -0,0 +1,66 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestKinds(t *testing.T) {
+	errGone := New(NotFound, "gone")
+	wrapped := fmt.Errorf("loading block: %w", errGone)
+
+	if !errors.Is(wrapped, errGone) {
+		t.Error("wrapped sentinel not matched by errors.Is")
+	}
+	if !errors.Is(wrapped, NotFound) {
+		t.Error("wrapped sentinel not matched by its kind")
+	}
+	if errors.Is(wrapped, Conflict) {
+		t.Error("wrapped sentinel matched by another kind")
+	}
+	if got := KindOf(wrapped); got != NotFound {
+		t.Errorf("KindOf = %v, want %v", got, NotFound)
+	}
+	if got := Code(wrapped); got != "not_found" {
+		t.Errorf("Code = %q, want %q", got, "not_found")
+	}
+	if wrapped.Error() != "loading block: gone" {
+		t.Errorf("unexpected message %q", wrapped.Error())
+	}
+
+	plain := errors.New("disk on fire")
+	if got := KindOf(plain); got != Unknown {
+		t.Errorf("KindOf(plain) = %v, want %v", got, Unknown)
+	}
+	if errors.Is(plain, Unknown) {
+		t.Error("plain error matched Unknown")
+	}
+	if KindOf(nil) != Unknown {
+		t.Error("KindOf(nil) is not Unknown")
+	}
+}
+
+func TestWithKind(t *testing.T) {
+	if WithKind(nil, Corruption) != nil {
+		t.Error("WithKind(nil) is not nil")
+	}
+
+	cause := errors.New("bad checksum")
+	err := WithKind(fmt.Errorf("chunk 3: %w", cause), Corruption)
+	if err.Error() != "chunk 3: bad checksum" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+	if !errors.Is(err, cause) || !errors.Is(err, Corruption) {
+		t.Error("WithKind lost the cause or the kind")
+	}
+
+	// The outermost kind wins
+	err = WithKind(fmt.Errorf("restore: %w", New(NotFound, "gone")), Unavailable)
+	if got := KindOf(err); got != Unavailable {
+		t.Errorf("KindOf = %v, want %v", got, Unavailable)
+	}
+	if !errors.Is(err, NotFound) {
+		t.Error("inner kind not matched")
+	}
+}
@@ -0,0 +1,58 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// csvHeader names the CSV columns
+var csvHeader = []string{"name", "labels", "timestamp", "value"}
+
+// CSVWriter writes one row per sample: the metric name, the remaining labels
+// as a JSON object, the timestamp in Unix milliseconds and the value
+type CSVWriter struct {
+	w       *csv.Writer
+	started bool
+}
+
+// NewCSVWriter creates a CSV writer
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w)}
+}
+
+// WriteSeries writes a row per sample, preceded by the header on first use
+func (c *CSVWriter) WriteSeries(labels map[string]string, samples []series.Sample) error {
+	if !c.started {
+		if err := c.w.Write(csvHeader); err != nil {
+			return err
+		}
+		c.started = true
+	}
+
+	encodedLabels, err := labelsJSON(labels)
+	if err != nil {
+		return err
+	}
+
+	record := []string{labels[MetricNameLabel], encodedLabels, "", ""}
+	for _, sample := range samples {
+		record[2] = strconv.FormatInt(sample.Timestamp, 10)
+		record[3] = formatValue(sample.Value)
+		if err := c.w.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close writes the header if nothing was written, then flushes
+func (c *CSVWriter) Close() error {
+	if !c.started {
+		c.w.Write(csvHeader)
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
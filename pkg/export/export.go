@@ -0,0 +1,81 @@
+// Package export writes time series to formats other tools can read:
+// OpenMetrics text, CSV and Parquet.
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// MetricNameLabel is the label holding the metric name
+const MetricNameLabel = "__name__"
+
+// Format is an export format
+type Format string
+
+const (
+	FormatOpenMetrics Format = "openmetrics"
+	FormatCSV         Format = "csv"
+	FormatParquet     Format = "parquet"
+)
+
+// Writer writes series in an export format
+type Writer interface {
+	// WriteSeries writes the samples of one series. Callers should write
+	// each series once, grouped by metric name, with samples in timestamp
+	// order; OpenMetrics requires it.
+	WriteSeries(labels map[string]string, samples []series.Sample) error
+
+	// Close flushes buffered output and writes any trailer. It does not
+	// close the underlying io.Writer.
+	Close() error
+}
+
+// NewWriter returns a Writer for the format
+func NewWriter(w io.Writer, format Format) (Writer, error) {
+	switch format {
+	case FormatOpenMetrics:
+		return NewOpenMetricsWriter(w), nil
+	case FormatCSV:
+		return NewCSVWriter(w), nil
+	case FormatParquet:
+		return NewParquetWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q (want openmetrics, csv or parquet)", format)
+	}
+}
+
+// labelsJSON encodes the labels other than the metric name as a JSON object
+// with sorted keys
+func labelsJSON(labels map[string]string) (string, error) {
+	rest := make(map[string]string, len(labels))
+	for name, value := range labels {
+		if name != MetricNameLabel {
+			rest[name] = value
+		}
+	}
+	data, err := json.Marshal(rest)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// formatValue formats a sample value the way the Prometheus text formats do
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,191 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+var testSamples = []series.Sample{
+	{Timestamp: 1000, Value: 1},
+	{Timestamp: 2500, Value: math.Inf(1)},
+}
+
+func TestOpenMetricsWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewOpenMetricsWriter(&buf)
+
+	if err := w.WriteSeries(map[string]string{"__name__": "cpu", "host": "a", "path": `C:\"x"`}, testSamples); err != nil {
+		t.Fatalf("WriteSeries failed: %v", err)
+	}
+	if err := w.WriteSeries(map[string]string{"__name__": "cpu", "host": "b"}, testSamples[:1]); err != nil {
+		t.Fatalf("WriteSeries failed: %v", err)
+	}
+	if err := w.WriteSeries(map[string]string{"__name__": "up"}, testSamples[:1]); err != nil {
+		t.Fatalf("WriteSeries failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	want := `# TYPE cpu unknown
+cpu{host="a",path="C:\\\"x\""} 1 1
+cpu{host="a",path="C:\\\"x\""} +Inf 2.5
+cpu{host="b"} 1 1
+# TYPE up unknown
+up 1 1
+# EOF
+`
+	if got := buf.String(); got != want {
+		t.Errorf("output mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	if err := NewOpenMetricsWriter(&buf).WriteSeries(map[string]string{"host": "a"}, testSamples); err == nil {
+		t.Error("expected an error for a series without a metric name")
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewCSVWriter(&buf)
+
+	if err := w.WriteSeries(map[string]string{"__name__": "cpu", "host": "a"}, testSamples); err != nil {
+		t.Fatalf("WriteSeries failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	want := `name,labels,timestamp,value
+cpu,"{""host"":""a""}",1000,1
+cpu,"{""host"":""a""}",2500,+Inf
+`
+	if got := buf.String(); got != want {
+		t.Errorf("output mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewParquetWriter(&buf)
+	w.rowGroupRows = 3 // Force several row groups
+
+	for _, host := range []string{"a", "b"} {
+		if err := w.WriteSeries(map[string]string{"__name__": "cpu", "host": host}, testSamples); err != nil {
+			t.Fatalf("WriteSeries failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte(parquetMagic)) || !bytes.HasSuffix(data, []byte(parquetMagic)) {
+		t.Fatal("missing Parquet magic")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := data[len(data)-8-footerLen : len(data)-8]
+
+	r := &compactReader{buf: footer}
+	meta := r.readStruct()
+	if r.pos != len(footer) {
+		t.Fatalf("footer decoded %d of %d bytes", r.pos, len(footer))
+	}
+
+	if numRows := meta[3].(int64); numRows != 4 {
+		t.Errorf("num_rows = %d, want 4", numRows)
+	}
+	rowGroups := meta[4].([]any)
+	if len(rowGroups) != 2 {
+		t.Fatalf("expected 2 row groups, got %d", len(rowGroups))
+	}
+
+	// Read the timestamp column back from every row group
+	var timestamps []int64
+	for _, rg := range rowGroups {
+		chunks := rg.(map[int16]any)[1].([]any)
+		columnMeta := chunks[2].(map[int16]any)[3].(map[int16]any)
+		if path := columnMeta[3].([]any)[0].(string); path != "timestamp" {
+			t.Fatalf("column 2 is %q, want timestamp", path)
+		}
+
+		offset := int(columnMeta[9].(int64))
+		pr := &compactReader{buf: data[offset:]}
+		pageHeader := pr.readStruct()
+		numValues := int(pageHeader[5].(map[int16]any)[1].(int64))
+		values := data[offset+pr.pos:]
+		for i := 0; i < numValues; i++ {
+			timestamps = append(timestamps, int64(binary.LittleEndian.Uint64(values[i*8:])))
+		}
+	}
+	if got := fmt.Sprint(timestamps); got != "[1000 2500 1000 2500]" {
+		t.Errorf("timestamps = %s, want [1000 2500 1000 2500]", got)
+	}
+}
+
+// compactReader decodes the Thrift compact protocol into maps keyed by
+// field ID, enough to check what ParquetWriter writes
+type compactReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *compactReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *compactReader) value(typ byte) any {
+	switch typ {
+	case compactI32, compactI64:
+		v, n := binary.Varint(r.buf[r.pos:])
+		r.pos += n
+		return v
+	case compactBinary:
+		n := int(r.uvarint())
+		v := string(r.buf[r.pos : r.pos+n])
+		r.pos += n
+		return v
+	case compactList:
+		header := r.buf[r.pos]
+		r.pos++
+		n, elemType := int(header>>4), header&0x0f
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i] = r.value(elemType)
+		}
+		return list
+	case compactStruct:
+		return r.readStruct()
+	default:
+		panic(fmt.Sprintf("unexpected compact type %d", typ))
+	}
+}
+
+func (r *compactReader) readStruct() map[int16]any {
+	fields := make(map[int16]any)
+	var last int16
+	for {
+		header := r.buf[r.pos]
+		r.pos++
+		if header == 0 {
+			return fields
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			v, n := binary.Varint(r.buf[r.pos:])
+			r.pos += n
+			id = int16(v)
+		}
+		fields[id] = r.value(header & 0x0f)
+		last = id
+	}
+}
@@ -0,0 +1,88 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// OpenMetricsWriter writes series in the OpenMetrics text format. Every
+// metric family is written with type unknown, since the stored samples
+// don't say how a family's series relate to each other.
+type OpenMetricsWriter struct {
+	w          *bufio.Writer
+	lastFamily string
+	started    bool
+}
+
+// NewOpenMetricsWriter creates an OpenMetrics writer
+func NewOpenMetricsWriter(w io.Writer) *OpenMetricsWriter {
+	return &OpenMetricsWriter{w: bufio.NewWriter(w)}
+}
+
+// labelEscaper escapes label values for the text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteSeries writes a series' samples, starting a new metric family if the
+// metric name changed. Series without a metric name are rejected.
+func (o *OpenMetricsWriter) WriteSeries(labels map[string]string, samples []series.Sample) error {
+	name := labels[MetricNameLabel]
+	if name == "" {
+		return fmt.Errorf("series %s has no metric name, which OpenMetrics requires", series.NewSeries(labels).String())
+	}
+
+	if !o.started || name != o.lastFamily {
+		fmt.Fprintf(o.w, "# TYPE %s unknown\n", name)
+		o.lastFamily = name
+		o.started = true
+	}
+
+	names := make([]string, 0, len(labels))
+	for labelName := range labels {
+		if labelName != MetricNameLabel {
+			names = append(names, labelName)
+		}
+	}
+	sort.Strings(names)
+
+	var prefix strings.Builder
+	prefix.WriteString(name)
+	if len(names) > 0 {
+		prefix.WriteByte('{')
+		for i, labelName := range names {
+			if i > 0 {
+				prefix.WriteByte(',')
+			}
+			prefix.WriteString(labelName)
+			prefix.WriteString(`="`)
+			prefix.WriteString(labelEscaper.Replace(labels[labelName]))
+			prefix.WriteByte('"')
+		}
+		prefix.WriteByte('}')
+	}
+	line := prefix.String()
+
+	for _, sample := range samples {
+		o.w.WriteString(line)
+		o.w.WriteByte(' ')
+		o.w.WriteString(formatValue(sample.Value))
+		o.w.WriteByte(' ')
+		// OpenMetrics timestamps are in seconds
+		o.w.WriteString(strconv.FormatFloat(float64(sample.Timestamp)/1000, 'f', -1, 64))
+		if err := o.w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close writes the EOF marker and flushes
+func (o *OpenMetricsWriter) Close() error {
+	o.w.WriteString("# EOF\n")
+	return o.w.Flush()
+}
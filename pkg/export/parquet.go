@@ -0,0 +1,262 @@
+package export
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// DefaultParquetRowGroupRows is the number of rows buffered per row group
+const DefaultParquetRowGroupRows = 1 << 17
+
+// parquetMagic starts and ends every Parquet file
+const parquetMagic = "PAR1"
+
+// Parquet enum values (see parquet.thrift)
+const (
+	parquetTypeInt64     = 2
+	parquetTypeDouble    = 5
+	parquetTypeByteArray = 6
+
+	parquetRequired = 0
+
+	parquetConvertedUTF8            = 0
+	parquetConvertedTimestampMillis = 9
+
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+
+	parquetCodecUncompressed = 0
+	parquetPageData          = 0
+)
+
+// parquetColumn is a column of the export schema
+type parquetColumn struct {
+	name          string
+	physicalType  int32
+	convertedType int32
+}
+
+// parquetColumns is the export schema: one row per sample
+var parquetColumns = []parquetColumn{
+	{name: "name", physicalType: parquetTypeByteArray, convertedType: parquetConvertedUTF8},
+	{name: "labels", physicalType: parquetTypeByteArray, convertedType: parquetConvertedUTF8},
+	{name: "timestamp", physicalType: parquetTypeInt64, convertedType: parquetConvertedTimestampMillis},
+	{name: "value", physicalType: parquetTypeDouble, convertedType: -1},
+}
+
+// parquetChunkMeta locates a written column chunk
+type parquetChunkMeta struct {
+	offset int64 // Offset of the data page header
+	size   int64 // Page header plus data
+}
+
+// parquetRowGroup records a written row group for the footer
+type parquetRowGroup struct {
+	numRows int64
+	chunks  []parquetChunkMeta
+}
+
+// ParquetWriter writes one row per sample with the columns name (string),
+// labels (string holding a JSON object of the remaining labels), timestamp
+// (INT64, TIMESTAMP_MILLIS) and value (DOUBLE). Columns are PLAIN encoded
+// and uncompressed. Rows are buffered and written in row groups of
+// DefaultParquetRowGroupRows; the footer is written on Close.
+type ParquetWriter struct {
+	w      io.Writer
+	offset int64
+	err    error
+
+	// PLAIN-encoded values of the current row group, one buffer per column
+	columns [][]byte
+	rows    int64
+
+	rowGroupRows int64
+	rowGroups    []parquetRowGroup
+}
+
+// NewParquetWriter creates a Parquet writer
+func NewParquetWriter(w io.Writer) *ParquetWriter {
+	return &ParquetWriter{
+		w:            w,
+		columns:      make([][]byte, len(parquetColumns)),
+		rowGroupRows: DefaultParquetRowGroupRows,
+	}
+}
+
+// WriteSeries buffers a row per sample, writing out full row groups
+func (p *ParquetWriter) WriteSeries(labels map[string]string, samples []series.Sample) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	encodedLabels, err := labelsJSON(labels)
+	if err != nil {
+		return err
+	}
+	name := labels[MetricNameLabel]
+
+	for _, sample := range samples {
+		p.columns[0] = appendByteArray(p.columns[0], name)
+		p.columns[1] = appendByteArray(p.columns[1], encodedLabels)
+		p.columns[2] = binary.LittleEndian.AppendUint64(p.columns[2], uint64(sample.Timestamp))
+		p.columns[3] = binary.LittleEndian.AppendUint64(p.columns[3], math.Float64bits(sample.Value))
+		p.rows++
+
+		if p.rows >= p.rowGroupRows {
+			if err := p.flushRowGroup(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close writes the last row group and the footer
+func (p *ParquetWriter) Close() error {
+	if p.err != nil {
+		return p.err
+	}
+	if err := p.flushRowGroup(); err != nil {
+		return err
+	}
+	if err := p.start(); err != nil {
+		return err
+	}
+
+	footer := p.encodeFileMetaData()
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	footer = append(footer, parquetMagic...)
+	return p.write(footer)
+}
+
+// start writes the leading magic once
+func (p *ParquetWriter) start() error {
+	if p.offset > 0 {
+		return nil
+	}
+	return p.write([]byte(parquetMagic))
+}
+
+// write writes to the underlying writer, tracking the offset and the first error
+func (p *ParquetWriter) write(data []byte) error {
+	if p.err != nil {
+		return p.err
+	}
+	n, err := p.w.Write(data)
+	p.offset += int64(n)
+	if err != nil {
+		p.err = err
+	}
+	return err
+}
+
+// flushRowGroup writes the buffered rows as a row group with one data page
+// per column
+func (p *ParquetWriter) flushRowGroup() error {
+	if p.rows == 0 {
+		return nil
+	}
+	if err := p.start(); err != nil {
+		return err
+	}
+
+	rowGroup := parquetRowGroup{numRows: p.rows}
+	for i, data := range p.columns {
+		header := encodeDataPageHeader(int32(p.rows), int32(len(data)))
+		chunk := parquetChunkMeta{offset: p.offset, size: int64(len(header) + len(data))}
+		if err := p.write(header); err != nil {
+			return err
+		}
+		if err := p.write(data); err != nil {
+			return err
+		}
+		rowGroup.chunks = append(rowGroup.chunks, chunk)
+		p.columns[i] = data[:0]
+	}
+
+	p.rowGroups = append(p.rowGroups, rowGroup)
+	p.rows = 0
+	return nil
+}
+
+// appendByteArray appends a PLAIN-encoded BYTE_ARRAY value
+func appendByteArray(buf []byte, s string) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
+
+// encodeDataPageHeader encodes a PageHeader for an uncompressed v1 data page
+func encodeDataPageHeader(numValues, size int32) []byte {
+	return encodeStruct(func(s *structWriter) {
+		s.i32(1, parquetPageData)
+		s.i32(2, size) // uncompressed_page_size
+		s.i32(3, size) // compressed_page_size
+		s.structField(5, func(s *structWriter) {
+			s.i32(1, numValues)
+			s.i32(2, parquetEncodingPlain)
+			s.i32(3, parquetEncodingRLE) // definition levels (none for required columns)
+			s.i32(4, parquetEncodingRLE) // repetition levels
+		})
+	})
+}
+
+// encodeFileMetaData encodes the footer's FileMetaData
+func (p *ParquetWriter) encodeFileMetaData() []byte {
+	var numRows int64
+	for _, rowGroup := range p.rowGroups {
+		numRows += rowGroup.numRows
+	}
+
+	return encodeStruct(func(s *structWriter) {
+		s.i32(1, 1) // version
+
+		// Schema: the root followed by its leaf columns
+		s.listStruct(2, len(parquetColumns)+1, func(i int, s *structWriter) {
+			if i == 0 {
+				s.binary(4, "schema")
+				s.i32(5, int32(len(parquetColumns)))
+				return
+			}
+			column := parquetColumns[i-1]
+			s.i32(1, column.physicalType)
+			s.i32(3, parquetRequired)
+			s.binary(4, column.name)
+			if column.convertedType >= 0 {
+				s.i32(6, column.convertedType)
+			}
+		})
+
+		s.i64(3, numRows)
+
+		s.listStruct(4, len(p.rowGroups), func(i int, s *structWriter) {
+			rowGroup := p.rowGroups[i]
+			var totalSize int64
+			for _, chunk := range rowGroup.chunks {
+				totalSize += chunk.size
+			}
+
+			s.listStruct(1, len(rowGroup.chunks), func(j int, s *structWriter) {
+				chunk := rowGroup.chunks[j]
+				column := parquetColumns[j]
+				s.i64(2, chunk.offset) // file_offset
+				s.structField(3, func(s *structWriter) {
+					s.i32(1, column.physicalType)
+					s.listI32(2, []int32{parquetEncodingPlain, parquetEncodingRLE})
+					s.listBinary(3, []string{column.name})
+					s.i32(4, parquetCodecUncompressed)
+					s.i64(5, rowGroup.numRows)
+					s.i64(6, chunk.size) // total_uncompressed_size
+					s.i64(7, chunk.size) // total_compressed_size
+					s.i64(9, chunk.offset)
+				})
+			})
+			s.i64(2, totalSize)
+			s.i64(3, rowGroup.numRows)
+		})
+
+		s.binary(6, "tsdb dump")
+	})
+}
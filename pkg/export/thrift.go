@@ -0,0 +1,105 @@
+package export
+
+import "encoding/binary"
+
+// Thrift compact protocol type IDs
+const (
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+// structWriter encodes a struct in the Thrift compact protocol, which
+// Parquet uses for page headers and the file footer. Fields must be written
+// in increasing ID order.
+type structWriter struct {
+	buf  *[]byte
+	last int16
+}
+
+// encodeStruct encodes the struct written by fn
+func encodeStruct(fn func(s *structWriter)) []byte {
+	var buf []byte
+	s := &structWriter{buf: &buf}
+	fn(s)
+	s.end()
+	return buf
+}
+
+// field writes a field header, using the short delta form where possible
+func (s *structWriter) field(id int16, typ byte) {
+	if delta := id - s.last; delta > 0 && delta <= 15 {
+		*s.buf = append(*s.buf, byte(delta)<<4|typ)
+	} else {
+		*s.buf = append(*s.buf, typ)
+		*s.buf = binary.AppendVarint(*s.buf, int64(id))
+	}
+	s.last = id
+}
+
+// end writes the stop field
+func (s *structWriter) end() {
+	*s.buf = append(*s.buf, 0)
+}
+
+func (s *structWriter) i32(id int16, v int32) {
+	s.field(id, compactI32)
+	*s.buf = binary.AppendVarint(*s.buf, int64(v))
+}
+
+func (s *structWriter) i64(id int16, v int64) {
+	s.field(id, compactI64)
+	*s.buf = binary.AppendVarint(*s.buf, v)
+}
+
+func (s *structWriter) binary(id int16, v string) {
+	s.field(id, compactBinary)
+	*s.buf = binary.AppendUvarint(*s.buf, uint64(len(v)))
+	*s.buf = append(*s.buf, v...)
+}
+
+// structField writes a nested struct field
+func (s *structWriter) structField(id int16, fn func(s *structWriter)) {
+	s.field(id, compactStruct)
+	inner := &structWriter{buf: s.buf}
+	fn(inner)
+	inner.end()
+}
+
+// listHeader writes a list field header
+func (s *structWriter) listHeader(id int16, elemType byte, n int) {
+	s.field(id, compactList)
+	if n < 15 {
+		*s.buf = append(*s.buf, byte(n)<<4|elemType)
+		return
+	}
+	*s.buf = append(*s.buf, 0xf0|elemType)
+	*s.buf = binary.AppendUvarint(*s.buf, uint64(n))
+}
+
+func (s *structWriter) listI32(id int16, values []int32) {
+	s.listHeader(id, compactI32, len(values))
+	for _, v := range values {
+		*s.buf = binary.AppendVarint(*s.buf, int64(v))
+	}
+}
+
+func (s *structWriter) listBinary(id int16, values []string) {
+	s.listHeader(id, compactBinary, len(values))
+	for _, v := range values {
+		*s.buf = binary.AppendUvarint(*s.buf, uint64(len(v)))
+		*s.buf = append(*s.buf, v...)
+	}
+}
+
+// listStruct writes a list of n structs, each encoded by fn
+func (s *structWriter) listStruct(id int16, n int, fn func(i int, s *structWriter)) {
+	s.listHeader(id, compactStruct, n)
+	for i := 0; i < n; i++ {
+		elem := &structWriter{buf: s.buf}
+		fn(i, elem)
+		elem.end()
+	}
+}
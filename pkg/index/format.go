@@ -0,0 +1,393 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/RoaringBitmap/roaring"
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+)
+
+const (
+	// IndexMagic identifies an index file ("TSDX")
+	IndexMagic = uint32(0x54534458)
+
+	// IndexFormatV1 is the original format: label names and values inline,
+	// each followed by its posting list. It must be decoded in full.
+	IndexFormatV1 = uint32(1)
+
+	// IndexFormatV2 adds a symbol table, a postings offset table and a
+	// table of contents so the file can be memory-mapped and posting lists
+	// decoded on demand.
+	IndexFormatV2 = uint32(2)
+
+	// IndexFormatVersion is the format written by WriteTo
+	IndexFormatVersion = IndexFormatV2
+
+	// indexHeaderSize is the size of the magic number and version
+	indexHeaderSize = 8
+
+	// indexTOCSize is the size of the v2 table of contents
+	indexTOCSize = 32
+
+	// offsetEntrySize is the size of one postings offset table entry
+	offsetEntrySize = 20
+)
+
+// offsetEntry is one entry of the v2 postings offset table
+type offsetEntry struct {
+	NameRef  uint32 // Symbol ref of the label name
+	ValueRef uint32 // Symbol ref of the label value
+	Offset   uint64 // File offset of the posting list
+	Length   uint32 // Length of the posting list in bytes
+}
+
+var (
+	// ErrInvalidIndex indicates a malformed or truncated index file
+	ErrInvalidIndex = tsdberrors.New(tsdberrors.Corruption, "invalid index")
+
+	// ErrIndexVersionTooNew indicates an index written by a newer release
+	ErrIndexVersionTooNew = errors.New("index format version too new")
+
+	// ErrLegacyIndexFormat indicates a v1 index, which cannot be memory-mapped
+	// and must be loaded with InvertedIndex.ReadFrom instead
+	ErrLegacyIndexFormat = errors.New("legacy index format")
+)
+
+// WriteTo writes the index to the given writer in the v2 binary format.
+// All integers are little endian.
+//
+// Format:
+//   - Header: magic number (4 bytes) + version (4 bytes)
+//   - Symbol table:
+//   - Number of symbols (4 bytes)
+//   - For each symbol, sorted: length (4 bytes) + bytes
+//   - Postings: roaring bitmaps back to back, in offset table order
+//   - Postings offset table:
+//   - Number of entries (4 bytes)
+//   - For each label pair, sorted by name then value:
+//     name symbol ref (4 bytes), value symbol ref (4 bytes),
+//     postings offset (8 bytes), postings length (4 bytes)
+//   - TOC: symbol table offset, postings offset, offset table offset,
+//     series count (8 bytes each)
+//
+// The posting list of all series is stored under the empty label pair,
+// which can never be a real label since label names must be non-empty.
+func (idx *InvertedIndex) WriteTo(w io.Writer) (int64, error) {
+	idx.rlockAll()
+	defer idx.runlockAll()
+
+	index := idx.mergedPostings()
+
+	// Collect label pairs in sorted order, with the all-series pair first
+	type pair struct {
+		name, value string
+		bitmap      *roaring.Bitmap
+	}
+	pairs := []pair{{"", "", idx.all}}
+	symbolSet := map[string]struct{}{"": {}}
+
+	names := make([]string, 0, len(index))
+	for name := range index {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		symbolSet[name] = struct{}{}
+
+		values := make([]string, 0, len(index[name]))
+		for value := range index[name] {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+
+		for _, value := range values {
+			symbolSet[value] = struct{}{}
+			pairs = append(pairs, pair{name, value, index[name][value]})
+		}
+	}
+
+	symbols := make([]string, 0, len(symbolSet))
+	for s := range symbolSet {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+	symbolRefs := make(map[string]uint32, len(symbols))
+	for i, s := range symbols {
+		symbolRefs[s] = uint32(i)
+	}
+
+	buf := new(bytes.Buffer)
+
+	// Write header
+	if err := binary.Write(buf, binary.LittleEndian, IndexMagic); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(buf, binary.LittleEndian, IndexFormatVersion); err != nil {
+		return 0, err
+	}
+
+	// Write symbol table
+	symbolsOffset := uint64(buf.Len())
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(symbols))); err != nil {
+		return 0, err
+	}
+	for _, s := range symbols {
+		if err := writeString(buf, s); err != nil {
+			return 0, err
+		}
+	}
+
+	// Write postings, remembering where each one starts
+	postingsOffset := uint64(buf.Len())
+	offsets := make([]uint64, len(pairs))
+	lengths := make([]uint32, len(pairs))
+	for i, p := range pairs {
+		bitmapBytes, err := p.bitmap.ToBytes()
+		if err != nil {
+			return 0, fmt.Errorf("failed to serialize bitmap: %w", err)
+		}
+		offsets[i] = uint64(buf.Len())
+		lengths[i] = uint32(len(bitmapBytes))
+		if _, err := buf.Write(bitmapBytes); err != nil {
+			return 0, err
+		}
+	}
+
+	// Write postings offset table
+	offsetTableOffset := uint64(buf.Len())
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(pairs))); err != nil {
+		return 0, err
+	}
+	for i, p := range pairs {
+		entry := offsetEntry{
+			NameRef:  symbolRefs[p.name],
+			ValueRef: symbolRefs[p.value],
+			Offset:   offsets[i],
+			Length:   lengths[i],
+		}
+		if err := binary.Write(buf, binary.LittleEndian, entry); err != nil {
+			return 0, err
+		}
+	}
+
+	// Write TOC
+	toc := [4]uint64{symbolsOffset, postingsOffset, offsetTableOffset, idx.all.GetCardinality()}
+	if err := binary.Write(buf, binary.LittleEndian, toc); err != nil {
+		return 0, err
+	}
+
+	// Write to the actual writer
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// ReadFrom reads the index from the given reader, decoding every posting
+// list into memory. Both the v1 and v2 formats are supported.
+func (idx *InvertedIndex) ReadFrom(r io.Reader) (int64, error) {
+	// Read all data into buffer
+	buf := new(bytes.Buffer)
+	n, err := buf.ReadFrom(r)
+	if err != nil {
+		return n, err
+	}
+
+	version, err := readIndexHeader(buf.Bytes())
+	if err != nil {
+		return n, err
+	}
+
+	// Decode into a fresh index and swap it in once complete
+	loaded := NewInvertedIndex()
+	switch version {
+	case IndexFormatV1:
+		err = loaded.readV1(buf.Bytes()[indexHeaderSize:])
+	default:
+		err = loaded.readV2(buf.Bytes())
+	}
+	if err != nil {
+		return n, err
+	}
+
+	for i, sh := range idx.shards {
+		sh.mu.Lock()
+		sh.postings = loaded.shards[i].postings
+		sh.mu.Unlock()
+	}
+	idx.allMu.Lock()
+	idx.all = loaded.all
+	idx.allMu.Unlock()
+	idx.usageMu.Lock()
+	idx.usage = loaded.usage
+	idx.usageMu.Unlock()
+
+	return n, nil
+}
+
+// readIndexHeader verifies the magic number and returns the format version.
+// Versions newer than IndexFormatVersion are rejected.
+func readIndexHeader(data []byte) (uint32, error) {
+	if len(data) < indexHeaderSize {
+		return 0, fmt.Errorf("%w: file too short", ErrInvalidIndex)
+	}
+	if magic := binary.LittleEndian.Uint32(data[0:4]); magic != IndexMagic {
+		return 0, fmt.Errorf("invalid magic number: 0x%x", magic)
+	}
+	version := binary.LittleEndian.Uint32(data[4:8])
+	if version > IndexFormatVersion {
+		return 0, fmt.Errorf("%w: file has version %d, this build supports up to %d",
+			ErrIndexVersionTooNew, version, IndexFormatVersion)
+	}
+	if version < IndexFormatV1 {
+		return 0, fmt.Errorf("unsupported version: %d", version)
+	}
+	return version, nil
+}
+
+// ReadFormatVersion returns the format version of the index file at path
+// without decoding it.
+func ReadFormatVersion(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	header := make([]byte, indexHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return 0, fmt.Errorf("%w: failed to read header: %v", ErrInvalidIndex, err)
+	}
+	return readIndexHeader(header)
+}
+
+// readV2 decodes a v2 index into idx, which must be empty.
+func (idx *InvertedIndex) readV2(data []byte) error {
+	mi, err := NewMappedIndex(data)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range mi.names {
+		for _, ref := range mi.postings[name] {
+			bitmap, err := mi.decode(ref)
+			if err != nil {
+				return err
+			}
+			idx.insertPostings(name, ref.value, bitmap)
+		}
+	}
+
+	all, err := mi.decode(mi.allRef)
+	if err != nil {
+		return err
+	}
+	idx.all = all
+	return nil
+}
+
+// readV1 decodes a v1 index body (everything after the header) into idx,
+// which must be empty.
+func (idx *InvertedIndex) readV1(data []byte) error {
+	buf := bytes.NewBuffer(data)
+
+	// Read series count. The count is derived from the posting lists
+	// below, so the header value is informational only.
+	var seriesCount uint64
+	if err := binary.Read(buf, binary.LittleEndian, &seriesCount); err != nil {
+		return err
+	}
+
+	// Read number of label names
+	var labelCount uint32
+	if err := binary.Read(buf, binary.LittleEndian, &labelCount); err != nil {
+		return err
+	}
+
+	// Read each label name and its values
+	for i := 0; i < int(labelCount); i++ {
+		// Read label name
+		name, err := readString(buf)
+		if err != nil {
+			return err
+		}
+
+		// Read number of values
+		var valueCount uint32
+		if err := binary.Read(buf, binary.LittleEndian, &valueCount); err != nil {
+			return err
+		}
+
+		// Read each value and its bitmap
+		for j := 0; j < int(valueCount); j++ {
+			// Read value
+			value, err := readString(buf)
+			if err != nil {
+				return err
+			}
+
+			// Read bitmap length
+			var bitmapLen uint32
+			if err := binary.Read(buf, binary.LittleEndian, &bitmapLen); err != nil {
+				return err
+			}
+
+			// Read bitmap data
+			bitmapBytes := make([]byte, bitmapLen)
+			if _, err := io.ReadFull(buf, bitmapBytes); err != nil {
+				return err
+			}
+
+			// Deserialize bitmap
+			bitmap := roaring.New()
+			if err := bitmap.UnmarshalBinary(bitmapBytes); err != nil {
+				return fmt.Errorf("failed to deserialize bitmap: %w", err)
+			}
+
+			idx.insertPostings(name, value, bitmap)
+			idx.all.Or(bitmap)
+		}
+	}
+
+	return nil
+}
+
+// insertPostings stores a decoded posting list. Only used while building
+// an index that is not yet shared, so no locks are taken.
+func (idx *InvertedIndex) insertPostings(name, value string, bitmap *roaring.Bitmap) {
+	sh := idx.shardFor(name, value)
+	if _, exists := sh.postings[name]; !exists {
+		sh.postings[name] = make(map[string]*roaring.Bitmap)
+	}
+	sh.postings[name][value] = bitmap
+	idx.usage[name] += postingsSize(value, bitmap)
+}
+
+// writeString writes a length-prefixed string to the buffer.
+func writeString(buf *bytes.Buffer, s string) error {
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(s))); err != nil {
+		return err
+	}
+	_, err := buf.WriteString(s)
+	return err
+}
+
+// readString reads a length-prefixed string from the buffer.
+func readString(buf *bytes.Buffer) (string, error) {
+	var length uint32
+	if err := binary.Read(buf, binary.LittleEndian, &length); err != nil {
+		return "", err
+	}
+
+	bytes := make([]byte, length)
+	if _, err := io.ReadFull(buf, bytes); err != nil {
+		return "", err
+	}
+
+	return string(bytes), nil
+}
@@ -1,10 +1,7 @@
 package index
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
-	"io"
 	"sort"
 	"sync"
 
@@ -12,6 +9,9 @@ import (
 	"github.com/therealutkarshpriyadarshi/time/pkg/series"
 )
 
+// MetricNameLabel is the reserved label holding the metric name.
+const MetricNameLabel = "__name__"
+
 // InvertedIndex is an inverted index for fast label-based series lookup.
 // It maps label name-value pairs to posting lists (sets of series IDs).
 //
@@ -27,29 +27,81 @@ import (
 //   - Find all series with host="server1"
 //   - Find all series with host="server1" AND metric="cpu"
 //   - Find all series with host=~"server.*"
+//
+// Posting lists are spread over indexShards shards by a hash of the label
+// name-value pair, each with its own lock, so concurrent Add calls for
+// different series rarely contend. Sharding by pair rather than by name
+// matters because every series carries the same few label names
+// (__name__, job, instance). Lookups are not atomic across shards: a series
+// added concurrently with a Lookup may or may not be part of the result.
 type InvertedIndex struct {
-	mu sync.RWMutex
+	shards [indexShards]*indexShard
+
+	// allMu guards all
+	allMu sync.RWMutex
+
+	// all is the posting list of every indexed series, maintained
+	// incrementally so negative matchers don't have to union the whole index
+	all *roaring.Bitmap
 
-	// index maps label name -> label value -> posting list (bitmap of series IDs)
-	index map[string]map[string]*roaring.Bitmap
+	// usageMu guards usage. It may be taken while holding a shard lock,
+	// never the other way round.
+	usageMu sync.Mutex
 
-	// labelNames tracks all unique label names for efficient iteration
-	labelNames map[string]struct{}
+	// usage is the approximate memory held by the posting lists of each
+	// label name (see postingsSize), maintained as they change
+	usage map[string]int64
+}
+
+// indexShards is the number of posting list shards. Must be a power of two.
+const indexShards = 16
 
-	// labelValues tracks all unique label name-value pairs for cardinality tracking
-	labelValues map[string]map[string]struct{}
+// postingsOverhead approximates the memory of a label value's map entry
+// and bitmap header, on top of the value itself and the bitmap's containers
+const postingsOverhead = 64
 
-	// seriesCount is the total number of series indexed
-	seriesCount int
+// indexShard holds the posting lists of the label pairs hashing to it.
+type indexShard struct {
+	mu sync.RWMutex
+
+	// postings maps label name -> label value -> posting list
+	postings map[string]map[string]*roaring.Bitmap
+}
+
+func newIndexShard() *indexShard {
+	return &indexShard{postings: make(map[string]map[string]*roaring.Bitmap)}
+}
+
+// get returns the posting list for a label pair without copying it, or nil.
+// Must be called with the shard lock held and the result must not be modified.
+func (sh *indexShard) get(name, value string) *roaring.Bitmap {
+	if values, exists := sh.postings[name]; exists {
+		return values[value]
+	}
+	return nil
 }
 
 // NewInvertedIndex creates a new inverted index.
 func NewInvertedIndex() *InvertedIndex {
-	return &InvertedIndex{
-		index:       make(map[string]map[string]*roaring.Bitmap),
-		labelNames:  make(map[string]struct{}),
-		labelValues: make(map[string]map[string]struct{}),
+	idx := &InvertedIndex{all: roaring.New(), usage: make(map[string]int64)}
+	for i := range idx.shards {
+		idx.shards[i] = newIndexShard()
+	}
+	return idx
+}
+
+// shardFor returns the shard holding the posting list of a label pair.
+func (idx *InvertedIndex) shardFor(name, value string) *indexShard {
+	// FNV-1a over name, a separator and value, inlined to avoid allocating
+	h := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		h = (h ^ uint32(name[i])) * 16777619
+	}
+	h = (h ^ 0xff) * 16777619
+	for i := 0; i < len(value); i++ {
+		h = (h ^ uint32(value[i])) * 16777619
 	}
+	return idx.shards[h&(indexShards-1)]
 }
 
 // Add adds a series to the index with the given series ID and labels.
@@ -62,59 +114,144 @@ func (idx *InvertedIndex) Add(id series.SeriesID, labels map[string]string) erro
 		return fmt.Errorf("labels cannot be empty")
 	}
 
-	idx.mu.Lock()
-	defer idx.mu.Unlock()
-
 	// Add to posting lists for each label
 	for name, value := range labels {
-		// Ensure the label name exists in the index
-		if _, exists := idx.index[name]; !exists {
-			idx.index[name] = make(map[string]*roaring.Bitmap)
-			idx.labelNames[name] = struct{}{}
+		sh := idx.shardFor(name, value)
+		sh.mu.Lock()
+
+		// Ensure the label name exists in the shard
+		values, exists := sh.postings[name]
+		if !exists {
+			values = make(map[string]*roaring.Bitmap)
+			sh.postings[name] = values
 		}
 
 		// Ensure the label value exists
-		if _, exists := idx.index[name][value]; !exists {
-			idx.index[name][value] = roaring.New()
-		}
-
-		// Track label value for cardinality
-		if _, exists := idx.labelValues[name]; !exists {
-			idx.labelValues[name] = make(map[string]struct{})
+		bitmap, exists := values[value]
+		var before int64
+		if exists {
+			before = postingsSize(value, bitmap)
+		} else {
+			bitmap = roaring.New()
+			values[value] = bitmap
 		}
-		idx.labelValues[name][value] = struct{}{}
 
 		// Add series ID to the posting list
-		idx.index[name][value].Add(uint32(id))
+		if bitmap.CheckedAdd(uint32(id)) {
+			idx.account(name, postingsSize(value, bitmap)-before)
+		}
+		sh.mu.Unlock()
 	}
 
-	idx.seriesCount++
+	idx.allMu.Lock()
+	idx.all.Add(uint32(id))
+	idx.allMu.Unlock()
 	return nil
 }
 
+// addPostings adds the series in bitmap to the posting list of a label
+// pair. The all-series posting list is left to the caller.
+func (idx *InvertedIndex) addPostings(name, value string, bitmap *roaring.Bitmap) {
+	sh := idx.shardFor(name, value)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	values, exists := sh.postings[name]
+	if !exists {
+		values = make(map[string]*roaring.Bitmap)
+		sh.postings[name] = values
+	}
+	if existing, exists := values[value]; exists {
+		before := postingsSize(value, existing)
+		existing.Or(bitmap)
+		idx.account(name, postingsSize(value, existing)-before)
+	} else {
+		values[value] = bitmap.Clone()
+		idx.account(name, postingsSize(value, values[value]))
+	}
+}
+
+// postingsSize approximates the memory held by the posting list of a
+// label value
+func postingsSize(value string, bitmap *roaring.Bitmap) int64 {
+	return int64(len(value)) + postingsOverhead + int64(bitmap.GetSizeInBytes())
+}
+
+// account adds delta bytes to the memory of a label name's posting lists
+func (idx *InvertedIndex) account(name string, delta int64) {
+	idx.usageMu.Lock()
+	defer idx.usageMu.Unlock()
+	if idx.usage[name] += delta; idx.usage[name] <= 0 {
+		delete(idx.usage, name)
+	}
+}
+
+// LabelMemoryBytes returns the approximate memory held by the posting
+// lists of a label name: its values, their bitmaps and the maps holding
+// them. It grows with both the number of distinct values and the number
+// of series carrying the label.
+func (idx *InvertedIndex) LabelMemoryBytes(name string) int64 {
+	idx.usageMu.Lock()
+	defer idx.usageMu.Unlock()
+	return idx.usage[name]
+}
+
+// LabelMemory returns LabelMemoryBytes of every label name. Unlike Stats
+// it doesn't walk the posting lists, so it is cheap however many values
+// a label has.
+func (idx *InvertedIndex) LabelMemory() map[string]int64 {
+	idx.usageMu.Lock()
+	defer idx.usageMu.Unlock()
+	usage := make(map[string]int64, len(idx.usage))
+	for name, bytes := range idx.usage {
+		usage[name] = bytes
+	}
+	return usage
+}
+
 // Lookup finds all series IDs that match the given matchers.
 // All matchers must be satisfied (AND operation).
 // Returns a roaring bitmap of matching series IDs.
+//
+// Matchers are planned before evaluation: the metric name (__name__="...")
+// is resolved first, then other positive matchers, and negative matchers
+// last. Once a candidate set exists, negative matchers are applied as a
+// single AndNot against it instead of scanning all series.
 func (idx *InvertedIndex) Lookup(matchers Matchers) (*roaring.Bitmap, error) {
 	if len(matchers) == 0 {
 		return nil, fmt.Errorf("at least one matcher required")
 	}
 
-	idx.mu.RLock()
-	defer idx.mu.RUnlock()
-
-	// Start with all series (universal set)
 	var result *roaring.Bitmap
 
 	// Process each matcher and intersect results
-	for i, m := range matchers {
-		matchedIDs := idx.lookupMatcher(m)
-
-		if i == 0 {
-			result = matchedIDs
-		} else {
+	for i, m := range planMatchers(matchers) {
+		switch {
+		case i == 0:
+			result = idx.lookupMatcher(m)
+		case m.Type == MatchNotEqual:
+			// Remove series with the exact label value
+			sh := idx.shardFor(m.Name, m.Value)
+			sh.mu.RLock()
+			if bitmap := sh.get(m.Name, m.Value); bitmap != nil {
+				result.AndNot(bitmap)
+			}
+			sh.mu.RUnlock()
+		case m.Type == MatchNotRegexp:
+			// Remove series whose label value matches the regex
+			result.AndNot(idx.lookupRegexp(m))
+		case m.Type == MatchEqual:
 			// Intersect with previous results (AND operation)
-			result = roaring.And(result, matchedIDs)
+			sh := idx.shardFor(m.Name, m.Value)
+			sh.mu.RLock()
+			if bitmap := sh.get(m.Name, m.Value); bitmap != nil {
+				result.And(bitmap)
+			} else {
+				result.Clear()
+			}
+			sh.mu.RUnlock()
+		default:
+			result.And(idx.lookupMatcher(m))
 		}
 
 		// Early exit if no matches
@@ -126,8 +263,46 @@ func (idx *InvertedIndex) Lookup(matchers Matchers) (*roaring.Bitmap, error) {
 	return result, nil
 }
 
+// planMatchers orders matchers for evaluation, cheapest and most selective
+// first: the metric name equality, other equalities, regexps of exact
+// values, other regexps, then negative matchers. The input slice is not
+// modified.
+func planMatchers(matchers Matchers) Matchers {
+	planned := make(Matchers, len(matchers))
+	copy(planned, matchers)
+
+	sort.SliceStable(planned, func(i, j int) bool {
+		return matcherCost(planned[i]) < matcherCost(planned[j])
+	})
+
+	return planned
+}
+
+// matcherCost ranks a matcher for planMatchers.
+func matcherCost(m *Matcher) int {
+	switch m.Type {
+	case MatchEqual:
+		if m.Name == MetricNameLabel {
+			return 0
+		}
+		return 1
+	case MatchRegexp:
+		if _, ok := m.exactValues(); ok {
+			return 2
+		}
+		return 3
+	default:
+		return 4
+	}
+}
+
+// MetricNamePostings returns the IDs of all series with the given metric name.
+// This is the fast path used by most queries, which select by __name__.
+func (idx *InvertedIndex) MetricNamePostings(name string) *roaring.Bitmap {
+	return idx.lookupEqual(MetricNameLabel, name)
+}
+
 // lookupMatcher finds all series IDs that match a single matcher.
-// Must be called with read lock held.
 func (idx *InvertedIndex) lookupMatcher(m *Matcher) *roaring.Bitmap {
 	switch m.Type {
 	case MatchEqual:
@@ -149,10 +324,12 @@ func (idx *InvertedIndex) lookupMatcher(m *Matcher) *roaring.Bitmap {
 
 // lookupEqual finds series with exact label match.
 func (idx *InvertedIndex) lookupEqual(name, value string) *roaring.Bitmap {
-	if values, exists := idx.index[name]; exists {
-		if bitmap, exists := values[value]; exists {
-			return bitmap.Clone()
-		}
+	sh := idx.shardFor(name, value)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	if bitmap := sh.get(name, value); bitmap != nil {
+		return bitmap.Clone()
 	}
 	return roaring.New() // empty result
 }
@@ -162,26 +339,45 @@ func (idx *InvertedIndex) lookupEqual(name, value string) *roaring.Bitmap {
 func (idx *InvertedIndex) lookupNotEqual(name, value string) *roaring.Bitmap {
 	result := idx.allSeries()
 
-	if values, exists := idx.index[name]; exists {
-		if bitmap, exists := values[value]; exists {
-			// Remove series with the exact label value
-			result = roaring.AndNot(result, bitmap)
-		}
+	sh := idx.shardFor(name, value)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	if bitmap := sh.get(name, value); bitmap != nil {
+		// Remove series with the exact label value
+		result.AndNot(bitmap)
 	}
 
 	return result
 }
 
 // lookupRegexp finds series where label value matches the regex.
+// The values of a label name are spread over all shards. A set of exact
+// values, such as ^(a|b|c)$, is the union of their postings instead.
 func (idx *InvertedIndex) lookupRegexp(m *Matcher) *roaring.Bitmap {
 	result := roaring.New()
 
-	if values, exists := idx.index[m.Name]; exists {
-		for value, bitmap := range values {
-			if m.Matches(value) {
-				result = roaring.Or(result, bitmap)
+	if values, ok := m.exactValues(); ok {
+		for _, value := range values {
+			sh := idx.shardFor(m.Name, value)
+			sh.mu.RLock()
+			if bitmap := sh.get(m.Name, value); bitmap != nil {
+				result.Or(bitmap)
 			}
+			sh.mu.RUnlock()
 		}
+		return result
+	}
+
+	for _, sh := range idx.shards {
+		sh.mu.RLock()
+		for value, bitmap := range sh.postings[m.Name] {
+			// Match the regex directly so NotRegexp matchers can reuse this lookup
+			if m.regex != nil && m.matchRegexp(value) {
+				result.Or(bitmap)
+			}
+		}
+		sh.mu.RUnlock()
 	}
 
 	return result
@@ -194,33 +390,34 @@ func (idx *InvertedIndex) lookupNotRegexp(m *Matcher) *roaring.Bitmap {
 	// Result = all series - series matching the regex
 	// This gives us series without the label OR with a non-matching value
 	result := idx.allSeries()
-	result = roaring.AndNot(result, matched)
+	result.AndNot(matched)
 
 	return result
 }
 
-// allSeries returns a bitmap of all series IDs in the index.
-func (idx *InvertedIndex) allSeries() *roaring.Bitmap {
-	result := roaring.New()
+// All returns a bitmap of all series IDs in the index.
+func (idx *InvertedIndex) All() *roaring.Bitmap {
+	return idx.allSeries()
+}
 
-	// Collect all series IDs from all posting lists
-	for _, values := range idx.index {
-		for _, bitmap := range values {
-			result = roaring.Or(result, bitmap)
-		}
-	}
+// allSeries returns a copy of the all-series posting list.
+func (idx *InvertedIndex) allSeries() *roaring.Bitmap {
+	idx.allMu.RLock()
+	defer idx.allMu.RUnlock()
 
-	return result
+	return idx.all.Clone()
 }
 
 // allSeriesWithLabel returns a bitmap of all series that have the given label.
 func (idx *InvertedIndex) allSeriesWithLabel(name string) *roaring.Bitmap {
 	result := roaring.New()
 
-	if values, exists := idx.index[name]; exists {
-		for _, bitmap := range values {
-			result = roaring.Or(result, bitmap)
+	for _, sh := range idx.shards {
+		sh.mu.RLock()
+		for _, bitmap := range sh.postings[name] {
+			result.Or(bitmap)
 		}
+		sh.mu.RUnlock()
 	}
 
 	return result
@@ -228,41 +425,55 @@ func (idx *InvertedIndex) allSeriesWithLabel(name string) *roaring.Bitmap {
 
 // Delete removes a series from the index.
 func (idx *InvertedIndex) Delete(id series.SeriesID) {
-	idx.mu.Lock()
-	defer idx.mu.Unlock()
+	idx.allMu.Lock()
+	removed := idx.all.CheckedRemove(uint32(id))
+	idx.allMu.Unlock()
+	if !removed {
+		return
+	}
 
 	// Remove from all posting lists
-	for name := range idx.index {
-		for value := range idx.index[name] {
-			idx.index[name][value].Remove(uint32(id))
-
-			// Clean up empty bitmaps
-			if idx.index[name][value].IsEmpty() {
-				delete(idx.index[name], value)
-				if values, exists := idx.labelValues[name]; exists {
+	for _, sh := range idx.shards {
+		sh.mu.Lock()
+		for name, values := range sh.postings {
+			for value, bitmap := range values {
+				if !bitmap.Contains(uint32(id)) {
+					continue
+				}
+				before := postingsSize(value, bitmap)
+				bitmap.Remove(uint32(id))
+
+				// Clean up empty bitmaps
+				if bitmap.IsEmpty() {
 					delete(values, value)
+					idx.account(name, -before)
+				} else {
+					idx.account(name, postingsSize(value, bitmap)-before)
 				}
 			}
-		}
 
-		// Clean up empty label names
-		if len(idx.index[name]) == 0 {
-			delete(idx.index, name)
-			delete(idx.labelNames, name)
-			delete(idx.labelValues, name)
+			// Clean up empty label names
+			if len(values) == 0 {
+				delete(sh.postings, name)
+			}
 		}
+		sh.mu.Unlock()
 	}
-
-	idx.seriesCount--
 }
 
 // LabelNames returns all unique label names in the index.
 func (idx *InvertedIndex) LabelNames() []string {
-	idx.mu.RLock()
-	defer idx.mu.RUnlock()
+	seen := make(map[string]struct{})
+	for _, sh := range idx.shards {
+		sh.mu.RLock()
+		for name := range sh.postings {
+			seen[name] = struct{}{}
+		}
+		sh.mu.RUnlock()
+	}
 
-	names := make([]string, 0, len(idx.labelNames))
-	for name := range idx.labelNames {
+	names := make([]string, 0, len(seen))
+	for name := range seen {
 		names = append(names, name)
 	}
 	sort.Strings(names)
@@ -271,19 +482,17 @@ func (idx *InvertedIndex) LabelNames() []string {
 
 // LabelValues returns all unique values for a given label name.
 func (idx *InvertedIndex) LabelValues(name string) []string {
-	idx.mu.RLock()
-	defer idx.mu.RUnlock()
-
-	if values, exists := idx.labelValues[name]; exists {
-		result := make([]string, 0, len(values))
-		for value := range values {
+	var result []string
+	for _, sh := range idx.shards {
+		sh.mu.RLock()
+		for value := range sh.postings[name] {
 			result = append(result, value)
 		}
-		sort.Strings(result)
-		return result
+		sh.mu.RUnlock()
 	}
 
-	return nil
+	sort.Strings(result)
+	return result
 }
 
 // Stats returns statistics about the index.
@@ -294,23 +503,23 @@ type IndexStats struct {
 	PostingListSizes  map[string]map[string]int // Size of each posting list
 	TotalPostingLists int            // Total number of posting lists
 	MemoryBytes       uint64         // Approximate memory usage in bytes
+	LabelMemoryBytes  map[string]int64 // Approximate memory per label name (see LabelMemoryBytes)
 }
 
 // Stats returns current index statistics.
 func (idx *InvertedIndex) Stats() IndexStats {
-	idx.mu.RLock()
-	defer idx.mu.RUnlock()
+	idx.rlockAll()
+	defer idx.runlockAll()
 
 	stats := IndexStats{
-		SeriesCount:      idx.seriesCount,
-		LabelCount:       len(idx.labelNames),
+		SeriesCount:      int(idx.all.GetCardinality()),
 		LabelValueCount:  make(map[string]int),
 		PostingListSizes: make(map[string]map[string]int),
 	}
 
 	var memoryBytes uint64
 
-	for name, values := range idx.index {
+	for name, values := range idx.mergedPostings() {
 		stats.LabelValueCount[name] = len(values)
 		stats.PostingListSizes[name] = make(map[string]int)
 
@@ -324,222 +533,46 @@ func (idx *InvertedIndex) Stats() IndexStats {
 		}
 	}
 
+	stats.LabelCount = len(stats.LabelValueCount)
 	stats.MemoryBytes = memoryBytes
+	stats.LabelMemoryBytes = idx.LabelMemory()
 	return stats
 }
 
-// WriteTo writes the index to the given writer in a compact binary format.
-// Format:
-//   - Header: magic number (4 bytes) + version (4 bytes)
-//   - Series count (8 bytes)
-//   - Number of label names (4 bytes)
-//   - For each label name:
-//     - Name length (4 bytes) + name bytes
-//     - Number of values (4 bytes)
-//     - For each value:
-//       - Value length (4 bytes) + value bytes
-//       - Roaring bitmap serialized bytes
-func (idx *InvertedIndex) WriteTo(w io.Writer) (int64, error) {
-	idx.mu.RLock()
-	defer idx.mu.RUnlock()
-
-	buf := new(bytes.Buffer)
-
-	// Write header
-	magic := uint32(0x54534458) // "TSDX" in hex
-	version := uint32(1)
-	if err := binary.Write(buf, binary.LittleEndian, magic); err != nil {
-		return 0, err
-	}
-	if err := binary.Write(buf, binary.LittleEndian, version); err != nil {
-		return 0, err
-	}
-
-	// Write series count
-	if err := binary.Write(buf, binary.LittleEndian, uint64(idx.seriesCount)); err != nil {
-		return 0, err
-	}
-
-	// Get sorted label names for deterministic output
-	labelNames := make([]string, 0, len(idx.index))
-	for name := range idx.index {
-		labelNames = append(labelNames, name)
-	}
-	sort.Strings(labelNames)
-
-	// Write number of label names
-	if err := binary.Write(buf, binary.LittleEndian, uint32(len(labelNames))); err != nil {
-		return 0, err
-	}
-
-	// Write each label name and its values
-	for _, name := range labelNames {
-		// Write label name
-		if err := writeString(buf, name); err != nil {
-			return 0, err
-		}
-
-		values := idx.index[name]
-
-		// Get sorted values for deterministic output
-		sortedValues := make([]string, 0, len(values))
-		for value := range values {
-			sortedValues = append(sortedValues, value)
-		}
-		sort.Strings(sortedValues)
-
-		// Write number of values
-		if err := binary.Write(buf, binary.LittleEndian, uint32(len(sortedValues))); err != nil {
-			return 0, err
-		}
-
-		// Write each value and its bitmap
-		for _, value := range sortedValues {
-			// Write value
-			if err := writeString(buf, value); err != nil {
-				return 0, err
-			}
-
-			// Serialize bitmap
-			bitmap := values[value]
-			bitmapBytes, err := bitmap.ToBytes()
-			if err != nil {
-				return 0, fmt.Errorf("failed to serialize bitmap: %w", err)
-			}
-
-			// Write bitmap length and data
-			if err := binary.Write(buf, binary.LittleEndian, uint32(len(bitmapBytes))); err != nil {
-				return 0, err
-			}
-			if _, err := buf.Write(bitmapBytes); err != nil {
-				return 0, err
-			}
-		}
+// rlockAll read-locks every shard and the all-series list, giving a
+// consistent view of the whole index. Locks are always taken in the same
+// order and Add/Delete never hold more than one at a time.
+func (idx *InvertedIndex) rlockAll() {
+	for _, sh := range idx.shards {
+		sh.mu.RLock()
 	}
-
-	// Write to the actual writer
-	n, err := w.Write(buf.Bytes())
-	return int64(n), err
+	idx.allMu.RLock()
 }
 
-// ReadFrom reads the index from the given reader.
-func (idx *InvertedIndex) ReadFrom(r io.Reader) (int64, error) {
-	idx.mu.Lock()
-	defer idx.mu.Unlock()
-
-	// Read all data into buffer
-	buf := new(bytes.Buffer)
-	n, err := buf.ReadFrom(r)
-	if err != nil {
-		return n, err
-	}
-
-	// Read and verify header
-	var magic, version uint32
-	if err := binary.Read(buf, binary.LittleEndian, &magic); err != nil {
-		return n, err
-	}
-	if magic != 0x54534458 {
-		return n, fmt.Errorf("invalid magic number: 0x%x", magic)
-	}
-	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
-		return n, err
-	}
-	if version != 1 {
-		return n, fmt.Errorf("unsupported version: %d", version)
-	}
-
-	// Read series count
-	var seriesCount uint64
-	if err := binary.Read(buf, binary.LittleEndian, &seriesCount); err != nil {
-		return n, err
+// runlockAll releases the locks taken by rlockAll.
+func (idx *InvertedIndex) runlockAll() {
+	idx.allMu.RUnlock()
+	for _, sh := range idx.shards {
+		sh.mu.RUnlock()
 	}
+}
 
-	// Read number of label names
-	var labelCount uint32
-	if err := binary.Read(buf, binary.LittleEndian, &labelCount); err != nil {
-		return n, err
-	}
-
-	// Clear existing index
-	idx.index = make(map[string]map[string]*roaring.Bitmap)
-	idx.labelNames = make(map[string]struct{})
-	idx.labelValues = make(map[string]map[string]struct{})
-
-	// Read each label name and its values
-	for i := 0; i < int(labelCount); i++ {
-		// Read label name
-		name, err := readString(buf)
-		if err != nil {
-			return n, err
-		}
-
-		idx.index[name] = make(map[string]*roaring.Bitmap)
-		idx.labelNames[name] = struct{}{}
-		idx.labelValues[name] = make(map[string]struct{})
-
-		// Read number of values
-		var valueCount uint32
-		if err := binary.Read(buf, binary.LittleEndian, &valueCount); err != nil {
-			return n, err
-		}
-
-		// Read each value and its bitmap
-		for j := 0; j < int(valueCount); j++ {
-			// Read value
-			value, err := readString(buf)
-			if err != nil {
-				return n, err
-			}
-
-			idx.labelValues[name][value] = struct{}{}
-
-			// Read bitmap length
-			var bitmapLen uint32
-			if err := binary.Read(buf, binary.LittleEndian, &bitmapLen); err != nil {
-				return n, err
-			}
-
-			// Read bitmap data
-			bitmapBytes := make([]byte, bitmapLen)
-			if _, err := io.ReadFull(buf, bitmapBytes); err != nil {
-				return n, err
+// mergedPostings returns a single label name -> value -> posting list view
+// over all shards. The bitmaps are shared, so the result is only valid
+// while the shards are locked.
+func (idx *InvertedIndex) mergedPostings() map[string]map[string]*roaring.Bitmap {
+	merged := make(map[string]map[string]*roaring.Bitmap)
+	for _, sh := range idx.shards {
+		for name, values := range sh.postings {
+			m, exists := merged[name]
+			if !exists {
+				m = make(map[string]*roaring.Bitmap, len(values))
+				merged[name] = m
 			}
-
-			// Deserialize bitmap
-			bitmap := roaring.New()
-			if err := bitmap.UnmarshalBinary(bitmapBytes); err != nil {
-				return n, fmt.Errorf("failed to deserialize bitmap: %w", err)
+			for value, bitmap := range values {
+				m[value] = bitmap
 			}
-
-			idx.index[name][value] = bitmap
 		}
 	}
-
-	idx.seriesCount = int(seriesCount)
-	return n, nil
-}
-
-// writeString writes a length-prefixed string to the buffer.
-func writeString(buf *bytes.Buffer, s string) error {
-	if err := binary.Write(buf, binary.LittleEndian, uint32(len(s))); err != nil {
-		return err
-	}
-	_, err := buf.WriteString(s)
-	return err
-}
-
-// readString reads a length-prefixed string from the buffer.
-func readString(buf *bytes.Buffer) (string, error) {
-	var length uint32
-	if err := binary.Read(buf, binary.LittleEndian, &length); err != nil {
-		return "", err
-	}
-
-	bytes := make([]byte, length)
-	if _, err := io.ReadFull(buf, bytes); err != nil {
-		return "", err
-	}
-
-	return string(bytes), nil
+	return merged
 }
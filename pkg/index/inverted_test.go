@@ -3,6 +3,8 @@ package index
 import (
 	"bytes"
 	"fmt"
+	"maps"
+	"sync"
 	"testing"
 
 	"github.com/therealutkarshpriyadarshi/time/pkg/series"
@@ -223,6 +225,27 @@ func TestInvertedIndex_Lookup_Regexp(t *testing.T) {
 			},
 			wantIDs: []uint32{},
 		},
+		{
+			name: "host=~^(server1|database1|missing)$",
+			matchers: Matchers{
+				MustNewMatcher(MatchRegexp, "host", "^(server1|database1|missing)$"),
+			},
+			wantIDs: []uint32{1, 3},
+		},
+		{
+			name: "host=~server2|database",
+			matchers: Matchers{
+				MustNewMatcher(MatchRegexp, "host", "server2|database"),
+			},
+			wantIDs: []uint32{2, 3},
+		},
+		{
+			name: "host=~(?i)^SERVER1$",
+			matchers: Matchers{
+				MustNewMatcher(MatchRegexp, "host", "(?i)^SERVER1$"),
+			},
+			wantIDs: []uint32{1},
+		},
 	}
 
 	for _, tt := range tests {
@@ -341,6 +364,73 @@ func TestInvertedIndex_Lookup_ComplexQuery(t *testing.T) {
 	}
 }
 
+func TestInvertedIndex_Lookup_MetricNameFirst(t *testing.T) {
+	idx := NewInvertedIndex()
+
+	idx.Add(1, map[string]string{"__name__": "cpu", "host": "a"})
+	idx.Add(2, map[string]string{"__name__": "cpu", "host": "b"})
+	idx.Add(3, map[string]string{"__name__": "mem", "host": "a"})
+	idx.Add(4, map[string]string{"__name__": "cpu"})
+
+	// Negative matchers listed first must give the same result as when
+	// the metric name is listed first.
+	matchers := Matchers{
+		MustNewMatcher(MatchNotEqual, "host", "b"),
+		MustNewMatcher(MatchNotRegexp, "host", "z.*"),
+		MustNewMatcher(MatchEqual, "__name__", "cpu"),
+	}
+
+	result, err := idx.Lookup(matchers)
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if got := result.ToArray(); !equalUint32Slices(got, []uint32{1, 4}) {
+		t.Errorf("Lookup() = %v, want [1 4]", got)
+	}
+
+	// The caller's matcher order must not be changed by planning
+	if matchers[0].Type != MatchNotEqual || matchers[2].Name != "__name__" {
+		t.Error("Lookup() reordered the caller's matchers")
+	}
+
+	if got := idx.MetricNamePostings("cpu").ToArray(); !equalUint32Slices(got, []uint32{1, 2, 4}) {
+		t.Errorf("MetricNamePostings() = %v, want [1 2 4]", got)
+	}
+	if !idx.MetricNamePostings("missing").IsEmpty() {
+		t.Error("MetricNamePostings() for unknown metric should be empty")
+	}
+}
+
+func TestPlanMatchers(t *testing.T) {
+	matchers := Matchers{
+		MustNewMatcher(MatchNotEqual, "env", "dev"),
+		MustNewMatcher(MatchRegexp, "host", "web.*"),
+		MustNewMatcher(MatchEqual, "region", "us"),
+		MustNewMatcher(MatchEqual, "__name__", "cpu"),
+	}
+
+	planned := planMatchers(matchers)
+	want := []string{"__name__", "region", "host", "env"}
+	for i, m := range planned {
+		if m.Name != want[i] {
+			t.Errorf("planned[%d] = %s, want %s", i, m.Name, want[i])
+		}
+	}
+
+	// Regexps of exact values go before other regexps
+	matchers = Matchers{
+		MustNewMatcher(MatchRegexp, "host", "web.*"),
+		MustNewMatcher(MatchRegexp, "env", "^(prod|staging)$"),
+	}
+	planned = planMatchers(matchers)
+	want = []string{"env", "host"}
+	for i, m := range planned {
+		if m.Name != want[i] {
+			t.Errorf("planned[%d] = %s, want %s", i, m.Name, want[i])
+		}
+	}
+}
+
 func TestInvertedIndex_Lookup_EmptyMatchers(t *testing.T) {
 	idx := NewInvertedIndex()
 
@@ -384,6 +474,106 @@ func TestInvertedIndex_Delete(t *testing.T) {
 	}
 }
 
+func TestInvertedIndex_AllSeriesTracking(t *testing.T) {
+	idx := NewInvertedIndex()
+
+	idx.Add(1, map[string]string{"host": "a"})
+	idx.Add(2, map[string]string{"host": "b"})
+	idx.Add(2, map[string]string{"host": "b"}) // re-adding is idempotent
+	idx.Add(3, map[string]string{"env": "prod"})
+
+	if got := idx.All().ToArray(); !equalUint32Slices(got, []uint32{1, 2, 3}) {
+		t.Errorf("All() = %v, want [1 2 3]", got)
+	}
+	if stats := idx.Stats(); stats.SeriesCount != 3 {
+		t.Errorf("SeriesCount = %d, want 3", stats.SeriesCount)
+	}
+
+	// Deleting unknown series must not affect the count
+	idx.Delete(2)
+	idx.Delete(42)
+
+	if got := idx.All().ToArray(); !equalUint32Slices(got, []uint32{1, 3}) {
+		t.Errorf("All() after delete = %v, want [1 3]", got)
+	}
+	if stats := idx.Stats(); stats.SeriesCount != 2 {
+		t.Errorf("SeriesCount after delete = %d, want 2", stats.SeriesCount)
+	}
+
+	// A lone negative matcher starts from the tracked set
+	result, err := idx.Lookup(Matchers{MustNewMatcher(MatchNotEqual, "host", "a")})
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if got := result.ToArray(); !equalUint32Slices(got, []uint32{3}) {
+		t.Errorf("Lookup(host!=a) = %v, want [3]", got)
+	}
+
+	// The tracked set is rebuilt when reading a persisted index
+	buf := new(bytes.Buffer)
+	if _, err := idx.WriteTo(buf); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	idx2 := NewInvertedIndex()
+	if _, err := idx2.ReadFrom(buf); err != nil {
+		t.Fatalf("ReadFrom() error = %v", err)
+	}
+	if got := idx2.All().ToArray(); !equalUint32Slices(got, []uint32{1, 3}) {
+		t.Errorf("All() after ReadFrom = %v, want [1 3]", got)
+	}
+}
+
+func TestInvertedIndex_ConcurrentAdd(t *testing.T) {
+	idx := NewInvertedIndex()
+
+	const writers = 8
+	const perWriter = 500
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				id := series.SeriesID(w*perWriter + i + 1)
+				idx.Add(id, map[string]string{
+					"__name__": fmt.Sprintf("metric%d", i%10),
+					"writer":   fmt.Sprintf("w%d", w),
+				})
+			}
+		}(w)
+	}
+
+	// Read concurrently with the writers
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			idx.Lookup(Matchers{MustNewMatcher(MatchNotEqual, "writer", "w0")})
+			idx.LabelValues("writer")
+		}
+	}()
+	wg.Wait()
+
+	if stats := idx.Stats(); stats.SeriesCount != writers*perWriter {
+		t.Errorf("SeriesCount = %d, want %d", stats.SeriesCount, writers*perWriter)
+	}
+	if values := idx.LabelValues("writer"); len(values) != writers {
+		t.Errorf("LabelValues(writer) = %v, want %d values", values, writers)
+	}
+
+	result, err := idx.Lookup(Matchers{
+		MustNewMatcher(MatchEqual, "__name__", "metric3"),
+		MustNewMatcher(MatchRegexp, "writer", "w[01]"),
+	})
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if got := result.GetCardinality(); got != 2*perWriter/10 {
+		t.Errorf("Lookup() returned %d series, want %d", got, 2*perWriter/10)
+	}
+}
+
 func TestInvertedIndex_LabelNames(t *testing.T) {
 	idx := NewInvertedIndex()
 
@@ -453,6 +643,55 @@ func TestInvertedIndex_Stats(t *testing.T) {
 	}
 }
 
+func TestInvertedIndex_LabelMemory(t *testing.T) {
+	idx := NewInvertedIndex()
+	for i := 1; i <= 100; i++ {
+		idx.Add(series.SeriesID(i), map[string]string{
+			"__name__":   "http_requests_total",
+			"request_id": fmt.Sprintf("req-%04d", i),
+		})
+	}
+
+	// Every request_id value has a posting list of its own
+	name, requestID := idx.LabelMemoryBytes("__name__"), idx.LabelMemoryBytes("request_id")
+	if name <= 0 || requestID < 100*postingsOverhead {
+		t.Fatalf("LabelMemoryBytes = %d for __name__, %d for request_id", name, requestID)
+	}
+	if requestID < 10*name {
+		t.Errorf("request_id holds %d bytes, __name__ %d; want request_id far larger", requestID, name)
+	}
+	if got := idx.Stats().LabelMemoryBytes; got["request_id"] != requestID || got["__name__"] != name {
+		t.Errorf("Stats().LabelMemoryBytes = %v", got)
+	}
+
+	// Adding a known series changes nothing
+	idx.Add(1, map[string]string{"__name__": "http_requests_total", "request_id": "req-0001"})
+	if got := idx.LabelMemoryBytes("request_id"); got != requestID {
+		t.Errorf("LabelMemoryBytes(request_id) = %d after re-adding a series, want %d", got, requestID)
+	}
+
+	// The accounting matches the index, however it was built
+	var buf bytes.Buffer
+	if _, err := idx.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	loaded := NewInvertedIndex()
+	if _, err := loaded.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom() error = %v", err)
+	}
+	if got := loaded.LabelMemory(); !maps.Equal(got, idx.LabelMemory()) {
+		t.Errorf("LabelMemory() after a round trip = %v, want %v", got, idx.LabelMemory())
+	}
+
+	// Deleting every series frees it all
+	for i := 1; i <= 100; i++ {
+		idx.Delete(series.SeriesID(i))
+	}
+	if got := idx.LabelMemory(); len(got) != 0 {
+		t.Errorf("LabelMemory() = %v after deleting every series, want none", got)
+	}
+}
+
 func TestInvertedIndex_Persistence(t *testing.T) {
 	// Create and populate index
 	idx1 := NewInvertedIndex()
@@ -558,7 +797,7 @@ func TestInvertedIndex_LargeDataset(t *testing.T) {
 	// Query should work efficiently
 	matchers := Matchers{
 		MustNewMatcher(MatchEqual, "host", "server5"),
-		MustNewMatcher(MatchEqual, "metric", "metric2"),
+		MustNewMatcher(MatchEqual, "metric", "metric0"),
 	}
 
 	result, err := idx.Lookup(matchers)
@@ -0,0 +1,80 @@
+package index
+
+import (
+	"fmt"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+// MatchingPostings returns the IDs of the series of r matching any of the
+// matcher sets. An empty matcher set, or no sets at all, matches every
+// series.
+func MatchingPostings(r Reader, matcherSets ...Matchers) (*roaring.Bitmap, error) {
+	if len(matcherSets) == 0 {
+		return r.All(), nil
+	}
+	ids := roaring.New()
+	for _, matchers := range matcherSets {
+		if len(matchers) == 0 {
+			ids.Or(r.All())
+			continue
+		}
+		matched, err := r.Lookup(matchers)
+		if err != nil {
+			return nil, err
+		}
+		ids.Or(matched)
+	}
+	return ids, nil
+}
+
+// LabelNamesOf returns the sorted names of the labels held by any of the
+// series in ids. A name is found from the first of its posting lists
+// intersecting ids, so names held by most series are cheap.
+func LabelNamesOf(r Reader, ids *roaring.Bitmap) ([]string, error) {
+	var names []string
+	if ids.IsEmpty() {
+		return names, nil
+	}
+	for _, name := range r.LabelNames() {
+		for _, value := range r.LabelValues(name) {
+			postings, err := lookupPair(r, name, value)
+			if err != nil {
+				return nil, err
+			}
+			if postings.Intersects(ids) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	return names, nil
+}
+
+// LabelValuesOf returns the sorted values of a label held by any of the
+// series in ids, intersecting ids with the posting list of each value.
+func LabelValuesOf(r Reader, name string, ids *roaring.Bitmap) ([]string, error) {
+	var values []string
+	if ids.IsEmpty() {
+		return values, nil
+	}
+	for _, value := range r.LabelValues(name) {
+		postings, err := lookupPair(r, name, value)
+		if err != nil {
+			return nil, err
+		}
+		if postings.Intersects(ids) {
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
+
+// lookupPair returns the posting list of a label pair.
+func lookupPair(r Reader, name, value string) (*roaring.Bitmap, error) {
+	postings, err := r.Lookup(Matchers{MustNewMatcher(MatchEqual, name, value)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read postings of %s=%q: %w", name, value, err)
+	}
+	return postings, nil
+}
@@ -0,0 +1,59 @@
+package index
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+func TestMatchingLabels(t *testing.T) {
+	idx := buildTestIndex()
+	buf := new(bytes.Buffer)
+	if _, err := idx.WriteTo(buf); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	mi, err := NewMappedIndex(buf.Bytes())
+	if err != nil {
+		t.Fatalf("NewMappedIndex() error = %v", err)
+	}
+
+	mem := Matchers{MustNewMatcher(MatchEqual, "__name__", "mem")}
+	dev := Matchers{MustNewMatcher(MatchEqual, "env", "dev")}
+	none := Matchers{MustNewMatcher(MatchEqual, "host", "missing")}
+
+	tests := []struct {
+		name        string
+		matcherSets []Matchers
+		wantNames   []string
+		wantHosts   []string
+	}{
+		{"no matchers", nil, []string{"__name__", "env", "host"}, []string{"server1", "server2", "server3"}},
+		{"empty matcher set", []Matchers{{}}, []string{"__name__", "env", "host"}, []string{"server1", "server2", "server3"}},
+		{"one set", []Matchers{mem}, []string{"__name__", "env", "host"}, []string{"server1", "server3"}},
+		{"union", []Matchers{mem, dev}, []string{"__name__", "env", "host"}, []string{"server1", "server2", "server3"}},
+		{"label missing from matches", []Matchers{{MustNewMatcher(MatchEqual, "host", "server3")}}, []string{"__name__", "host"}, []string{"server3"}},
+		{"no match", []Matchers{none}, nil, nil},
+	}
+	for _, tt := range tests {
+		for _, r := range []Reader{idx, mi} {
+			ids, err := MatchingPostings(r, tt.matcherSets...)
+			if err != nil {
+				t.Fatalf("%s: MatchingPostings() error = %v", tt.name, err)
+			}
+			names, err := LabelNamesOf(r, ids)
+			if err != nil {
+				t.Fatalf("%s: LabelNamesOf() error = %v", tt.name, err)
+			}
+			if !slices.Equal(names, tt.wantNames) {
+				t.Errorf("%s: LabelNamesOf(%T) = %v, want %v", tt.name, r, names, tt.wantNames)
+			}
+			hosts, err := LabelValuesOf(r, "host", ids)
+			if err != nil {
+				t.Fatalf("%s: LabelValuesOf() error = %v", tt.name, err)
+			}
+			if !slices.Equal(hosts, tt.wantHosts) {
+				t.Errorf("%s: LabelValuesOf(%T, host) = %v, want %v", tt.name, r, hosts, tt.wantHosts)
+			}
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package index
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+// Reader is a read-only view of an index. It is implemented by both the
+// in-memory InvertedIndex and the file-backed MappedIndex.
+type Reader interface {
+	// Lookup returns the IDs of all series matching every matcher
+	Lookup(matchers Matchers) (*roaring.Bitmap, error)
+
+	// All returns the IDs of all series
+	All() *roaring.Bitmap
+
+	// LabelNames returns all label names, sorted
+	LabelNames() []string
+
+	// LabelValues returns all values of a label name, sorted
+	LabelValues(name string) []string
+}
+
+var (
+	_ Reader = (*InvertedIndex)(nil)
+	_ Reader = (*MappedIndex)(nil)
+)
+
+// MappedIndex is a read-only index over a v2 index file.
+//
+// Opening it decodes only the symbol table and postings offset table.
+// Posting lists stay in the (memory-mapped) file and are decoded on demand
+// when a lookup needs them, so opening a block with a large index is cheap
+// and memory use is proportional to the label pairs actually queried.
+//
+// A MappedIndex is safe for concurrent use. It must not be used after Close.
+type MappedIndex struct {
+	data  []byte
+	unmap func() error
+
+	// names holds all label names, sorted
+	names []string
+
+	// postings maps label name -> postings refs sorted by value
+	postings map[string][]postingsRef
+
+	// allRef locates the posting list of all series
+	allRef postingsRef
+
+	seriesCount uint64
+}
+
+// postingsRef locates one posting list in the index file
+type postingsRef struct {
+	value  string
+	offset uint64
+	length uint32
+}
+
+// OpenMappedIndex memory-maps the index file at path.
+// A v1 file yields ErrLegacyIndexFormat.
+func OpenMappedIndex(path string) (*MappedIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index: %w", err)
+	}
+	defer f.Close()
+
+	data, unmap, err := mmapFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map index: %w", err)
+	}
+
+	mi, err := NewMappedIndex(data)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	mi.unmap = unmap
+	return mi, nil
+}
+
+// NewMappedIndex parses a v2 index held in data. The slice must not be
+// modified while the index is in use.
+func NewMappedIndex(data []byte) (*MappedIndex, error) {
+	version, err := readIndexHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if version == IndexFormatV1 {
+		return nil, ErrLegacyIndexFormat
+	}
+	if len(data) < indexHeaderSize+indexTOCSize {
+		return nil, fmt.Errorf("%w: file too short for TOC", ErrInvalidIndex)
+	}
+
+	// Read TOC
+	tocStart := uint64(len(data) - indexTOCSize)
+	toc := data[tocStart:]
+	symbolsOffset := binary.LittleEndian.Uint64(toc[0:8])
+	postingsOffset := binary.LittleEndian.Uint64(toc[8:16])
+	offsetTableOffset := binary.LittleEndian.Uint64(toc[16:24])
+	seriesCount := binary.LittleEndian.Uint64(toc[24:32])
+
+	if symbolsOffset < indexHeaderSize || symbolsOffset > postingsOffset ||
+		postingsOffset > offsetTableOffset || offsetTableOffset > tocStart {
+		return nil, fmt.Errorf("%w: corrupt TOC", ErrInvalidIndex)
+	}
+
+	symbols, err := readSymbols(data[symbolsOffset:postingsOffset])
+	if err != nil {
+		return nil, err
+	}
+
+	mi := &MappedIndex{
+		data:        data,
+		postings:    make(map[string][]postingsRef),
+		seriesCount: seriesCount,
+	}
+
+	// Read postings offset table
+	table := data[offsetTableOffset:tocStart]
+	if len(table) < 4 {
+		return nil, fmt.Errorf("%w: truncated offset table", ErrInvalidIndex)
+	}
+	count := binary.LittleEndian.Uint32(table[0:4])
+	table = table[4:]
+	if uint64(len(table)) != uint64(count)*offsetEntrySize {
+		return nil, fmt.Errorf("%w: offset table size mismatch", ErrInvalidIndex)
+	}
+
+	foundAll := false
+	for i := uint32(0); i < count; i++ {
+		e := table[i*offsetEntrySize : (i+1)*offsetEntrySize]
+		nameRef := binary.LittleEndian.Uint32(e[0:4])
+		valueRef := binary.LittleEndian.Uint32(e[4:8])
+		ref := postingsRef{
+			offset: binary.LittleEndian.Uint64(e[8:16]),
+			length: binary.LittleEndian.Uint32(e[16:20]),
+		}
+
+		if int(nameRef) >= len(symbols) || int(valueRef) >= len(symbols) {
+			return nil, fmt.Errorf("%w: symbol ref out of range", ErrInvalidIndex)
+		}
+		if ref.offset < postingsOffset || ref.offset+uint64(ref.length) > offsetTableOffset {
+			return nil, fmt.Errorf("%w: postings out of range", ErrInvalidIndex)
+		}
+
+		name := symbols[nameRef]
+		ref.value = symbols[valueRef]
+		if name == "" {
+			mi.allRef = ref
+			foundAll = true
+			continue
+		}
+
+		if _, exists := mi.postings[name]; !exists {
+			mi.names = append(mi.names, name)
+		}
+		mi.postings[name] = append(mi.postings[name], ref)
+	}
+	if !foundAll {
+		return nil, fmt.Errorf("%w: missing all-series postings", ErrInvalidIndex)
+	}
+
+	// The writer emits entries sorted; don't rely on it for correctness
+	sort.Strings(mi.names)
+	for _, refs := range mi.postings {
+		sort.Slice(refs, func(i, j int) bool { return refs[i].value < refs[j].value })
+	}
+
+	return mi, nil
+}
+
+// readSymbols decodes the symbol table
+func readSymbols(b []byte) ([]string, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("%w: truncated symbol table", ErrInvalidIndex)
+	}
+	count := binary.LittleEndian.Uint32(b[0:4])
+	b = b[4:]
+
+	symbols := make([]string, 0, count)
+	for i := uint32(0); i < count; i++ {
+		if len(b) < 4 {
+			return nil, fmt.Errorf("%w: truncated symbol table", ErrInvalidIndex)
+		}
+		length := binary.LittleEndian.Uint32(b[0:4])
+		if uint64(len(b)-4) < uint64(length) {
+			return nil, fmt.Errorf("%w: truncated symbol", ErrInvalidIndex)
+		}
+		symbols = append(symbols, string(b[4:4+length]))
+		b = b[4+length:]
+	}
+	return symbols, nil
+}
+
+// Close releases the memory mapping, if any
+func (mi *MappedIndex) Close() error {
+	if mi.unmap == nil {
+		return nil
+	}
+	err := mi.unmap()
+	mi.unmap = nil
+	mi.data = nil
+	return err
+}
+
+// SeriesCount returns the number of series in the index
+func (mi *MappedIndex) SeriesCount() int {
+	return int(mi.seriesCount)
+}
+
+// LabelNames returns all label names, sorted
+func (mi *MappedIndex) LabelNames() []string {
+	names := make([]string, len(mi.names))
+	copy(names, mi.names)
+	return names
+}
+
+// LabelValues returns all values of a label name, sorted
+func (mi *MappedIndex) LabelValues(name string) []string {
+	refs := mi.postings[name]
+	if len(refs) == 0 {
+		return nil
+	}
+	values := make([]string, len(refs))
+	for i, ref := range refs {
+		values[i] = ref.value
+	}
+	return values
+}
+
+// All returns the IDs of all series
+func (mi *MappedIndex) All() *roaring.Bitmap {
+	bitmap, err := mi.decode(mi.allRef)
+	if err != nil {
+		return roaring.New()
+	}
+	return bitmap
+}
+
+// MetricNamePostings returns the IDs of all series with the given metric name
+func (mi *MappedIndex) MetricNamePostings(name string) *roaring.Bitmap {
+	bitmap, err := mi.lookupEqual(MetricNameLabel, name)
+	if err != nil {
+		return roaring.New()
+	}
+	return bitmap
+}
+
+// Lookup finds all series IDs that match the given matchers.
+// Matchers are planned the same way as for InvertedIndex.Lookup.
+func (mi *MappedIndex) Lookup(matchers Matchers) (*roaring.Bitmap, error) {
+	if len(matchers) == 0 {
+		return nil, fmt.Errorf("at least one matcher required")
+	}
+
+	var result *roaring.Bitmap
+
+	for i, m := range planMatchers(matchers) {
+		var bitmap *roaring.Bitmap
+		var err error
+
+		switch m.Type {
+		case MatchEqual, MatchNotEqual:
+			bitmap, err = mi.lookupEqual(m.Name, m.Value)
+		case MatchRegexp, MatchNotRegexp:
+			bitmap, err = mi.lookupRegexp(m)
+		default:
+			bitmap = roaring.New()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		negative := m.Type == MatchNotEqual || m.Type == MatchNotRegexp
+		switch {
+		case i == 0 && negative:
+			if result, err = mi.decode(mi.allRef); err != nil {
+				return nil, err
+			}
+			result.AndNot(bitmap)
+		case i == 0:
+			result = bitmap
+		case negative:
+			result.AndNot(bitmap)
+		default:
+			result.And(bitmap)
+		}
+
+		// Early exit if no matches
+		if result.IsEmpty() {
+			return roaring.New(), nil
+		}
+	}
+
+	return result, nil
+}
+
+// lookupEqual decodes the posting list of a label pair
+func (mi *MappedIndex) lookupEqual(name, value string) (*roaring.Bitmap, error) {
+	refs := mi.postings[name]
+	i := sort.Search(len(refs), func(i int) bool { return refs[i].value >= value })
+	if i == len(refs) || refs[i].value != value {
+		return roaring.New(), nil
+	}
+	return mi.decode(refs[i])
+}
+
+// lookupRegexp decodes and unions the posting lists of all values matching the regex
+func (mi *MappedIndex) lookupRegexp(m *Matcher) (*roaring.Bitmap, error) {
+	result := roaring.New()
+	if values, ok := m.exactValues(); ok {
+		for _, value := range values {
+			bitmap, err := mi.lookupEqual(m.Name, value)
+			if err != nil {
+				return nil, err
+			}
+			result.Or(bitmap)
+		}
+		return result, nil
+	}
+
+	for _, ref := range mi.postings[m.Name] {
+		if m.regex == nil || !m.matchRegexp(ref.value) {
+			continue
+		}
+		bitmap, err := mi.decode(ref)
+		if err != nil {
+			return nil, err
+		}
+		result.Or(bitmap)
+	}
+	return result, nil
+}
+
+// decode copies a posting list out of the file into a new bitmap
+func (mi *MappedIndex) decode(ref postingsRef) (*roaring.Bitmap, error) {
+	if mi.data == nil {
+		return nil, fmt.Errorf("index is closed")
+	}
+	bitmap := roaring.New()
+	if err := bitmap.UnmarshalBinary(mi.data[ref.offset : ref.offset+uint64(ref.length)]); err != nil {
+		return nil, fmt.Errorf("failed to deserialize bitmap: %w", err)
+	}
+	return bitmap, nil
+}
@@ -0,0 +1,171 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+func buildTestIndex() *InvertedIndex {
+	idx := NewInvertedIndex()
+	idx.Add(1, map[string]string{"__name__": "cpu", "host": "server1", "env": "prod"})
+	idx.Add(2, map[string]string{"__name__": "cpu", "host": "server2", "env": "dev"})
+	idx.Add(3, map[string]string{"__name__": "mem", "host": "server1", "env": "prod"})
+	idx.Add(4, map[string]string{"__name__": "mem", "host": "server3"})
+	return idx
+}
+
+func TestMappedIndex_MatchesInvertedIndex(t *testing.T) {
+	idx := buildTestIndex()
+
+	buf := new(bytes.Buffer)
+	if _, err := idx.WriteTo(buf); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+
+	mi, err := NewMappedIndex(buf.Bytes())
+	if err != nil {
+		t.Fatalf("NewMappedIndex() error = %v", err)
+	}
+
+	queries := []Matchers{
+		{MustNewMatcher(MatchEqual, "__name__", "cpu")},
+		{MustNewMatcher(MatchEqual, "host", "missing")},
+		{MustNewMatcher(MatchNotEqual, "env", "prod")},
+		{MustNewMatcher(MatchRegexp, "host", "server[12]")},
+		{MustNewMatcher(MatchRegexp, "host", "^(server1|server3|missing)$")},
+		{MustNewMatcher(MatchNotRegexp, "host", "^(server1|server3)$")},
+		{MustNewMatcher(MatchRegexp, "host", "(?i)^Server2$")},
+		{MustNewMatcher(MatchNotRegexp, "host", "server1")},
+		{
+			MustNewMatcher(MatchNotEqual, "host", "server2"),
+			MustNewMatcher(MatchEqual, "__name__", "cpu"),
+		},
+		{
+			MustNewMatcher(MatchEqual, "env", "prod"),
+			MustNewMatcher(MatchRegexp, "__name__", "m.*"),
+		},
+	}
+
+	for _, q := range queries {
+		want, err := idx.Lookup(q)
+		if err != nil {
+			t.Fatalf("InvertedIndex.Lookup(%v) error = %v", q, err)
+		}
+		got, err := mi.Lookup(q)
+		if err != nil {
+			t.Fatalf("MappedIndex.Lookup(%v) error = %v", q, err)
+		}
+		if !equalUint32Slices(got.ToArray(), want.ToArray()) {
+			t.Errorf("Lookup(%v) = %v, want %v", q, got.ToArray(), want.ToArray())
+		}
+	}
+
+	if got := mi.All().ToArray(); !equalUint32Slices(got, []uint32{1, 2, 3, 4}) {
+		t.Errorf("All() = %v, want [1 2 3 4]", got)
+	}
+	if mi.SeriesCount() != 4 {
+		t.Errorf("SeriesCount() = %d, want 4", mi.SeriesCount())
+	}
+	if got := mi.LabelNames(); len(got) != 3 || got[0] != "__name__" {
+		t.Errorf("LabelNames() = %v, want [__name__ env host]", got)
+	}
+	if got := mi.LabelValues("host"); len(got) != 3 || got[2] != "server3" {
+		t.Errorf("LabelValues(host) = %v, want [server1 server2 server3]", got)
+	}
+	if got := mi.MetricNamePostings("mem").ToArray(); !equalUint32Slices(got, []uint32{3, 4}) {
+		t.Errorf("MetricNamePostings(mem) = %v, want [3 4]", got)
+	}
+}
+
+func TestOpenMappedIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index")
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create index file: %v", err)
+	}
+	if _, err := buildTestIndex().WriteTo(f); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	f.Close()
+
+	mi, err := OpenMappedIndex(path)
+	if err != nil {
+		t.Fatalf("OpenMappedIndex() error = %v", err)
+	}
+
+	result, err := mi.Lookup(Matchers{MustNewMatcher(MatchEqual, "host", "server1")})
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if got := result.ToArray(); !equalUint32Slices(got, []uint32{1, 3}) {
+		t.Errorf("Lookup() = %v, want [1 3]", got)
+	}
+
+	if err := mi.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := mi.Lookup(Matchers{MustNewMatcher(MatchEqual, "host", "server1")}); err == nil {
+		t.Error("Lookup() after Close should fail")
+	}
+}
+
+func TestMappedIndex_InvalidData(t *testing.T) {
+	buf := new(bytes.Buffer)
+	buildTestIndex().WriteTo(buf)
+	data := buf.Bytes()
+
+	// Truncated file
+	if _, err := NewMappedIndex(data[:len(data)-10]); !errors.Is(err, ErrInvalidIndex) {
+		t.Errorf("truncated index: got %v, want ErrInvalidIndex", err)
+	}
+
+	// Version newer than supported
+	future := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(future[4:8], IndexFormatVersion+1)
+	if _, err := NewMappedIndex(future); !errors.Is(err, ErrIndexVersionTooNew) {
+		t.Errorf("future version: got %v, want ErrIndexVersionTooNew", err)
+	}
+	if _, err := NewInvertedIndex().ReadFrom(bytes.NewReader(future)); err == nil {
+		t.Error("ReadFrom() expected error for future index version")
+	}
+}
+
+func TestInvertedIndex_ReadFrom_V1(t *testing.T) {
+	// Hand-encode a v1 index: host=server1 -> [1], host=server2 -> [2]
+	v1 := new(bytes.Buffer)
+	binary.Write(v1, binary.LittleEndian, IndexMagic)
+	binary.Write(v1, binary.LittleEndian, IndexFormatV1)
+	binary.Write(v1, binary.LittleEndian, uint64(2))
+	binary.Write(v1, binary.LittleEndian, uint32(1))
+	writeString(v1, "host")
+	binary.Write(v1, binary.LittleEndian, uint32(2))
+	for id, value := range []string{"server1", "server2"} {
+		writeString(v1, value)
+		b, _ := roaring.BitmapOf(uint32(id + 1)).ToBytes()
+		binary.Write(v1, binary.LittleEndian, uint32(len(b)))
+		v1.Write(b)
+	}
+	data := v1.Bytes()
+
+	if _, err := NewMappedIndex(data); !errors.Is(err, ErrLegacyIndexFormat) {
+		t.Errorf("NewMappedIndex(v1) error = %v, want ErrLegacyIndexFormat", err)
+	}
+
+	idx := NewInvertedIndex()
+	if _, err := idx.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatalf("ReadFrom(v1) error = %v", err)
+	}
+	if got := idx.All().ToArray(); !equalUint32Slices(got, []uint32{1, 2}) {
+		t.Errorf("All() = %v, want [1 2]", got)
+	}
+	if got := idx.LabelValues("host"); len(got) != 2 {
+		t.Errorf("LabelValues(host) = %v, want 2 values", got)
+	}
+}
@@ -3,6 +3,7 @@ package index
 import (
 	"fmt"
 	"regexp"
+	"strings"
 )
 
 // MatchType defines the type of label matching operation.
@@ -52,6 +53,99 @@ type Matcher struct {
 	// regex is the compiled regular expression for MatchRegexp and MatchNotRegexp.
 	// It's lazily compiled on first use and cached for performance.
 	regex *regexp.Regexp
+
+	// set holds the alternatives of a regex made only of literals, such as
+	// a|b|c or ^(a|b|c)$, which are matched without the regex engine
+	set *valueSet
+}
+
+// valueSet is a regex of literal alternatives
+type valueSet struct {
+	values []string
+
+	// anchored is set for ^(a|b)$: a value must equal one of values.
+	// Otherwise it must contain one, as regex matchers aren't anchored.
+	anchored bool
+
+	// foldCase is set for (?i)^(a|b)$, matching values equal to one of
+	// values under Unicode case folding. Unanchored sets never fold case.
+	foldCase bool
+}
+
+// parseValueSet returns the set of a regex of literal alternatives, or nil
+// for any other regex
+func parseValueSet(expr string) *valueSet {
+	set := &valueSet{}
+	if rest, ok := strings.CutPrefix(expr, "(?i)"); ok {
+		set.foldCase = true
+		expr = rest
+	}
+	if strings.HasPrefix(expr, "^") && strings.HasSuffix(expr, "$") {
+		set.anchored = true
+		expr = expr[1 : len(expr)-1]
+	} else if strings.HasPrefix(expr, "^") || strings.HasSuffix(expr, "$") || set.foldCase {
+		return nil
+	}
+
+	// Alternatives are grouped between anchors, as ^a|b$ means (^a)|(b$)
+	grouped := false
+	for _, prefix := range []string{"(?:", "("} {
+		if strings.HasPrefix(expr, prefix) && strings.HasSuffix(expr, ")") {
+			expr = expr[len(prefix) : len(expr)-1]
+			grouped = true
+			break
+		}
+	}
+	set.values = strings.Split(expr, "|")
+	if set.anchored && !grouped && len(set.values) > 1 {
+		return nil
+	}
+	for _, v := range set.values {
+		if v == "" || regexp.QuoteMeta(v) != v {
+			return nil
+		}
+	}
+	return set
+}
+
+// matches reports whether value matches the set
+func (s *valueSet) matches(value string) bool {
+	for _, v := range s.values {
+		switch {
+		case s.foldCase:
+			if strings.EqualFold(value, v) {
+				return true
+			}
+		case s.anchored:
+			if value == v {
+				return true
+			}
+		default:
+			if strings.Contains(value, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// exactValues returns the label values a regex matcher selects if they are
+// known without scanning the values of its label: those of an anchored set
+// that doesn't fold case. ok is false otherwise.
+func (m *Matcher) exactValues() (values []string, ok bool) {
+	if m.set == nil || !m.set.anchored || m.set.foldCase {
+		return nil, false
+	}
+	return m.set.values, true
+}
+
+// matchRegexp reports whether value matches the regex of a MatchRegexp or
+// MatchNotRegexp matcher
+func (m *Matcher) matchRegexp(value string) bool {
+	if m.set != nil {
+		return m.set.matches(value)
+	}
+	return m.regex != nil && m.regex.MatchString(value)
 }
 
 // NewMatcher creates a new label matcher.
@@ -77,6 +171,7 @@ func NewMatcher(matchType MatchType, name, value string) (*Matcher, error) {
 			return nil, fmt.Errorf("invalid regex %q: %w", value, err)
 		}
 		m.regex = re
+		m.set = parseValueSet(value)
 	}
 
 	return m, nil
@@ -90,9 +185,9 @@ func (m *Matcher) Matches(value string) bool {
 	case MatchNotEqual:
 		return value != m.Value
 	case MatchRegexp:
-		return m.regex != nil && m.regex.MatchString(value)
+		return m.regex != nil && m.matchRegexp(value)
 	case MatchNotRegexp:
-		return m.regex != nil && !m.regex.MatchString(value)
+		return m.regex != nil && !m.matchRegexp(value)
 	default:
 		return false
 	}
@@ -117,10 +212,10 @@ func (m *Matcher) MatchesLabels(labels map[string]string) bool {
 		// If label doesn't exist, it's considered "not equal" to any value
 		return !exists || value != m.Value
 	case MatchRegexp:
-		return exists && m.regex != nil && m.regex.MatchString(value)
+		return exists && m.regex != nil && m.matchRegexp(value)
 	case MatchNotRegexp:
 		// If label doesn't exist, it's considered "not matching" the regex
-		return !exists || (m.regex != nil && !m.regex.MatchString(value))
+		return !exists || (m.regex != nil && !m.matchRegexp(value))
 	default:
 		return false
 	}
@@ -194,3 +289,68 @@ func MustNewMatcher(matchType MatchType, name, value string) *Matcher {
 	}
 	return m
 }
+
+// ParseMatchers parses a selector into label matchers.
+// Example: {__name__="cpu_usage",host="server1"}
+// This is a simplified parser for the basic format.
+func ParseMatchers(queryStr string) (Matchers, error) {
+	queryStr = strings.TrimSpace(queryStr)
+
+	// Simple parsing: expect format {label="value",label2="value2"}
+	if !strings.HasPrefix(queryStr, "{") || !strings.HasSuffix(queryStr, "}") {
+		return nil, fmt.Errorf("query must be in format {label=\"value\",...}")
+	}
+
+	// Remove braces
+	queryStr = strings.TrimPrefix(queryStr, "{")
+	queryStr = strings.TrimSuffix(queryStr, "}")
+
+	if queryStr == "" {
+		// Empty matcher matches all series
+		return Matchers{}, nil
+	}
+
+	// Split by comma
+	parts := strings.Split(queryStr, ",")
+	matchers := make(Matchers, 0, len(parts))
+
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+
+		// Parse label="value" or label!="value" or label=~"regex" or label!~"regex"
+		var matchType MatchType
+		var labelName, labelValue string
+
+		if strings.Contains(part, "=~") {
+			matchType = MatchRegexp
+			sides := strings.SplitN(part, "=~", 2)
+			labelName = strings.TrimSpace(sides[0])
+			labelValue = strings.Trim(strings.TrimSpace(sides[1]), "\"")
+		} else if strings.Contains(part, "!~") {
+			matchType = MatchNotRegexp
+			sides := strings.SplitN(part, "!~", 2)
+			labelName = strings.TrimSpace(sides[0])
+			labelValue = strings.Trim(strings.TrimSpace(sides[1]), "\"")
+		} else if strings.Contains(part, "!=") {
+			matchType = MatchNotEqual
+			sides := strings.SplitN(part, "!=", 2)
+			labelName = strings.TrimSpace(sides[0])
+			labelValue = strings.Trim(strings.TrimSpace(sides[1]), "\"")
+		} else if strings.Contains(part, "=") {
+			matchType = MatchEqual
+			sides := strings.SplitN(part, "=", 2)
+			labelName = strings.TrimSpace(sides[0])
+			labelValue = strings.Trim(strings.TrimSpace(sides[1]), "\"")
+		} else {
+			return nil, fmt.Errorf("invalid matcher format: %s", part)
+		}
+
+		matcher, err := NewMatcher(matchType, labelName, labelValue)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+
+	return matchers, nil
+}
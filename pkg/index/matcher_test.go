@@ -1,6 +1,7 @@
 package index
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -422,7 +423,7 @@ func TestMatchers_Matches(t *testing.T) {
 			wantMatch: true,
 		},
 		{
-			name: "empty matchers",
+			name:     "empty matchers",
 			matchers: Matchers{},
 			labels: map[string]string{
 				"host": "server1",
@@ -531,3 +532,47 @@ func TestMustNewMatcher(t *testing.T) {
 	}()
 	MustNewMatcher(MatchRegexp, "host", "[invalid")
 }
+
+func TestParseValueSet(t *testing.T) {
+	tests := []struct {
+		expr string
+		want *valueSet
+	}{
+		{"a|b|c", &valueSet{values: []string{"a", "b", "c"}}},
+		{"(a|b)", &valueSet{values: []string{"a", "b"}}},
+		{"^(a|b)$", &valueSet{values: []string{"a", "b"}, anchored: true}},
+		{"^(?:a|b)$", &valueSet{values: []string{"a", "b"}, anchored: true}},
+		{"^server1$", &valueSet{values: []string{"server1"}, anchored: true}},
+		{"(?i)^(a|b)$", &valueSet{values: []string{"a", "b"}, anchored: true, foldCase: true}},
+		{"^a|b$", nil},          // (^a)|(b$)
+		{"^a|b", nil},           // Anchored at one end only
+		{"(?i)a|b", nil},        // Unanchored and case-insensitive
+		{"a|", nil},             // Matches everything
+		{"server.*|db", nil},    // Not literal
+		{"(a)|(b)", nil},        // Several groups
+		{`^a\$`, nil},           // Escaped anchor
+		{"^(a|b.c)$", nil},      // Not literal
+		{"(?i)^server.*$", nil}, // Not literal
+	}
+	for _, tt := range tests {
+		if got := parseValueSet(tt.expr); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseValueSet(%q) = %+v, want %+v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestMatcher_ValueSetMatchesRegexp(t *testing.T) {
+	exprs := []string{"a|bc|c", "(a|bc)", "^(a|bc)$", "^bc$", "(?i)^(a|Bc)$"}
+	values := []string{"", "a", "A", "bc", "BC", "abc", "xbcx", "c", "ab"}
+	for _, expr := range exprs {
+		m := MustNewMatcher(MatchRegexp, "l", expr)
+		if m.set == nil {
+			t.Fatalf("%s: no value set", expr)
+		}
+		for _, v := range values {
+			if got, want := m.Matches(v), m.regex.MatchString(v); got != want {
+				t.Errorf("%s matches %q = %v, regex says %v", expr, v, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// IndexWriter builds the index of a block. A flushed block's index is
+// built from the label sets of its series; a compacted block's is merged
+// from the indexes of the blocks it replaces, with each source's series
+// IDs remapped to the new block's, so label sets never have to be
+// rebuilt from posting lists. Series a remapping leaves out, such as
+// deleted series, are dropped from the merged index.
+type IndexWriter struct {
+	idx *InvertedIndex
+}
+
+// NewIndexWriter returns a writer for an empty index
+func NewIndexWriter() *IndexWriter {
+	return &IndexWriter{idx: NewInvertedIndex()}
+}
+
+// AddSeries indexes a series under id
+func (w *IndexWriter) AddSeries(id series.SeriesID, labels map[string]string) error {
+	return w.idx.Add(id, labels)
+}
+
+// Merge adds the postings of r, with the ID of each of its series replaced
+// by remap[id]. Series of r missing from remap are left out. It returns
+// the number of series of r merged.
+func (w *IndexWriter) Merge(r Reader, remap map[uint32]uint32) (int, error) {
+	for _, name := range r.LabelNames() {
+		for _, value := range r.LabelValues(name) {
+			postings, err := r.Lookup(Matchers{MustNewMatcher(MatchEqual, name, value)})
+			if err != nil {
+				return 0, fmt.Errorf("failed to read postings of %s=%q: %w", name, value, err)
+			}
+			if remapped := remapPostings(postings, remap); !remapped.IsEmpty() {
+				w.idx.addPostings(name, value, remapped)
+			}
+		}
+	}
+
+	all := remapPostings(r.All(), remap)
+	w.idx.allMu.Lock()
+	w.idx.all.Or(all)
+	w.idx.allMu.Unlock()
+	return int(all.GetCardinality()), nil
+}
+
+// remapPostings returns the IDs of postings found in remap, replaced by
+// the IDs they map to
+func remapPostings(postings *roaring.Bitmap, remap map[uint32]uint32) *roaring.Bitmap {
+	remapped := roaring.New()
+	it := postings.Iterator()
+	for it.HasNext() {
+		if id, ok := remap[it.Next()]; ok {
+			remapped.Add(id)
+		}
+	}
+	return remapped
+}
+
+// Index returns the index built so far. Series added after it is
+// returned are added to it too.
+func (w *IndexWriter) Index() *InvertedIndex {
+	return w.idx
+}
+
+// WriteTo writes the index in the current format
+func (w *IndexWriter) WriteTo(out io.Writer) (int64, error) {
+	return w.idx.WriteTo(out)
+}
+
+// WriteFile writes the index to path and syncs it
+func (w *IndexWriter) WriteFile(path string) error {
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		return fmt.Errorf("failed to encode index: %w", err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create index file: %w", err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync index: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close index file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package index
+
+import (
+	"bytes"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// assertSameIndex fails unless got and want hold the same label pairs with
+// the same posting lists
+func assertSameIndex(t *testing.T, got, want Reader) {
+	t.Helper()
+	if g, w := got.All().ToArray(), want.All().ToArray(); !slices.Equal(g, w) {
+		t.Errorf("All() = %v, want %v", g, w)
+	}
+	if g, w := got.LabelNames(), want.LabelNames(); !slices.Equal(g, w) {
+		t.Fatalf("LabelNames() = %v, want %v", g, w)
+	}
+	for _, name := range want.LabelNames() {
+		if g, w := got.LabelValues(name), want.LabelValues(name); !slices.Equal(g, w) {
+			t.Errorf("LabelValues(%s) = %v, want %v", name, g, w)
+			continue
+		}
+		for _, value := range want.LabelValues(name) {
+			q := Matchers{MustNewMatcher(MatchEqual, name, value)}
+			g, err := got.Lookup(q)
+			if err != nil {
+				t.Fatalf("Lookup(%v) error = %v", q, err)
+			}
+			w, err := want.Lookup(q)
+			if err != nil {
+				t.Fatalf("Lookup(%v) error = %v", q, err)
+			}
+			if !slices.Equal(g.ToArray(), w.ToArray()) {
+				t.Errorf("Lookup(%v) = %v, want %v", q, g.ToArray(), w.ToArray())
+			}
+		}
+	}
+}
+
+func TestIndexWriter_RoundTrip(t *testing.T) {
+	w := NewIndexWriter()
+	w.AddSeries(1, map[string]string{"__name__": "cpu", "host": "server1", "env": "prod"})
+	w.AddSeries(2, map[string]string{"__name__": "cpu", "host": "server2", "env": "dev"})
+	w.AddSeries(3, map[string]string{"__name__": "mem", "host": "server1", "env": "prod"})
+	w.AddSeries(4, map[string]string{"__name__": "mem", "host": "server3"})
+
+	path := filepath.Join(t.TempDir(), "index")
+	if err := w.WriteFile(path); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	mi, err := OpenMappedIndex(path)
+	if err != nil {
+		t.Fatalf("OpenMappedIndex() error = %v", err)
+	}
+	defer mi.Close()
+
+	assertSameIndex(t, mi, buildTestIndex())
+	assertSameIndex(t, w.Index(), buildTestIndex())
+}
+
+func TestIndexWriter_Merge(t *testing.T) {
+	// Both sources as written to disk
+	encode := func(idx *InvertedIndex) *MappedIndex {
+		buf := new(bytes.Buffer)
+		if _, err := idx.WriteTo(buf); err != nil {
+			t.Fatalf("WriteTo() error = %v", err)
+		}
+		mi, err := NewMappedIndex(buf.Bytes())
+		if err != nil {
+			t.Fatalf("NewMappedIndex() error = %v", err)
+		}
+		return mi
+	}
+	first := encode(buildTestIndex())
+	second := NewInvertedIndex()
+	second.Add(1, map[string]string{"__name__": "cpu", "host": "server1", "env": "prod"})
+	second.Add(2, map[string]string{"__name__": "disk", "host": "server4"})
+
+	// Series 1 of both sources is the same series; series 3 of the first
+	// was deleted
+	w := NewIndexWriter()
+	if n, err := w.Merge(first, map[uint32]uint32{1: 10, 2: 11, 4: 13}); err != nil || n != 3 {
+		t.Fatalf("Merge(first) = %d, %v; want 3 series", n, err)
+	}
+	if n, err := w.Merge(encode(second), map[uint32]uint32{1: 10, 2: 14}); err != nil || n != 2 {
+		t.Fatalf("Merge(second) = %d, %v; want 2 series", n, err)
+	}
+
+	want := NewInvertedIndex()
+	want.Add(10, map[string]string{"__name__": "cpu", "host": "server1", "env": "prod"})
+	want.Add(11, map[string]string{"__name__": "cpu", "host": "server2", "env": "dev"})
+	want.Add(13, map[string]string{"__name__": "mem", "host": "server3"})
+	want.Add(14, map[string]string{"__name__": "disk", "host": "server4"})
+	assertSameIndex(t, w.Index(), want)
+
+	// The merged index survives a round trip
+	buf := new(bytes.Buffer)
+	if _, err := w.WriteTo(buf); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	mi, err := NewMappedIndex(buf.Bytes())
+	if err != nil {
+		t.Fatalf("NewMappedIndex() error = %v", err)
+	}
+	assertSameIndex(t, mi, want)
+
+	// Merging an index with every series dropped adds nothing
+	if n, err := w.Merge(first, nil); err != nil || n != 0 {
+		t.Errorf("Merge without a remapping = %d, %v; want nothing merged", n, err)
+	}
+	assertSameIndex(t, w.Index(), want)
+}
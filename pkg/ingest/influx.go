@@ -0,0 +1,152 @@
+package ingest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseInfluxLine parses a line of the Influx line protocol:
+//
+//	measurement[,tag=value...] field=value[,field=value...] [timestamp_ns]
+//
+// Each numeric or boolean field becomes a sample of the metric
+// measurement_field, labeled with the tags; string fields are skipped.
+// Names are sanitized to valid metric and label names.
+func parseInfluxLine(line string, defaultTimestamp int64) ([]Sample, error) {
+	parts := splitUnescaped(line, ' ', true)
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("expected measurement, fields and an optional timestamp, got %q", line)
+	}
+
+	key := splitUnescaped(parts[0], ',', false)
+	measurement := unescapeInflux(key[0])
+	if measurement == "" {
+		return nil, fmt.Errorf("missing measurement")
+	}
+
+	tags := make(map[string]string, len(key)-1)
+	for _, tag := range key[1:] {
+		kv := splitUnescaped(tag, '=', false)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid tag %q", tag)
+		}
+		tags[sanitizeName(unescapeInflux(kv[0]), false)] = unescapeInflux(kv[1])
+	}
+
+	timestamp := defaultTimestamp
+	if len(parts) == 3 {
+		ns, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", parts[2])
+		}
+		timestamp = ns / 1e6
+	}
+
+	var samples []Sample
+	for _, field := range splitUnescaped(parts[1], ',', true) {
+		kv := splitUnescaped(field, '=', true)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid field %q", field)
+		}
+		value, ok, err := parseInfluxValue(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", kv[0], err)
+		}
+		if !ok {
+			continue
+		}
+
+		labels := make(map[string]string, len(tags)+1)
+		for name, v := range tags {
+			labels[name] = v
+		}
+		labels[MetricNameLabel] = sanitizeName(measurement+"_"+unescapeInflux(kv[0]), true)
+		samples = append(samples, Sample{Labels: labels, Timestamp: timestamp, Value: value})
+	}
+	return samples, nil
+}
+
+// parseInfluxValue parses a field value. ok is false for string values,
+// which have no numeric representation.
+func parseInfluxValue(s string) (value float64, ok bool, err error) {
+	switch s {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+	if s[0] == '"' {
+		return 0, false, nil
+	}
+
+	switch s[len(s)-1] {
+	case 'i':
+		n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid integer %q", s)
+		}
+		return float64(n), true, nil
+	case 'u':
+		n, err := strconv.ParseUint(s[:len(s)-1], 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid unsigned integer %q", s)
+		}
+		return float64(n), true, nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid float %q", s)
+	}
+	return f, true, nil
+}
+
+// splitUnescaped splits s at each sep not escaped by a backslash and, if
+// quotes is set, not inside a double-quoted string. Escapes are kept.
+func splitUnescaped(s string, sep byte, quotes bool) []string {
+	var parts []string
+	start := 0
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"' && quotes:
+			inQuotes = !inQuotes
+		case c == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+			// Runs of spaces separate the sections of a line
+			for sep == ' ' && start < len(s) && s[start] == ' ' {
+				start++
+				i++
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// influxUnescaper removes the escapes allowed in measurements, tags and
+// field keys
+var influxUnescaper = strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=", `\\`, `\`)
+
+func unescapeInflux(s string) string {
+	return influxUnescaper.Replace(s)
+}
+
+// sanitizeName replaces characters not allowed in a metric (or, if
+// metric is false, label) name with underscores
+func sanitizeName(s string, metric bool) string {
+	b := []byte(s)
+	for i, c := range b {
+		valid := isLabelNameChar(c, i == 0)
+		if metric {
+			valid = isMetricNameChar(c, i == 0)
+		}
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
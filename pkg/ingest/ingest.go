@@ -0,0 +1,120 @@
+// Package ingest parses time series from text formats other tools write:
+// the Prometheus exposition format and the Influx line protocol.
+package ingest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// MetricNameLabel is the label holding the metric name
+const MetricNameLabel = "__name__"
+
+// Format is an ingest format
+type Format string
+
+const (
+	FormatPrometheus Format = "prometheus"
+	FormatInflux     Format = "influx"
+)
+
+// ParseFormat parses a format name
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatPrometheus, FormatInflux:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format %q: must be prometheus or influx", s)
+	}
+}
+
+// Sample is one parsed sample with the labels of its series
+type Sample struct {
+	Labels    map[string]string
+	Timestamp int64 // Unix milliseconds
+	Value     float64
+}
+
+// ParseError reports a malformed line
+type ParseError struct {
+	Line int
+	Err  error
+}
+
+// Error implements the error interface
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Parser reads samples line by line. Blank lines and comments are
+// skipped; an Influx line with several fields yields several samples.
+type Parser struct {
+	scanner   *bufio.Scanner
+	parseLine func(line string, defaultTimestamp int64) ([]Sample, error)
+	defaultTS int64
+	line      int
+	pending   []Sample
+}
+
+// maxLineSize bounds the length of a single input line
+const maxLineSize = 1024 * 1024
+
+// NewParser creates a parser reading the format from r. Samples without a
+// timestamp get defaultTimestamp (Unix milliseconds).
+func NewParser(r io.Reader, format Format, defaultTimestamp int64) (*Parser, error) {
+	p := &Parser{
+		scanner:   bufio.NewScanner(r),
+		defaultTS: defaultTimestamp,
+	}
+	p.scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	switch format {
+	case FormatPrometheus:
+		p.parseLine = parsePrometheusLine
+	case FormatInflux:
+		p.parseLine = parseInfluxLine
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	return p, nil
+}
+
+// Next returns the next sample. It returns io.EOF once the input is
+// exhausted and a *ParseError for a malformed line.
+func (p *Parser) Next() (Sample, error) {
+	for len(p.pending) == 0 {
+		if !p.scanner.Scan() {
+			if err := p.scanner.Err(); err != nil {
+				return Sample{}, err
+			}
+			return Sample{}, io.EOF
+		}
+		p.line++
+
+		line := strings.TrimSpace(p.scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		samples, err := p.parseLine(line, p.defaultTS)
+		if err != nil {
+			return Sample{}, &ParseError{Line: p.line, Err: err}
+		}
+		p.pending = samples
+	}
+
+	s := p.pending[0]
+	p.pending = p.pending[1:]
+	return s, nil
+}
+
+// Line returns the number of the last line read
+func (p *Parser) Line() int {
+	return p.line
+}
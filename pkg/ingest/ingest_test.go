@@ -0,0 +1,143 @@
+package ingest
+
+import (
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parseAll(t *testing.T, input string, format Format) ([]Sample, error) {
+	t.Helper()
+	p, err := NewParser(strings.NewReader(input), format, 42)
+	if err != nil {
+		t.Fatalf("NewParser failed: %v", err)
+	}
+	var samples []Sample
+	for {
+		s, err := p.Next()
+		if err == io.EOF {
+			return samples, nil
+		}
+		if err != nil {
+			return samples, err
+		}
+		samples = append(samples, s)
+	}
+}
+
+func TestParsePrometheus(t *testing.T) {
+	input := `# HELP http_requests_total Requests served
+# TYPE http_requests_total counter
+http_requests_total{method="GET",path="/a \"b\"\\c"} 1027 1700000000000
+http_requests_total{method="POST",} 3
+
+node:load1 NaN
+up{job="api"}   +Inf
+`
+	samples, err := parseAll(t, input, FormatPrometheus)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if len(samples) != 4 {
+		t.Fatalf("expected 4 samples, got %d", len(samples))
+	}
+
+	want := Sample{
+		Labels:    map[string]string{MetricNameLabel: "http_requests_total", "method": "GET", "path": `/a "b"\c`},
+		Timestamp: 1700000000000,
+		Value:     1027,
+	}
+	if !reflect.DeepEqual(samples[0], want) {
+		t.Errorf("got %+v, want %+v", samples[0], want)
+	}
+	if samples[1].Timestamp != 42 || samples[1].Labels["method"] != "POST" {
+		t.Errorf("unexpected second sample %+v", samples[1])
+	}
+	if samples[2].Labels[MetricNameLabel] != "node:load1" || !math.IsNaN(samples[2].Value) {
+		t.Errorf("unexpected third sample %+v", samples[2])
+	}
+	if !math.IsInf(samples[3].Value, 1) {
+		t.Errorf("expected +Inf, got %v", samples[3].Value)
+	}
+}
+
+func TestParsePrometheusErrors(t *testing.T) {
+	for _, line := range []string{
+		`{job="api"} 1`,
+		`up{job=api} 1`,
+		`up{job="api" 1`,
+		`up{job="api"}`,
+		`up 1 2 3`,
+		`up one`,
+		`up 1 soon`,
+	} {
+		_, err := parseAll(t, "ok 1\n"+line+"\n", FormatPrometheus)
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Line != 2 {
+			t.Errorf("%q: expected a ParseError on line 2, got %v", line, err)
+		}
+	}
+}
+
+func TestParseInflux(t *testing.T) {
+	input := `cpu,host=server\ 1,region=us-west usage_user=0.5,usage_system=2i,idle=true 1700000000000000000
+disk\,io,dev.name=sda reads=10u,model="WD 1TB, fast"
+events msg="string only"
+`
+	samples, err := parseAll(t, input, FormatInflux)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if len(samples) != 4 {
+		t.Fatalf("expected 4 samples, got %d: %+v", len(samples), samples)
+	}
+
+	want := Sample{
+		Labels:    map[string]string{MetricNameLabel: "cpu_usage_user", "host": "server 1", "region": "us-west"},
+		Timestamp: 1700000000000,
+		Value:     0.5,
+	}
+	if !reflect.DeepEqual(samples[0], want) {
+		t.Errorf("got %+v, want %+v", samples[0], want)
+	}
+	if samples[1].Labels[MetricNameLabel] != "cpu_usage_system" || samples[1].Value != 2 {
+		t.Errorf("unexpected integer field %+v", samples[1])
+	}
+	if samples[2].Labels[MetricNameLabel] != "cpu_idle" || samples[2].Value != 1 {
+		t.Errorf("unexpected boolean field %+v", samples[2])
+	}
+
+	disk := samples[3]
+	if disk.Labels[MetricNameLabel] != "disk_io_reads" || disk.Labels["dev_name"] != "sda" ||
+		disk.Value != 10 || disk.Timestamp != 42 {
+		t.Errorf("unexpected escaped sample %+v", disk)
+	}
+}
+
+func TestParseInfluxErrors(t *testing.T) {
+	for _, line := range []string{
+		`cpu`,
+		`cpu,host usage=1`,
+		`cpu usage`,
+		`cpu usage=abc`,
+		`cpu usage=1 yesterday`,
+	} {
+		_, err := parseAll(t, line+"\n", FormatInflux)
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Line != 1 {
+			t.Errorf("%q: expected a ParseError on line 1, got %v", line, err)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, err := ParseFormat("Influx"); err != nil || f != FormatInflux {
+		t.Errorf("ParseFormat(Influx) = %q, %v", f, err)
+	}
+	if _, err := ParseFormat("csv"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,128 @@
+package ingest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parsePrometheusLine parses a sample line of the Prometheus exposition
+// format: name{label="value",...} value [timestamp_ms]
+func parsePrometheusLine(line string, defaultTimestamp int64) ([]Sample, error) {
+	i := 0
+	for i < len(line) && isMetricNameChar(line[i], i == 0) {
+		i++
+	}
+	if i == 0 {
+		return nil, fmt.Errorf("invalid metric name in %q", line)
+	}
+	labels := map[string]string{MetricNameLabel: line[:i]}
+
+	rest := line[i:]
+	if strings.HasPrefix(rest, "{") {
+		n, err := parsePrometheusLabels(rest, labels)
+		if err != nil {
+			return nil, err
+		}
+		rest = rest[n:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("expected a value and an optional timestamp, got %q", strings.TrimSpace(rest))
+	}
+
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q", fields[0])
+	}
+
+	timestamp := defaultTimestamp
+	if len(fields) == 2 {
+		timestamp, err = strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", fields[1])
+		}
+	}
+
+	return []Sample{{Labels: labels, Timestamp: timestamp, Value: value}}, nil
+}
+
+// parsePrometheusLabels parses a {label="value",...} set at the start of s
+// into labels and returns its length
+func parsePrometheusLabels(s string, labels map[string]string) (int, error) {
+	i := 1 // Skip '{'
+	for {
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		if i < len(s) && s[i] == '}' {
+			return i + 1, nil
+		}
+
+		start := i
+		for i < len(s) && isLabelNameChar(s[i], i == start) {
+			i++
+		}
+		if i == start {
+			return 0, fmt.Errorf("invalid label name at offset %d", start)
+		}
+		name := s[start:i]
+
+		if i+1 >= len(s) || s[i] != '=' || s[i+1] != '"' {
+			return 0, fmt.Errorf("expected =\" after label %q", name)
+		}
+		i += 2
+
+		var value strings.Builder
+		for {
+			if i >= len(s) {
+				return 0, fmt.Errorf("unterminated value for label %q", name)
+			}
+			c := s[i]
+			if c == '"' {
+				i++
+				break
+			}
+			if c == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				case '\\', '"':
+					value.WriteByte(s[i])
+				default:
+					return 0, fmt.Errorf("invalid escape \\%c in label %q", s[i], name)
+				}
+				i++
+				continue
+			}
+			value.WriteByte(c)
+			i++
+		}
+		labels[name] = value.String()
+
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		switch {
+		case i < len(s) && s[i] == ',':
+			i++
+		case i < len(s) && s[i] == '}':
+			return i + 1, nil
+		default:
+			return 0, fmt.Errorf("expected , or } after label %q", name)
+		}
+	}
+}
+
+// isMetricNameChar reports whether c may appear in a metric name
+func isMetricNameChar(c byte, first bool) bool {
+	return c == ':' || isLabelNameChar(c, first)
+}
+
+// isLabelNameChar reports whether c may appear in a label name
+func isLabelNameChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+		(!first && c >= '0' && c <= '9')
+}
@@ -13,6 +13,7 @@ type Metrics struct {
 	samplesIngestedBytesTotal atomic.Int64
 	insertErrorsTotal        atomic.Int64
 	insertDurationSeconds    *Histogram
+	seriesHashCollisionsTotal atomic.Int64
 
 	// WAL metrics
 	walSizeBytes          atomic.Int64
@@ -20,6 +21,10 @@ type Metrics struct {
 	walSyncDurationSeconds *Histogram
 	walCorruptionsTotal   atomic.Int64
 
+	// Flush metrics
+	flushesTotal         atomic.Int64
+	flushDurationSeconds *Histogram
+
 	// MemTable metrics
 	headSeries atomic.Int64
 	headChunks atomic.Int64
@@ -67,6 +72,7 @@ func NewMetrics() *Metrics {
 	return &Metrics{
 		insertDurationSeconds:     NewHistogram("insert_duration_seconds"),
 		walSyncDurationSeconds:    NewHistogram("wal_sync_duration_seconds"),
+		flushDurationSeconds:      NewHistogram("flush_duration_seconds"),
 		compactionDurationSeconds: NewHistogram("compaction_duration_seconds"),
 		queryDurationSeconds:      NewHistogram("query_duration_seconds"),
 		gcDurationSeconds:         NewHistogram("gc_duration_seconds"),
@@ -84,6 +90,12 @@ func (m *Metrics) RecordInsertError() {
 	m.insertErrorsTotal.Add(1)
 }
 
+// RecordSeriesHashCollision records a series found sharing a hash with
+// another label set
+func (m *Metrics) RecordSeriesHashCollision() {
+	m.seriesHashCollisionsTotal.Add(1)
+}
+
 // RecordInsertDuration records insert latency
 func (m *Metrics) RecordInsertDuration(d time.Duration) {
 	m.insertDurationSeconds.Observe(d.Seconds())
@@ -109,6 +121,22 @@ func (m *Metrics) RecordWALCorruption() {
 	m.walCorruptionsTotal.Add(1)
 }
 
+// WALSyncDuration returns statistics of WAL sync durations, in seconds
+func (m *Metrics) WALSyncDuration() *HistogramStats {
+	return m.walSyncDurationSeconds.GetStats()
+}
+
+// RecordFlush records a MemTable flush to a block
+func (m *Metrics) RecordFlush(d time.Duration) {
+	m.flushesTotal.Add(1)
+	m.flushDurationSeconds.Observe(d.Seconds())
+}
+
+// FlushDuration returns statistics of flush durations, in seconds
+func (m *Metrics) FlushDuration() *HistogramStats {
+	return m.flushDurationSeconds.GetStats()
+}
+
 // SetHeadSeries sets number of series in head (MemTable)
 func (m *Metrics) SetHeadSeries(count int64) {
 	m.headSeries.Store(count)
@@ -151,6 +179,11 @@ func (m *Metrics) RecordCompaction(duration time.Duration, bytes int64) {
 	m.compactedBytesTotal.Add(bytes)
 }
 
+// CompactionDuration returns statistics of compaction durations, in seconds
+func (m *Metrics) CompactionDuration() *HistogramStats {
+	return m.compactionDurationSeconds.GetStats()
+}
+
 // RecordCompactionFailure records a compaction failure
 func (m *Metrics) RecordCompactionFailure() {
 	m.compactionFailuresTotal.Add(1)
@@ -188,11 +221,14 @@ type MetricsSnapshot struct {
 	SamplesIngestedTotal      int64
 	SamplesIngestedBytesTotal int64
 	InsertErrorsTotal         int64
+	SeriesHashCollisionsTotal int64
 
 	WALSizeBytes        int64
 	WALSegmentsTotal    int64
 	WALCorruptionsTotal int64
 
+	FlushesTotal int64
+
 	HeadSeries    int64
 	HeadChunks    int64
 	HeadSizeBytes int64
@@ -220,11 +256,14 @@ func (m *Metrics) Snapshot() *MetricsSnapshot {
 		SamplesIngestedTotal:      m.samplesIngestedTotal.Load(),
 		SamplesIngestedBytesTotal: m.samplesIngestedBytesTotal.Load(),
 		InsertErrorsTotal:         m.insertErrorsTotal.Load(),
+		SeriesHashCollisionsTotal: m.seriesHashCollisionsTotal.Load(),
 
 		WALSizeBytes:        m.walSizeBytes.Load(),
 		WALSegmentsTotal:    m.walSegmentsTotal.Load(),
 		WALCorruptionsTotal: m.walCorruptionsTotal.Load(),
 
+		FlushesTotal: m.flushesTotal.Load(),
+
 		HeadSeries:    m.headSeries.Load(),
 		HeadChunks:    m.headChunks.Load(),
 		HeadSizeBytes: m.headSizeBytes.Load(),
@@ -18,6 +18,7 @@ func WritePrometheusMetrics(w io.Writer, m *Metrics) error {
 	writeCounter(&sb, "tsdb_samples_ingested_total", "Total number of samples ingested", snapshot.SamplesIngestedTotal)
 	writeCounter(&sb, "tsdb_samples_ingested_bytes_total", "Total bytes of samples ingested", snapshot.SamplesIngestedBytesTotal)
 	writeCounter(&sb, "tsdb_insert_errors_total", "Total number of insert errors", snapshot.InsertErrorsTotal)
+	writeCounter(&sb, "tsdb_series_hash_collisions_total", "Total series found sharing a hash with another label set", snapshot.SeriesHashCollisionsTotal)
 	writeHistogramStats(&sb, "tsdb_insert_duration_seconds", "Insert operation duration", m.insertDurationSeconds)
 
 	// WAL metrics
@@ -26,6 +27,10 @@ func WritePrometheusMetrics(w io.Writer, m *Metrics) error {
 	writeCounter(&sb, "tsdb_wal_corruptions_total", "Total WAL corruptions detected", snapshot.WALCorruptionsTotal)
 	writeHistogramStats(&sb, "tsdb_wal_sync_duration_seconds", "WAL sync duration", m.walSyncDurationSeconds)
 
+	// Flush metrics
+	writeCounter(&sb, "tsdb_flushes_total", "Total number of MemTable flushes", snapshot.FlushesTotal)
+	writeHistogramStats(&sb, "tsdb_flush_duration_seconds", "MemTable flush duration", m.flushDurationSeconds)
+
 	// MemTable/Head metrics
 	writeGauge(&sb, "tsdb_head_series", "Number of series in head (MemTable)", snapshot.HeadSeries)
 	writeGauge(&sb, "tsdb_head_chunks", "Number of chunks in head", snapshot.HeadChunks)
@@ -122,6 +127,20 @@ func GetMetricsSummary(m *Metrics) string {
 	sb.WriteString(fmt.Sprintf("  Segments: %d\n", snapshot.WALSegmentsTotal))
 	sb.WriteString(fmt.Sprintf("  Corruptions: %d\n", snapshot.WALCorruptionsTotal))
 
+	if syncStats := m.walSyncDurationSeconds.GetStats(); syncStats.Count > 0 {
+		sb.WriteString(fmt.Sprintf("  Sync Latency: p50=%.3fms p99=%.3fms\n",
+			syncStats.P50*1000, syncStats.P99*1000))
+	}
+
+	// Flushes
+	sb.WriteString("\nFlushes:\n")
+	sb.WriteString(fmt.Sprintf("  Total Flushes: %d\n", snapshot.FlushesTotal))
+
+	if flushStats := m.flushDurationSeconds.GetStats(); flushStats.Count > 0 {
+		sb.WriteString(fmt.Sprintf("  Flush Duration: p50=%.3fms p99=%.3fms\n",
+			flushStats.P50*1000, flushStats.P99*1000))
+	}
+
 	// MemTable/Head
 	sb.WriteString("\nHead (MemTable):\n")
 	sb.WriteString(fmt.Sprintf("  Series: %d\n", snapshot.HeadSeries))
@@ -139,6 +158,11 @@ func GetMetricsSummary(m *Metrics) string {
 	sb.WriteString(fmt.Sprintf("  Bytes Compacted: %.2f MB\n", float64(snapshot.CompactedBytesTotal)/(1024*1024)))
 	sb.WriteString(fmt.Sprintf("  Failures: %d\n", snapshot.CompactionFailuresTotal))
 
+	if compactionStats := m.compactionDurationSeconds.GetStats(); compactionStats.Count > 0 {
+		sb.WriteString(fmt.Sprintf("  Merge Duration: p50=%.3fms p99=%.3fms\n",
+			compactionStats.P50*1000, compactionStats.P99*1000))
+	}
+
 	// Queries
 	sb.WriteString("\nQueries:\n")
 	sb.WriteString(fmt.Sprintf("  Total Queries: %d\n", snapshot.QueriesTotal))
@@ -165,10 +189,13 @@ func MetricsList() []string {
 		"tsdb_samples_ingested_bytes_total",
 		"tsdb_insert_errors_total",
 		"tsdb_insert_duration_seconds",
+		"tsdb_series_hash_collisions_total",
 		"tsdb_wal_size_bytes",
 		"tsdb_wal_segments_total",
 		"tsdb_wal_corruptions_total",
 		"tsdb_wal_sync_duration_seconds",
+		"tsdb_flushes_total",
+		"tsdb_flush_duration_seconds",
 		"tsdb_head_series",
 		"tsdb_head_chunks",
 		"tsdb_head_size_bytes",
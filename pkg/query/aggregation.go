@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"strconv"
 
 	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
 )
 
 // AggregateFunc represents an aggregation function type.
@@ -32,8 +34,26 @@ const (
 
 	// StdVar aggregates by calculating variance
 	StdVar AggregateFunc = "stdvar"
+
+	// Group aggregates to 1 for every bucket with samples, enumerating the
+	// groups present
+	Group AggregateFunc = "group"
+
+	// CountValues counts the samples with each distinct value, returning a
+	// series per value with the value in AggregationQuery.ValueLabel
+	CountValues AggregateFunc = "count_values"
 )
 
+// ParseAggregateFunc parses an aggregation function name.
+func ParseAggregateFunc(s string) (AggregateFunc, error) {
+	switch fn := AggregateFunc(s); fn {
+	case Sum, Avg, Max, Min, Count, StdDev, StdVar, Group, CountValues:
+		return fn, nil
+	default:
+		return "", fmt.Errorf("unsupported aggregation function: %s", s)
+	}
+}
+
 // AggregationQuery represents an aggregation query.
 type AggregationQuery struct {
 	// Base query
@@ -45,17 +65,33 @@ type AggregationQuery struct {
 	// Step interval for bucketing (e.g., 5 minutes)
 	Step int64
 
+	// Align decides where buckets begin; each is stamped with its start
+	Align Alignment
+
+	// Fill decides what is returned for a group's empty buckets; empty
+	// means FillNone
+	Fill FillPolicy
+
 	// Group by labels (for multi-series aggregation)
 	GroupBy []string
 
 	// Without labels (exclude these labels from grouping)
 	Without []string
+
+	// ValueLabel is the label CountValues stores each distinct value in,
+	// replacing a group label of the same name; other functions must
+	// leave it empty
+	ValueLabel string
 }
 
 // AggregationResult represents the result of an aggregation.
 type AggregationResult struct {
 	// Grouped series results
 	Series []AggregatedTimeSeries
+
+	// Warnings are non-fatal notes about the result, like those of
+	// QueryResult
+	Warnings []string
 }
 
 // AggregatedTimeSeries represents a single aggregated time series.
@@ -64,79 +100,174 @@ type AggregatedTimeSeries struct {
 	Samples []series.Sample
 }
 
-// Aggregate executes an aggregation query.
+// Aggregate executes an aggregation query. Samples are read in batches
+// straight from the chunks and folded into the step buckets of their group
+// as they stream by, so memory grows with the number of buckets rather
+// than the number of samples selected.
 func (qe *QueryEngine) Aggregate(aq *AggregationQuery) (*AggregationResult, error) {
 	if aq == nil || aq.Query == nil {
 		return nil, fmt.Errorf("aggregation query cannot be nil")
 	}
 
-	if aq.Step <= 0 {
-		return nil, fmt.Errorf("step must be positive")
+	if err := aq.Align.Validate(aq.Step); err != nil {
+		return nil, err
+	}
+
+	if _, err := ParseAggregateFunc(string(aq.Function)); err != nil {
+		return nil, err
+	}
+	if aq.Function == CountValues && !storage.IsValidLabelName(aq.ValueLabel) {
+		return nil, fmt.Errorf("count_values needs a valid value label, got %q", aq.ValueLabel)
+	}
+	if aq.Function != CountValues && aq.ValueLabel != "" {
+		return nil, fmt.Errorf("value label is only used by count_values")
 	}
 
-	// Execute the base query
-	result, err := qe.ExecQuery(aq.Query)
+	policy, err := ParseFillPolicy(string(aq.Fill))
 	if err != nil {
 		return nil, err
 	}
+	var times []int64
+	if policy != FillNone {
+		if times, err = bucketTimes(aq); err != nil {
+			return nil, err
+		}
+	}
 
-	// Group series by labels
-	groups := qe.groupSeries(result.Series, aq.GroupBy, aq.Without)
+	groups, warnings, err := qe.aggregateGroups(aq)
+	if err != nil {
+		return nil, err
+	}
 
-	// Aggregate each group
 	aggregated := &AggregationResult{
-		Series: make([]AggregatedTimeSeries, 0, len(groups)),
+		Series:   make([]AggregatedTimeSeries, 0, len(groups)),
+		Warnings: warnings,
 	}
-
 	for _, group := range groups {
-		// Aggregate the series in this group
-		samples, err := qe.aggregateGroup(group.Series, aq.Function, aq.Step, aq.Query.MinTime, aq.Query.MaxTime)
-		if err != nil {
-			return nil, fmt.Errorf("failed to aggregate group: %w", err)
-		}
-
 		aggregated.Series = append(aggregated.Series, AggregatedTimeSeries{
-			Labels:  group.Labels,
-			Samples: samples,
+			Labels:  group.labels,
+			Samples: fill(group.samples(aq.Function), times, policy),
 		})
 	}
 
+	// Map iteration order is random; return groups in label order
+	sort.Slice(aggregated.Series, func(i, j int) bool {
+		return series.CompareLabels(aggregated.Series[i].Labels, aggregated.Series[j].Labels) < 0
+	})
+
 	return aggregated, nil
 }
 
-// groupSeries groups time series by labels.
-func (qe *QueryEngine) groupSeries(seriesList []TimeSeries, groupBy []string, without []string) []struct {
-	Labels  map[string]string
-	Series  []TimeSeries
-} {
-	groups := make(map[string][]TimeSeries)
-	groupLabels := make(map[string]map[string]string)
-
-	for _, ts := range seriesList {
-		// Compute group key
-		groupKey, labels := computeGroupKey(ts.Labels, groupBy, without)
-
-		groups[groupKey] = append(groups[groupKey], ts)
-		groupLabels[groupKey] = labels
-	}
-
-	// Convert to slice of groups
-	result := make([]struct {
-		Labels  map[string]string
-		Series  []TimeSeries
-	}, 0, len(groups))
-
-	for key, seriesGroup := range groups {
-		result = append(result, struct {
-			Labels  map[string]string
-			Series  []TimeSeries
-		}{
-			Labels:  groupLabels[key],
-			Series:  seriesGroup,
-		})
+// aggregateGroups streams the series selected by the query into their
+// groups, enforcing the query's limits like ExecQuery. Warnings tell of
+// data removed by retention or skipped as unreadable.
+func (qe *QueryEngine) aggregateGroups(aq *AggregationQuery) (map[string]*aggregationGroup, []string, error) {
+	groups := make(map[string]*aggregationGroup)
+	if aq.Query.Offset < 0 {
+		return nil, nil, fmt.Errorf("offset must not be negative")
 	}
 
-	return result
+	q, warnings, purged := qe.retentionBounds(aq.Query.window())
+	if purged {
+		return groups, warnings, nil
+	}
+
+	querier, err := qe.db.TracedQuerier(q.MinTime, q.MaxTime, q.BlockMatchers, q.Trace)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer querier.Close()
+
+	set, err := querier.SelectBatches(q.Matchers...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	limits := q.Limits
+	variance := aq.Function == StdDev || aq.Function == StdVar
+	numSeries, total := 0, 0
+	for set.Next() {
+		if limits.MaxSeries > 0 && numSeries == limits.MaxSeries {
+			if !limits.Truncate {
+				return nil, nil, limits.seriesError()
+			}
+			break
+		}
+		numSeries++
+
+		s, it := set.At()
+		key, labels := computeGroupKey(s.Labels, aq.GroupBy, aq.Without)
+		group, ok := groups[key]
+		if !ok {
+			group = newAggregationGroup(labels)
+		}
+
+		// A whole chunk in one bucket is folded in from its summary
+		if it, ok := it.(storage.SummaryIterator); ok && !variance && aq.Function != CountValues {
+			if summary, ok := it.Summary(); ok && (limits.MaxSamples <= 0 || total+summary.Count <= limits.MaxSamples) &&
+				group.addSummary(summary, aq) {
+				total += summary.Count
+				groups[key] = group
+				continue
+			}
+		}
+
+		truncated := false
+		for it.Next() {
+			batch := it.At()
+			total += len(batch)
+			if limits.MaxSamples > 0 && total > limits.MaxSamples {
+				if !limits.Truncate {
+					return nil, nil, limits.samplesError()
+				}
+				// Keep the earliest samples of this series that still fit
+				batch = batch[:len(batch)-(total-limits.MaxSamples)]
+				truncated = true
+			}
+			if aq.Function == CountValues {
+				addValues(groups, key, labels, batch, aq)
+			} else {
+				group.add(batch, aq, variance)
+			}
+			if truncated {
+				break
+			}
+		}
+
+		if len(group.times) > 0 {
+			groups[key] = group
+		}
+		if truncated {
+			break
+		}
+	}
+	if err := set.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return groups, append(warnings, querier.Warnings()...), nil
+}
+
+// addValues folds each sample of batch into the group of its value, a
+// subgroup of the group key and labels identify
+func addValues(groups map[string]*aggregationGroup, key string, labels map[string]string, batch []series.Sample, aq *AggregationQuery) {
+	for i, sample := range batch {
+		value := strconv.FormatFloat(sample.Value, 'f', -1, 64)
+		valueKey := key + "\xff" + value
+		group, ok := groups[valueKey]
+		if !ok {
+			valueLabels := make(map[string]string, len(labels)+1)
+			for name, v := range labels {
+				valueLabels[name] = v
+			}
+			valueLabels[aq.ValueLabel] = value
+			group = newAggregationGroup(valueLabels)
+		}
+		group.add(batch[i:i+1], aq, false)
+		if len(group.times) > 0 {
+			groups[valueKey] = group
+		}
+	}
 }
 
 // computeGroupKey computes a grouping key and labels for a series.
@@ -184,332 +315,237 @@ func computeGroupKey(labels map[string]string, groupBy []string, without []strin
 	return key, groupLabels
 }
 
-// aggregateGroup aggregates a group of time series.
-func (qe *QueryEngine) aggregateGroup(seriesList []TimeSeries, fn AggregateFunc, step int64, minTime, maxTime int64) ([]series.Sample, error) {
-	if len(seriesList) == 0 {
-		return nil, nil
-	}
-
-	// Align samples to step boundaries
-	buckets := make(map[int64][]float64)
-
-	for _, ts := range seriesList {
-		for _, sample := range ts.Samples {
-			if sample.Timestamp < minTime || sample.Timestamp > maxTime {
-				continue
-			}
-
-			// Align to step boundary
-			bucketTime := (sample.Timestamp / step) * step
-			buckets[bucketTime] = append(buckets[bucketTime], sample.Value)
-		}
-	}
-
-	// Aggregate each bucket
-	result := make([]series.Sample, 0, len(buckets))
-
-	for bucketTime, values := range buckets {
-		aggregatedValue, err := applyAggregation(values, fn)
-		if err != nil {
-			return nil, err
-		}
-
-		result = append(result, series.Sample{
-			Timestamp: bucketTime,
-			Value:     aggregatedValue,
-		})
-	}
+// aggregationGroup accumulates the samples of a group's series into step
+// buckets
+type aggregationGroup struct {
+	labels map[string]string
 
-	// Sort by timestamp
-	sort.Slice(result, func(i, j int) bool {
-		return result[i].Timestamp < result[j].Timestamp
-	})
+	index       map[int64]int // Bucket time to its position in times and aggregators
+	times       []int64
+	aggregators []aggregator
 
-	return result, nil
+	// Bucket [lastTime, lastEnd) of the previous sample; consecutive
+	// samples of a series mostly share one
+	lastTime  int64
+	lastEnd   int64
+	lastIndex int
 }
 
-// applyAggregation applies an aggregation function to a set of values.
-func applyAggregation(values []float64, fn AggregateFunc) (float64, error) {
-	if len(values) == 0 {
-		return 0, nil
+func newAggregationGroup(labels map[string]string) *aggregationGroup {
+	return &aggregationGroup{
+		labels:    labels,
+		index:     make(map[int64]int),
+		lastIndex: -1,
 	}
+}
 
-	switch fn {
-	case Sum:
-		sum := 0.0
-		for _, v := range values {
-			sum += v
-		}
-		return sum, nil
-
-	case Avg:
-		sum := 0.0
-		for _, v := range values {
-			sum += v
-		}
-		return sum / float64(len(values)), nil
-
-	case Max:
-		max := math.Inf(-1)
-		for _, v := range values {
-			if v > max {
-				max = v
-			}
+// add folds the samples of batch, moved forward by the query offset,
+// within the query range into the buckets aq aligns them to
+func (g *aggregationGroup) add(batch []series.Sample, aq *AggregationQuery, variance bool) {
+	q := aq.Query
+	for _, sample := range batch {
+		ts := sample.Timestamp + q.Offset
+		if ts < q.MinTime || ts > q.MaxTime {
+			continue
 		}
-		return max, nil
 
-	case Min:
-		min := math.Inf(1)
-		for _, v := range values {
-			if v < min {
-				min = v
+		if g.lastIndex < 0 || ts < g.lastTime || ts >= g.lastEnd {
+			bucketTime, bucketEnd := aq.Align.Bucket(ts, aq.Step, q.MinTime)
+			i, ok := g.index[bucketTime]
+			if !ok {
+				i = len(g.times)
+				g.index[bucketTime] = i
+				g.times = append(g.times, bucketTime)
+				g.aggregators = append(g.aggregators, newAggregator())
 			}
+			g.lastTime, g.lastEnd, g.lastIndex = bucketTime, bucketEnd, i
 		}
-		return min, nil
-
-	case Count:
-		return float64(len(values)), nil
-
-	case StdDev:
-		// Calculate standard deviation
-		if len(values) < 2 {
-			return 0, nil
-		}
-		mean := 0.0
-		for _, v := range values {
-			mean += v
-		}
-		mean /= float64(len(values))
-
-		variance := 0.0
-		for _, v := range values {
-			diff := v - mean
-			variance += diff * diff
-		}
-		variance /= float64(len(values))
-
-		return math.Sqrt(variance), nil
-
-	case StdVar:
-		// Calculate variance
-		if len(values) < 2 {
-			return 0, nil
-		}
-		mean := 0.0
-		for _, v := range values {
-			mean += v
-		}
-		mean /= float64(len(values))
-
-		variance := 0.0
-		for _, v := range values {
-			diff := v - mean
-			variance += diff * diff
-		}
-		variance /= float64(len(values))
-
-		return variance, nil
-
-	default:
-		return 0, fmt.Errorf("unsupported aggregation function: %s", fn)
+		g.aggregators[g.lastIndex].add(sample.Value, variance)
 	}
 }
 
-// Rate calculates the per-second rate of increase over a time range.
-// This is commonly used for counters that only increase.
-//
-// rate(v[5m]) calculates the per-second rate of increase averaged over 5 minutes.
-func (qe *QueryEngine) Rate(q *Query, rangeSeconds int64) (*QueryResult, error) {
-	if rangeSeconds <= 0 {
-		return nil, fmt.Errorf("range must be positive")
+// addSummary folds the samples summary describes into their bucket, and
+// reports false, adding nothing, unless they all fall in one bucket within
+// the query range
+func (g *aggregationGroup) addSummary(summary storage.ChunkSummary, aq *AggregationQuery) bool {
+	q := aq.Query
+	first, last := summary.FirstTime+q.Offset, summary.LastTime+q.Offset
+	if first < q.MinTime || last > q.MaxTime {
+		return false
 	}
-
-	// Execute base query
-	result, err := qe.ExecQuery(q)
-	if err != nil {
-		return nil, err
+	bucketTime, bucketEnd := aq.Align.Bucket(first, aq.Step, q.MinTime)
+	if last >= bucketEnd {
+		return false
 	}
 
-	// Calculate rate for each series
-	rateResult := &QueryResult{
-		Series: make([]TimeSeries, 0, len(result.Series)),
+	i, ok := g.index[bucketTime]
+	if !ok {
+		i = len(g.times)
+		g.index[bucketTime] = i
+		g.times = append(g.times, bucketTime)
+		g.aggregators = append(g.aggregators, newAggregator())
 	}
+	g.aggregators[i].merge(summary)
+	return true
+}
 
-	for _, ts := range result.Series {
-		if len(ts.Samples) < 2 {
-			continue // Need at least 2 samples
-		}
-
-		rateSamples := make([]series.Sample, 0, len(ts.Samples)-1)
-
-		for i := 1; i < len(ts.Samples); i++ {
-			prev := ts.Samples[i-1]
-			curr := ts.Samples[i]
-
-			timeDiff := float64(curr.Timestamp-prev.Timestamp) / 1000.0 // Convert ms to seconds
-			if timeDiff <= 0 {
-				continue
-			}
-
-			valueDiff := curr.Value - prev.Value
+// samples returns the value of fn in each bucket, ordered by time
+func (g *aggregationGroup) samples(fn AggregateFunc) []series.Sample {
+	result := make([]series.Sample, len(g.times))
+	for i, t := range g.times {
+		result[i] = series.Sample{Timestamp: t, Value: g.aggregators[i].value(fn)}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Timestamp < result[j].Timestamp
+	})
+	return result
+}
 
-			// Handle counter resets (value decreases)
-			if valueDiff < 0 {
-				valueDiff = curr.Value // Assume reset to 0
-			}
+// aggregator accumulates values in constant space, for any AggregateFunc
+type aggregator struct {
+	count    int64
+	sum      float64
+	min, max float64
 
-			rate := valueDiff / timeDiff
+	// Running mean and sum of squared deviations from it (Welford's
+	// algorithm), kept only when the variance is needed
+	mean, m2 float64
+}
 
-			rateSamples = append(rateSamples, series.Sample{
-				Timestamp: curr.Timestamp,
-				Value:     rate,
-			})
-		}
+func newAggregator() aggregator {
+	return aggregator{min: math.Inf(1), max: math.Inf(-1)}
+}
 
-		if len(rateSamples) > 0 {
-			rateResult.Series = append(rateResult.Series, TimeSeries{
-				Labels:  ts.Labels,
-				Samples: rateSamples,
-			})
-		}
+// add accumulates v, updating the variance if asked to
+func (a *aggregator) add(v float64, variance bool) {
+	a.count++
+	a.sum += v
+	if v > a.max {
+		a.max = v
+	}
+	if v < a.min {
+		a.min = v
+	}
+	if variance {
+		d := v - a.mean
+		a.mean += d / float64(a.count)
+		a.m2 += d * (v - a.mean)
 	}
-
-	return rateResult, nil
 }
 
-// Increase calculates the total increase over a time range.
-// This is commonly used for counters.
-//
-// increase(v[5m]) calculates the total increase over 5 minutes.
-func (qe *QueryEngine) Increase(q *Query) (*QueryResult, error) {
-	// Execute base query
-	result, err := qe.ExecQuery(q)
-	if err != nil {
-		return nil, err
+// merge accumulates the values summary describes; it doesn't keep the
+// variance
+func (a *aggregator) merge(summary storage.ChunkSummary) {
+	a.count += int64(summary.Count)
+	a.sum += summary.Sum
+	if summary.Max > a.max {
+		a.max = summary.Max
 	}
-
-	// Calculate increase for each series
-	increaseResult := &QueryResult{
-		Series: make([]TimeSeries, 0, len(result.Series)),
+	if summary.Min < a.min {
+		a.min = summary.Min
 	}
+}
 
-	for _, ts := range result.Series {
-		if len(ts.Samples) < 2 {
-			continue // Need at least 2 samples
-		}
-
-		first := ts.Samples[0]
-		last := ts.Samples[len(ts.Samples)-1]
-
-		increase := last.Value - first.Value
+// value returns fn over the values accumulated, which must be a supported
+// function
+func (a *aggregator) value(fn AggregateFunc) float64 {
+	if a.count == 0 {
+		return 0
+	}
 
-		// Handle counter resets
-		if increase < 0 {
-			increase = last.Value // Assume reset
+	switch fn {
+	case Sum:
+		return a.sum
+	case Avg:
+		return a.sum / float64(a.count)
+	case Max:
+		return a.max
+	case Min:
+		return a.min
+	case Count, CountValues:
+		return float64(a.count)
+	case Group:
+		return 1
+	case StdDev, StdVar:
+		if a.count < 2 {
+			return 0
 		}
-
-		increaseSamples := []series.Sample{
-			{
-				Timestamp: last.Timestamp,
-				Value:     increase,
-			},
+		variance := a.m2 / float64(a.count)
+		if fn == StdDev {
+			return math.Sqrt(variance)
 		}
-
-		increaseResult.Series = append(increaseResult.Series, TimeSeries{
-			Labels:  ts.Labels,
-			Samples: increaseSamples,
-		})
+		return variance
+	default:
+		return 0
 	}
-
-	return increaseResult, nil
 }
 
-// Delta calculates the difference between the first and last value.
-// Unlike increase, it can be negative.
-func (qe *QueryEngine) Delta(q *Query) (*QueryResult, error) {
-	// Execute base query
-	result, err := qe.ExecQuery(q)
-	if err != nil {
-		return nil, err
+// applyAggregation applies an aggregation function to a set of values.
+func applyAggregation(values []float64, fn AggregateFunc) (float64, error) {
+	if len(values) == 0 {
+		return 0, nil
 	}
-
-	// Calculate delta for each series
-	deltaResult := &QueryResult{
-		Series: make([]TimeSeries, 0, len(result.Series)),
+	if _, err := ParseAggregateFunc(string(fn)); err != nil {
+		return 0, err
 	}
 
-	for _, ts := range result.Series {
-		if len(ts.Samples) < 2 {
-			continue
-		}
-
-		first := ts.Samples[0]
-		last := ts.Samples[len(ts.Samples)-1]
-
-		delta := last.Value - first.Value
-
-		deltaSamples := []series.Sample{
-			{
-				Timestamp: last.Timestamp,
-				Value:     delta,
-			},
-		}
-
-		deltaResult.Series = append(deltaResult.Series, TimeSeries{
-			Labels:  ts.Labels,
-			Samples: deltaSamples,
-		})
+	a := newAggregator()
+	variance := fn == StdDev || fn == StdVar
+	for _, v := range values {
+		a.add(v, variance)
 	}
-
-	return deltaResult, nil
+	return a.value(fn), nil
 }
 
-// Derivative calculates the per-second derivative (rate of change).
-// Similar to rate() but doesn't handle counter resets.
-func (qe *QueryEngine) Derivative(q *Query) (*QueryResult, error) {
-	// Execute base query
-	result, err := qe.ExecQuery(q)
-	if err != nil {
-		return nil, err
+// Rate calculates the per-second rate of increase of counters at each
+// evaluation time of q over the rangeSeconds before it, like
+// rate(v[5m]). It is EvalRange with RangeRate.
+func (qe *QueryEngine) Rate(q *Query, rangeSeconds int64) (*QueryResult, error) {
+	if rangeSeconds <= 0 {
+		return nil, fmt.Errorf("range must be positive")
 	}
-
-	// Calculate derivative for each series
-	derivResult := &QueryResult{
-		Series: make([]TimeSeries, 0, len(result.Series)),
+	if rangeSeconds > math.MaxInt64/1000 {
+		return nil, fmt.Errorf("range too large: %ds", rangeSeconds)
 	}
+	return qe.EvalRange(q, RangeRate, rangeSeconds*1000)
+}
 
-	for _, ts := range result.Series {
-		if len(ts.Samples) < 2 {
-			continue
-		}
-
-		derivSamples := make([]series.Sample, 0, len(ts.Samples)-1)
-
-		for i := 1; i < len(ts.Samples); i++ {
-			prev := ts.Samples[i-1]
-			curr := ts.Samples[i]
-
-			timeDiff := float64(curr.Timestamp-prev.Timestamp) / 1000.0 // Convert ms to seconds
-			if timeDiff <= 0 {
-				continue
-			}
+// Increase calculates the total increase of counters over the whole query
+// range, counting a drop as a reset to 0. It is EvalRange with
+// RangeIncrease, evaluated once at q.MaxTime.
+func (qe *QueryEngine) Increase(q *Query) (*QueryResult, error) {
+	return qe.evalWholeRange(q, RangeIncrease)
+}
 
-			valueDiff := curr.Value - prev.Value
-			derivative := valueDiff / timeDiff
+// Delta calculates the difference between the last and first value over
+// the whole query range. Unlike increase, it can be negative.
+func (qe *QueryEngine) Delta(q *Query) (*QueryResult, error) {
+	return qe.evalWholeRange(q, RangeDelta)
+}
 
-			derivSamples = append(derivSamples, series.Sample{
-				Timestamp: curr.Timestamp,
-				Value:     derivative,
-			})
-		}
+// Derivative calculates the per-second change between the first and last
+// value over the whole query range. Similar to rate() but doesn't handle
+// counter resets.
+func (qe *QueryEngine) Derivative(q *Query) (*QueryResult, error) {
+	return qe.evalWholeRange(q, RangeDerivative)
+}
 
-		if len(derivSamples) > 0 {
-			derivResult.Series = append(derivResult.Series, TimeSeries{
-				Labels:  ts.Labels,
-				Samples: derivSamples,
-			})
-		}
+// evalWholeRange evaluates fn once, at q.MaxTime over the samples in
+// [q.MinTime, q.MaxTime]
+func (qe *QueryEngine) evalWholeRange(q *Query, fn RangeFunc) (*QueryResult, error) {
+	if q == nil {
+		return nil, fmt.Errorf("query cannot be nil")
+	}
+	if q.MaxTime <= q.MinTime {
+		return nil, fmt.Errorf("end must be after start")
 	}
 
-	return derivResult, nil
+	// Unsigned, as the span of an unbounded range overflows int64
+	span := uint64(q.MaxTime) - uint64(q.MinTime)
+	if span > math.MaxInt64 {
+		span = math.MaxInt64
+	}
+	instant := *q
+	instant.Step = 0
+	return qe.EvalRange(&instant, fn, int64(span))
 }
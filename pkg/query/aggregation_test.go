@@ -1,9 +1,14 @@
 package query
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"math"
+	"reflect"
 	"testing"
 
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
 	"github.com/therealutkarshpriyadarshi/time/pkg/series"
 )
 
@@ -224,8 +229,222 @@ func TestQueryEngine_Aggregate(t *testing.T) {
 	}
 }
 
+func TestQueryEngine_AggregateStreams(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// 3 series of 2000 samples each, several batches per series
+	for host := 0; host < 3; host++ {
+		s := series.NewSeries(map[string]string{
+			"__name__": "http_requests",
+			"host":     fmt.Sprintf("server%d", host),
+			"region":   []string{"east", "west", "east"}[host],
+		})
+		samples := make([]series.Sample, 2000)
+		for i := range samples {
+			samples[i] = series.Sample{Timestamp: int64(i) * 1000, Value: float64(host + 1)}
+		}
+		if err := db.Insert(s, samples); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+
+	qe := NewQueryEngine(db)
+	aggregate := func(fn AggregateFunc, limits Limits) (*AggregationResult, error) {
+		return qe.Aggregate(&AggregationQuery{
+			Query:    &Query{MinTime: 0, MaxTime: 1_999_000, Limits: limits},
+			Function: fn,
+			Step:     1_000_000,
+			GroupBy:  []string{"region"},
+		})
+	}
+
+	result, err := aggregate(Sum, Limits{})
+	if err != nil {
+		t.Fatalf("aggregation failed: %v", err)
+	}
+	if len(result.Series) != 2 {
+		t.Fatalf("got %d groups, want 2", len(result.Series))
+	}
+	// east holds server0 and server2, 1000 samples each per bucket
+	east := result.Series[0]
+	if east.Labels["region"] != "east" || len(east.Samples) != 2 || east.Samples[0].Value != 4000 {
+		t.Errorf("east: got %v %v, want 2 buckets summing to 4000", east.Labels, east.Samples)
+	}
+
+	result, err = aggregate(StdDev, Limits{})
+	if err != nil {
+		t.Fatalf("aggregation failed: %v", err)
+	}
+	if got := result.Series[0].Samples[0].Value; math.Abs(got-1) > 1e-9 {
+		t.Errorf("east stddev %f, want 1", got)
+	}
+
+	if _, err := aggregate(Sum, Limits{MaxSamples: 3000}); !errors.Is(err, ErrTooManySamples) {
+		t.Errorf("got %v, want ErrTooManySamples", err)
+	}
+	if _, err := aggregate(Sum, Limits{MaxSeries: 2}); !errors.Is(err, ErrTooManySeries) {
+		t.Errorf("got %v, want ErrTooManySeries", err)
+	}
+
+	// Truncation keeps the earliest samples within the limit
+	result, err = aggregate(Count, Limits{MaxSamples: 2500, Truncate: true})
+	if err != nil {
+		t.Fatalf("truncated aggregation failed: %v", err)
+	}
+	var total float64
+	for _, ts := range result.Series {
+		for _, sample := range ts.Samples {
+			total += sample.Value
+		}
+	}
+	if total != 2500 {
+		t.Errorf("truncated aggregation counted %v samples, want 2500", total)
+	}
+
+	if _, err := aggregate("median", Limits{}); err == nil {
+		t.Error("unsupported function accepted")
+	}
+}
+
+func TestQueryEngine_AggregateChunkSummaries(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Two flushed chunks of 1..5, one also with a sample in the head
+	for _, host := range []string{"a", "b"} {
+		s := series.NewSeries(map[string]string{"__name__": "queue_depth", "host": host})
+		var samples []series.Sample
+		for i := int64(1); i <= 5; i++ {
+			samples = append(samples, series.Sample{Timestamp: i * 1000, Value: float64(i)})
+		}
+		if err := db.Insert(s, samples); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	b := series.NewSeries(map[string]string{"__name__": "queue_depth", "host": "b"})
+	if err := db.Insert(b, []series.Sample{{Timestamp: 12_000, Value: 6}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	aggregate := func(fn AggregateFunc, step, offset int64) []series.Sample {
+		t.Helper()
+		result, err := NewQueryEngine(db).Aggregate(&AggregationQuery{
+			Query:    &Query{MinTime: offset, MaxTime: offset + 19_999, Offset: offset},
+			Function: fn,
+			Step:     step,
+		})
+		if err != nil {
+			t.Fatalf("aggregation failed: %v", err)
+		}
+		if len(result.Series) != 1 {
+			t.Fatalf("got %d groups, want 1", len(result.Series))
+		}
+		return result.Series[0].Samples
+	}
+
+	// Whole chunks in one bucket are aggregated from their summaries
+	for fn, want := range map[AggregateFunc]float64{Sum: 30, Avg: 3, Min: 1, Max: 5, Count: 10} {
+		last := 6.0
+		if fn == Count {
+			last = 1
+		}
+		got := aggregate(fn, 10_000, 0)
+		if len(got) != 2 || got[0].Value != want || got[1].Value != last {
+			t.Errorf("%s: got %v, want %v then %v", fn, got, want, last)
+		}
+	}
+	if got := aggregate(Sum, 10_000, 100_000); len(got) != 2 || got[0] != (series.Sample{Timestamp: 100_000, Value: 30}) {
+		t.Errorf("offset sum: got %v, want 30 at 100000", got)
+	}
+	// Chunks spanning buckets are decoded
+	if got := aggregate(Sum, 3000, 0); len(got) != 3 || got[0].Value != 6 || got[1].Value != 24 {
+		t.Errorf("3s sum: got %v, want 6, 24 and 6", got)
+	}
+	if got := aggregate(StdDev, 10_000, 0); math.Abs(got[0].Value-math.Sqrt2) > 1e-9 {
+		t.Errorf("stddev: got %v, want sqrt(2)", got[0].Value)
+	}
+}
+
+func TestQueryEngine_AggregateCountValues(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Flushed chunks, which count_values can't take from their summaries
+	for host, values := range map[string][]float64{"a": {1, 1, 0}, "b": {1, 1, 1}} {
+		s := series.NewSeries(map[string]string{"__name__": "up", "host": host})
+		var samples []series.Sample
+		for i, v := range values {
+			samples = append(samples, series.Sample{Timestamp: int64(i+1) * 1000, Value: v})
+		}
+		if err := db.Insert(s, samples); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	qe := NewQueryEngine(db)
+	aggregate := func(fn AggregateFunc, valueLabel string, groupBy ...string) (*AggregationResult, error) {
+		return qe.Aggregate(&AggregationQuery{
+			Query:      &Query{MinTime: 0, MaxTime: 9999},
+			Function:   fn,
+			Step:       10_000,
+			GroupBy:    groupBy,
+			ValueLabel: valueLabel,
+		})
+	}
+
+	result, err := aggregate(CountValues, "state")
+	if err != nil {
+		t.Fatalf("count_values failed: %v", err)
+	}
+	got := make(map[string]float64)
+	for _, ts := range result.Series {
+		got[series.NewSeries(ts.Labels).String()] = ts.Samples[0].Value
+	}
+	want := map[string]float64{`{state="0"}`: 1, `{state="1"}`: 5}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("count_values: got %v, want %v", got, want)
+	}
+
+	result, err = aggregate(CountValues, "state", "host")
+	if err != nil {
+		t.Fatalf("count_values by host failed: %v", err)
+	}
+	if len(result.Series) != 3 || result.Series[0].Labels["host"] != "a" || result.Series[0].Labels["state"] != "0" {
+		t.Errorf("count_values by host: got %v, want a/0, a/1 and b/1", result.Series)
+	}
+
+	result, err = aggregate(Group, "", "host")
+	if err != nil {
+		t.Fatalf("group failed: %v", err)
+	}
+	if len(result.Series) != 2 {
+		t.Fatalf("group: got %d series, want one per host", len(result.Series))
+	}
+	for _, ts := range result.Series {
+		if len(ts.Samples) != 1 || ts.Samples[0].Value != 1 {
+			t.Errorf("group %v: got %v, want 1", ts.Labels, ts.Samples)
+		}
+	}
+
+	if _, err := aggregate(CountValues, ""); err == nil {
+		t.Error("count_values without a value label accepted")
+	}
+	if _, err := aggregate(CountValues, "1state"); err == nil {
+		t.Error("count_values with an invalid value label accepted")
+	}
+	if _, err := aggregate(Sum, "state"); err == nil {
+		t.Error("value label accepted for sum")
+	}
+}
+
 func TestQueryEngine_Rate(t *testing.T) {
-	t.Skip("Skipping - requires series enumeration")
 	db := setupTestDB(t)
 	defer db.Close()
 
@@ -248,12 +467,15 @@ func TestQueryEngine_Rate(t *testing.T) {
 
 	qe := NewQueryEngine(db)
 
+	// Rate over the second before each step, spanning two samples
 	q := &Query{
-		MinTime: 1000,
-		MaxTime: 5000,
+		Matchers: index.Matchers{index.MustNewMatcher(index.MatchEqual, "__name__", "http_requests_total")},
+		MinTime:  2000,
+		MaxTime:  4000,
+		Step:     1000,
 	}
 
-	result, err := qe.Rate(q, 5)
+	result, err := qe.Rate(q, 1)
 	if err != nil {
 		t.Fatalf("rate calculation failed: %v", err)
 	}
@@ -263,7 +485,7 @@ func TestQueryEngine_Rate(t *testing.T) {
 	}
 
 	rateSamples := result.Series[0].Samples
-	if len(rateSamples) != 3 { // 4 samples = 3 rate calculations
+	if len(rateSamples) != 3 { // One per step
 		t.Errorf("expected 3 rate samples, got %d", len(rateSamples))
 	}
 
@@ -281,7 +503,6 @@ func TestQueryEngine_Rate(t *testing.T) {
 }
 
 func TestQueryEngine_Rate_CounterReset(t *testing.T) {
-	t.Skip("Skipping - requires series enumeration")
 	db := setupTestDB(t)
 	defer db.Close()
 
@@ -304,12 +525,15 @@ func TestQueryEngine_Rate_CounterReset(t *testing.T) {
 
 	qe := NewQueryEngine(db)
 
+	// Rate over the second before each step, spanning two samples
 	q := &Query{
-		MinTime: 1000,
-		MaxTime: 5000,
+		Matchers: index.Matchers{index.MustNewMatcher(index.MatchEqual, "__name__", "counter")},
+		MinTime:  2000,
+		MaxTime:  4000,
+		Step:     1000,
 	}
 
-	result, err := qe.Rate(q, 5)
+	result, err := qe.Rate(q, 1)
 	if err != nil {
 		t.Fatalf("rate calculation failed: %v", err)
 	}
@@ -321,6 +545,9 @@ func TestQueryEngine_Rate_CounterReset(t *testing.T) {
 
 	rateSamples := result.Series[0].Samples
 	// After reset, rate should be based on new value (10/1s = 10)
+	if rateSamples[1].Value != 10 {
+		t.Errorf("expected rate 10 across the reset, got %f", rateSamples[1].Value)
+	}
 	for i, sample := range rateSamples {
 		if sample.Value < 0 {
 			t.Errorf("sample %d: rate should not be negative: %f", i, sample.Value)
@@ -329,7 +556,6 @@ func TestQueryEngine_Rate_CounterReset(t *testing.T) {
 }
 
 func TestQueryEngine_Increase(t *testing.T) {
-	t.Skip("Skipping - requires series enumeration")
 	db := setupTestDB(t)
 	defer db.Close()
 
@@ -351,8 +577,9 @@ func TestQueryEngine_Increase(t *testing.T) {
 	qe := NewQueryEngine(db)
 
 	q := &Query{
-		MinTime: 1000,
-		MaxTime: 5000,
+		Matchers: index.Matchers{index.MustNewMatcher(index.MatchEqual, "__name__", "http_requests_total")},
+		MinTime:  1000,
+		MaxTime:  5000,
 	}
 
 	result, err := qe.Increase(q)
@@ -377,7 +604,6 @@ func TestQueryEngine_Increase(t *testing.T) {
 }
 
 func TestQueryEngine_Delta(t *testing.T) {
-	t.Skip("Skipping - requires series enumeration")
 	db := setupTestDB(t)
 	defer db.Close()
 
@@ -399,8 +625,9 @@ func TestQueryEngine_Delta(t *testing.T) {
 	qe := NewQueryEngine(db)
 
 	q := &Query{
-		MinTime: 1000,
-		MaxTime: 5000,
+		Matchers: index.Matchers{index.MustNewMatcher(index.MatchEqual, "__name__", "temperature")},
+		MinTime:  1000,
+		MaxTime:  5000,
 	}
 
 	result, err := qe.Delta(q)
@@ -425,7 +652,6 @@ func TestQueryEngine_Delta(t *testing.T) {
 }
 
 func TestQueryEngine_Derivative(t *testing.T) {
-	t.Skip("Skipping - requires series enumeration")
 	db := setupTestDB(t)
 	defer db.Close()
 
@@ -448,8 +674,9 @@ func TestQueryEngine_Derivative(t *testing.T) {
 	qe := NewQueryEngine(db)
 
 	q := &Query{
-		MinTime: 1000,
-		MaxTime: 5000,
+		Matchers: index.Matchers{index.MustNewMatcher(index.MatchEqual, "__name__", "gauge")},
+		MinTime:  1000,
+		MaxTime:  5000,
 	}
 
 	result, err := qe.Derivative(q)
@@ -462,20 +689,17 @@ func TestQueryEngine_Derivative(t *testing.T) {
 	}
 
 	derivSamples := result.Series[0].Samples
-	if len(derivSamples) != 3 {
-		t.Errorf("expected 3 derivative samples, got %d", len(derivSamples))
+	if len(derivSamples) != 1 {
+		t.Fatalf("expected 1 derivative sample, got %d", len(derivSamples))
 	}
 
-	// Verify derivative values
-	expectedDerivs := []float64{10.0, 5.0, -5.0}
-	for i, expected := range expectedDerivs {
-		if i >= len(derivSamples) {
-			break
-		}
-		if math.Abs(derivSamples[i].Value-expected) > 0.01 {
-			t.Errorf("sample %d: expected derivative %f, got %f",
-				i, expected, derivSamples[i].Value)
-		}
+	// From 10 to 20 over 3s, ignoring the rise and fall between
+	expected := 10.0 / 3
+	if math.Abs(derivSamples[0].Value-expected) > 0.01 {
+		t.Errorf("expected derivative %f, got %f", expected, derivSamples[0].Value)
+	}
+	if derivSamples[0].Timestamp != 5000 {
+		t.Errorf("expected derivative at the end of the range, got %d", derivSamples[0].Timestamp)
 	}
 }
 
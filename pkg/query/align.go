@@ -0,0 +1,130 @@
+package query
+
+import (
+	"fmt"
+	"time"
+)
+
+// AlignMode chooses where the step buckets of an aggregation begin
+type AlignMode string
+
+const (
+	// AlignEpoch starts buckets at multiples of the step since the Unix
+	// epoch, so 1h buckets start on the hour in UTC. This is the default.
+	AlignEpoch AlignMode = "epoch"
+
+	// AlignStart starts buckets at the query's MinTime, so a query from
+	// 10:20 with 1h steps has buckets starting at 10:20, 11:20 and so on
+	AlignStart AlignMode = "start"
+
+	// AlignCalendar starts buckets at calendar boundaries in a time zone:
+	// steps dividing a day start at local midnight and every step after
+	// it, steps of whole days at local midnight. Buckets spanning a
+	// daylight saving change are an hour shorter or longer.
+	AlignCalendar AlignMode = "calendar"
+)
+
+// day is the length of a calendar day without daylight saving changes
+const day = 24 * time.Hour
+
+// Alignment decides which bucket of a step aggregation a timestamp falls in
+type Alignment struct {
+	// Mode is where buckets begin; empty means AlignEpoch
+	Mode AlignMode
+
+	// Location is the time zone of AlignCalendar; nil means UTC
+	Location *time.Location
+}
+
+// ParseAlignment parses an alignment mode and, for AlignCalendar, an IANA
+// time zone name such as "Europe/Berlin" (empty means UTC)
+func ParseAlignment(mode, tz string) (Alignment, error) {
+	a := Alignment{Mode: AlignMode(mode)}
+	switch a.Mode {
+	case "", AlignEpoch, AlignStart:
+		if tz != "" {
+			return Alignment{}, fmt.Errorf("a time zone needs calendar alignment")
+		}
+	case AlignCalendar:
+		if tz != "" {
+			loc, err := time.LoadLocation(tz)
+			if err != nil {
+				return Alignment{}, fmt.Errorf("unknown time zone %q", tz)
+			}
+			a.Location = loc
+		}
+	default:
+		return Alignment{}, fmt.Errorf("unknown alignment %q (want epoch, start or calendar)", mode)
+	}
+	return a, nil
+}
+
+// Validate checks that the alignment can bucket by step (milliseconds).
+// Calendar alignment needs a step that divides a day or is a whole number
+// of days.
+func (a Alignment) Validate(step int64) error {
+	if step <= 0 {
+		return fmt.Errorf("step must be positive")
+	}
+	if a.Mode != AlignCalendar {
+		return nil
+	}
+	dayMs := day.Milliseconds()
+	if dayMs%step != 0 && step%dayMs != 0 {
+		return fmt.Errorf("calendar alignment needs a step that divides a day or is a whole number of days, got %s",
+			time.Duration(step)*time.Millisecond)
+	}
+	return nil
+}
+
+// Bucket returns the bounds [start, end) of the bucket of ts for buckets
+// step milliseconds long, where queryStart is where an AlignStart query
+// begins. The step must pass Validate.
+func (a Alignment) Bucket(ts, step, queryStart int64) (int64, int64) {
+	switch a.Mode {
+	case AlignStart:
+		start := queryStart + floorDiv(ts-queryStart, step)*step
+		return start, start + step
+	case AlignCalendar:
+		return a.calendarBucket(ts, step)
+	default:
+		start := floorDiv(ts, step) * step
+		return start, start + step
+	}
+}
+
+// calendarBucket is Bucket for AlignCalendar
+func (a Alignment) calendarBucket(ts, step int64) (int64, int64) {
+	loc := a.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	y, m, d := time.UnixMilli(ts).In(loc).Date()
+
+	dayMs := day.Milliseconds()
+	if step >= dayMs {
+		// Count days on the local calendar, so a bucket of n days always
+		// starts at local midnight
+		days := step / dayMs
+		since := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / int64(day.Seconds())
+		first := floorDiv(since, days) * days
+		start := time.Date(1970, 1, 1+int(first), 0, 0, 0, 0, loc)
+		end := time.Date(1970, 1, 1+int(first+days), 0, 0, 0, 0, loc)
+		return start.UnixMilli(), end.UnixMilli()
+	}
+
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, loc).UnixMilli()
+	next := time.Date(y, m, d+1, 0, 0, 0, 0, loc).UnixMilli()
+	start := midnight + floorDiv(ts-midnight, step)*step
+	return start, min(start+step, next)
+}
+
+// floorDiv divides rounding towards negative infinity, so timestamps
+// before the epoch fall in the bucket below them
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,150 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestAlignmentBucket(t *testing.T) {
+	hour := time.Hour.Milliseconds()
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	utc := func(y int, m time.Month, d, h, min int) int64 {
+		return time.Date(y, m, d, h, min, 0, 0, time.UTC).UnixMilli()
+	}
+
+	tests := []struct {
+		name               string
+		align              Alignment
+		ts, step, start    int64
+		wantStart, wantEnd int64
+	}{
+		{"epoch", Alignment{}, 5400000, hour, 0, hour, 2 * hour},
+		{"epoch before 1970", Alignment{}, -1, hour, 0, -hour, 0},
+		{"query start", Alignment{Mode: AlignStart}, 5400000, hour, 1200000, 4800000, 4800000 + hour},
+		{
+			"calendar hours at a half-hour offset",
+			Alignment{Mode: AlignCalendar, Location: kolkata},
+			utc(2021, 6, 1, 10, 0), hour, 0,
+			utc(2021, 6, 1, 9, 30), utc(2021, 6, 1, 10, 30),
+		},
+		{
+			"calendar day",
+			Alignment{Mode: AlignCalendar, Location: kolkata},
+			utc(2021, 6, 1, 20, 0), 24 * hour, 0,
+			utc(2021, 6, 1, 18, 30), utc(2021, 6, 2, 18, 30),
+		},
+		{
+			"calendar day clocks go back",
+			Alignment{Mode: AlignCalendar, Location: newYork},
+			utc(2021, 11, 7, 12, 0), 24 * hour, 0,
+			utc(2021, 11, 7, 4, 0), utc(2021, 11, 8, 5, 0),
+		},
+		{
+			"calendar hours end at midnight",
+			Alignment{Mode: AlignCalendar, Location: newYork},
+			utc(2021, 3, 15, 3, 30), 6 * hour, 0,
+			utc(2021, 3, 14, 23, 0), utc(2021, 3, 15, 4, 0),
+		},
+		{
+			"calendar days in UTC",
+			Alignment{Mode: AlignCalendar},
+			utc(1970, 1, 4, 1, 0), 2 * 24 * hour, 0,
+			utc(1970, 1, 3, 0, 0), utc(1970, 1, 5, 0, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := tt.align.Bucket(tt.ts, tt.step, tt.start)
+			if start != tt.wantStart || end != tt.wantEnd {
+				t.Errorf("Bucket() = [%d, %d), want [%d, %d)", start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestParseAlignment(t *testing.T) {
+	if a, err := ParseAlignment("calendar", "Europe/Berlin"); err != nil || a.Location == nil || a.Location.String() != "Europe/Berlin" {
+		t.Errorf("ParseAlignment(calendar, Europe/Berlin) = %+v, %v", a, err)
+	}
+	for _, tt := range [][2]string{{"weekly", ""}, {"epoch", "Europe/Berlin"}, {"calendar", "Nowhere/Special"}} {
+		if _, err := ParseAlignment(tt[0], tt[1]); err == nil {
+			t.Errorf("ParseAlignment(%q, %q) expected error", tt[0], tt[1])
+		}
+	}
+
+	calendar := Alignment{Mode: AlignCalendar}
+	for step, valid := range map[time.Duration]bool{
+		15 * time.Minute:   true,
+		24 * time.Hour:     true,
+		7 * 24 * time.Hour: true,
+		7 * time.Hour:      false,
+		36 * time.Hour:     false,
+	} {
+		if err := calendar.Validate(step.Milliseconds()); (err == nil) != valid {
+			t.Errorf("Validate(%s) = %v, want valid %v", step, err, valid)
+		}
+	}
+}
+
+func TestAggregateCalendarAligned(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Hourly samples over two UTC days
+	base := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	s := series.NewSeries(map[string]string{"__name__": "requests"})
+	var samples []series.Sample
+	for h := int64(0); h < 48; h++ {
+		samples = append(samples, series.Sample{Timestamp: base + h*time.Hour.Milliseconds(), Value: 1})
+	}
+	if err := db.Insert(s, samples); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	qe := NewQueryEngine(db)
+	result, err := qe.Aggregate(&AggregationQuery{
+		Query:    &Query{Matchers: index.Matchers{index.MustNewMatcher(index.MatchEqual, "__name__", "requests")}, MinTime: base, MaxTime: base + 48*time.Hour.Milliseconds()},
+		Function: Count,
+		Step:     (24 * time.Hour).Milliseconds(),
+		Align:    Alignment{Mode: AlignCalendar, Location: kolkata},
+	})
+	if err != nil {
+		t.Fatalf("aggregation failed: %v", err)
+	}
+
+	// Local days start at 18:30 UTC, splitting the samples 19/24/5
+	midnight := time.Date(2021, 6, 1, 0, 0, 0, 0, kolkata).UnixMilli()
+	day := (24 * time.Hour).Milliseconds()
+	want := []series.Sample{{Timestamp: midnight, Value: 19}, {Timestamp: midnight + day, Value: 24}, {Timestamp: midnight + 2*day, Value: 5}}
+	if len(result.Series) != 1 || len(result.Series[0].Samples) != len(want) {
+		t.Fatalf("got %+v, want %v", result.Series, want)
+	}
+	for i, got := range result.Series[0].Samples {
+		if got != want[i] {
+			t.Errorf("bucket %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	if _, err := qe.Aggregate(&AggregationQuery{
+		Query:    &Query{MinTime: base, MaxTime: base + day},
+		Function: Count,
+		Step:     (7 * time.Hour).Milliseconds(),
+		Align:    Alignment{Mode: AlignCalendar},
+	}); err == nil {
+		t.Error("calendar alignment accepted a 7h step")
+	}
+}
@@ -2,9 +2,14 @@ package query
 
 import (
 	"fmt"
+	"math"
+	"runtime"
+	"slices"
 	"sort"
 	"sync"
+	"time"
 
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
 	"github.com/therealutkarshpriyadarshi/time/pkg/index"
 	"github.com/therealutkarshpriyadarshi/time/pkg/series"
 	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
@@ -21,45 +26,554 @@ type Query struct {
 
 	// Step for range queries (0 for instant queries)
 	Step int64
+
+	// Offset evaluates the query this many milliseconds in the past, like
+	// PromQL's offset modifier: data is read from [MinTime-Offset,
+	// MaxTime-Offset] and returned with its timestamps moved forward by
+	// Offset, so it lines up with unshifted data (e.g. for week-over-week
+	// comparisons). It must not be negative.
+	Offset int64
+
+	// Lookback is how far back, in milliseconds, a query evaluated at a
+	// single time looks for samples, so series scraped less often than
+	// queries are made still show up: an instant query (Step 0) returns
+	// the newest sample of each series in [MaxTime-Lookback, MaxTime], and
+	// Absent without a range looks back this far from each evaluation
+	// time. 0 only matches samples at the evaluation time itself. It must
+	// not be negative.
+	Lookback int64
+
+	// Limits bounds how much data the query may select
+	Limits Limits
+
+	// BlockMatchers restricts the query to blocks whose labels match, such
+	// as {tenant="a"}; nil reads every block
+	BlockMatchers index.Matchers
+
+	// Trace, if set, records the blocks and chunks the query reads
+	Trace *storage.Trace
+}
+
+var (
+	// ErrTooManySeries is returned when a query selects more series than
+	// its MaxSeries limit
+	ErrTooManySeries = tsdberrors.New(tsdberrors.ResourceExhausted, "query selects too many series")
+
+	// ErrTooManySamples is returned when a query selects more samples than
+	// its MaxSamples limit
+	ErrTooManySamples = tsdberrors.New(tsdberrors.ResourceExhausted, "query selects too many samples")
+)
+
+// Limits bounds the series and samples a query may select, guarding against
+// selectors such as {__name__=~".+"} that match everything. Zero fields are
+// unbounded.
+type Limits struct {
+	// MaxSeries is the maximum number of series selected
+	MaxSeries int
+
+	// MaxSamples is the maximum number of samples selected across all
+	// series, counted before step alignment
+	MaxSamples int
+
+	// Truncate returns the series and samples within the limits, with a
+	// warning, instead of failing the query
+	Truncate bool
+}
+
+// Merge returns the limits with each bound lowered to the one in other,
+// so a request can tighten but never loosen a server default. Truncate is
+// taken from other.
+func (l Limits) Merge(other Limits) Limits {
+	return Limits{
+		MaxSeries:  minLimit(l.MaxSeries, other.MaxSeries),
+		MaxSamples: minLimit(l.MaxSamples, other.MaxSamples),
+		Truncate:   other.Truncate,
+	}
+}
+
+// minLimit returns the smaller of two limits, where 0 is unbounded
+func minLimit(a, b int) int {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// seriesError is the error of a query matching more than MaxSeries series
+func (l Limits) seriesError() error {
+	return fmt.Errorf("%w: more than %d series matched", ErrTooManySeries, l.MaxSeries)
+}
+
+// samplesError is the error of a query selecting more than MaxSamples samples
+func (l Limits) samplesError() error {
+	return fmt.Errorf("%w: limit is %d", ErrTooManySamples, l.MaxSamples)
+}
+
+// DefaultShardMinRange is the shortest range a query must span to be split
+// into shards when sharding is enabled
+const DefaultShardMinRange = 24 * time.Hour
+
+// seriesWarning is the warning of a result truncated to MaxSeries series
+func (l Limits) seriesWarning() string {
+	return fmt.Sprintf("result truncated to %d matching series", l.MaxSeries)
+}
+
+// samplesWarning is the warning of a result truncated to MaxSamples samples
+func (l Limits) samplesWarning() string {
+	return fmt.Sprintf("result truncated to %d samples", l.MaxSamples)
 }
 
 // QueryEngine executes queries against the TSDB.
 type QueryEngine struct {
 	db *storage.TSDB
+
+	// Series whose samples a query reads at once; see SetConcurrency
+	concurrency int
+
+	// Time sharding of long queries; see SetSharding
+	shards        int
+	shardMinRange time.Duration
 }
 
-// NewQueryEngine creates a new query engine.
+// NewQueryEngine creates a new query engine reading as many series at once
+// as there are CPUs.
 func NewQueryEngine(db *storage.TSDB) *QueryEngine {
 	return &QueryEngine{db: db}
 }
 
+// SetConcurrency sets how many series a query reads and decodes in
+// parallel. 0 means runtime.GOMAXPROCS(0), and 1 reads them one at a time.
+// Results are returned in the same order whatever the concurrency.
+func (qe *QueryEngine) SetConcurrency(n int) {
+	qe.concurrency = n
+}
+
+// SetSharding splits queries spanning at least minRange into up to shards
+// sub-ranges, each read concurrently by its own querier over the blocks
+// overlapping it, and stitches their results back together. Results are
+// the same as those of an unsharded query, limits included. shards <= 1
+// disables sharding, and minRange 0 means DefaultShardMinRange.
+func (qe *QueryEngine) SetSharding(shards int, minRange time.Duration) {
+	if minRange <= 0 {
+		minRange = DefaultShardMinRange
+	}
+	qe.shards = shards
+	qe.shardMinRange = minRange
+}
+
+// workers returns the number of series read in parallel
+func (qe *QueryEngine) workers() int {
+	if qe.concurrency <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return qe.concurrency
+}
+
 // Select executes a query and returns series iterators.
 // The query is executed across both in-memory MemTables and disk blocks.
 //
 // Query execution plan:
-// 1. Use label matchers to filter series (if provided)
-// 2. For each series hash that has been seen, query the TSDB
-// 3. TSDB.Query automatically merges data from:
-//    - Active MemTable
+// 1. Open a storage.Querier over the time range, which leases the blocks
+//    overlapping it
+// 2. Use label matchers to select series from the head and block indexes
+// 3. For each matching series, read in parallel by a bounded pool of
+//    workers (see SetConcurrency), the querier merges samples from:
+//    - Disk blocks, in ULID order
 //    - Flushing MemTable (if exists)
-//    - Disk blocks (future enhancement)
-// 4. Return iterators for all matching series
+//    - Active MemTable
+//    keeping the last write for timestamps found in more than one
+// 4. Return iterators for all matching series, sorted by label set
+//    (see series.CompareLabels), so results are stable between calls
 //
-// Note: This is a simplified implementation for Phase 5.
-// Full index integration and block querying will be enhanced in future phases.
+// The time range is clamped to the oldest retained sample, so queries for
+// data removed by retention return without touching storage. Long ranges
+// may be split into shards read concurrently (see SetSharding).
 func (qe *QueryEngine) Select(q *Query) ([]SeriesIterator, error) {
+	iterators, _, err := qe.selectRetained(q)
+	return iterators, err
+}
+
+// selectRetained executes Select and also returns warnings about the
+// portion of the range that has been removed by retention.
+func (qe *QueryEngine) selectRetained(q *Query) ([]SeriesIterator, []string, error) {
 	if q == nil {
-		return nil, fmt.Errorf("query cannot be nil")
+		return nil, nil, fmt.Errorf("query cannot be nil")
+	}
+	if q.Offset < 0 {
+		return nil, nil, fmt.Errorf("offset must not be negative")
+	}
+
+	offset := q.Offset
+	q, warnings, purged := qe.retentionBounds(q.window())
+	if purged {
+		return []SeriesIterator{}, warnings, nil
+	}
+
+	var iterators []SeriesIterator
+	if ranges := qe.shardRanges(q.MinTime, q.MaxTime); len(ranges) > 1 {
+		var shardWarnings []string
+		var err error
+		iterators, shardWarnings, err = qe.selectSharded(q, ranges)
+		if err != nil {
+			return nil, nil, err
+		}
+		warnings = append(warnings, shardWarnings...)
+	} else {
+		set, skipped, err := qe.selectLimited(q)
+		if err != nil {
+			return nil, nil, err
+		}
+		iterators = set.iterators
+		warnings = append(append(warnings, set.warnings...), skipped...)
+	}
+
+	for _, it := range iterators {
+		it.(*sliceIterator).offset = offset
+	}
+	return iterators, warnings, nil
+}
+
+// window returns q with its range moved Offset into the past, the range
+// its data is read from. The range is clamped rather than wrapping around
+// at the smallest timestamp.
+func (q *Query) window() *Query {
+	if q.Offset == 0 {
+		return q
+	}
+	shifted := *q
+	shifted.MinTime = shiftBack(q.MinTime, q.Offset)
+	shifted.MaxTime = shiftBack(q.MaxTime, q.Offset)
+	shifted.Offset = 0
+	return &shifted
+}
+
+// shiftBack returns t-offset, clamped to math.MinInt64
+func shiftBack(t, offset int64) int64 {
+	if t < math.MinInt64+offset {
+		return math.MinInt64
+	}
+	return t - offset
+}
+
+// selectLimited reads the series matching q within its range and limits.
+// It also returns the querier's warnings about block data it skipped.
+func (qe *QueryEngine) selectLimited(q *Query) (*limitedSet, []string, error) {
+	querier, err := qe.db.TracedQuerier(q.MinTime, q.MaxTime, q.BlockMatchers, q.Trace)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer querier.Close()
+
+	matched, err := querier.Series(q.Matchers...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	set := &limitedSet{limits: q.Limits, iterators: make([]SeriesIterator, 0)}
+	err = qe.readSeries(querier, matched, set.add)
+	if err == nil {
+		err = set.err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return set, querier.Warnings(), nil
+}
+
+// limitedSet collects series in label order until they exceed its limits
+type limitedSet struct {
+	limits    Limits
+	iterators []SeriesIterator
+	total     int // Samples collected
+	warnings  []string
+	err       error // Set when a limit is exceeded without Truncate
+}
+
+// add collects the samples of a series, keeping only those within the
+// limits, and reports whether more series may follow. Series without
+// samples are skipped.
+func (ls *limitedSet) add(s *series.Series, samples []series.Sample) bool {
+	limits := ls.limits
+	if len(samples) == 0 {
+		return true
+	}
+	if limits.MaxSeries > 0 && len(ls.iterators) == limits.MaxSeries {
+		if !limits.Truncate {
+			ls.err = limits.seriesError()
+			return false
+		}
+		ls.warnings = append(ls.warnings, limits.seriesWarning())
+		return false
+	}
+
+	ls.total += len(samples)
+	if limits.MaxSamples > 0 && ls.total > limits.MaxSamples {
+		if !limits.Truncate {
+			ls.err = limits.samplesError()
+			return false
+		}
+		// Keep the earliest samples of this series that still fit
+		samples = samples[:len(samples)-(ls.total-limits.MaxSamples)]
+		ls.warnings = append(ls.warnings, limits.samplesWarning())
+		if len(samples) > 0 {
+			ls.iterators = append(ls.iterators, &sliceIterator{series: s, samples: samples, idx: -1})
+		}
+		return false
+	}
+
+	ls.iterators = append(ls.iterators, &sliceIterator{
+		series:  s,
+		samples: samples,
+		idx:     -1,
+	})
+	return true
+}
+
+// exceed records the limit the next series would exceed, for when more
+// series are known to follow those collected
+func (ls *limitedSet) exceed() {
+	if ls.limits.MaxSeries > 0 && len(ls.iterators) == ls.limits.MaxSeries {
+		ls.warnings = append(ls.warnings, ls.limits.seriesWarning())
+	} else if ls.limits.MaxSamples > 0 {
+		ls.warnings = append(ls.warnings, ls.limits.samplesWarning())
+	}
+}
+
+// shardRanges splits [minTime, maxTime] into the sub-ranges of a sharded
+// query, or returns it whole if it is too short to shard. The split stops
+// at the current time, so a range reaching into the future doesn't leave
+// shards with nothing to read; the last shard runs to maxTime.
+func (qe *QueryEngine) shardRanges(minTime, maxTime int64) [][2]int64 {
+	whole := [][2]int64{{minTime, maxTime}}
+	end := min(maxTime, time.Now().UnixMilli())
+	if qe.shards <= 1 || end <= minTime {
+		return whole
+	}
+	// Unsigned, as the span of an unbounded range overflows int64
+	span := uint64(end) - uint64(minTime) + 1
+	if span < uint64(qe.shardMinRange.Milliseconds()) {
+		return whole
 	}
 
-	// For Phase 5, we return an empty iterator list
-	// In a full implementation, we would:
-	// 1. Use the inverted index to find series matching the matchers
-	// 2. Query each series from TSDB
-	// 3. Return iterators
-	//
-	// For now, callers should use ExecQuery which returns all series
-	return make([]SeriesIterator, 0), nil
+	width := int64((span + uint64(qe.shards) - 1) / uint64(qe.shards))
+	ranges := make([][2]int64, 0, qe.shards)
+	for start := minTime; len(ranges) < qe.shards; start += width {
+		if len(ranges) == qe.shards-1 || end-start < width {
+			ranges = append(ranges, [2]int64{start, maxTime})
+			break
+		}
+		ranges = append(ranges, [2]int64{start, start + width - 1})
+	}
+	return ranges
+}
+
+// selectSharded reads each of the sub-ranges of q concurrently and stitches
+// the series found in them back together in label order. Each shard is held
+// to the limits of q: the samples one shard keeps are never more than the
+// whole query would keep from it, so applying the limits again to the
+// stitched series gives the result of an unsharded query.
+func (qe *QueryEngine) selectSharded(q *Query, ranges [][2]int64) ([]SeriesIterator, []string, error) {
+	shards := make([]*limitedSet, len(ranges))
+	skipped := make([][]string, len(ranges))
+	errs := make([]error, len(ranges))
+	var wg sync.WaitGroup
+	for i, r := range ranges {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sub := *q
+			sub.MinTime, sub.MaxTime = r[0], r[1]
+			shards[i], skipped[i], errs[i] = qe.selectLimited(&sub)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Shards are disjoint and in time order, so a series' samples are
+	// stitched by appending them shard after shard
+	type stitched struct {
+		series  *series.Series
+		parts   [][]series.Sample
+		samples int
+	}
+	byHash := make(map[uint64]*stitched)
+	var all []*stitched
+	truncated := false
+	for _, shard := range shards {
+		truncated = truncated || len(shard.warnings) > 0
+		for _, it := range shard.iterators {
+			si := it.(*sliceIterator)
+			st, ok := byHash[si.series.Hash]
+			if !ok {
+				st = &stitched{series: si.series}
+				byHash[si.series.Hash] = st
+				all = append(all, st)
+			}
+			st.parts = append(st.parts, si.samples)
+			st.samples += len(si.samples)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return series.CompareLabels(all[i].series.Labels, all[j].series.Labels) < 0
+	})
+
+	set := &limitedSet{limits: q.Limits, iterators: make([]SeriesIterator, 0, len(all))}
+	complete := true
+	for _, st := range all {
+		samples := st.parts[0]
+		if len(st.parts) > 1 {
+			samples = make([]series.Sample, 0, st.samples)
+			for _, part := range st.parts {
+				samples = append(samples, part...)
+			}
+		}
+		if !set.add(st.series, samples) {
+			complete = false
+			break
+		}
+	}
+	if complete && truncated {
+		// The shards stopped exactly at the limits, leaving out data
+		// beyond them
+		set.exceed()
+	}
+	if set.err != nil {
+		return nil, nil, set.err
+	}
+
+	// A block overlapping several shards may be skipped by each
+	warnings := set.warnings
+	for _, shardSkipped := range skipped {
+		for _, warning := range shardSkipped {
+			if !slices.Contains(warnings, warning) {
+				warnings = append(warnings, warning)
+			}
+		}
+	}
+	return set.iterators, warnings, nil
+}
+
+// readSeries reads the samples of matched with a pool of up to
+// qe.workers() goroutines and passes them to fn in the order of matched.
+// Workers stay a bounded number of series ahead of fn, so a query stopped
+// by its limits doesn't read every series first. Reading stops when fn
+// returns false or at the first error.
+func (qe *QueryEngine) readSeries(querier storage.BatchQuerier, matched []*series.Series, fn func(*series.Series, []series.Sample) bool) error {
+	workers := min(qe.workers(), len(matched))
+	if workers <= 1 {
+		for _, s := range matched {
+			samples, err := querier.Samples(s)
+			if err != nil {
+				return fmt.Errorf("failed to query series %s: %w", s, err)
+			}
+			if !fn(s, samples) {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	type result struct {
+		samples []series.Sample
+		err     error
+	}
+	results := make([]chan result, len(matched))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+
+	// ahead holds a token for each series handed out but not yet passed
+	// to fn
+	ahead := make(chan struct{}, 2*workers)
+	next := make(chan int)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	wg.Add(1 + workers)
+	go func() {
+		defer wg.Done()
+		defer close(next)
+		for i := range matched {
+			select {
+			case ahead <- struct{}{}:
+			case <-stop:
+				return
+			}
+			select {
+			case next <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				samples, err := querier.Samples(matched[i])
+				results[i] <- result{samples, err}
+			}
+		}()
+	}
+
+	for i, s := range matched {
+		r := <-results[i]
+		<-ahead
+		if r.err != nil {
+			return fmt.Errorf("failed to query series %s: %w", s, r.err)
+		}
+		if !fn(s, r.samples) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// retentionBounds clamps the query range to the oldest retained sample.
+// It returns the clamped query, a warning if retention removed data the
+// range asked for, and whether the whole range predates retained data.
+func (qe *QueryEngine) retentionBounds(q *Query) (*Query, []string, bool) {
+	purgedBefore := qe.db.PurgedBefore()
+	minTime, ok := qe.db.MinTime()
+
+	if !ok {
+		// Nothing retained at all
+		if purgedBefore > q.MinTime {
+			return q, []string{purgedWarning(purgedBefore)}, true
+		}
+		return q, nil, false
+	}
+
+	if q.MinTime >= minTime {
+		return q, nil, false
+	}
+
+	var warnings []string
+	if purgedBefore > q.MinTime {
+		warnings = append(warnings, purgedWarning(minTime))
+	}
+	if q.MaxTime < minTime {
+		return q, warnings, true
+	}
+
+	clamped := *q
+	clamped.MinTime = minTime
+	return &clamped, warnings, false
+}
+
+// purgedWarning formats the warning attached to queries reaching into purged data
+func purgedWarning(before int64) string {
+	return fmt.Sprintf("data before %s has been removed by retention",
+		time.UnixMilli(before).UTC().Format(time.RFC3339))
 }
 
 // SeriesIterator allows iterating over samples in a time series.
@@ -87,6 +601,7 @@ type sliceIterator struct {
 	samples []series.Sample
 	idx     int
 	err     error
+	offset  int64 // Added to timestamps, see Query.Offset
 }
 
 func (it *sliceIterator) Next() bool {
@@ -99,7 +614,7 @@ func (it *sliceIterator) At() (int64, float64) {
 		return 0, 0
 	}
 	s := it.samples[it.idx]
-	return s.Timestamp, s.Value
+	return s.Timestamp + it.offset, s.Value
 }
 
 func (it *sliceIterator) Err() error {
@@ -286,6 +801,10 @@ func (it *mergeIterator) Close() error {
 // QueryResult represents the result of a query.
 type QueryResult struct {
 	Series []TimeSeries
+
+	// Warnings are non-fatal notes about the result, such as part of the
+	// requested range having been removed by retention
+	Warnings []string
 }
 
 // TimeSeries represents a single time series with its samples.
@@ -296,14 +815,30 @@ type TimeSeries struct {
 
 // ExecQuery executes a query and returns all results materialized in memory.
 // This is a convenience method that collects all samples from iterators.
+// An instant query with a Lookback returns the newest sample of each series
+// within it.
 func (qe *QueryEngine) ExecQuery(q *Query) (*QueryResult, error) {
-	iterators, err := qe.Select(q)
+	if q == nil {
+		return nil, fmt.Errorf("query cannot be nil")
+	}
+	if q.Lookback < 0 {
+		return nil, fmt.Errorf("lookback must not be negative")
+	}
+	newest := q.Step == 0 && q.Lookback > 0
+	if newest {
+		window := *q
+		window.MinTime = min(q.MinTime, shiftBack(q.MaxTime, q.Lookback))
+		q = &window
+	}
+
+	iterators, warnings, err := qe.selectRetained(q)
 	if err != nil {
 		return nil, err
 	}
 
 	result := &QueryResult{
-		Series: make([]TimeSeries, 0, len(iterators)),
+		Series:   make([]TimeSeries, 0, len(iterators)),
+		Warnings: warnings,
 	}
 
 	for _, iter := range iterators {
@@ -327,6 +862,9 @@ func (qe *QueryEngine) ExecQuery(q *Query) (*QueryResult, error) {
 
 		iter.Close()
 
+		if newest && len(ts.Samples) > 1 {
+			ts.Samples = ts.Samples[len(ts.Samples)-1:]
+		}
 		if len(ts.Samples) > 0 {
 			result.Series = append(result.Series, ts)
 		}
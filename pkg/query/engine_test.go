@@ -1,7 +1,14 @@
 package query
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/therealutkarshpriyadarshi/time/pkg/series"
 	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
@@ -13,8 +20,11 @@ func setupTestDB(t *testing.T) *storage.TSDB {
 	// Create temporary directory
 	tmpDir := t.TempDir()
 
-	// Open TSDB
-	db, err := storage.Open(storage.DefaultOptions(tmpDir))
+	// Open TSDB, without retention: tests flush samples from 1970, whose
+	// blocks it would delete
+	opts := storage.DefaultOptions(tmpDir)
+	opts.EnableRetention = false
+	db, err := storage.Open(opts)
 	if err != nil {
 		t.Fatalf("failed to open TSDB: %v", err)
 	}
@@ -439,3 +449,399 @@ func BenchmarkMergeIterator(b *testing.B) {
 		}
 	}
 }
+
+func TestQueryEngine_RetentionBounds(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UnixMilli()
+	oldTime := now - 48*time.Hour.Milliseconds()
+
+	block, err := storage.NewBlock(oldTime, oldTime)
+	if err != nil {
+		t.Fatalf("failed to create block: %v", err)
+	}
+	old := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "old"})
+	if err := block.AddSeries(old, []series.Sample{{Timestamp: oldTime, Value: 1}}); err != nil {
+		t.Fatalf("failed to add series: %v", err)
+	}
+	if err := block.Persist(dir); err != nil {
+		t.Fatalf("failed to persist block: %v", err)
+	}
+
+	db, err := storage.Open(storage.DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	headTime := now - time.Hour.Milliseconds()
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "server1"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: headTime, Value: 0.5}}); err != nil {
+		t.Fatalf("failed to insert samples: %v", err)
+	}
+
+	qe := NewQueryEngine(db)
+
+	// Nothing purged yet: no warning
+	result, err := qe.ExecQuery(&Query{MinTime: 0, MaxTime: now})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(result.Warnings) != 0 {
+		t.Errorf("unexpected warnings before retention: %v", result.Warnings)
+	}
+
+	if err := db.SetRetentionPolicy(storage.RetentionPolicy{MaxAge: 24 * time.Hour, Enabled: true}); err != nil {
+		t.Fatalf("failed to set retention policy: %v", err)
+	}
+	if err := db.TriggerRetention(); err != nil {
+		t.Fatalf("retention failed: %v", err)
+	}
+
+	// Range reaching into purged data is clamped and warned about
+	result, err = qe.ExecQuery(&Query{MinTime: 0, MaxTime: now})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(result.Series) != 1 {
+		t.Fatalf("expected 1 series, got %d", len(result.Series))
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "has been removed") {
+		t.Errorf("expected a purged data warning, got %v", result.Warnings)
+	}
+
+	// Range entirely before retained data returns nothing, with the warning
+	result, err = qe.ExecQuery(&Query{MinTime: 0, MaxTime: oldTime + 1})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(result.Series) != 0 || len(result.Warnings) != 1 {
+		t.Errorf("expected no series and 1 warning, got %d series, warnings %v", len(result.Series), result.Warnings)
+	}
+
+	// Range within retained data is unaffected
+	result, err = qe.ExecQuery(&Query{MinTime: headTime, MaxTime: now})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(result.Warnings) != 0 {
+		t.Errorf("unexpected warnings for retained range: %v", result.Warnings)
+	}
+}
+
+func TestQueryEngine_Limits(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for _, host := range []string{"a", "b", "c"} {
+		s := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": host})
+		samples := []series.Sample{{Timestamp: 3000, Value: 3}, {Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}}
+		if err := db.Insert(s, samples); err != nil {
+			t.Fatalf("failed to insert samples: %v", err)
+		}
+	}
+
+	qe := NewQueryEngine(db)
+	exec := func(limits Limits) (*QueryResult, error) {
+		return qe.ExecQuery(&Query{MinTime: 0, MaxTime: 10000, Limits: limits})
+	}
+
+	// Within the limits
+	result, err := exec(Limits{MaxSeries: 3, MaxSamples: 9})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(result.Series) != 3 || len(result.Warnings) != 0 {
+		t.Errorf("expected 3 series and no warnings, got %d series, warnings %v", len(result.Series), result.Warnings)
+	}
+
+	if _, err := exec(Limits{MaxSeries: 2}); !errors.Is(err, ErrTooManySeries) {
+		t.Errorf("expected ErrTooManySeries, got %v", err)
+	}
+	if _, err := exec(Limits{MaxSamples: 8}); !errors.Is(err, ErrTooManySamples) {
+		t.Errorf("expected ErrTooManySamples, got %v", err)
+	}
+
+	result, err = exec(Limits{MaxSeries: 2, Truncate: true})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(result.Series) != 2 || len(result.Warnings) != 1 {
+		t.Errorf("expected 2 series and 1 warning, got %d series, warnings %v", len(result.Series), result.Warnings)
+	}
+
+	// The series hitting the limit keeps its earliest samples
+	result, err = exec(Limits{MaxSamples: 5, Truncate: true})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(result.Series) != 2 || len(result.Warnings) != 1 {
+		t.Fatalf("expected 2 series and 1 warning, got %d series, warnings %v", len(result.Series), result.Warnings)
+	}
+	last := result.Series[1].Samples
+	if len(last) != 2 || last[0].Timestamp != 1000 || last[1].Timestamp != 2000 {
+		t.Errorf("expected the 2 earliest samples, got %v", last)
+	}
+}
+
+func TestQueryEngine_ParallelMatchesSerial(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Series spread over a block and the head, some in both
+	insert := func(from, to int, ts int64) {
+		for i := from; i < to; i++ {
+			s := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": fmt.Sprintf("h%02d", i)})
+			samples := []series.Sample{{Timestamp: ts + int64(i), Value: float64(i)}, {Timestamp: ts + 500, Value: 1}}
+			if err := db.Insert(s, samples); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+		}
+	}
+	insert(0, 40, 1000)
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	insert(20, 60, 2000)
+
+	serial, parallel := NewQueryEngine(db), NewQueryEngine(db)
+	serial.SetConcurrency(1)
+	parallel.SetConcurrency(8)
+
+	for _, limits := range []Limits{
+		{},
+		{MaxSeries: 25, Truncate: true},
+		{MaxSamples: 51, Truncate: true},
+		{MaxSeries: 25},
+	} {
+		q := &Query{MinTime: 0, MaxTime: 10000, Limits: limits}
+		want, wantErr := serial.ExecQuery(q)
+		for i := 0; i < 3; i++ {
+			got, err := parallel.ExecQuery(q)
+			if fmt.Sprint(err) != fmt.Sprint(wantErr) {
+				t.Fatalf("limits %+v: parallel error %v, serial error %v", limits, err, wantErr)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("limits %+v: parallel result differs from serial", limits)
+			}
+		}
+	}
+}
+
+func TestQueryEngine_Sharding(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Hourly samples over 3 days, with series coming and going
+	start := time.Now().Add(-72 * time.Hour).UnixMilli()
+	hour := time.Hour.Milliseconds()
+	for i := 0; i < 10; i++ {
+		s := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": fmt.Sprintf("h%d", i)})
+		var samples []series.Sample
+		for h := int64(i * 6); h < 72-int64(i); h++ {
+			samples = append(samples, series.Sample{Timestamp: start + h*hour, Value: float64(h)})
+		}
+		if err := db.Insert(s, samples); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+
+	whole, sharded := NewQueryEngine(db), NewQueryEngine(db)
+	sharded.SetSharding(4, time.Hour)
+
+	if ranges := sharded.shardRanges(start, math.MaxInt64); len(ranges) != 4 || ranges[3][1] != math.MaxInt64 {
+		t.Fatalf("got shards %v, want 4 ending at the end of the range", ranges)
+	}
+	if ranges := sharded.shardRanges(start, start+hour/2); len(ranges) != 1 {
+		t.Errorf("range shorter than the minimum split into %d shards", len(ranges))
+	}
+
+	for _, limits := range []Limits{
+		{},
+		{MaxSeries: 4, Truncate: true},
+		{MaxSamples: 150, Truncate: true},
+		{MaxSamples: 150},
+	} {
+		q := &Query{MinTime: start, MaxTime: math.MaxInt64, Limits: limits}
+		want, wantErr := whole.ExecQuery(q)
+		got, err := sharded.ExecQuery(q)
+		if fmt.Sprint(err) != fmt.Sprint(wantErr) {
+			t.Fatalf("limits %+v: sharded error %v, unsharded error %v", limits, err, wantErr)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("limits %+v: sharded result differs from unsharded", limits)
+		}
+	}
+}
+
+func TestExecQueryOrderedByLabels(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Inserted out of label order
+	for _, host := range []string{"web3", "db1", "web1", "app2"} {
+		s := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": host})
+		if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	mem := series.NewSeries(map[string]string{"__name__": "mem_usage", "host": "db1"})
+	if err := db.Insert(mem, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	qe := NewQueryEngine(db)
+	for i := 0; i < 3; i++ {
+		result, err := qe.ExecQuery(&Query{MinTime: 0, MaxTime: 2000})
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+
+		var got []string
+		for _, ts := range result.Series {
+			got = append(got, ts.Labels["__name__"]+"/"+ts.Labels["host"])
+		}
+		want := "cpu_usage/app2 cpu_usage/db1 cpu_usage/web1 cpu_usage/web3 mem_usage/db1"
+		if strings.Join(got, " ") != want {
+			t.Fatalf("got order %v, want %s", got, want)
+		}
+	}
+
+	aggregated, err := qe.Aggregate(&AggregationQuery{
+		Query:    &Query{MinTime: 0, MaxTime: 2000},
+		Function: Sum,
+		GroupBy:  []string{"host"},
+		Step:     1000,
+	})
+	if err != nil {
+		t.Fatalf("aggregation failed: %v", err)
+	}
+	var hosts []string
+	for _, ts := range aggregated.Series {
+		hosts = append(hosts, ts.Labels["host"])
+	}
+	if strings.Join(hosts, " ") != "app2 db1 web1 web3" {
+		t.Errorf("aggregation groups out of order: %v", hosts)
+	}
+}
+
+func TestLimitsMerge(t *testing.T) {
+	server := Limits{MaxSeries: 100, MaxSamples: 0}
+
+	got := server.Merge(Limits{MaxSeries: 500, MaxSamples: 1000, Truncate: true})
+	want := Limits{MaxSeries: 100, MaxSamples: 1000, Truncate: true}
+	if got != want {
+		t.Errorf("Merge() = %+v, want %+v", got, want)
+	}
+
+	if got := server.Merge(Limits{MaxSeries: 10}); got.MaxSeries != 10 {
+		t.Errorf("expected request to lower MaxSeries to 10, got %d", got.MaxSeries)
+	}
+}
+
+func TestQueryEngine_Offset(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	s := series.NewSeries(map[string]string{"__name__": "requests"})
+	var samples []series.Sample
+	for i := int64(1); i <= 10; i++ {
+		samples = append(samples, series.Sample{Timestamp: i * 1000, Value: float64(i)})
+	}
+	if err := db.Insert(s, samples); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	qe := NewQueryEngine(db)
+
+	// [11s, 13s] offset 10s reads [1s, 3s] and reports it at [11s, 13s]
+	result, err := qe.ExecQuery(&Query{MinTime: 11000, MaxTime: 13000, Offset: 10000})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	want := []series.Sample{{Timestamp: 11000, Value: 1}, {Timestamp: 12000, Value: 2}, {Timestamp: 13000, Value: 3}}
+	if len(result.Series) != 1 || !reflect.DeepEqual(result.Series[0].Samples, want) {
+		t.Fatalf("got %+v, want %v", result.Series, want)
+	}
+
+	iterators, err := qe.SelectRange(&Query{MinTime: 11000, MaxTime: 13000, Step: 2000, Offset: 10000})
+	if err != nil {
+		t.Fatalf("range query failed: %v", err)
+	}
+	var got []series.Sample
+	for iterators[0].Next() {
+		ts, v := iterators[0].At()
+		got = append(got, series.Sample{Timestamp: ts, Value: v})
+	}
+	if want := []series.Sample{{Timestamp: 11000, Value: 1}, {Timestamp: 13000, Value: 3}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("range query got %v, want %v", got, want)
+	}
+
+	aggregated, err := qe.Aggregate(&AggregationQuery{
+		Query:    &Query{MinTime: 12000, MaxTime: 13999, Offset: 10000},
+		Function: Sum,
+		Step:     2000,
+	})
+	if err != nil {
+		t.Fatalf("aggregation failed: %v", err)
+	}
+	if want := []series.Sample{{Timestamp: 12000, Value: 5}}; len(aggregated.Series) != 1 || !reflect.DeepEqual(aggregated.Series[0].Samples, want) {
+		t.Errorf("aggregation got %+v, want %v", aggregated.Series, want)
+	}
+
+	// An offset past the smallest timestamp reads nothing rather than
+	// wrapping around
+	result, err = qe.ExecQuery(&Query{MinTime: math.MinInt64 + 1, MaxTime: 0, Offset: 10000})
+	if err != nil || len(result.Series) != 0 {
+		t.Errorf("got %+v, %v, want no series", result, err)
+	}
+
+	if _, err := qe.ExecQuery(&Query{MinTime: 0, MaxTime: 1000, Offset: -1}); err == nil {
+		t.Error("negative offset accepted")
+	}
+}
+
+func TestQueryEngine_Lookback(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Scraped every 5 seconds
+	s := series.NewSeries(map[string]string{"__name__": "backup_size"})
+	samples := []series.Sample{{Timestamp: 5000, Value: 1}, {Timestamp: 10000, Value: 2}}
+	if err := db.Insert(s, samples); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	qe := NewQueryEngine(db)
+
+	tests := []struct {
+		name string
+		q    Query
+		want []series.Sample // nil if no series
+	}{
+		{"no lookback between samples", Query{MinTime: 12000, MaxTime: 12000}, nil},
+		{"newest within lookback", Query{MinTime: 12000, MaxTime: 12000, Lookback: 10000}, []series.Sample{{Timestamp: 10000, Value: 2}}},
+		{"past lookback", Query{MinTime: 16000, MaxTime: 16000, Lookback: 5000}, nil},
+		{"with offset", Query{MinTime: 17000, MaxTime: 17000, Offset: 10000, Lookback: 3000}, []series.Sample{{Timestamp: 15000, Value: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := qe.ExecQuery(&tt.q)
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			if tt.want == nil {
+				if len(result.Series) != 0 {
+					t.Errorf("got %+v, want no series", result.Series)
+				}
+				return
+			}
+			if len(result.Series) != 1 || !reflect.DeepEqual(result.Series[0].Samples, tt.want) {
+				t.Errorf("got %+v, want %v", result.Series, tt.want)
+			}
+		})
+	}
+
+	if _, err := qe.ExecQuery(&Query{MinTime: 0, MaxTime: 1000, Lookback: -1}); err == nil {
+		t.Error("negative lookback accepted")
+	}
+}
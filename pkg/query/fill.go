@@ -0,0 +1,106 @@
+package query
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// FillPolicy decides what an aggregation returns for buckets of a group
+// without samples
+type FillPolicy string
+
+const (
+	// FillNone leaves empty buckets out. This is the default.
+	FillNone FillPolicy = "none"
+
+	// FillNull returns NaN for empty buckets, so charts break the line
+	// instead of connecting the points either side of a gap
+	FillNull FillPolicy = "null"
+
+	// FillPrevious repeats the value of the last non-empty bucket
+	FillPrevious FillPolicy = "previous"
+
+	// FillLinear interpolates between the non-empty buckets either side
+	FillLinear FillPolicy = "linear"
+
+	// FillZero returns 0 for empty buckets
+	FillZero FillPolicy = "zero"
+)
+
+// ParseFillPolicy parses a fill policy name; empty means FillNone
+func ParseFillPolicy(s string) (FillPolicy, error) {
+	switch p := FillPolicy(s); p {
+	case "":
+		return FillNone, nil
+	case FillNone, FillNull, FillPrevious, FillLinear, FillZero:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown fill policy %q (want none, null, previous, linear or zero)", s)
+	}
+}
+
+// bucketTimes returns the start of every bucket of aq overlapping its
+// query range, in order
+func bucketTimes(aq *AggregationQuery) ([]int64, error) {
+	q := aq.Query
+	var times []int64
+	start, end := aq.Align.Bucket(q.MinTime, aq.Step, q.MinTime)
+	for start <= q.MaxTime {
+		if len(times) == MaxSteps {
+			return nil, fmt.Errorf("%w: limit is %d buckets", ErrTooManySteps, MaxSteps)
+		}
+		times = append(times, start)
+		if end <= start {
+			break // The last bucket before int64 wraps around
+		}
+		start, end = aq.Align.Bucket(end, aq.Step, q.MinTime)
+	}
+	return times, nil
+}
+
+// fill returns samples, one per non-empty bucket in time order, with the
+// empty buckets among times filled according to policy. Buckets that
+// previous and linear have no value for, before the first non-empty one
+// or after the last for linear, stay empty.
+func fill(samples []series.Sample, times []int64, policy FillPolicy) []series.Sample {
+	if policy == FillNone || policy == "" {
+		return samples
+	}
+
+	filled := make([]series.Sample, 0, len(times))
+	next := 0 // Index in samples of the next non-empty bucket
+	for _, t := range times {
+		for next < len(samples) && samples[next].Timestamp < t {
+			next++
+		}
+		if next < len(samples) && samples[next].Timestamp == t {
+			filled = append(filled, samples[next])
+			next++
+			continue
+		}
+
+		value, ok := 0.0, true
+		switch policy {
+		case FillNull:
+			value = math.NaN()
+		case FillPrevious:
+			ok = next > 0
+			if ok {
+				value = samples[next-1].Value
+			}
+		case FillLinear:
+			ok = next > 0 && next < len(samples)
+			if ok {
+				prev, after := samples[next-1], samples[next]
+				frac := float64(t-prev.Timestamp) / float64(after.Timestamp-prev.Timestamp)
+				value = prev.Value + frac*(after.Value-prev.Value)
+			}
+		}
+		if ok {
+			filled = append(filled, series.Sample{Timestamp: t, Value: value})
+		}
+	}
+	return filled
+}
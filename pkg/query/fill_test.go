@@ -0,0 +1,80 @@
+package query
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestAggregateFill(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Buckets of 1s from 0s to 6s; only 1s and 4s have samples
+	s := series.NewSeries(map[string]string{"__name__": "requests"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 10}, {Timestamp: 4000, Value: 40}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	qe := NewQueryEngine(db)
+	nan := math.NaN()
+	tests := []struct {
+		policy FillPolicy
+		want   []float64 // Values at 0s..6s; -1 means the bucket is left out
+	}{
+		{FillNone, []float64{-1, 10, -1, -1, 40, -1, -1}},
+		{FillNull, []float64{nan, 10, nan, nan, 40, nan, nan}},
+		{FillZero, []float64{0, 10, 0, 0, 40, 0, 0}},
+		{FillPrevious, []float64{-1, 10, 10, 10, 40, 40, 40}},
+		{FillLinear, []float64{-1, 10, 20, 30, 40, -1, -1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			result, err := qe.Aggregate(&AggregationQuery{
+				Query:    &Query{MinTime: 0, MaxTime: 6000},
+				Function: Sum,
+				Step:     1000,
+				Fill:     tt.policy,
+			})
+			if err != nil {
+				t.Fatalf("aggregation failed: %v", err)
+			}
+			if len(result.Series) != 1 {
+				t.Fatalf("got %d series, want 1", len(result.Series))
+			}
+
+			got := make(map[int64]float64)
+			for _, sample := range result.Series[0].Samples {
+				got[sample.Timestamp] = sample.Value
+			}
+			for i, want := range tt.want {
+				v, ok := got[int64(i)*1000]
+				switch {
+				case want == -1 && ok:
+					t.Errorf("bucket %ds = %v, want it left out", i, v)
+				case want == -1:
+				case !ok:
+					t.Errorf("bucket %ds missing, want %v", i, want)
+				case math.IsNaN(want) != math.IsNaN(v) || (!math.IsNaN(want) && v != want):
+					t.Errorf("bucket %ds = %v, want %v", i, v, want)
+				}
+			}
+		})
+	}
+
+	if _, err := ParseFillPolicy("spline"); err == nil {
+		t.Error("ParseFillPolicy accepted an unknown policy")
+	}
+	_, err := qe.Aggregate(&AggregationQuery{
+		Query:    &Query{MinTime: 0, MaxTime: MaxSteps * 1000},
+		Function: Sum,
+		Step:     1000,
+		Fill:     FillZero,
+	})
+	if !errors.Is(err, ErrTooManySteps) {
+		t.Errorf("got %v, want ErrTooManySteps", err)
+	}
+}
@@ -0,0 +1,303 @@
+package query
+
+import (
+	"fmt"
+	"slices"
+
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
+)
+
+// MaxSteps is the most evaluation steps a function may produce per series,
+// guarding against a tiny step over a long range
+const MaxSteps = 11000
+
+var (
+	// ErrTooManySteps is returned when a query's range and step give more
+	// than MaxSteps evaluation times
+	ErrTooManySteps = tsdberrors.New(tsdberrors.Invalid, "query has too many steps")
+
+	// ErrDuplicateLabelSet is returned when a function's result would hold
+	// several series with the same labels, such as rate over a selector
+	// matching several metrics once their names are dropped
+	ErrDuplicateLabelSet = tsdberrors.New(tsdberrors.Invalid, "vector cannot contain metrics with the same labelset")
+)
+
+// Absent reports when the series selected by q have no samples, like
+// PromQL's absent_over_time: at each evaluation time t (MaxTime for an
+// instant query, every Step from MinTime for a range query) it is absent
+// if no matching series has a sample in [t-rangeMs, t]. A rangeMs of 0
+// looks back q.Lookback instead, like absent().
+//
+// The result is empty if data is present at every evaluation time.
+// Otherwise it is a single series with value 1 at the times data is
+// absent, labelled from the equality matchers of q (see AbsentLabels), so
+// an alerting rule firing on it can tell which series disappeared.
+// Selectors that match nothing in the head and block indexes of the window
+// are answered without reading any chunks.
+func (qe *QueryEngine) Absent(q *Query, rangeMs int64) (*QueryResult, error) {
+	if q == nil {
+		return nil, fmt.Errorf("query cannot be nil")
+	}
+	if rangeMs < 0 {
+		return nil, fmt.Errorf("range must not be negative")
+	}
+	if q.Lookback < 0 {
+		return nil, fmt.Errorf("lookback must not be negative")
+	}
+	if rangeMs == 0 {
+		rangeMs = q.Lookback
+	}
+	times, err := evalTimes(q)
+	if err != nil {
+		return nil, err
+	}
+
+	window := *q
+	window.MinTime = shiftBack(q.MinTime, rangeMs)
+	iterators, warnings, err := qe.selectRetained(&window)
+	if err != nil {
+		return nil, err
+	}
+
+	var present []int64
+	for _, iter := range iterators {
+		for iter.Next() {
+			ts, _ := iter.At()
+			present = append(present, ts)
+		}
+		iter.Close()
+	}
+	slices.Sort(present)
+
+	var samples []series.Sample
+	for _, t := range times {
+		// The first sample at or after the start of t's window
+		i, _ := slices.BinarySearch(present, shiftBack(t, rangeMs))
+		if i == len(present) || present[i] > t {
+			samples = append(samples, series.Sample{Timestamp: t, Value: 1})
+		}
+	}
+
+	result := &QueryResult{Series: []TimeSeries{}, Warnings: warnings}
+	if len(samples) > 0 {
+		result.Series = append(result.Series, TimeSeries{
+			Labels:  AbsentLabels(q.Matchers),
+			Samples: samples,
+		})
+	}
+	return result, nil
+}
+
+// AbsentLabels returns the labels of Absent's result: those set by an
+// equality matcher, other than the metric name. A label with conflicting
+// equality matchers is left out.
+func AbsentLabels(matchers index.Matchers) map[string]string {
+	labels := make(map[string]string)
+	conflicting := make(map[string]bool)
+	for _, m := range matchers {
+		if m.Type != index.MatchEqual || m.Name == storage.MetricNameLabel {
+			continue
+		}
+		if v, ok := labels[m.Name]; ok && v != m.Value {
+			conflicting[m.Name] = true
+		}
+		labels[m.Name] = m.Value
+	}
+	for name := range conflicting {
+		delete(labels, name)
+	}
+	return labels
+}
+
+// Vector returns a series without labels holding value at each evaluation
+// time of q, like PromQL's vector(s). Combined with a selector client side,
+// vector(0) gives panels a zero line where the selector has no data.
+func (qe *QueryEngine) Vector(q *Query, value float64) (*QueryResult, error) {
+	if q == nil {
+		return nil, fmt.Errorf("query cannot be nil")
+	}
+	times, err := evalTimes(q)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]series.Sample, len(times))
+	for i, t := range times {
+		samples[i] = series.Sample{Timestamp: t, Value: value}
+	}
+	return &QueryResult{
+		Series: []TimeSeries{{Labels: map[string]string{}, Samples: samples}},
+	}, nil
+}
+
+// RangeFunc is a function of the samples of each series within a range
+// before every evaluation time, evaluated by EvalRange
+type RangeFunc string
+
+const (
+	// RangeRate is the per-second increase of a counter between the first
+	// and last sample in the range, counting a drop as a reset to 0
+	RangeRate RangeFunc = "rate"
+
+	// RangeIncrease is the increase of a counter between the first and
+	// last sample in the range, counting a drop as a reset to 0
+	RangeIncrease RangeFunc = "increase"
+
+	// RangeDelta is the difference between the last and first sample in
+	// the range, which may be negative
+	RangeDelta RangeFunc = "delta"
+
+	// RangeDerivative is the per-second change between the first and last
+	// sample in the range, without counter resets
+	RangeDerivative RangeFunc = "derivative"
+)
+
+// ParseRangeFunc parses a range function name
+func ParseRangeFunc(s string) (RangeFunc, error) {
+	switch fn := RangeFunc(s); fn {
+	case RangeRate, RangeIncrease, RangeDelta, RangeDerivative:
+		return fn, nil
+	default:
+		return "", fmt.Errorf("unsupported range function: %s", s)
+	}
+}
+
+// EvalRange evaluates fn for each series selected by q at each evaluation
+// time t (MaxTime for an instant query, every Step from MinTime for a
+// range query) over its samples in [t-rangeMs, t], like PromQL's rate()
+// and friends. A series has no value at times with fewer than two samples
+// in the range, and series without any value are left out.
+//
+// Values aren't extrapolated to the edges of the range: a rate is the
+// increase between the first and last sample divided by the time between
+// them. The metric name is dropped, since the values are no longer those
+// of the metric; a selector matching series that only differ by metric
+// name fails with ErrDuplicateLabelSet, as they couldn't be told apart.
+func (qe *QueryEngine) EvalRange(q *Query, fn RangeFunc, rangeMs int64) (*QueryResult, error) {
+	if q == nil {
+		return nil, fmt.Errorf("query cannot be nil")
+	}
+	if _, err := ParseRangeFunc(string(fn)); err != nil {
+		return nil, err
+	}
+	if rangeMs <= 0 {
+		return nil, fmt.Errorf("range must be positive")
+	}
+	times, err := evalTimes(q)
+	if err != nil {
+		return nil, err
+	}
+
+	window := *q
+	window.MinTime = shiftBack(q.MinTime, rangeMs)
+	iterators, warnings, err := qe.selectRetained(&window)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &QueryResult{Series: []TimeSeries{}, Warnings: warnings}
+	seen := make(map[string]bool)
+	var samples []series.Sample
+	var increases []float64
+	for _, iter := range iterators {
+		samples = samples[:0]
+		for iter.Next() {
+			ts, v := iter.At()
+			samples = append(samples, series.Sample{Timestamp: ts, Value: v})
+		}
+		err := iter.Err()
+		labels := iter.Labels()
+		iter.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		// Counter increase up to each sample, for any range in constant time
+		increases = increases[:0]
+		total := 0.0
+		for i, sample := range samples {
+			if i > 0 {
+				if d := sample.Value - samples[i-1].Value; d >= 0 {
+					total += d
+				} else {
+					total += sample.Value // Reset to 0
+				}
+			}
+			increases = append(increases, total)
+		}
+
+		var values []series.Sample
+		first, last := 0, 0
+		for _, t := range times {
+			for first < len(samples) && samples[first].Timestamp < shiftBack(t, rangeMs) {
+				first++
+			}
+			for last < len(samples) && samples[last].Timestamp <= t {
+				last++
+			}
+			// samples[first:last] are in the range
+			if last-first < 2 {
+				continue
+			}
+			a, b := samples[first], samples[last-1]
+			seconds := float64(b.Timestamp-a.Timestamp) / 1000
+			var v float64
+			switch fn {
+			case RangeRate:
+				v = (increases[last-1] - increases[first]) / seconds
+			case RangeIncrease:
+				v = increases[last-1] - increases[first]
+			case RangeDelta:
+				v = b.Value - a.Value
+			case RangeDerivative:
+				v = (b.Value - a.Value) / seconds
+			}
+			values = append(values, series.Sample{Timestamp: t, Value: v})
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		outLabels := make(map[string]string, len(labels))
+		for name, value := range labels {
+			if name != storage.MetricNameLabel {
+				outLabels[name] = value
+			}
+		}
+		key := series.NewSeries(outLabels).String()
+		if seen[key] {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateLabelSet, key)
+		}
+		seen[key] = true
+		result.Series = append(result.Series, TimeSeries{Labels: outLabels, Samples: values})
+	}
+	return result, nil
+}
+
+// evalTimes returns the times a function is evaluated at: MaxTime for an
+// instant query (Step 0), otherwise every Step from MinTime to MaxTime
+func evalTimes(q *Query) ([]int64, error) {
+	if q.Step < 0 {
+		return nil, fmt.Errorf("step must not be negative")
+	}
+	if q.MaxTime < q.MinTime {
+		return nil, fmt.Errorf("end must not be before start")
+	}
+	if q.Step == 0 {
+		return []int64{q.MaxTime}, nil
+	}
+
+	// Unsigned, as the span of an unbounded range overflows int64
+	n := (uint64(q.MaxTime)-uint64(q.MinTime))/uint64(q.Step) + 1
+	if n > MaxSteps {
+		return nil, fmt.Errorf("%w: %d steps, limit is %d", ErrTooManySteps, n, MaxSteps)
+	}
+	times := make([]int64, n)
+	for i := range times {
+		times[i] = q.MinTime + int64(i)*q.Step
+	}
+	return times, nil
+}
@@ -0,0 +1,188 @@
+package query
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestAbsent(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// up{job="api"} reports every second until 5s, then disappears
+	s := series.NewSeries(map[string]string{"__name__": "up", "job": "api"})
+	var samples []series.Sample
+	for i := int64(1); i <= 5; i++ {
+		samples = append(samples, series.Sample{Timestamp: i * 1000, Value: 1})
+	}
+	if err := db.Insert(s, samples); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	qe := NewQueryEngine(db)
+	up := index.Matchers{
+		index.MustNewMatcher(index.MatchEqual, "__name__", "up"),
+		index.MustNewMatcher(index.MatchEqual, "job", "api"),
+	}
+
+	tests := []struct {
+		name    string
+		q       Query
+		rangeMs int64
+		want    []series.Sample // nil if present throughout
+	}{
+		{"present at instant", Query{Matchers: up, MinTime: 3000, MaxTime: 3000}, 0, nil},
+		{"absent at instant", Query{Matchers: up, MinTime: 3500, MaxTime: 3500}, 0, []series.Sample{{Timestamp: 3500, Value: 1}}},
+		{"present over range", Query{Matchers: up, MinTime: 7000, MaxTime: 7000}, 2000, nil},
+		{"absent over range", Query{Matchers: up, MinTime: 8000, MaxTime: 8000}, 2000, []series.Sample{{Timestamp: 8000, Value: 1}}},
+		{
+			"range query",
+			Query{Matchers: up, MinTime: 4000, MaxTime: 10000, Step: 2000}, 2000,
+			[]series.Sample{{Timestamp: 8000, Value: 1}, {Timestamp: 10000, Value: 1}},
+		},
+		{"offset", Query{Matchers: up, MinTime: 13000, MaxTime: 13000, Offset: 10000}, 0, nil},
+		{"present within lookback", Query{Matchers: up, MinTime: 3500, MaxTime: 3500, Lookback: 1000}, 0, nil},
+		{"absent past lookback", Query{Matchers: up, MinTime: 7000, MaxTime: 7000, Lookback: 1000}, 0, []series.Sample{{Timestamp: 7000, Value: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := qe.Absent(&tt.q, tt.rangeMs)
+			if err != nil {
+				t.Fatalf("Absent failed: %v", err)
+			}
+			if tt.want == nil {
+				if len(result.Series) != 0 {
+					t.Errorf("got %+v, want no series", result.Series)
+				}
+				return
+			}
+			if len(result.Series) != 1 || !reflect.DeepEqual(result.Series[0].Samples, tt.want) {
+				t.Fatalf("got %+v, want %v", result.Series, tt.want)
+			}
+			if want := map[string]string{"job": "api"}; !reflect.DeepEqual(result.Series[0].Labels, want) {
+				t.Errorf("labels = %v, want %v", result.Series[0].Labels, want)
+			}
+		})
+	}
+
+	// A series never written is absent throughout
+	missing := index.Matchers{index.MustNewMatcher(index.MatchEqual, "__name__", "missing")}
+	result, err := qe.Absent(&Query{Matchers: missing, MinTime: 0, MaxTime: 4000, Step: 2000}, 0)
+	if err != nil || len(result.Series) != 1 || len(result.Series[0].Samples) != 3 {
+		t.Errorf("got %+v, %v, want 3 absent steps", result, err)
+	}
+
+	_, err = qe.Absent(&Query{Matchers: up, MinTime: 0, MaxTime: MaxSteps, Step: 1}, 0)
+	if !errors.Is(err, ErrTooManySteps) {
+		t.Errorf("got %v, want ErrTooManySteps", err)
+	}
+}
+
+func TestAbsentLabels(t *testing.T) {
+	matchers := index.Matchers{
+		index.MustNewMatcher(index.MatchEqual, "__name__", "up"),
+		index.MustNewMatcher(index.MatchEqual, "job", "api"),
+		index.MustNewMatcher(index.MatchRegexp, "instance", "a.*"),
+		index.MustNewMatcher(index.MatchEqual, "env", "prod"),
+		index.MustNewMatcher(index.MatchEqual, "env", "dev"),
+	}
+	if got, want := AbsentLabels(matchers), map[string]string{"job": "api"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("AbsentLabels() = %v, want %v", got, want)
+	}
+}
+
+func TestVector(t *testing.T) {
+	qe := NewQueryEngine(nil)
+	result, err := qe.Vector(&Query{MinTime: 0, MaxTime: 5000, Step: 2500}, 0)
+	if err != nil {
+		t.Fatalf("Vector failed: %v", err)
+	}
+	want := []series.Sample{{Timestamp: 0}, {Timestamp: 2500}, {Timestamp: 5000}}
+	if len(result.Series) != 1 || len(result.Series[0].Labels) != 0 || !reflect.DeepEqual(result.Series[0].Samples, want) {
+		t.Errorf("got %+v, want %v", result.Series, want)
+	}
+}
+
+func TestEvalRange(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// A counter at 2/s, reset after 6s, and a gauge dropping 1/s
+	counter := series.NewSeries(map[string]string{"__name__": "requests_total", "job": "api"})
+	gauge := series.NewSeries(map[string]string{"__name__": "temperature", "job": "api"})
+	for i := int64(0); i <= 10; i++ {
+		v := 2 * i
+		if i > 6 {
+			v -= 12
+		}
+		if err := db.Insert(counter, []series.Sample{{Timestamp: i * 1000, Value: float64(v)}}); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+		if err := db.Insert(gauge, []series.Sample{{Timestamp: i * 1000, Value: float64(100 - i)}}); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+
+	qe := NewQueryEngine(db)
+	selector := func(name string) index.Matchers {
+		return index.Matchers{index.MustNewMatcher(index.MatchEqual, "__name__", name)}
+	}
+
+	tests := []struct {
+		name    string
+		fn      RangeFunc
+		metric  string
+		q       Query
+		rangeMs int64
+		want    []series.Sample
+	}{
+		{"rate across reset", RangeRate, "requests_total", Query{MinTime: 10000, MaxTime: 10000}, 8000, []series.Sample{{Timestamp: 10000, Value: 2}}},
+		{"increase", RangeIncrease, "requests_total", Query{MinTime: 4000, MaxTime: 8000, Step: 4000}, 4000,
+			[]series.Sample{{Timestamp: 4000, Value: 8}, {Timestamp: 8000, Value: 8}}},
+		{"delta", RangeDelta, "temperature", Query{MinTime: 10000, MaxTime: 10000}, 5000, []series.Sample{{Timestamp: 10000, Value: -5}}},
+		{"derivative", RangeDerivative, "temperature", Query{MinTime: 10000, MaxTime: 10000}, 5000, []series.Sample{{Timestamp: 10000, Value: -1}}},
+		{"one sample in range", RangeRate, "requests_total", Query{MinTime: 10000, MaxTime: 10000}, 500, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := tt.q
+			q.Matchers = selector(tt.metric)
+			result, err := qe.EvalRange(&q, tt.fn, tt.rangeMs)
+			if err != nil {
+				t.Fatalf("EvalRange failed: %v", err)
+			}
+			if tt.want == nil {
+				if len(result.Series) != 0 {
+					t.Errorf("got %+v, want no series", result.Series)
+				}
+				return
+			}
+			if len(result.Series) != 1 {
+				t.Fatalf("got %d series, want 1", len(result.Series))
+			}
+			if want := map[string]string{"job": "api"}; !reflect.DeepEqual(result.Series[0].Labels, want) {
+				t.Errorf("labels = %v, want %v", result.Series[0].Labels, want)
+			}
+			if !reflect.DeepEqual(result.Series[0].Samples, tt.want) {
+				t.Errorf("got %v, want %v", result.Series[0].Samples, tt.want)
+			}
+		})
+	}
+
+	// Both metrics of the job are left with {job="api"}
+	job := index.Matchers{index.MustNewMatcher(index.MatchEqual, "job", "api")}
+	if _, err := qe.EvalRange(&Query{Matchers: job, MinTime: 10000, MaxTime: 10000}, RangeRate, 5000); !errors.Is(err, ErrDuplicateLabelSet) {
+		t.Errorf("rate over two metrics of a job: got %v, want ErrDuplicateLabelSet", err)
+	}
+
+	if _, err := qe.EvalRange(&Query{Matchers: selector("temperature")}, "irate", 1000); err == nil {
+		t.Error("unsupported function accepted")
+	}
+	if _, err := qe.EvalRange(&Query{Matchers: selector("temperature")}, RangeRate, 0); err == nil {
+		t.Error("zero range accepted")
+	}
+}
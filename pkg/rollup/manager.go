@@ -0,0 +1,425 @@
+package rollup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/query"
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
+)
+
+const (
+	// RulesFile is the file in the data directory holding rollup rules
+	RulesFile = "rollups.json"
+
+	// DefaultCheckInterval is how often rules are checked for completed windows
+	DefaultCheckInterval = 10 * time.Second
+
+	// DefaultDelay is how long after a window ends it is evaluated, to give
+	// late samples time to arrive
+	DefaultDelay = 30 * time.Second
+
+	// DefaultMaxCatchUp limits how far back missed windows are evaluated
+	// after downtime
+	DefaultMaxCatchUp = time.Hour
+)
+
+// Options configures the rollup manager
+type Options struct {
+	DataDir       string
+	CheckInterval time.Duration
+	Delay         time.Duration
+	MaxCatchUp    time.Duration
+}
+
+// DefaultOptions returns default rollup manager options
+func DefaultOptions(dataDir string) *Options {
+	return &Options{
+		DataDir:       dataDir,
+		CheckInterval: DefaultCheckInterval,
+		Delay:         DefaultDelay,
+		MaxCatchUp:    DefaultMaxCatchUp,
+	}
+}
+
+// RuleStatus reports a rule and its evaluation state
+type RuleStatus struct {
+	Rule
+
+	// NextWindow is the start (Unix ms) of the next window to evaluate
+	NextWindow int64
+
+	// LastEvaluation is when the rule last ran, successfully or not
+	LastEvaluation time.Time
+
+	// LastError is the error of the last evaluation, if it failed
+	LastError string
+
+	// SamplesWritten counts samples written since the manager started
+	SamplesWritten int64
+}
+
+// ruleState is a configured rule and its evaluation state
+type ruleState struct {
+	rule           *compiledRule
+	nextWindow     int64
+	lastEvaluation time.Time
+	lastError      string
+	samplesWritten atomic.Int64
+}
+
+// persistedRule is the on-disk form of a rule; the next window is kept so
+// that a restart neither skips nor repeats windows
+type persistedRule struct {
+	Rule
+	NextWindow int64 `json:"nextWindow"`
+}
+
+// Manager evaluates rollup rules in the background and persists them in the
+// data directory.
+type Manager struct {
+	db     *storage.TSDB
+	engine *query.QueryEngine
+
+	path          string
+	checkInterval time.Duration
+	delay         time.Duration
+	maxCatchUp    time.Duration
+
+	rules map[string]*ruleState
+	mu    sync.Mutex
+
+	// evalMu serializes evaluation passes
+	evalMu sync.Mutex
+
+	now func() time.Time
+
+	running atomic.Bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// NewManager creates a rollup manager writing into db. Call Load to read
+// persisted rules and Run to start evaluating them.
+func NewManager(db *storage.TSDB, opts *Options) *Manager {
+	if opts == nil {
+		opts = DefaultOptions("")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Manager{
+		db:            db,
+		engine:        query.NewQueryEngine(db),
+		path:          filepath.Join(opts.DataDir, RulesFile),
+		checkInterval: opts.CheckInterval,
+		delay:         opts.Delay,
+		maxCatchUp:    opts.MaxCatchUp,
+		rules:         make(map[string]*ruleState),
+		now:           time.Now,
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+// Load reads persisted rules from disk. A missing file is not an error.
+func (m *Manager) Load() error {
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read rollup rules: %w", err)
+	}
+
+	var persisted []persistedRule
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		return fmt.Errorf("failed to parse rollup rules: %w", err)
+	}
+
+	rules := make(map[string]*ruleState, len(persisted))
+	for _, p := range persisted {
+		rule, err := compile(p.Rule)
+		if err != nil {
+			return fmt.Errorf("rollup rule %q: %w", p.Name, err)
+		}
+		rules[rule.Name] = &ruleState{rule: rule, nextWindow: p.NextWindow}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rules = rules
+	return nil
+}
+
+// persist atomically writes the rules to disk. Callers must hold m.mu.
+func (m *Manager) persist() error {
+	persisted := make([]persistedRule, 0, len(m.rules))
+	for _, state := range m.sortedRules() {
+		persisted = append(persisted, persistedRule{Rule: state.rule.Rule, NextWindow: state.nextWindow})
+	}
+
+	data, err := json.MarshalIndent(persisted, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal rollup rules: %w", err)
+	}
+
+	tmpPath := m.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write rollup rules: %w", err)
+	}
+	if err := os.Rename(tmpPath, m.path); err != nil {
+		return fmt.Errorf("failed to rename rollup rules: %w", err)
+	}
+	return nil
+}
+
+// sortedRules returns the rules ordered by name. Callers must hold m.mu.
+func (m *Manager) sortedRules() []*ruleState {
+	states := make([]*ruleState, 0, len(m.rules))
+	for _, state := range m.rules {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].rule.Name < states[j].rule.Name
+	})
+	return states
+}
+
+// AddRule validates and adds a rule. Its first window is the one in progress,
+// so no history is backfilled.
+func (m *Manager) AddRule(rule Rule) error {
+	compiled, err := compile(rule)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rules[rule.Name]; ok {
+		return fmt.Errorf("%w: %s", ErrRuleExists, rule.Name)
+	}
+
+	start, _ := compiled.window(m.now().UnixMilli())
+	state := &ruleState{
+		rule:       compiled,
+		nextWindow: start,
+	}
+	m.rules[rule.Name] = state
+	if err := m.persist(); err != nil {
+		delete(m.rules, rule.Name)
+		return err
+	}
+
+	// Describe the output metric; rollups of counters are no longer counters
+	m.db.SetMetadata(rule.Name, storage.MetricMetadata{
+		Type: storage.MetricTypeGauge,
+		Help: fmt.Sprintf("%s of %s every %s", rule.Function, rule.Source, rule.Interval),
+	})
+	return nil
+}
+
+// RemoveRule removes a rule. Samples it already wrote are kept.
+func (m *Manager) RemoveRule(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.rules[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrRuleNotFound, name)
+	}
+
+	delete(m.rules, name)
+	if err := m.persist(); err != nil {
+		m.rules[name] = state
+		return err
+	}
+	return nil
+}
+
+// Rules returns the configured rules and their state, ordered by name
+func (m *Manager) Rules() []RuleStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	statuses := make([]RuleStatus, 0, len(m.rules))
+	for _, state := range m.sortedRules() {
+		statuses = append(statuses, RuleStatus{
+			Rule:           state.rule.Rule,
+			NextWindow:     state.nextWindow,
+			LastEvaluation: state.lastEvaluation,
+			LastError:      state.lastError,
+			SamplesWritten: state.samplesWritten.Load(),
+		})
+	}
+	return statuses
+}
+
+// Run starts the background evaluation loop
+func (m *Manager) Run() error {
+	if m.running.Swap(true) {
+		return fmt.Errorf("rollup manager already running")
+	}
+	defer m.running.Store(false)
+
+	ticker := time.NewTicker(m.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Evaluate(); err != nil {
+				fmt.Printf("rollup: %v\n", err)
+			}
+		case <-m.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Stop stops the evaluation loop
+func (m *Manager) Stop() error {
+	m.cancel()
+	return nil
+}
+
+// Evaluate runs every rule over the windows that have completed since it
+// last ran. A failing window is retried on the next call; the other rules
+// still run.
+func (m *Manager) Evaluate() error {
+	m.evalMu.Lock()
+	defer m.evalMu.Unlock()
+
+	m.mu.Lock()
+	states := m.sortedRules()
+	m.mu.Unlock()
+
+	now := m.now()
+	var errs []error
+	advanced := false
+	for _, state := range states {
+		n, err := m.evaluateRule(state, now)
+		if n > 0 {
+			advanced = true
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", state.rule.Name, err))
+		}
+	}
+
+	if advanced {
+		m.mu.Lock()
+		err := m.persist()
+		m.mu.Unlock()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// evaluateRule evaluates a rule's completed windows, returning how many
+// windows it advanced over
+func (m *Manager) evaluateRule(state *ruleState, now time.Time) (int, error) {
+	m.mu.Lock()
+	next := state.nextWindow
+	m.mu.Unlock()
+
+	cutoff := now.Add(-m.delay).UnixMilli()
+
+	// Skip windows too old to catch up on
+	if m.maxCatchUp > 0 {
+		oldest, _ := state.rule.window(now.Add(-m.maxCatchUp).UnixMilli())
+		if next < oldest {
+			next = oldest
+		}
+	}
+
+	var evalErr error
+	windows := 0
+	for {
+		// Calendar windows vary in length across daylight saving changes
+		_, end := state.rule.window(next)
+		if end > cutoff {
+			break
+		}
+		if evalErr = m.evaluateWindow(state, next, end); evalErr != nil {
+			break
+		}
+		next = end
+		windows++
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if next != state.nextWindow {
+		state.nextWindow = next
+		if windows == 0 {
+			// Only skipped ahead, but that still needs persisting
+			windows = 1
+		}
+	}
+	if windows > 0 || evalErr != nil {
+		state.lastEvaluation = now
+		state.lastError = ""
+		if evalErr != nil {
+			state.lastError = evalErr.Error()
+		}
+	}
+	return windows, evalErr
+}
+
+// evaluateWindow aggregates [start, end) and writes one sample per group,
+// stamped with the window start
+func (m *Manager) evaluateWindow(state *ruleState, start, end int64) error {
+	rule := state.rule
+	result, err := m.engine.Aggregate(&query.AggregationQuery{
+		Query: &query.Query{
+			Matchers: rule.matchers,
+			MinTime:  start,
+			MaxTime:  end - 1,
+		},
+		Function:   rule.Function,
+		Step:       end - start,
+		Align:      query.Alignment{Mode: query.AlignStart},
+		GroupBy:    rule.By,
+		Without:    rule.Without,
+		ValueLabel: rule.ValueLabel,
+	})
+	if err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+	for _, warning := range result.Warnings {
+		fmt.Printf("rollup: rule %s: %s\n", rule.Name, warning)
+	}
+
+	// Write the window in one transaction, so a failed window can be
+	// retried without duplicating samples
+	app := m.db.Appender()
+	written := 0
+	for _, group := range result.Series {
+		labels := rule.outputLabels(group.Labels)
+		for _, sample := range group.Samples {
+			if err := app.Append(labels, sample.Timestamp, sample.Value); err != nil {
+				app.Rollback()
+				return fmt.Errorf("write failed: %w", err)
+			}
+		}
+		written += len(group.Samples)
+	}
+	if err := app.Commit(); err != nil {
+		return fmt.Errorf("write failed: %w", err)
+	}
+	state.samplesWritten.Add(int64(written))
+	return nil
+}
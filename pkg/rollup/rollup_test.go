@@ -0,0 +1,193 @@
+package rollup
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
+)
+
+func TestCompile(t *testing.T) {
+	valid := Rule{Name: "cpu_usage:avg5m", Source: "cpu_usage", Function: "avg", By: []string{"host"}, Interval: "5m"}
+
+	rule, err := compile(valid)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if rule.interval != 5*time.Minute {
+		t.Errorf("interval = %v, want 5m", rule.interval)
+	}
+	// Metric name plus the exclusion of the rule's own output
+	if len(rule.matchers) != 2 {
+		t.Errorf("got %d matchers, want 2", len(rule.matchers))
+	}
+
+	rule, err = compile(Rule{Name: "up:count", Source: `up{env="prod",job=~"api.*"}`, Function: "count", Interval: "1m"})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if len(rule.matchers) != 4 {
+		t.Errorf("got %d matchers, want 4", len(rule.matchers))
+	}
+
+	// A 1d window in New York ends at the next local midnight, 23h later
+	// on the day clocks go forward
+	rule, err = compile(Rule{Name: "requests:sum1d", Source: "requests", Function: "sum", Interval: "24h", Timezone: "America/New_York"})
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	start, end := rule.window(time.Date(2021, 3, 14, 12, 0, 0, 0, time.UTC).UnixMilli())
+	if want := time.Date(2021, 3, 14, 5, 0, 0, 0, time.UTC).UnixMilli(); start != want || end-start != (23*time.Hour).Milliseconds() {
+		t.Errorf("window = [%d, %d), want a 23h window from %d", start, end, want)
+	}
+
+	if _, err := compile(Rule{Name: "up:states", Source: "up", Function: "count_values", ValueLabel: "state", Interval: "1m"}); err != nil {
+		t.Errorf("count_values rule rejected: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(r *Rule)
+	}{
+		{"bad name", func(r *Rule) { r.Name = "cpu-usage" }},
+		{"empty source", func(r *Rule) { r.Source = "" }},
+		{"bad selector", func(r *Rule) { r.Source = "cpu_usage{host}" }},
+		{"unknown function", func(r *Rule) { r.Function = "median" }},
+		{"by and without", func(r *Rule) { r.Without = []string{"env"} }},
+		{"bad interval", func(r *Rule) { r.Interval = "5 minutes" }},
+		{"interval too short", func(r *Rule) { r.Interval = "100ms" }},
+		{"unknown timezone", func(r *Rule) { r.Timezone = "Mars/Olympus" }},
+		{"interval not dividing a day", func(r *Rule) { r.Interval, r.Timezone = "7h", "Europe/Berlin" }},
+		{"count_values without value label", func(r *Rule) { r.Function = "count_values" }},
+		{"value label for avg", func(r *Rule) { r.ValueLabel = "state" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := valid
+			tt.modify(&rule)
+			if _, err := compile(rule); !errors.Is(err, ErrInvalidRule) {
+				t.Errorf("compile error = %v, want ErrInvalidRule", err)
+			}
+		})
+	}
+}
+
+func TestManagerEvaluate(t *testing.T) {
+	dataDir := t.TempDir()
+	db, err := storage.Open(storage.DefaultOptions(dataDir))
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	// A 5m-aligned window start
+	base := int64(1_700_000_100_000)
+	interval := int64(5 * time.Minute / time.Millisecond)
+
+	for host, values := range map[string][]float64{"a": {1, 3}, "b": {10, 20}} {
+		s := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": host, "core": "0"})
+		samples := []series.Sample{
+			{Timestamp: base + 1000, Value: values[0]},
+			{Timestamp: base + 2000, Value: values[1]},
+			// Next window, not complete yet
+			{Timestamp: base + interval + 1000, Value: 100},
+		}
+		if err := db.Insert(s, samples); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	now := time.UnixMilli(base + 1000)
+	m := NewManager(db, DefaultOptions(dataDir))
+	m.now = func() time.Time { return now }
+
+	rule := Rule{Name: "cpu_usage:avg5m", Source: "cpu_usage", Function: "avg", By: []string{"host"}, Interval: "5m"}
+	if err := m.AddRule(rule); err != nil {
+		t.Fatalf("AddRule failed: %v", err)
+	}
+	if err := m.AddRule(rule); !errors.Is(err, ErrRuleExists) {
+		t.Errorf("duplicate AddRule error = %v, want ErrRuleExists", err)
+	}
+
+	// Window complete but still within the delay
+	now = time.UnixMilli(base + interval + 1000)
+	if err := m.Evaluate(); err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if got := m.Rules()[0].SamplesWritten; got != 0 {
+		t.Fatalf("wrote %d samples before the delay passed", got)
+	}
+
+	now = time.UnixMilli(base + interval).Add(DefaultDelay)
+	for i := 0; i < 2; i++ {
+		if err := m.Evaluate(); err != nil {
+			t.Fatalf("Evaluate failed: %v", err)
+		}
+	}
+
+	status := m.Rules()[0]
+	if status.SamplesWritten != 2 || status.NextWindow != base+interval || status.LastError != "" {
+		t.Errorf("status = %+v, want 2 samples written and next window %d", status, base+interval)
+	}
+
+	for host, want := range map[string]float64{"a": 2, "b": 15} {
+		s := series.NewSeries(map[string]string{"__name__": "cpu_usage:avg5m", "host": host})
+		samples, err := db.Query(s.Hash, base, base+2*interval)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if len(samples) != 1 || samples[0].Timestamp != base || samples[0].Value != want {
+			t.Errorf("host %s: got %v, want one sample %v at %d", host, samples, want, base)
+		}
+	}
+
+	// Rules and progress survive a restart
+	reloaded := NewManager(db, DefaultOptions(dataDir))
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	rules := reloaded.Rules()
+	if len(rules) != 1 || rules[0].Name != rule.Name || rules[0].NextWindow != base+interval {
+		t.Errorf("reloaded rules = %+v", rules)
+	}
+
+	if err := reloaded.RemoveRule(rule.Name); err != nil {
+		t.Fatalf("RemoveRule failed: %v", err)
+	}
+	if err := reloaded.RemoveRule(rule.Name); !errors.Is(err, ErrRuleNotFound) {
+		t.Errorf("second RemoveRule error = %v, want ErrRuleNotFound", err)
+	}
+}
+
+func TestManagerMaxCatchUp(t *testing.T) {
+	dataDir := t.TempDir()
+	db, err := storage.Open(storage.DefaultOptions(dataDir))
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	base := int64(1_700_000_100_000)
+	now := time.UnixMilli(base)
+
+	opts := DefaultOptions(dataDir)
+	opts.Delay = 0
+	opts.MaxCatchUp = 10 * time.Minute
+	m := NewManager(db, opts)
+	m.now = func() time.Time { return now }
+
+	if err := m.AddRule(Rule{Name: "up:sum", Source: "up", Function: "sum", Interval: "1m"}); err != nil {
+		t.Fatalf("AddRule failed: %v", err)
+	}
+
+	// A day of downtime only catches up on the last 10 minutes
+	now = now.Add(24 * time.Hour)
+	if err := m.Evaluate(); err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if got, want := m.Rules()[0].NextWindow, now.UnixMilli(); got != want {
+		t.Errorf("NextWindow = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,171 @@
+// Package rollup runs continuous queries: on a fixed interval, each rule
+// aggregates the samples of its source series over the last completed window
+// and writes the result back into the database as ordinary series, so
+// long-range dashboards can read a few pre-aggregated points instead of the
+// raw data.
+package rollup
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/query"
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
+)
+
+// MinInterval is the shortest interval a rule may run at
+const MinInterval = time.Second
+
+var (
+	// ErrInvalidRule indicates a rule definition is malformed
+	ErrInvalidRule = tsdberrors.New(tsdberrors.Invalid, "invalid rollup rule")
+
+	// ErrRuleExists indicates a rule with the same name is already configured
+	ErrRuleExists = tsdberrors.New(tsdberrors.Conflict, "rollup rule already exists")
+
+	// ErrRuleNotFound indicates no rule has the given name
+	ErrRuleNotFound = tsdberrors.New(tsdberrors.NotFound, "rollup rule not found")
+)
+
+// Rule describes a rollup, e.g. "every 5m, write avg of cpu_usage by host
+// into cpu_usage:avg5m".
+type Rule struct {
+	// Name is the metric name the results are written to
+	Name string `json:"name"`
+
+	// Source selects the input series, either as a selector
+	// ({__name__="cpu_usage",env="prod"}), a metric name (cpu_usage) or
+	// both (cpu_usage{env="prod"})
+	Source string `json:"source"`
+
+	// Function is the aggregation applied to each window
+	Function query.AggregateFunc `json:"function"`
+
+	// ValueLabel is the label a count_values rule writes each distinct
+	// value to; other functions leave it empty
+	ValueLabel string `json:"valueLabel,omitempty"`
+
+	// By keeps only these labels on the output series; Without drops these
+	// labels instead. With neither, all source series are aggregated into one.
+	By      []string `json:"by,omitempty"`
+	Without []string `json:"without,omitempty"`
+
+	// Interval is the window size and evaluation period, e.g. "5m"
+	Interval string `json:"interval"`
+
+	// Timezone, an IANA name such as "Europe/Berlin", aligns windows to
+	// calendar boundaries in that zone, so 1d windows start at local
+	// midnight. The interval must then divide a day or be whole days.
+	// Without it windows start at multiples of the interval since the
+	// epoch, i.e. at UTC boundaries.
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// compiledRule is a validated rule with its source, interval and alignment
+// parsed
+type compiledRule struct {
+	Rule
+	matchers index.Matchers
+	interval time.Duration
+	align    query.Alignment
+}
+
+// compile validates a rule and parses its source and interval
+func compile(rule Rule) (*compiledRule, error) {
+	if err := storage.DefaultValidationOptions().ValidateLabels(map[string]string{
+		storage.MetricNameLabel: rule.Name,
+	}); err != nil {
+		return nil, fmt.Errorf("%w: name: %v", ErrInvalidRule, err)
+	}
+
+	if _, err := query.ParseAggregateFunc(string(rule.Function)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+	}
+	if rule.Function == query.CountValues && !storage.IsValidLabelName(rule.ValueLabel) {
+		return nil, fmt.Errorf("%w: count_values needs a valid valueLabel", ErrInvalidRule)
+	}
+	if rule.Function != query.CountValues && rule.ValueLabel != "" {
+		return nil, fmt.Errorf("%w: valueLabel is only used by count_values", ErrInvalidRule)
+	}
+
+	if len(rule.By) > 0 && len(rule.Without) > 0 {
+		return nil, fmt.Errorf("%w: by and without are mutually exclusive", ErrInvalidRule)
+	}
+
+	interval, err := time.ParseDuration(rule.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("%w: interval: %v", ErrInvalidRule, err)
+	}
+	if interval < MinInterval || interval%time.Millisecond != 0 {
+		return nil, fmt.Errorf("%w: interval must be a whole number of milliseconds and at least %s", ErrInvalidRule, MinInterval)
+	}
+
+	var align query.Alignment
+	if rule.Timezone != "" {
+		if align, err = query.ParseAlignment(string(query.AlignCalendar), rule.Timezone); err != nil {
+			return nil, fmt.Errorf("%w: timezone: %v", ErrInvalidRule, err)
+		}
+		if err := align.Validate(interval.Milliseconds()); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+		}
+	}
+
+	matchers, err := parseSource(rule.Source)
+	if err != nil {
+		return nil, fmt.Errorf("%w: source: %v", ErrInvalidRule, err)
+	}
+
+	// Never feed a rule its own output, e.g. for a {} source
+	exclude, err := index.NewMatcher(index.MatchNotEqual, storage.MetricNameLabel, rule.Name)
+	if err != nil {
+		return nil, err
+	}
+	matchers = append(matchers, exclude)
+
+	return &compiledRule{Rule: rule, matchers: matchers, interval: interval, align: align}, nil
+}
+
+// window returns the bounds [start, end) of the window containing ts
+func (r *compiledRule) window(ts int64) (int64, int64) {
+	return r.align.Bucket(ts, r.interval.Milliseconds(), 0)
+}
+
+// parseSource parses a selector with an optional leading metric name
+func parseSource(source string) (index.Matchers, error) {
+	source = strings.TrimSpace(source)
+	if source == "" {
+		return nil, fmt.Errorf("source cannot be empty")
+	}
+
+	name, selector := source, "{}"
+	if i := strings.Index(source, "{"); i >= 0 {
+		name, selector = strings.TrimSpace(source[:i]), source[i:]
+	}
+
+	matchers, err := index.ParseMatchers(selector)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return matchers, nil
+	}
+
+	nameMatcher, err := index.NewMatcher(index.MatchEqual, storage.MetricNameLabel, name)
+	if err != nil {
+		return nil, err
+	}
+	return append(index.Matchers{nameMatcher}, matchers...), nil
+}
+
+// outputLabels returns the labels of an output series for an aggregation group
+func (r *compiledRule) outputLabels(group map[string]string) map[string]string {
+	labels := make(map[string]string, len(group)+1)
+	for name, value := range group {
+		labels[name] = value
+	}
+	labels[storage.MetricNameLabel] = r.Name
+	return labels
+}
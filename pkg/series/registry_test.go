@@ -151,8 +151,8 @@ func TestRegistry_Get(t *testing.T) {
 	id1, _ := r.GetOrCreate(s1)
 
 	// Get existing series
-	if id, ok := r.Get(s1.Hash()); !ok || id != id1 {
-		t.Errorf("Get(%d) = (%d, %v), want (%d, true)", s1.Hash(), id, ok, id1)
+	if id, ok := r.Get(s1.Hash); !ok || id != id1 {
+		t.Errorf("Get(%d) = (%d, %v), want (%d, true)", s1.Hash, id, ok, id1)
 	}
 
 	// Get non-existent series
@@ -172,8 +172,8 @@ func TestRegistry_GetSeries(t *testing.T) {
 	if !ok {
 		t.Fatal("GetSeries(id1) not found")
 	}
-	if series.Hash() != s1.Hash() {
-		t.Errorf("GetSeries(id1) hash = %d, want %d", series.Hash(), s1.Hash())
+	if series.Hash != s1.Hash {
+		t.Errorf("GetSeries(id1) hash = %d, want %d", series.Hash, s1.Hash)
 	}
 
 	// Get non-existent series
@@ -203,13 +203,13 @@ func TestRegistry_Delete(t *testing.T) {
 	}
 
 	// Verify s1 is deleted
-	if _, ok := r.Get(s1.Hash()); ok {
-		t.Error("Get(s1.Hash()) found after delete, want not found")
+	if _, ok := r.Get(s1.Hash); ok {
+		t.Error("Get(s1.Hash) found after delete, want not found")
 	}
 
 	// Verify s2 still exists
-	if _, ok := r.Get(s2.Hash()); !ok {
-		t.Error("Get(s2.Hash()) not found, want found")
+	if _, ok := r.Get(s2.Hash); !ok {
+		t.Error("Get(s2.Hash) not found, want found")
 	}
 
 	// Delete non-existent series (should not panic)
@@ -239,7 +239,7 @@ func TestRegistry_Stats(t *testing.T) {
 
 	// Create some series
 	id1, _ := r.GetOrCreate(s1)
-	id2, _ := r.GetOrCreate(s2)
+	r.GetOrCreate(s2)
 
 	stats = r.Stats()
 	if stats.Cardinality != 2 {
@@ -267,7 +267,7 @@ func TestRegistry_Stats(t *testing.T) {
 	}
 
 	// Test LRU stats
-	r.Get(s2.Hash()) // Hit (already in cache from GetOrCreate)
+	r.Get(s2.Hash) // Hit (already in cache from GetOrCreate)
 	stats = r.Stats()
 	if stats.LRUHits == 0 {
 		t.Error("LRUHits = 0, want > 0")
@@ -1,8 +1,11 @@
 package series
 
 import (
+	"cmp"
+	"encoding/binary"
 	"hash/fnv"
 	"sort"
+	"strings"
 )
 
 // Sample represents a single time-series data point.
@@ -32,19 +35,37 @@ func NewSeries(labels map[string]string) *Series {
 // The hash is deterministic and considers both label names and values.
 // Labels are sorted to ensure consistent hashing regardless of insertion order.
 func (s *Series) computeHash() uint64 {
+	return hashLabels(s.Labels, 0)
+}
+
+// CollisionHash returns the secondary hash of labels for an attempt
+// (from 1). A series whose hash another label set already holds is given
+// the first of these no other label set holds, so the two keep apart.
+func CollisionHash(labels map[string]string, attempt int) uint64 {
+	return hashLabels(labels, attempt)
+}
+
+// hashLabels hashes labels sorted by name, seeded with attempt unless it
+// is 0
+func hashLabels(labels map[string]string, attempt int) uint64 {
 	// Sort label names for consistent hashing
-	names := make([]string, 0, len(s.Labels))
-	for name := range s.Labels {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
 		names = append(names, name)
 	}
 	sort.Strings(names)
 
 	// Use FNV-1a hash for good distribution and speed
 	h := fnv.New64a()
+	if attempt > 0 {
+		// No label name starts with 0xff, as names are valid UTF-8
+		h.Write([]byte{0xff})
+		h.Write(binary.BigEndian.AppendUint64(nil, uint64(attempt)))
+	}
 	for _, name := range names {
 		h.Write([]byte(name))
 		h.Write([]byte{0}) // Separator
-		h.Write([]byte(s.Labels[name]))
+		h.Write([]byte(labels[name]))
 		h.Write([]byte{0}) // Separator
 	}
 
@@ -91,11 +112,40 @@ func (s *Series) Equals(other *Series) bool {
 	return true
 }
 
-// Clone creates a deep copy of the series.
+// CompareLabels orders label sets by their label pairs sorted by name,
+// comparing names first and then values, pair by pair; a set that is a
+// prefix of another sorts first. It returns -1, 0 or +1.
+func CompareLabels(a, b map[string]string) int {
+	namesA := sortedNames(a)
+	namesB := sortedNames(b)
+
+	for i := 0; i < len(namesA) && i < len(namesB); i++ {
+		if c := strings.Compare(namesA[i], namesB[i]); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a[namesA[i]], b[namesB[i]]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(namesA), len(namesB))
+}
+
+// sortedNames returns the label names of labels in sorted order
+func sortedNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Clone creates a deep copy of the series, keeping its hash, which may be
+// a CollisionHash.
 func (s *Series) Clone() *Series {
 	labels := make(map[string]string, len(s.Labels))
 	for k, v := range s.Labels {
 		labels[k] = v
 	}
-	return NewSeries(labels)
+	return &Series{Labels: labels, Hash: s.Hash}
 }
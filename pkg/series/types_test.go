@@ -196,6 +196,29 @@ func TestSample(t *testing.T) {
 	}
 }
 
+func TestCompareLabels(t *testing.T) {
+	tests := []struct {
+		a, b map[string]string
+		want int
+	}{
+		{map[string]string{"a": "1"}, map[string]string{"a": "1"}, 0},
+		{map[string]string{"a": "1"}, map[string]string{"a": "2"}, -1},
+		{map[string]string{"a": "2", "b": "1"}, map[string]string{"a": "1", "c": "1"}, 1},
+		{map[string]string{"a": "1", "b": "1"}, map[string]string{"a": "1", "c": "0"}, -1},
+		{map[string]string{"a": "1"}, map[string]string{"a": "1", "b": "1"}, -1},
+		{map[string]string{}, map[string]string{"a": "1"}, -1},
+	}
+
+	for _, tt := range tests {
+		if got := CompareLabels(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareLabels(%v, %v) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := CompareLabels(tt.b, tt.a); got != -tt.want {
+			t.Errorf("CompareLabels(%v, %v) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
 func TestSeriesHash_CollisionResistance(t *testing.T) {
 	// Create many series and check for hash collisions
 	seen := make(map[uint64]bool)
@@ -219,3 +242,24 @@ func TestSeriesHash_CollisionResistance(t *testing.T) {
 		t.Errorf("Too many hash collisions: %d out of 10000", collisions)
 	}
 }
+
+func TestCollisionHash(t *testing.T) {
+	labels := map[string]string{"__name__": "cpu_usage", "host": "server1"}
+
+	first := CollisionHash(labels, 1)
+	if first == NewSeries(labels).Hash {
+		t.Error("collision hash should differ from the series hash")
+	}
+	if CollisionHash(labels, 1) != first {
+		t.Error("collision hash should be deterministic")
+	}
+	if CollisionHash(labels, 2) == first {
+		t.Error("each attempt should give another hash")
+	}
+
+	// Clones keep the hash they were given
+	s := &Series{Labels: labels, Hash: first}
+	if s.Clone().Hash != first {
+		t.Error("clone should keep a collision hash")
+	}
+}
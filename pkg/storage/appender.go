@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"fmt"
+	"maps"
+
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// ErrAppenderDone indicates use of an appender after Commit or Rollback
+var ErrAppenderDone = tsdberrors.New(tsdberrors.Conflict, "tsdb: appender already committed or rolled back")
+
+// SeriesError is the failure of one series of a commit. A commit rejected
+// by the sample policy fails with one SeriesError per rejected series,
+// joined by errors.Join.
+type SeriesError struct {
+	Series  *series.Series
+	Samples int // Samples of the series in the commit
+	Err     error
+}
+
+func (e *SeriesError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SeriesError) Unwrap() error {
+	return e.Err
+}
+
+// dbAppender is the TSDB's Appender. Samples are grouped per series in
+// the order their series were first appended.
+type dbAppender struct {
+	db *TSDB
+
+	series  []*series.Series
+	samples [][]series.Sample
+	byHash  map[uint64][]int // Indexes in series by the hash of the relabeled labels
+
+	// Series appended by the hash of their labels, so each series is
+	// relabeled and validated once. Label sets sharing a hash are told
+	// apart by their labels.
+	resolved map[uint64][]appendedSeries
+
+	done bool
+}
+
+// appendedSeries is a series appended to a dbAppender: its labels as
+// appended and its index in dbAppender.series, or droppedSeries
+type appendedSeries struct {
+	labels map[string]string
+	index  int
+}
+
+// droppedSeries marks a series dropped by relabel rules in
+// dbAppender.resolved
+const droppedSeries = -1
+
+// Appender returns a new appender writing to the TSDB
+func (db *TSDB) Appender() Appender {
+	return &dbAppender{db: db, byHash: make(map[uint64][]int), resolved: make(map[uint64][]appendedSeries)}
+}
+
+// Append buffers a sample. A sample outside the timestamp bounds fails
+// with ErrSampleTooOld or ErrSampleTooNew and is not buffered; the
+// appender can still be used. Samples of series dropped by relabel rules
+// are discarded.
+func (a *dbAppender) Append(labels map[string]string, t int64, v float64) error {
+	if a.done {
+		return ErrAppenderDone
+	}
+	if a.db.closed.Load() {
+		return ErrClosed
+	}
+
+	s := series.NewSeries(labels)
+	i, ok := a.lookup(s)
+	if !ok {
+		var err error
+		if i, err = a.resolve(s); err != nil {
+			return err
+		}
+		// Keep the labels as appended, as callers can reuse their map
+		kept := appendedSeries{labels: maps.Clone(labels), index: i}
+		if i != droppedSeries && maps.Equal(a.series[i].Labels, labels) {
+			kept.labels = a.series[i].Labels
+		}
+		a.resolved[s.Hash] = append(a.resolved[s.Hash], kept)
+	}
+	if i == droppedSeries {
+		a.db.stats.RelabelDropped.Add(1)
+		return nil
+	}
+
+	if err := a.db.bounds.check(t); err != nil {
+		a.db.stats.recordBounds(err)
+		a.db.ingest.record(a.series[i], 0, 1, 0)
+		return fmt.Errorf("%w: timestamp %d", err, t)
+	}
+	a.samples[i] = append(a.samples[i], series.Sample{Timestamp: t, Value: v})
+	return nil
+}
+
+// lookup returns the index of an appended series with the labels of s, or
+// droppedSeries
+func (a *dbAppender) lookup(s *series.Series) (int, bool) {
+	for _, appended := range a.resolved[s.Hash] {
+		if maps.Equal(appended.labels, s.Labels) {
+			return appended.index, true
+		}
+	}
+	return 0, false
+}
+
+// resolve relabels and validates a series appended for the first time,
+// returning its index in the buffer or droppedSeries
+func (a *dbAppender) resolve(s *series.Series) (int, error) {
+	relabeled, keep := a.db.relabeler.relabel(s)
+	if !keep {
+		return droppedSeries, nil
+	}
+	if err := a.db.validation.ValidateLabels(relabeled.Labels); err != nil {
+		a.db.ingest.record(relabeled, 0, 1, 0)
+		return 0, err
+	}
+
+	// Relabeling can map several series to the same one
+	for _, i := range a.byHash[relabeled.Hash] {
+		if a.series[i].Equals(relabeled) {
+			return i, nil
+		}
+	}
+	i := len(a.series)
+	a.byHash[relabeled.Hash] = append(a.byHash[relabeled.Hash], i)
+	if relabeled == s {
+		// Copy the labels so callers can reuse their map
+		relabeled = s.Clone()
+	}
+	a.series = append(a.series, relabeled)
+	a.samples = append(a.samples, nil)
+	return i, nil
+}
+
+// Commit writes the buffered samples as a single transaction: they are
+// logged as one WAL entry and become visible to queries together. If the
+// commit fails, none of them are written.
+func (a *dbAppender) Commit() error {
+	if a.done {
+		return ErrAppenderDone
+	}
+	defer a.reset()
+
+	if len(a.series) == 0 {
+		return nil
+	}
+	return a.db.insertBatch(a.series, a.samples)
+}
+
+// Rollback discards the buffered samples
+func (a *dbAppender) Rollback() error {
+	if a.done {
+		return ErrAppenderDone
+	}
+	a.reset()
+	return nil
+}
+
+// reset drops the buffer and marks the appender as done
+func (a *dbAppender) reset() {
+	a.series = nil
+	a.samples = nil
+	a.byHash = nil
+	a.resolved = nil
+	a.done = true
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestAppender(t *testing.T) {
+	db, err := Open(DefaultOptions(t.TempDir()))
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	labels := map[string]string{"__name__": "cpu_usage", "host": "server1"}
+	hash := series.NewSeries(labels).Hash
+
+	app := db.Appender()
+	for ts := int64(1000); ts <= 3000; ts += 1000 {
+		if err := app.Append(labels, ts, float64(ts)); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	if err := app.Append(map[string]string{"__name__": "mem_usage"}, 1000, 1); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	// The caller's map may be reused after Append
+	labels["host"] = "server2"
+
+	if samples, _ := db.Query(hash, 0, 0); len(samples) != 0 {
+		t.Fatalf("samples visible before Commit: %v", samples)
+	}
+	if err := app.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if samples, _ := db.Query(hash, 0, 0); len(samples) != 3 {
+		t.Fatalf("expected 3 samples after Commit, got %d", len(samples))
+	}
+	if n := db.GetStatsSnapshot().TotalSeries; n != 2 {
+		t.Errorf("expected 2 series, got %d", n)
+	}
+
+	if err := app.Append(labels, 4000, 4); !errors.Is(err, ErrAppenderDone) {
+		t.Errorf("Append after Commit: got %v, want ErrAppenderDone", err)
+	}
+	if err := app.Commit(); !errors.Is(err, ErrAppenderDone) {
+		t.Errorf("second Commit: got %v, want ErrAppenderDone", err)
+	}
+}
+
+func TestAppenderRollback(t *testing.T) {
+	db, err := Open(DefaultOptions(t.TempDir()))
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	labels := map[string]string{"__name__": "cpu_usage"}
+	app := db.Appender()
+	if err := app.Append(labels, 1000, 1); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if err := app.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if samples, _ := db.Query(series.NewSeries(labels).Hash, 0, 0); len(samples) != 0 {
+		t.Errorf("samples written after Rollback: %v", samples)
+	}
+
+	// Invalid labels are rejected on Append
+	app = db.Appender()
+	if err := app.Append(map[string]string{"__name__": "bad-name"}, 1000, 1); !errors.Is(err, ErrInvalidMetricName) {
+		t.Errorf("Append with invalid name: got %v, want ErrInvalidMetricName", err)
+	}
+}
+
+func TestAppenderCommitAtomic(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultOptions(dir)
+	opts.MemTableSize = 100 * EstimatedBytesPerSample
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+
+	// Too large for the MemTable: nothing may be written
+	app := db.Appender()
+	for i := int64(0); i < 200; i++ {
+		app.Append(map[string]string{"__name__": "big"}, i, 1)
+	}
+	app.Append(map[string]string{"__name__": "small"}, 0, 1)
+	if err := app.Commit(); !errors.Is(err, ErrMemTableFull) {
+		t.Fatalf("Commit: got %v, want ErrMemTableFull", err)
+	}
+	small := series.NewSeries(map[string]string{"__name__": "small"}).Hash
+	if samples, _ := db.Query(small, 0, 0); len(samples) != 0 {
+		t.Errorf("failed commit wrote %d samples", len(samples))
+	}
+
+	// A committed batch survives a restart
+	app = db.Appender()
+	app.Append(map[string]string{"__name__": "small"}, 1000, 1)
+	app.Append(map[string]string{"__name__": "other"}, 1000, 2)
+	if err := app.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	db.Close()
+
+	db, err = Open(opts)
+	if err != nil {
+		t.Fatalf("failed to reopen TSDB: %v", err)
+	}
+	defer db.Close()
+	if samples, _ := db.Query(small, 0, 0); len(samples) != 1 {
+		t.Errorf("expected 1 recovered sample, got %d", len(samples))
+	}
+	if n := db.GetStatsSnapshot().TotalSeries; n != 2 {
+		t.Errorf("expected 2 recovered series, got %d", n)
+	}
+}
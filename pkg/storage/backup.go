@@ -0,0 +1,299 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+	"github.com/therealutkarshpriyadarshi/time/pkg/wal"
+)
+
+// ErrBackupClosed indicates a backup was read after it was closed
+var ErrBackupClosed = tsdberrors.New(tsdberrors.Unavailable, "tsdb: backup closed")
+
+// tarBlockSize is the unit tar archives are padded to
+const tarBlockSize = 512
+
+// Backup is a consistent snapshot of the database, read as a tar archive
+// that unpacks into a data directory: every block, a checkpoint of the
+// WAL and the metric metadata. Samples still in memory are covered by the
+// WAL, and are replayed when the restored database is opened.
+//
+// The archive is laid out when the backup is taken, so it has a fixed size
+// and can be read from any offset, e.g. to resume an interrupted download.
+// The backup holds its blocks and WAL segments until it is closed; it must
+// be closed once read.
+type Backup struct {
+	ID        string
+	CreatedAt time.Time
+	Blocks    int
+
+	parts []backupPart
+	size  int64
+
+	mu         sync.RWMutex // Held for reading by ReadAt; Close waits on it
+	closed     bool
+	snap       *blockSnapshot
+	checkpoint *wal.Checkpoint
+}
+
+// backupPart is a section of the archive, starting at offset
+type backupPart struct {
+	offset int64
+	size   int64
+	data   []byte      // Tar headers, padding and small files
+	src    io.ReaderAt // File content read from disk, if data is nil
+}
+
+// backupFile is a file stored in the archive
+type backupFile struct {
+	name string // Slash-separated path in the archive
+	size int64
+	data []byte
+	src  io.ReaderAt
+}
+
+// Backup takes a backup of the database. The WAL is checkpointed before
+// the blocks are leased, so samples flushed in between are found in the
+// blocks, and samples compacted in between are found in the merged blocks;
+// some samples may be in both, which is harmless.
+func (db *TSDB) Backup() (*Backup, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	createdAt := time.Now()
+	id, err := ulid.New(ulid.Timestamp(createdAt), ulidEntropy)
+	if err != nil {
+		return nil, fmt.Errorf("tsdb: failed to generate backup ID: %w", err)
+	}
+
+	checkpoint, err := db.walWriter.Checkpoint()
+	if err != nil {
+		return nil, fmt.Errorf("tsdb: failed to checkpoint WAL: %w", err)
+	}
+	snap, err := db.leases.snapshot(db.dataDir, db.coldDir)
+	if err != nil {
+		checkpoint.Close()
+		return nil, fmt.Errorf("tsdb: failed to snapshot blocks: %w", err)
+	}
+
+	b := &Backup{
+		ID:         id.String(),
+		CreatedAt:  createdAt,
+		Blocks:     len(snap.Dirs()),
+		snap:       snap,
+		checkpoint: checkpoint,
+	}
+	if err := b.layout(db); err != nil {
+		b.Close()
+		return nil, fmt.Errorf("tsdb: failed to lay out backup: %w", err)
+	}
+	return b, nil
+}
+
+// layout lists the backup's files and arranges them into tar parts
+func (b *Backup) layout(db *TSDB) error {
+	var files []backupFile
+	for _, dir := range b.snap.Dirs() {
+		blockFiles, err := listBlockFiles(dir)
+		if err != nil {
+			return err
+		}
+		files = append(files, blockFiles...)
+	}
+
+	for _, seg := range b.checkpoint.Segments {
+		files = append(files, backupFile{
+			name: path.Join(DefaultWALDir, seg.Name),
+			size: seg.Size,
+			src:  seg,
+		})
+	}
+
+	metadata, err := db.metadata.marshal()
+	if err != nil {
+		return err
+	}
+	files = append(files, backupFile{name: MetadataFile, size: int64(len(metadata)), data: metadata})
+
+	for _, file := range files {
+		if err := b.addFile(file); err != nil {
+			return err
+		}
+	}
+
+	// An archive ends with two zero blocks
+	b.addPart(backupPart{size: 2 * tarBlockSize, data: make([]byte, 2*tarBlockSize)})
+	return nil
+}
+
+// listBlockFiles lists the files of the block in dir. The hold marker is
+// read into memory, as it may be placed or lifted while the backup is
+// read; other block files never change once written.
+func listBlockFiles(dir string) ([]backupFile, error) {
+	root := filepath.Dir(dir)
+
+	var files []backupFile
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		file := backupFile{name: filepath.ToSlash(rel)}
+
+		if d.Name() == NoDeleteFile {
+			if file.data, err = os.ReadFile(p); err != nil {
+				if os.IsNotExist(err) {
+					return nil // Hold lifted meanwhile
+				}
+				return err
+			}
+			file.size = int64(len(file.data))
+		} else {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			file.size = info.Size()
+			file.src = blockFile(p)
+		}
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list block %s: %w", filepath.Base(dir), err)
+	}
+	return files, nil
+}
+
+// addFile appends file's tar header, content and padding to the archive
+func (b *Backup) addFile(file backupFile) error {
+	var header bytes.Buffer
+	tw := tar.NewWriter(&header)
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     file.name,
+		Size:     file.size,
+		Mode:     0644,
+		ModTime:  b.CreatedAt.Truncate(time.Second),
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write tar header for %s: %w", file.name, err)
+	}
+
+	b.addPart(backupPart{size: int64(header.Len()), data: header.Bytes()})
+	if file.size > 0 {
+		b.addPart(backupPart{size: file.size, data: file.data, src: file.src})
+	}
+	if pad := (tarBlockSize - file.size%tarBlockSize) % tarBlockSize; pad > 0 {
+		b.addPart(backupPart{size: pad, data: make([]byte, pad)})
+	}
+	return nil
+}
+
+// addPart appends part at the end of the archive
+func (b *Backup) addPart(part backupPart) {
+	part.offset = b.size
+	b.parts = append(b.parts, part)
+	b.size += part.size
+}
+
+// Size returns the size of the archive in bytes
+func (b *Backup) Size() int64 {
+	return b.size
+}
+
+// ReadAt reads the archive at off. It is safe for concurrent use.
+func (b *Backup) ReadAt(p []byte, off int64) (int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return 0, ErrBackupClosed
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("tsdb: negative backup offset %d", off)
+	}
+
+	// First part ending after off
+	i := sort.Search(len(b.parts), func(i int) bool {
+		return b.parts[i].offset+b.parts[i].size > off
+	})
+
+	n := 0
+	for ; i < len(b.parts) && n < len(p); i++ {
+		part := b.parts[i]
+		start := off + int64(n) - part.offset
+		want := p[n:]
+		if remaining := part.size - start; int64(len(want)) > remaining {
+			want = want[:remaining]
+		}
+
+		if part.data != nil {
+			n += copy(want, part.data[start:])
+			continue
+		}
+		read, err := part.src.ReadAt(want, start)
+		n += read
+		if read < len(want) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF // File shrank since the backup was taken
+			}
+			return n, err
+		}
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close releases the backup's blocks and WAL segments, waiting for reads
+// in progress. Closing twice is a no-op.
+func (b *Backup) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	b.snap.Release()
+	return b.checkpoint.Close()
+}
+
+// blockFile is a block file, opened for each read so a backup of many
+// blocks doesn't hold a descriptor for each of their files
+type blockFile string
+
+// ReadAt implements io.ReaderAt
+func (f blockFile) ReadAt(p []byte, off int64) (int, error) {
+	file, err := os.Open(string(f))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return file.ReadAt(p, off)
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// extractTar unpacks archive into dir
+func extractTar(t *testing.T, archive []byte, dir string) {
+	t.Helper()
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("failed to read archive: %v", err)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", hdr.Name, err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBackup(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.EnableRetention = false
+	opts.EnableCompaction = false
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	cpu := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "a"})
+	insert := func(ts int64) {
+		if err := db.Insert(cpu, []series.Sample{{Timestamp: ts, Value: float64(ts)}}); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+
+	// One sample in a block, one only in the WAL
+	insert(1000)
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	insert(2000)
+
+	b, err := db.Backup()
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	defer b.Close()
+	if b.Blocks != 1 {
+		t.Errorf("expected 1 block in the backup, got %d", b.Blocks)
+	}
+
+	// Written after the backup was taken
+	insert(3000)
+
+	archive, err := io.ReadAll(io.NewSectionReader(b, 0, b.Size()))
+	if err != nil {
+		t.Fatalf("failed to read backup: %v", err)
+	}
+	if int64(len(archive)) != b.Size() {
+		t.Fatalf("read %d bytes, backup size is %d", len(archive), b.Size())
+	}
+
+	// Reads resume from any offset
+	off := b.Size() / 3
+	rest := make([]byte, b.Size()-off)
+	if _, err := b.ReadAt(rest, off); err != nil {
+		t.Fatalf("ReadAt failed: %v", err)
+	}
+	if !bytes.Equal(rest, archive[off:]) {
+		t.Error("ReadAt from an offset returned different content")
+	}
+
+	restoreDir := t.TempDir()
+	extractTar(t, archive, restoreDir)
+	if _, err := os.Stat(filepath.Join(restoreDir, MetadataFile)); err != nil {
+		t.Errorf("metadata missing from backup: %v", err)
+	}
+
+	restoredOpts := *opts
+	restoredOpts.DataDir = restoreDir
+	restored, err := Open(&restoredOpts)
+	if err != nil {
+		t.Fatalf("failed to open restored TSDB: %v", err)
+	}
+	defer restored.Close()
+
+	q, err := restored.Querier(0, 10000)
+	if err != nil {
+		t.Fatalf("Querier failed: %v", err)
+	}
+	defer q.Close()
+	set, err := q.Select(index.MustNewMatcher(index.MatchEqual, "__name__", "cpu_usage"))
+	if err != nil {
+		t.Fatalf("Select failed: %v", err)
+	}
+	var timestamps []int64
+	for set.Next() {
+		_, samples := set.At()
+		for _, sample := range samples {
+			timestamps = append(timestamps, sample.Timestamp)
+		}
+	}
+	if len(timestamps) != 2 || timestamps[0] != 1000 || timestamps[1] != 2000 {
+		t.Errorf("restored timestamps %v, want [1000 2000]", timestamps)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := b.ReadAt(make([]byte, 1), 0); !errors.Is(err, ErrBackupClosed) {
+		t.Errorf("ReadAt after Close: got %v, want ErrBackupClosed", err)
+	}
+}
@@ -2,16 +2,19 @@ package storage
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"math/rand"
+	"maps"
 	"os"
 	"path/filepath"
 	"sort"
 	"sync"
 	"time"
 
+	"github.com/RoaringBitmap/roaring"
 	"github.com/oklog/ulid/v2"
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
 	"github.com/therealutkarshpriyadarshi/time/pkg/series"
 )
 
@@ -27,7 +30,8 @@ import (
 //   │   │   ├── 000001        # Chunk file for series 1
 //   │   │   ├── 000002        # Chunk file for series 2
 //   │   │   └── ...
-//   │   └── index             # Series index (future: inverted index)
+//   │   ├── index             # Inverted index (series ID = chunk file number)
+//   │   └── bloom             # Bloom filter over series hashes
 //   └── 01H8XDEF00000000/
 //       └── ...
 type Block struct {
@@ -41,6 +45,18 @@ type Block struct {
 	NumSeries  int64 // Total number of unique series
 	NumChunks  int64 // Total number of chunks
 
+	// Level is the block's compaction level: Level0 when flushed, bumped
+	// by each merge
+	Level CompactionLevel
+
+	// Labels describe where the block came from (see BlockLabelLevel and
+	// friends); nil for blocks written before blocks were labeled
+	Labels map[string]string
+
+	// Precision lists the PrecisionRules that rounded the block's values;
+	// nil if none did
+	Precision []BlockPrecision
+
 	// Directory path
 	dir string
 
@@ -49,6 +65,20 @@ type Block struct {
 	series       map[uint64]*series.Series
 	seriesChunks map[uint64]int // seriesHash -> chunkFile number (for lazy loading)
 
+	// Index over the block's series, memory-mapped lazily from disk.
+	// Series IDs in the index are chunk file numbers.
+	index index.Reader
+
+	// Bloom filter over series hashes, consulted before touching chunks.
+	// Read from disk on first use; nil for blocks that have not been
+	// persisted.
+	bloom     *BloomFilter
+	bloomOnce sync.Once
+
+	// Blocks whose indexes Persist merges into the block's, as compaction
+	// replaces them, instead of indexing the series' labels again
+	indexSources []*Block
+
 	mu sync.RWMutex
 }
 
@@ -59,8 +89,15 @@ type BlockMeta struct {
 	MaxTime      int64             `json:"maxTime"`
 	Stats        BlockStats        `json:"stats"`
 	Version      int               `json:"version"`
+	IndexVersion uint32            `json:"indexVersion,omitempty"` // Format version of the index file (block version 2+)
+	Level        *CompactionLevel  `json:"level,omitempty"`        // Unset in blocks written before levels were recorded
 	Labels       map[string]string `json:"labels,omitempty"`
+	Precision    []BlockPrecision  `json:"precision,omitempty"` // Rules that rounded the block's values
 	SeriesChunks map[string]int    `json:"seriesChunks"` // seriesHash -> chunkFile number
+
+	// Collisions holds the labels of the series stored under a
+	// series.CollisionHash rather than the hash of their labels, by hash
+	Collisions map[string]map[string]string `json:"collisions,omitempty"`
 }
 
 // BlockStats contains block statistics
@@ -71,8 +108,13 @@ type BlockStats struct {
 }
 
 const (
-	// BlockVersion is the current block format version
-	BlockVersion = 1
+	// BlockVersion is the current block format version.
+	// Version 2 uses the v2 index format and records it in IndexVersion.
+	BlockVersion = 2
+
+	// MinBlockVersion is the oldest block format that can still be read.
+	// Older supported blocks can be upgraded with MigrateBlock.
+	MinBlockVersion = 1
 
 	// ChunksDir is the subdirectory for chunks
 	ChunksDir = "chunks"
@@ -80,7 +122,7 @@ const (
 	// MetaFile is the metadata file name
 	MetaFile = "meta.json"
 
-	// IndexFile is the index file name (placeholder for Phase 4)
+	// IndexFile is the inverted index file name
 	IndexFile = "index"
 
 	// DefaultBlockDuration is the default block time window (2 hours)
@@ -90,8 +132,7 @@ const (
 // NewBlock creates a new empty block
 func NewBlock(minTime, maxTime int64) (*Block, error) {
 	// Generate ULID based on minTime
-	entropy := rand.New(rand.NewSource(time.Now().UnixNano()))
-	blockULID, err := ulid.New(uint64(minTime), entropy)
+	blockULID, err := newBlockULID(uint64(minTime))
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate ULID: %w", err)
 	}
@@ -109,15 +150,12 @@ func NewBlock(minTime, maxTime int64) (*Block, error) {
 // OpenBlock opens an existing block from disk
 func OpenBlock(dir string) (*Block, error) {
 	// Read metadata
-	metaPath := filepath.Join(dir, MetaFile)
-	metaData, err := os.ReadFile(metaPath)
+	meta, err := readBlockMeta(dir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read block metadata: %w", err)
+		return nil, err
 	}
-
-	var meta BlockMeta
-	if err := json.Unmarshal(metaData, &meta); err != nil {
-		return nil, fmt.Errorf("failed to parse block metadata: %w", err)
+	if err := checkBlockVersion(meta); err != nil {
+		return nil, err
 	}
 
 	// Parse ULID
@@ -141,15 +179,74 @@ func OpenBlock(dir string) (*Block, error) {
 		NumSamples:   meta.Stats.NumSamples,
 		NumSeries:    meta.Stats.NumSeries,
 		NumChunks:    meta.Stats.NumChunks,
+		Level:        inferLevel(meta.MinTime, meta.MaxTime),
+		Labels:       meta.Labels,
+		Precision:    meta.Precision,
 		dir:          dir,
 		chunks:       make(map[uint64]*Chunk),
 		series:       make(map[uint64]*series.Series),
 		seriesChunks: seriesChunks,
 	}
-
+	if meta.Level != nil {
+		block.Level = *meta.Level
+	}
 	return block, nil
 }
 
+// openBloom loads the bloom filter of a block opened from disk. A filter
+// that can't be read is logged and left out, so every series may be in
+// the block.
+func (b *Block) openBloom() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.bloom != nil || b.dir == "" {
+		return
+	}
+	bloom, err := loadBloom(b.dir, b.seriesChunks)
+	if err != nil {
+		fmt.Printf("tsdb: block %s: %v\n", b.ULID, err)
+		return
+	}
+	b.bloom = bloom
+}
+
+// loadBloom reads the block's bloom filter. Blocks written before bloom
+// filters existed get one built from the series hashes in meta.json.
+func loadBloom(dir string, seriesChunks map[uint64]int) (*BloomFilter, error) {
+	data, err := os.ReadFile(filepath.Join(dir, BloomFile))
+	if err == nil {
+		bloom := &BloomFilter{}
+		if err := bloom.UnmarshalBinary(data); err != nil {
+			return nil, fmt.Errorf("failed to read bloom filter: %w", err)
+		}
+		return bloom, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read bloom filter: %w", err)
+	}
+
+	bloom := NewBloomFilter(len(seriesChunks), DefaultBloomFalsePositiveRate)
+	for hash := range seriesChunks {
+		bloom.Add(hash)
+	}
+	return bloom, nil
+}
+
+// MayContainSeries reports whether the block may hold the series.
+// False means the series is definitely not in the block.
+func (b *Block) MayContainSeries(seriesHash uint64) bool {
+	b.bloomOnce.Do(b.openBloom)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.bloom == nil {
+		return true
+	}
+	return b.bloom.MayContain(seriesHash)
+}
+
 // AddSeries adds a series with its samples to the block
 func (b *Block) AddSeries(s *series.Series, samples []series.Sample) error {
 	if len(samples) == 0 {
@@ -190,32 +287,71 @@ func (b *Block) AddSeries(s *series.Series, samples []series.Sample) error {
 
 // GetSeries retrieves samples for a series within a time range
 func (b *Block) GetSeries(seriesHash uint64, minTime, maxTime int64) ([]series.Sample, error) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
+	return b.appendSeries(nil, seriesHash, minTime, maxTime)
+}
+
+// appendSeries appends the samples of a series within a time range to dst,
+// so callers reading many series can reuse one buffer. Chunks are loaded
+// and decoded without holding the block's lock, so series can be read in
+// parallel.
+func (b *Block) appendSeries(dst []series.Sample, seriesHash uint64, minTime, maxTime int64) ([]series.Sample, error) {
+	chunk, err := b.chunk(seriesHash)
+	if err != nil {
+		return nil, err
+	}
+	if chunk == nil {
+		return dst, nil
+	}
+	return appendChunk(dst, chunk, minTime, maxTime)
+}
+
+// chunk returns the chunk of a series, loading it from disk on first use,
+// or nil if the block doesn't hold the series
+func (b *Block) chunk(seriesHash uint64) (*Chunk, error) {
+	chunk, _, err := b.loadChunk(seriesHash)
+	return chunk, err
+}
 
+// loadChunk returns the chunk of a series like chunk, and whether this
+// call read it from disk rather than finding it in memory
+func (b *Block) loadChunk(seriesHash uint64) (*Chunk, bool, error) {
+	b.mu.RLock()
 	chunk, ok := b.chunks[seriesHash]
+	chunkNum, exists := b.seriesChunks[seriesHash]
+	dir := b.dir
+	b.mu.RUnlock()
+
 	if !ok {
 		// Try to load chunk from disk (lazy loading)
-		chunkNum, exists := b.seriesChunks[seriesHash]
 		if !exists {
-			return nil, nil // Series not found in this block
+			return nil, false, nil // Series not found in this block
 		}
 
 		// Load chunk from disk
-		chunkFile := filepath.Join(b.dir, ChunksDir, fmt.Sprintf("%06d", chunkNum))
+		chunkFile := filepath.Join(dir, ChunksDir, fmt.Sprintf("%06d", chunkNum))
 		loadedChunk, err := b.LoadChunk(chunkFile)
 		if err != nil {
-			return nil, fmt.Errorf("failed to load chunk: %w", err)
+			return nil, false, fmt.Errorf("failed to load chunk: %w", err)
 		}
 
-		// Cache the loaded chunk
-		b.chunks[seriesHash] = loadedChunk
-		chunk = loadedChunk
+		// Cache the loaded chunk, unless a concurrent read already did
+		b.mu.Lock()
+		if cached, ok := b.chunks[seriesHash]; ok {
+			loadedChunk = cached
+		} else {
+			b.chunks[seriesHash] = loadedChunk
+		}
+		b.mu.Unlock()
+		return loadedChunk, true, nil
 	}
+	return chunk, false, nil
+}
 
+// appendChunk appends the samples of chunk within a time range to dst
+func appendChunk(dst []series.Sample, chunk *Chunk, minTime, maxTime int64) ([]series.Sample, error) {
 	// Check if time range overlaps with chunk
 	if maxTime < chunk.MinTime || minTime > chunk.MaxTime {
-		return nil, nil // No overlap
+		return dst, nil // No overlap
 	}
 
 	// Iterate through chunk and filter by time range
@@ -224,7 +360,7 @@ func (b *Block) GetSeries(seriesHash uint64, minTime, maxTime int64) ([]series.S
 		return nil, fmt.Errorf("failed to create iterator: %w", err)
 	}
 
-	var result []series.Sample
+	result := dst
 	for iter.Next() {
 		sample, err := iter.At()
 		if err != nil {
@@ -244,16 +380,19 @@ func (b *Block) GetSeries(seriesHash uint64, minTime, maxTime int64) ([]series.S
 	return result, nil
 }
 
-// Persist writes the block to disk
+// Persist writes the block to disk. Every file and directory is synced
+// before it returns, so the block survives a crash once Persist succeeds.
 func (b *Block) Persist(dataDir string) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	// Create block directory
-	blockDir := filepath.Join(dataDir, b.ULID.String())
-	if err := os.MkdirAll(blockDir, 0755); err != nil {
-		return fmt.Errorf("failed to create block directory: %w", err)
+	// Create the block directory, unless the caller already has
+	if b.dir == "" {
+		if err := b.createDirLocked(dataDir, mkdirNew); err != nil {
+			return err
+		}
 	}
+	blockDir := b.dir
 
 	// Create chunks directory
 	chunksDir := filepath.Join(blockDir, ChunksDir)
@@ -264,6 +403,8 @@ func (b *Block) Persist(dataDir string) error {
 	// Write chunks and build seriesChunks mapping
 	chunkNum := 1
 	seriesChunksMap := make(map[string]int)
+	var collisions map[string]map[string]string
+	bloom := NewBloomFilter(len(b.chunks), DefaultBloomFalsePositiveRate)
 	for seriesHash, chunk := range b.chunks {
 		chunkFile := filepath.Join(chunksDir, fmt.Sprintf("%06d", chunkNum))
 		f, err := os.Create(chunkFile)
@@ -275,16 +416,36 @@ func (b *Block) Persist(dataDir string) error {
 			f.Close()
 			return fmt.Errorf("failed to write chunk: %w", err)
 		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to sync chunk: %w", err)
+		}
 
 		f.Close()
 
 		// Store mapping for lazy loading
 		b.seriesChunks[seriesHash] = chunkNum
 		seriesChunksMap[fmt.Sprintf("%d", seriesHash)] = chunkNum
+		bloom.Add(seriesHash)
+
+		// Record collision hashes, for the series to get them again once
+		// the head that resolved them is gone
+		if s, ok := b.series[seriesHash]; ok && len(s.Labels) > 0 && series.NewSeries(s.Labels).Hash != seriesHash {
+			if collisions == nil {
+				collisions = make(map[string]map[string]string)
+			}
+			collisions[fmt.Sprintf("%d", seriesHash)] = s.Labels
+		}
 
 		chunkNum++
 	}
 
+	// Index the series under their chunk numbers
+	indexWriter, err := b.buildIndex()
+	if err != nil {
+		return err
+	}
+
 	// Update series count
 	b.NumSeries = int64(len(b.series))
 
@@ -299,7 +460,12 @@ func (b *Block) Persist(dataDir string) error {
 			NumChunks:  b.NumChunks,
 		},
 		Version:      BlockVersion,
+		IndexVersion: index.IndexFormatVersion,
+		Level:        &b.Level,
+		Labels:       b.Labels,
+		Precision:    b.Precision,
 		SeriesChunks: seriesChunksMap,
+		Collisions:   collisions,
 	}
 
 	metaData, err := json.MarshalIndent(meta, "", "  ")
@@ -308,20 +474,306 @@ func (b *Block) Persist(dataDir string) error {
 	}
 
 	metaPath := filepath.Join(blockDir, MetaFile)
-	if err := os.WriteFile(metaPath, metaData, 0644); err != nil {
+	if err := writeFileAtomic(metaPath, metaData); err != nil {
 		return fmt.Errorf("failed to write metadata: %w", err)
 	}
 
-	// Create placeholder index file (will be implemented in Phase 4)
-	indexPath := filepath.Join(blockDir, IndexFile)
-	if err := os.WriteFile(indexPath, []byte{}, 0644); err != nil {
-		return fmt.Errorf("failed to create index file: %w", err)
+	// Write inverted index
+	if err := indexWriter.WriteFile(filepath.Join(blockDir, IndexFile)); err != nil {
+		return err
+	}
+
+	// Write bloom filter
+	bloomData, err := bloom.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed to marshal bloom filter: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(blockDir, BloomFile), bloomData); err != nil {
+		return fmt.Errorf("failed to write bloom filter: %w", err)
+	}
+
+	// Make the new files and the block directory itself durable
+	for _, dir := range []string{chunksDir, blockDir, dataDir} {
+		if err := syncDir(dir); err != nil {
+			return fmt.Errorf("failed to sync block directory: %w", err)
+		}
 	}
 
+	b.index = indexWriter.Index()
+	b.bloom = bloom
 	b.dir = blockDir
 	return nil
 }
 
+// buildIndex indexes the block's series under their chunk numbers. The
+// indexes of b.indexSources are merged, with their chunk numbers remapped
+// to the block's, so series the block doesn't hold are dropped; series
+// found in none of them are indexed by their labels. b.mu must be held.
+func (b *Block) buildIndex() (*index.IndexWriter, error) {
+	w := index.NewIndexWriter()
+	merged := make(map[uint64]bool, len(b.seriesChunks))
+	for _, src := range b.indexSources {
+		src.mu.RLock()
+		remap := make(map[uint32]uint32)
+		hashes := make(map[uint32]uint64)
+		for hash, num := range src.seriesChunks {
+			if to, ok := b.seriesChunks[hash]; ok && !merged[hash] {
+				remap[uint32(num)] = uint32(to)
+				hashes[uint32(num)] = hash
+			}
+		}
+		src.mu.RUnlock()
+		if len(remap) == 0 {
+			continue
+		}
+
+		err := src.withIndex(func(idx index.Reader) error {
+			// Early blocks have an empty index; their series are indexed
+			// by label below
+			all := idx.All()
+			for num := range remap {
+				if !all.Contains(num) {
+					delete(remap, num)
+				}
+			}
+			_, err := w.Merge(idx, remap)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge index of block %s: %w", src.ULID, err)
+		}
+		for num := range remap {
+			merged[hashes[num]] = true
+		}
+	}
+
+	for hash, num := range b.seriesChunks {
+		if s, ok := b.series[hash]; ok && !merged[hash] && len(s.Labels) > 0 {
+			if err := w.AddSeries(series.SeriesID(num), s.Labels); err != nil {
+				return nil, fmt.Errorf("failed to index series: %w", err)
+			}
+		}
+	}
+	return w, nil
+}
+
+// LookupSeries returns the hashes of all series in the block matching the matchers.
+// The metric name is resolved first via the index's __name__ postings.
+func (b *Block) LookupSeries(matchers index.Matchers) ([]uint64, error) {
+	var hashes []uint64
+	err := b.withIndex(func(idx index.Reader) error {
+		var ids *roaring.Bitmap
+		var err error
+		if len(matchers) == 0 {
+			ids = idx.All()
+		} else if ids, err = idx.Lookup(matchers); err != nil {
+			return err
+		}
+
+		hashes = make([]uint64, 0, ids.GetCardinality())
+		for hash, chunkNum := range b.seriesChunks {
+			if ids.Contains(uint32(chunkNum)) {
+				hashes = append(hashes, hash)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	return hashes, nil
+}
+
+// LabelNames returns the label names of the block's series matching any
+// of the matcher sets, sorted; with no matcher sets, those of all series
+func (b *Block) LabelNames(matcherSets ...index.Matchers) ([]string, error) {
+	var names []string
+	err := b.withIndex(func(idx index.Reader) error {
+		if len(matcherSets) == 0 {
+			names = idx.LabelNames()
+			return nil
+		}
+		ids, err := index.MatchingPostings(idx, matcherSets...)
+		if err != nil {
+			return err
+		}
+		names, err = index.LabelNamesOf(idx, ids)
+		return err
+	})
+	return names, err
+}
+
+// LabelValues returns the values of a label across the block's series
+// matching any of the matcher sets, sorted; with no matcher sets, across
+// all series
+func (b *Block) LabelValues(name string, matcherSets ...index.Matchers) ([]string, error) {
+	var values []string
+	err := b.withIndex(func(idx index.Reader) error {
+		if len(matcherSets) == 0 {
+			values = idx.LabelValues(name)
+			return nil
+		}
+		ids, err := index.MatchingPostings(idx, matcherSets...)
+		if err != nil {
+			return err
+		}
+		values, err = index.LabelValuesOf(idx, name, ids)
+		return err
+	})
+	return values, err
+}
+
+// SeriesLabels returns the label sets of the block's series matching any of
+// the matcher sets; with no matcher sets all series are returned. Label sets
+// are rebuilt from the index postings, so this reads every posting list.
+func (b *Block) SeriesLabels(matcherSets ...index.Matchers) ([]map[string]string, error) {
+	var result []map[string]string
+	err := b.eachSeries(matcherSets, func(_ uint32, labels map[string]string) {
+		result = append(result, labels)
+	})
+	return result, err
+}
+
+// Series is like SeriesLabels, returning each series with the hash it is
+// stored under in the block. That is the hash of its labels, unless it
+// was given a series.CollisionHash when written.
+func (b *Block) Series(matcherSets ...index.Matchers) ([]*series.Series, error) {
+	var result []*series.Series
+	var hashes map[uint32]uint64
+	err := b.eachSeries(matcherSets, func(id uint32, labels map[string]string) {
+		if hashes == nil {
+			hashes = make(map[uint32]uint64, len(b.seriesChunks))
+			for hash, chunkNum := range b.seriesChunks {
+				hashes[uint32(chunkNum)] = hash
+			}
+		}
+		if hash, ok := hashes[id]; ok {
+			result = append(result, &series.Series{Labels: labels, Hash: hash})
+		} else {
+			result = append(result, series.NewSeries(labels))
+		}
+	})
+	return result, err
+}
+
+// eachSeries calls fn with the index ID and label set of each series
+// matching any of the matcher sets, in ID order, holding the read lock
+func (b *Block) eachSeries(matcherSets []index.Matchers, fn func(id uint32, labels map[string]string)) error {
+	return b.withIndex(func(idx index.Reader) error {
+		ids, err := index.MatchingPostings(idx, matcherSets...)
+		if err != nil {
+			return err
+		}
+		if ids.IsEmpty() {
+			return nil
+		}
+
+		byID := make(map[uint32]map[string]string, ids.GetCardinality())
+		for _, name := range idx.LabelNames() {
+			for _, value := range idx.LabelValues(name) {
+				postings, err := idx.Lookup(index.Matchers{index.MustNewMatcher(index.MatchEqual, name, value)})
+				if err != nil {
+					return err
+				}
+				postings.And(ids)
+				it := postings.Iterator()
+				for it.HasNext() {
+					id := it.Next()
+					if byID[id] == nil {
+						byID[id] = make(map[string]string)
+					}
+					byID[id][name] = value
+				}
+			}
+		}
+
+		it := ids.Iterator()
+		for it.HasNext() {
+			id := it.Next()
+			if labels, ok := byID[id]; ok {
+				fn(id, labels)
+			}
+		}
+		return nil
+	})
+}
+
+// withIndex calls fn with the block's index, holding the read lock so
+// Close can't unmap it while in use
+func (b *Block) withIndex(fn func(idx index.Reader) error) error {
+	if _, err := b.Index(); err != nil {
+		return err
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.index == nil {
+		return fmt.Errorf("block index closed")
+	}
+	return fn(b.index)
+}
+
+// Index returns the block's index, opening it from disk on first use.
+// Current index files are memory-mapped and posting lists are decoded on
+// demand; legacy v1 files are loaded into memory in full.
+func (b *Block) Index() (index.Reader, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.index != nil {
+		return b.index, nil
+	}
+	if b.dir == "" {
+		return nil, fmt.Errorf("block not persisted to disk")
+	}
+
+	indexPath := filepath.Join(b.dir, IndexFile)
+	mapped, err := index.OpenMappedIndex(indexPath)
+	if err == nil {
+		b.index = mapped
+		return mapped, nil
+	}
+	if !errors.Is(err, index.ErrLegacyIndexFormat) {
+		return nil, fmt.Errorf("failed to open index: %w", err)
+	}
+
+	f, err := os.Open(indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index: %w", err)
+	}
+	defer f.Close()
+
+	idx := index.NewInvertedIndex()
+	if _, err := idx.ReadFrom(f); err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	b.index = idx
+	return idx, nil
+}
+
+// Close releases the block's index. The block can still be used; the
+// index is reopened on next use.
+func (b *Block) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.closeIndex()
+}
+
+// closeIndex unmaps the index if it is memory-mapped. Must be called with lock held.
+func (b *Block) closeIndex() error {
+	mapped, ok := b.index.(*index.MappedIndex)
+	b.index = nil
+	if !ok {
+		return nil
+	}
+	return mapped.Close()
+}
+
 // Delete removes the block from disk
 func (b *Block) Delete() error {
 	b.mu.Lock()
@@ -331,6 +783,10 @@ func (b *Block) Delete() error {
 		return fmt.Errorf("block not persisted to disk")
 	}
 
+	if err := b.closeIndex(); err != nil {
+		return fmt.Errorf("failed to close index: %w", err)
+	}
+
 	return os.RemoveAll(b.dir)
 }
 
@@ -364,22 +820,88 @@ func (b *Block) Overlaps(minTime, maxTime int64) bool {
 	return b.MinTime <= maxTime && b.MaxTime >= minTime
 }
 
-// Size returns the approximate size of the block in bytes
+// Size returns the approximate size of the block in bytes. Chunks not
+// loaded are sized from their files.
 func (b *Block) Size() int64 {
+	var size int64
+	for _, chunkSize := range b.seriesSizes() {
+		size += chunkSize
+	}
+	return size
+}
+
+// seriesSizes returns the approximate encoded size of each series in the
+// block, keyed by series hash. Chunks not loaded are sized from their files.
+func (b *Block) seriesSizes() map[uint64]int64 {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
-	var size int64
-	for _, chunk := range b.chunks {
-		size += int64(chunk.Size())
+	sizes := make(map[uint64]int64, len(b.seriesChunks)+len(b.chunks))
+	for hash, chunk := range b.chunks {
+		sizes[hash] = int64(chunk.Size())
 	}
-	return size
+	for hash, chunkNum := range b.seriesChunks {
+		if _, ok := sizes[hash]; ok {
+			continue
+		}
+		sizes[hash] = 0
+		if info, err := os.Stat(chunkPath(b.dir, chunkNum)); err == nil {
+			sizes[hash] = info.Size()
+		}
+	}
+	return sizes
+}
+
+// seriesSet returns the block's series keyed by hash. Blocks opened from
+// disk keep no series in memory, so their label sets are rebuilt from the
+// index. A series missing from the index is an error, since callers such
+// as compaction would otherwise drop its samples.
+func (b *Block) seriesSet() (map[uint64]*series.Series, error) {
+	b.mu.RLock()
+	result := make(map[uint64]*series.Series, len(b.seriesChunks))
+	for hash, s := range b.series {
+		result[hash] = s
+	}
+	complete := true
+	for hash := range b.seriesChunks {
+		if _, ok := result[hash]; !ok {
+			complete = false
+			break
+		}
+	}
+	b.mu.RUnlock()
+
+	if complete {
+		return result, nil
+	}
+
+	indexed, err := b.Series()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read series labels: %w", err)
+	}
+	for _, s := range indexed {
+		result[s.Hash] = s
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for hash := range b.seriesChunks {
+		if _, ok := result[hash]; !ok {
+			return nil, fmt.Errorf("series %d has no labels in the block index", hash)
+		}
+	}
+	return result, nil
 }
 
 // BlockWriter helps write MemTable data to blocks
 type BlockWriter struct {
 	dataDir       string
 	blockDuration time.Duration
+	source        string            // BlockLabelSource of written blocks
+	labels        map[string]string // Extra labels of written blocks
+
+	// Creates block directories; nil for mkdirNew
+	mkdir func(dir string) (bool, error)
 }
 
 // NewBlockWriter creates a new block writer
@@ -387,9 +909,17 @@ func NewBlockWriter(dataDir string) *BlockWriter {
 	return &BlockWriter{
 		dataDir:       dataDir,
 		blockDuration: DefaultBlockDuration,
+		source:        BlockSourceFlush,
 	}
 }
 
+// SetLabels sets the source recorded in the blocks written, and labels to
+// record besides the level, source and version
+func (bw *BlockWriter) SetLabels(source string, labels map[string]string) {
+	bw.source = source
+	bw.labels = labels
+}
+
 // WriteMemTable writes a MemTable to disk as a block
 func (bw *BlockWriter) WriteMemTable(mt *MemTable) (*Block, error) {
 	minTime, maxTime := mt.TimeRange()
@@ -402,44 +932,61 @@ func (bw *BlockWriter) WriteMemTable(mt *MemTable) (*Block, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create block: %w", err)
 	}
-
-	// Get all series from MemTable
-	allSeriesHashes := mt.AllSeries()
-
-	// Add each series to the block
-	for _, hash := range allSeriesHashes {
-		// Get series metadata
-		s, ok := mt.GetSeries(hash)
-		if !ok {
-			continue
+	block.Labels = blockLabels(bw.labels, Level0, bw.source)
+
+	// Size the block's maps once rather than growing them series by series
+	n := mt.SeriesCount()
+	block.chunks = make(map[uint64]*Chunk, n)
+	block.series = make(map[uint64]*series.Series, n)
+	block.seriesChunks = make(map[uint64]int, n)
+
+	// Merge all MemTable shards into the block
+	err = mt.Each(func(s *series.Series, samples []series.Sample) error {
+		if len(samples) == 0 {
+			return nil
 		}
-
-		// Query samples for this series
-		samples, err := mt.Query(hash, minTime, maxTime)
-		if err != nil {
-			return nil, fmt.Errorf("failed to query series %d: %w", hash, err)
+		if err := block.AddSeries(s, samples); err != nil {
+			return fmt.Errorf("failed to add series to block: %w", err)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		if len(samples) > 0 {
-			if err := block.AddSeries(s, samples); err != nil {
-				return nil, fmt.Errorf("failed to add series to block: %w", err)
-			}
+	// Persist block to disk, removing what was written if that fails
+	if bw.mkdir != nil {
+		if err := block.createDir(bw.dataDir, bw.mkdir); err != nil {
+			return nil, fmt.Errorf("failed to persist block: %w", err)
 		}
 	}
-
-	// Persist block to disk
 	if err := block.Persist(bw.dataDir); err != nil {
+		if dir := block.Dir(); dir != "" {
+			os.RemoveAll(dir)
+		}
 		return nil, fmt.Errorf("failed to persist block: %w", err)
 	}
 
 	return block, nil
 }
 
-// BlockReader helps read blocks from disk
+// BlockReader helps read blocks from disk. Opened blocks are cached: a
+// block is only reopened when its meta.json changes, and the directories
+// are only listed again when they change or Invalidate is called.
 type BlockReader struct {
 	dataDir string
+	coldDir string // Second tier of blocks; empty if none
 	blocks  []*Block
 	mu      sync.RWMutex
+
+	// Modification times of the directories at the last listing, and of
+	// the meta.json of each loaded block, by block directory
+	dirMods  map[string]time.Time
+	metaMods map[string]time.Time
+	stale    bool // Set by Invalidate
+
+	// Reports whether a block directory may be loaded; nil loads all
+	visible func(dir string) bool
 }
 
 // NewBlockReader creates a new block reader
@@ -450,44 +997,93 @@ func NewBlockReader(dataDir string) *BlockReader {
 	}
 }
 
-// LoadBlocks loads all blocks from the data directory
+// Invalidate makes the next LoadBlocks list the directories again. Writers
+// call it after adding or removing blocks, in case the change fell within
+// the timestamp granularity of the filesystem.
+func (br *BlockReader) Invalidate() {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	br.stale = true
+}
+
+// LoadBlocks syncs the loaded blocks with the data directory and the cold
+// directory: new blocks are opened, known ones kept and deleted ones
+// dropped. A block moved to the cold directory is reopened there, as is a
+// block whose meta.json was rewritten. Nothing is read while neither
+// directory changed since the last call.
 func (br *BlockReader) LoadBlocks() error {
 	br.mu.Lock()
 	defer br.mu.Unlock()
 
+	dirMods := make(map[string]time.Time, 2)
+	for _, dir := range []string{br.dataDir, br.coldDir} {
+		if dir == "" {
+			continue
+		}
+		info, err := os.Stat(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read data directory: %w", err)
+		}
+		if err == nil {
+			dirMods[dir] = info.ModTime()
+		}
+	}
+	if !br.stale && br.dirMods != nil && maps.Equal(dirMods, br.dirMods) {
+		return nil
+	}
+
 	// List block directories
-	entries, err := os.ReadDir(br.dataDir)
+	dirs, err := blockDirs(br.dataDir, br.coldDir)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil // No blocks yet
-		}
-		return fmt.Errorf("failed to read data directory: %w", err)
+		return err
+	}
+
+	// Blocks already loaded are kept rather than reopened
+	loaded := make(map[string]*Block, len(br.blocks))
+	for _, block := range br.blocks {
+		loaded[block.Dir()] = block
 	}
 
-	for _, entry := range entries {
-		if !entry.IsDir() {
+	blocks := make([]*Block, 0, len(dirs))
+	metaMods := make(map[string]time.Time, len(dirs))
+	for _, dir := range dirs {
+		if br.visible != nil && !br.visible(dir) {
 			continue
 		}
+		info, err := os.Stat(filepath.Join(dir, MetaFile))
+		if err != nil {
+			return fmt.Errorf("failed to open block %s: %w", filepath.Base(dir), err)
+		}
+		metaMods[dir] = info.ModTime()
 
-		// Check if it's a valid ULID
-		if _, err := ulid.Parse(entry.Name()); err != nil {
-			continue // Skip non-ULID directories
+		if block, ok := loaded[dir]; ok && info.ModTime().Equal(br.metaMods[dir]) {
+			blocks = append(blocks, block)
+			delete(loaded, dir)
+			continue
 		}
 
 		// Open block
-		blockDir := filepath.Join(br.dataDir, entry.Name())
-		block, err := OpenBlock(blockDir)
+		block, err := OpenBlock(dir)
 		if err != nil {
-			return fmt.Errorf("failed to open block %s: %w", entry.Name(), err)
+			return fmt.Errorf("failed to open block %s: %w", filepath.Base(dir), err)
 		}
 
-		br.blocks = append(br.blocks, block)
+		blocks = append(blocks, block)
+	}
+
+	// Release blocks that no longer exist on disk, or were reopened
+	for _, block := range loaded {
+		block.Close()
 	}
 
 	// Sort blocks by time (ULID is time-sortable)
-	sort.Slice(br.blocks, func(i, j int) bool {
-		return br.blocks[i].ULID.Time() < br.blocks[j].ULID.Time()
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].ULID.Time() < blocks[j].ULID.Time()
 	})
+	br.blocks = blocks
+	br.dirMods = dirMods
+	br.metaMods = metaMods
+	br.stale = false
 
 	return nil
 }
@@ -499,9 +1095,9 @@ func (br *BlockReader) Query(seriesHash uint64, minTime, maxTime int64) ([]serie
 
 	var result []series.Sample
 
-	// Query each overlapping block
+	// Query each overlapping block that may hold the series
 	for _, block := range br.blocks {
-		if !block.Overlaps(minTime, maxTime) {
+		if !block.Overlaps(minTime, maxTime) || !block.MayContainSeries(seriesHash) {
 			continue
 		}
 
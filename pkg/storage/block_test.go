@@ -4,7 +4,9 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
 	"github.com/therealutkarshpriyadarshi/time/pkg/series"
 )
 
@@ -57,6 +59,67 @@ func TestBlockCreateAndPersist(t *testing.T) {
 	}
 }
 
+// TestBlockLookupSeries tests resolving matchers through the persisted block index
+func TestBlockLookupSeries(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	block, err := NewBlock(1000, 10000)
+	if err != nil {
+		t.Fatalf("NewBlock failed: %v", err)
+	}
+
+	cpu1 := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "server1"})
+	cpu2 := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "server2"})
+	mem := series.NewSeries(map[string]string{"__name__": "memory_usage", "host": "server1"})
+
+	for _, s := range []*series.Series{cpu1, cpu2, mem} {
+		if err := block.AddSeries(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+			t.Fatalf("AddSeries failed: %v", err)
+		}
+	}
+
+	if err := block.Persist(tmpDir); err != nil {
+		t.Fatalf("Persist failed: %v", err)
+	}
+
+	// Reopen so the index is read back from disk
+	opened, err := OpenBlock(filepath.Join(tmpDir, block.ULID.String()))
+	if err != nil {
+		t.Fatalf("OpenBlock failed: %v", err)
+	}
+
+	hashes, err := opened.LookupSeries(index.Matchers{
+		index.MustNewMatcher(index.MatchEqual, "__name__", "cpu_usage"),
+		index.MustNewMatcher(index.MatchNotEqual, "host", "server2"),
+	})
+	if err != nil {
+		t.Fatalf("LookupSeries failed: %v", err)
+	}
+	if len(hashes) != 1 || hashes[0] != cpu1.Hash {
+		t.Errorf("LookupSeries = %v, want [%d]", hashes, cpu1.Hash)
+	}
+
+	all, err := opened.LookupSeries(nil)
+	if err != nil {
+		t.Fatalf("LookupSeries failed: %v", err)
+	}
+	if len(all) != 3 {
+		t.Errorf("LookupSeries(nil) returned %d series, want 3", len(all))
+	}
+
+	// Persisted indexes are memory-mapped rather than decoded up front
+	idx, err := opened.Index()
+	if err != nil {
+		t.Fatalf("Index failed: %v", err)
+	}
+	if _, ok := idx.(*index.MappedIndex); !ok {
+		t.Errorf("Index() = %T, want *index.MappedIndex", idx)
+	}
+	if err := opened.Delete(); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+}
+
 // TestBlockOpenAndLoad tests opening a persisted block
 func TestBlockOpenAndLoad(t *testing.T) {
 	// Create temporary directory
@@ -333,6 +396,79 @@ func TestBlockReaderLoadBlocks(t *testing.T) {
 	}
 }
 
+// TestBlockReaderCache tests that loaded blocks are kept until their
+// directory or meta.json changes
+func TestBlockReaderCache(t *testing.T) {
+	tmpDir := t.TempDir()
+	writer := NewBlockWriter(tmpDir)
+	write := func(ts int64) *Block {
+		mt := NewMemTable()
+		mt.Insert(series.NewSeries(map[string]string{"__name__": "metric"}), []series.Sample{{Timestamp: ts, Value: 1}})
+		block, err := writer.WriteMemTable(mt)
+		if err != nil {
+			t.Fatalf("WriteMemTable failed: %v", err)
+		}
+		return block
+	}
+	write(1000)
+
+	reader := NewBlockReader(tmpDir)
+	if err := reader.LoadBlocks(); err != nil {
+		t.Fatalf("LoadBlocks failed: %v", err)
+	}
+	first := reader.Blocks()[0]
+
+	// A new block is found without Invalidate; the known one is kept
+	second := write(2000)
+	if err := reader.LoadBlocks(); err != nil {
+		t.Fatalf("LoadBlocks failed: %v", err)
+	}
+	blocks := reader.Blocks()
+	if len(blocks) != 2 || blocks[0] != first {
+		t.Fatalf("got blocks %v, want the cached block and %s", blocks, second.ULID)
+	}
+
+	// A rewritten meta.json reopens the block
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(first.Dir(), MetaFile), later, later); err != nil {
+		t.Fatal(err)
+	}
+	reader.Invalidate()
+	if err := reader.LoadBlocks(); err != nil {
+		t.Fatalf("LoadBlocks failed: %v", err)
+	}
+	if blocks := reader.Blocks(); blocks[0] == first || blocks[0].ULID != first.ULID {
+		t.Error("block with a changed meta.json was not reopened")
+	}
+}
+
+// TestBlockLazyBloom tests that an unreadable bloom filter only disables
+// the filter
+func TestBlockLazyBloom(t *testing.T) {
+	block, err := NewBlock(1000, 10000)
+	if err != nil {
+		t.Fatalf("NewBlock failed: %v", err)
+	}
+	s := series.NewSeries(map[string]string{"__name__": "metric"})
+	if err := block.AddSeries(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("AddSeries failed: %v", err)
+	}
+	if err := block.Persist(t.TempDir()); err != nil {
+		t.Fatalf("Persist failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(block.Dir(), BloomFile), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err := OpenBlock(block.Dir())
+	if err != nil {
+		t.Fatalf("OpenBlock failed: %v", err)
+	}
+	if !opened.MayContainSeries(s.Hash + 1) {
+		t.Error("MayContainSeries = false without a readable filter")
+	}
+}
+
 // TestBlockReaderQuery tests querying across multiple blocks
 func TestBlockReaderQuery(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"strconv"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+)
+
+// Labels recorded in the meta.json of every block written, so blocks can
+// be told apart by where they came from. Other labels, such as the tenant,
+// are kept through compaction when every source block shares them.
+const (
+	// BlockLabelLevel is the compaction level of the block: 0 for flushed
+	// blocks, higher for merged ones
+	BlockLabelLevel = "level"
+
+	// BlockLabelSource is how the block was written: BlockSourceFlush,
+	// BlockSourceCompaction or BlockSourceBackfill
+	BlockLabelSource = "source"
+
+	// BlockLabelTenant is the tenant the block's data belongs to, set from
+	// Options.Tenant
+	BlockLabelTenant = "tenant"
+
+	// BlockLabelVersion is the Version of the release that wrote the block
+	BlockLabelVersion = "version"
+)
+
+// Values of BlockLabelSource
+const (
+	BlockSourceFlush      = "flush"      // Written from the head
+	BlockSourceCompaction = "compaction" // Merged from other blocks
+	BlockSourceBackfill   = "backfill"   // Written from samples restored from a backup's WAL
+)
+
+// Version is recorded as BlockLabelVersion in the blocks this process
+// writes. The tsdb command sets it to its build version.
+var Version = "dev"
+
+// blockLabels returns the labels of a block at level written by source,
+// on top of extra
+func blockLabels(extra map[string]string, level CompactionLevel, source string) map[string]string {
+	labels := make(map[string]string, len(extra)+3)
+	for name, value := range extra {
+		labels[name] = value
+	}
+	labels[BlockLabelLevel] = strconv.Itoa(int(level))
+	labels[BlockLabelSource] = source
+	labels[BlockLabelVersion] = Version
+	return labels
+}
+
+// mergedBlockLabels returns the labels of a block merged from blocks into
+// level: the labels all of them share, with those of the merge on top
+func mergedBlockLabels(blocks []*Block, level CompactionLevel) map[string]string {
+	var shared map[string]string
+	for i, block := range blocks {
+		if i == 0 {
+			shared = make(map[string]string, len(block.Labels))
+			for name, value := range block.Labels {
+				shared[name] = value
+			}
+			continue
+		}
+		for name, value := range shared {
+			if block.Labels[name] != value {
+				delete(shared, name)
+			}
+		}
+	}
+	return blockLabels(shared, level, BlockSourceCompaction)
+}
+
+// matchBlockLabels reports whether labels satisfy every matcher
+func matchBlockLabels(matchers index.Matchers, labels map[string]string) bool {
+	for _, m := range matchers {
+		if !m.MatchesLabels(labels) {
+			return false
+		}
+	}
+	return true
+}
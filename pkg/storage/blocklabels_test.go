@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// writeLabeledBlock flushes samples of s at ts into a block of dataDir
+// written by source with extra labels
+func writeLabeledBlock(t *testing.T, dataDir, source string, extra map[string]string, s *series.Series, ts int64, v float64) *Block {
+	t.Helper()
+	mt := NewMemTable()
+	if err := mt.Insert(s, []series.Sample{{Timestamp: ts, Value: v}}); err != nil {
+		t.Fatal(err)
+	}
+	bw := NewBlockWriter(dataDir)
+	bw.SetLabels(source, extra)
+	block, err := bw.WriteMemTable(mt)
+	if err != nil {
+		t.Fatalf("WriteMemTable failed: %v", err)
+	}
+	return block
+}
+
+func TestBlockLabelsPersisted(t *testing.T) {
+	dataDir := t.TempDir()
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	written := writeLabeledBlock(t, dataDir, BlockSourceFlush, map[string]string{BlockLabelTenant: "a"}, s, 1000, 1)
+
+	block, err := OpenBlock(written.Dir())
+	if err != nil {
+		t.Fatalf("OpenBlock failed: %v", err)
+	}
+	defer block.Close()
+
+	want := map[string]string{
+		BlockLabelLevel:   "0",
+		BlockLabelSource:  BlockSourceFlush,
+		BlockLabelTenant:  "a",
+		BlockLabelVersion: Version,
+	}
+	if len(block.Labels) != len(want) {
+		t.Errorf("labels %v, want %v", block.Labels, want)
+	}
+	for name, value := range want {
+		if block.Labels[name] != value {
+			t.Errorf("label %s is %q, want %q", name, block.Labels[name], value)
+		}
+	}
+	if block.Level != Level0 {
+		t.Errorf("level %d, want 0", block.Level)
+	}
+}
+
+func TestMergedBlockLabels(t *testing.T) {
+	blocks := []*Block{
+		{Labels: blockLabels(map[string]string{BlockLabelTenant: "a", "zone": "x"}, 0, BlockSourceFlush)},
+		{Labels: blockLabels(map[string]string{BlockLabelTenant: "a", "zone": "y"}, 0, BlockSourceBackfill)},
+	}
+	labels := mergedBlockLabels(blocks, 1)
+
+	if labels[BlockLabelTenant] != "a" {
+		t.Errorf("shared tenant label lost: %v", labels)
+	}
+	if _, ok := labels["zone"]; ok {
+		t.Errorf("label differing between sources kept: %v", labels)
+	}
+	if labels[BlockLabelSource] != BlockSourceCompaction || labels[BlockLabelLevel] != "1" {
+		t.Errorf("labels %v, want source compaction at level 1", labels)
+	}
+}
+
+func TestCompactionLabelsBlocks(t *testing.T) {
+	dataDir := t.TempDir()
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	base := time.Now().Add(-24 * time.Hour).Truncate(Level1Duration).UnixMilli()
+	window := Level0Duration.Milliseconds()
+
+	for i := int64(0); i < 4; i++ {
+		block, err := NewBlock(base+i*window, base+(i+1)*window-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.Labels = blockLabels(map[string]string{BlockLabelTenant: "a"}, 0, BlockSourceFlush)
+		if err := block.AddSeries(s, []series.Sample{{Timestamp: base + i*window, Value: float64(i)}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := block.Persist(dataDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	compactor := NewCompactor(DefaultCompactorOptions(dataDir))
+	defer compactor.Stop()
+	if err := compactor.CompactNow(); err != nil {
+		t.Fatalf("compaction failed: %v", err)
+	}
+
+	reader := NewBlockReader(dataDir)
+	if err := reader.LoadBlocks(); err != nil {
+		t.Fatal(err)
+	}
+	blocks := reader.Blocks()
+	if len(blocks) != 1 {
+		t.Fatalf("got %d blocks after compaction, want 1", len(blocks))
+	}
+	defer blocks[0].Close()
+
+	labels := blocks[0].Labels
+	if labels[BlockLabelSource] != BlockSourceCompaction || labels[BlockLabelTenant] != "a" {
+		t.Errorf("compacted block labels %v, want source compaction and tenant a", labels)
+	}
+	if blocks[0].Level != Level1 {
+		t.Errorf("compacted block at level %d, want %d", blocks[0].Level, Level1)
+	}
+}
+
+func TestRetentionRules(t *testing.T) {
+	dataDir := t.TempDir()
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	now := time.Now()
+	ts := func(age time.Duration) int64 { return now.Add(-age).UnixMilli() }
+
+	flushed := writeLabeledBlock(t, dataDir, BlockSourceFlush, nil, s, ts(10*24*time.Hour), 1)
+	backfilled := writeLabeledBlock(t, dataDir, BlockSourceBackfill, nil, s, ts(10*24*time.Hour), 2)
+	pinned := writeLabeledBlock(t, dataDir, BlockSourceFlush, map[string]string{BlockLabelTenant: "audit"}, s, ts(60*24*time.Hour), 3)
+
+	compactor := NewCompactor(DefaultCompactorOptions(dataDir))
+	defer compactor.Stop()
+	rm := NewRetentionManager(compactor, &RetentionManagerOptions{
+		Policy: RetentionPolicy{
+			MaxAge:  30 * 24 * time.Hour,
+			Enabled: true,
+			Rules: []BlockRetentionRule{
+				{Matchers: index.Matchers{index.MustNewMatcher(index.MatchEqual, BlockLabelTenant, "audit")}},
+				{Matchers: index.Matchers{index.MustNewMatcher(index.MatchEqual, BlockLabelSource, BlockSourceBackfill)}, MaxAge: 7 * 24 * time.Hour},
+			},
+		},
+		Interval: time.Hour,
+	})
+	defer rm.Stop()
+
+	report, err := rm.CalculateRetentionStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.BlocksEligibleForDeletion != 1 {
+		t.Errorf("%d blocks eligible for deletion, want 1", report.BlocksEligibleForDeletion)
+	}
+
+	if err := rm.CleanupNow(); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if _, err := os.Stat(backfilled.Dir()); !os.IsNotExist(err) {
+		t.Error("backfilled block older than its rule was kept")
+	}
+	if _, err := os.Stat(flushed.Dir()); err != nil {
+		t.Errorf("flushed block within the policy was deleted: %v", err)
+	}
+	if _, err := os.Stat(pinned.Dir()); err != nil {
+		t.Errorf("block kept forever by its rule was deleted: %v", err)
+	}
+}
+
+func TestBlockQuerierMatchesBlockLabels(t *testing.T) {
+	dataDir := t.TempDir()
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	writeLabeledBlock(t, dataDir, BlockSourceFlush, map[string]string{BlockLabelTenant: "a"}, s, 1000, 1)
+	writeLabeledBlock(t, dataDir, BlockSourceBackfill, map[string]string{BlockLabelTenant: "b"}, s, 2000, 2)
+
+	opts := DefaultOptions(dataDir)
+	opts.EnableRetention = false
+	opts.EnableCompaction = false
+	opts.Tenant = "a"
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+	if err := db.Insert(s, []series.Sample{{Timestamp: 3000, Value: 3}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		match string
+		want  []int64
+	}{
+		{`{tenant="a"}`, []int64{1000, 3000}},
+		{`{tenant="b"}`, []int64{2000}},
+		{`{source!="backfill"}`, []int64{1000, 3000}},
+		{`{tenant="c"}`, nil},
+	}
+	for _, tt := range tests {
+		matchers, err := index.ParseMatchers(tt.match)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, err := db.BlockQuerier(0, 5000, matchers)
+		if err != nil {
+			t.Fatalf("BlockQuerier failed: %v", err)
+		}
+		set, err := q.Select()
+		if err != nil {
+			t.Fatalf("Select failed: %v", err)
+		}
+		var got []int64
+		for set.Next() {
+			_, samples := set.At()
+			for _, sample := range samples {
+				got = append(got, sample.Timestamp)
+			}
+		}
+		if err := set.Err(); err != nil {
+			t.Fatal(err)
+		}
+		q.Close()
+
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got samples at %v, want %v", tt.match, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got samples at %v, want %v", tt.match, got, tt.want)
+				break
+			}
+		}
+	}
+}
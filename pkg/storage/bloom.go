@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+)
+
+const (
+	// BloomFile is the per-block bloom filter file name
+	BloomFile = "bloom"
+
+	// DefaultBloomFalsePositiveRate is the target false positive rate of block bloom filters
+	DefaultBloomFalsePositiveRate = 0.01
+
+	// bloomHeaderSize is the size of the serialized header (k + word count)
+	bloomHeaderSize = 8
+)
+
+// BloomFilter is a bloom filter over series hashes.
+//
+// Format:
+//
+//	[4 bytes: number of hash functions]
+//	[4 bytes: number of 64-bit words]
+//	[8 bytes × words: bit array]
+//	[4 bytes: CRC32 checksum of everything before]
+type BloomFilter struct {
+	bits []uint64
+	k    uint32
+}
+
+// NewBloomFilter creates a bloom filter sized for n entries at the given false positive rate
+func NewBloomFilter(n int, fpRate float64) *BloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = DefaultBloomFalsePositiveRate
+	}
+
+	// Optimal size m = -n ln(p) / (ln 2)^2 and hash count k = m/n ln 2
+	m := math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	words := int(math.Ceil(m / 64))
+	k := uint32(math.Round(float64(words*64) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return &BloomFilter{bits: make([]uint64, words), k: k}
+}
+
+// Add adds a series hash to the filter
+func (bf *BloomFilter) Add(hash uint64) {
+	h1, h2 := bloomHashes(hash)
+	m := uint64(len(bf.bits) * 64)
+	for i := uint32(0); i < bf.k; i++ {
+		bit := (h1 + uint64(i)*h2) % m
+		bf.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// MayContain reports whether the hash may have been added.
+// False means the hash was definitely never added.
+func (bf *BloomFilter) MayContain(hash uint64) bool {
+	h1, h2 := bloomHashes(hash)
+	m := uint64(len(bf.bits) * 64)
+	for i := uint32(0); i < bf.k; i++ {
+		bit := (h1 + uint64(i)*h2) % m
+		if bf.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHashes derives two independent hashes from a series hash for
+// double hashing. The series hash is remixed so filters don't depend on
+// how well its low bits are distributed.
+func bloomHashes(hash uint64) (uint64, uint64) {
+	h1 := splitmix64(hash)
+	h2 := splitmix64(h1) | 1 // odd, so probes cycle through all bits
+	return h1, h2
+}
+
+// splitmix64 is the SplitMix64 finalizer
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// MarshalBinary serializes the bloom filter
+func (bf *BloomFilter) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, bloomHeaderSize+len(bf.bits)*8+4)
+
+	binary.BigEndian.PutUint32(buf[0:4], bf.k)
+	binary.BigEndian.PutUint32(buf[4:8], uint32(len(bf.bits)))
+	for i, w := range bf.bits {
+		binary.BigEndian.PutUint64(buf[bloomHeaderSize+i*8:], w)
+	}
+
+	end := len(buf) - 4
+	binary.BigEndian.PutUint32(buf[end:], crc32.ChecksumIEEE(buf[:end]))
+	return buf, nil
+}
+
+// UnmarshalBinary deserializes the bloom filter
+func (bf *BloomFilter) UnmarshalBinary(data []byte) error {
+	if len(data) < bloomHeaderSize+4 {
+		return fmt.Errorf("bloom filter too short: %d bytes", len(data))
+	}
+
+	k := binary.BigEndian.Uint32(data[0:4])
+	words := binary.BigEndian.Uint32(data[4:8])
+	if k == 0 || words == 0 || len(data) != bloomHeaderSize+int(words)*8+4 {
+		return fmt.Errorf("bloom filter size mismatch")
+	}
+
+	end := len(data) - 4
+	if checksum := crc32.ChecksumIEEE(data[:end]); checksum != binary.BigEndian.Uint32(data[end:]) {
+		return fmt.Errorf("bloom filter checksum verification failed")
+	}
+
+	bf.k = k
+	bf.bits = make([]uint64, words)
+	for i := range bf.bits {
+		bf.bits[i] = binary.BigEndian.Uint64(data[bloomHeaderSize+i*8:])
+	}
+	return nil
+}
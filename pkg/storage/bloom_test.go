@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestBloomFilter(t *testing.T) {
+	const n = 10000
+	bf := NewBloomFilter(n, 0.01)
+
+	for i := uint64(0); i < n; i++ {
+		bf.Add(i * 7919)
+	}
+
+	// No false negatives
+	for i := uint64(0); i < n; i++ {
+		if !bf.MayContain(i * 7919) {
+			t.Fatalf("MayContain(%d) = false for added hash", i*7919)
+		}
+	}
+
+	// False positive rate close to target
+	falsePositives := 0
+	for i := uint64(0); i < n; i++ {
+		if bf.MayContain(i*7919 + 1) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / n; rate > 0.02 {
+		t.Errorf("false positive rate = %.4f, want <= 0.02", rate)
+	}
+}
+
+func TestBloomFilterMarshal(t *testing.T) {
+	bf := NewBloomFilter(100, 0.01)
+	bf.Add(42)
+	bf.Add(1 << 40)
+
+	data, err := bf.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	decoded := &BloomFilter{}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	if !decoded.MayContain(42) || !decoded.MayContain(1<<40) {
+		t.Error("decoded filter lost entries")
+	}
+
+	data[10] ^= 0xff
+	if err := decoded.UnmarshalBinary(data); err == nil {
+		t.Error("expected checksum error for corrupted filter")
+	}
+}
+
+func TestBlockBloomFilter(t *testing.T) {
+	dataDir := t.TempDir()
+
+	block, err := NewBlock(1000, 10000)
+	if err != nil {
+		t.Fatalf("NewBlock failed: %v", err)
+	}
+	present := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "server1"})
+	absent := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "server2"})
+	if err := block.AddSeries(present, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("AddSeries failed: %v", err)
+	}
+	if err := block.Persist(dataDir); err != nil {
+		t.Fatalf("Persist failed: %v", err)
+	}
+
+	opened, err := OpenBlock(block.Dir())
+	if err != nil {
+		t.Fatalf("OpenBlock failed: %v", err)
+	}
+	if !opened.MayContainSeries(present.Hash) {
+		t.Error("MayContainSeries = false for a series in the block")
+	}
+	if opened.MayContainSeries(absent.Hash) {
+		t.Error("MayContainSeries = true for a series not in the block")
+	}
+
+	// Blocks without a bloom file get one built from meta.json
+	if err := os.Remove(filepath.Join(block.Dir(), BloomFile)); err != nil {
+		t.Fatalf("failed to remove bloom file: %v", err)
+	}
+	opened, err = OpenBlock(block.Dir())
+	if err != nil {
+		t.Fatalf("OpenBlock without bloom file failed: %v", err)
+	}
+	if !opened.MayContainSeries(present.Hash) {
+		t.Error("rebuilt filter is missing a series in the block")
+	}
+
+	// BlockReader skips the block for unknown series and still finds known ones
+	reader := NewBlockReader(dataDir)
+	if err := reader.LoadBlocks(); err != nil {
+		t.Fatalf("LoadBlocks failed: %v", err)
+	}
+	samples, err := reader.Query(present.Hash, 0, 20000)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(samples) != 1 {
+		t.Errorf("Query returned %d samples, want 1", len(samples))
+	}
+	if samples, _ := reader.Query(absent.Hash, 0, 20000); len(samples) != 0 {
+		t.Errorf("Query for absent series returned %d samples", len(samples))
+	}
+}
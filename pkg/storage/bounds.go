@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+var (
+	// ErrSampleTooOld indicates a sample older than the retention horizon,
+	// which retention would delete as soon as it reached a block
+	ErrSampleTooOld = tsdberrors.New(tsdberrors.Invalid, "sample older than the retention horizon")
+
+	// ErrSampleTooNew indicates a sample further ahead of the clock than
+	// Options.MaxFutureSkew allows
+	ErrSampleTooNew = tsdberrors.New(tsdberrors.Invalid, "sample too far in the future")
+)
+
+// RejectedSample is a sample left out of a write for being outside the
+// timestamp bounds
+type RejectedSample struct {
+	Series    *series.Series
+	Timestamp int64
+	Err       error // ErrSampleTooOld or ErrSampleTooNew
+}
+
+// OutOfBoundsError reports the samples passed to TSDB.Insert outside the
+// timestamp bounds (see Options.RejectBeforeRetention and
+// Options.MaxFutureSkew). The other samples were written.
+type OutOfBoundsError struct {
+	Rejected []RejectedSample
+}
+
+func (e *OutOfBoundsError) Error() string {
+	first := e.Rejected[0]
+	return fmt.Sprintf("%d samples out of bounds, first: %v: series %s, timestamp %d",
+		len(e.Rejected), first.Err, first.Series, first.Timestamp)
+}
+
+// Unwrap returns the errors of the rejected samples, so errors.Is tells
+// whether any sample was too old or too new
+func (e *OutOfBoundsError) Unwrap() []error {
+	var errs []error
+	for _, r := range e.Rejected {
+		if !slices.Contains(errs, r.Err) {
+			errs = append(errs, r.Err)
+		}
+	}
+	return errs
+}
+
+// boundsError returns the error of a write rejecting samples, or nil if it
+// rejected none
+func boundsError(rejected []RejectedSample) error {
+	if len(rejected) == 0 {
+		return nil
+	}
+	return &OutOfBoundsError{Rejected: rejected}
+}
+
+// sampleBounds rejects samples older than the retention horizon or too far
+// ahead of the clock
+type sampleBounds struct {
+	maxAge  time.Duration // 0 for no lower bound
+	maxSkew time.Duration // 0 for no upper bound
+	now     func() time.Time
+}
+
+// newSampleBounds returns the bounds set by opts, or nil if there are none
+func newSampleBounds(opts *Options) *sampleBounds {
+	b := &sampleBounds{maxSkew: opts.MaxFutureSkew, now: time.Now}
+	if opts.RejectBeforeRetention {
+		b.maxAge = opts.RetentionPeriod
+	}
+	if b.maxAge == 0 && b.maxSkew == 0 {
+		return nil
+	}
+	return b
+}
+
+// check returns ErrSampleTooOld or ErrSampleTooNew if a sample at ts is
+// outside the bounds at the current time
+func (b *sampleBounds) check(ts int64) error {
+	if b == nil {
+		return nil
+	}
+	now := b.now().UnixMilli()
+	if b.maxAge > 0 && ts < now-b.maxAge.Milliseconds() {
+		return ErrSampleTooOld
+	}
+	if b.maxSkew > 0 && ts > now+b.maxSkew.Milliseconds() {
+		return ErrSampleTooNew
+	}
+	return nil
+}
+
+// filter returns the samples of s within the bounds, appending the others
+// to rejected. samples is not modified.
+func (b *sampleBounds) filter(s *series.Series, samples []series.Sample, rejected []RejectedSample) ([]series.Sample, []RejectedSample) {
+	if b == nil {
+		return samples, rejected
+	}
+
+	var kept []series.Sample // Copied on the first rejected sample
+	for i, sample := range samples {
+		err := b.check(sample.Timestamp)
+		if err == nil {
+			if kept != nil {
+				kept = append(kept, sample)
+			}
+			continue
+		}
+		if kept == nil {
+			kept = make([]series.Sample, i, len(samples))
+			copy(kept, samples[:i])
+		}
+		rejected = append(rejected, RejectedSample{Series: s, Timestamp: sample.Timestamp, Err: err})
+	}
+	if kept == nil {
+		return samples, rejected
+	}
+	return kept, rejected
+}
+
+// recordBounds counts a sample rejected with err in the TSDB statistics
+func (s *Stats) recordBounds(err error) {
+	if err == ErrSampleTooOld {
+		s.TooOldRejected.Add(1)
+	} else {
+		s.TooNewRejected.Add(1)
+	}
+}
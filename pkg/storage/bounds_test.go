@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// withBounds keeps 30 days and accepts samples up to a minute ahead of now
+func withBounds(opts *Options) {
+	opts.RetentionPeriod = 30 * 24 * time.Hour
+	opts.RejectBeforeRetention = true
+	opts.MaxFutureSkew = time.Minute
+}
+
+func TestInsertRejectsOutOfBoundsSamples(t *testing.T) {
+	now := time.UnixMilli(100 * 24 * time.Hour.Milliseconds())
+	db := openTestDB(t, withBounds)
+	db.bounds.now = func() time.Time { return now }
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+
+	old := now.Add(-31 * 24 * time.Hour).UnixMilli()
+	skewed := now.Add(2 * time.Minute).UnixMilli()
+	err := db.Insert(s, []series.Sample{
+		{Timestamp: old, Value: 1},
+		{Timestamp: now.UnixMilli(), Value: 2},
+		{Timestamp: skewed, Value: 3},
+		{Timestamp: now.Add(30 * time.Second).UnixMilli(), Value: 4},
+	})
+
+	var oob *OutOfBoundsError
+	if !errors.As(err, &oob) {
+		t.Fatalf("expected an OutOfBoundsError, got %v", err)
+	}
+	if len(oob.Rejected) != 2 || oob.Rejected[0].Timestamp != old || oob.Rejected[1].Timestamp != skewed {
+		t.Errorf("rejected %v, want the samples at %d and %d", oob.Rejected, old, skewed)
+	}
+	if !errors.Is(err, ErrSampleTooOld) || !errors.Is(err, ErrSampleTooNew) {
+		t.Errorf("error %v doesn't match both ErrSampleTooOld and ErrSampleTooNew", err)
+	}
+
+	// The samples within the bounds are written
+	samples, _ := db.Query(s.Hash, math.MinInt64, math.MaxInt64)
+	if len(samples) != 2 || samples[0].Value != 2 || samples[1].Value != 4 {
+		t.Errorf("stored %v, want the 2 samples within the bounds", samples)
+	}
+	stats := db.GetStatsSnapshot()
+	if stats.TooOldRejected != 1 || stats.TooNewRejected != 1 {
+		t.Errorf("counted %d too old and %d too new, want 1 each", stats.TooOldRejected, stats.TooNewRejected)
+	}
+
+	// A write entirely out of bounds writes nothing
+	if err := db.Insert(s, []series.Sample{{Timestamp: old, Value: 5}}); !errors.Is(err, ErrSampleTooOld) {
+		t.Errorf("expected ErrSampleTooOld, got %v", err)
+	}
+}
+
+func TestAppenderRejectsOutOfBoundsSamples(t *testing.T) {
+	now := time.UnixMilli(100 * 24 * time.Hour.Milliseconds())
+	db := openTestDB(t, withBounds)
+	db.bounds.now = func() time.Time { return now }
+	labels := map[string]string{"__name__": "cpu_usage"}
+
+	app := db.Appender()
+	if err := app.Append(labels, now.Add(time.Hour).UnixMilli(), 1); !errors.Is(err, ErrSampleTooNew) {
+		t.Errorf("expected ErrSampleTooNew, got %v", err)
+	}
+	if err := app.Append(labels, now.UnixMilli(), 2); err != nil {
+		t.Fatalf("append within the bounds failed: %v", err)
+	}
+	if err := app.Commit(); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+
+	samples, _ := db.Query(series.NewSeries(labels).Hash, math.MinInt64, math.MaxInt64)
+	if len(samples) != 1 || samples[0].Value != 2 {
+		t.Errorf("stored %v, want only the sample within the bounds", samples)
+	}
+}
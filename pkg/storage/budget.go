@@ -0,0 +1,330 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+)
+
+// RetentionBudget caps the series and samples kept in the blocks whose
+// labels match BlockMatchers, e.g. {tenant="a"}. When a cap is exceeded,
+// the oldest matching blocks are deleted until the newest ones fit.
+//
+// With SeriesMatchers set, e.g. {__name__=~"debug_.*"}, only the matching
+// series count against the caps, and the oldest blocks are rewritten
+// without them instead of deleted.
+type RetentionBudget struct {
+	BlockMatchers  index.Matchers
+	SeriesMatchers index.Matchers
+	MaxSeries      int64 // Distinct series; 0 for no limit
+	MaxSamples     int64 // 0 for no limit
+}
+
+// String formats the budget like the --retention-budget flag, e.g.
+// {tenant="a"}=series:1000,samples:1000000
+func (b RetentionBudget) String() string {
+	s := b.BlockMatchers.String()
+	if len(b.SeriesMatchers) > 0 {
+		s += b.SeriesMatchers.String()
+	}
+	s += "="
+	sep := ""
+	if b.MaxSeries > 0 {
+		s += fmt.Sprintf("series:%d", b.MaxSeries)
+		sep = ","
+	}
+	if b.MaxSamples > 0 {
+		s += fmt.Sprintf("%ssamples:%d", sep, b.MaxSamples)
+	}
+	return s
+}
+
+// validate checks that the budget sets a cap and none is negative
+func (b RetentionBudget) validate() error {
+	if b.MaxSeries < 0 || b.MaxSamples < 0 {
+		return fmt.Errorf("retention budget %s cannot be negative", b)
+	}
+	if b.MaxSeries == 0 && b.MaxSamples == 0 {
+		return fmt.Errorf("retention budget %s sets no limit", b.BlockMatchers)
+	}
+	return nil
+}
+
+// RetentionBudgetUsage reports what a budget counts and the blocks the
+// next cleanup deletes or rewrites to meet it
+type RetentionBudgetUsage struct {
+	Budget  RetentionBudget
+	Series  int64 // Distinct series counted against the budget
+	Samples int64 // Samples counted against the budget
+
+	// OverBudget lists the blocks beyond the budget, oldest first. Held
+	// blocks are left alone and not listed.
+	OverBudget []BlockSummary
+}
+
+// budgetAction is what a cleanup does to a block over a budget
+type budgetAction struct {
+	block *Block
+	drop  map[uint64]bool // Series to rewrite the block without; nil deletes it
+}
+
+// blockUsage is the part of a block that counts against a budget
+type blockUsage struct {
+	series  []uint64
+	samples int64
+	all     bool // Every series of the block counts
+}
+
+// planBudgets returns the usage of each budget over blocks and what to do
+// to the blocks over them. A block acted on for one budget is not counted
+// against the following ones; they see it after the cleanup.
+func planBudgets(budgets []RetentionBudget, blocks []*Block) ([]RetentionBudgetUsage, []budgetAction, error) {
+	// Newest first, so the newest blocks fill the budget
+	sorted := make([]*Block, len(blocks))
+	copy(sorted, blocks)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].MaxTime != sorted[j].MaxTime {
+			return sorted[i].MaxTime > sorted[j].MaxTime
+		}
+		return sorted[i].ULID.Compare(sorted[j].ULID) > 0
+	})
+
+	var usages []RetentionBudgetUsage
+	var actions []budgetAction
+	acted := make(map[*Block]bool)
+	for _, budget := range budgets {
+		usage := RetentionBudgetUsage{Budget: budget}
+		seen := make(map[uint64]bool)
+		over := false
+		var overBudget []BlockSummary
+
+		for _, block := range sorted {
+			if acted[block] || !matchBlockLabels(budget.BlockMatchers, block.Labels) {
+				continue
+			}
+			u, err := budgetUsage(budget, block)
+			if err != nil {
+				return nil, nil, fmt.Errorf("block %s: %w", block.ULID, err)
+			}
+			for _, hash := range u.series {
+				if !seen[hash] {
+					seen[hash] = true
+					usage.Series++
+				}
+			}
+			usage.Samples += u.samples
+
+			// Once a block exceeds the budget, it and every older block
+			// are over it
+			over = over || (budget.MaxSeries > 0 && usage.Series > budget.MaxSeries) ||
+				(budget.MaxSamples > 0 && usage.Samples > budget.MaxSamples)
+			if !over || len(u.series) == 0 || block.Protected() {
+				continue
+			}
+
+			action := budgetAction{block: block}
+			if !u.all {
+				action.drop = make(map[uint64]bool, len(u.series))
+				for _, hash := range u.series {
+					action.drop[hash] = true
+				}
+			}
+			actions = append(actions, action)
+			acted[block] = true
+			overBudget = append(overBudget, summarizeBlock(block))
+		}
+
+		// Oldest first, like the other retention listings
+		for i, j := 0, len(overBudget)-1; i < j; i, j = i+1, j-1 {
+			overBudget[i], overBudget[j] = overBudget[j], overBudget[i]
+		}
+		usage.OverBudget = overBudget
+		usages = append(usages, usage)
+	}
+	return usages, actions, nil
+}
+
+// budgetUsage returns the series and samples of block counting against
+// budget
+func budgetUsage(budget RetentionBudget, block *Block) (blockUsage, error) {
+	var all []uint64
+	for hash := range block.seriesSizes() {
+		all = append(all, hash)
+	}
+	if len(budget.SeriesMatchers) == 0 {
+		return blockUsage{series: all, samples: block.NumSamples, all: true}, nil
+	}
+
+	hashes, err := block.LookupSeries(budget.SeriesMatchers)
+	if err != nil {
+		return blockUsage{}, err
+	}
+	u := blockUsage{series: hashes, all: len(hashes) == len(all)}
+	for _, hash := range hashes {
+		n, err := block.seriesNumSamples(hash)
+		if err != nil {
+			return blockUsage{}, err
+		}
+		u.samples += n
+	}
+	return u, nil
+}
+
+// seriesNumSamples returns the number of samples of a series in the block,
+// read from its chunk header without decoding the samples
+func (b *Block) seriesNumSamples(seriesHash uint64) (int64, error) {
+	b.mu.RLock()
+	chunk, loaded := b.chunks[seriesHash]
+	chunkNum, exists := b.seriesChunks[seriesHash]
+	dir := b.dir
+	b.mu.RUnlock()
+
+	if loaded {
+		return int64(chunk.NumSamples), nil
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	f, err := os.Open(chunkPath(dir, chunkNum))
+	if err != nil {
+		return 0, fmt.Errorf("failed to open chunk file: %w", err)
+	}
+	defer f.Close()
+
+	// The sample count follows the chunk's time range in its header
+	header := make([]byte, ChunkHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return 0, fmt.Errorf("failed to read chunk header: %w", err)
+	}
+	return int64(binary.BigEndian.Uint16(header[16:18])), nil
+}
+
+// budgetCleanup is the outcome of enforcing retention budgets
+type budgetCleanup struct {
+	deleted       int   // Blocks deleted
+	rewritten     int   // Blocks rewritten without some series
+	droppedSeries int64 // Series dropped by rewrites
+	purgedBefore  int64 // Newest end of the blocks acted on, exclusive
+}
+
+// enforceBudgets deletes or rewrites the blocks over the budgets. Blocks
+// are only rewritten while the compactor may write; otherwise they wait
+// for the next cleanup.
+func (c *Compactor) enforceBudgets(budgets []RetentionBudget) (budgetCleanup, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var result budgetCleanup
+	if err := c.blockReader.LoadBlocks(); err != nil {
+		return result, fmt.Errorf("failed to load blocks: %w", err)
+	}
+	_, actions, err := planBudgets(budgets, c.blockReader.Blocks())
+	if err != nil {
+		return result, err
+	}
+
+	for _, action := range actions {
+		block := action.block
+		if action.drop == nil {
+			size := block.Size()
+			if err := c.trashBlock(block); err != nil {
+				return result, fmt.Errorf("failed to delete block %s: %w", block.ULID, err)
+			}
+			result.deleted++
+			c.stats.BytesReclaimed.Add(size)
+		} else {
+			if c.readOnly.Load() {
+				continue
+			}
+			if err := c.rewriteBlock(block, action.drop); err != nil {
+				return result, fmt.Errorf("failed to rewrite block %s: %w", block.ULID, err)
+			}
+			result.rewritten++
+			result.droppedSeries += int64(len(action.drop))
+		}
+		result.purgedBefore = max(result.purgedBefore, block.MaxTime+1)
+	}
+	return result, nil
+}
+
+// rewriteBlock replaces block with a copy without the series in drop,
+// keeping its time range, level, labels, precision and directory. c.mu
+// must be held.
+func (c *Compactor) rewriteBlock(block *Block, drop map[uint64]bool) error {
+	defer c.blockReader.Invalidate()
+
+	seriesSet, err := block.seriesSet()
+	if err != nil {
+		return err
+	}
+
+	rewritten, err := NewBlock(block.MinTime, block.MaxTime)
+	if err != nil {
+		return fmt.Errorf("failed to create block: %w", err)
+	}
+	rewritten.Level = block.Level
+	rewritten.Labels = block.Labels
+	rewritten.Precision = block.Precision
+	rewritten.indexSources = []*Block{block}
+
+	// The copy stays hidden from queries until it replaces the block. It
+	// is written next to the block, so cold blocks stay cold.
+	tier := filepath.Dir(block.Dir())
+	var outputDirs []string
+	defer func() {
+		c.leases.commit(outputDirs, nil)
+	}()
+	if err := rewritten.createDir(tier, c.leases.create); err != nil {
+		return err
+	}
+	outputDirs = append(outputDirs, rewritten.Dir())
+
+	hashes := make([]uint64, 0, len(seriesSet))
+	for hash := range seriesSet {
+		if !drop[hash] {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	for _, hash := range hashes {
+		chunk, err := block.chunk(hash)
+		if err != nil {
+			return fmt.Errorf("failed to get series samples: %w", err)
+		}
+		if chunk == nil {
+			continue
+		}
+		samples, err := appendChunk(nil, chunk, math.MinInt64, math.MaxInt64)
+		if err != nil {
+			return fmt.Errorf("failed to get series samples: %w", err)
+		}
+		if len(samples) == 0 {
+			continue
+		}
+		if err := rewritten.AddSeries(seriesSet[hash], samples); err != nil {
+			return fmt.Errorf("failed to add series: %w", err)
+		}
+		// Pace the chunk read and the one written
+		if err := c.throttle(chunk.Size() + rewritten.chunks[hash].Size()); err != nil {
+			return err
+		}
+	}
+	if err := rewritten.Persist(tier); err != nil {
+		return fmt.Errorf("failed to persist block: %w", err)
+	}
+
+	size := block.Size() - rewritten.Size()
+	if err := c.leases.commit(outputDirs, []*Block{block}); err != nil {
+		return fmt.Errorf("failed to delete old block: %w", err)
+	}
+	outputDirs = nil
+	c.stats.BytesReclaimed.Add(max(size, 0))
+	return nil
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// writeTenantBlock flushes one sample at ts of each series into a block of
+// dataDir labeled with tenant
+func writeTenantBlock(t *testing.T, dataDir, tenant string, ts int64, set ...*series.Series) *Block {
+	t.Helper()
+	mt := NewMemTable()
+	for _, s := range set {
+		if err := mt.Insert(s, []series.Sample{{Timestamp: ts, Value: 1}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bw := NewBlockWriter(dataDir)
+	bw.SetLabels(BlockSourceFlush, map[string]string{BlockLabelTenant: tenant})
+	block, err := bw.WriteMemTable(mt)
+	if err != nil {
+		t.Fatalf("WriteMemTable failed: %v", err)
+	}
+	return block
+}
+
+// newBudgetManager returns a retention manager over dataDir enforcing
+// budgets on blocks younger than its maximum age
+func newBudgetManager(t *testing.T, dataDir string, budgets ...RetentionBudget) *RetentionManager {
+	t.Helper()
+	compactor := NewCompactor(DefaultCompactorOptions(dataDir))
+	t.Cleanup(func() { compactor.Stop() })
+	rm := NewRetentionManager(compactor, &RetentionManagerOptions{
+		Policy: RetentionPolicy{
+			MaxAge:  30 * 24 * time.Hour,
+			Enabled: true,
+			Budgets: budgets,
+		},
+		Interval: time.Hour,
+	})
+	t.Cleanup(func() { rm.Stop() })
+	return rm
+}
+
+func tenantMatchers(tenant string) index.Matchers {
+	return index.Matchers{index.MustNewMatcher(index.MatchEqual, BlockLabelTenant, tenant)}
+}
+
+func TestRetentionBudgetDeletesOldestBlocks(t *testing.T) {
+	dataDir := t.TempDir()
+	cpu := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	mem := series.NewSeries(map[string]string{"__name__": "memory_usage"})
+	now := time.Now().UnixMilli()
+
+	other := writeTenantBlock(t, dataDir, "b", now-4000, cpu, mem)
+	oldest := writeTenantBlock(t, dataDir, "a", now-3000, cpu, mem)
+	middle := writeTenantBlock(t, dataDir, "a", now-2000, cpu, mem)
+	newest := writeTenantBlock(t, dataDir, "a", now-1000, cpu, mem)
+
+	// The same two series in every block count once
+	rm := newBudgetManager(t, dataDir,
+		RetentionBudget{BlockMatchers: tenantMatchers("a"), MaxSeries: 2, MaxSamples: 4})
+
+	report, err := rm.CalculateRetentionStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Budgets) != 1 {
+		t.Fatalf("report has %d budgets, want 1", len(report.Budgets))
+	}
+	usage := report.Budgets[0]
+	if usage.Series != 2 || usage.Samples != 6 {
+		t.Errorf("budget counts %d series and %d samples, want 2 and 6", usage.Series, usage.Samples)
+	}
+	if len(usage.OverBudget) != 1 || usage.OverBudget[0].ULID != oldest.ULID.String() {
+		t.Errorf("over budget %v, want only block %s", usage.OverBudget, oldest.ULID)
+	}
+	if report.BlocksEligibleForDeletion != 1 {
+		t.Errorf("%d blocks eligible for deletion, want 1", report.BlocksEligibleForDeletion)
+	}
+
+	if err := rm.CleanupNow(); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if _, err := os.Stat(oldest.Dir()); !os.IsNotExist(err) {
+		t.Error("oldest block over the budget was kept")
+	}
+	for _, block := range []*Block{other, middle, newest} {
+		if _, err := os.Stat(block.Dir()); err != nil {
+			t.Errorf("block %s within its budget was deleted: %v", block.ULID, err)
+		}
+	}
+	if rm.PurgedBefore() != oldest.MaxTime+1 {
+		t.Errorf("purged before %d, want %d", rm.PurgedBefore(), oldest.MaxTime+1)
+	}
+}
+
+func TestRetentionBudgetRewritesSeries(t *testing.T) {
+	dataDir := t.TempDir()
+	cpu := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	debug := series.NewSeries(map[string]string{"__name__": "debug_requests"})
+	now := time.Now().UnixMilli()
+
+	older := writeTenantBlock(t, dataDir, "a", now-2000, cpu, debug)
+	newer := writeTenantBlock(t, dataDir, "a", now-1000, cpu, debug)
+
+	rm := newBudgetManager(t, dataDir, RetentionBudget{
+		BlockMatchers:  tenantMatchers("a"),
+		SeriesMatchers: index.Matchers{index.MustNewMatcher(index.MatchRegexp, "__name__", "debug_.*")},
+		MaxSamples:     1,
+	})
+	if err := rm.CleanupNow(); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+
+	if _, err := os.Stat(newer.Dir()); err != nil {
+		t.Errorf("newest block within the budget was rewritten: %v", err)
+	}
+	if _, err := os.Stat(older.Dir()); !os.IsNotExist(err) {
+		t.Error("block over the budget was not replaced")
+	}
+	if n := rm.GetStats().BlocksRewritten.Load(); n != 1 {
+		t.Errorf("%d blocks rewritten, want 1", n)
+	}
+	if n := rm.GetStats().SeriesGarbageCollected.Load(); n != 1 {
+		t.Errorf("%d series dropped, want 1", n)
+	}
+
+	reader := NewBlockReader(dataDir)
+	if err := reader.LoadBlocks(); err != nil {
+		t.Fatal(err)
+	}
+	blocks := reader.Blocks()
+	defer func() {
+		for _, block := range blocks {
+			block.Close()
+		}
+	}()
+	if len(blocks) != 2 {
+		t.Fatalf("got %d blocks, want 2", len(blocks))
+	}
+	for _, block := range blocks {
+		if block.ULID == newer.ULID {
+			continue
+		}
+		if block.Labels[BlockLabelTenant] != "a" || block.Level != Level0 {
+			t.Errorf("rewritten block lost its labels or level: %v, level %d", block.Labels, block.Level)
+		}
+		if samples, err := block.GetSeries(debug.Hash, math.MinInt64, math.MaxInt64); err != nil || len(samples) != 0 {
+			t.Errorf("rewritten block still holds %d debug samples (err %v)", len(samples), err)
+		}
+		if samples, err := block.GetSeries(cpu.Hash, math.MinInt64, math.MaxInt64); err != nil || len(samples) != 1 {
+			t.Errorf("rewritten block holds %d cpu samples (err %v), want 1", len(samples), err)
+		}
+	}
+}
+
+func TestRetentionBudgetSkipsHeldBlocks(t *testing.T) {
+	dataDir := t.TempDir()
+	cpu := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	now := time.Now().UnixMilli()
+
+	held := writeTenantBlock(t, dataDir, "a", now-2000, cpu)
+	writeTenantBlock(t, dataDir, "a", now-1000, cpu)
+	if err := os.WriteFile(filepath.Join(held.Dir(), NoDeleteFile), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rm := newBudgetManager(t, dataDir, RetentionBudget{BlockMatchers: tenantMatchers("a"), MaxSamples: 1})
+	if err := rm.CleanupNow(); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if _, err := os.Stat(held.Dir()); err != nil {
+		t.Errorf("held block over the budget was deleted: %v", err)
+	}
+}
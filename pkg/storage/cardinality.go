@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"cmp"
+	"math"
+	"slices"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// DefaultCardinalityValues is how many values of each label a cardinality
+// report lists by default
+const DefaultCardinalityValues = 10
+
+// CardinalityReport describes the series matching a set of matchers
+type CardinalityReport struct {
+	Series  int64              // Series matching
+	Samples int64              // Samples of those series; see TSDB.Cardinality
+	Labels  []LabelCardinality // Labels of those series, most distinct values first
+}
+
+// LabelCardinality is how the values of a label spread over the series
+// matching a cardinality report's matchers
+type LabelCardinality struct {
+	Name           string
+	Series         int64             // Matching series with the label
+	DistinctValues int               // Values among them, listed or not
+	Values         []LabelValueCount // Most series first
+}
+
+// LabelValueCount is the number of matching series with a label value
+type LabelValueCount struct {
+	Value  string
+	Series int64
+}
+
+// Cardinality counts the series matching matchers and breaks them down by
+// the values of each of their labels, like count by (label) for every
+// label. Labels fixed by an equality matcher are left out, so adding a
+// matcher on one of the values listed drills down into it. Only the head
+// and block indexes are read, never chunks.
+//
+// opts.Limit caps the values listed per label; 0 lists them all. Time
+// ranges are handled as in ListLabelNames. Samples in the head are
+// counted, while those in blocks are estimated from each block's average
+// samples per series, since blocks don't index per-series sample counts.
+func (db *TSDB) Cardinality(matchers index.Matchers, opts ListOptions) (*CardinalityReport, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	ids, err := db.head.Postings(matchers)
+	if err != nil {
+		return nil, err
+	}
+	minTime, maxTime, bounded := opts.timeRange()
+	var headSeries []*series.Series
+	if bounded {
+		headSeries = db.head.SeriesInRange(ids, minTime, maxTime)
+	} else {
+		headSeries = db.head.Series(ids)
+		minTime, maxTime = math.MinInt64, math.MaxInt64
+	}
+
+	report := &CardinalityReport{}
+	labelSets := make(map[uint64]map[string]string, len(headSeries))
+	active, flushing := db.head.memTables()
+	for _, s := range headSeries {
+		labelSets[s.Hash] = s.Labels
+		report.Samples += active.CountSamples(s.Hash, minTime, maxTime)
+		if flushing != nil {
+			report.Samples += flushing.CountSamples(s.Hash, minTime, maxTime)
+		}
+	}
+
+	if bounded {
+		err := db.eachBlockInRange(opts.snapshot, minTime, maxTime, func(b *Block) error {
+			blockSeries, err := b.Series(matchers)
+			if err != nil {
+				return err
+			}
+			for _, s := range blockSeries {
+				labelSets[s.Hash] = s.Labels
+			}
+			if b.NumSeries > 0 {
+				report.Samples += b.NumSamples * int64(len(blockSeries)) / b.NumSeries
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	report.Series = int64(len(labelSets))
+
+	fixed := make(map[string]bool)
+	for _, m := range matchers {
+		if m.Type == index.MatchEqual {
+			fixed[m.Name] = true
+		}
+	}
+	counts := make(map[string]map[string]int64)
+	for _, labels := range labelSets {
+		for name, value := range labels {
+			if fixed[name] {
+				continue
+			}
+			if counts[name] == nil {
+				counts[name] = make(map[string]int64)
+			}
+			counts[name][value]++
+		}
+	}
+
+	for name, values := range counts {
+		label := LabelCardinality{Name: name, DistinctValues: len(values)}
+		for value, n := range values {
+			label.Series += n
+			label.Values = append(label.Values, LabelValueCount{Value: value, Series: n})
+		}
+		slices.SortFunc(label.Values, func(a, b LabelValueCount) int {
+			if c := cmp.Compare(b.Series, a.Series); c != 0 {
+				return c
+			}
+			return cmp.Compare(a.Value, b.Value)
+		})
+		if opts.Limit > 0 && len(label.Values) > opts.Limit {
+			label.Values = label.Values[:opts.Limit]
+		}
+		report.Labels = append(report.Labels, label)
+	}
+	slices.SortFunc(report.Labels, func(a, b LabelCardinality) int {
+		if c := cmp.Compare(b.DistinctValues, a.DistinctValues); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return report, nil
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestCardinality(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	opts.FlushInterval = time.Hour
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	insert := func(labels map[string]string, samples ...series.Sample) {
+		t.Helper()
+		if err := db.Insert(series.NewSeries(labels), samples); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	// Four pods flushed to a block, then pod 0 and a new pod in the head
+	for pod := range 4 {
+		insert(map[string]string{"__name__": "http_requests", "pod": fmt.Sprint(pod), "code": "200"},
+			series.Sample{Timestamp: 1000, Value: 1}, series.Sample{Timestamp: 2000, Value: 1})
+	}
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	insert(map[string]string{"__name__": "http_requests", "pod": "0", "code": "200"}, series.Sample{Timestamp: 3000, Value: 1})
+	insert(map[string]string{"__name__": "http_requests", "pod": "4", "code": "500"}, series.Sample{Timestamp: 3000, Value: 1})
+	insert(map[string]string{"__name__": "up"}, series.Sample{Timestamp: 3000, Value: 1})
+
+	matchers := index.Matchers{index.MustNewMatcher(index.MatchEqual, "__name__", "http_requests")}
+
+	// Without a time range only the head index is read
+	report, err := db.Cardinality(matchers, ListOptions{})
+	if err != nil {
+		t.Fatalf("Cardinality failed: %v", err)
+	}
+	if report.Series != 5 || report.Samples != 2 {
+		t.Errorf("unbounded report has %d series and %d samples, want 5 and 2", report.Series, report.Samples)
+	}
+
+	report, err = db.Cardinality(matchers, ListOptions{MinTime: 0, MaxTime: 10_000, Limit: 1})
+	if err != nil {
+		t.Fatalf("Cardinality failed: %v", err)
+	}
+	// Four block series of two samples each, plus two head samples
+	if report.Series != 5 || report.Samples != 10 {
+		t.Errorf("report has %d series and %d samples, want 5 and 10", report.Series, report.Samples)
+	}
+	if len(report.Labels) != 2 {
+		t.Fatalf("report has labels %+v, want pod and code", report.Labels)
+	}
+	pod, code := report.Labels[0], report.Labels[1]
+	if pod.Name != "pod" || pod.DistinctValues != 5 || pod.Series != 5 || len(pod.Values) != 1 {
+		t.Errorf("pod = %+v, want 5 values over 5 series, 1 listed", pod)
+	}
+	if code.Name != "code" || code.DistinctValues != 2 || code.Values[0] != (LabelValueCount{Value: "200", Series: 4}) {
+		t.Errorf("code = %+v, want 200 on 4 series first", code)
+	}
+
+	// Drilling down into code="500"
+	matchers = append(matchers, index.MustNewMatcher(index.MatchEqual, "code", "500"))
+	report, err = db.Cardinality(matchers, ListOptions{MinTime: 0, MaxTime: 10_000})
+	if err != nil {
+		t.Fatalf("Cardinality failed: %v", err)
+	}
+	if report.Series != 1 || len(report.Labels) != 1 || report.Labels[0].Values[0].Value != "4" {
+		t.Errorf("drill-down report = %+v, want pod 4", report)
+	}
+}
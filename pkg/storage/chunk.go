@@ -2,9 +2,13 @@ package storage
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
+	"math"
+	"slices"
+	"sync"
 
 	"github.com/therealutkarshpriyadarshi/time/pkg/compression"
 	"github.com/therealutkarshpriyadarshi/time/pkg/series"
@@ -23,6 +27,8 @@ import (
 //     [2 bytes: encoding flags]
 //
 //   Data:
+//     [24 bytes: sum, min and max of the values (EncodingGorillaSummary)]
+//     [4 bytes: timestamps length]
 //     [N bytes: compressed timestamps]
 //     [M bytes: compressed values]
 //
@@ -50,15 +56,59 @@ const (
 
 	// EncodingGorilla indicates Gorilla compression (delta-of-delta + XOR)
 	EncodingGorilla uint16 = 1
+
+	// EncodingGorillaSummary is EncodingGorilla with the sum, min and max
+	// of the values recorded ahead of the compressed data, so whole chunks
+	// can be aggregated without decoding them. Chunks whose timestamps
+	// don't strictly increase are still written as EncodingGorilla.
+	EncodingGorillaSummary uint16 = 2
+
+	// MaxChunkEncoding is the newest chunk encoding this build can decode
+	MaxChunkEncoding = EncodingGorillaSummary
+
+	// chunkSummarySize is the size of the summary of EncodingGorillaSummary
+	chunkSummarySize = 24
 )
 
+// ChunkSummary describes the samples of a chunk without decoding them
+type ChunkSummary struct {
+	Count     int
+	Sum       float64
+	Min, Max  float64 // NaN values are skipped, like the comparisons of a running min or max
+	FirstTime int64
+	LastTime  int64
+}
+
+// ErrChunkEncodingTooNew indicates a chunk written by a newer release
+var ErrChunkEncodingTooNew = errors.New("chunk encoding too new")
+
 // NewChunk creates a new empty chunk
 func NewChunk() *Chunk {
 	return &Chunk{
-		Encoding: EncodingGorilla,
+		Encoding: EncodingGorillaSummary,
 	}
 }
 
+// chunkEncoder holds the encoders of a chunk, kept in chunkEncoderPool so
+// flushes and compactions reuse their buffers across series
+type chunkEncoder struct {
+	timestamps *compression.TimestampEncoder
+	values     *compression.ValueEncoder
+}
+
+var chunkEncoderPool = sync.Pool{
+	New: func() interface{} {
+		return &chunkEncoder{
+			timestamps: compression.NewTimestampEncoder(),
+			values:     compression.NewValueEncoder(),
+		}
+	},
+}
+
+// maxPooledChunkBuffer caps the encoder and marshal buffers kept for reuse,
+// so a single huge chunk doesn't pin memory
+const maxPooledChunkBuffer = 1 << 16
+
 // Append compresses and appends samples to the chunk.
 // This creates a new chunk with the provided samples.
 func (c *Chunk) Append(samples []series.Sample) error {
@@ -75,8 +125,17 @@ func (c *Chunk) Append(samples []series.Sample) error {
 	c.MaxTime = samples[len(samples)-1].Timestamp
 	c.NumSamples = uint16(len(samples))
 
+	enc := chunkEncoderPool.Get().(*chunkEncoder)
+	enc.timestamps.Reset()
+	enc.values.Reset()
+	defer func() {
+		if enc.timestamps.BitsWritten()+enc.values.BitsWritten() <= 8*maxPooledChunkBuffer {
+			chunkEncoderPool.Put(enc)
+		}
+	}()
+
 	// Compress timestamps
-	tsEncoder := compression.NewTimestampEncoder()
+	tsEncoder := enc.timestamps
 	for _, sample := range samples {
 		if err := tsEncoder.Encode(sample.Timestamp); err != nil {
 			return fmt.Errorf("failed to encode timestamp: %w", err)
@@ -89,7 +148,7 @@ func (c *Chunk) Append(samples []series.Sample) error {
 	}
 
 	// Compress values
-	valEncoder := compression.NewValueEncoder()
+	valEncoder := enc.values
 	for _, sample := range samples {
 		if err := valEncoder.Encode(sample.Value); err != nil {
 			return fmt.Errorf("failed to encode value: %w", err)
@@ -101,13 +160,28 @@ func (c *Chunk) Append(samples []series.Sample) error {
 		return fmt.Errorf("failed to finish value encoding: %w", err)
 	}
 
-	// Combine compressed data: [4 bytes: ts length][timestamps][values]
+	// Only samples in strictly increasing time order are summarized, as
+	// readers drop duplicate timestamps the summary would still count
+	c.Encoding = EncodingGorilla
+	if strictlyIncreasing(samples) {
+		c.Encoding = EncodingGorillaSummary
+	}
+	offset := c.summarySize()
+
+	// Combine compressed data: [summary][4 bytes: ts length][timestamps][values]
 	tsLen := uint32(len(compressedTS))
-	c.Data = make([]byte, 4+len(compressedTS)+len(compressedVals))
+	c.Data = make([]byte, offset+4+len(compressedTS)+len(compressedVals))
 
-	binary.BigEndian.PutUint32(c.Data[0:4], tsLen)
-	copy(c.Data[4:4+tsLen], compressedTS)
-	copy(c.Data[4+tsLen:], compressedVals)
+	if offset > 0 {
+		summary := summarize(samples)
+		binary.BigEndian.PutUint64(c.Data[0:8], math.Float64bits(summary.Sum))
+		binary.BigEndian.PutUint64(c.Data[8:16], math.Float64bits(summary.Min))
+		binary.BigEndian.PutUint64(c.Data[16:24], math.Float64bits(summary.Max))
+	}
+	data := c.Data[offset:]
+	binary.BigEndian.PutUint32(data[0:4], tsLen)
+	copy(data[4:4+tsLen], compressedTS)
+	copy(data[4+tsLen:], compressedVals)
 
 	// Calculate checksum
 	c.Checksum = crc32.ChecksumIEEE(c.Data)
@@ -115,20 +189,67 @@ func (c *Chunk) Append(samples []series.Sample) error {
 	return nil
 }
 
+// summarize returns the summary of samples, which must not be empty
+func summarize(samples []series.Sample) ChunkSummary {
+	summary := ChunkSummary{
+		Count:     len(samples),
+		Min:       math.Inf(1),
+		Max:       math.Inf(-1),
+		FirstTime: samples[0].Timestamp,
+		LastTime:  samples[len(samples)-1].Timestamp,
+	}
+	for _, sample := range samples {
+		summary.Sum += sample.Value
+		if sample.Value < summary.Min {
+			summary.Min = sample.Value
+		}
+		if sample.Value > summary.Max {
+			summary.Max = sample.Value
+		}
+	}
+	return summary
+}
+
+// summarySize returns the size of the summary ahead of the compressed data
+func (c *Chunk) summarySize() int {
+	if c.Encoding == EncodingGorillaSummary {
+		return chunkSummarySize
+	}
+	return 0
+}
+
+// Summary returns the summary of the chunk's samples, and false if its
+// encoding doesn't record one
+func (c *Chunk) Summary() (ChunkSummary, bool) {
+	if c.Encoding != EncodingGorillaSummary || len(c.Data) < chunkSummarySize {
+		return ChunkSummary{}, false
+	}
+	return ChunkSummary{
+		Count:     int(c.NumSamples),
+		Sum:       math.Float64frombits(binary.BigEndian.Uint64(c.Data[0:8])),
+		Min:       math.Float64frombits(binary.BigEndian.Uint64(c.Data[8:16])),
+		Max:       math.Float64frombits(binary.BigEndian.Uint64(c.Data[16:24])),
+		FirstTime: c.MinTime,
+		LastTime:  c.MaxTime,
+	}, true
+}
+
 // Iterator returns an iterator over the samples in the chunk
 func (c *Chunk) Iterator() (*ChunkIterator, error) {
-	if len(c.Data) < 4 {
+	offset := c.summarySize()
+	if len(c.Data) < offset+4 {
 		return nil, fmt.Errorf("invalid chunk data: too short")
 	}
 
 	// Extract timestamp and value data
-	tsLen := binary.BigEndian.Uint32(c.Data[0:4])
-	if len(c.Data) < int(4+tsLen) {
+	data := c.Data[offset:]
+	tsLen := binary.BigEndian.Uint32(data[0:4])
+	if len(data) < int(4+tsLen) {
 		return nil, fmt.Errorf("invalid chunk data: timestamp length mismatch")
 	}
 
-	compressedTS := c.Data[4 : 4+tsLen]
-	compressedVals := c.Data[4+tsLen:]
+	compressedTS := data[4 : 4+tsLen]
+	compressedVals := data[4+tsLen:]
 
 	// Verify checksum
 	checksum := crc32.ChecksumIEEE(c.Data)
@@ -150,8 +271,14 @@ func (c *Chunk) Iterator() (*ChunkIterator, error) {
 
 // MarshalBinary serializes the chunk to bytes
 func (c *Chunk) MarshalBinary() ([]byte, error) {
+	return c.appendBinary(nil), nil
+}
+
+// appendBinary appends the serialized chunk to dst, growing it if needed
+func (c *Chunk) appendBinary(dst []byte) []byte {
 	totalSize := ChunkHeaderSize + len(c.Data) + ChunkFooterSize
-	buf := make([]byte, totalSize)
+	dst = slices.Grow(dst, totalSize)
+	buf := dst[len(dst) : len(dst)+totalSize]
 
 	// Write header
 	binary.BigEndian.PutUint64(buf[0:8], uint64(c.MinTime))
@@ -166,7 +293,7 @@ func (c *Chunk) MarshalBinary() ([]byte, error) {
 	// Write footer (checksum)
 	binary.BigEndian.PutUint32(buf[ChunkHeaderSize+len(c.Data):], c.Checksum)
 
-	return buf, nil
+	return dst[:len(dst)+totalSize]
 }
 
 // UnmarshalBinary deserializes the chunk from bytes
@@ -182,6 +309,11 @@ func (c *Chunk) UnmarshalBinary(data []byte) error {
 	dataLength := binary.BigEndian.Uint32(data[18:22])
 	c.Encoding = binary.BigEndian.Uint16(data[22:24])
 
+	if c.Encoding > MaxChunkEncoding {
+		return fmt.Errorf("%w: chunk has encoding %d, this build supports up to %d",
+			ErrChunkEncodingTooNew, c.Encoding, MaxChunkEncoding)
+	}
+
 	// Validate data length
 	expectedSize := ChunkHeaderSize + int(dataLength) + ChunkFooterSize
 	if len(data) != expectedSize {
@@ -219,14 +351,23 @@ func (c *Chunk) CompressionRatio() float64 {
 	return float64(uncompressed) / float64(compressed)
 }
 
+// chunkBufPool holds buffers chunks are serialized into by WriteTo
+var chunkBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 4096)
+		return &buf
+	},
+}
+
 // WriteTo writes the chunk to a writer
 func (c *Chunk) WriteTo(w io.Writer) (int64, error) {
-	data, err := c.MarshalBinary()
-	if err != nil {
-		return 0, err
-	}
-
+	bp := chunkBufPool.Get().(*[]byte)
+	data := c.appendBinary((*bp)[:0])
 	n, err := w.Write(data)
+	if cap(data) <= maxPooledChunkBuffer {
+		*bp = data
+		chunkBufPool.Put(bp)
+	}
 	return int64(n), err
 }
 
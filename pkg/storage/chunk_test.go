@@ -2,6 +2,8 @@ package storage
 
 import (
 	"bytes"
+	"hash/crc32"
+	"math"
 	"testing"
 
 	"github.com/therealutkarshpriyadarshi/time/pkg/series"
@@ -178,6 +180,111 @@ func TestChunkWriteRead(t *testing.T) {
 	}
 }
 
+// TestChunkSummary tests the summary recorded by EncodingGorillaSummary
+func TestChunkSummary(t *testing.T) {
+	samples := []series.Sample{
+		{Timestamp: 1000, Value: 4},
+		{Timestamp: 2000, Value: math.NaN()},
+		{Timestamp: 3000, Value: -2},
+		{Timestamp: 4000, Value: 7},
+	}
+	chunk := NewChunk()
+	if err := chunk.Append(samples); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	data, err := chunk.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	decoded := &Chunk{}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+
+	summary, ok := decoded.Summary()
+	if !ok || decoded.Encoding != EncodingGorillaSummary {
+		t.Fatalf("chunk with encoding %d has no summary", decoded.Encoding)
+	}
+	if summary.Count != 4 || summary.Min != -2 || summary.Max != 7 || !math.IsNaN(summary.Sum) ||
+		summary.FirstTime != 1000 || summary.LastTime != 4000 {
+		t.Errorf("summary = %+v, want 4 samples from 1000 to 4000 between -2 and 7 summing to NaN", summary)
+	}
+	if got := collectChunk(t, decoded); len(got) != len(samples) || got[3] != samples[3] {
+		t.Errorf("decoded %v, want %v", got, samples)
+	}
+
+	// Chunks written before summaries decode as they did
+	chunk.Encoding = EncodingGorilla
+	chunk.Data = chunk.Data[chunkSummarySize:]
+	chunk.Checksum = crc32.ChecksumIEEE(chunk.Data)
+	if _, ok := chunk.Summary(); ok {
+		t.Error("EncodingGorilla chunk has a summary")
+	}
+	if got := collectChunk(t, chunk); len(got) != len(samples) || got[0] != samples[0] {
+		t.Errorf("decoded %v, want %v", got, samples)
+	}
+
+	// Duplicate timestamps are left unsummarized
+	unsorted := NewChunk()
+	if err := unsorted.Append([]series.Sample{{Timestamp: 2000, Value: 1}, {Timestamp: 2000, Value: 2}}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if _, ok := unsorted.Summary(); ok || unsorted.Encoding != EncodingGorilla {
+		t.Errorf("chunk with duplicate timestamps has encoding %d, want %d", unsorted.Encoding, EncodingGorilla)
+	}
+}
+
+// collectChunk returns the decoded samples of chunk
+func collectChunk(t *testing.T, chunk *Chunk) []series.Sample {
+	t.Helper()
+	iter, err := chunk.Iterator()
+	if err != nil {
+		t.Fatalf("Iterator failed: %v", err)
+	}
+	var samples []series.Sample
+	for iter.Next() {
+		sample, err := iter.At()
+		if err != nil {
+			t.Fatalf("At failed: %v", err)
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+// TestChunkAppendAllocs tests that encoding reuses pooled buffers, so
+// flushes allocate little beyond the encoded chunks
+func TestChunkAppendAllocs(t *testing.T) {
+	samples := make([]series.Sample, 120)
+	for i := range samples {
+		samples[i] = series.Sample{Timestamp: int64(i) * 15000, Value: float64(i % 7)}
+	}
+
+	var buf bytes.Buffer
+	allocs := testing.AllocsPerRun(100, func() {
+		chunk := NewChunk()
+		if err := chunk.Append(samples); err != nil {
+			t.Fatal(err)
+		}
+		buf.Reset()
+		if _, err := chunk.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// The chunk and its data
+	if allocs > 2 && !raceEnabled {
+		t.Errorf("expected at most 2 allocations per chunk, got %v", allocs)
+	}
+
+	restored := NewChunk()
+	if _, err := restored.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom failed: %v", err)
+	}
+	if got := collectChunk(t, restored); len(got) != len(samples) || got[len(got)-1] != samples[len(samples)-1] {
+		t.Errorf("decoded %d samples, want %d", len(got), len(samples))
+	}
+}
+
 // TestChunkCompressionRatio tests compression effectiveness
 func TestChunkCompressionRatio(t *testing.T) {
 	// Create regular-interval samples (should compress well)
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ChurnWindows are the sliding windows series churn is reported over
+var ChurnWindows = []time.Duration{5 * time.Minute, time.Hour}
+
+// MaxChurnMetrics is the most metric names reported per churn window
+const MaxChurnMetrics = 10
+
+// churnBucketWidth is the resolution of the churn windows; the longest
+// window is kept in as many buckets as it has widths
+const churnBucketWidth = time.Minute
+
+// MetricChurn is the series churn of one metric name over a window
+type MetricChurn struct {
+	Metric  string
+	Created int64 // Series first written to the head
+	Ended   int64 // Series that stopped receiving samples
+}
+
+// SeriesChurn lists the metric names churning the most series over a
+// window, most created and ended first
+type SeriesChurn struct {
+	Window  time.Duration
+	Metrics []MetricChurn
+}
+
+// seriesChurn counts, per metric name, the series created in the head and
+// those that ended. A series has ended when it was written to one MemTable
+// but not to the next, so churn is only seen at the granularity of
+// flushes.
+type seriesChurn struct {
+	mu      sync.Mutex
+	buckets []churnBucket // Ring of churnBucketWidth buckets
+
+	// Metric name of every series of the last MemTable cut
+	lastCut map[uint64]string
+}
+
+// churnBucket holds the churn counted in one churnBucketWidth
+type churnBucket struct {
+	start  int64 // In churnBucketWidths since the epoch
+	counts map[string]*MetricChurn
+}
+
+// newSeriesChurn returns a tracker covering the longest of ChurnWindows
+func newSeriesChurn() *seriesChurn {
+	longest := slices.Max(ChurnWindows)
+	return &seriesChurn{
+		buckets: make([]churnBucket, longest/churnBucketWidth),
+	}
+}
+
+// bucket returns the counts of metric in the bucket of now. c.mu must be
+// held.
+func (c *seriesChurn) bucket(metric string, now time.Time) *MetricChurn {
+	start := now.UnixNano() / int64(churnBucketWidth)
+	b := &c.buckets[start%int64(len(c.buckets))]
+	if b.counts != nil && b.start > start {
+		return &MetricChurn{} // Older than the longest window
+	}
+	if b.start != start || b.counts == nil {
+		*b = churnBucket{start: start, counts: make(map[string]*MetricChurn)}
+	}
+	counts, ok := b.counts[metric]
+	if !ok {
+		counts = &MetricChurn{Metric: metric}
+		b.counts[metric] = counts
+	}
+	return counts
+}
+
+// created counts a series of metric created at now
+func (c *seriesChurn) created(metric string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bucket(metric, now).Created++
+}
+
+// cut counts the series of the last MemTable cut that are missing from
+// mt, which was cut at now, as ended
+func (c *seriesChurn) cut(mt *MemTable, now time.Time) {
+	hashes := mt.AllSeries()
+	current := make(map[uint64]string, len(hashes))
+	for _, hash := range hashes {
+		if s, ok := mt.GetSeries(hash); ok {
+			current[hash] = s.Labels[MetricNameLabel]
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for hash, metric := range c.lastCut {
+		if _, ok := current[hash]; !ok {
+			c.bucket(metric, now).Ended++
+		}
+	}
+	c.lastCut = current
+}
+
+// reset forgets the churn counted so far
+func (c *seriesChurn) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.buckets)
+	c.lastCut = nil
+}
+
+// snapshot returns the churn over each of ChurnWindows ending at now
+func (c *seriesChurn) snapshot(now time.Time) []SeriesChurn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current := now.UnixNano() / int64(churnBucketWidth)
+	result := make([]SeriesChurn, 0, len(ChurnWindows))
+	for _, window := range ChurnWindows {
+		// The current, partial bucket and those before it in the window
+		first := current - int64(window/churnBucketWidth) + 1
+		totals := make(map[string]*MetricChurn)
+		for _, b := range c.buckets {
+			if b.counts == nil || b.start < first || b.start > current {
+				continue
+			}
+			for metric, counts := range b.counts {
+				total, ok := totals[metric]
+				if !ok {
+					total = &MetricChurn{Metric: metric}
+					totals[metric] = total
+				}
+				total.Created += counts.Created
+				total.Ended += counts.Ended
+			}
+		}
+
+		metrics := make([]MetricChurn, 0, len(totals))
+		for _, total := range totals {
+			metrics = append(metrics, *total)
+		}
+		slices.SortFunc(metrics, func(a, b MetricChurn) int {
+			if c := cmp.Compare(b.Created+b.Ended, a.Created+a.Ended); c != 0 {
+				return c
+			}
+			return cmp.Compare(a.Metric, b.Metric)
+		})
+		if len(metrics) > MaxChurnMetrics {
+			metrics = metrics[:MaxChurnMetrics]
+		}
+		result = append(result, SeriesChurn{Window: window, Metrics: metrics})
+	}
+	return result
+}
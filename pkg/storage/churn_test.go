@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestSeriesChurnWindows(t *testing.T) {
+	c := newSeriesChurn()
+	now := time.Unix(1700000000, 0)
+
+	// Two hours ago, 20 minutes ago and just now
+	c.created("old_job", now.Add(-2*time.Hour))
+	for i := 0; i < 3; i++ {
+		c.created("batch_job", now.Add(-20*time.Minute))
+	}
+	c.created("api", now)
+	c.created("api", now.Add(-time.Minute))
+	c.created("old_job", now.Add(-time.Hour)) // Shares a bucket with now
+
+	got := c.snapshot(now)
+	want := []SeriesChurn{
+		{Window: 5 * time.Minute, Metrics: []MetricChurn{{Metric: "api", Created: 2}}},
+		{Window: time.Hour, Metrics: []MetricChurn{
+			{Metric: "batch_job", Created: 3},
+			{Metric: "api", Created: 2},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshot = %+v, want %+v", got, want)
+	}
+
+	c.reset()
+	for _, w := range c.snapshot(now) {
+		if len(w.Metrics) != 0 {
+			t.Errorf("%s window after reset = %+v, want none", w.Window, w.Metrics)
+		}
+	}
+}
+
+func TestSeriesChurnEnded(t *testing.T) {
+	c := newSeriesChurn()
+	now := time.Unix(1700000000, 0)
+
+	memTable := func(hosts ...string) *MemTable {
+		mt := NewShardedMemTable(DefaultMaxSize, 1)
+		for _, host := range hosts {
+			s := series.NewSeries(map[string]string{"__name__": "up", "host": host})
+			if err := mt.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+				t.Fatalf("insert failed: %v", err)
+			}
+		}
+		return mt
+	}
+
+	// Hosts a and b stop being written after the first cut
+	c.cut(memTable("a", "b", "c"), now)
+	c.cut(memTable("c", "d"), now)
+	c.cut(memTable("c", "d"), now)
+
+	got := c.snapshot(now)[0].Metrics
+	want := []MetricChurn{{Metric: "up", Ended: 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("churn = %+v, want %+v", got, want)
+	}
+}
+
+func TestHeadSeriesChurn(t *testing.T) {
+	head := NewHead(DefaultMaxSize, 1, SamplePolicy{})
+	for _, host := range []string{"a", "b"} {
+		s := series.NewSeries(map[string]string{"__name__": "cpu", "host": host})
+		if _, err := head.indexSeries(s); err != nil {
+			t.Fatalf("indexSeries failed: %v", err)
+		}
+	}
+
+	churn := head.SeriesChurn()
+	if len(churn) != len(ChurnWindows) {
+		t.Fatalf("got %d windows, want %d", len(churn), len(ChurnWindows))
+	}
+	for _, w := range churn {
+		want := []MetricChurn{{Metric: "cpu", Created: 2}}
+		if !reflect.DeepEqual(w.Metrics, want) {
+			t.Errorf("%s window = %+v, want %+v", w.Window, w.Metrics, want)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/therealutkarshpriyadarshi/time/pkg/observability"
 	"github.com/therealutkarshpriyadarshi/time/pkg/series"
 )
 
@@ -19,9 +20,9 @@ type CompactionLevel int
 const (
 	// Level0 represents raw 2-hour ingestion blocks
 	Level0 CompactionLevel = 0
-	// Level1 represents merged 12-hour blocks (6x L0 blocks)
+	// Level1 represents merged 12-hour blocks (6x L0 blocks) by default
 	Level1 CompactionLevel = 1
-	// Level2 represents merged 7-day blocks (14x L1 blocks)
+	// Level2 represents merged 7-day blocks (14x L1 blocks) by default
 	Level2 CompactionLevel = 2
 )
 
@@ -42,8 +43,42 @@ const (
 	MinBlocksForCompaction = 3
 )
 
+// CompactionLevelConfig is one level of the compaction schedule above
+// level 0. Blocks of the level below are merged into a block of this
+// level once at least MinBlocks of them fall within a window of Duration.
+type CompactionLevelConfig struct {
+	Duration  time.Duration
+	MinBlocks int // Fan-in; 0 means MinBlocksForCompaction
+}
+
+// DefaultCompactionLevels returns the default compaction schedule:
+// Level1Duration blocks, then Level2Duration blocks
+func DefaultCompactionLevels() []CompactionLevelConfig {
+	return []CompactionLevelConfig{
+		{Duration: Level1Duration, MinBlocks: MinBlocksForCompaction},
+		{Duration: Level2Duration, MinBlocks: MinBlocksForCompaction},
+	}
+}
+
+// validateCompactionLevels checks that each level's blocks are longer than
+// the last's and merge at least two blocks
+func validateCompactionLevels(levels []CompactionLevelConfig) error {
+	prev := Level0Duration
+	for i, level := range levels {
+		if level.Duration <= prev {
+			return fmt.Errorf("compaction level %d duration %s must be longer than level %d's %s", i+1, level.Duration, i, prev)
+		}
+		if level.MinBlocks < 0 || level.MinBlocks == 1 {
+			return fmt.Errorf("compaction level %d must merge at least 2 blocks, not %d", i+1, level.MinBlocks)
+		}
+		prev = level.Duration
+	}
+	return nil
+}
+
 // Compactor manages background compaction of time-series blocks.
-// It implements a tiered compaction strategy similar to LSM trees:
+// It implements a tiered compaction strategy similar to LSM trees, by
+// default (see CompactorOptions.Levels):
 // - Level 0: 2-hour blocks (raw ingestion)
 // - Level 1: 12-hour blocks (merge 6x L0 blocks)
 // - Level 2: 7-day blocks (merge 14x L1 blocks)
@@ -52,29 +87,61 @@ type Compactor struct {
 	interval    time.Duration
 	concurrency int
 
+	// Output block caps; 0 means unlimited
+	maxBlockBytes  int64
+	maxBlockSeries int
+
+	precisionRules []PrecisionRule
+
+	// Compaction schedule above level 0
+	levels []CompactionLevelConfig
+
+	// Blocks whose data is all older than coldAfter are moved to coldDir;
+	// empty if there is no cold tier
+	coldDir   string
+	coldAfter time.Duration
+
+	// How long blocks deleted by retention stay in the trash; 0 deletes
+	// them at once
+	trashGracePeriod time.Duration
+
 	// Block management
 	blockReader *BlockReader
 	blockWriter *BlockWriter
 
+	// Leases defer deleting blocks that queries are reading; shared with
+	// the TSDB
+	leases *blockLeases
+
+	// io paces the block reads and writes of merges and rewrites; shared
+	// with the TSDB, which reports query latency to it. Nil paces nothing.
+	io *ioScheduler
+
 	// State
-	mu      sync.RWMutex
-	running atomic.Bool
-	ctx     context.Context
-	cancel  context.CancelFunc
+	mu       sync.RWMutex
+	running  atomic.Bool
+	paused   atomic.Bool // Background cycles are skipped while set
+	readOnly atomic.Bool // Set by the disk watchdog; no blocks are written while set
+	ctx      context.Context
+	cancel   context.CancelFunc
 
 	// Metrics
-	stats CompactionStats
+	stats     CompactionStats
+	metrics   *observability.Metrics // Optional
+	slowMerge time.Duration
 }
 
 // CompactionStats holds compaction metrics
 type CompactionStats struct {
-	TotalCompactions   atomic.Int64
-	BlocksMerged       atomic.Int64
-	BytesReclaimed     atomic.Int64
-	LastCompactionTime atomic.Int64 // Unix milliseconds
-	CompactionErrors   atomic.Int64
-	Level0Compactions  atomic.Int64
-	Level1Compactions  atomic.Int64
+	TotalCompactions    atomic.Int64
+	BlocksMerged        atomic.Int64
+	BytesReclaimed      atomic.Int64
+	LastCompactionTime  atomic.Int64 // Unix milliseconds
+	CompactionErrors    atomic.Int64
+	Level0Compactions   atomic.Int64
+	Level1Compactions   atomic.Int64
+	VerticalCompactions atomic.Int64
+	BlocksMovedCold     atomic.Int64
 }
 
 // CompactorOptions configures the compactor
@@ -82,6 +149,37 @@ type CompactorOptions struct {
 	DataDir     string
 	Interval    time.Duration
 	Concurrency int // Number of concurrent compaction workers
+
+	// MaxBlockBytes caps the estimated size of a merged block; merges that
+	// would exceed it are split into several blocks. 0 means unlimited.
+	MaxBlockBytes int64
+
+	// MaxBlockSeries caps the number of series in a merged block, splitting
+	// merges like MaxBlockBytes. 0 means unlimited.
+	MaxBlockSeries int
+
+	// PrecisionRules round the values of matching series in merges of
+	// blocks old enough for them
+	PrecisionRules []PrecisionRule
+
+	// Levels is the compaction schedule above level 0, checked by
+	// Options.Validate; nil means DefaultCompactionLevels
+	Levels []CompactionLevelConfig
+
+	// ColdDir, if set, is a second block directory, e.g. on cheaper
+	// disks. Each cycle moves blocks whose data is all older than
+	// ColdAfter there; blocks are read from both directories.
+	ColdDir   string
+	ColdAfter time.Duration
+
+	// TrashGracePeriod keeps the blocks retention deletes in TrashDir for
+	// this long, so they can be moved back; 0 deletes them at once
+	TrashGracePeriod time.Duration
+
+	// Metrics, if set, records the duration of each merge. Merges taking
+	// longer than SlowMergeThreshold are logged; 0 disables the log.
+	Metrics            *observability.Metrics
+	SlowMergeThreshold time.Duration
 }
 
 // DefaultCompactorOptions returns default compactor options
@@ -101,14 +199,29 @@ func NewCompactor(opts *CompactorOptions) *Compactor {
 
 	ctx, cancel := context.WithCancel(context.Background())
 
+	levels := opts.Levels
+	if levels == nil {
+		levels = DefaultCompactionLevels()
+	}
+
 	return &Compactor{
-		dataDir:     opts.DataDir,
-		interval:    opts.Interval,
-		concurrency: opts.Concurrency,
-		blockReader: NewBlockReader(opts.DataDir),
-		blockWriter: NewBlockWriter(opts.DataDir),
-		ctx:         ctx,
-		cancel:      cancel,
+		dataDir:          opts.DataDir,
+		interval:         opts.Interval,
+		concurrency:      opts.Concurrency,
+		maxBlockBytes:    opts.MaxBlockBytes,
+		maxBlockSeries:   opts.MaxBlockSeries,
+		precisionRules:   opts.PrecisionRules,
+		levels:           levels,
+		coldDir:          opts.ColdDir,
+		coldAfter:        opts.ColdAfter,
+		trashGracePeriod: opts.TrashGracePeriod,
+		blockReader:      &BlockReader{dataDir: opts.DataDir, coldDir: opts.ColdDir},
+		blockWriter:      NewBlockWriter(opts.DataDir),
+		leases:           newBlockLeases(),
+		metrics:          opts.Metrics,
+		slowMerge:        opts.SlowMergeThreshold,
+		ctx:              ctx,
+		cancel:           cancel,
 	}
 }
 
@@ -123,16 +236,21 @@ func (c *Compactor) Run() error {
 	defer ticker.Stop()
 
 	// Run initial compaction
-	if err := c.compact(); err != nil {
-		c.stats.CompactionErrors.Add(1)
-		// Log error but continue
+	if !c.paused.Load() {
+		if err := c.compact(); err != nil {
+			c.recordError()
+			// Log error but continue
+		}
 	}
 
 	for {
 		select {
 		case <-ticker.C:
+			if c.paused.Load() {
+				continue
+			}
 			if err := c.compact(); err != nil {
-				c.stats.CompactionErrors.Add(1)
+				c.recordError()
 				// Log error but continue
 			}
 		case <-c.ctx.Done():
@@ -147,39 +265,72 @@ func (c *Compactor) Stop() error {
 	return nil
 }
 
+// Pause stops background compaction cycles until Resume is called. It
+// returns once any cycle in progress has finished, so the block directory
+// is not modified by compaction afterwards. Explicit CompactNow calls still
+// run.
+func (c *Compactor) Pause() {
+	c.paused.Store(true)
+
+	// Wait out a running cycle
+	c.mu.Lock()
+	c.mu.Unlock()
+}
+
+// Resume restarts background compaction cycles after Pause
+func (c *Compactor) Resume() {
+	c.paused.Store(false)
+}
+
+// Paused reports whether background compaction is paused
+func (c *Compactor) Paused() bool {
+	return c.paused.Load()
+}
+
+// SetReadOnly stops (or resumes) writing merged blocks. While read-only,
+// compaction cycles fail with ErrReadOnly.
+func (c *Compactor) SetReadOnly(readOnly bool) {
+	c.readOnly.Store(readOnly)
+}
+
 // compact performs a single compaction cycle
 func (c *Compactor) compact() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.readOnly.Load() {
+		return ErrReadOnly
+	}
+
 	// Load all blocks from disk
 	if err := c.blockReader.LoadBlocks(); err != nil {
 		return fmt.Errorf("failed to load blocks: %w", err)
 	}
 
-	blocks := c.blockReader.Blocks()
-	if len(blocks) < MinBlocksForCompaction {
-		return nil // Not enough blocks to compact
-	}
+	plan := c.plan(c.blockReader.Blocks())
+	for _, merge := range plan.Merges {
+		if merge.Vertical {
+			if err := c.executeMerge(merge); err != nil {
+				return fmt.Errorf("failed to merge overlapping blocks: %w", err)
+			}
+			c.stats.VerticalCompactions.Add(1)
+			continue
+		}
 
-	// Group blocks by level
-	level0Blocks := c.getBlocksByLevel(blocks, Level0)
-	level1Blocks := c.getBlocksByLevel(blocks, Level1)
+		if err := c.executeMerge(merge); err != nil {
+			return fmt.Errorf("failed to compact level %d: %w", merge.FromLevel, err)
+		}
 
-	// Compact Level 0 blocks to Level 1
-	if len(level0Blocks) >= MinBlocksForCompaction {
-		if err := c.compactLevel(level0Blocks, Level0, Level1); err != nil {
-			return fmt.Errorf("failed to compact level 0: %w", err)
+		switch merge.FromLevel {
+		case Level0:
+			c.stats.Level0Compactions.Add(1)
+		case Level1:
+			c.stats.Level1Compactions.Add(1)
 		}
-		c.stats.Level0Compactions.Add(1)
 	}
 
-	// Compact Level 1 blocks to Level 2
-	if len(level1Blocks) >= MinBlocksForCompaction {
-		if err := c.compactLevel(level1Blocks, Level1, Level2); err != nil {
-			return fmt.Errorf("failed to compact level 1: %w", err)
-		}
-		c.stats.Level1Compactions.Add(1)
+	if err := c.moveColdBlocks(time.Now()); err != nil {
+		return fmt.Errorf("failed to move blocks to cold storage: %w", err)
 	}
 
 	c.stats.TotalCompactions.Add(1)
@@ -188,120 +339,160 @@ func (c *Compactor) compact() error {
 	return nil
 }
 
-// compactLevel compacts blocks from one level to the next
-func (c *Compactor) compactLevel(blocks []*Block, fromLevel, toLevel CompactionLevel) error {
-	if len(blocks) == 0 {
-		return nil
-	}
-
-	// Group blocks by time windows
-	groups := c.groupBlocksByTimeWindow(blocks, c.getLevelDuration(toLevel))
-
-	for _, group := range groups {
-		if len(group) < MinBlocksForCompaction {
-			continue // Need at least MinBlocksForCompaction blocks to merge
-		}
-
-		// Merge blocks in this group
-		if err := c.mergeBlocks(group); err != nil {
-			return fmt.Errorf("failed to merge blocks: %w", err)
-		}
-	}
-
-	return nil
-}
-
-// mergeBlocks merges multiple blocks into a single larger block
+// mergeBlocks merges multiple blocks into larger blocks, split according
+// to the compactor's caps
 func (c *Compactor) mergeBlocks(blocks []*Block) error {
 	if len(blocks) <= 1 {
 		return nil // Nothing to merge
 	}
+	return c.executeMerge(c.planMerge(blocks))
+}
 
-	// Sort blocks by time
-	sort.Slice(blocks, func(i, j int) bool {
-		return blocks[i].MinTime < blocks[j].MinTime
-	})
-
-	// Calculate merged block time range
-	minTime := blocks[0].MinTime
-	maxTime := blocks[len(blocks)-1].MaxTime
-
-	// Create new merged block
-	mergedBlock, err := NewBlock(minTime, maxTime)
-	if err != nil {
-		return fmt.Errorf("failed to create merged block: %w", err)
+// executeMerge writes the output blocks of a planned merge and deletes its
+// source blocks
+func (c *Compactor) executeMerge(merge PlannedMerge) error {
+	start := time.Now()
+	defer c.blockReader.Invalidate()
+
+	// Resolve the series of every source block
+	blockSeries := make([]map[uint64]*series.Series, len(merge.blocks))
+	for i, block := range merge.blocks {
+		seriesSet, err := block.seriesSet()
+		if err != nil {
+			return fmt.Errorf("block %s: %w", block.ULID, err)
+		}
+		blockSeries[i] = seriesSet
 	}
 
-	// Collect all unique series across blocks
-	seriesMap := make(map[uint64]*series.Series)
-	seriesSamples := make(map[uint64][]series.Sample)
+	// Where blocks overlap, the later block's sample wins a timestamp, as
+	// in queries
+	byULID := make([]int, len(merge.blocks))
+	for i := range byULID {
+		byULID[i] = i
+	}
+	sort.Slice(byULID, func(i, j int) bool {
+		return merge.blocks[byULID[i]].ULID.Compare(merge.blocks[byULID[j]].ULID) < 0
+	})
 
-	for _, block := range blocks {
-		// First, collect all series hashes from this block
-		var seriesHashes []uint64
-		block.mu.RLock()
-		for hash, s := range block.series {
-			seriesMap[hash] = s
-			seriesHashes = append(seriesHashes, hash)
+	// Rules whose age the merged data has reached round its values
+	coldRules := coldPrecisionRules(c.precisionRules, merge.MaxTime, start)
+
+	// Outputs stay hidden from queries until the merge is committed
+	var outputDirs, outputIDs []string
+	numSeries := 0
+	defer func() {
+		// Unhide whatever was left behind by a failed merge
+		c.leases.commit(outputDirs, nil)
+	}()
+
+	for _, output := range merge.Outputs {
+		mergedBlock, err := NewBlock(merge.MinTime, merge.MaxTime)
+		if err != nil {
+			return fmt.Errorf("failed to create merged block: %w", err)
 		}
-		block.mu.RUnlock()
-
-		// Now get samples for each series (without holding the lock)
-		for _, hash := range seriesHashes {
-			samples, err := block.GetSeries(hash, minTime, maxTime)
-			if err != nil {
-				return fmt.Errorf("failed to get series samples: %w", err)
+		mergedBlock.Level = merge.outputLevel()
+		mergedBlock.Labels = mergedBlockLabels(merge.blocks, mergedBlock.Level)
+		mergedBlock.indexSources = merge.blocks
+		if err := mergedBlock.createDir(c.dataDir, c.leases.create); err != nil {
+			return err
+		}
+		outputDirs = append(outputDirs, mergedBlock.Dir())
+		outputIDs = append(outputIDs, mergedBlock.ULID.String())
+
+		applied := make([]bool, len(coldRules))
+		for _, hash := range output.series {
+			var s *series.Series
+			var samples []series.Sample
+			for _, i := range byULID {
+				block := merge.blocks[i]
+				blockS, ok := blockSeries[i][hash]
+				if !ok {
+					continue
+				}
+				s = blockS
+
+				chunk, err := block.chunk(hash)
+				if err != nil {
+					return fmt.Errorf("failed to get series samples: %w", err)
+				}
+				if chunk == nil {
+					continue
+				}
+				if err := c.throttle(chunk.Size()); err != nil {
+					return err
+				}
+				if samples, err = appendChunk(samples, chunk, merge.MinTime, merge.MaxTime); err != nil {
+					return fmt.Errorf("failed to get series samples: %w", err)
+				}
+			}
+			if s == nil || len(samples) == 0 {
+				continue
 			}
 
-			seriesSamples[hash] = append(seriesSamples[hash], samples...)
-		}
-	}
+			// Sort and deduplicate samples
+			samples = c.deduplicateSamples(samples)
+			if r := precisionRule(coldRules, s.Labels); r >= 0 {
+				for i := range samples {
+					samples[i].Value = roundSignificant(samples[i].Value, coldRules[r].Digits)
+				}
+				applied[r] = true
+			}
 
-	// Add all series to merged block
-	for hash, s := range seriesMap {
-		samples := seriesSamples[hash]
-		if len(samples) == 0 {
-			continue
+			if err := mergedBlock.AddSeries(s, samples); err != nil {
+				return fmt.Errorf("failed to add series to merged block: %w", err)
+			}
+			if err := c.throttle(mergedBlock.chunks[s.Hash].Size()); err != nil {
+				return err
+			}
+			numSeries++
 		}
 
-		// Sort and deduplicate samples
-		samples = c.deduplicateSamples(samples)
-
-		if err := mergedBlock.AddSeries(s, samples); err != nil {
-			return fmt.Errorf("failed to add series to merged block: %w", err)
+		// Persist merged block
+		mergedBlock.Precision = mergedBlockPrecision(merge.blocks, coldRules, applied)
+		if err := mergedBlock.Persist(c.dataDir); err != nil {
+			return fmt.Errorf("failed to persist merged block: %w", err)
 		}
 	}
 
-	// Persist merged block
-	if err := mergedBlock.Persist(c.dataDir); err != nil {
-		return fmt.Errorf("failed to persist merged block: %w", err)
-	}
-
-	// Delete old blocks atomically
+	// Swap the outputs in for the old blocks. Old blocks still being read
+	// are deleted once their readers finish.
 	var totalReclaimed int64
-	for _, block := range blocks {
-		blockSize := block.Size()
-		if err := block.Delete(); err != nil {
-			return fmt.Errorf("failed to delete old block %s: %w", block.ULID.String(), err)
-		}
-		totalReclaimed += blockSize
+	for _, block := range merge.blocks {
+		totalReclaimed += block.Size()
+	}
+	if err := c.leases.commit(outputDirs, merge.blocks); err != nil {
+		return fmt.Errorf("failed to delete old blocks: %w", err)
 	}
 
 	// Update metrics
-	c.stats.BlocksMerged.Add(int64(len(blocks)))
+	c.stats.BlocksMerged.Add(int64(len(merge.blocks)))
 	c.stats.BytesReclaimed.Add(totalReclaimed)
+	c.observeMerge(time.Since(start), merge, outputIDs, numSeries, totalReclaimed)
 
 	return nil
 }
 
+// removeBlock deletes block now, or once the last query reading it is
+// done
+func (c *Compactor) removeBlock(block *Block) error {
+	defer c.blockReader.Invalidate()
+	return c.leases.remove(block, false)
+}
+
+// throttle waits until n bytes of block I/O may proceed, failing if the
+// compactor is stopped first
+func (c *Compactor) throttle(n int) error {
+	return c.io.wait(c.ctx, int64(n))
+}
+
 // deduplicateSamples removes duplicate samples and sorts by timestamp
 func (c *Compactor) deduplicateSamples(samples []series.Sample) []series.Sample {
 	if len(samples) <= 1 {
 		return samples
 	}
 
-	// Sort by timestamp
-	sort.Slice(samples, func(i, j int) bool {
+	// Sort by timestamp, keeping the order of samples sharing one
+	sort.SliceStable(samples, func(i, j int) bool {
 		return samples[i].Timestamp < samples[j].Timestamp
 	})
 
@@ -367,43 +558,52 @@ func (c *Compactor) groupBlocksByTimeWindow(blocks []*Block, windowDuration time
 	return groups
 }
 
-// getBlocksByLevel filters blocks by their level (based on duration)
+// getBlocksByLevel filters blocks by their recorded level
 func (c *Compactor) getBlocksByLevel(blocks []*Block, level CompactionLevel) []*Block {
 	var result []*Block
-	levelDuration := c.getLevelDuration(level)
-	tolerance := time.Hour.Milliseconds() // Allow some tolerance
-
 	for _, block := range blocks {
-		duration := block.MaxTime - block.MinTime
-		expectedDuration := levelDuration.Milliseconds()
-
-		// Check if block duration matches this level (with tolerance)
-		if duration >= expectedDuration-tolerance && duration <= expectedDuration+tolerance {
+		if block.Level == level {
 			result = append(result, block)
 		}
 	}
-
 	return result
 }
 
+// inferLevel guesses the level of a block written before levels were
+// recorded from its time range: a block spanning over an hour more than
+// one level's duration belongs to the next level
+func inferLevel(minTime, maxTime int64) CompactionLevel {
+	duration := time.Duration(maxTime-minTime) * time.Millisecond
+	switch {
+	case duration > Level1Duration+time.Hour:
+		return Level2
+	case duration > Level0Duration+time.Hour:
+		return Level1
+	default:
+		return Level0
+	}
+}
+
 // getLevelDuration returns the duration for a compaction level
 func (c *Compactor) getLevelDuration(level CompactionLevel) time.Duration {
-	switch level {
-	case Level0:
-		return Level0Duration
-	case Level1:
-		return Level1Duration
-	case Level2:
-		return Level2Duration
-	default:
+	if level <= Level0 || int(level) > len(c.levels) {
 		return Level0Duration
 	}
+	return c.levels[level-1].Duration
+}
+
+// minBlocks returns the number of blocks merged into a block of level
+func (c *Compactor) minBlocks(level CompactionLevel) int {
+	if level <= Level0 || int(level) > len(c.levels) || c.levels[level-1].MinBlocks == 0 {
+		return MinBlocksForCompaction
+	}
+	return c.levels[level-1].MinBlocks
 }
 
 // GetStats returns a snapshot of compaction statistics
-func (c *Compactor) GetStats() CompactionStats {
+func (c *Compactor) GetStats() *CompactionStats {
 	// Return a copy of the current stats
-	var stats CompactionStats
+	stats := &CompactionStats{}
 	stats.TotalCompactions.Store(c.stats.TotalCompactions.Load())
 	stats.BlocksMerged.Store(c.stats.BlocksMerged.Load())
 	stats.BytesReclaimed.Store(c.stats.BytesReclaimed.Load())
@@ -411,14 +611,25 @@ func (c *Compactor) GetStats() CompactionStats {
 	stats.CompactionErrors.Store(c.stats.CompactionErrors.Load())
 	stats.Level0Compactions.Store(c.stats.Level0Compactions.Load())
 	stats.Level1Compactions.Store(c.stats.Level1Compactions.Load())
+	stats.VerticalCompactions.Store(c.stats.VerticalCompactions.Load())
 	return stats
 }
 
-// CompactNow triggers an immediate compaction (for testing/debugging)
+// recordError counts a failed compaction cycle
+func (c *Compactor) recordError() {
+	c.stats.CompactionErrors.Add(1)
+	if c.metrics != nil {
+		c.metrics.RecordCompactionFailure()
+	}
+}
+
+// CompactNow runs a compaction cycle immediately and returns once it is done
 func (c *Compactor) CompactNow() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	return c.compact()
+	if err := c.compact(); err != nil {
+		c.recordError()
+		return err
+	}
+	return nil
 }
 
 // BlockCount returns the number of blocks at each level
@@ -447,32 +658,84 @@ func (c *Compactor) SetDataDir(dir string) {
 	c.blockWriter = NewBlockWriter(dir)
 }
 
-// CleanupOldBlocks removes blocks older than the specified cutoff time
-// This is used by the retention policy
+// CleanupOldBlocks removes blocks older than the specified cutoff time,
+// skipping protected blocks. This is used by the retention policy
 func (c *Compactor) CleanupOldBlocks(cutoffTime int64) (int, error) {
+	deleted, _, err := c.cleanupBlocks(func(*Block) int64 { return cutoffTime })
+	return deleted, err
+}
+
+// cleanupBlocks deletes the unprotected blocks whose maxTime is older than
+// their cutoff, moving them to the trash if there is a grace period. It
+// returns the number of blocks deleted and the newest cutoff among them.
+func (c *Compactor) cleanupBlocks(cutoff func(block *Block) int64) (deleted int, purgedBefore int64, err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if err := c.blockReader.LoadBlocks(); err != nil {
-		return 0, fmt.Errorf("failed to load blocks: %w", err)
+		return 0, 0, fmt.Errorf("failed to load blocks: %w", err)
 	}
 
-	blocks := c.blockReader.Blocks()
-	deletedCount := 0
-
-	for _, block := range blocks {
-		// Delete block if its maxTime is older than cutoff
-		if block.MaxTime < cutoffTime {
+	for _, block := range c.blockReader.Blocks() {
+		// Delete block if its maxTime is older than cutoff, unless it is held
+		blockCutoff := cutoff(block)
+		if block.MaxTime < blockCutoff && !block.Protected() {
 			blockSize := block.Size()
-			if err := block.Delete(); err != nil {
-				return deletedCount, fmt.Errorf("failed to delete block %s: %w", block.ULID.String(), err)
+			if err := c.trashBlock(block); err != nil {
+				return deleted, purgedBefore, fmt.Errorf("failed to delete block %s: %w", block.ULID.String(), err)
 			}
-			deletedCount++
+			deleted++
+			purgedBefore = max(purgedBefore, blockCutoff)
 			c.stats.BytesReclaimed.Add(blockSize)
 		}
 	}
 
-	return deletedCount, nil
+	return deleted, purgedBefore, nil
+}
+
+// DeleteOldestBlocks deletes unprotected blocks, oldest first, until
+// enough returns true. The trash of the data directory is emptied first,
+// and blocks are deleted at once rather than moved there. It returns the
+// number of blocks deleted and the newest MaxTime among them.
+func (c *Compactor) DeleteOldestBlocks(enough func() bool) (deleted int, maxTime int64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.blockReader.LoadBlocks(); err != nil {
+		return 0, 0, fmt.Errorf("failed to load blocks: %w", err)
+	}
+
+	if !enough() {
+		if _, err := purgeTrash(time.Now(), c.dataDir); err != nil {
+			return 0, 0, fmt.Errorf("failed to empty trash: %w", err)
+		}
+	}
+
+	blocks := c.blockReader.Blocks()
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].MinTime < blocks[j].MinTime
+	})
+
+	for _, block := range blocks {
+		if enough() {
+			break
+		}
+		// Deleting cold blocks frees no space in the data directory
+		if block.Protected() || c.isCold(block) {
+			continue
+		}
+		blockSize := block.Size()
+		if err := c.removeBlock(block); err != nil {
+			return deleted, maxTime, fmt.Errorf("failed to delete block %s: %w", block.ULID.String(), err)
+		}
+		deleted++
+		if block.MaxTime > maxTime {
+			maxTime = block.MaxTime
+		}
+		c.stats.BytesReclaimed.Add(blockSize)
+	}
+
+	return deleted, maxTime, nil
 }
 
 // ValidateBlocks checks all blocks for corruption
@@ -3,6 +3,7 @@ package storage
 import (
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
@@ -116,6 +117,82 @@ func TestCompactorMergeBlocks(t *testing.T) {
 	}
 }
 
+// indexedSeries returns the label sets in the index of the block in dir,
+// with their hashes checked against the block's chunk mapping
+func indexedSeries(t *testing.T, dir string) []string {
+	t.Helper()
+	block, err := OpenBlock(dir)
+	if err != nil {
+		t.Fatalf("failed to open block: %v", err)
+	}
+	defer block.Close()
+	set, err := block.Series()
+	if err != nil {
+		t.Fatalf("failed to read series: %v", err)
+	}
+	var names []string
+	for _, s := range set {
+		if s.Hash != series.NewSeries(s.Labels).Hash {
+			t.Errorf("series %v indexed under the chunk of another series", s.Labels)
+		}
+		names = append(names, s.String())
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestCompactorMergeIndexes(t *testing.T) {
+	dataDir := t.TempDir()
+	cpu := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "a"})
+	mem := series.NewSeries(map[string]string{"__name__": "mem_usage", "host": "a"})
+	disk := series.NewSeries(map[string]string{"__name__": "disk_usage", "host": "b"})
+	blocks := []*Block{
+		writeTenantBlock(t, dataDir, "a", 1000, cpu, mem),
+		writeTenantBlock(t, dataDir, "a", 2000, cpu, disk),
+	}
+	want := []string{cpu.String(), disk.String(), mem.String()}
+	slices.Sort(want)
+
+	c := NewCompactor(DefaultCompactorOptions(dataDir))
+	defer c.Stop()
+	if err := c.mergeBlocks(blocks); err != nil {
+		t.Fatalf("failed to merge blocks: %v", err)
+	}
+	if err := c.blockReader.LoadBlocks(); err != nil {
+		t.Fatal(err)
+	}
+	merged := c.blockReader.Blocks()
+	if len(merged) != 1 {
+		t.Fatalf("%d blocks after merging, want 1", len(merged))
+	}
+	if got := indexedSeries(t, merged[0].Dir()); !slices.Equal(got, want) {
+		t.Errorf("merged index holds %v, want %v", got, want)
+	}
+
+	// Series deleted by a rewrite are dropped from the index
+	c.mu.Lock()
+	err := c.rewriteBlock(merged[0], map[uint64]bool{mem.Hash: true})
+	c.mu.Unlock()
+	if err != nil {
+		t.Fatalf("failed to rewrite block: %v", err)
+	}
+	if err := c.blockReader.LoadBlocks(); err != nil {
+		t.Fatal(err)
+	}
+	rewritten := c.blockReader.Blocks()[0]
+	want = slices.DeleteFunc(want, func(s string) bool { return s == mem.String() })
+	if got := indexedSeries(t, rewritten.Dir()); !slices.Equal(got, want) {
+		t.Errorf("rewritten index holds %v, want %v", got, want)
+	}
+	idx, err := rewritten.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := idx.LabelValues("__name__"); slices.Contains(values, "mem_usage") {
+		t.Errorf("deleted series' labels still indexed: %v", values)
+	}
+}
+
 func TestCompactorDeduplication(t *testing.T) {
 	// Create temporary directory
 	tmpDir, err := os.MkdirTemp("", "compactor_dedup_test_*")
@@ -233,15 +310,18 @@ func TestCompactorGetBlocksByLevel(t *testing.T) {
 
 	baseTime := time.Now().UnixMilli()
 
-	// Create blocks at different levels
+	// Create blocks at different levels. Levels are recorded, not
+	// inferred, so a partially filled block keeps its level.
 	// Level 0: 2 hours
 	block0, _ := NewBlock(baseTime, baseTime+Level0Duration.Milliseconds())
 
-	// Level 1: 12 hours
-	block1, _ := NewBlock(baseTime, baseTime+Level1Duration.Milliseconds())
+	// Level 1: 3 hours, from a low-volume window
+	block1, _ := NewBlock(baseTime, baseTime+3*time.Hour.Milliseconds())
+	block1.Level = Level1
 
 	// Level 2: 7 days
 	block2, _ := NewBlock(baseTime, baseTime+Level2Duration.Milliseconds())
+	block2.Level = Level2
 
 	allBlocks := []*Block{block0, block1, block2}
 
@@ -264,6 +344,45 @@ func TestCompactorGetBlocksByLevel(t *testing.T) {
 	}
 }
 
+func TestBlockLevelPersisted(t *testing.T) {
+	dataDir := t.TempDir()
+	baseTime := time.Now().UnixMilli()
+	testSeries := series.NewSeries(map[string]string{"__name__": "test_metric"})
+
+	block, _ := NewBlock(baseTime, baseTime+time.Hour.Milliseconds())
+	block.Level = Level1
+	block.AddSeries(testSeries, []series.Sample{{Timestamp: baseTime, Value: 1}})
+	if err := block.Persist(dataDir); err != nil {
+		t.Fatalf("failed to persist block: %v", err)
+	}
+
+	reopened, err := OpenBlock(block.Dir())
+	if err != nil {
+		t.Fatalf("failed to open block: %v", err)
+	}
+	defer reopened.Close()
+	if reopened.Level != Level1 {
+		t.Errorf("reopened block at level %d, want %d", reopened.Level, Level1)
+	}
+
+	// Blocks written before levels were recorded get one from their range
+	tests := []struct {
+		duration time.Duration
+		want     CompactionLevel
+	}{
+		{Level0Duration, Level0},
+		{30 * time.Minute, Level0},
+		{6 * time.Hour, Level1},
+		{Level1Duration, Level1},
+		{Level2Duration, Level2},
+	}
+	for _, tt := range tests {
+		if got := inferLevel(baseTime, baseTime+tt.duration.Milliseconds()); got != tt.want {
+			t.Errorf("inferLevel(%s) = %d, want %d", tt.duration, got, tt.want)
+		}
+	}
+}
+
 func TestCompactorBlockCount(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "compactor_count_test_*")
 	if err != nil {
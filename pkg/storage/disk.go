@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultDiskCheckInterval is how often the disk watchdog checks free space
+	DefaultDiskCheckInterval = 30 * time.Second
+)
+
+// diskWatchdog periodically checks free space under the data directory
+func (db *TSDB) diskWatchdog() {
+	defer close(db.diskWatchdogDone)
+
+	ticker := time.NewTicker(db.diskCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := db.checkDisk(); err != nil {
+				fmt.Printf("tsdb: disk check failed: %v\n", err)
+			}
+		}
+	}
+}
+
+// checkDisk records free space under the data directory and switches the
+// TSDB into read-only mode when it drops below the minimum, or back out
+// once it has recovered. With emergency retention enabled, the oldest
+// blocks are deleted first to try to stay writable.
+func (db *TSDB) checkDisk() error {
+	free, err := db.freeDiskSpace()
+	if err != nil {
+		return err
+	}
+
+	if free < db.minFreeDisk && db.emergencyRetention && db.compactor != nil {
+		deleted, maxTime, err := db.compactor.DeleteOldestBlocks(func() bool {
+			free, err = db.freeDiskSpace()
+			return err != nil || free >= db.minFreeDisk
+		})
+		if deleted > 0 {
+			fmt.Printf("tsdb: emergency retention deleted %d blocks\n", deleted)
+			db.stats.EmergencyBlocksDeleted.Add(int64(deleted))
+			if maxTime+1 > db.emergencyPurgedBefore.Load() {
+				db.emergencyPurgedBefore.Store(maxTime + 1)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("emergency retention failed: %w", err)
+		}
+	}
+
+	db.setReadOnly(free < db.minFreeDisk)
+	return nil
+}
+
+// freeDiskSpace returns the free bytes under the data directory and
+// records them, with the filesystem size, in stats
+func (db *TSDB) freeDiskSpace() (int64, error) {
+	free, total, err := db.diskUsage(db.dataDir)
+	if err != nil {
+		return 0, err
+	}
+	db.stats.DiskFreeBytes.Store(int64(free))
+	db.stats.DiskTotalBytes.Store(int64(total))
+	return int64(free), nil
+}
+
+// setReadOnly switches read-only mode. While read-only, writes fail with
+// ErrReadOnly and no blocks are flushed or compacted; queries still work.
+func (db *TSDB) setReadOnly(readOnly bool) {
+	if db.readOnly.Swap(readOnly) == readOnly {
+		return
+	}
+	if db.compactor != nil {
+		db.compactor.SetReadOnly(readOnly)
+	}
+	if readOnly {
+		fmt.Printf("tsdb: free disk space below %d bytes, entering read-only mode\n", db.minFreeDisk)
+	} else {
+		fmt.Printf("tsdb: free disk space recovered, leaving read-only mode\n")
+	}
+}
+
+// ReadOnly reports whether the TSDB is refusing writes for lack of disk
+// space
+func (db *TSDB) ReadOnly() bool {
+	return db.readOnly.Load()
+}
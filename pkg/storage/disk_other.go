@@ -0,0 +1,10 @@
+//go:build !unix
+
+package storage
+
+import "errors"
+
+// diskUsage is not supported on this platform; the disk watchdog stays idle.
+func diskUsage(dir string) (free, total uint64, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// fakeDiskUsage reports free bytes from a function, out of 1000 in total
+func fakeDiskUsage(free func() uint64) func(string) (uint64, uint64, error) {
+	return func(string) (uint64, uint64, error) {
+		return free(), 1000, nil
+	}
+}
+
+func TestDiskWatchdogReadOnly(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	opts.FlushInterval = time.Hour
+	opts.MinFreeDiskBytes = 100
+	opts.DiskCheckInterval = time.Hour
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	s := series.NewSeries(map[string]string{"__name__": "disk_test"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	free := uint64(50)
+	db.diskUsage = fakeDiskUsage(func() uint64 { return free })
+	if err := db.checkDisk(); err != nil {
+		t.Fatalf("checkDisk failed: %v", err)
+	}
+
+	if !db.ReadOnly() {
+		t.Fatal("expected read-only mode below the free space minimum")
+	}
+	if err := db.Insert(s, []series.Sample{{Timestamp: 2000, Value: 2}}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly on insert, got %v", err)
+	}
+	app := db.Appender()
+	if err := app.Append(s.Labels, 2000, 2); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	if err := app.Commit(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly on commit, got %v", err)
+	}
+	if err := db.TriggerFlush(context.Background()); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly on flush, got %v", err)
+	}
+
+	// Queries keep working
+	samples, err := db.Query(s.Hash, 0, 3000)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(samples) != 1 {
+		t.Errorf("expected 1 sample, got %d", len(samples))
+	}
+
+	stats := db.GetStatsSnapshot()
+	if !stats.ReadOnly || stats.DiskFreeBytes != 50 || stats.DiskTotalBytes != 1000 {
+		t.Errorf("unexpected disk stats: %+v", stats)
+	}
+
+	// Freed space makes the TSDB writable again
+	free = 500
+	if err := db.checkDisk(); err != nil {
+		t.Fatalf("checkDisk failed: %v", err)
+	}
+	if db.ReadOnly() {
+		t.Fatal("expected read-only mode to end once space is freed")
+	}
+	if err := db.Insert(s, []series.Sample{{Timestamp: 2000, Value: 2}}); err != nil {
+		t.Errorf("insert failed after leaving read-only mode: %v", err)
+	}
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		t.Errorf("flush failed after leaving read-only mode: %v", err)
+	}
+}
+
+func TestDiskWatchdogEmergencyRetention(t *testing.T) {
+	dataDir := t.TempDir()
+
+	opts := DefaultOptions(dataDir)
+	opts.EnableRetention = false
+	opts.CompactionInterval = time.Hour
+	opts.FlushInterval = time.Hour
+	opts.MinFreeDiskBytes = 150
+	opts.DiskCheckInterval = time.Hour
+	opts.EmergencyRetention = true
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	// Written after Open so the initial compaction cycle leaves them alone
+	writeLevel0Blocks(t, dataDir, 3, 1)
+	if _, err := db.ScanBlocks(); err != nil {
+		t.Fatalf("ScanBlocks failed: %v", err)
+	}
+	dirs, _ := blockDirs(dataDir)
+	second, err := readBlockMeta(dirs[1])
+	if err != nil {
+		t.Fatalf("readBlockMeta failed: %v", err)
+	}
+
+	// Each deleted block frees 100 bytes
+	db.diskUsage = fakeDiskUsage(func() uint64 {
+		remaining, _ := blockDirs(dataDir)
+		return uint64(3-len(remaining)) * 100
+	})
+	if err := db.checkDisk(); err != nil {
+		t.Fatalf("checkDisk failed: %v", err)
+	}
+
+	remaining, _ := blockDirs(dataDir)
+	if len(remaining) != 1 || remaining[0] != dirs[2] {
+		t.Errorf("expected only the newest block to remain, got %v", remaining)
+	}
+	if db.ReadOnly() {
+		t.Error("expected emergency retention to keep the TSDB writable")
+	}
+	if got := db.GetStatsSnapshot().EmergencyBlocksDeleted; got != 2 {
+		t.Errorf("EmergencyBlocksDeleted = %d, want 2", got)
+	}
+	if got := db.PurgedBefore(); got != second.MaxTime+1 {
+		t.Errorf("PurgedBefore = %d, want %d", got, second.MaxTime+1)
+	}
+}
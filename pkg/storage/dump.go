@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"math"
+	"sort"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// DumpOptions selects the data Dump reads
+type DumpOptions struct {
+	// MinTime and MaxTime restrict samples to [MinTime, MaxTime] (Unix
+	// milliseconds). A zero MaxTime means no upper bound.
+	MinTime int64
+	MaxTime int64
+
+	// MatcherSets selects series matching any of the sets; none selects all
+	MatcherSets []index.Matchers
+}
+
+// Dump reads the persisted blocks of a data directory and calls fn once per
+// matching series, ordered by metric name and then labels, with its samples
+// from all blocks merged in timestamp order. Samples still in the WAL are
+// not included. Dump only reads the data directory, so it can run while the
+// database is offline.
+func Dump(dataDir string, opts DumpOptions, fn func(labels map[string]string, samples []series.Sample) error) error {
+	maxTime := opts.MaxTime
+	if maxTime == 0 {
+		maxTime = math.MaxInt64
+	}
+
+	dirs, err := blockDirs(dataDir)
+	if err != nil {
+		return err
+	}
+
+	// Open the blocks overlapping the range and collect the matching series
+	var blocks []*Block
+	defer func() {
+		for _, block := range blocks {
+			block.Close()
+		}
+	}()
+
+	byKey := make(map[string]*series.Series)
+	for _, dir := range dirs {
+		block, err := OpenBlock(dir)
+		if err != nil {
+			return err
+		}
+		if !block.Overlaps(opts.MinTime, maxTime) {
+			continue
+		}
+		blocks = append(blocks, block)
+
+		blockSeries, err := block.Series(opts.MatcherSets...)
+		if err != nil {
+			return err
+		}
+		for _, s := range blockSeries {
+			byKey[dumpSortKey(s)] = s
+		}
+	}
+
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].MinTime < blocks[j].MinTime
+	})
+
+	for _, key := range sortedKeys(byKey) {
+		s := byKey[key]
+
+		var sources [][]series.Sample
+		for _, block := range blocks {
+			if !block.MayContainSeries(s.Hash) {
+				continue
+			}
+			blockSamples, err := block.GetSeries(s.Hash, opts.MinTime, maxTime)
+			if err != nil {
+				return err
+			}
+			sources = append(sources, sortSamples(blockSamples))
+		}
+		samples := mergeSources(sources...)
+		if len(samples) == 0 {
+			continue
+		}
+
+		if err := fn(s.Labels, samples); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dumpSortKey orders series by metric name, then by their full label set
+func dumpSortKey(s *series.Series) string {
+	return s.Labels[MetricNameLabel] + "\x00" + s.String()
+}
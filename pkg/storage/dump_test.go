@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestDump(t *testing.T) {
+	dataDir := t.TempDir()
+
+	// Two blocks holding the same series; the second rewrites one sample
+	for i, samples := range [][]series.Sample{
+		{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}},
+		{{Timestamp: 2000, Value: 20}, {Timestamp: 3000, Value: 3}},
+	} {
+		block, err := NewBlock(int64(i+1)*1000, int64(i+3)*1000)
+		if err != nil {
+			t.Fatalf("NewBlock failed: %v", err)
+		}
+		for _, labels := range []map[string]string{
+			{"__name__": "mem", "host": "a"},
+			{"__name__": "cpu", "host": "b"},
+			{"__name__": "cpu", "host": "a"},
+		} {
+			if err := block.AddSeries(series.NewSeries(labels), samples); err != nil {
+				t.Fatalf("AddSeries failed: %v", err)
+			}
+		}
+		if err := block.Persist(dataDir); err != nil {
+			t.Fatalf("Persist failed: %v", err)
+		}
+	}
+
+	dump := func(opts DumpOptions) []string {
+		var out []string
+		err := Dump(dataDir, opts, func(labels map[string]string, samples []series.Sample) error {
+			out = append(out, fmt.Sprintf("%s %v", series.NewSeries(labels).String(), samples))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Dump failed: %v", err)
+		}
+		return out
+	}
+
+	got := dump(DumpOptions{})
+	want := []string{
+		`{__name__="cpu", host="a"} [{1000 1} {2000 20} {3000 3}]`,
+		`{__name__="cpu", host="b"} [{1000 1} {2000 20} {3000 3}]`,
+		`{__name__="mem", host="a"} [{1000 1} {2000 20} {3000 3}]`,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Dump = %v, want %v", got, want)
+	}
+
+	got = dump(DumpOptions{
+		MinTime:     2500,
+		MatcherSets: []index.Matchers{{index.MustNewMatcher(index.MatchEqual, "host", "b")}},
+	})
+	want = []string{`{__name__="cpu", host="b"} [{3000 3}]`}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Dump = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/wal"
+)
+
+// Features reports which optional parts of the TSDB are enabled
+type Features struct {
+	Compaction   bool
+	Retention    bool
+	DiskWatchdog bool   // Options.MinFreeDiskBytes is set
+	Tenant       string // Options.Tenant; empty for a single-tenant TSDB
+}
+
+// Features returns the optional parts of the TSDB enabled by its options.
+func (db *TSDB) Features() Features {
+	return Features{
+		Compaction:   db.compactor != nil,
+		Retention:    db.retentionManager != nil,
+		DiskWatchdog: db.minFreeDisk > 0,
+		Tenant:       db.tenant,
+	}
+}
+
+// FormatVersions are the on-disk format versions this build writes, and
+// the oldest block format it reads
+type FormatVersions struct {
+	Block    int
+	MinBlock int
+	Index    uint32
+	WAL      int
+}
+
+// CurrentFormatVersions returns the format versions of this build
+func CurrentFormatVersions() FormatVersions {
+	return FormatVersions{
+		Block:    BlockVersion,
+		MinBlock: MinBlockVersion,
+		Index:    index.IndexFormatVersion,
+		WAL:      wal.FormatVersion,
+	}
+}
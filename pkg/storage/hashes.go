@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// blockHashes holds the series written to blocks under a
+// series.CollisionHash, by hash and by label set. It is replaced, never
+// changed, so it can be read without a lock.
+type blockHashes struct {
+	byHash   map[uint64]*series.Series
+	byLabels map[string]uint64
+}
+
+// hash returns the hash s is stored under in blocks, if it was given a
+// series.CollisionHash
+func (bh *blockHashes) hash(s *series.Series) (uint64, bool) {
+	if bh == nil || len(bh.byLabels) == 0 {
+		return 0, false
+	}
+	hash, ok := bh.byLabels[s.String()]
+	return hash, ok
+}
+
+// series returns the series stored in blocks under a collision hash
+func (bh *blockHashes) series(hash uint64) (*series.Series, bool) {
+	if bh == nil {
+		return nil, false
+	}
+	s, ok := bh.byHash[hash]
+	return s, ok
+}
+
+// collisionSeries returns the series of meta stored under a
+// series.CollisionHash, as recorded by Block.Persist
+func collisionSeries(meta *BlockMeta) ([]*series.Series, error) {
+	result := make([]*series.Series, 0, len(meta.Collisions))
+	for hashStr, labels := range meta.Collisions {
+		hash, err := strconv.ParseUint(hashStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid collision hash %q: %w", hashStr, err)
+		}
+		result = append(result, &series.Series{Labels: labels, Hash: hash})
+	}
+	return result, nil
+}
+
+// addBlockHashes makes the head give the series of meta stored under a
+// series.CollisionHash that hash again, and keep other label sets off it.
+// Without this, a label set written again after a restart would be
+// resolved against the head alone and could land under another hash than
+// its block data, or under the hash of another series' block data.
+func (h *Head) addBlockHashes(meta *BlockMeta) error {
+	if len(meta.Collisions) == 0 {
+		return nil
+	}
+	added, err := collisionSeries(meta)
+	if err != nil {
+		return err
+	}
+
+	h.seriesMu.Lock()
+	defer h.seriesMu.Unlock()
+
+	next := &blockHashes{
+		byHash:   make(map[uint64]*series.Series),
+		byLabels: make(map[string]uint64),
+	}
+	if old := h.collisions.Load(); old != nil {
+		for hash, s := range old.byHash {
+			next.byHash[hash] = s
+		}
+		for key, hash := range old.byLabels {
+			next.byLabels[key] = hash
+		}
+	}
+	for _, s := range added {
+		next.byHash[s.Hash] = s
+		next.byLabels[s.String()] = s.Hash
+	}
+	h.collisions.Store(next)
+	return nil
+}
+
+// loadBlockHashes adds the collision hashes recorded by the blocks in
+// dataDirs to the head. Blocks whose metadata can't be read are skipped.
+func (db *TSDB) loadBlockHashes(dataDirs ...string) error {
+	dirs, err := blockDirs(dataDirs...)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		meta, err := readBlockMeta(dir)
+		if err != nil {
+			continue // Added once adopted, if the block scanner reads it
+		}
+		if err := db.head.addBlockHashes(meta); err != nil {
+			return fmt.Errorf("block %s: %w", filepath.Base(dir), err)
+		}
+	}
+	return nil
+}
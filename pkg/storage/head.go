@@ -0,0 +1,374 @@
+package storage
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// errHashCollision indicates two label sets written under the same hash
+var errHashCollision = errors.New("series hash collision")
+
+// Head is the in-memory part of the database: the active MemTable taking
+// writes, the MemTable being flushed to a block, if any, and the index of
+// every series written since startup.
+//
+// Its read methods are safe for concurrent use and see both MemTables.
+// Writes and MemTable swaps are driven by the TSDB.
+type Head struct {
+	mu       sync.RWMutex
+	active   *MemTable
+	flushing *MemTable // nil unless a flush is in progress or has failed
+	policy   SamplePolicy
+
+	// Series registry and inverted index over all known series
+	seriesMu sync.Mutex // Serializes registering new series
+	registry *series.Registry
+	index    *index.InvertedIndex
+
+	// Series stored in blocks under a series.CollisionHash
+	collisions atomic.Pointer[blockHashes]
+
+	// Series created and ended per metric name
+	churn *seriesChurn
+}
+
+// NewHead creates an empty head whose MemTables hold up to maxSize bytes
+// in the given number of shards and apply policy to incoming samples
+func NewHead(maxSize int64, shards int, policy SamplePolicy) *Head {
+	h := &Head{
+		active:   NewShardedMemTable(maxSize, shards),
+		policy:   policy,
+		registry: series.NewRegistry(series.RegistryConfig{}),
+		index:    index.NewInvertedIndex(),
+		churn:    newSeriesChurn(),
+	}
+	h.active.SetSamplePolicy(policy)
+	return h
+}
+
+// memTables returns the active MemTable and the flushing one, which may be nil
+func (h *Head) memTables() (active, flushing *MemTable) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.active, h.flushing
+}
+
+// activeMemTable returns the MemTable currently taking writes
+func (h *Head) activeMemTable() *MemTable {
+	active, _ := h.memTables()
+	return active
+}
+
+// flushingMemTable returns the MemTable being flushed, or nil
+func (h *Head) flushingMemTable() *MemTable {
+	_, flushing := h.memTables()
+	return flushing
+}
+
+// cutMemTable makes the active MemTable the flushing one and starts a new
+// active MemTable. It returns nil, changing nothing, if the active MemTable
+// is empty.
+func (h *Head) cutMemTable() *MemTable {
+	h.mu.Lock()
+	if h.active.SeriesCount() == 0 {
+		h.mu.Unlock()
+		return nil
+	}
+
+	old := h.active
+	h.active = NewShardedMemTable(old.MaxSize(), old.ShardCount())
+	h.active.SetSamplePolicy(h.policy)
+	h.flushing = old
+	h.mu.Unlock()
+
+	h.churn.cut(old, time.Now())
+	return old
+}
+
+// clearFlushing drops the flushing MemTable once its block is durable
+func (h *Head) clearFlushing() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.flushing = nil
+}
+
+// Query returns the samples of a series within [start, end] from both
+// MemTables, sorted by timestamp. A timestamp held by both keeps the
+// active MemTable's sample, which was written last.
+func (h *Head) Query(seriesHash uint64, start, end int64) ([]series.Sample, error) {
+	active, flushing := h.memTables()
+
+	activeSamples, err := active.Query(seriesHash, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if flushing == nil {
+		if len(activeSamples) == 0 {
+			return nil, nil
+		}
+		return activeSamples, nil // Already a sorted copy
+	}
+	flushingSamples, err := flushing.Query(seriesHash, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return mergeSources(flushingSamples, activeSamples), nil
+}
+
+// Latest returns the newest sample of a series within [start, end] in
+// either MemTable. A timestamp held by both keeps the active MemTable's
+// sample, as in Query.
+func (h *Head) Latest(seriesHash uint64, start, end int64) (series.Sample, bool) {
+	active, flushing := h.memTables()
+
+	latest, ok := active.Latest(seriesHash, start, end)
+	if flushing == nil {
+		return latest, ok
+	}
+	if flushed, found := flushing.Latest(seriesHash, start, end); found && (!ok || flushed.Timestamp > latest.Timestamp) {
+		return flushed, true
+	}
+	return latest, ok
+}
+
+// sortSamples sorts samples by timestamp in place, keeping the insertion
+// order of equal timestamps, and returns them
+func sortSamples(samples []series.Sample) []series.Sample {
+	slices.SortStableFunc(samples, compareTimestamps)
+	return samples
+}
+
+// compareTimestamps orders samples by timestamp
+func compareTimestamps(a, b series.Sample) int {
+	return cmp.Compare(a.Timestamp, b.Timestamp)
+}
+
+// GetSeries returns the series with the given hash if either MemTable
+// holds samples for it
+func (h *Head) GetSeries(seriesHash uint64) (*series.Series, bool) {
+	active, flushing := h.memTables()
+
+	if s, ok := active.GetSeries(seriesHash); ok {
+		return s, true
+	}
+	if flushing != nil {
+		return flushing.GetSeries(seriesHash)
+	}
+	return nil, false
+}
+
+// LabelNames returns the sorted label names of all known series
+func (h *Head) LabelNames() []string {
+	return h.index.LabelNames()
+}
+
+// LabelValues returns the sorted values of a label among all known series
+func (h *Head) LabelValues(name string) []string {
+	return h.index.LabelValues(name)
+}
+
+// AllPostings returns the IDs of all known series
+func (h *Head) AllPostings() *roaring.Bitmap {
+	return h.index.All()
+}
+
+// Postings returns the IDs of the series matching all matchers; no
+// matchers match every series
+func (h *Head) Postings(matchers index.Matchers) (*roaring.Bitmap, error) {
+	if len(matchers) == 0 {
+		return h.AllPostings(), nil
+	}
+	return h.index.Lookup(matchers)
+}
+
+// MatchingPostings returns the IDs of the series matching any of the
+// matcher sets; no matcher sets match every series
+func (h *Head) MatchingPostings(matcherSets ...index.Matchers) (*roaring.Bitmap, error) {
+	return index.MatchingPostings(h.index, matcherSets...)
+}
+
+// MatchingLabelNames returns the sorted label names of the series matching
+// any of the matcher sets; no matcher sets match every series
+func (h *Head) MatchingLabelNames(matcherSets ...index.Matchers) ([]string, error) {
+	if len(matcherSets) == 0 {
+		return h.LabelNames(), nil
+	}
+	ids, err := h.MatchingPostings(matcherSets...)
+	if err != nil {
+		return nil, err
+	}
+	return index.LabelNamesOf(h.index, ids)
+}
+
+// MatchingLabelValues returns the sorted values of a label among the
+// series matching any of the matcher sets; no matcher sets match every
+// series
+func (h *Head) MatchingLabelValues(name string, matcherSets ...index.Matchers) ([]string, error) {
+	if len(matcherSets) == 0 {
+		return h.LabelValues(name), nil
+	}
+	ids, err := h.MatchingPostings(matcherSets...)
+	if err != nil {
+		return nil, err
+	}
+	return index.LabelValuesOf(h.index, name, ids)
+}
+
+// Select returns the series matching all matchers, sorted by label set
+// (see series.CompareLabels); no matchers select every series
+func (h *Head) Select(matchers index.Matchers) ([]*series.Series, error) {
+	ids, err := h.Postings(matchers)
+	if err != nil {
+		return nil, err
+	}
+	result := h.Series(ids)
+	slices.SortFunc(result, func(a, b *series.Series) int {
+		return series.CompareLabels(a.Labels, b.Labels)
+	})
+	return result, nil
+}
+
+// Series resolves series IDs returned by Postings
+func (h *Head) Series(ids *roaring.Bitmap) []*series.Series {
+	result := make([]*series.Series, 0, ids.GetCardinality())
+	it := ids.Iterator()
+	for it.HasNext() {
+		if s, ok := h.registry.GetSeries(series.SeriesID(it.Next())); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// SeriesInRange resolves series IDs to the series that have samples in
+// [minTime, maxTime] in either MemTable
+func (h *Head) SeriesInRange(ids *roaring.Bitmap, minTime, maxTime int64) []*series.Series {
+	active, flushing := h.memTables()
+
+	var result []*series.Series
+	for _, s := range h.Series(ids) {
+		if active.HasSamples(s.Hash, minTime, maxTime) ||
+			(flushing != nil && flushing.HasSamples(s.Hash, minTime, maxTime)) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// MinTime returns the oldest timestamp in either MemTable. ok is false if
+// the head holds no samples.
+func (h *Head) MinTime() (minTime int64, ok bool) {
+	active, flushing := h.memTables()
+
+	for _, mt := range []*MemTable{active, flushing} {
+		if mt == nil || mt.SeriesCount() == 0 {
+			continue
+		}
+		if mint, _ := mt.TimeRange(); !ok || mint < minTime {
+			minTime, ok = mint, true
+		}
+	}
+	return minTime, ok
+}
+
+// resolveHash returns s, or a copy of it with a series.CollisionHash if
+// another label set in the head, in pending or in blocks holds its hash.
+// Hashes are probed attempt by attempt until one is free or held by the
+// same labels, starting from the hash blocks store s under if they
+// recorded one, so a label set gets the same hash each time it is
+// written. collided reports a collision not seen before: s is given a
+// hash no series holds yet. pending holds series about to be written
+// alongside s, by hash, and may be nil.
+func (h *Head) resolveHash(s *series.Series, pending map[uint64]*series.Series) (resolved *series.Series, collided bool) {
+	collisions := h.collisions.Load()
+	hash := s.Hash
+	if stored, ok := collisions.hash(s); ok {
+		hash = stored
+	}
+	known := false
+	for attempt := 1; ; attempt++ {
+		other, ok := h.seriesByHash(hash)
+		if !ok {
+			other, ok = pending[hash]
+		}
+		if !ok {
+			other, ok = collisions.series(hash)
+		}
+		if !ok {
+			break
+		}
+		if other.Equals(s) {
+			known = true
+			break
+		}
+		hash = series.CollisionHash(s.Labels, attempt)
+	}
+	if hash == s.Hash {
+		return s, false
+	}
+	return &series.Series{Labels: s.Labels, Hash: hash}, !known
+}
+
+// seriesByHash returns the series registered in the head with a hash
+func (h *Head) seriesByHash(hash uint64) (*series.Series, bool) {
+	id, ok := h.registry.Get(hash)
+	if !ok {
+		return nil, false
+	}
+	return h.registry.GetSeries(id)
+}
+
+// indexSeries registers a series in the head index if it is not known
+// yet. It reports whether the series was new, and returns
+// errHashCollision if another label set holds its hash: one written
+// concurrently with it while both were new, so resolveHash couldn't keep
+// them apart.
+func (h *Head) indexSeries(s *series.Series) (bool, error) {
+	if known, ok := h.seriesByHash(s.Hash); ok {
+		return false, checkSameSeries(known, s)
+	}
+
+	h.seriesMu.Lock()
+	defer h.seriesMu.Unlock()
+
+	// Double-check after acquiring the lock (another goroutine may have indexed it)
+	if known, ok := h.seriesByHash(s.Hash); ok {
+		return false, checkSameSeries(known, s)
+	}
+
+	id, err := h.registry.GetOrCreate(s.Clone())
+	if err != nil {
+		return false, err
+	}
+	if err := h.index.Add(id, s.Labels); err != nil {
+		h.registry.Delete(id)
+		return false, err
+	}
+	h.churn.created(s.Labels[MetricNameLabel], time.Now())
+	return true, nil
+}
+
+// checkSameSeries returns errHashCollision unless known, the series
+// registered with the hash of s, has its labels
+func checkSameSeries(known, s *series.Series) error {
+	if known.Equals(s) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s and %s share hash %d", errHashCollision, known, s, s.Hash)
+}
+
+// SeriesChurn reports the metric names creating and ending the most series
+// in the head over each of ChurnWindows. Series replayed from the WAL on
+// startup don't count as created.
+func (h *Head) SeriesChurn() []SeriesChurn {
+	return h.churn.snapshot(time.Now())
+}
@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestHeadReadsSpanFlushingMemTable(t *testing.T) {
+	head := NewHead(DefaultMaxSize, 1, SamplePolicy{})
+
+	s1 := series.NewSeries(map[string]string{"__name__": "cpu", "host": "a"})
+	s2 := series.NewSeries(map[string]string{"__name__": "cpu", "host": "b"})
+	for _, s := range []*series.Series{s1, s2} {
+		if _, err := head.indexSeries(s); err != nil {
+			t.Fatalf("indexSeries failed: %v", err)
+		}
+	}
+	if added, _ := head.indexSeries(s1); added {
+		t.Error("expected known series not to be indexed again")
+	}
+
+	if err := head.activeMemTable().Insert(s1, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	if cut := head.cutMemTable(); cut == nil {
+		t.Fatal("expected a non-empty MemTable to be cut")
+	}
+	if head.cutMemTable() != nil {
+		t.Error("expected cutting an empty MemTable to be a no-op")
+	}
+	if err := head.activeMemTable().Insert(s1, []series.Sample{{Timestamp: 2000, Value: 2}}); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+
+	samples, err := head.Query(s1.Hash, 0, 3000)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(samples) != 2 {
+		t.Errorf("expected samples from both MemTables, got %v", samples)
+	}
+	if mint, ok := head.MinTime(); !ok || mint != 1000 {
+		t.Errorf("MinTime = %d, %v; want 1000, true", mint, ok)
+	}
+
+	m, _ := index.NewMatcher(index.MatchEqual, "host", "b")
+	selected, err := head.Select(index.Matchers{m})
+	if err != nil {
+		t.Fatalf("Select failed: %v", err)
+	}
+	if len(selected) != 1 || selected[0].Hash != s2.Hash {
+		t.Errorf("expected only host=b, got %v", selected)
+	}
+
+	all, _ := head.Postings(nil)
+	if got := head.SeriesInRange(all, 0, 3000); len(got) != 1 || got[0].Hash != s1.Hash {
+		t.Errorf("expected only the series with samples in range, got %v", got)
+	}
+
+	head.clearFlushing()
+	if samples, _ := head.Query(s1.Hash, 0, 3000); len(samples) != 1 {
+		t.Errorf("expected only active samples after clearing, got %v", samples)
+	}
+}
+
+func TestSeriesHashCollision(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	opts.FlushInterval = time.Hour
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	// Label sets sharing a hash are made up, as real collisions are rare
+	cpu := func(host string, hash uint64) *series.Series {
+		s := series.NewSeries(map[string]string{"__name__": "cpu", "host": host})
+		if hash != 0 {
+			s.Hash = hash
+		}
+		return s
+	}
+	a := cpu("a", 0)
+	if err := db.Insert(a, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	for range 2 {
+		if err := db.Insert(cpu("b", a.Hash), []series.Sample{{Timestamp: 1000, Value: 2}}); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if got := db.GetStatsSnapshot().HashCollisions; got != 1 {
+		t.Errorf("HashCollisions = %d, want 1 after writing a colliding series twice", got)
+	}
+
+	// Two new series sharing a hash in one batch
+	c := cpu("c", 0)
+	batch := []*series.Series{c, cpu("d", c.Hash)}
+	if err := db.insertBatch(batch, [][]series.Sample{{{Timestamp: 1000, Value: 3}}, {{Timestamp: 1000, Value: 4}}}); err != nil {
+		t.Fatalf("failed to insert batch: %v", err)
+	}
+	if batch[1].Hash != c.Hash {
+		t.Error("insertBatch changed the caller's batch")
+	}
+	if got := db.GetStatsSnapshot().HashCollisions; got != 2 {
+		t.Errorf("HashCollisions = %d, want 2", got)
+	}
+
+	check := func(when string) {
+		t.Helper()
+		q, err := db.Querier(0, 5000)
+		if err != nil {
+			t.Fatalf("Querier failed: %v", err)
+		}
+		defer q.Close()
+		set, err := q.Select(index.MustNewMatcher(index.MatchEqual, "__name__", "cpu"))
+		if err != nil {
+			t.Fatalf("Select failed: %v", err)
+		}
+		want := map[string]float64{"a": 1, "b": 2, "c": 3, "d": 4}
+		got := make(map[string]float64)
+		for set.Next() {
+			s, samples := set.At()
+			if len(samples) != 1 {
+				t.Errorf("%s: series %s has samples %v, want 1", when, s, samples)
+				continue
+			}
+			got[s.Labels["host"]] = samples[0].Value
+		}
+		if set.Err() != nil {
+			t.Fatalf("iteration failed: %v", set.Err())
+		}
+		if !maps.Equal(got, want) {
+			t.Errorf("%s: got %v, want %v", when, got, want)
+		}
+	}
+	check("in the head")
+
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	check("after a flush")
+}
+
+func TestSeriesHashCollisionAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultOptions(dir)
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	opts.FlushInterval = time.Hour
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+
+	a := series.NewSeries(map[string]string{"__name__": "cpu", "host": "a"})
+	b := func() *series.Series {
+		return &series.Series{Labels: map[string]string{"__name__": "cpu", "host": "b"}, Hash: a.Hash}
+	}
+	if err := db.Insert(a, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.Insert(b(), []series.Sample{{Timestamp: 1000, Value: 2}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	// Only the block knows the hash b was given now
+	if err := os.RemoveAll(filepath.Join(dir, DefaultWALDir)); err != nil {
+		t.Fatalf("failed to remove WAL: %v", err)
+	}
+	db, err = Open(opts)
+	if err != nil {
+		t.Fatalf("failed to reopen TSDB: %v", err)
+	}
+	defer db.Close()
+
+	// b is written first, so a doesn't hold the hash in the head
+	if err := db.Insert(b(), []series.Sample{{Timestamp: 2000, Value: 4}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.Insert(a, []series.Sample{{Timestamp: 2000, Value: 3}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if got := db.GetStatsSnapshot().HashCollisions; got != 0 {
+		t.Errorf("HashCollisions = %d, want 0 for a collision resolved before the restart", got)
+	}
+
+	q, err := db.Querier(0, 5000)
+	if err != nil {
+		t.Fatalf("Querier failed: %v", err)
+	}
+	defer q.Close()
+	set, err := q.Select(index.MustNewMatcher(index.MatchEqual, "__name__", "cpu"))
+	if err != nil {
+		t.Fatalf("Select failed: %v", err)
+	}
+	want := map[string][]float64{"a": {1, 3}, "b": {2, 4}}
+	got := make(map[string][]float64)
+	for set.Next() {
+		s, samples := set.At()
+		for _, sample := range samples {
+			got[s.Labels["host"]] = append(got[s.Labels["host"]], sample.Value)
+		}
+	}
+	if set.Err() != nil {
+		t.Fatalf("iteration failed: %v", set.Err())
+	}
+	if !maps.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
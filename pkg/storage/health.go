@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// UnhealthyFailures is how many flushes or WAL appends in a row must fail
+// before the TSDB reports itself failing
+const UnhealthyFailures = 3
+
+// HealthStatus is the overall state of the TSDB
+type HealthStatus string
+
+const (
+	// HealthOK means writes, flushes and queries work
+	HealthOK HealthStatus = "healthy"
+
+	// HealthReadOnly means free disk space is below the minimum: writes are
+	// refused and nothing is flushed, but queries work
+	HealthReadOnly HealthStatus = "read_only"
+
+	// HealthFailing means flushes or WAL appends keep failing
+	HealthFailing HealthStatus = "failing"
+
+	// HealthClosed means the TSDB is closed
+	HealthClosed HealthStatus = "closed"
+)
+
+// Health reports the state of the TSDB and the storage errors behind it
+type Health struct {
+	Status        HealthStatus
+	LastFlushTime int64 // Unix milliseconds of the last successful flush, 0 if none
+
+	FlushFailures  int64 // Flushes failed since the last successful one
+	LastFlushError string
+	WALFailures    int64 // WAL appends failed since the last successful one
+	LastWALError   string
+}
+
+// healthTracker counts storage failures since the last success
+type healthTracker struct {
+	mu             sync.Mutex
+	flushFailures  int64
+	lastFlushError string
+	walFailures    atomic.Int64 // Read without mu on every append
+	lastWALError   string
+}
+
+// flushed records the outcome of a flush
+func (h *healthTracker) flushed(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil {
+		h.flushFailures, h.lastFlushError = 0, ""
+		return
+	}
+	h.flushFailures++
+	h.lastFlushError = err.Error()
+}
+
+// appended records the outcome of a WAL append
+func (h *healthTracker) appended(err error) {
+	if err == nil && h.walFailures.Load() == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil {
+		h.walFailures.Store(0)
+		h.lastWALError = ""
+		return
+	}
+	h.walFailures.Add(1)
+	h.lastWALError = err.Error()
+}
+
+// Health returns the state of the TSDB. It is failing once UnhealthyFailures
+// flushes or WAL appends in a row have failed, which takes precedence over
+// being read-only.
+func (db *TSDB) Health() Health {
+	db.health.mu.Lock()
+	health := Health{
+		Status:         HealthOK,
+		LastFlushTime:  db.stats.LastFlushTime.Load(),
+		FlushFailures:  db.health.flushFailures,
+		LastFlushError: db.health.lastFlushError,
+		WALFailures:    db.health.walFailures.Load(),
+		LastWALError:   db.health.lastWALError,
+	}
+	db.health.mu.Unlock()
+
+	switch {
+	case db.closed.Load():
+		health.Status = HealthClosed
+	case health.FlushFailures >= UnhealthyFailures || health.WALFailures >= UnhealthyFailures:
+		health.Status = HealthFailing
+	case db.readOnly.Load():
+		health.Status = HealthReadOnly
+	}
+	return health
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestHealth(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	opts.FlushInterval = time.Hour
+	opts.MinFreeDiskBytes = 100
+	opts.DiskCheckInterval = time.Hour
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	if h := db.Health(); h.Status != HealthOK {
+		t.Fatalf("status after open = %s, want %s", h.Status, HealthOK)
+	}
+
+	s := series.NewSeries(map[string]string{"__name__": "health_test"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	// Flushes failing until the threshold, then one succeeding
+	errInjected := errors.New("injected")
+	db.failpoints = func(flushPhase) error { return errInjected }
+	for i := 1; i <= UnhealthyFailures; i++ {
+		if err := db.TriggerFlush(context.Background()); !errors.Is(err, errInjected) {
+			t.Fatalf("expected injected error, got %v", err)
+		}
+		h := db.Health()
+		want := HealthOK
+		if i == UnhealthyFailures {
+			want = HealthFailing
+		}
+		if h.Status != want || h.FlushFailures != int64(i) || h.LastFlushError != errInjected.Error() {
+			t.Errorf("after %d failed flushes health = %+v, want %s", i, h, want)
+		}
+	}
+	db.failpoints = nil
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	if h := db.Health(); h.Status != HealthOK || h.FlushFailures != 0 || h.LastFlushError != "" || h.LastFlushTime == 0 {
+		t.Errorf("health after a successful flush = %+v, want healthy", h)
+	}
+
+	// Failing WAL appends outrank read-only mode
+	db.diskUsage = fakeDiskUsage(func() uint64 { return 50 })
+	if err := db.checkDisk(); err != nil {
+		t.Fatalf("checkDisk failed: %v", err)
+	}
+	if h := db.Health(); h.Status != HealthReadOnly {
+		t.Errorf("status below the free space minimum = %s, want %s", h.Status, HealthReadOnly)
+	}
+	for range UnhealthyFailures {
+		db.health.appended(errInjected)
+	}
+	if h := db.Health(); h.Status != HealthFailing || h.WALFailures != UnhealthyFailures {
+		t.Errorf("health after failed WAL appends = %+v, want failing", h)
+	}
+	db.health.appended(nil)
+	if h := db.Health(); h.Status != HealthReadOnly || h.WALFailures != 0 || h.LastWALError != "" {
+		t.Errorf("health after a successful WAL append = %+v, want read-only", h)
+	}
+
+	db.Close()
+	if h := db.Health(); h.Status != HealthClosed {
+		t.Errorf("status after close = %s, want %s", h.Status, HealthClosed)
+	}
+}
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+)
+
+// NoDeleteFile is the marker file that protects a block from retention and
+// compaction. Creating or removing it by hand has the same effect as the
+// hold API.
+const NoDeleteFile = "no-delete"
+
+var (
+	// ErrBlockNotFound indicates a selected block does not exist
+	ErrBlockNotFound = tsdberrors.New(tsdberrors.NotFound, "block not found")
+
+	// ErrEmptyBlockSelector indicates a block selector that selects nothing
+	ErrEmptyBlockSelector = tsdberrors.New(tsdberrors.Invalid, "block selector needs block IDs or a time range")
+)
+
+// BlockSelector selects blocks by ULID, by time range, or both
+type BlockSelector struct {
+	ULIDs []string
+
+	// MinTime and MaxTime select blocks overlapping [MinTime, MaxTime]
+	// (Unix milliseconds). Both zero selects no range; a zero MaxTime alone
+	// means no upper bound.
+	MinTime int64
+	MaxTime int64
+}
+
+// timeRange returns the selected time range and whether one is set
+func (s BlockSelector) timeRange() (int64, int64, bool) {
+	if s.MinTime == 0 && s.MaxTime == 0 {
+		return 0, 0, false
+	}
+	maxTime := s.MaxTime
+	if maxTime == 0 {
+		maxTime = math.MaxInt64
+	}
+	return s.MinTime, maxTime, true
+}
+
+// BlockHold describes a protected block
+type BlockHold struct {
+	Block     string // Block ULID
+	MinTime   int64
+	MaxTime   int64
+	Reason    string
+	CreatedAt int64 // Unix milliseconds
+}
+
+// holdMarker is the content of a no-delete marker file
+type holdMarker struct {
+	Reason    string `json:"reason,omitempty"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// Protected reports whether the block carries a no-delete marker
+func (b *Block) Protected() bool {
+	dir := b.Dir()
+	if dir == "" {
+		return false
+	}
+	return isProtected(dir)
+}
+
+// isProtected reports whether the block in dir carries a no-delete marker
+func isProtected(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, NoDeleteFile))
+	return err == nil
+}
+
+// unprotectedBlocks filters out protected blocks
+func unprotectedBlocks(blocks []*Block) []*Block {
+	result := make([]*Block, 0, len(blocks))
+	for _, block := range blocks {
+		if !block.Protected() {
+			result = append(result, block)
+		}
+	}
+	return result
+}
+
+// selectBlocks returns the directories of blocks in dataDirs matching sel
+func selectBlocks(sel BlockSelector, dataDirs ...string) ([]string, error) {
+	minTime, maxTime, bounded := sel.timeRange()
+	if len(sel.ULIDs) == 0 && !bounded {
+		return nil, ErrEmptyBlockSelector
+	}
+
+	dirs, err := blockDirs(dataDirs...)
+	if err != nil {
+		return nil, err
+	}
+
+	byULID := make(map[string]string, len(dirs))
+	for _, dir := range dirs {
+		byULID[filepath.Base(dir)] = dir
+	}
+
+	selected := make(map[string]bool)
+	for _, id := range sel.ULIDs {
+		if _, ok := byULID[id]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrBlockNotFound, id)
+		}
+		selected[id] = true
+	}
+
+	if bounded {
+		for _, dir := range dirs {
+			meta, err := readBlockMeta(dir)
+			if err != nil {
+				return nil, fmt.Errorf("block %s: %w", filepath.Base(dir), err)
+			}
+			if meta.MaxTime >= minTime && meta.MinTime <= maxTime {
+				selected[filepath.Base(dir)] = true
+			}
+		}
+	}
+
+	result := make([]string, 0, len(selected))
+	for _, id := range sortedKeys(selected) {
+		result = append(result, byULID[id])
+	}
+	return result, nil
+}
+
+// ProtectBlocks writes a no-delete marker into every block in dataDir
+// matching sel and returns their ULIDs. A time range only selects blocks
+// that exist now; blocks written later are not protected.
+func ProtectBlocks(dataDir string, sel BlockSelector, reason string) ([]string, error) {
+	return protectBlocks(sel, reason, dataDir)
+}
+
+// protectBlocks is ProtectBlocks over the blocks of several directories
+func protectBlocks(sel BlockSelector, reason string, dataDirs ...string) ([]string, error) {
+	dirs, err := selectBlocks(sel, dataDirs...)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(holdMarker{Reason: reason, CreatedAt: time.Now().UnixMilli()})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		if err := writeFileAtomic(filepath.Join(dir, NoDeleteFile), data); err != nil {
+			return ids, fmt.Errorf("failed to protect block %s: %w", filepath.Base(dir), err)
+		}
+		ids = append(ids, filepath.Base(dir))
+	}
+	return ids, nil
+}
+
+// UnprotectBlocks removes the no-delete marker from every block in dataDir
+// matching sel and returns the ULIDs of the blocks that were protected
+func UnprotectBlocks(dataDir string, sel BlockSelector) ([]string, error) {
+	return unprotectBlocks(sel, dataDir)
+}
+
+// unprotectBlocks is UnprotectBlocks over the blocks of several directories
+func unprotectBlocks(sel BlockSelector, dataDirs ...string) ([]string, error) {
+	dirs, err := selectBlocks(sel, dataDirs...)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, dir := range dirs {
+		err := os.Remove(filepath.Join(dir, NoDeleteFile))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return ids, fmt.Errorf("failed to unprotect block %s: %w", filepath.Base(dir), err)
+		}
+		ids = append(ids, filepath.Base(dir))
+	}
+	return ids, nil
+}
+
+// ListBlockHolds returns the protected blocks in dataDir, oldest first
+func ListBlockHolds(dataDir string) ([]BlockHold, error) {
+	return listBlockHolds(dataDir)
+}
+
+// listBlockHolds is ListBlockHolds over the blocks of several directories
+func listBlockHolds(dataDirs ...string) ([]BlockHold, error) {
+	dirs, err := blockDirs(dataDirs...)
+	if err != nil {
+		return nil, err
+	}
+
+	var holds []BlockHold
+	for _, dir := range dirs {
+		data, err := os.ReadFile(filepath.Join(dir, NoDeleteFile))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("block %s: %w", filepath.Base(dir), err)
+		}
+
+		// Markers created by hand may be empty
+		var marker holdMarker
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &marker); err != nil {
+				marker.Reason = string(data)
+			}
+		}
+
+		meta, err := readBlockMeta(dir)
+		if err != nil {
+			return nil, fmt.Errorf("block %s: %w", filepath.Base(dir), err)
+		}
+
+		holds = append(holds, BlockHold{
+			Block:     filepath.Base(dir),
+			MinTime:   meta.MinTime,
+			MaxTime:   meta.MaxTime,
+			Reason:    marker.Reason,
+			CreatedAt: marker.CreatedAt,
+		})
+	}
+	return holds, nil
+}
+
+// ProtectBlocks protects the blocks matching sel from retention and
+// compaction; see the package-level ProtectBlocks
+func (db *TSDB) ProtectBlocks(sel BlockSelector, reason string) ([]string, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	// Hold the compactor lock so a cycle in progress can't delete a block
+	// between being planned and being marked
+	if db.compactor != nil {
+		db.compactor.mu.Lock()
+		defer db.compactor.mu.Unlock()
+	}
+	return protectBlocks(sel, reason, db.dataDir, db.coldDir)
+}
+
+// UnprotectBlocks lifts the protection of the blocks matching sel
+func (db *TSDB) UnprotectBlocks(sel BlockSelector) ([]string, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+	return unprotectBlocks(sel, db.dataDir, db.coldDir)
+}
+
+// BlockHolds returns the protected blocks
+func (db *TSDB) BlockHolds() ([]BlockHold, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+	return listBlockHolds(db.dataDir, db.coldDir)
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProtectBlocks(t *testing.T) {
+	dataDir := t.TempDir()
+	writeLevel0Blocks(t, dataDir, 3, 1)
+	dirs, _ := blockDirs(dataDir)
+
+	// Select the first block by ID and the last by time range
+	last, err := readBlockMeta(dirs[2])
+	if err != nil {
+		t.Fatalf("readBlockMeta failed: %v", err)
+	}
+	sel := BlockSelector{ULIDs: []string{filepath.Base(dirs[0])}, MinTime: last.MaxTime}
+	ids, err := ProtectBlocks(dataDir, sel, "incident 42")
+	if err != nil {
+		t.Fatalf("ProtectBlocks failed: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != filepath.Base(dirs[0]) || ids[1] != filepath.Base(dirs[2]) {
+		t.Errorf("ProtectBlocks = %v, want first and last block", ids)
+	}
+	if isProtected(dirs[1]) {
+		t.Error("middle block should not be protected")
+	}
+
+	holds, err := ListBlockHolds(dataDir)
+	if err != nil {
+		t.Fatalf("ListBlockHolds failed: %v", err)
+	}
+	if len(holds) != 2 || holds[0].Reason != "incident 42" || holds[0].CreatedAt == 0 {
+		t.Errorf("unexpected holds: %+v", holds)
+	}
+
+	// A hand-made empty marker counts too
+	if err := os.WriteFile(filepath.Join(dirs[1], NoDeleteFile), nil, 0644); err != nil {
+		t.Fatalf("failed to write marker: %v", err)
+	}
+	if holds, _ := ListBlockHolds(dataDir); len(holds) != 3 {
+		t.Errorf("expected 3 holds, got %d", len(holds))
+	}
+
+	ids, err = UnprotectBlocks(dataDir, BlockSelector{MinTime: 1})
+	if err != nil {
+		t.Fatalf("UnprotectBlocks failed: %v", err)
+	}
+	if len(ids) != 3 {
+		t.Errorf("UnprotectBlocks = %v, want all 3 blocks", ids)
+	}
+	if holds, _ := ListBlockHolds(dataDir); len(holds) != 0 {
+		t.Errorf("expected no holds, got %+v", holds)
+	}
+}
+
+func TestProtectBlocksInvalidSelector(t *testing.T) {
+	dataDir := t.TempDir()
+	writeLevel0Blocks(t, dataDir, 1, 1)
+
+	if _, err := ProtectBlocks(dataDir, BlockSelector{}, ""); !errors.Is(err, ErrEmptyBlockSelector) {
+		t.Errorf("error = %v, want ErrEmptyBlockSelector", err)
+	}
+	sel := BlockSelector{ULIDs: []string{"01ARZ3NDEKTSV4RRFFQ69G5FAV"}}
+	if _, err := ProtectBlocks(dataDir, sel, ""); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("error = %v, want ErrBlockNotFound", err)
+	}
+}
+
+func TestProtectedBlocksSkipped(t *testing.T) {
+	dataDir := t.TempDir()
+	writeLevel0Blocks(t, dataDir, 3, 1)
+	dirs, _ := blockDirs(dataDir)
+
+	if _, err := ProtectBlocks(dataDir, BlockSelector{ULIDs: []string{filepath.Base(dirs[0])}}, ""); err != nil {
+		t.Fatalf("ProtectBlocks failed: %v", err)
+	}
+
+	compactor := NewCompactor(DefaultCompactorOptions(dataDir))
+	defer compactor.Stop()
+
+	// Two unprotected blocks are too few to merge
+	plan, err := compactor.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if len(plan.Merges) != 0 {
+		t.Errorf("expected no merges with a protected block, got %+v", plan.Merges)
+	}
+
+	// Retention deletes everything but the protected block
+	deleted, err := compactor.CleanupOldBlocks(1 << 62)
+	if err != nil {
+		t.Fatalf("CleanupOldBlocks failed: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("deleted %d blocks, want 2", deleted)
+	}
+	if _, err := os.Stat(dirs[0]); err != nil {
+		t.Errorf("protected block was deleted: %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"cmp"
+	"container/heap"
+	"fmt"
+	"slices"
+	"sync"
+
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+const (
+	// TenantLabel is the series label ingest statistics are broken down
+	// by, besides the metric name
+	TenantLabel = "tenant"
+
+	// DefaultIngestStatsCapacity is how many metric name and tenant pairs
+	// the ingest statistics track by default
+	DefaultIngestStatsCapacity = 1000
+)
+
+// ErrInvalidIngestSort indicates an unknown IngestSort
+var ErrInvalidIngestSort = tsdberrors.New(tsdberrors.Invalid, "invalid ingest statistics order")
+
+// IngestStats are the writes of one metric name and tenant since startup
+type IngestStats struct {
+	Metric   string
+	Tenant   string // Value of TenantLabel; empty if the series have none
+	Accepted int64  // Samples written
+	Rejected int64  // Samples refused by validation, the timestamp bounds, the rate limit or the sample policy
+	Series   int64  // Series in the head
+
+	// Error is the most Accepted+Rejected may overcount. The pair was
+	// tracked after evicting another, and inherited its count; the other
+	// counts only cover the writes since.
+	Error int64
+}
+
+// IngestSort is the order ingest statistics are ranked in
+type IngestSort string
+
+const (
+	IngestSortSamples  IngestSort = "samples"  // Most samples accepted and rejected first
+	IngestSortRejected IngestSort = "rejected" // Most samples rejected first
+	IngestSortSeries   IngestSort = "series"   // Most series first
+)
+
+// ingestKey identifies a writer in the ingest statistics
+type ingestKey struct {
+	metric, tenant string
+}
+
+// ingestEntry is a writer tracked by an ingestSketch
+type ingestEntry struct {
+	stats IngestStats
+	index int // In ingestSketch.heap
+}
+
+// weight is the count the Space-Saving sketch ranks entries by
+func (e *ingestEntry) weight() int64 {
+	return e.stats.Accepted + e.stats.Rejected + e.stats.Error
+}
+
+// ingestSketch counts writes per metric name and tenant in bounded memory
+// with the Space-Saving algorithm: it tracks at most capacity pairs, and
+// a new pair arriving when full replaces the one with the fewest samples,
+// inheriting its count as the error. The heaviest writers are always
+// tracked, while rare ones churn through the lightest slots. A nil sketch
+// counts nothing.
+type ingestSketch struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[ingestKey]*ingestEntry
+	heap     ingestHeap // Lightest first
+}
+
+// newIngestSketch returns a sketch tracking up to capacity pairs, or nil
+// if capacity is 0
+func newIngestSketch(capacity int) *ingestSketch {
+	if capacity <= 0 {
+		return nil
+	}
+	return &ingestSketch{
+		capacity: capacity,
+		entries:  make(map[ingestKey]*ingestEntry, capacity),
+	}
+}
+
+// record counts samples accepted and rejected, and series created, for
+// the metric name and tenant of s
+func (sk *ingestSketch) record(s *series.Series, accepted, rejected, created int64) {
+	if sk == nil || s == nil || accepted+rejected+created == 0 {
+		return
+	}
+	key := ingestKey{metric: s.Labels[MetricNameLabel], tenant: s.Labels[TenantLabel]}
+
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+
+	e, ok := sk.entries[key]
+	if !ok {
+		e = sk.admit(key)
+	}
+	e.stats.Accepted += accepted
+	e.stats.Rejected += rejected
+	e.stats.Series += created
+	heap.Fix(&sk.heap, e.index)
+}
+
+// recordDropped counts the samples of batch the rate limiter dropped as
+// rejected: those missing from kept, the series it kept samples of in
+// order, and their keptSamples
+func (sk *ingestSketch) recordDropped(batch []*series.Series, samples [][]series.Sample,
+	kept []*series.Series, keptSamples [][]series.Sample) {
+	if sk == nil {
+		return
+	}
+	j := 0
+	for i, s := range batch {
+		n := len(samples[i])
+		if j < len(kept) && kept[j] == s {
+			n -= len(keptSamples[j])
+			j++
+		}
+		sk.record(s, 0, int64(n), 0)
+	}
+}
+
+// admit starts tracking key, evicting the lightest entry if the sketch is
+// full. sk.mu must be held.
+func (sk *ingestSketch) admit(key ingestKey) *ingestEntry {
+	e := &ingestEntry{stats: IngestStats{Metric: key.metric, Tenant: key.tenant}}
+	if len(sk.heap) < sk.capacity {
+		heap.Push(&sk.heap, e)
+		sk.entries[key] = e
+		return e
+	}
+
+	lightest := sk.heap[0]
+	delete(sk.entries, ingestKey{metric: lightest.stats.Metric, tenant: lightest.stats.Tenant})
+	e.stats.Error = lightest.weight()
+	e.index = 0
+	sk.heap[0] = e
+	sk.entries[key] = e
+	return e
+}
+
+// top returns up to n tracked pairs ranked by sort, and how many are
+// tracked
+func (sk *ingestSketch) top(n int, sort IngestSort) ([]IngestStats, int) {
+	sk.mu.Lock()
+	stats := make([]IngestStats, 0, len(sk.heap))
+	for _, e := range sk.heap {
+		stats = append(stats, e.stats)
+	}
+	sk.mu.Unlock()
+
+	key := func(s IngestStats) int64 {
+		switch sort {
+		case IngestSortRejected:
+			return s.Rejected
+		case IngestSortSeries:
+			return s.Series
+		default:
+			return s.Accepted + s.Rejected + s.Error
+		}
+	}
+	slices.SortFunc(stats, func(a, b IngestStats) int {
+		if c := cmp.Compare(key(b), key(a)); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.Metric, b.Metric); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Tenant, b.Tenant)
+	})
+	tracked := len(stats)
+	if n > 0 && len(stats) > n {
+		stats = stats[:n]
+	}
+	return stats, tracked
+}
+
+// ingestHeap is a min-heap of entries by weight
+type ingestHeap []*ingestEntry
+
+func (h ingestHeap) Len() int           { return len(h) }
+func (h ingestHeap) Less(i, j int) bool { return h[i].weight() < h[j].weight() }
+
+func (h ingestHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *ingestHeap) Push(x any) {
+	e := x.(*ingestEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *ingestHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// IngestReport ranks the writers tracked by the ingest statistics
+type IngestReport struct {
+	Writers  []IngestStats
+	Tracked  int // Metric name and tenant pairs tracked
+	Capacity int // Most pairs tracked at once; see Options.IngestStatsCapacity
+}
+
+// IngestStats returns up to n of the metric name and tenant pairs writing
+// the most, ranked by sort; n <= 0 returns all of them. It fails with
+// ErrIngestStatsDisabled if ingest statistics are disabled.
+func (db *TSDB) IngestStats(n int, sort IngestSort) (*IngestReport, error) {
+	if db.ingest == nil {
+		return nil, ErrIngestStatsDisabled
+	}
+	switch sort {
+	case IngestSortSamples, IngestSortRejected, IngestSortSeries:
+	default:
+		return nil, fmt.Errorf("%w %q: must be samples, rejected or series", ErrInvalidIngestSort, sort)
+	}
+	writers, tracked := db.ingest.top(n, sort)
+	return &IngestReport{Writers: writers, Tracked: tracked, Capacity: db.ingest.capacity}, nil
+}
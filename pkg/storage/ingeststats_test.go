@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestIngestSketchEviction(t *testing.T) {
+	sk := newIngestSketch(3)
+	metric := func(name string) *series.Series {
+		return series.NewSeries(map[string]string{"__name__": name})
+	}
+
+	sk.record(metric("heavy"), 100, 0, 1)
+	sk.record(metric("medium"), 50, 0, 1)
+	sk.record(metric("light"), 5, 0, 1)
+	// Full: each new metric takes the lightest slot and its count
+	sk.record(metric("rare_1"), 1, 0, 0)
+	sk.record(metric("rare_2"), 1, 0, 0)
+
+	got, tracked := sk.top(0, IngestSortSamples)
+	if tracked != 3 {
+		t.Fatalf("tracking %d pairs, want 3", tracked)
+	}
+	want := []IngestStats{
+		{Metric: "heavy", Accepted: 100, Series: 1},
+		{Metric: "medium", Accepted: 50, Series: 1},
+		{Metric: "rare_2", Accepted: 1, Error: 6},
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("writer %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if top, _ := sk.top(1, IngestSortSamples); len(top) != 1 || top[0].Metric != "heavy" {
+		t.Errorf("top 1 = %+v, want heavy", top)
+	}
+	if newIngestSketch(0) != nil {
+		t.Error("sketch of capacity 0 is not disabled")
+	}
+}
+
+func TestIngestStats(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	opts.FlushInterval = time.Hour
+	opts.SamplePolicy = SamplePolicy{Duplicates: DuplicateReject}
+	opts.MaxFutureSkew = time.Hour
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	for i := range 3 {
+		s := series.NewSeries(map[string]string{"__name__": "http_requests", "tenant": "a", "pod": fmt.Sprint(i)})
+		if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}}); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	// A duplicate, then one sample too far in the future
+	s := series.NewSeries(map[string]string{"__name__": "http_requests", "tenant": "b"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 2}}); !errors.Is(err, ErrDuplicateSample) {
+		t.Fatalf("expected ErrDuplicateSample, got %v", err)
+	}
+	future := time.Now().Add(24 * time.Hour).UnixMilli()
+	if err := db.Insert(s, []series.Sample{{Timestamp: 2000, Value: 1}, {Timestamp: future, Value: 1}}); err == nil {
+		t.Fatal("expected the future sample to be rejected")
+	}
+
+	// Through an appender
+	app := db.Appender()
+	up := map[string]string{"__name__": "up"}
+	if err := app.Append(up, 1000, 1); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	if err := app.Append(up, future, 1); err == nil {
+		t.Fatal("expected the future sample to be rejected")
+	}
+	if err := app.Commit(); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+
+	report, err := db.IngestStats(0, IngestSortSamples)
+	if err != nil {
+		t.Fatalf("IngestStats failed: %v", err)
+	}
+	want := []IngestStats{
+		{Metric: "http_requests", Tenant: "a", Accepted: 6, Series: 3},
+		{Metric: "http_requests", Tenant: "b", Accepted: 2, Rejected: 2, Series: 1},
+		{Metric: "up", Accepted: 1, Rejected: 1, Series: 1},
+	}
+	if report.Tracked != 3 || report.Capacity != DefaultIngestStatsCapacity || len(report.Writers) != len(want) {
+		t.Fatalf("report = %+v, want %d writers", report, len(want))
+	}
+	for i := range want {
+		if report.Writers[i] != want[i] {
+			t.Errorf("writer %d = %+v, want %+v", i, report.Writers[i], want[i])
+		}
+	}
+
+	if report, _ := db.IngestStats(1, IngestSortRejected); report.Writers[0].Tenant != "b" {
+		t.Errorf("most rejected = %+v, want tenant b", report.Writers[0])
+	}
+	if _, err := db.IngestStats(0, "bytes"); !errors.Is(err, ErrInvalidIngestSort) {
+		t.Errorf("expected ErrInvalidIngestSort, got %v", err)
+	}
+}
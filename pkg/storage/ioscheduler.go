@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BackgroundIOPause is how long compaction and retention I/O is held after
+// a query slower than Options.BackgroundIOPauseLatency
+const BackgroundIOPause = 5 * time.Second
+
+// ioScheduler paces the block reads and writes of compaction and
+// retention, so background maintenance doesn't saturate the disk and slow
+// queries down. Queries are never paced. A nil scheduler lets all I/O
+// through.
+//
+// Bytes are granted at bytesPerSec: each request waits until the bytes
+// granted before it have been paid for, so the first one in a while goes
+// through at once. After a query slower than pauseLatency, requests are
+// held for BackgroundIOPause, handing the disk to queries.
+type ioScheduler struct {
+	bytesPerSec  int64         // 0 for no limit
+	pauseLatency time.Duration // 0 never pauses
+
+	mu   sync.Mutex
+	next time.Time // When the bytes granted so far are paid for
+
+	pausedUntil atomic.Int64 // Unix nanoseconds
+
+	granted atomic.Int64 // Bytes granted
+	waited  atomic.Int64 // Nanoseconds requests were held
+}
+
+// newIOScheduler returns a scheduler, or nil if it would neither limit
+// nor pause anything
+func newIOScheduler(bytesPerSec int64, pauseLatency time.Duration) *ioScheduler {
+	if bytesPerSec <= 0 && pauseLatency <= 0 {
+		return nil
+	}
+	return &ioScheduler{bytesPerSec: bytesPerSec, pauseLatency: pauseLatency}
+}
+
+// wait blocks until n bytes of background I/O may proceed. It fails with
+// the context's error if ctx is done first.
+func (s *ioScheduler) wait(ctx context.Context, n int64) error {
+	if s == nil {
+		return nil
+	}
+	s.granted.Add(n)
+
+	// Hold while queries are slow
+	for {
+		paused := time.Until(time.Unix(0, s.pausedUntil.Load()))
+		if paused <= 0 {
+			break
+		}
+		if err := s.sleep(ctx, paused); err != nil {
+			return err
+		}
+	}
+
+	if s.bytesPerSec <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	now := time.Now()
+	if s.next.Before(now) {
+		s.next = now
+	}
+	delay := s.next.Sub(now)
+	s.next = s.next.Add(time.Duration(float64(n) / float64(s.bytesPerSec) * float64(time.Second)))
+	s.mu.Unlock()
+
+	return s.sleep(ctx, delay)
+}
+
+// sleep waits for d or until ctx is done, counting the time waited
+func (s *ioScheduler) sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	start := time.Now()
+	defer func() { s.waited.Add(int64(time.Since(start))) }()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// observeQuery pauses background I/O if a query took longer than the
+// pause latency
+func (s *ioScheduler) observeQuery(d time.Duration) {
+	if s == nil || s.pauseLatency <= 0 || d <= s.pauseLatency {
+		return
+	}
+	until := time.Now().Add(BackgroundIOPause).UnixNano()
+	for {
+		current := s.pausedUntil.Load()
+		if current >= until || s.pausedUntil.CompareAndSwap(current, until) {
+			return
+		}
+	}
+}
+
+// stats returns the bytes granted and the time requests were held
+func (s *ioScheduler) stats() (granted int64, waited time.Duration) {
+	if s == nil {
+		return 0, 0
+	}
+	return s.granted.Load(), time.Duration(s.waited.Load())
+}
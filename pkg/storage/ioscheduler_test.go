@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIOSchedulerPacing(t *testing.T) {
+	s := newIOScheduler(1000, 0)
+	ctx := context.Background()
+
+	start := time.Now()
+	// The first request goes through at once, the second waits for it
+	if err := s.wait(ctx, 100); err != nil {
+		t.Fatalf("wait failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("first request held for %s", elapsed)
+	}
+	if err := s.wait(ctx, 100); err != nil {
+		t.Fatalf("wait failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("second request went through after %s, want about 100ms", elapsed)
+	}
+
+	granted, waited := s.stats()
+	if granted != 200 || waited < 90*time.Millisecond {
+		t.Errorf("stats = %d bytes, %s waited; want 200 bytes, about 100ms", granted, waited)
+	}
+}
+
+func TestIOSchedulerPause(t *testing.T) {
+	s := newIOScheduler(0, 100*time.Millisecond)
+
+	// Fast queries don't pause anything
+	s.observeQuery(10 * time.Millisecond)
+	if err := s.wait(context.Background(), 1<<30); err != nil {
+		t.Fatalf("wait failed: %v", err)
+	}
+
+	s.observeQuery(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.wait(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait while paused = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestIOSchedulerDisabled(t *testing.T) {
+	s := newIOScheduler(0, 0)
+	if s != nil {
+		t.Fatal("scheduler without limits is not disabled")
+	}
+	s.observeQuery(time.Hour)
+	if err := s.wait(context.Background(), 1<<30); err != nil {
+		t.Errorf("wait failed: %v", err)
+	}
+	if granted, waited := s.stats(); granted != 0 || waited != 0 {
+		t.Errorf("stats = %d, %s; want zero", granted, waited)
+	}
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// ErrLabelPostingsLimit is returned for new series carrying a label whose
+// posting lists in the head index are over LabelPostingsLimit.MaxBytes
+var ErrLabelPostingsLimit = tsdberrors.New(tsdberrors.ResourceExhausted, "label postings over limit")
+
+// LabelLimitAction decides what happens to a new series carrying a label
+// over its postings limit
+type LabelLimitAction string
+
+const (
+	// LabelLimitReject rejects the series with ErrLabelPostingsLimit
+	LabelLimitReject LabelLimitAction = "reject"
+
+	// LabelLimitWarn accepts the series, logging the label the first time
+	// it is found over the limit
+	LabelLimitWarn LabelLimitAction = "warn"
+)
+
+// ParseLabelLimitAction parses a label limit action name
+func ParseLabelLimitAction(s string) (LabelLimitAction, error) {
+	switch a := LabelLimitAction(s); a {
+	case LabelLimitReject, LabelLimitWarn:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown label limit action %q (want reject or warn)", s)
+	}
+}
+
+// LabelPostingsLimit caps the memory the posting lists of a single label
+// name may hold in the head index, to catch labels with unbounded values,
+// such as request IDs, before they exhaust memory. Each distinct value
+// has a posting list of its own, so such a label soon dwarfs the others.
+// Only series new to the head are checked; samples of known series are
+// always written. The zero value disables the limit.
+type LabelPostingsLimit struct {
+	// MaxBytes is the memory a label name's posting lists may hold, as
+	// reported by IndexStats; 0 disables the limit. Every label's posting
+	// lists grow with the series carrying it, so it should be well above
+	// what the number of series alone accounts for.
+	MaxBytes int64
+
+	// Action is applied to new series carrying a label over MaxBytes;
+	// empty means LabelLimitReject
+	Action LabelLimitAction
+}
+
+// action returns the effective action
+func (l LabelPostingsLimit) action() LabelLimitAction {
+	if l.Action == "" {
+		return LabelLimitReject
+	}
+	return l.Action
+}
+
+// Validate checks the limit
+func (l LabelPostingsLimit) Validate() error {
+	if l.MaxBytes < 0 {
+		return fmt.Errorf("label postings limit %d cannot be negative", l.MaxBytes)
+	}
+	if l.Action != "" {
+		if _, err := ParseLabelLimitAction(string(l.Action)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// labelLimiter applies a LabelPostingsLimit to the head index
+type labelLimiter struct {
+	limit LabelPostingsLimit
+
+	// warned holds the label names logged as over the limit
+	warned sync.Map
+}
+
+// newLabelLimiter returns a limiter for limit, or nil if it is disabled
+func newLabelLimiter(limit LabelPostingsLimit) *labelLimiter {
+	if limit.MaxBytes == 0 {
+		return nil
+	}
+	return &labelLimiter{limit: limit}
+}
+
+// check returns ErrLabelPostingsLimit if s is new to the head and carries
+// a label over the limit, or logs the label instead under LabelLimitWarn.
+// Series written concurrently are checked against the same usage, so the
+// limit may be overshot by a few series.
+func (l *labelLimiter) check(h *Head, s *series.Series) error {
+	if l == nil {
+		return nil
+	}
+	if _, known := h.seriesByHash(s.Hash); known {
+		return nil
+	}
+	for name := range s.Labels {
+		bytes := h.index.LabelMemoryBytes(name)
+		if bytes < l.limit.MaxBytes {
+			continue
+		}
+		if l.limit.action() == LabelLimitReject {
+			return fmt.Errorf("%w: label %q holds %d bytes of postings, limit %d",
+				ErrLabelPostingsLimit, name, bytes, l.limit.MaxBytes)
+		}
+		if _, logged := l.warned.LoadOrStore(name, struct{}{}); !logged {
+			fmt.Printf("tsdb: label %q holds %d bytes of postings, over the %d byte limit\n",
+				name, bytes, l.limit.MaxBytes)
+		}
+	}
+	return nil
+}
+
+// LabelMemory is the memory held by the posting lists of a label name in
+// the head index
+type LabelMemory struct {
+	Name      string
+	Bytes     int64
+	OverLimit bool // Bytes is at or over LabelPostingsLimit.MaxBytes
+}
+
+// LargestLabels returns the n label names whose posting lists hold the
+// most memory in the head index, largest first; n <= 0 returns all
+func (db *TSDB) LargestLabels(n int) []LabelMemory {
+	usage := db.head.index.LabelMemory()
+	labels := make([]LabelMemory, 0, len(usage))
+	for name, bytes := range usage {
+		labels = append(labels, LabelMemory{
+			Name:      name,
+			Bytes:     bytes,
+			OverLimit: db.labelLimiter != nil && bytes >= db.labelLimiter.limit.MaxBytes,
+		})
+	}
+	slices.SortFunc(labels, func(a, b LabelMemory) int {
+		if c := cmp.Compare(b.Bytes, a.Bytes); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	if n > 0 && len(labels) > n {
+		labels = labels[:n]
+	}
+	return labels
+}
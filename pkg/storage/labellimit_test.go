@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// requestSeries returns a series with a request_id label, the kind of
+// label with a value per request
+func requestSeries(i int) *series.Series {
+	return series.NewSeries(map[string]string{
+		"__name__":   "http_requests_total",
+		"request_id": fmt.Sprintf("req-%06d", i),
+	})
+}
+
+func TestLabelPostingsLimitReject(t *testing.T) {
+	db := openTestDB(t, func(opts *Options) { opts.LabelPostingsLimit = LabelPostingsLimit{MaxBytes: 2048} })
+
+	// Write series until request_id is over the limit
+	var err error
+	written := 0
+	for ; written < 1000; written++ {
+		if err = db.Insert(requestSeries(written), secondly(0, 1)); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrLabelPostingsLimit) {
+		t.Fatalf("Insert() error = %v, want ErrLabelPostingsLimit", err)
+	}
+	if written < 10 {
+		t.Fatalf("rejected after %d series, want request_id to fill the limit first", written)
+	}
+	if got := db.IndexStats().LabelMemoryBytes["request_id"]; got < 2048 {
+		t.Errorf("request_id holds %d bytes, want at least the limit", got)
+	}
+
+	// Known series keep writing, and new ones without the label too
+	if err := db.Insert(requestSeries(0), secondly(1000, 1)); err != nil {
+		t.Errorf("Insert() of a known series error = %v", err)
+	}
+	if samples, _ := db.Query(requestSeries(0).Hash, math.MinInt64, math.MaxInt64); len(samples) != 2 {
+		t.Errorf("known series has %d samples, want 2", len(samples))
+	}
+	other := series.NewSeries(map[string]string{"__name__": "http_requests_total", "path": "/"})
+	if err := db.Insert(other, secondly(0, 1)); err != nil {
+		t.Errorf("Insert() of a series without request_id error = %v", err)
+	}
+
+	// Batches with a new series over the limit write nothing
+	app := db.Appender()
+	if err := app.Append(map[string]string{"__name__": "up"}, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Append(requestSeries(written+1).Labels, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Commit(); !errors.Is(err, ErrLabelPostingsLimit) {
+		t.Errorf("Commit() error = %v, want ErrLabelPostingsLimit", err)
+	}
+	if samples, _ := db.Query(series.NewSeries(map[string]string{"__name__": "up"}).Hash, math.MinInt64, math.MaxInt64); len(samples) != 0 {
+		t.Errorf("rejected batch wrote %v", samples)
+	}
+
+	if n := db.GetStatsSnapshot().LabelLimitRejected; n != 2 {
+		t.Errorf("%d series rejected, want 2", n)
+	}
+	largest := db.LargestLabels(1)
+	if len(largest) != 1 || largest[0].Name != "request_id" || !largest[0].OverLimit {
+		t.Errorf("LargestLabels(1) = %+v, want request_id over the limit", largest)
+	}
+}
+
+func TestLabelPostingsLimitWarn(t *testing.T) {
+	db := openTestDB(t, func(opts *Options) {
+		opts.LabelPostingsLimit = LabelPostingsLimit{MaxBytes: 2048, Action: LabelLimitWarn}
+	})
+
+	for i := 0; i < 100; i++ {
+		if err := db.Insert(requestSeries(i), secondly(0, 1)); err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+	}
+	if n := db.GetStatsSnapshot().TotalSeries; n != 100 {
+		t.Errorf("%d series written, want 100", n)
+	}
+	if n := db.GetStatsSnapshot().LabelLimitRejected; n != 0 {
+		t.Errorf("%d series rejected, want none", n)
+	}
+	if largest := db.LargestLabels(0); len(largest) != 2 || !largest[0].OverLimit || largest[1].OverLimit {
+		t.Errorf("LargestLabels(0) = %+v, want request_id over the limit and __name__ under", largest)
+	}
+}
+
+func TestLabelPostingsLimitValidate(t *testing.T) {
+	tests := []struct {
+		limit   LabelPostingsLimit
+		wantErr bool
+	}{
+		{LabelPostingsLimit{}, false},
+		{LabelPostingsLimit{MaxBytes: 1 << 20, Action: LabelLimitWarn}, false},
+		{LabelPostingsLimit{MaxBytes: -1}, true},
+		{LabelPostingsLimit{MaxBytes: 1, Action: "drop"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.limit.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%+v) = %v, want error %v", tt.limit, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"slices"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// DefaultLatestLookback is how far back Latest looks for the newest
+// sample of a series by default
+const DefaultLatestLookback = 5 * time.Minute
+
+// LatestSample is the newest sample of a series
+type LatestSample struct {
+	Series *series.Series
+	Sample series.Sample
+}
+
+// Latest returns the newest sample at or before t of each series in the
+// head matching matchers, sorted by label set (see series.CompareLabels).
+// It answers "current value" lookups without range queries.
+//
+// Samples older than lookback are stale: a series with none newer is left
+// out, so series no longer written drop out of the result. A lookback of
+// 0 means DefaultLatestLookback. Samples are read from the head; only
+// series whose newest samples were flushed since are looked up in the
+// blocks overlapping the lookback window. Series not written since the
+// TSDB was opened are not in the head, and not returned.
+func (db *TSDB) Latest(matchers index.Matchers, t int64, lookback time.Duration) ([]LatestSample, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+	if lookback <= 0 {
+		lookback = DefaultLatestLookback
+	}
+	minTime := t - lookback.Milliseconds()
+
+	matched, err := db.head.Select(matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]LatestSample, 0, len(matched))
+	flushed := make(map[uint64]int) // Index in result by hash
+	for _, s := range matched {
+		sample, ok := db.head.Latest(s.Hash, minTime, t)
+		if !ok {
+			flushed[s.Hash] = len(result)
+		}
+		result = append(result, LatestSample{Series: s, Sample: sample})
+	}
+
+	if len(flushed) > 0 {
+		found := make(map[uint64]bool, len(flushed))
+		err := db.eachBlockInRange(nil, minTime, t, func(b *Block) error {
+			for hash, i := range flushed {
+				if !b.MayContainSeries(hash) {
+					continue
+				}
+				samples, err := b.GetSeries(hash, minTime, t)
+				if err != nil {
+					return err
+				}
+				if n := len(samples); n > 0 && (!found[hash] || samples[n-1].Timestamp > result[i].Sample.Timestamp) {
+					result[i].Sample = samples[n-1]
+					found[hash] = true
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		result = slices.DeleteFunc(result, func(l LatestSample) bool {
+			_, wasFlushed := flushed[l.Series.Hash]
+			return wasFlushed && !found[l.Series.Hash]
+		})
+	}
+	return result, nil
+}
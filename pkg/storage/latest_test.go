@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestLatest(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	opts.FlushInterval = time.Hour
+	opts.SamplePolicy = SamplePolicy{OutOfOrderWindow: time.Hour}
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	insert := func(host string, samples ...series.Sample) {
+		s := series.NewSeries(map[string]string{"__name__": "cpu", "host": host})
+		if err := db.Insert(s, samples); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	insert("a", series.Sample{Timestamp: 1000, Value: 1}, series.Sample{Timestamp: 3000, Value: 3})
+	insert("a", series.Sample{Timestamp: 2000, Value: 2}) // Out of order
+	insert("b", series.Sample{Timestamp: 1000, Value: 10})
+
+	cpu := index.Matchers{index.MustNewMatcher(index.MatchEqual, "__name__", "cpu")}
+	check := func(at int64, lookback time.Duration, want map[string]series.Sample) {
+		t.Helper()
+		latest, err := db.Latest(cpu, at, lookback)
+		if err != nil {
+			t.Fatalf("Latest failed: %v", err)
+		}
+		if len(latest) != len(want) {
+			t.Fatalf("Latest(%d, %v) = %+v, want %v", at, lookback, latest, want)
+		}
+		for i, l := range latest {
+			host := l.Series.Labels["host"]
+			if l.Sample != want[host] {
+				t.Errorf("Latest(%d, %v): host %s = %v, want %v", at, lookback, host, l.Sample, want[host])
+			}
+			if i > 0 && series.CompareLabels(latest[i-1].Series.Labels, l.Series.Labels) >= 0 {
+				t.Errorf("series out of order: %v", latest)
+			}
+		}
+	}
+	check(2500, 0, map[string]series.Sample{"a": {Timestamp: 2000, Value: 2}, "b": {Timestamp: 1000, Value: 10}})
+	// b is stale
+	check(3000, time.Second, map[string]series.Sample{"a": {Timestamp: 3000, Value: 3}})
+
+	// Flushed series are looked up in blocks
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	insert("a", series.Sample{Timestamp: 4000, Value: 4})
+	check(5000, 0, map[string]series.Sample{"a": {Timestamp: 4000, Value: 4}, "b": {Timestamp: 1000, Value: 10}})
+	check(5000, 2*time.Second, map[string]series.Sample{"a": {Timestamp: 4000, Value: 4}})
+}
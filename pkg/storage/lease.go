@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// blockLeases reference-counts readers of persisted blocks, so compaction
+// and retention never delete a block while a query is reading it.
+//
+// Readers take a snapshot: a lease on every block visible at that moment.
+// A block removed while leased disappears from new snapshots at once, but
+// its directory is only deleted when the last lease on it is released.
+// Blocks being written by compaction stay hidden until their merge is
+// committed, so a snapshot sees either the source blocks of a merge or its
+// outputs, never both or neither.
+//
+// Once adoption is enabled, only adopted blocks are visible: those found
+// on open, and those written or moved here since. Blocks added by other
+// tools are adopted by TSDB.ScanBlocks once verified.
+type blockLeases struct {
+	mu      sync.Mutex
+	refs    map[string]int      // block dir -> open leases
+	pending map[string]bool     // Removed while leased, deleted on last release; true to move to the trash instead
+	hidden  map[string]struct{} // Being written; not yet visible
+	adopted map[string]struct{} // Verified blocks; nil if adoption is disabled
+}
+
+// newBlockLeases creates an empty lease table
+func newBlockLeases() *blockLeases {
+	return &blockLeases{
+		refs:    make(map[string]int),
+		pending: make(map[string]bool),
+		hidden:  make(map[string]struct{}),
+	}
+}
+
+// blockSnapshot is a consistent, leased view of the blocks in a data
+// directory. It must be released once the reader is done with it.
+type blockSnapshot struct {
+	leases *blockLeases
+	dirs   []string
+	once   sync.Once
+}
+
+// snapshot leases every visible block in dataDirs
+func (l *blockLeases) snapshot(dataDirs ...string) (*blockSnapshot, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	dirs, err := blockDirs(dataDirs...)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := dirs[:0]
+	for _, dir := range dirs {
+		if _, ok := l.pending[dir]; ok {
+			continue
+		}
+		if _, ok := l.hidden[dir]; ok {
+			continue
+		}
+		if !l.isAdoptedLocked(dir) {
+			continue
+		}
+		l.refs[dir]++
+		visible = append(visible, dir)
+	}
+	return &blockSnapshot{leases: l, dirs: visible}, nil
+}
+
+// Dirs returns the block directories in the snapshot, sorted by ULID
+func (s *blockSnapshot) Dirs() []string {
+	return s.dirs
+}
+
+// Release drops the snapshot's leases, deleting blocks that were removed
+// while it held them. Releasing twice is a no-op.
+func (s *blockSnapshot) Release() {
+	s.once.Do(func() {
+		s.leases.release(s.dirs)
+	})
+}
+
+// release drops one lease on each of dirs
+func (l *blockLeases) release(dirs []string) {
+	deletable := make(map[string]bool)
+
+	l.mu.Lock()
+	for _, dir := range dirs {
+		l.refs[dir]--
+		if l.refs[dir] > 0 {
+			continue
+		}
+		delete(l.refs, dir)
+		if trash, ok := l.pending[dir]; ok {
+			deletable[dir] = trash
+			delete(l.adopted, dir)
+		}
+	}
+	l.mu.Unlock()
+
+	// Still pending while deleting, so no new snapshot picks them up
+	for dir, trash := range deletable {
+		if err := deleteBlockDir(dir, trash); err != nil {
+			fmt.Printf("tsdb: failed to delete released block %s: %v\n", dir, err)
+		}
+		l.mu.Lock()
+		delete(l.pending, dir)
+		l.mu.Unlock()
+	}
+}
+
+// create makes the directory of a new block, hidden until commit. It
+// reports false if the directory already exists.
+func (l *blockLeases) create(dir string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	created, err := mkdirNew(dir)
+	if created {
+		l.hidden[dir] = struct{}{}
+	}
+	return created, err
+}
+
+// commit atomically makes the hidden outputs visible and removes the
+// source blocks
+func (l *blockLeases) commit(outputs []string, sources []*Block) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, dir := range outputs {
+		delete(l.hidden, dir)
+		l.adoptLocked(dir)
+	}
+	for _, block := range sources {
+		if err := l.removeLocked(block, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// move renames the copy of block at tmp to dst, making it visible, and
+// removes block, so a snapshot sees exactly one of the two
+func (l *blockLeases) move(block *Block, tmp, dst string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.Rename(tmp, dst); err != nil {
+		return err
+	}
+	l.adoptLocked(dst)
+	return l.removeLocked(block, false)
+}
+
+// remove deletes block, or moves it to the trash if trash is set, now or
+// once the last lease on it is released
+func (l *blockLeases) remove(block *Block, trash bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.removeLocked(block, trash)
+}
+
+// removeLocked is remove with l.mu held
+func (l *blockLeases) removeLocked(block *Block, trash bool) error {
+	dir := block.Dir()
+	if l.refs[dir] == 0 {
+		delete(l.adopted, dir)
+		if !trash {
+			return block.Delete()
+		}
+		if err := block.Close(); err != nil {
+			return err
+		}
+		return moveToTrash(dir, time.Now())
+	}
+
+	l.pending[dir] = trash
+	return block.Close()
+}
+
+// close deletes blocks still waiting for their leases to be released; the
+// readers holding them are gone once the database is closed
+func (l *blockLeases) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for dir, trash := range l.pending {
+		if err := deleteBlockDir(dir, trash); err != nil {
+			fmt.Printf("tsdb: failed to delete released block %s: %v\n", dir, err)
+		}
+		delete(l.pending, dir)
+		delete(l.refs, dir)
+		delete(l.adopted, dir)
+	}
+}
+
+// enableAdoption hides blocks from snapshots until they are adopted,
+// adopting the blocks in dataDirs now
+func (l *blockLeases) enableAdoption(dataDirs ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	dirs, err := blockDirs(dataDirs...)
+	if err != nil {
+		return err
+	}
+	l.adopted = make(map[string]struct{}, len(dirs))
+	for _, dir := range dirs {
+		l.adopted[dir] = struct{}{}
+	}
+	return nil
+}
+
+// adopt makes the blocks in dirs visible, if adoption is enabled
+func (l *blockLeases) adopt(dirs ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, dir := range dirs {
+		l.adoptLocked(dir)
+	}
+}
+
+// adoptLocked is adopt with l.mu held
+func (l *blockLeases) adoptLocked(dir string) {
+	if l.adopted != nil {
+		l.adopted[dir] = struct{}{}
+	}
+}
+
+// isAdoptedLocked reports whether the block in dir may be read. l.mu
+// must be held.
+func (l *blockLeases) isAdoptedLocked(dir string) bool {
+	if l.adopted == nil {
+		return true
+	}
+	_, ok := l.adopted[dir]
+	return ok
+}
+
+// visible reports whether the block in dir may be read: it is adopted,
+// completely written and not being removed
+func (l *blockLeases) visible(dir string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.pending[dir]; ok {
+		return false
+	}
+	if _, ok := l.hidden[dir]; ok {
+		return false
+	}
+	return l.isAdoptedLocked(dir)
+}
+
+// unadopted returns the blocks in dataDirs waiting to be adopted, sorted
+// by ULID. Blocks being written or removed are left out, and nothing is
+// waiting while adoption is disabled.
+func (l *blockLeases) unadopted(dataDirs ...string) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.adopted == nil {
+		return nil, nil
+	}
+	dirs, err := blockDirs(dataDirs...)
+	if err != nil {
+		return nil, err
+	}
+	var waiting []string
+	for _, dir := range dirs {
+		_, pending := l.pending[dir]
+		_, hidden := l.hidden[dir]
+		_, adopted := l.adopted[dir]
+		if !pending && !hidden && !adopted {
+			waiting = append(waiting, dir)
+		}
+	}
+	return waiting, nil
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestBlockLeasesDeferCompactionDeletes(t *testing.T) {
+	dataDir := t.TempDir()
+	writeLevel0Blocks(t, dataDir, 3, 2)
+
+	compactor := NewCompactor(DefaultCompactorOptions(dataDir))
+	defer compactor.Stop()
+
+	snap, err := compactor.leases.snapshot(dataDir)
+	if err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+	sources := slices.Clone(snap.Dirs())
+	if len(sources) != 3 {
+		t.Fatalf("expected 3 blocks in snapshot, got %d", len(sources))
+	}
+
+	if err := compactor.CompactNow(); err != nil {
+		t.Fatalf("compaction failed: %v", err)
+	}
+
+	// The leased source blocks are still readable
+	for _, dir := range sources {
+		block, err := OpenBlock(dir)
+		if err != nil {
+			t.Fatalf("leased block %s was removed: %v", dir, err)
+		}
+		block.Close()
+	}
+
+	// A new snapshot only sees the merged block
+	after, err := compactor.leases.snapshot(dataDir)
+	if err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+	defer after.Release()
+	if len(after.Dirs()) != 1 || slices.Contains(sources, after.Dirs()[0]) {
+		t.Errorf("expected only the merged block in a new snapshot, got %v", after.Dirs())
+	}
+
+	snap.Release()
+	snap.Release() // No-op
+	for _, dir := range sources {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("source block %s should be deleted after release", dir)
+		}
+	}
+	if _, err := os.Stat(after.Dirs()[0]); err != nil {
+		t.Errorf("merged block should survive: %v", err)
+	}
+}
+
+func TestBlockLeasesHideUntilCommit(t *testing.T) {
+	dataDir := t.TempDir()
+	writeLevel0Blocks(t, dataDir, 1, 1)
+	dirs, _ := blockDirs(dataDir)
+
+	leases := newBlockLeases()
+	output, _ := NewBlock(1000, 2000)
+	if err := output.createDir(dataDir, leases.create); err != nil {
+		t.Fatalf("createDir failed: %v", err)
+	}
+	output.AddSeries(series.NewSeries(map[string]string{"__name__": "merged"}),
+		[]series.Sample{{Timestamp: 1500, Value: 1}})
+	if err := output.Persist(dataDir); err != nil {
+		t.Fatalf("Persist failed: %v", err)
+	}
+
+	snap, err := leases.snapshot(dataDir)
+	if err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+	if !slices.Equal(snap.Dirs(), dirs) {
+		t.Errorf("hidden block visible: %v", snap.Dirs())
+	}
+	snap.Release()
+
+	source, err := OpenBlock(dirs[0])
+	if err != nil {
+		t.Fatalf("OpenBlock failed: %v", err)
+	}
+	if err := leases.commit([]string{output.Dir()}, []*Block{source}); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+
+	snap, err = leases.snapshot(dataDir)
+	if err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+	defer snap.Release()
+	if !slices.Equal(snap.Dirs(), []string{output.Dir()}) {
+		t.Errorf("expected only the committed block, got %v", snap.Dirs())
+	}
+	if _, err := os.Stat(dirs[0]); !os.IsNotExist(err) {
+		t.Error("unleased source block should be deleted at commit")
+	}
+}
+
+func TestQuerierSnapshotSurvivesRetention(t *testing.T) {
+	dataDir := t.TempDir()
+
+	opts := DefaultOptions(dataDir)
+	opts.EnableRetention = false
+	opts.CompactionInterval = time.Hour
+	opts.FlushInterval = time.Hour
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	// Written after Open so the initial compaction cycle leaves it alone
+	block, _ := NewBlock(1000, 2000)
+	block.AddSeries(series.NewSeries(map[string]string{"__name__": "old_metric"}),
+		[]series.Sample{{Timestamp: 1500, Value: 1}})
+	if err := block.Persist(dataDir); err != nil {
+		t.Fatalf("failed to persist block: %v", err)
+	}
+	if _, err := db.ScanBlocks(); err != nil {
+		t.Fatalf("ScanBlocks failed: %v", err)
+	}
+
+	q, err := db.Querier(0, 3000)
+	if err != nil {
+		t.Fatalf("Querier failed: %v", err)
+	}
+
+	if _, err := db.compactor.CleanupOldBlocks(3000); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+
+	values, err := q.LabelValues("__name__")
+	if err != nil {
+		t.Fatalf("LabelValues failed: %v", err)
+	}
+	if !slices.Equal(values, []string{"old_metric"}) {
+		t.Errorf("querier lost its block: got %v", values)
+	}
+
+	q.Close()
+	if _, err := os.Stat(block.Dir()); !os.IsNotExist(err) {
+		t.Error("block should be deleted once the querier is closed")
+	}
+
+	// New listings no longer see it
+	values, _, err = db.ListLabelValues("__name__", ListOptions{MinTime: 0, MaxTime: 3000})
+	if err != nil {
+		t.Fatalf("ListLabelValues failed: %v", err)
+	}
+	if len(values) != 0 {
+		t.Errorf("expected no values after deletion, got %v", values)
+	}
+}
@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"sort"
+
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+var (
+	// ErrInvalidToken indicates a malformed continuation token
+	ErrInvalidToken = tsdberrors.New(tsdberrors.Invalid, "invalid continuation token")
+
+	// ErrTooManyListedSeries is returned when a series listing matches more
+	// series than its MaxSeries option allows
+	ErrTooManyListedSeries = tsdberrors.New(tsdberrors.ResourceExhausted, "listing matches too many series")
+)
+
+// ListOptions bounds and paginates label and series listings
+type ListOptions struct {
+	// MinTime and MaxTime restrict results to series with samples in
+	// [MinTime, MaxTime] (Unix milliseconds). Both zero means unbounded;
+	// a zero MaxTime alone means no upper bound.
+	MinTime int64
+	MaxTime int64
+
+	// Limit caps the number of results per page; 0 means no limit
+	Limit int
+
+	// MaxSeries caps the series a ListSeries call may match in total,
+	// across all its matcher sets and pages; 0 means no limit
+	MaxSeries int
+
+	// Token continues a previous listing from the token it returned
+	Token string
+
+	// snapshot, if set, is the block set to consult instead of a fresh one
+	snapshot *blockSnapshot
+}
+
+// timeRange returns the effective time range and whether it is bounded
+func (o ListOptions) timeRange() (int64, int64, bool) {
+	if o.MinTime == 0 && o.MaxTime == 0 {
+		return 0, 0, false
+	}
+	maxTime := o.MaxTime
+	if maxTime == 0 {
+		maxTime = math.MaxInt64
+	}
+	return o.MinTime, maxTime, true
+}
+
+// ListLabelNames returns the sorted label names of the series matching
+// any of the matcher sets, one page at a time; no matcher sets match all
+// series. Each index only looks at the posting lists of the series
+// matched in it. The returned token is empty once the listing is complete.
+//
+// Without a time range only the head index is consulted. With one, the head
+// and the indexes of all blocks overlapping the range are; block series
+// count as present if the block overlaps the range at all.
+func (db *TSDB) ListLabelNames(opts ListOptions, matcherSets ...index.Matchers) ([]string, string, error) {
+	if db.closed.Load() {
+		return nil, "", ErrClosed
+	}
+
+	minTime, maxTime, bounded := opts.timeRange()
+	if !bounded {
+		names, err := db.head.MatchingLabelNames(matcherSets...)
+		if err != nil {
+			return nil, "", err
+		}
+		return paginate(names, opts)
+	}
+
+	ids, err := db.head.MatchingPostings(matcherSets...)
+	if err != nil {
+		return nil, "", err
+	}
+	names := make(map[string]struct{})
+	for _, s := range db.head.SeriesInRange(ids, minTime, maxTime) {
+		for name := range s.Labels {
+			names[name] = struct{}{}
+		}
+	}
+
+	err = db.eachBlockInRange(opts.snapshot, minTime, maxTime, func(b *Block) error {
+		blockNames, err := b.LabelNames(matcherSets...)
+		for _, name := range blockNames {
+			names[name] = struct{}{}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return paginate(sortedKeys(names), opts)
+}
+
+// ListLabelValues returns the sorted values of a label among the series
+// matching any of the matcher sets, one page at a time; no matcher sets
+// match all series. The returned token is empty once the listing is
+// complete. Matchers and time ranges are handled as in ListLabelNames.
+func (db *TSDB) ListLabelValues(labelName string, opts ListOptions, matcherSets ...index.Matchers) ([]string, string, error) {
+	if db.closed.Load() {
+		return nil, "", ErrClosed
+	}
+
+	minTime, maxTime, bounded := opts.timeRange()
+	if !bounded {
+		values, err := db.head.MatchingLabelValues(labelName, matcherSets...)
+		if err != nil {
+			return nil, "", err
+		}
+		return paginate(values, opts)
+	}
+
+	ids, err := db.head.MatchingPostings(matcherSets...)
+	if err != nil {
+		return nil, "", err
+	}
+	values := make(map[string]struct{})
+	for _, s := range db.head.SeriesInRange(ids, minTime, maxTime) {
+		if value, ok := s.Labels[labelName]; ok {
+			values[value] = struct{}{}
+		}
+	}
+
+	err = db.eachBlockInRange(opts.snapshot, minTime, maxTime, func(b *Block) error {
+		blockValues, err := b.LabelValues(labelName, matcherSets...)
+		for _, value := range blockValues {
+			values[value] = struct{}{}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return paginate(sortedKeys(values), opts)
+}
+
+// ListSeries returns the label sets of series matching any of the matcher
+// sets, ordered by their label string, one page at a time. A series
+// matching several sets, or found in both the head and blocks, is listed
+// once. An empty matcher set matches all series. The returned token is
+// empty once the listing is complete. Time ranges are handled as in
+// ListLabelNames.
+//
+// If the union of the matched series is larger than opts.MaxSeries, the
+// listing fails with ErrTooManyListedSeries rather than returning part of it.
+func (db *TSDB) ListSeries(opts ListOptions, matcherSets ...index.Matchers) ([]map[string]string, string, error) {
+	if db.closed.Load() {
+		return nil, "", ErrClosed
+	}
+
+	ids, err := db.head.MatchingPostings(matcherSets...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	minTime, maxTime, bounded := opts.timeRange()
+
+	var matched []*series.Series
+	if bounded {
+		matched = db.head.SeriesInRange(ids, minTime, maxTime)
+	} else {
+		matched = db.head.Series(ids)
+	}
+
+	byKey := make(map[string]map[string]string, len(matched))
+	for _, s := range matched {
+		byKey[s.String()] = s.Labels
+	}
+
+	if bounded {
+		err = db.eachBlockInRange(opts.snapshot, minTime, maxTime, func(b *Block) error {
+			labelSets, err := b.SeriesLabels(matcherSets...)
+			for _, labels := range labelSets {
+				byKey[series.NewSeries(labels).String()] = labels
+			}
+			return err
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if opts.MaxSeries > 0 && len(byKey) > opts.MaxSeries {
+		return nil, "", fmt.Errorf("%w: %d series matched, limit is %d", ErrTooManyListedSeries, len(byKey), opts.MaxSeries)
+	}
+
+	keys, next, err := paginate(sortedKeys(byKey), opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	result := make([]map[string]string, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, byKey[key])
+	}
+	return result, next, nil
+}
+
+// eachBlockInRange opens each block of snap overlapping [minTime, maxTime],
+// calls fn with it and closes it again. With a nil snap, a snapshot of the
+// current blocks is taken for the call. Leased blocks are not deleted by
+// retention or compaction until the snapshot is released.
+func (db *TSDB) eachBlockInRange(snap *blockSnapshot, minTime, maxTime int64, fn func(b *Block) error) error {
+	if snap == nil {
+		var err error
+		if snap, err = db.leases.snapshot(db.dataDir, db.coldDir); err != nil {
+			return err
+		}
+		defer snap.Release()
+	}
+
+	for _, dir := range snap.Dirs() {
+		block, err := openBlockInRange(dir, minTime, maxTime)
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			continue
+		}
+
+		err = fn(block)
+		block.Close()
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("block %s: %w", block.ULID, err)
+		}
+	}
+	return nil
+}
+
+// openBlockInRange opens the block in dir if it overlaps [minTime, maxTime].
+// It returns nil if the block doesn't overlap or has been deleted.
+func openBlockInRange(dir string, minTime, maxTime int64) (*Block, error) {
+	meta, err := readBlockMeta(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("block %s: %w", filepath.Base(dir), err)
+	}
+	if meta.MaxTime < minTime || meta.MinTime > maxTime {
+		return nil, nil
+	}
+
+	block, err := OpenBlock(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("block %s: %w", meta.ULID, err)
+	}
+	return block, nil
+}
+
+// paginate returns the page of sorted keys following opts.Token and the
+// token for the next page, or "" if this is the last page.
+func paginate(keys []string, opts ListOptions) ([]string, string, error) {
+	start := 0
+	if opts.Token != "" {
+		after, err := decodeToken(opts.Token)
+		if err != nil {
+			return nil, "", err
+		}
+		start = sort.SearchStrings(keys, after)
+		if start < len(keys) && keys[start] == after {
+			start++
+		}
+	}
+
+	page := keys[start:]
+	if opts.Limit <= 0 || len(page) <= opts.Limit {
+		return page, "", nil
+	}
+
+	page = page[:opts.Limit]
+	return page, encodeToken(page[len(page)-1]), nil
+}
+
+// encodeToken encodes the last key of a page as an opaque continuation token
+func encodeToken(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// decodeToken decodes a continuation token back into the key it continues after
+func decodeToken(token string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return string(key), nil
+}
+
+// sortedKeys returns the keys of m in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
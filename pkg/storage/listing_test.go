@@ -0,0 +1,328 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func openListingTestDB(t *testing.T) *TSDB {
+	t.Helper()
+
+	opts := DefaultOptions(t.TempDir())
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// host00..host09 with samples at 1000*i; odd hosts also carry a "zone" label
+	for i := 0; i < 10; i++ {
+		labels := map[string]string{"__name__": "cpu_usage", "host": fmt.Sprintf("host%02d", i)}
+		if i%2 == 1 {
+			labels["zone"] = "b"
+		}
+		if err := db.Insert(series.NewSeries(labels), []series.Sample{{Timestamp: int64(1000 * (i + 1)), Value: 1}}); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	return db
+}
+
+func TestListLabelValuesPagination(t *testing.T) {
+	db := openListingTestDB(t)
+
+	var all []string
+	token := ""
+	pages := 0
+	for {
+		values, next, err := db.ListLabelValues("host", ListOptions{Limit: 3, Token: token})
+		if err != nil {
+			t.Fatalf("ListLabelValues failed: %v", err)
+		}
+		if len(values) > 3 {
+			t.Fatalf("page has %d values, limit is 3", len(values))
+		}
+		all = append(all, values...)
+		pages++
+		if next == "" {
+			break
+		}
+		token = next
+	}
+
+	if pages != 4 {
+		t.Errorf("expected 4 pages, got %d", pages)
+	}
+	want, _ := db.GetLabelValues("host")
+	if !reflect.DeepEqual(all, want) {
+		t.Errorf("paged values = %v, want %v", all, want)
+	}
+}
+
+func TestListSeriesPaginationAndUnion(t *testing.T) {
+	db := openListingTestDB(t)
+
+	host0 := index.Matchers{index.MustNewMatcher(index.MatchEqual, "host", "host00")}
+	zoneB := index.Matchers{index.MustNewMatcher(index.MatchEqual, "zone", "b")}
+
+	seen := make(map[string]bool)
+	token := ""
+	for {
+		page, next, err := db.ListSeries(ListOptions{Limit: 2, Token: token}, host0, zoneB, zoneB)
+		if err != nil {
+			t.Fatalf("ListSeries failed: %v", err)
+		}
+		for _, labels := range page {
+			key := series.NewSeries(labels).String()
+			if seen[key] {
+				t.Errorf("series %s returned twice", key)
+			}
+			seen[key] = true
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+
+	if len(seen) != 6 {
+		t.Errorf("expected 6 series (host00 plus 5 in zone b), got %d", len(seen))
+	}
+
+	// MaxSeries applies to the union, not to each matcher set or page
+	if _, _, err := db.ListSeries(ListOptions{Limit: 2, MaxSeries: 6}, host0, zoneB, zoneB); err != nil {
+		t.Errorf("ListSeries within MaxSeries failed: %v", err)
+	}
+	if _, _, err := db.ListSeries(ListOptions{Limit: 2, MaxSeries: 5}, host0, zoneB, zoneB); !errors.Is(err, ErrTooManyListedSeries) {
+		t.Errorf("ListSeries over MaxSeries: got %v, want ErrTooManyListedSeries", err)
+	}
+}
+
+func TestListTimeBounded(t *testing.T) {
+	db := openListingTestDB(t)
+
+	// Only host02..host04 have samples in [3000, 5000]
+	values, _, err := db.ListLabelValues("host", ListOptions{MinTime: 3000, MaxTime: 5000})
+	if err != nil {
+		t.Fatalf("ListLabelValues failed: %v", err)
+	}
+	if want := []string{"host02", "host03", "host04"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+
+	// host00 is the only series in [0, 1000] and has no zone label
+	names, _, err := db.ListLabelNames(ListOptions{MinTime: 0, MaxTime: 1000})
+	if err != nil {
+		t.Fatalf("ListLabelNames failed: %v", err)
+	}
+	if want := []string{"__name__", "host"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+
+	seriesList, _, err := db.ListSeries(ListOptions{MinTime: 9000})
+	if err != nil {
+		t.Fatalf("ListSeries failed: %v", err)
+	}
+	if len(seriesList) != 2 {
+		t.Errorf("expected 2 series from 9000 on, got %d", len(seriesList))
+	}
+}
+
+func TestListInvalidToken(t *testing.T) {
+	db := openListingTestDB(t)
+
+	if _, _, err := db.ListLabelNames(ListOptions{Token: "not base64!"}); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken, got %v", err)
+	}
+}
+
+func TestListTimeBoundedConsultsBlocks(t *testing.T) {
+	dir := t.TempDir()
+
+	// A block from "last week" whose series are no longer in the head
+	block, err := NewBlock(1000, 2000)
+	if err != nil {
+		t.Fatalf("failed to create block: %v", err)
+	}
+	for _, host := range []string{"old1", "old2"} {
+		s := series.NewSeries(map[string]string{"__name__": "disk_usage", "host": host, "dc": "west"})
+		if err := block.AddSeries(s, []series.Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}}); err != nil {
+			t.Fatalf("failed to add series: %v", err)
+		}
+	}
+	if err := block.Persist(dir); err != nil {
+		t.Fatalf("failed to persist block: %v", err)
+	}
+
+	opts := DefaultOptions(dir)
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	head := series.NewSeries(map[string]string{"__name__": "disk_usage", "host": "new1"})
+	if err := db.Insert(head, []series.Sample{{Timestamp: 10000, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	names, _, err := db.ListLabelNames(ListOptions{MinTime: 1500, MaxTime: 1600})
+	if err != nil {
+		t.Fatalf("ListLabelNames failed: %v", err)
+	}
+	if want := []string{"__name__", "dc", "host"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+
+	values, _, err := db.ListLabelValues("host", ListOptions{MinTime: 0, MaxTime: 20000})
+	if err != nil {
+		t.Fatalf("ListLabelValues failed: %v", err)
+	}
+	if want := []string{"new1", "old1", "old2"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+
+	seriesList, _, err := db.ListSeries(ListOptions{MinTime: 1000, MaxTime: 2000},
+		index.Matchers{index.MustNewMatcher(index.MatchEqual, "host", "old2")})
+	if err != nil {
+		t.Fatalf("ListSeries failed: %v", err)
+	}
+	want := map[string]string{"__name__": "disk_usage", "host": "old2", "dc": "west"}
+	if len(seriesList) != 1 || !reflect.DeepEqual(seriesList[0], want) {
+		t.Errorf("series = %v, want [%v]", seriesList, want)
+	}
+
+	// Windows after the block only see the head
+	values, _, err = db.ListLabelValues("host", ListOptions{MinTime: 5000})
+	if err != nil {
+		t.Fatalf("ListLabelValues failed: %v", err)
+	}
+	if want := []string{"new1"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+}
+
+func TestListLabelsMatchers(t *testing.T) {
+	db := openListingTestDB(t)
+	zoneB := index.Matchers{index.MustNewMatcher(index.MatchEqual, "zone", "b")}
+	host00 := index.Matchers{index.MustNewMatcher(index.MatchEqual, "host", "host00")}
+
+	values, _, err := db.ListLabelValues("host", ListOptions{}, zoneB)
+	if err != nil {
+		t.Fatalf("ListLabelValues failed: %v", err)
+	}
+	if want := []string{"host01", "host03", "host05", "host07", "host09"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+
+	// Matcher sets are unioned, and paginate like unscoped listings
+	values, next, err := db.ListLabelValues("host", ListOptions{Limit: 2}, zoneB, host00)
+	if err != nil {
+		t.Fatalf("ListLabelValues failed: %v", err)
+	}
+	if want := []string{"host00", "host01"}; !reflect.DeepEqual(values, want) || next == "" {
+		t.Errorf("values = %v, next %q; want %v and a next page", values, next, want)
+	}
+
+	names, _, err := db.ListLabelNames(ListOptions{}, host00)
+	if err != nil {
+		t.Fatalf("ListLabelNames failed: %v", err)
+	}
+	if want := []string{"__name__", "host"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+
+	// Time ranges narrow the matched series further
+	names, _, err = db.ListLabelNames(ListOptions{MinTime: 2000, MaxTime: 2000}, zoneB)
+	if err != nil {
+		t.Fatalf("ListLabelNames failed: %v", err)
+	}
+	if want := []string{"__name__", "host", "zone"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+	values, _, err = db.ListLabelValues("zone", ListOptions{MinTime: 1000, MaxTime: 1000}, zoneB)
+	if err != nil {
+		t.Fatalf("ListLabelValues failed: %v", err)
+	}
+	if len(values) != 0 {
+		t.Errorf("values = %v, want none", values)
+	}
+
+	// A selector matching nothing lists nothing
+	none := index.Matchers{index.MustNewMatcher(index.MatchEqual, "host", "missing")}
+	if names, _, err := db.ListLabelNames(ListOptions{}, none); err != nil || len(names) != 0 {
+		t.Errorf("ListLabelNames() = %v, %v; want nothing", names, err)
+	}
+}
+
+func TestListLabelsMatchersConsultBlocks(t *testing.T) {
+	dir := t.TempDir()
+
+	block, err := NewBlock(1000, 2000)
+	if err != nil {
+		t.Fatalf("failed to create block: %v", err)
+	}
+	for _, labels := range []map[string]string{
+		{"__name__": "disk_usage", "host": "old1", "dc": "west"},
+		{"__name__": "disk_usage", "host": "old2", "dc": "east"},
+		{"__name__": "net_bytes", "host": "old3", "iface": "eth0"},
+	} {
+		if err := block.AddSeries(series.NewSeries(labels), []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+			t.Fatalf("failed to add series: %v", err)
+		}
+	}
+	if err := block.Persist(dir); err != nil {
+		t.Fatalf("failed to persist block: %v", err)
+	}
+
+	opts := DefaultOptions(dir)
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	head := series.NewSeries(map[string]string{"__name__": "disk_usage", "host": "new1", "mount": "/"})
+	if err := db.Insert(head, []series.Sample{{Timestamp: 10000, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	diskUsage := index.Matchers{index.MustNewMatcher(index.MatchEqual, "__name__", "disk_usage")}
+	window := ListOptions{MinTime: 0, MaxTime: 20000}
+
+	names, _, err := db.ListLabelNames(window, diskUsage)
+	if err != nil {
+		t.Fatalf("ListLabelNames failed: %v", err)
+	}
+	if want := []string{"__name__", "dc", "host", "mount"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+
+	values, _, err := db.ListLabelValues("host", window, diskUsage)
+	if err != nil {
+		t.Fatalf("ListLabelValues failed: %v", err)
+	}
+	if want := []string{"new1", "old1", "old2"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+
+	west := index.Matchers{index.MustNewMatcher(index.MatchRegexp, "dc", "we.*")}
+	values, _, err = db.ListLabelValues("host", window, west)
+	if err != nil {
+		t.Fatalf("ListLabelValues failed: %v", err)
+	}
+	if want := []string{"old1"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+}
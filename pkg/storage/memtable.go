@@ -3,18 +3,21 @@ package storage
 import (
 	"errors"
 	"fmt"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
 	"github.com/therealutkarshpriyadarshi/time/pkg/series"
 )
 
 var (
 	// ErrMemTableFull indicates the MemTable has reached its size limit
-	ErrMemTableFull = errors.New("memtable is full")
+	ErrMemTableFull = tsdberrors.New(tsdberrors.Unavailable, "memtable is full")
 
 	// ErrInvalidSample indicates the sample data is invalid
-	ErrInvalidSample = errors.New("invalid sample")
+	ErrInvalidSample = tsdberrors.New(tsdberrors.Invalid, "invalid sample")
 )
 
 const (
@@ -25,24 +28,45 @@ const (
 	EstimatedBytesPerSample = 24 // 8 bytes timestamp + 8 bytes value + ~8 bytes overhead
 )
 
+// DefaultMemTableShards is the default number of MemTable shards
+const DefaultMemTableShards = 16
+
 // MemTable is an in-memory buffer for time-series samples.
 // It provides thread-safe operations for inserting and querying samples.
 // When the MemTable reaches its size threshold, it should be flushed to disk.
+//
+// Series are spread over shards by series hash, each with its own lock and
+// size accounting, so inserts for different series proceed in parallel.
+// The size limit applies to the MemTable as a whole.
 type MemTable struct {
+	shards []*memTableShard
+
+	// size tracks the approximate memory usage in bytes across all shards
+	size atomic.Int64
+
+	// maxSize is the size threshold for triggering a flush
+	maxSize int64
+
+	// createdAt tracks when this MemTable was created (unix nanoseconds)
+	createdAt atomic.Int64
+
+	// policy decides how duplicate and out-of-order samples are handled
+	policy SamplePolicy
+}
+
+// memTableShard holds the series whose hash maps to it
+type memTableShard struct {
 	// series maps seriesHash -> samples
 	series map[uint64][]series.Sample
 
 	// seriesMeta maps seriesHash -> Series metadata
 	seriesMeta map[uint64]*series.Series
 
-	// size tracks the approximate memory usage in bytes
-	size int64
+	// newest maps seriesHash -> timestamp of the series' newest sample
+	newest map[uint64]int64
 
-	// maxSize is the size threshold for triggering a flush
-	maxSize int64
-
-	// createdAt tracks when this MemTable was created
-	createdAt time.Time
+	// size tracks the approximate memory usage of this shard in bytes
+	size int64
 
 	// minTime and maxTime track the time range of samples
 	minTime int64
@@ -52,6 +76,16 @@ type MemTable struct {
 	mu sync.RWMutex
 }
 
+func newMemTableShard() *memTableShard {
+	return &memTableShard{
+		series:     make(map[uint64][]series.Sample),
+		seriesMeta: make(map[uint64]*series.Series),
+		newest:     make(map[uint64]int64),
+		minTime:    -1,
+		maxTime:    -1,
+	}
+}
+
 // NewMemTable creates a new MemTable with the default maximum size.
 func NewMemTable() *MemTable {
 	return NewMemTableWithSize(DefaultMaxSize)
@@ -59,107 +93,407 @@ func NewMemTable() *MemTable {
 
 // NewMemTableWithSize creates a new MemTable with a custom maximum size.
 func NewMemTableWithSize(maxSize int64) *MemTable {
-	return &MemTable{
-		series:     make(map[uint64][]series.Sample),
-		seriesMeta: make(map[uint64]*series.Series),
-		maxSize:    maxSize,
-		createdAt:  time.Now(),
-		minTime:    -1,
-		maxTime:    -1,
+	return NewShardedMemTable(maxSize, DefaultMemTableShards)
+}
+
+// NewShardedMemTable creates a new MemTable with a custom maximum size and
+// number of shards. Values of shards below 1 mean a single shard.
+func NewShardedMemTable(maxSize int64, shards int) *MemTable {
+	if shards < 1 {
+		shards = 1
+	}
+
+	m := &MemTable{
+		shards:  make([]*memTableShard, shards),
+		maxSize: maxSize,
+	}
+	for i := range m.shards {
+		m.shards[i] = newMemTableShard()
+	}
+	m.createdAt.Store(time.Now().UnixNano())
+	return m
+}
+
+// shardFor returns the shard holding a series
+func (m *MemTable) shardFor(seriesHash uint64) *memTableShard {
+	return m.shards[seriesHash%uint64(len(m.shards))]
+}
+
+// reserve accounts for n bytes against the size limit.
+// Returns false if that would exceed the limit.
+func (m *MemTable) reserve(n int64) bool {
+	for {
+		size := m.size.Load()
+		if size+n > m.maxSize {
+			return false
+		}
+		if m.size.CompareAndSwap(size, size+n) {
+			return true
+		}
 	}
 }
 
+// SetSamplePolicy sets how duplicate and out-of-order samples are handled.
+// It must be called before the MemTable is used.
+func (m *MemTable) SetSamplePolicy(policy SamplePolicy) {
+	m.policy = policy
+}
+
 // Insert adds samples for a given series to the MemTable.
 // Returns an error if the MemTable is full or if the input is invalid.
+//
+// Samples the sample policy rejects are dropped rather than failing the
+// insert; the TSDB checks writes against the policy before logging them.
 func (m *MemTable) Insert(s *series.Series, samples []series.Sample) error {
+	_, err := m.insertSamples(s, samples)
+	return err
+}
+
+// insertSamples inserts samples and returns how the sample policy treated them
+func (m *MemTable) insertSamples(s *series.Series, samples []series.Sample) (sampleCounts, error) {
 	if s == nil || len(samples) == 0 {
-		return ErrInvalidSample
+		return sampleCounts{}, ErrInvalidSample
 	}
 
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	// Check if we have space
 	estimatedSize := int64(len(samples)) * EstimatedBytesPerSample
-	if m.size+estimatedSize > m.maxSize {
+	if !m.reserve(estimatedSize) {
+		return sampleCounts{}, ErrMemTableFull
+	}
+
+	sh := m.shardFor(s.Hash)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	counts, sizeDelta := sh.insert(s, samples, m.policy)
+	m.size.Add(sizeDelta)
+	return counts, nil
+}
+
+// check returns an error if the sample policy rejects any of the samples,
+// along with counts of the rejected samples. Nothing is inserted.
+func (m *MemTable) check(s *series.Series, samples []series.Sample) (sampleCounts, error) {
+	var counts sampleCounts
+	reject := m.policy.duplicates() == DuplicateReject
+	if !reject && m.policy.OutOfOrderWindow == 0 {
+		return counts, nil
+	}
+
+	sh := m.shardFor(s.Hash)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	existing := sh.series[s.Hash]
+	newest, ok := sh.newest[s.Hash]
+
+	var firstErr error
+	for i, sample := range samples {
+		ts := sample.Timestamp
+		if !ok || ts > newest {
+			newest, ok = ts, true
+			continue
+		}
+
+		if m.policy.tooOld(ts, newest) {
+			counts.outOfOrderRejected++
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%w: series %s, timestamp %d is more than %s behind %d",
+					ErrOutOfOrderSample, s, ts, m.policy.OutOfOrderWindow, newest)
+			}
+			continue
+		}
+
+		if reject && (findTimestamp(existing, ts) >= 0 || findTimestamp(samples[:i], ts) >= 0) {
+			counts.duplicatesRejected++
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%w: series %s, timestamp %d", ErrDuplicateSample, s, ts)
+			}
+		}
+	}
+
+	return counts, firstErr
+}
+
+// findTimestamp returns the index of the sample at ts, or -1. It searches
+// from the end, where duplicates of recent writes are.
+func findTimestamp(samples []series.Sample, ts int64) int {
+	for i := len(samples) - 1; i >= 0; i-- {
+		if samples[i].Timestamp == ts {
+			return i
+		}
+	}
+	return -1
+}
+
+// InsertBatch adds samples for several series at once: either all of them
+// are inserted or, if the MemTable lacks space for the whole batch, none.
+// Queries see either none or all of the batch.
+func (m *MemTable) InsertBatch(batch []*series.Series, samples [][]series.Sample) error {
+	if len(batch) == 0 || len(batch) != len(samples) {
+		return ErrInvalidSample
+	}
+
+	for i, s := range batch {
+		if s == nil || len(samples[i]) == 0 {
+			return ErrInvalidSample
+		}
+	}
+	if !m.reserve(batchSize(samples)) {
 		return ErrMemTableFull
 	}
 
+	m.applyBatch(batch, samples)
+	return nil
+}
+
+// checkBatch checks every series of a batch against the sample policy,
+// returning a *SeriesError for each rejected series
+func (m *MemTable) checkBatch(batch []*series.Series, samples [][]series.Sample) (sampleCounts, error) {
+	var counts sampleCounts
+	var errs []error
+	for i, s := range batch {
+		c, err := m.check(s, samples[i])
+		counts.add(c)
+		if err != nil {
+			errs = append(errs, &SeriesError{Series: s, Samples: len(samples[i]), Err: err})
+		}
+	}
+	return counts, errors.Join(errs...)
+}
+
+// batchSize returns the estimated size of a batch's samples
+func batchSize(samples [][]series.Sample) int64 {
+	var n int64
+	for _, s := range samples {
+		n += int64(len(s)) * EstimatedBytesPerSample
+	}
+	return n
+}
+
+// release returns reserved size that will not be used
+func (m *MemTable) release(n int64) {
+	m.size.Add(-n)
+}
+
+// applyBatch inserts a validated batch whose size has been reserved and
+// returns how the sample policy treated it
+func (m *MemTable) applyBatch(batch []*series.Series, samples [][]series.Sample) sampleCounts {
+	// Lock the shards involved in index order, so concurrent batches can't
+	// deadlock
+	locked := make([]bool, len(m.shards))
+	for _, s := range batch {
+		locked[s.Hash%uint64(len(m.shards))] = true
+	}
+	for i, sh := range m.shards {
+		if locked[i] {
+			sh.mu.Lock()
+			defer sh.mu.Unlock()
+		}
+	}
+
+	var counts sampleCounts
+	for i, s := range batch {
+		c, sizeDelta := m.shardFor(s.Hash).insert(s, samples[i], m.policy)
+		m.size.Add(sizeDelta)
+		counts.add(c)
+	}
+	return counts
+}
+
+// insert adds samples for a series whose size has been reserved, applying
+// the sample policy. Samples the policy rejects are dropped. It returns how
+// the samples were treated and the size to add to the reservation: new
+// series metadata, minus reserved samples that were not stored. sh.mu must
+// be held.
+func (sh *memTableShard) insert(s *series.Series, samples []series.Sample, policy SamplePolicy) (sampleCounts, int64) {
 	// Store series metadata if not already present
-	if _, exists := m.seriesMeta[s.Hash]; !exists {
-		m.seriesMeta[s.Hash] = s.Clone()
+	var metaSize int64
+	if _, exists := sh.seriesMeta[s.Hash]; !exists {
+		sh.seriesMeta[s.Hash] = s.Clone()
 		// Add estimated size for series metadata
 		for k, v := range s.Labels {
-			m.size += int64(len(k) + len(v) + 16) // rough estimate
+			metaSize += int64(len(k) + len(v) + 16) // rough estimate
 		}
+		sh.size += metaSize
 	}
 
 	// Get existing samples or create new slice
-	existingSamples := m.series[s.Hash]
-
-	// Append new samples
-	m.series[s.Hash] = append(existingSamples, samples...)
-	m.size += estimatedSize
+	existingSamples := sh.series[s.Hash]
+	newest, ok := sh.newest[s.Hash]
 
-	// Update time range
+	var counts sampleCounts
+	added := 0
 	for _, sample := range samples {
-		if m.minTime == -1 || sample.Timestamp < m.minTime {
-			m.minTime = sample.Timestamp
+		ts := sample.Timestamp
+		switch {
+		case !ok || ts > newest:
+			// In order, the common case
+			newest, ok = ts, true
+		case policy.tooOld(ts, newest):
+			counts.outOfOrderRejected++
+			continue
+		default:
+			if i := findTimestamp(existingSamples, ts); i >= 0 {
+				switch policy.duplicates() {
+				case DuplicateOverwrite:
+					existingSamples[i].Value = sample.Value
+					counts.duplicatesOverwritten++
+				case DuplicateKeepFirst:
+					counts.duplicatesDropped++
+				default:
+					counts.duplicatesRejected++
+				}
+				continue
+			}
+			counts.outOfOrder++
+		}
+
+		existingSamples = append(existingSamples, sample)
+		added++
+
+		// Update time range
+		if sh.minTime == -1 || ts < sh.minTime {
+			sh.minTime = ts
 		}
-		if m.maxTime == -1 || sample.Timestamp > m.maxTime {
-			m.maxTime = sample.Timestamp
+		if sh.maxTime == -1 || ts > sh.maxTime {
+			sh.maxTime = ts
 		}
 	}
 
-	return nil
+	if added > 0 {
+		sh.series[s.Hash] = existingSamples
+		sh.newest[s.Hash] = newest
+	}
+	sh.size += int64(added) * EstimatedBytesPerSample
+
+	return counts, metaSize - int64(len(samples)-added)*EstimatedBytesPerSample
 }
 
 // Query retrieves samples for a given series hash within a time range.
 // Returns all samples if start and end are both 0.
+//
+// The samples are sorted by timestamp, even where they were inserted out
+// of order, and copied: the caller owns the slice, and later inserts
+// neither change nor reorder it.
 func (m *MemTable) Query(seriesHash uint64, start, end int64) ([]series.Sample, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	samples, exists := m.series[seriesHash]
+	sh := m.shardFor(seriesHash)
+	sh.mu.RLock()
+	samples, exists := sh.series[seriesHash]
 	if !exists {
+		sh.mu.RUnlock()
 		return nil, nil // No error, just no data
 	}
 
-	// If no time range specified, return all samples
+	var result []series.Sample
 	if start == 0 && end == 0 {
-		result := make([]series.Sample, len(samples))
+		// If no time range specified, return all samples
+		result = make([]series.Sample, len(samples))
 		copy(result, samples)
-		return result, nil
+	} else {
+		// Filter by time range
+		result = make([]series.Sample, 0, len(samples))
+		for _, sample := range samples {
+			if sample.Timestamp >= start && sample.Timestamp <= end {
+				result = append(result, sample)
+			}
+		}
 	}
+	sh.mu.RUnlock()
 
-	// Filter by time range
-	result := make([]series.Sample, 0, len(samples))
-	for _, sample := range samples {
+	// Samples are appended in timestamp order unless accepted out of
+	// order; sort the copy outside the lock
+	if !slices.IsSortedFunc(result, compareTimestamps) {
+		sortSamples(result)
+	}
+	return result, nil
+}
+
+// HasSamples reports whether the series has any sample in [start, end].
+func (m *MemTable) HasSamples(seriesHash uint64, start, end int64) bool {
+	sh := m.shardFor(seriesHash)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	for _, sample := range sh.series[seriesHash] {
 		if sample.Timestamp >= start && sample.Timestamp <= end {
-			result = append(result, sample)
+			return true
 		}
 	}
+	return false
+}
 
-	return result, nil
+// CountSamples returns how many samples the series has in [start, end].
+func (m *MemTable) CountSamples(seriesHash uint64, start, end int64) int64 {
+	sh := m.shardFor(seriesHash)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	var count int64
+	for _, sample := range sh.series[seriesHash] {
+		if sample.Timestamp >= start && sample.Timestamp <= end {
+			count++
+		}
+	}
+	return count
+}
+
+// Latest returns the series' newest sample in [start, end]. ok is false if
+// it has none.
+func (m *MemTable) Latest(seriesHash uint64, start, end int64) (latest series.Sample, ok bool) {
+	sh := m.shardFor(seriesHash)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	samples := sh.series[seriesHash]
+	if len(samples) == 0 || sh.newest[seriesHash] < start {
+		return series.Sample{}, false
+	}
+	// Samples are appended in timestamp order unless accepted out of order
+	if last := samples[len(samples)-1]; last.Timestamp == sh.newest[seriesHash] && last.Timestamp <= end {
+		return last, true
+	}
+	for _, sample := range samples {
+		if sample.Timestamp >= start && sample.Timestamp <= end && (!ok || sample.Timestamp > latest.Timestamp) {
+			latest, ok = sample, true
+		}
+	}
+	return latest, ok
 }
 
 // GetSeries retrieves the series metadata for a given hash.
 func (m *MemTable) GetSeries(seriesHash uint64) (*series.Series, bool) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	sh := m.shardFor(seriesHash)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
 
-	s, exists := m.seriesMeta[seriesHash]
+	s, exists := sh.seriesMeta[seriesHash]
 	if !exists {
 		return nil, false
 	}
 	return s.Clone(), true
 }
 
+// Each calls fn for every series in the MemTable, merging all shards.
+// Each shard is read-locked while its series are visited, so fn must not
+// insert into the MemTable, and must not retain the samples slice.
+// Iteration stops at the first error, which is returned.
+func (m *MemTable) Each(fn func(s *series.Series, samples []series.Sample) error) error {
+	for _, sh := range m.shards {
+		sh.mu.RLock()
+		for hash, samples := range sh.series {
+			if err := fn(sh.seriesMeta[hash].Clone(), samples); err != nil {
+				sh.mu.RUnlock()
+				return err
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	return nil
+}
+
 // Size returns the current size of the MemTable in bytes.
 func (m *MemTable) Size() int64 {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return m.size
+	return m.size.Load()
 }
 
 // MaxSize returns the maximum size threshold.
@@ -169,80 +503,109 @@ func (m *MemTable) MaxSize() int64 {
 
 // IsFull returns true if the MemTable has reached its size threshold.
 func (m *MemTable) IsFull() bool {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return m.size >= m.maxSize
+	return m.size.Load() >= m.maxSize
+}
+
+// ShardCount returns the number of shards.
+func (m *MemTable) ShardCount() int {
+	return len(m.shards)
 }
 
 // SeriesCount returns the number of unique series in the MemTable.
 func (m *MemTable) SeriesCount() int {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return len(m.series)
+	count := 0
+	for _, sh := range m.shards {
+		sh.mu.RLock()
+		count += len(sh.series)
+		sh.mu.RUnlock()
+	}
+	return count
 }
 
 // SampleCount returns the total number of samples in the MemTable.
 func (m *MemTable) SampleCount() int64 {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
 	var count int64
-	for _, samples := range m.series {
-		count += int64(len(samples))
+	for _, sh := range m.shards {
+		sh.mu.RLock()
+		for _, samples := range sh.series {
+			count += int64(len(samples))
+		}
+		sh.mu.RUnlock()
 	}
 	return count
 }
 
 // TimeRange returns the minimum and maximum timestamps in the MemTable.
 func (m *MemTable) TimeRange() (int64, int64) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return m.minTime, m.maxTime
+	minTime, maxTime := int64(-1), int64(-1)
+	for _, sh := range m.shards {
+		sh.mu.RLock()
+		if sh.minTime != -1 && (minTime == -1 || sh.minTime < minTime) {
+			minTime = sh.minTime
+		}
+		if sh.maxTime != -1 && (maxTime == -1 || sh.maxTime > maxTime) {
+			maxTime = sh.maxTime
+		}
+		sh.mu.RUnlock()
+	}
+	return minTime, maxTime
 }
 
 // CreatedAt returns when this MemTable was created.
 func (m *MemTable) CreatedAt() time.Time {
-	return m.createdAt
+	return time.Unix(0, m.createdAt.Load())
 }
 
 // AllSeries returns all series hashes in the MemTable.
 func (m *MemTable) AllSeries() []uint64 {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	hashes := make([]uint64, 0, len(m.series))
-	for hash := range m.series {
-		hashes = append(hashes, hash)
+	var hashes []uint64
+	for _, sh := range m.shards {
+		sh.mu.RLock()
+		for hash := range sh.series {
+			hashes = append(hashes, hash)
+		}
+		sh.mu.RUnlock()
 	}
 	return hashes
 }
 
 // Stats returns statistics about the MemTable.
 func (m *MemTable) Stats() string {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	size := m.Size()
+	minTime, maxTime := m.TimeRange()
 
-	return fmt.Sprintf("MemTable{series: %d, samples: %d, size: %d/%d bytes (%.1f%%), timeRange: [%d, %d]}",
-		len(m.series),
+	return fmt.Sprintf("MemTable{series: %d, samples: %d, shards: %d, size: %d/%d bytes (%.1f%%), timeRange: [%d, %d]}",
+		m.SeriesCount(),
 		m.SampleCount(),
-		m.size,
+		len(m.shards),
+		size,
 		m.maxSize,
-		float64(m.size)/float64(m.maxSize)*100,
-		m.minTime,
-		m.maxTime,
+		float64(size)/float64(m.maxSize)*100,
+		minTime,
+		maxTime,
 	)
 }
 
 // Clear removes all data from the MemTable and resets its state.
 // This is typically called after a successful flush to disk.
 func (m *MemTable) Clear() {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	m.series = make(map[uint64][]series.Sample)
-	m.seriesMeta = make(map[uint64]*series.Series)
-	m.size = 0
-	m.minTime = -1
-	m.maxTime = -1
-	m.createdAt = time.Now()
+	for _, sh := range m.shards {
+		sh.mu.Lock()
+	}
+	defer func() {
+		for _, sh := range m.shards {
+			sh.mu.Unlock()
+		}
+	}()
+
+	for i := range m.shards {
+		m.shards[i].series = make(map[uint64][]series.Sample)
+		m.shards[i].seriesMeta = make(map[uint64]*series.Series)
+		m.shards[i].newest = make(map[uint64]int64)
+		m.shards[i].size = 0
+		m.shards[i].minTime = -1
+		m.shards[i].maxTime = -1
+	}
+	m.size.Store(0)
+	m.createdAt.Store(time.Now().UnixNano())
 }
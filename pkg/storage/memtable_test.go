@@ -2,7 +2,9 @@ package storage
 
 import (
 	"fmt"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -205,6 +207,39 @@ func TestMemTableQuery_TimeRange(t *testing.T) {
 	}
 }
 
+func TestMemTableQuery_OutOfOrder(t *testing.T) {
+	mt := NewMemTable()
+	s := series.NewSeries(map[string]string{"host": "server1"})
+
+	// Interleaved inserts, each in order but overlapping the others
+	for _, batch := range [][]int64{{1000, 4000}, {2000, 5000}, {3000}} {
+		var samples []series.Sample
+		for _, ts := range batch {
+			samples = append(samples, series.Sample{Timestamp: ts, Value: float64(ts)})
+		}
+		if err := mt.Insert(s, samples); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	for _, r := range [][2]int64{{0, 0}, {1500, 4500}} {
+		result, err := mt.Query(s.Hash, r[0], r[1])
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if len(result) == 0 || !slices.IsSortedFunc(result, compareTimestamps) {
+			t.Errorf("Query(%d, %d) = %v, want samples sorted by timestamp", r[0], r[1], result)
+		}
+	}
+
+	// The result is a copy
+	result, _ := mt.Query(s.Hash, 0, 0)
+	result[0].Value = -1
+	if again, _ := mt.Query(s.Hash, 0, 0); again[0].Value != 1000 {
+		t.Errorf("modifying a result changed the MemTable: %v", again)
+	}
+}
+
 func TestMemTableQuery_NonExistent(t *testing.T) {
 	mt := NewMemTable()
 
@@ -286,9 +321,9 @@ func TestMemTableIsFull(t *testing.T) {
 	// The insert failed, so it shouldn't be marked as full yet
 	// Let's insert smaller amounts until it's full
 	mt2 := NewMemTableWithSize(100)
-	sample := []series.Sample{{Timestamp: 1000, Value: 0.5}}
-	for !mt2.IsFull() {
-		err := mt2.Insert(s, sample)
+	for ts := int64(1000); !mt2.IsFull(); ts += 1000 {
+		// Distinct timestamps: duplicates overwrite and don't grow the MemTable
+		err := mt2.Insert(s, []series.Sample{{Timestamp: ts, Value: 0.5}})
 		if err == ErrMemTableFull {
 			break
 		}
@@ -443,3 +478,100 @@ func TestMemTableStats(t *testing.T) {
 		t.Error("Stats string seems too short")
 	}
 }
+
+func TestMemTableShardedSizeLimit(t *testing.T) {
+	// Room for exactly 100 single-sample inserts, ignoring label overhead
+	mt := NewShardedMemTable(100*EstimatedBytesPerSample, 8)
+
+	var wg sync.WaitGroup
+	var accepted atomic.Int64
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				s := series.NewSeries(map[string]string{"g": fmt.Sprintf("%d", g), "i": fmt.Sprintf("%d", i)})
+				if err := mt.Insert(s, []series.Sample{{Timestamp: int64(i), Value: 1}}); err == nil {
+					accepted.Add(1)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// The limit applies across shards: samples beyond it are rejected
+	if got := mt.SampleCount(); got != accepted.Load() || got > 100 {
+		t.Errorf("SampleCount = %d, accepted = %d, want equal and <= 100", got, accepted.Load())
+	}
+	if !mt.IsFull() {
+		t.Error("MemTable should be full")
+	}
+}
+
+func TestMemTableEach(t *testing.T) {
+	mt := NewShardedMemTable(DefaultMaxSize, 4)
+
+	for i := 0; i < 20; i++ {
+		s := series.NewSeries(map[string]string{"host": fmt.Sprintf("server%d", i)})
+		mt.Insert(s, []series.Sample{{Timestamp: int64(1000 + i), Value: float64(i)}})
+	}
+
+	seen := make(map[string]bool)
+	err := mt.Each(func(s *series.Series, samples []series.Sample) error {
+		if len(samples) != 1 {
+			t.Errorf("series %v has %d samples, want 1", s.Labels, len(samples))
+		}
+		seen[s.Labels["host"]] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Each failed: %v", err)
+	}
+	if len(seen) != 20 {
+		t.Errorf("Each visited %d series, want 20", len(seen))
+	}
+
+	minTime, maxTime := mt.TimeRange()
+	if minTime != 1000 || maxTime != 1019 {
+		t.Errorf("TimeRange = [%d, %d], want [1000, 1019]", minTime, maxTime)
+	}
+
+	stop := fmt.Errorf("stop")
+	if err := mt.Each(func(*series.Series, []series.Sample) error { return stop }); err != stop {
+		t.Errorf("Each error = %v, want %v", err, stop)
+	}
+}
+
+func TestMemTableInsertBatch(t *testing.T) {
+	mt := NewMemTableWithSize(10 * EstimatedBytesPerSample)
+
+	s1 := series.NewSeries(map[string]string{"host": "server1"})
+	s2 := series.NewSeries(map[string]string{"host": "server2"})
+	samples := func(n int) []series.Sample {
+		result := make([]series.Sample, n)
+		for i := range result {
+			result[i] = series.Sample{Timestamp: int64(i * 1000), Value: float64(i)}
+		}
+		return result
+	}
+
+	if err := mt.InsertBatch([]*series.Series{s1, s2}, [][]series.Sample{samples(2), samples(3)}); err != nil {
+		t.Fatalf("InsertBatch failed: %v", err)
+	}
+	if mt.SampleCount() != 5 {
+		t.Errorf("Expected 5 samples, got %d", mt.SampleCount())
+	}
+
+	// A batch that doesn't fit is rejected as a whole
+	err := mt.InsertBatch([]*series.Series{s1, s2}, [][]series.Sample{samples(1), samples(10)})
+	if err != ErrMemTableFull {
+		t.Fatalf("Expected ErrMemTableFull, got %v", err)
+	}
+	if samples, _ := mt.Query(s1.Hash, 0, 0); len(samples) != 2 {
+		t.Errorf("Expected 2 samples for server1 after rejected batch, got %d", len(samples))
+	}
+
+	if err := mt.InsertBatch([]*series.Series{s1}, [][]series.Sample{nil}); err != ErrInvalidSample {
+		t.Errorf("Expected ErrInvalidSample, got %v", err)
+	}
+}
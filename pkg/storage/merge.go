@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"container/heap"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// mergeIterator merges sample lists of one series, each sorted by
+// timestamp, into a single sorted stream. When several sources hold a
+// sample for the same timestamp only the one from the latest source is
+// returned, so sources must be passed from oldest to newest write: blocks
+// in ULID order, then the flushing MemTable, then the active one.
+type mergeIterator struct {
+	heap sourceHeap
+	cur  series.Sample
+}
+
+// mergeSource is a source's position in the heap
+type mergeSource struct {
+	samples  []series.Sample
+	priority int // Index of the source; higher wins on equal timestamps
+}
+
+// sourceHeap orders sources by their next timestamp, newest source first
+// on ties
+type sourceHeap []*mergeSource
+
+func (h sourceHeap) Len() int { return len(h) }
+func (h sourceHeap) Less(i, j int) bool {
+	ti, tj := h[i].samples[0].Timestamp, h[j].samples[0].Timestamp
+	if ti != tj {
+		return ti < tj
+	}
+	return h[i].priority > h[j].priority
+}
+func (h sourceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *sourceHeap) Push(x interface{}) { *h = append(*h, x.(*mergeSource)) }
+func (h *sourceHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// newMergeIterator returns an iterator over sources, ordered from oldest
+// to newest write. Each source must be sorted by timestamp.
+func newMergeIterator(sources ...[]series.Sample) *mergeIterator {
+	it := &mergeIterator{heap: make(sourceHeap, 0, len(sources))}
+	for i, samples := range sources {
+		if len(samples) > 0 {
+			it.heap = append(it.heap, &mergeSource{samples: samples, priority: i})
+		}
+	}
+	heap.Init(&it.heap)
+	return it
+}
+
+// Next advances to the next timestamp, reporting false when all sources
+// are exhausted
+func (it *mergeIterator) Next() bool {
+	if len(it.heap) == 0 {
+		return false
+	}
+
+	// The top of the heap is the newest sample for the lowest timestamp;
+	// drop every other sample with that timestamp
+	top := it.heap[0]
+	it.cur = top.samples[0]
+	for len(it.heap) > 0 && it.heap[0].samples[0].Timestamp == it.cur.Timestamp {
+		src := it.heap[0]
+		// A source may repeat a timestamp itself; its later sample wins
+		if src == top {
+			for len(src.samples) > 1 && src.samples[1].Timestamp == it.cur.Timestamp {
+				src.samples = src.samples[1:]
+				it.cur = src.samples[0]
+			}
+		}
+		src.samples = src.samples[1:]
+		if len(src.samples) == 0 {
+			heap.Pop(&it.heap)
+		} else {
+			heap.Fix(&it.heap, 0)
+		}
+	}
+	return true
+}
+
+// At returns the current sample. Only valid after Next returns true.
+func (it *mergeIterator) At() series.Sample {
+	return it.cur
+}
+
+// mergeSources merges sources, ordered from oldest to newest write, into
+// one sorted list with a single sample per timestamp
+func mergeSources(sources ...[]series.Sample) []series.Sample {
+	n := 0
+	for _, samples := range sources {
+		n += len(samples)
+	}
+	if n == 0 {
+		return nil
+	}
+
+	result := make([]series.Sample, 0, n)
+	it := newMergeIterator(sources...)
+	for it.Next() {
+		result = append(result, it.At())
+	}
+	return result
+}
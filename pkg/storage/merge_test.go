@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestMergeSources(t *testing.T) {
+	older := []series.Sample{{Timestamp: 1, Value: 1}, {Timestamp: 3, Value: 3}, {Timestamp: 5, Value: 5}}
+	middle := []series.Sample{{Timestamp: 2, Value: 20}, {Timestamp: 3, Value: 30}, {Timestamp: 3, Value: 31}}
+	newer := []series.Sample{{Timestamp: 3, Value: 300}, {Timestamp: 6, Value: 600}}
+
+	got := mergeSources(older, nil, middle, newer)
+	want := []series.Sample{
+		{Timestamp: 1, Value: 1},
+		{Timestamp: 2, Value: 20},
+		{Timestamp: 3, Value: 300},
+		{Timestamp: 5, Value: 5},
+		{Timestamp: 6, Value: 600},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Without the newest source, the later of middle's duplicates wins
+	got = mergeSources(older, middle)
+	if len(got) != 4 || got[2] != (series.Sample{Timestamp: 3, Value: 31}) {
+		t.Errorf("got %v, want 31 at timestamp 3", got)
+	}
+
+	if got := mergeSources(nil, nil); got != nil {
+		t.Errorf("expected nil for empty sources, got %v", got)
+	}
+}
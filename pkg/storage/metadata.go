@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// MetadataFile is the file in the data directory holding metric metadata
+	MetadataFile = "metadata.json"
+
+	// MetricNameLabel is the reserved label holding the metric name
+	MetricNameLabel = "__name__"
+)
+
+// MetricType is the type of a metric family (Prometheus/OpenMetrics semantics)
+type MetricType string
+
+const (
+	MetricTypeUnknown   MetricType = "unknown"
+	MetricTypeCounter   MetricType = "counter"
+	MetricTypeGauge     MetricType = "gauge"
+	MetricTypeHistogram MetricType = "histogram"
+	MetricTypeSummary   MetricType = "summary"
+)
+
+// ParseMetricType parses a metric type string. Unrecognized types map to MetricTypeUnknown.
+func ParseMetricType(s string) MetricType {
+	switch MetricType(strings.ToLower(s)) {
+	case MetricTypeCounter:
+		return MetricTypeCounter
+	case MetricTypeGauge:
+		return MetricTypeGauge
+	case MetricTypeHistogram:
+		return MetricTypeHistogram
+	case MetricTypeSummary:
+		return MetricTypeSummary
+	default:
+		return MetricTypeUnknown
+	}
+}
+
+// MetricMetadata describes a metric family
+type MetricMetadata struct {
+	Type MetricType `json:"type"`
+	Help string     `json:"help"`
+	Unit string     `json:"unit"`
+
+	// Inferred is true if the type was guessed from the metric name rather
+	// than supplied by a client. Explicit metadata always replaces inferred metadata.
+	Inferred bool `json:"inferred,omitempty"`
+}
+
+// InferMetricType guesses the metric type from naming conventions.
+// Names ending in _total, _count, _sum or _bucket are treated as counters,
+// everything else as a gauge.
+func InferMetricType(name string) MetricType {
+	for _, suffix := range []string{"_total", "_count", "_sum", "_bucket"} {
+		if strings.HasSuffix(name, suffix) {
+			return MetricTypeCounter
+		}
+	}
+	return MetricTypeGauge
+}
+
+// MetadataStore keeps per-metric metadata and persists it in the data directory.
+type MetadataStore struct {
+	path     string
+	metadata map[string]MetricMetadata
+	dirty    bool
+	mu       sync.RWMutex
+}
+
+// NewMetadataStore creates a metadata store backed by the given data directory
+func NewMetadataStore(dataDir string) *MetadataStore {
+	return &MetadataStore{
+		path:     filepath.Join(dataDir, MetadataFile),
+		metadata: make(map[string]MetricMetadata),
+	}
+}
+
+// Load reads persisted metadata from disk. A missing file is not an error.
+func (ms *MetadataStore) Load() error {
+	data, err := os.ReadFile(ms.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	metadata := make(map[string]MetricMetadata)
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return fmt.Errorf("failed to parse metadata: %w", err)
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.metadata = metadata
+	ms.dirty = false
+	return nil
+}
+
+// Persist atomically writes the metadata to disk if it changed since the last write
+func (ms *MetadataStore) Persist() error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if !ms.dirty {
+		return nil
+	}
+
+	data, err := ms.marshalLocked()
+	if err != nil {
+		return err
+	}
+
+	tmpPath := ms.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	if err := os.Rename(tmpPath, ms.path); err != nil {
+		return fmt.Errorf("failed to rename metadata: %w", err)
+	}
+
+	ms.dirty = false
+	return nil
+}
+
+// marshal returns the metadata as written to disk
+func (ms *MetadataStore) marshal() ([]byte, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.marshalLocked()
+}
+
+// marshalLocked is marshal with ms.mu held
+func (ms *MetadataStore) marshalLocked() ([]byte, error) {
+	data, err := json.MarshalIndent(ms.metadata, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	return data, nil
+}
+
+// Set stores explicit metadata for a metric, replacing any previous value
+func (ms *MetadataStore) Set(metric string, md MetricMetadata) {
+	md.Inferred = false
+	if md.Type == "" {
+		md.Type = MetricTypeUnknown
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if existing, ok := ms.metadata[metric]; ok && existing == md {
+		return
+	}
+	ms.metadata[metric] = md
+	ms.dirty = true
+}
+
+// Observe records that a metric was written. If no metadata is known yet,
+// the type is inferred from the metric name.
+func (ms *MetadataStore) Observe(metric string) {
+	if metric == "" {
+		return
+	}
+
+	ms.mu.RLock()
+	_, ok := ms.metadata[metric]
+	ms.mu.RUnlock()
+	if ok {
+		return
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.metadata[metric]; ok {
+		return
+	}
+	ms.metadata[metric] = MetricMetadata{
+		Type:     InferMetricType(metric),
+		Inferred: true,
+	}
+	ms.dirty = true
+}
+
+// Get returns the metadata for a metric
+func (ms *MetadataStore) Get(metric string) (MetricMetadata, bool) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	md, ok := ms.metadata[metric]
+	return md, ok
+}
+
+// List returns metadata for all metrics, sorted by metric name.
+// If limit is positive, at most limit metrics are returned.
+func (ms *MetadataStore) List(limit int) map[string]MetricMetadata {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	names := make([]string, 0, len(ms.metadata))
+	for name := range ms.metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if limit > 0 && len(names) > limit {
+		names = names[:limit]
+	}
+
+	result := make(map[string]MetricMetadata, len(names))
+	for _, name := range names {
+		result[name] = ms.metadata[name]
+	}
+	return result
+}
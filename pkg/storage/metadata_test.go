@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestInferMetricType(t *testing.T) {
+	tests := []struct {
+		name string
+		want MetricType
+	}{
+		{"http_requests_total", MetricTypeCounter},
+		{"rpc_duration_seconds_count", MetricTypeCounter},
+		{"rpc_duration_seconds_sum", MetricTypeCounter},
+		{"rpc_duration_seconds_bucket", MetricTypeCounter},
+		{"cpu_usage", MetricTypeGauge},
+		{"total_memory", MetricTypeGauge},
+	}
+
+	for _, tt := range tests {
+		if got := InferMetricType(tt.name); got != tt.want {
+			t.Errorf("InferMetricType(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMetadataStoreExplicitOverridesInferred(t *testing.T) {
+	ms := NewMetadataStore(t.TempDir())
+
+	ms.Observe("queue_depth")
+	md, ok := ms.Get("queue_depth")
+	if !ok || md.Type != MetricTypeGauge || !md.Inferred {
+		t.Fatalf("expected inferred gauge, got %+v (ok=%v)", md, ok)
+	}
+
+	ms.Set("queue_depth", MetricMetadata{Type: MetricTypeCounter, Help: "Items queued", Unit: "items"})
+	ms.Observe("queue_depth")
+
+	md, _ = ms.Get("queue_depth")
+	if md.Type != MetricTypeCounter || md.Inferred || md.Help != "Items queued" {
+		t.Errorf("explicit metadata was not kept: %+v", md)
+	}
+}
+
+func TestMetadataStorePersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	ms := NewMetadataStore(dir)
+	ms.Set("cpu_seconds_total", MetricMetadata{Type: MetricTypeCounter, Help: "CPU time", Unit: "seconds"})
+	ms.Observe("memory_bytes")
+	if err := ms.Persist(); err != nil {
+		t.Fatalf("failed to persist metadata: %v", err)
+	}
+
+	reloaded := NewMetadataStore(dir)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("failed to load metadata: %v", err)
+	}
+
+	all := reloaded.List(0)
+	if len(all) != 2 {
+		t.Fatalf("expected 2 metrics, got %d", len(all))
+	}
+	if md := all["cpu_seconds_total"]; md.Unit != "seconds" || md.Type != MetricTypeCounter {
+		t.Errorf("unexpected metadata after reload: %+v", md)
+	}
+
+	if limited := reloaded.List(1); len(limited) != 1 {
+		t.Errorf("expected 1 metric with limit, got %d", len(limited))
+	}
+}
+
+func TestTSDBMetadataSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+
+	s := series.NewSeries(map[string]string{"__name__": "requests_total", "job": "api"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.SetMetadata("latency_seconds", MetricMetadata{Type: MetricTypeHistogram, Unit: "seconds"}); err != nil {
+		t.Fatalf("failed to set metadata: %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close TSDB: %v", err)
+	}
+
+	db, err = Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("failed to reopen TSDB: %v", err)
+	}
+	defer db.Close()
+
+	if md, ok := db.GetMetadata("requests_total"); !ok || md.Type != MetricTypeCounter {
+		t.Errorf("expected inferred counter after restart, got %+v (ok=%v)", md, ok)
+	}
+	if md, ok := db.GetMetadata("latency_seconds"); !ok || md.Type != MetricTypeHistogram {
+		t.Errorf("expected histogram after restart, got %+v (ok=%v)", md, ok)
+	}
+}
@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+)
+
+var (
+	// ErrBlockVersionTooNew indicates a block written by a newer release
+	ErrBlockVersionTooNew = errors.New("block format version too new")
+
+	// ErrBlockVersionTooOld indicates a block older than MinBlockVersion
+	ErrBlockVersionTooOld = errors.New("block format version too old")
+)
+
+// MigrateOptions configures MigrateDataDir
+type MigrateOptions struct {
+	// BackupDir receives a copy of every file rewritten by the migration,
+	// under a subdirectory per block. Required unless DryRun is set.
+	BackupDir string
+
+	// DryRun reports which blocks need migration without changing anything
+	DryRun bool
+}
+
+// MigrationResult describes what happened to one block
+type MigrationResult struct {
+	Block        string // Block ULID
+	FromVersion  int    // Block version before migration
+	IndexVersion uint32 // Index format version before migration (0 if unreadable)
+	Migrated     bool   // Whether the block was (or, in a dry run, would be) upgraded
+	Note         string // Extra detail, e.g. why an index had to be recreated
+}
+
+// readBlockMeta reads and parses a block's meta.json
+func readBlockMeta(dir string) (*BlockMeta, error) {
+	metaData, err := os.ReadFile(filepath.Join(dir, MetaFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block metadata: %w", err)
+	}
+
+	var meta BlockMeta
+	if err := json.Unmarshal(metaData, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse block metadata: %w", err)
+	}
+	return &meta, nil
+}
+
+// checkBlockVersion refuses blocks this build cannot read
+func checkBlockVersion(meta *BlockMeta) error {
+	if meta.Version > BlockVersion {
+		return fmt.Errorf("%w: block %s has version %d, this build supports up to %d; upgrade tsdb to read it",
+			ErrBlockVersionTooNew, meta.ULID, meta.Version, BlockVersion)
+	}
+	if meta.Version < MinBlockVersion {
+		return fmt.Errorf("%w: block %s has version %d, oldest supported is %d",
+			ErrBlockVersionTooOld, meta.ULID, meta.Version, MinBlockVersion)
+	}
+	if meta.IndexVersion > index.IndexFormatVersion {
+		return fmt.Errorf("%w: block %s has index version %d, this build supports up to %d; upgrade tsdb to read it",
+			index.ErrIndexVersionTooNew, meta.ULID, meta.IndexVersion, index.IndexFormatVersion)
+	}
+	return nil
+}
+
+// CheckFormatVersions verifies that every block in the data directory can
+// be read by this build. It only reads block metadata and index headers.
+func CheckFormatVersions(dataDir string) error {
+	dirs, err := blockDirs(dataDir)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		meta, err := readBlockMeta(dir)
+		if err != nil {
+			return fmt.Errorf("block %s: %w", filepath.Base(dir), err)
+		}
+		if err := checkBlockVersion(meta); err != nil {
+			return err
+		}
+
+		// The index header is authoritative for blocks that predate IndexVersion
+		if _, err := index.ReadFormatVersion(filepath.Join(dir, IndexFile)); errors.Is(err, index.ErrIndexVersionTooNew) {
+			return fmt.Errorf("block %s: %w", meta.ULID, err)
+		}
+	}
+	return nil
+}
+
+// BlockNeedsMigration reports whether the block in dir uses an older format
+func BlockNeedsMigration(dir string) (bool, error) {
+	meta, err := readBlockMeta(dir)
+	if err != nil {
+		return false, err
+	}
+	if err := checkBlockVersion(meta); err != nil {
+		return false, err
+	}
+
+	if meta.Version < BlockVersion {
+		return true, nil
+	}
+	indexVersion, err := index.ReadFormatVersion(filepath.Join(dir, IndexFile))
+	if err != nil {
+		if errors.Is(err, index.ErrIndexVersionTooNew) {
+			return false, err
+		}
+		return true, nil
+	}
+	return indexVersion < index.IndexFormatVersion, nil
+}
+
+// MigrateBlock upgrades the block in dir to the current format in place.
+// Files that are rewritten are first copied to backupDir/<block ULID>/.
+// Chunk files are never rewritten. Blocks already in the current format
+// are left untouched and reported with Migrated=false.
+func MigrateBlock(dir, backupDir string) (*MigrationResult, error) {
+	return migrateBlock(dir, backupDir, false)
+}
+
+func migrateBlock(dir, backupDir string, dryRun bool) (*MigrationResult, error) {
+	meta, err := readBlockMeta(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBlockVersion(meta); err != nil {
+		return nil, err
+	}
+
+	result := &MigrationResult{Block: meta.ULID, FromVersion: meta.Version}
+
+	indexPath := filepath.Join(dir, IndexFile)
+	indexVersion, indexErr := index.ReadFormatVersion(indexPath)
+	if errors.Is(indexErr, index.ErrIndexVersionTooNew) {
+		return nil, fmt.Errorf("block %s: %w", meta.ULID, indexErr)
+	}
+	if indexErr == nil {
+		result.IndexVersion = indexVersion
+	}
+
+	if meta.Version == BlockVersion && indexErr == nil && indexVersion == index.IndexFormatVersion {
+		return result, nil
+	}
+	result.Migrated = true
+
+	// Early blocks were written with an empty placeholder index and don't
+	// record series labels anywhere else, so the index can only be recreated empty.
+	idx := index.NewInvertedIndex()
+	if indexErr != nil {
+		result.Note = "index unreadable, recreated empty (series labels not recorded in this block)"
+	} else if indexVersion < index.IndexFormatVersion {
+		f, err := os.Open(indexPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open index: %w", err)
+		}
+		_, err = idx.ReadFrom(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read index: %w", err)
+		}
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	if backupDir == "" {
+		return nil, fmt.Errorf("backup directory required")
+	}
+	blockBackup := filepath.Join(backupDir, meta.ULID)
+	if err := os.MkdirAll(blockBackup, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	for _, name := range []string{MetaFile, IndexFile} {
+		if err := copyFile(filepath.Join(dir, name), filepath.Join(blockBackup, name)); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to back up %s: %w", name, err)
+		}
+	}
+
+	// Rewrite the index first: a block with a new index and old meta.json
+	// is still readable and will simply be migrated again.
+	if indexErr != nil || indexVersion < index.IndexFormatVersion {
+		var buf bytes.Buffer
+		if _, err := idx.WriteTo(&buf); err != nil {
+			return nil, fmt.Errorf("failed to encode index: %w", err)
+		}
+		if err := writeFileAtomic(indexPath, buf.Bytes()); err != nil {
+			return nil, fmt.Errorf("failed to write index: %w", err)
+		}
+	}
+
+	meta.Version = BlockVersion
+	meta.IndexVersion = index.IndexFormatVersion
+	metaData, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, MetaFile), metaData); err != nil {
+		return nil, fmt.Errorf("failed to write metadata: %w", err)
+	}
+
+	return result, nil
+}
+
+// MigrateDataDir upgrades every block in the data directory to the current
+// format. The database must not be running. Blocks are processed in ULID
+// order; the first error stops the migration, leaving already migrated
+// blocks upgraded and backed up.
+func MigrateDataDir(dataDir string, opts MigrateOptions) ([]MigrationResult, error) {
+	if !opts.DryRun && opts.BackupDir == "" {
+		return nil, fmt.Errorf("backup directory required")
+	}
+
+	dirs, err := blockDirs(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]MigrationResult, 0, len(dirs))
+	for _, dir := range dirs {
+		result, err := migrateBlock(dir, opts.BackupDir, opts.DryRun)
+		if err != nil {
+			return results, fmt.Errorf("failed to migrate block %s: %w", filepath.Base(dir), err)
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
+
+// blockDirs returns the block directories in dataDirs, sorted by ULID.
+// Empty paths are skipped. A block found in several directories is
+// returned from the last one: blocks are copied to the cold directory
+// before being removed from the data directory.
+func blockDirs(dataDirs ...string) ([]string, error) {
+	byULID := make(map[string]string)
+	for _, dataDir := range dataDirs {
+		if dataDir == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dataDir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read data directory: %w", err)
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			if _, err := ulid.Parse(entry.Name()); err != nil {
+				continue // Skip non-ULID directories
+			}
+			byULID[entry.Name()] = filepath.Join(dataDir, entry.Name())
+		}
+	}
+
+	var dirs []string
+	for _, id := range sortedKeys(byULID) {
+		dirs = append(dirs, byULID[id])
+	}
+	return dirs, nil
+}
+
+// writeFileAtomic writes data to a temporary file, syncs it and renames it over path
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// syncDir syncs a directory, making entries created or renamed in it durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// copyFile copies src to dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
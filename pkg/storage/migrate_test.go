@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// writeLegacyBlock persists a block and rewrites it in the version 1 layout:
+// meta.json without indexVersion and a v1 index file.
+func writeLegacyBlock(t *testing.T, dataDir string) (*Block, *series.Series) {
+	t.Helper()
+
+	block, err := NewBlock(1000, 10000)
+	if err != nil {
+		t.Fatalf("NewBlock failed: %v", err)
+	}
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "server1"})
+	if err := block.AddSeries(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("AddSeries failed: %v", err)
+	}
+	if err := block.Persist(dataDir); err != nil {
+		t.Fatalf("Persist failed: %v", err)
+	}
+	dir := block.Dir()
+
+	// v1 index: label names inline, each value followed by its bitmap
+	v1 := new(bytes.Buffer)
+	binary.Write(v1, binary.LittleEndian, index.IndexMagic)
+	binary.Write(v1, binary.LittleEndian, index.IndexFormatV1)
+	binary.Write(v1, binary.LittleEndian, uint64(1))
+	binary.Write(v1, binary.LittleEndian, uint32(len(s.Labels)))
+	for _, name := range []string{"__name__", "host"} {
+		binary.Write(v1, binary.LittleEndian, uint32(len(name)))
+		v1.WriteString(name)
+		binary.Write(v1, binary.LittleEndian, uint32(1))
+		value := s.Labels[name]
+		binary.Write(v1, binary.LittleEndian, uint32(len(value)))
+		v1.WriteString(value)
+		bitmap, _ := roaring.BitmapOf(1).ToBytes()
+		binary.Write(v1, binary.LittleEndian, uint32(len(bitmap)))
+		v1.Write(bitmap)
+	}
+	if err := os.WriteFile(filepath.Join(dir, IndexFile), v1.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write v1 index: %v", err)
+	}
+
+	setMetaVersion(t, dir, 1, 0)
+	return block, s
+}
+
+func setMetaVersion(t *testing.T, dir string, version int, indexVersion uint32) {
+	t.Helper()
+
+	meta, err := readBlockMeta(dir)
+	if err != nil {
+		t.Fatalf("readBlockMeta failed: %v", err)
+	}
+	meta.Version = version
+	meta.IndexVersion = indexVersion
+	data, _ := json.Marshal(meta)
+	if err := os.WriteFile(filepath.Join(dir, MetaFile), data, 0644); err != nil {
+		t.Fatalf("failed to write meta: %v", err)
+	}
+}
+
+func TestMigrateBlock(t *testing.T) {
+	dataDir := t.TempDir()
+	backupDir := t.TempDir()
+	block, s := writeLegacyBlock(t, dataDir)
+	dir := block.Dir()
+
+	needs, err := BlockNeedsMigration(dir)
+	if err != nil {
+		t.Fatalf("BlockNeedsMigration failed: %v", err)
+	}
+	if !needs {
+		t.Fatal("legacy block should need migration")
+	}
+
+	result, err := MigrateBlock(dir, backupDir)
+	if err != nil {
+		t.Fatalf("MigrateBlock failed: %v", err)
+	}
+	if !result.Migrated || result.FromVersion != 1 || result.IndexVersion != index.IndexFormatV1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	// Originals are backed up
+	for _, name := range []string{MetaFile, IndexFile} {
+		if _, err := os.Stat(filepath.Join(backupDir, block.ULID.String(), name)); err != nil {
+			t.Errorf("backup of %s missing: %v", name, err)
+		}
+	}
+
+	// The upgraded block opens with a memory-mapped index and finds the series
+	opened, err := OpenBlock(dir)
+	if err != nil {
+		t.Fatalf("OpenBlock failed: %v", err)
+	}
+	idx, err := opened.Index()
+	if err != nil {
+		t.Fatalf("Index failed: %v", err)
+	}
+	if _, ok := idx.(*index.MappedIndex); !ok {
+		t.Errorf("Index() = %T, want *index.MappedIndex", idx)
+	}
+	hashes, err := opened.LookupSeries(index.Matchers{index.MustNewMatcher(index.MatchEqual, "host", "server1")})
+	if err != nil {
+		t.Fatalf("LookupSeries failed: %v", err)
+	}
+	if len(hashes) != 1 || hashes[0] != s.Hash {
+		t.Errorf("LookupSeries = %v, want [%d]", hashes, s.Hash)
+	}
+	opened.Close()
+
+	// Migrating again is a no-op
+	if needs, _ := BlockNeedsMigration(dir); needs {
+		t.Error("migrated block should not need migration")
+	}
+	result, err = MigrateBlock(dir, backupDir)
+	if err != nil {
+		t.Fatalf("second MigrateBlock failed: %v", err)
+	}
+	if result.Migrated {
+		t.Error("second migration should not rewrite the block")
+	}
+}
+
+func TestMigrateDataDirPlaceholderIndex(t *testing.T) {
+	dataDir := t.TempDir()
+	block, _ := writeLegacyBlock(t, dataDir)
+
+	// The earliest blocks were written with an empty index file
+	if err := os.WriteFile(filepath.Join(block.Dir(), IndexFile), nil, 0644); err != nil {
+		t.Fatalf("failed to truncate index: %v", err)
+	}
+
+	results, err := MigrateDataDir(dataDir, MigrateOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if len(results) != 1 || !results[0].Migrated || results[0].Note == "" {
+		t.Fatalf("unexpected dry-run results: %+v", results)
+	}
+	if needs, _ := BlockNeedsMigration(block.Dir()); !needs {
+		t.Fatal("dry run must not modify the block")
+	}
+
+	if _, err := MigrateDataDir(dataDir, MigrateOptions{}); err == nil {
+		t.Error("expected error without a backup directory")
+	}
+
+	if _, err := MigrateDataDir(dataDir, MigrateOptions{BackupDir: t.TempDir()}); err != nil {
+		t.Fatalf("MigrateDataDir failed: %v", err)
+	}
+	opened, err := OpenBlock(block.Dir())
+	if err != nil {
+		t.Fatalf("OpenBlock failed: %v", err)
+	}
+	if _, err := opened.Index(); err != nil {
+		t.Errorf("Index after migration failed: %v", err)
+	}
+	opened.Close()
+}
+
+func TestNewerFormatRefused(t *testing.T) {
+	dataDir := t.TempDir()
+
+	block, err := NewBlock(1000, 10000)
+	if err != nil {
+		t.Fatalf("NewBlock failed: %v", err)
+	}
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	if err := block.AddSeries(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("AddSeries failed: %v", err)
+	}
+	if err := block.Persist(dataDir); err != nil {
+		t.Fatalf("Persist failed: %v", err)
+	}
+
+	setMetaVersion(t, block.Dir(), BlockVersion+1, index.IndexFormatVersion)
+
+	if _, err := OpenBlock(block.Dir()); !errors.Is(err, ErrBlockVersionTooNew) {
+		t.Errorf("OpenBlock error = %v, want ErrBlockVersionTooNew", err)
+	}
+	if _, err := MigrateBlock(block.Dir(), t.TempDir()); !errors.Is(err, ErrBlockVersionTooNew) {
+		t.Errorf("MigrateBlock error = %v, want ErrBlockVersionTooNew", err)
+	}
+
+	opts := DefaultOptions(dataDir)
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	if db, err := Open(opts); !errors.Is(err, ErrBlockVersionTooNew) {
+		if db != nil {
+			db.Close()
+		}
+		t.Errorf("Open error = %v, want ErrBlockVersionTooNew", err)
+	}
+}
+
+func TestChunkEncodingTooNew(t *testing.T) {
+	chunk := NewChunk()
+	if err := chunk.Append([]series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	chunk.Encoding = MaxChunkEncoding + 1
+
+	data, err := chunk.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if err := NewChunk().UnmarshalBinary(data); !errors.Is(err, ErrChunkEncodingTooNew) {
+		t.Errorf("UnmarshalBinary error = %v, want ErrChunkEncodingTooNew", err)
+	}
+}
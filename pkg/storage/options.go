@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/wal"
+)
+
+// Validate checks the options for nonsensical values and fills in defaults
+// for those left at zero. It is called by Open; callers building options
+// from user input can call it first to report mistakes early.
+//
+// Negative sizes, counts and durations are rejected, as is a retention
+// period shorter than DefaultBlockDuration, which would delete blocks as
+// soon as they are written. Zero values fall back to the same defaults as
+// DefaultOptions, except where zero has a documented meaning of its own
+// (MaxMemTableSpan, MaxWALSize, the compaction caps, the background I/O
+// limits, MinFreeDiskBytes, BlockScanInterval, TrashGracePeriod and the
+// slow operation thresholds). A cold
+// directory needs compaction, which moves blocks there, and must differ
+// from the data directory.
+func (o *Options) Validate() error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidOptions, fmt.Sprintf(format, args...))
+	}
+
+	if o.DataDir == "" {
+		return invalid("data directory must be set")
+	}
+
+	if o.FlushInterval < 0 {
+		return invalid("flush interval %s cannot be negative", o.FlushInterval)
+	}
+	if o.FlushInterval == 0 {
+		o.FlushInterval = DefaultFlushInterval
+	}
+
+	if o.WALOptions == nil {
+		o.WALOptions = wal.DefaultOptions()
+	}
+	if o.WALOptions.SegmentSize <= 0 {
+		return invalid("WAL segment size %d must be positive", o.WALOptions.SegmentSize)
+	}
+
+	if o.MemTableSize < 0 {
+		return invalid("memtable size %d cannot be negative", o.MemTableSize)
+	}
+	if o.MemTableSize == 0 {
+		o.MemTableSize = DefaultMaxSize
+	}
+	if o.MemTableShards < 0 {
+		return invalid("memtable shard count %d cannot be negative", o.MemTableShards)
+	}
+	if o.MemTableShards == 0 {
+		o.MemTableShards = DefaultMemTableShards
+	}
+
+	if o.CompactionInterval < 0 {
+		return invalid("compaction interval %s cannot be negative", o.CompactionInterval)
+	}
+	if o.CompactionInterval == 0 {
+		o.CompactionInterval = DefaultCompactionInterval
+	}
+	if o.CompactionMaxBlockBytes < 0 || o.CompactionMaxBlockSeries < 0 {
+		return invalid("compaction block caps cannot be negative")
+	}
+	if o.CompactionLevels == nil {
+		o.CompactionLevels = DefaultCompactionLevels()
+	}
+	if err := validateCompactionLevels(o.CompactionLevels); err != nil {
+		return invalid("%v", err)
+	}
+	if o.BackgroundIOBytesPerSecond < 0 {
+		return invalid("background I/O rate %d cannot be negative", o.BackgroundIOBytesPerSecond)
+	}
+	if o.BackgroundIOPauseLatency < 0 {
+		return invalid("background I/O pause latency %s cannot be negative", o.BackgroundIOPauseLatency)
+	}
+
+	if o.RetentionPeriod < 0 {
+		return invalid("retention period %s cannot be negative", o.RetentionPeriod)
+	}
+	if o.RetentionPeriod == 0 {
+		o.RetentionPeriod = DefaultRetentionPeriod
+	}
+	if o.EnableRetention && o.RetentionPeriod < DefaultBlockDuration {
+		return invalid("retention period %s is shorter than the block duration %s",
+			o.RetentionPeriod, DefaultBlockDuration)
+	}
+	for _, rule := range o.BlockRetention {
+		if rule.MaxAge < 0 {
+			return invalid("block retention %s for %s cannot be negative", rule.MaxAge, rule.Matchers)
+		}
+	}
+	for _, rule := range o.PrecisionRules {
+		if err := rule.validate(); err != nil {
+			return invalid("%v", err)
+		}
+	}
+	for _, budget := range o.RetentionBudgets {
+		if err := budget.validate(); err != nil {
+			return invalid("%v", err)
+		}
+	}
+	if o.RejectBeforeRetention && !o.EnableRetention {
+		return invalid("rejecting samples before the retention horizon requires retention")
+	}
+	if o.MaxFutureSkew < 0 {
+		return invalid("max future skew %s cannot be negative", o.MaxFutureSkew)
+	}
+
+	for i, c := range o.Relabel {
+		if err := c.Validate(); err != nil {
+			return invalid("relabel rule %d: %v", i+1, err)
+		}
+	}
+
+	if o.Validation == nil {
+		o.Validation = DefaultValidationOptions()
+	}
+	if v := o.Validation; v.MaxLabelNamesPerSeries < 0 || v.MaxLabelNameLength < 0 ||
+		v.MaxLabelValueLength < 0 || v.MaxLabelsSize < 0 {
+		return invalid("label validation limits cannot be negative")
+	}
+	if o.VerifyChunkSamples == 0 {
+		o.VerifyChunkSamples = DefaultVerifyChunkSamples
+	}
+
+	if err := o.SamplePolicy.Validate(); err != nil {
+		return invalid("sample policy: %v", err)
+	}
+	if err := o.SeriesRateLimit.Validate(); err != nil {
+		return invalid("%v", err)
+	}
+	if o.SeriesRateLimit.Action == RateLimitAggregate && o.SamplePolicy.Duplicates == DuplicateReject {
+		return invalid("aggregating samples over the series rate limit needs a duplicate policy other than reject")
+	}
+	if err := o.LabelPostingsLimit.Validate(); err != nil {
+		return invalid("%v", err)
+	}
+
+	if o.MaxMemTableSpan < 0 {
+		return invalid("max memtable span %s cannot be negative", o.MaxMemTableSpan)
+	}
+	if o.MaxWALSize < 0 {
+		return invalid("max WAL size %d cannot be negative", o.MaxWALSize)
+	}
+
+	if o.IngestStatsCapacity < 0 {
+		return invalid("ingest statistics capacity %d cannot be negative", o.IngestStatsCapacity)
+	}
+	if o.MinFreeDiskBytes < 0 {
+		return invalid("minimum free disk space %d cannot be negative", o.MinFreeDiskBytes)
+	}
+	if o.DiskCheckInterval < 0 {
+		return invalid("disk check interval %s cannot be negative", o.DiskCheckInterval)
+	}
+	if o.DiskCheckInterval == 0 {
+		o.DiskCheckInterval = DefaultDiskCheckInterval
+	}
+	if o.EmergencyRetention && !o.EnableCompaction {
+		return invalid("emergency retention requires compaction")
+	}
+	if o.BlockScanInterval < 0 {
+		return invalid("block scan interval %s cannot be negative", o.BlockScanInterval)
+	}
+
+	if o.TrashGracePeriod < 0 {
+		return invalid("trash grace period %s cannot be negative", o.TrashGracePeriod)
+	}
+
+	if o.ColdAfter < 0 {
+		return invalid("cold storage age %s cannot be negative", o.ColdAfter)
+	}
+	if o.ColdAfter == 0 {
+		o.ColdAfter = DefaultColdAfter
+	}
+	if o.ColdDir != "" && !o.EnableCompaction {
+		return invalid("cold directory requires compaction")
+	}
+	if o.ColdDir != "" && filepath.Clean(o.ColdDir) == filepath.Clean(o.DataDir) {
+		return invalid("cold directory %s is the data directory", o.ColdDir)
+	}
+
+	if o.SlowFlushThreshold < 0 || o.SlowWALSyncThreshold < 0 || o.SlowCompactionThreshold < 0 {
+		return invalid("slow operation thresholds cannot be negative")
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOptionsValidateRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Options)
+	}{
+		{"no data dir", func(o *Options) { o.DataDir = "" }},
+		{"negative flush interval", func(o *Options) { o.FlushInterval = -time.Second }},
+		{"negative memtable size", func(o *Options) { o.MemTableSize = -1 }},
+		{"negative shards", func(o *Options) { o.MemTableShards = -1 }},
+		{"negative compaction interval", func(o *Options) { o.CompactionInterval = -time.Minute }},
+		{"negative block cap", func(o *Options) { o.CompactionMaxBlockSeries = -1 }},
+		{"retention below block duration", func(o *Options) { o.RetentionPeriod = time.Hour }},
+		{"negative block retention", func(o *Options) {
+			o.BlockRetention = []BlockRetentionRule{{MaxAge: -time.Hour}}
+		}},
+		{"retention budget without limit", func(o *Options) {
+			o.RetentionBudgets = []RetentionBudget{{}}
+		}},
+		{"bad sample policy", func(o *Options) { o.SamplePolicy.OutOfOrderWindow = -time.Second }},
+		{"bad rate limit action", func(o *Options) {
+			o.SeriesRateLimit = SeriesRateLimit{MaxSamples: 10, Action: "sample"}
+		}},
+		{"aggregate with reject duplicates", func(o *Options) {
+			o.SeriesRateLimit = SeriesRateLimit{MaxSamples: 10, Action: RateLimitAggregate}
+			o.SamplePolicy.Duplicates = DuplicateReject
+		}},
+		{"bounds before retention without retention", func(o *Options) {
+			o.EnableRetention = false
+			o.RejectBeforeRetention = true
+		}},
+		{"negative future skew", func(o *Options) { o.MaxFutureSkew = -time.Minute }},
+		{"invalid relabel rule", func(o *Options) {
+			o.Relabel = []RelabelConfig{{SourceLabels: []string{"job"}, Action: "labelmap"}}
+		}},
+		{"negative max wal size", func(o *Options) { o.MaxWALSize = -1 }},
+		{"negative min free disk", func(o *Options) { o.MinFreeDiskBytes = -1 }},
+		{"negative slow threshold", func(o *Options) { o.SlowWALSyncThreshold = -time.Millisecond }},
+		{"emergency retention without compaction", func(o *Options) {
+			o.EnableCompaction = false
+			o.EmergencyRetention = true
+		}},
+		{"cold dir without compaction", func(o *Options) {
+			o.EnableCompaction = false
+			o.ColdDir = o.DataDir + "-cold"
+		}},
+		{"cold dir is data dir", func(o *Options) { o.ColdDir = o.DataDir + "/" }},
+		{"negative cold after", func(o *Options) { o.ColdAfter = -time.Hour }},
+		{"negative trash grace period", func(o *Options) { o.TrashGracePeriod = -time.Hour }},
+		{"compaction levels not growing", func(o *Options) {
+			o.CompactionLevels = []CompactionLevelConfig{{Duration: 12 * time.Hour}, {Duration: 6 * time.Hour}}
+		}},
+		{"compaction level within level 0", func(o *Options) {
+			o.CompactionLevels = []CompactionLevelConfig{{Duration: time.Hour}}
+		}},
+		{"compaction level merging one block", func(o *Options) {
+			o.CompactionLevels = []CompactionLevelConfig{{Duration: 12 * time.Hour, MinBlocks: 1}}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions(t.TempDir())
+			tt.modify(opts)
+			if err := opts.Validate(); !errors.Is(err, ErrInvalidOptions) {
+				t.Errorf("expected ErrInvalidOptions, got %v", err)
+			}
+		})
+	}
+
+	// Short retention is fine while retention is disabled
+	opts := DefaultOptions(t.TempDir())
+	opts.EnableRetention = false
+	opts.RetentionPeriod = time.Hour
+	if err := opts.Validate(); err != nil {
+		t.Errorf("unexpected error with retention disabled: %v", err)
+	}
+}
+
+func TestOptionsValidateDefaults(t *testing.T) {
+	opts := &Options{DataDir: t.TempDir()}
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	if opts.FlushInterval != DefaultFlushInterval ||
+		opts.MemTableSize != DefaultMaxSize ||
+		opts.MemTableShards != DefaultMemTableShards ||
+		opts.CompactionInterval != DefaultCompactionInterval ||
+		opts.RetentionPeriod != DefaultRetentionPeriod ||
+		opts.DiskCheckInterval != DefaultDiskCheckInterval ||
+		opts.VerifyChunkSamples != DefaultVerifyChunkSamples {
+		t.Errorf("zero options not defaulted: %+v", opts)
+	}
+	if opts.WALOptions == nil || opts.Validation == nil || len(opts.CompactionLevels) != 2 {
+		t.Error("expected WAL, validation and compaction level options to be defaulted")
+	}
+	// Zero keeps its meaning where it disables a feature
+	if opts.MaxWALSize != 0 || opts.MaxMemTableSpan != 0 || opts.MinFreeDiskBytes != 0 {
+		t.Errorf("disabling zero values were overwritten: %+v", opts)
+	}
+}
+
+func TestOpenRejectsInvalidOptions(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.FlushInterval = -time.Second
+
+	if _, err := Open(opts); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions, got %v", err)
+	}
+
+	// Open does not normalize the caller's options
+	opts = &Options{DataDir: t.TempDir()}
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+	if opts.FlushInterval != 0 {
+		t.Error("Open modified the caller's options")
+	}
+}
@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// OverlappingFile is the marker file of a block that overlaps other blocks
+// in time, e.g. one restored from a backup. Compaction merges a marked
+// block with every block it overlaps, leaving a single block for the range.
+const OverlappingFile = "overlapping"
+
+// CompactionPlan describes the merges a compaction cycle performs
+type CompactionPlan struct {
+	Merges []PlannedMerge
+}
+
+// PlannedMerge merges a group of source blocks into one or more output
+// blocks covering the same time range. The output is split when a single
+// block would exceed the compactor's series or size caps.
+type PlannedMerge struct {
+	FromLevel    CompactionLevel
+	ToLevel      CompactionLevel
+	Vertical     bool // Merges overlapping blocks; levels are unset
+	MinTime      int64
+	MaxTime      int64
+	Sources      []string       // Source block ULIDs, oldest first
+	SourceBlocks []BlockSummary // Source blocks, in the order of Sources
+	Outputs      []PlannedBlock
+
+	blocks []*Block
+}
+
+// BlockSummary identifies a block with its time range, size and labels
+type BlockSummary struct {
+	ULID    string
+	MinTime int64
+	MaxTime int64
+	Size    int64
+	Labels  map[string]string
+}
+
+// summarizeBlock returns the summary of block
+func summarizeBlock(block *Block) BlockSummary {
+	return BlockSummary{
+		ULID:    block.ULID.String(),
+		MinTime: block.MinTime,
+		MaxTime: block.MaxTime,
+		Size:    block.Size(),
+		Labels:  block.Labels,
+	}
+}
+
+// outputLevel returns the compaction level of the merge's outputs: its
+// target level, or for vertical merges the highest level among the sources
+func (m PlannedMerge) outputLevel() CompactionLevel {
+	if !m.Vertical && m.ToLevel > m.FromLevel {
+		return m.ToLevel
+	}
+	level := Level0
+	for _, block := range m.blocks {
+		level = max(level, block.Level)
+	}
+	return level
+}
+
+// PlannedBlock is one output block of a merge
+type PlannedBlock struct {
+	NumSeries      int
+	EstimatedBytes int64 // Sum of the source chunk sizes of its series
+
+	series []uint64
+}
+
+// Plan returns the merges the next compaction cycle would perform, without
+// changing anything on disk
+func (c *Compactor) Plan() (*CompactionPlan, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.blockReader.LoadBlocks(); err != nil {
+		return nil, err
+	}
+	return c.plan(c.blockReader.Blocks()), nil
+}
+
+// plan builds the compaction plan for the given blocks. Protected blocks
+// are left alone.
+func (c *Compactor) plan(blocks []*Block) *CompactionPlan {
+	plan := &CompactionPlan{}
+	blocks = unprotectedBlocks(blocks)
+
+	// Overlaps are resolved first; their blocks are gone after the merge
+	vertical, blocks := c.planVertical(blocks)
+	plan.Merges = append(plan.Merges, vertical...)
+
+	// Blocks of the top level are not merged further
+	for level := Level0; int(level) < len(c.levels); level++ {
+		fromBlocks := c.getBlocksByLevel(blocks, level)
+		plan.Merges = append(plan.Merges, c.planLevel(fromBlocks, level, level+1)...)
+	}
+	return plan
+}
+
+// planLevel plans the merges of blocks from one level to the next
+func (c *Compactor) planLevel(blocks []*Block, fromLevel, toLevel CompactionLevel) []PlannedMerge {
+	var merges []PlannedMerge
+	for _, group := range c.groupBlocksByTimeWindow(blocks, c.getLevelDuration(toLevel)) {
+		if len(group) < c.minBlocks(toLevel) {
+			continue // Not enough blocks in the window yet
+		}
+
+		merge := c.planMerge(group)
+		merge.FromLevel = fromLevel
+		merge.ToLevel = toLevel
+		merges = append(merges, merge)
+	}
+	return merges
+}
+
+// planVertical plans merging each block marked as overlapping with the
+// blocks it overlaps, directly or through other overlapping blocks. It
+// returns the merges and the blocks not part of any.
+func (c *Compactor) planVertical(blocks []*Block) ([]PlannedMerge, []*Block) {
+	sorted := make([]*Block, len(blocks))
+	copy(sorted, blocks)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].MinTime < sorted[j].MinTime
+	})
+
+	var merges []PlannedMerge
+	var rest []*Block
+	for i := 0; i < len(sorted); {
+		// Extend the group while the next block starts before it ends
+		group := []*Block{sorted[i]}
+		maxTime := sorted[i].MaxTime
+		marked := sorted[i].Overlapping()
+		for i++; i < len(sorted) && sorted[i].MinTime <= maxTime; i++ {
+			group = append(group, sorted[i])
+			maxTime = max(maxTime, sorted[i].MaxTime)
+			marked = marked || sorted[i].Overlapping()
+		}
+
+		if len(group) < 2 || !marked {
+			rest = append(rest, group...)
+			continue
+		}
+		merge := c.planMerge(group)
+		merge.Vertical = true
+		merges = append(merges, merge)
+	}
+	return merges, rest
+}
+
+// Overlapping reports whether the block is marked as overlapping others
+func (b *Block) Overlapping() bool {
+	dir := b.Dir()
+	if dir == "" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(dir, OverlappingFile))
+	return err == nil
+}
+
+// planMerge plans merging blocks, assigning series to output blocks in hash
+// order and starting a new output whenever the next series would push the
+// current one over MaxBlockSeries or MaxBlockBytes. A single series larger
+// than MaxBlockBytes gets an output of its own.
+func (c *Compactor) planMerge(blocks []*Block) PlannedMerge {
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].MinTime < blocks[j].MinTime
+	})
+
+	merge := PlannedMerge{
+		MinTime: blocks[0].MinTime,
+		MaxTime: blocks[len(blocks)-1].MaxTime,
+		blocks:  blocks,
+	}
+	for _, block := range blocks {
+		merge.MaxTime = max(merge.MaxTime, block.MaxTime) // Blocks may overlap
+	}
+
+	sizes := make(map[uint64]int64)
+	for _, block := range blocks {
+		merge.Sources = append(merge.Sources, block.ULID.String())
+		merge.SourceBlocks = append(merge.SourceBlocks, summarizeBlock(block))
+		for hash, size := range block.seriesSizes() {
+			sizes[hash] += size
+		}
+	}
+
+	hashes := make([]uint64, 0, len(sizes))
+	for hash := range sizes {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	var current PlannedBlock
+	for _, hash := range hashes {
+		size := sizes[hash]
+		if current.NumSeries > 0 && c.exceedsBlockCaps(current, size) {
+			merge.Outputs = append(merge.Outputs, current)
+			current = PlannedBlock{}
+		}
+		current.series = append(current.series, hash)
+		current.NumSeries++
+		current.EstimatedBytes += size
+	}
+	if current.NumSeries > 0 {
+		merge.Outputs = append(merge.Outputs, current)
+	}
+
+	return merge
+}
+
+// exceedsBlockCaps reports whether adding a series of the given size to the
+// output block would exceed the configured caps
+func (c *Compactor) exceedsBlockCaps(output PlannedBlock, size int64) bool {
+	if c.maxBlockSeries > 0 && output.NumSeries+1 > c.maxBlockSeries {
+		return true
+	}
+	if c.maxBlockBytes > 0 && output.EstimatedBytes+size > c.maxBlockBytes {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// writeLevel0Blocks persists numBlocks consecutive Level 0 blocks, each with
+// the same numSeries series
+func writeLevel0Blocks(t *testing.T, dataDir string, numBlocks, numSeries int) {
+	t.Helper()
+
+	baseTime := time.Now().Add(-24 * time.Hour).UnixMilli()
+	for i := 0; i < numBlocks; i++ {
+		minTime := baseTime + int64(i)*Level0Duration.Milliseconds()
+		block, err := NewBlock(minTime, minTime+Level0Duration.Milliseconds())
+		if err != nil {
+			t.Fatalf("NewBlock failed: %v", err)
+		}
+		for j := 0; j < numSeries; j++ {
+			s := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": fmt.Sprintf("server%d", j)})
+			samples := []series.Sample{
+				{Timestamp: minTime + 1000, Value: float64(i)},
+				{Timestamp: minTime + 2000, Value: float64(j)},
+			}
+			if err := block.AddSeries(s, samples); err != nil {
+				t.Fatalf("AddSeries failed: %v", err)
+			}
+		}
+		if err := block.Persist(dataDir); err != nil {
+			t.Fatalf("Persist failed: %v", err)
+		}
+	}
+}
+
+func TestCompactorPlanSplitsBySeriesCap(t *testing.T) {
+	dataDir := t.TempDir()
+	writeLevel0Blocks(t, dataDir, 3, 5)
+
+	opts := DefaultCompactorOptions(dataDir)
+	opts.MaxBlockSeries = 2
+	compactor := NewCompactor(opts)
+	defer compactor.Stop()
+
+	plan, err := compactor.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if len(plan.Merges) != 1 {
+		t.Fatalf("expected 1 merge, got %d", len(plan.Merges))
+	}
+
+	merge := plan.Merges[0]
+	if merge.FromLevel != Level0 || merge.ToLevel != Level1 || len(merge.Sources) != 3 {
+		t.Errorf("unexpected merge: %+v", merge)
+	}
+	for i, source := range merge.SourceBlocks {
+		if source.ULID != merge.Sources[i] || source.MaxTime <= source.MinTime || source.Size <= 0 {
+			t.Errorf("unexpected source block %d: %+v", i, source)
+		}
+	}
+	var outputSeries []int
+	for _, output := range merge.Outputs {
+		outputSeries = append(outputSeries, output.NumSeries)
+		if output.EstimatedBytes <= 0 {
+			t.Errorf("output has no estimated size: %+v", output)
+		}
+	}
+	if fmt.Sprint(outputSeries) != "[2 2 1]" {
+		t.Errorf("output series = %v, want [2 2 1]", outputSeries)
+	}
+
+	// Planning is a dry run
+	dirs, _ := blockDirs(dataDir)
+	if len(dirs) != 3 {
+		t.Fatalf("Plan changed the data directory: %d blocks", len(dirs))
+	}
+
+	if err := compactor.compact(); err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+
+	dirs, _ = blockDirs(dataDir)
+	if len(dirs) != 3 {
+		t.Fatalf("expected 3 merged blocks, got %d", len(dirs))
+	}
+
+	var totalSeries, totalSamples int64
+	for _, dir := range dirs {
+		block, err := OpenBlock(dir)
+		if err != nil {
+			t.Fatalf("OpenBlock failed: %v", err)
+		}
+		if block.NumSeries > 2 {
+			t.Errorf("block %s has %d series, cap is 2", block.ULID, block.NumSeries)
+		}
+		totalSeries += block.NumSeries
+		totalSamples += block.NumSamples
+	}
+	if totalSeries != 5 || totalSamples != 30 {
+		t.Errorf("merged blocks hold %d series and %d samples, want 5 and 30", totalSeries, totalSamples)
+	}
+}
+
+func TestCompactorPlanSplitsByBytesCap(t *testing.T) {
+	dataDir := t.TempDir()
+	writeLevel0Blocks(t, dataDir, 3, 4)
+
+	opts := DefaultCompactorOptions(dataDir)
+	opts.MaxBlockBytes = 1 // Smaller than any series
+	compactor := NewCompactor(opts)
+	defer compactor.Stop()
+
+	plan, err := compactor.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if len(plan.Merges) != 1 {
+		t.Fatalf("expected 1 merge, got %d", len(plan.Merges))
+	}
+	if got := len(plan.Merges[0].Outputs); got != 4 {
+		t.Errorf("expected one output per series, got %d outputs", got)
+	}
+}
+
+func TestCompactorLevelSchedule(t *testing.T) {
+	dataDir := t.TempDir()
+	writeLevel0Blocks(t, dataDir, 4, 1)
+
+	// 4-hour level 1 blocks from pairs of level 0 blocks, then an 8-hour
+	// top level
+	opts := DefaultCompactorOptions(dataDir)
+	opts.Levels = []CompactionLevelConfig{
+		{Duration: 4 * time.Hour, MinBlocks: 2},
+		{Duration: 8 * time.Hour, MinBlocks: 2},
+	}
+	compactor := NewCompactor(opts)
+	defer compactor.Stop()
+
+	plan, err := compactor.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if len(plan.Merges) != 2 {
+		t.Fatalf("expected 2 merges of 2 blocks, got %+v", plan.Merges)
+	}
+	for _, merge := range plan.Merges {
+		if merge.FromLevel != Level0 || merge.ToLevel != Level1 || len(merge.Sources) != 2 {
+			t.Errorf("unexpected merge: %+v", merge)
+		}
+	}
+
+	for _, want := range [][3]int{{0, 2, 0}, {0, 0, 1}, {0, 0, 1}} {
+		if err := compactor.compact(); err != nil {
+			t.Fatalf("compact failed: %v", err)
+		}
+		level0, level1, level2, err := compactor.BlockCount()
+		if err != nil {
+			t.Fatalf("BlockCount failed: %v", err)
+		}
+		if got := [3]int{level0, level1, level2}; got != want {
+			t.Errorf("blocks per level = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestBlockReaderLoadBlocksIdempotent(t *testing.T) {
+	dataDir := t.TempDir()
+	writeLevel0Blocks(t, dataDir, 3, 1)
+
+	reader := NewBlockReader(dataDir)
+	for i := 0; i < 2; i++ {
+		if err := reader.LoadBlocks(); err != nil {
+			t.Fatalf("LoadBlocks failed: %v", err)
+		}
+	}
+	blocks := reader.Blocks()
+	if len(blocks) != 3 {
+		t.Fatalf("expected 3 blocks after reloading, got %d", len(blocks))
+	}
+
+	if err := blocks[0].Delete(); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := reader.LoadBlocks(); err != nil {
+		t.Fatalf("LoadBlocks failed: %v", err)
+	}
+	if got := len(reader.Blocks()); got != 2 {
+		t.Errorf("expected deleted block to be dropped, got %d blocks", got)
+	}
+}
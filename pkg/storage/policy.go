@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+)
+
+var (
+	// ErrDuplicateSample indicates a sample for a timestamp its series
+	// already has, rejected by the DuplicateReject policy
+	ErrDuplicateSample = tsdberrors.New(tsdberrors.Conflict, "duplicate sample for timestamp")
+
+	// ErrOutOfOrderSample indicates a sample older than the out-of-order
+	// window allows
+	ErrOutOfOrderSample = tsdberrors.New(tsdberrors.Conflict, "sample too far out of order")
+)
+
+// DuplicatePolicy decides what happens to a sample whose series already
+// has a sample at the same timestamp
+type DuplicatePolicy string
+
+const (
+	// DuplicateOverwrite replaces the stored value (last write wins)
+	DuplicateOverwrite DuplicatePolicy = "overwrite"
+
+	// DuplicateKeepFirst keeps the stored value and silently drops the new sample
+	DuplicateKeepFirst DuplicatePolicy = "keep-first"
+
+	// DuplicateReject fails the write with ErrDuplicateSample
+	DuplicateReject DuplicatePolicy = "reject"
+)
+
+// ParseDuplicatePolicy parses a duplicate policy name
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch p := DuplicatePolicy(s); p {
+	case DuplicateOverwrite, DuplicateKeepFirst, DuplicateReject:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown duplicate policy %q (want overwrite, keep-first or reject)", s)
+	}
+}
+
+// SamplePolicy controls how ingestion treats duplicate and out-of-order
+// samples. A sample is out of order when its series already has a newer
+// sample in the head (the MemTable); blocks are not consulted, so the
+// policy applies to samples within the current MemTable's lifetime.
+type SamplePolicy struct {
+	// Duplicates is the policy for repeated timestamps; empty means
+	// DuplicateOverwrite
+	Duplicates DuplicatePolicy
+
+	// OutOfOrderWindow is how far behind its series' newest sample a
+	// sample may be. Older samples fail with ErrOutOfOrderSample. 0 means
+	// no limit.
+	OutOfOrderWindow time.Duration
+}
+
+// duplicates returns the effective duplicate policy
+func (p SamplePolicy) duplicates() DuplicatePolicy {
+	if p.Duplicates == "" {
+		return DuplicateOverwrite
+	}
+	return p.Duplicates
+}
+
+// tooOld reports whether a sample at ts is outside the out-of-order window
+// of a series whose newest sample is at maxTime
+func (p SamplePolicy) tooOld(ts, maxTime int64) bool {
+	return p.OutOfOrderWindow > 0 && ts < maxTime-p.OutOfOrderWindow.Milliseconds()
+}
+
+// Validate checks the policy
+func (p SamplePolicy) Validate() error {
+	if p.Duplicates != "" {
+		if _, err := ParseDuplicatePolicy(string(p.Duplicates)); err != nil {
+			return err
+		}
+	}
+	if p.OutOfOrderWindow < 0 {
+		return fmt.Errorf("out-of-order window cannot be negative")
+	}
+	return nil
+}
+
+// sampleCounts tallies how the sample policy treated the samples of a write
+type sampleCounts struct {
+	duplicatesOverwritten int64
+	duplicatesDropped     int64
+	duplicatesRejected    int64
+	outOfOrder            int64 // accepted within the window
+	outOfOrderRejected    int64
+}
+
+func (c *sampleCounts) add(o sampleCounts) {
+	c.duplicatesOverwritten += o.duplicatesOverwritten
+	c.duplicatesDropped += o.duplicatesDropped
+	c.duplicatesRejected += o.duplicatesRejected
+	c.outOfOrder += o.outOfOrder
+	c.outOfOrderRejected += o.outOfOrderRejected
+}
+
+// record adds the counts to the TSDB statistics
+func (s *Stats) record(c sampleCounts) {
+	s.DuplicatesOverwritten.Add(c.duplicatesOverwritten)
+	s.DuplicatesDropped.Add(c.duplicatesDropped)
+	s.DuplicatesRejected.Add(c.duplicatesRejected)
+	s.OutOfOrderSamples.Add(c.outOfOrder)
+	s.OutOfOrderRejected.Add(c.outOfOrderRejected)
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestDuplicatePolicy(t *testing.T) {
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+
+	tests := []struct {
+		policy    DuplicatePolicy
+		wantErr   error
+		wantValue float64
+		counter   func(StatsSnapshot) int64
+	}{
+		{"", nil, 2, func(st StatsSnapshot) int64 { return st.DuplicatesOverwritten }},
+		{DuplicateOverwrite, nil, 2, func(st StatsSnapshot) int64 { return st.DuplicatesOverwritten }},
+		{DuplicateKeepFirst, nil, 1, func(st StatsSnapshot) int64 { return st.DuplicatesDropped }},
+		{DuplicateReject, ErrDuplicateSample, 1, func(st StatsSnapshot) int64 { return st.DuplicatesRejected }},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			db := openTestDB(t, func(opts *Options) { opts.SamplePolicy = SamplePolicy{Duplicates: tt.policy} })
+
+			if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+			err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 2}, {Timestamp: 2000, Value: 3}})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("duplicate insert: got %v, want %v", err, tt.wantErr)
+			}
+
+			samples, _ := db.Query(s.Hash, 1000, 1000)
+			if len(samples) != 1 || samples[0].Value != tt.wantValue {
+				t.Errorf("got %v, want a single sample with value %v", samples, tt.wantValue)
+			}
+
+			// A rejected write stores none of its samples
+			all, _ := db.Query(s.Hash, 0, 0)
+			wantAll := 2
+			if tt.wantErr != nil {
+				wantAll = 1
+			}
+			if len(all) != wantAll {
+				t.Errorf("got %d samples, want %d", len(all), wantAll)
+			}
+
+			if n := tt.counter(db.GetStatsSnapshot()); n != 1 {
+				t.Errorf("policy counter = %d, want 1", n)
+			}
+		})
+	}
+}
+
+func TestDuplicatePolicyWithinWrite(t *testing.T) {
+	db := openTestDB(t, func(opts *Options) { opts.SamplePolicy = SamplePolicy{Duplicates: DuplicateReject} })
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+
+	err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}, {Timestamp: 1000, Value: 3}})
+	if !errors.Is(err, ErrDuplicateSample) {
+		t.Fatalf("got %v, want ErrDuplicateSample", err)
+	}
+
+	// Appender commits are checked as a whole
+	app := db.Appender()
+	app.Append(map[string]string{"__name__": "other"}, 1000, 1)
+	app.Append(s.Labels, 1000, 1)
+	app.Append(s.Labels, 1000, 2)
+	err = app.Commit()
+	if !errors.Is(err, ErrDuplicateSample) {
+		t.Fatalf("Commit: got %v, want ErrDuplicateSample", err)
+	}
+	var se *SeriesError
+	if !errors.As(err, &se) || se.Series.Hash != s.Hash || se.Samples != 2 {
+		t.Errorf("Commit error %v doesn't identify the rejected series", err)
+	}
+	other := series.NewSeries(map[string]string{"__name__": "other"})
+	if samples, _ := db.Query(other.Hash, 0, 0); len(samples) != 0 {
+		t.Errorf("rejected commit wrote %v", samples)
+	}
+}
+
+func TestOutOfOrderWindow(t *testing.T) {
+	db := openTestDB(t, func(opts *Options) { opts.SamplePolicy = SamplePolicy{OutOfOrderWindow: 10 * time.Second} })
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+
+	if err := db.Insert(s, []series.Sample{{Timestamp: 100000, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.Insert(s, []series.Sample{{Timestamp: 95000, Value: 2}}); err != nil {
+		t.Fatalf("sample within the window rejected: %v", err)
+	}
+	if err := db.Insert(s, []series.Sample{{Timestamp: 80000, Value: 3}}); !errors.Is(err, ErrOutOfOrderSample) {
+		t.Fatalf("sample outside the window: got %v, want ErrOutOfOrderSample", err)
+	}
+
+	// Other series are unaffected
+	other := series.NewSeries(map[string]string{"__name__": "other"})
+	if err := db.Insert(other, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert new series: %v", err)
+	}
+
+	stats := db.GetStatsSnapshot()
+	if stats.OutOfOrderSamples != 1 || stats.OutOfOrderRejected != 1 {
+		t.Errorf("out-of-order counters = %d accepted, %d rejected; want 1, 1",
+			stats.OutOfOrderSamples, stats.OutOfOrderRejected)
+	}
+	if samples, _ := db.Query(s.Hash, 0, 0); len(samples) != 2 {
+		t.Errorf("got %d samples, want 2", len(samples))
+	}
+}
+
+func TestSamplePolicyValidate(t *testing.T) {
+	if _, err := ParseDuplicatePolicy("keep-first"); err != nil {
+		t.Errorf("ParseDuplicatePolicy(keep-first) failed: %v", err)
+	}
+	if _, err := ParseDuplicatePolicy("keep-last"); err == nil {
+		t.Error("ParseDuplicatePolicy accepted an unknown policy")
+	}
+
+	opts := DefaultOptions(t.TempDir())
+	opts.SamplePolicy = SamplePolicy{OutOfOrderWindow: -time.Second}
+	if _, err := Open(opts); err == nil {
+		t.Error("Open accepted a negative out-of-order window")
+	}
+}
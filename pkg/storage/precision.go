@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+)
+
+// MaxPrecisionDigits is the most significant digits a PrecisionRule may
+// keep; a float64 holds no more than 15 reliably
+const MaxPrecisionDigits = 15
+
+// PrecisionRule rounds the values of the series whose labels match
+// Matchers to Digits significant digits when compaction merges blocks whose
+// data is all older than MinAge. Fewer digits make consecutive values share
+// more bits, which XOR compression stores far more compactly, at the cost
+// of the precision dropped. The first matching rule applies to a series.
+type PrecisionRule struct {
+	Matchers index.Matchers
+	MinAge   time.Duration
+	Digits   int
+}
+
+// String formats the rule like the --reduce-precision flag, e.g.
+// {__name__=~"node_.*"}=30d:3
+func (r PrecisionRule) String() string {
+	return fmt.Sprintf("%s=%s:%d", r.Matchers, r.MinAge, r.Digits)
+}
+
+// validate checks that the rule keeps a usable number of digits
+func (r PrecisionRule) validate() error {
+	if r.MinAge < 0 {
+		return fmt.Errorf("precision rule %s: age cannot be negative", r)
+	}
+	if r.Digits < 1 || r.Digits > MaxPrecisionDigits {
+		return fmt.Errorf("precision rule %s: digits must be between 1 and %d", r, MaxPrecisionDigits)
+	}
+	return nil
+}
+
+// BlockPrecision records in a block's meta.json that the values of the
+// series matching Matchers were rounded to Digits significant digits, so
+// the loss of precision can be told from the data
+type BlockPrecision struct {
+	Matchers string `json:"matchers"`
+	Digits   int    `json:"digits"`
+}
+
+// precisionRule returns the index of the first of rules matching a
+// series' labels, or -1
+func precisionRule(rules []PrecisionRule, labels map[string]string) int {
+	for i, rule := range rules {
+		if matchBlockLabels(rule.Matchers, labels) {
+			return i
+		}
+	}
+	return -1
+}
+
+// coldPrecisionRules returns those of rules whose MinAge data ending at
+// maxTime (Unix milliseconds) has reached by now
+func coldPrecisionRules(rules []PrecisionRule, maxTime int64, now time.Time) []PrecisionRule {
+	var cold []PrecisionRule
+	for _, rule := range rules {
+		if maxTime <= now.Add(-rule.MinAge).UnixMilli() {
+			cold = append(cold, rule)
+		}
+	}
+	return cold
+}
+
+// mergedBlockPrecision returns the precision provenance of a block merged
+// from blocks, where applied[i] is set if rules[i] rounded its values:
+// every distinct entry of the sources followed by those of the rules applied
+func mergedBlockPrecision(blocks []*Block, rules []PrecisionRule, applied []bool) []BlockPrecision {
+	var merged []BlockPrecision
+	seen := make(map[BlockPrecision]bool)
+	add := func(p BlockPrecision) {
+		if !seen[p] {
+			seen[p] = true
+			merged = append(merged, p)
+		}
+	}
+	for _, block := range blocks {
+		for _, p := range block.Precision {
+			add(p)
+		}
+	}
+	for i, rule := range rules {
+		if applied[i] {
+			add(BlockPrecision{Matchers: rule.Matchers.String(), Digits: rule.Digits})
+		}
+	}
+	return merged
+}
+
+// roundSignificant rounds v to digits significant digits. Zero, NaN and
+// infinities, including the stale marker, are returned unchanged.
+func roundSignificant(v float64, digits int) float64 {
+	if v == 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return v
+	}
+	// The power of ten of the last digit kept
+	exp := int(math.Floor(math.Log10(math.Abs(v)))) - digits + 1
+	if exp >= 0 {
+		scale := math.Pow10(exp)
+		return math.Round(v/scale) * scale
+	}
+	scale := math.Pow10(-exp)
+	if math.IsInf(scale, 0) || math.IsInf(v*scale, 0) {
+		return v // Subnormal values have no digits to spare
+	}
+	return math.Round(v*scale) / scale
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestRoundSignificant(t *testing.T) {
+	tests := []struct {
+		v      float64
+		digits int
+		want   float64
+	}{
+		{123.456, 3, 123},
+		{123.556, 4, 123.6},
+		{-0.00123456, 2, -0.0012},
+		{98765, 2, 99000},
+		{9.99, 2, 10},
+		{0, 3, 0},
+		{1e300, 1, 1e300},
+		{5e-324, 3, 5e-324},
+		{math.Inf(-1), 3, math.Inf(-1)},
+	}
+	for _, tt := range tests {
+		if got := roundSignificant(tt.v, tt.digits); got != tt.want {
+			t.Errorf("roundSignificant(%g, %d) = %g, want %g", tt.v, tt.digits, got, tt.want)
+		}
+	}
+	if got := roundSignificant(math.NaN(), 3); !math.IsNaN(got) {
+		t.Errorf("roundSignificant(NaN) = %g, want NaN", got)
+	}
+}
+
+func TestPrecisionRuleValidate(t *testing.T) {
+	matchers, _ := index.ParseMatchers(`{__name__="cpu"}`)
+	tests := []struct {
+		rule    PrecisionRule
+		wantErr bool
+	}{
+		{PrecisionRule{Matchers: matchers, MinAge: 24 * time.Hour, Digits: 3}, false},
+		{PrecisionRule{Matchers: matchers, Digits: MaxPrecisionDigits}, false},
+		{PrecisionRule{Matchers: matchers, Digits: 0}, true},
+		{PrecisionRule{Matchers: matchers, Digits: MaxPrecisionDigits + 1}, true},
+		{PrecisionRule{Matchers: matchers, MinAge: -time.Hour, Digits: 3}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.rule.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("validate(%s) = %v, want error %v", tt.rule, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCompactorReducePrecision(t *testing.T) {
+	dataDir := t.TempDir()
+	cpu := series.NewSeries(map[string]string{"__name__": "cpu", "host": "a"})
+	mem := series.NewSeries(map[string]string{"__name__": "mem", "host": "a"})
+
+	// Blocks ending a day ago, then blocks ending now
+	now := time.Now()
+	writeBlocks := func(end time.Time, rounded BlockPrecision) []*Block {
+		var blocks []*Block
+		for i := range 3 {
+			minTime := end.Add(-time.Duration(3-i) * Level0Duration).UnixMilli()
+			block, _ := NewBlock(minTime, minTime+Level0Duration.Milliseconds()-1)
+			block.AddSeries(cpu, []series.Sample{{Timestamp: minTime, Value: 1.23456}})
+			block.AddSeries(mem, []series.Sample{{Timestamp: minTime, Value: 1.23456}})
+			if i == 0 && rounded.Digits > 0 {
+				block.Precision = []BlockPrecision{rounded}
+			}
+			if err := block.Persist(dataDir); err != nil {
+				t.Fatalf("failed to persist block: %v", err)
+			}
+			blocks = append(blocks, block)
+		}
+		return blocks
+	}
+
+	matchers, _ := index.ParseMatchers(`{__name__="cpu"}`)
+	rules := []PrecisionRule{{Matchers: matchers, MinAge: 12 * time.Hour, Digits: 3}}
+	opts := DefaultCompactorOptions(dataDir)
+	opts.PrecisionRules = rules
+	compactor := NewCompactor(opts)
+	defer compactor.Stop()
+
+	earlier := BlockPrecision{Matchers: `{__name__="disk"}`, Digits: 2}
+	tests := []struct {
+		name          string
+		blocks        []*Block
+		wantCPU       float64
+		wantPrecision []BlockPrecision
+	}{
+		{"cold", writeBlocks(now.Add(-24*time.Hour), earlier), 1.23,
+			[]BlockPrecision{earlier, {Matchers: `{__name__="cpu"}`, Digits: 3}}},
+		{"hot", writeBlocks(now, BlockPrecision{}), 1.23456, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := compactor.mergeBlocks(tt.blocks); err != nil {
+				t.Fatalf("failed to merge blocks: %v", err)
+			}
+			merged := findMergedBlock(t, dataDir, tt.blocks[0].MinTime)
+			defer merged.Close()
+
+			for _, s := range []*series.Series{cpu, mem} {
+				samples, err := merged.GetSeries(s.Hash, math.MinInt64, math.MaxInt64)
+				if err != nil {
+					t.Fatalf("failed to read %s: %v", s.Labels["__name__"], err)
+				}
+				if len(samples) != 3 {
+					t.Fatalf("%s has %d samples, want 3", s.Labels["__name__"], len(samples))
+				}
+				want := 1.23456
+				if s == cpu {
+					want = tt.wantCPU
+				}
+				for _, sample := range samples {
+					if sample.Value != want {
+						t.Errorf("%s = %g, want %g", s.Labels["__name__"], sample.Value, want)
+					}
+				}
+			}
+			if !reflect.DeepEqual(merged.Precision, tt.wantPrecision) {
+				t.Errorf("precision = %+v, want %+v", merged.Precision, tt.wantPrecision)
+			}
+		})
+	}
+}
+
+// findMergedBlock opens the block in dataDir starting at minTime
+func findMergedBlock(t *testing.T, dataDir string, minTime int64) *Block {
+	t.Helper()
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		block, err := OpenBlock(filepath.Join(dataDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		if block.MinTime == minTime {
+			return block
+		}
+		block.Close()
+	}
+	t.Fatalf("no block starting at %d", minTime)
+	return nil
+}
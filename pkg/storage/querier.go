@@ -0,0 +1,636 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// ErrQuerierClosed indicates use of a querier after Close
+var ErrQuerierClosed = tsdberrors.New(tsdberrors.Unavailable, "tsdb: querier closed")
+
+// DefaultBatchSize is the most samples in a batch of a SampleIterator
+const DefaultBatchSize = 512
+
+// dbQuerier is the TSDB's Querier. It reads the head and the blocks of
+// its snapshot overlapping its range, merging samples of a series found in
+// several of them.
+type dbQuerier struct {
+	db         *TSDB
+	mint, maxt int64
+	closed     bool
+
+	// Blocks visible when the querier was created; every call reads the
+	// same set, even if compaction or retention replaces them meanwhile
+	snapshot *blockSnapshot
+
+	// Blocks of the snapshot overlapping the range, in ULID order; opened
+	// on first use and closed with the querier
+	blocks       []*Block
+	blocksOpened bool
+
+	// Only blocks whose labels match are read; the head is skipped when
+	// the labels its flushed blocks get don't match
+	blockMatchers index.Matchers
+	skipHead      bool
+
+	// First error of each block whose data was skipped, by ULID; series
+	// may be read concurrently
+	failMu sync.Mutex
+	failed map[string]error
+
+	// When the querier was created; its lifetime is reported to the
+	// background I/O scheduler on close
+	opened time.Time
+
+	// Records the blocks and chunks read; nil if the query isn't traced
+	trace *Trace
+}
+
+// Querier returns a querier over samples in [mint, maxt]
+func (db *TSDB) Querier(mint, maxt int64) (Querier, error) {
+	return db.BlockQuerier(mint, maxt, nil)
+}
+
+// BlockQuerier returns a querier over samples in [mint, maxt] of the blocks
+// whose labels match blockMatchers, e.g. {tenant="a"} or {source!="backfill"}.
+// The head counts as a level 0 block written by a flush.
+func (db *TSDB) BlockQuerier(mint, maxt int64, blockMatchers index.Matchers) (BatchQuerier, error) {
+	return db.TracedQuerier(mint, maxt, blockMatchers, nil)
+}
+
+// TracedQuerier returns a querier like BlockQuerier that records the blocks
+// and chunks it reads in trace, which may be nil
+func (db *TSDB) TracedQuerier(mint, maxt int64, blockMatchers index.Matchers, trace *Trace) (BatchQuerier, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+	if mint > maxt {
+		return nil, fmt.Errorf("tsdb: invalid querier range: mint %d after maxt %d", mint, maxt)
+	}
+	snapshot, err := db.leases.snapshot(db.dataDir, db.coldDir)
+	if err != nil {
+		return nil, fmt.Errorf("tsdb: failed to snapshot blocks: %w", err)
+	}
+	return &dbQuerier{
+		db:            db,
+		mint:          mint,
+		maxt:          maxt,
+		snapshot:      snapshot,
+		blockMatchers: blockMatchers,
+		skipHead:      !matchBlockLabels(blockMatchers, db.headLabels()),
+		opened:        time.Now(),
+		trace:         trace,
+	}, nil
+}
+
+// headLabels returns the labels the blocks flushed from the head get
+func (db *TSDB) headLabels() map[string]string {
+	return blockLabels(db.blockWriter.labels, Level0, db.blockWriter.source)
+}
+
+// Select returns matching series with samples in range. Series are found
+// through the head index and the indexes of the blocks in range; samples
+// are read as the set is iterated.
+func (q *dbQuerier) Select(matchers ...*index.Matcher) (SeriesSet, error) {
+	matched, err := q.selectSeries(matchers)
+	if err != nil {
+		return nil, err
+	}
+	return &querierSeriesSet{querier: q, series: matched, idx: -1}, nil
+}
+
+// SelectBatches returns matching series like Select. Each series' samples
+// are decoded into buffers reused from one series to the next, and merged
+// into batches as its iterator is advanced.
+func (q *dbQuerier) SelectBatches(matchers ...*index.Matcher) (BatchSeriesSet, error) {
+	matched, err := q.selectSeries(matchers)
+	if err != nil {
+		return nil, err
+	}
+	return &querierBatchSet{querier: q, series: matched, idx: -1}, nil
+}
+
+// Series returns the series matching matchers in the head and the blocks
+// in range, ordered by label set, without reading their samples
+func (q *dbQuerier) Series(matchers ...*index.Matcher) ([]*series.Series, error) {
+	return q.selectSeries(matchers)
+}
+
+// Samples returns the samples of s in range. The blocks are opened by
+// Series or Select, so calls made after them only read the querier.
+func (q *dbQuerier) Samples(s *series.Series) ([]series.Sample, error) {
+	if q.closed {
+		return nil, ErrQuerierClosed
+	}
+	q.openBlocks()
+	return q.samples(s)
+}
+
+// selectSeries returns the series matching matchers in the head and the
+// blocks in range, ordered by label set
+func (q *dbQuerier) selectSeries(matchers index.Matchers) ([]*series.Series, error) {
+	if q.closed {
+		return nil, ErrQuerierClosed
+	}
+
+	var matched []*series.Series
+	if !q.skipHead {
+		var err error
+		if matched, err = q.db.head.Select(matchers); err != nil {
+			return nil, err
+		}
+	}
+	blocks := q.openBlocks()
+
+	known := make(map[uint64]bool, len(matched))
+	for _, s := range matched {
+		known[s.Hash] = true
+	}
+	for _, block := range blocks {
+		var blockOnly []*series.Series
+		q.readBlock(block.ULID.String(), func() (err error) {
+			blockOnly, err = q.blockOnlySeries(block, matchers, known)
+			if err != nil {
+				return fmt.Errorf("block %s: %w", block.ULID, err)
+			}
+			return nil
+		})
+		matched = append(matched, blockOnly...)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return series.CompareLabels(matched[i].Labels, matched[j].Labels) < 0
+	})
+	return matched, nil
+}
+
+// blockOnlySeries returns the series of block matching matchers that are
+// not in known, adding them to it. Label sets are only rebuilt from the
+// block index when the block holds such series.
+func (q *dbQuerier) blockOnlySeries(block *Block, matchers index.Matchers, known map[uint64]bool) ([]*series.Series, error) {
+	hashes, err := block.LookupSeries(matchers)
+	if err != nil {
+		return nil, err
+	}
+	missing := false
+	for _, hash := range hashes {
+		if !known[hash] {
+			missing = true
+			break
+		}
+	}
+	if !missing {
+		return nil, nil
+	}
+
+	blockSeries, err := block.Series(matchers)
+	if err != nil {
+		return nil, err
+	}
+	var result []*series.Series
+	for _, s := range blockSeries {
+		if !known[s.Hash] {
+			known[s.Hash] = true
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+// samples returns the samples of a series in range from every block and
+// the head, merged in timestamp order. Where sources hold the same
+// timestamp the last write wins: later blocks over earlier ones, and the
+// head over all blocks, since a WAL replay can re-add samples to the head
+// that were already flushed.
+func (q *dbQuerier) samples(s *series.Series) ([]series.Sample, error) {
+	sources := make([][]series.Sample, 0, len(q.blocks)+1)
+	for _, block := range q.blocks {
+		if !q.mayContainSeries(block, s.Hash) {
+			continue
+		}
+		var samples []series.Sample
+		ok := q.readBlock(block.ULID.String(), func() error {
+			chunk, err := q.chunk(block, s.Hash)
+			if err != nil || chunk == nil {
+				return err
+			}
+			samples, err = q.appendChunk(nil, block, chunk)
+			return err
+		})
+		if ok {
+			sources = append(sources, sortSamples(samples))
+		}
+	}
+
+	if !q.skipHead {
+		head, err := q.db.head.Query(s.Hash, q.mint, q.maxt)
+		if err != nil {
+			return nil, err
+		}
+		if len(head) > 0 {
+			q.trace.headRead()
+		}
+		sources = append(sources, head)
+	}
+	return mergeSources(sources...), nil
+}
+
+// mayContainSeries reports whether block may hold the series with hash
+// according to its bloom filter
+func (q *dbQuerier) mayContainSeries(block *Block, hash uint64) bool {
+	if block.MayContainSeries(hash) {
+		return true
+	}
+	q.trace.bloomSkip(block.ULID.String())
+	return false
+}
+
+// chunk returns the chunk of the series with hash in block, or nil if the
+// block doesn't hold it
+func (q *dbQuerier) chunk(block *Block, hash uint64) (*Chunk, error) {
+	chunk, loaded, err := block.loadChunk(hash)
+	if err != nil {
+		return nil, fmt.Errorf("block %s: %w", block.ULID, err)
+	}
+	if chunk != nil {
+		q.trace.chunkRead(block.ULID.String(), loaded)
+	}
+	return chunk, nil
+}
+
+// appendChunk appends the samples of chunk in range to dst. The chunk is
+// only decoded if it overlaps the range.
+func (q *dbQuerier) appendChunk(dst []series.Sample, block *Block, chunk *Chunk) ([]series.Sample, error) {
+	if chunk.MinTime > q.maxt || chunk.MaxTime < q.mint {
+		return dst, nil
+	}
+	samples, err := appendChunk(dst, chunk, q.mint, q.maxt)
+	if err != nil {
+		return nil, fmt.Errorf("block %s: %w", block.ULID, err)
+	}
+	q.trace.chunkDecoded(block.ULID.String(), len(samples)-len(dst))
+	return samples, nil
+}
+
+// openBlocks opens the snapshot's blocks overlapping the querier's range
+// whose labels match its block matchers, skipping those that fail to open
+func (q *dbQuerier) openBlocks() []*Block {
+	if q.blocksOpened {
+		return q.blocks
+	}
+
+	for _, dir := range q.snapshot.Dirs() {
+		var block *Block
+		q.readBlock(filepath.Base(dir), func() (err error) {
+			block, err = openBlockInRange(dir, q.mint, q.maxt)
+			return err
+		})
+		if block == nil {
+			continue
+		}
+		if !matchBlockLabels(q.blockMatchers, block.Labels) {
+			block.Close()
+			continue
+		}
+		q.trace.blockOpened(block.ULID.String())
+		q.blocks = append(q.blocks, block)
+	}
+	q.blocksOpened = true
+	return q.blocks
+}
+
+// readBlock runs read, a read of the block with ULID id, retrying it once
+// if the database retries block reads. If it still fails, the error is
+// recorded for Warnings and counted, and false tells the caller to go on
+// without the block's data rather than fail the query.
+func (q *dbQuerier) readBlock(id string, read func() error) bool {
+	err := read()
+	if err != nil && q.db.retryBlockReads {
+		err = read()
+	}
+	if err == nil {
+		return true
+	}
+
+	q.db.stats.CorruptBlockReads.Add(1)
+	q.failMu.Lock()
+	defer q.failMu.Unlock()
+	if _, seen := q.failed[id]; !seen {
+		if q.failed == nil {
+			q.failed = make(map[string]error)
+		}
+		q.failed[id] = err
+		fmt.Printf("tsdb: query skipped unreadable block data: %v\n", err)
+	}
+	return false
+}
+
+// Warnings describes the blocks whose data was skipped, in ULID order
+func (q *dbQuerier) Warnings() []string {
+	q.failMu.Lock()
+	defer q.failMu.Unlock()
+
+	ids := make([]string, 0, len(q.failed))
+	for id := range q.failed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	warnings := make([]string, 0, len(ids))
+	for _, id := range ids {
+		warnings = append(warnings, fmt.Sprintf("skipped unreadable block data: %v", q.failed[id]))
+	}
+	return warnings
+}
+
+// LabelNames returns label names of series in range
+func (q *dbQuerier) LabelNames() ([]string, error) {
+	if q.closed {
+		return nil, ErrQuerierClosed
+	}
+	names, _, err := q.db.ListLabelNames(q.listOptions())
+	return names, err
+}
+
+// LabelValues returns values of a label among series in range
+func (q *dbQuerier) LabelValues(name string) ([]string, error) {
+	if q.closed {
+		return nil, ErrQuerierClosed
+	}
+	values, _, err := q.db.ListLabelValues(name, q.listOptions())
+	return values, err
+}
+
+// Close marks the querier closed and releases its blocks
+func (q *dbQuerier) Close() error {
+	if !q.closed {
+		q.closed = true
+		for _, block := range q.blocks {
+			block.Close()
+		}
+		q.blocks = nil
+		q.snapshot.Release()
+		q.db.io.observeQuery(time.Since(q.opened))
+	}
+	return nil
+}
+
+// listOptions returns listing options for the querier's range
+func (q *dbQuerier) listOptions() ListOptions {
+	return ListOptions{MinTime: q.mint, MaxTime: q.maxt, snapshot: q.snapshot}
+}
+
+// querierSeriesSet is a SeriesSet reading each series' samples when
+// iteration reaches it and skipping series without samples in range
+type querierSeriesSet struct {
+	querier *dbQuerier
+	series  []*series.Series
+	idx     int
+	samples []series.Sample
+	err     error
+}
+
+func (s *querierSeriesSet) Next() bool {
+	for s.err == nil {
+		s.idx++
+		if s.idx >= len(s.series) {
+			return false
+		}
+		if s.querier.closed {
+			s.err = ErrQuerierClosed
+			return false
+		}
+		samples, err := s.querier.samples(s.series[s.idx])
+		if err != nil {
+			s.err = fmt.Errorf("failed to query series %s: %w", s.series[s.idx], err)
+			return false
+		}
+		if len(samples) > 0 {
+			s.samples = samples
+			return true
+		}
+	}
+	return false
+}
+
+func (s *querierSeriesSet) At() (*series.Series, []series.Sample) {
+	return s.series[s.idx], s.samples
+}
+
+func (s *querierSeriesSet) Err() error { return s.err }
+
+// querierBatchSet is a BatchSeriesSet reading each series' samples when
+// iteration reaches it and skipping series without samples in range
+type querierBatchSet struct {
+	querier *dbQuerier
+	series  []*series.Series
+	idx     int
+	buffers [][]series.Sample // Decoded samples of each block, reused between series
+	chunks  []blockChunk      // Chunks of the current series, reused between series
+	iter    batchIterator
+	err     error
+}
+
+// blockChunk is the chunk of a series in the querier's block at index
+type blockChunk struct {
+	index int
+	chunk *Chunk
+}
+
+func (s *querierBatchSet) Next() bool {
+	for s.err == nil {
+		s.idx++
+		if s.idx >= len(s.series) {
+			return false
+		}
+		if s.querier.closed {
+			s.err = ErrQuerierClosed
+			return false
+		}
+		found, err := s.load(s.series[s.idx])
+		if err != nil {
+			s.err = fmt.Errorf("failed to query series %s: %w", s.series[s.idx], err)
+			return false
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// load resets the iterator to the samples of a series in range from every
+// block and the head, ordered from oldest to newest write like the sources
+// of dbQuerier.samples, and reports whether there are any. A series that
+// is a single summarized chunk inside the range is only decoded if the
+// iterator is advanced rather than asked for its summary.
+func (s *querierBatchSet) load(ser *series.Series) (bool, error) {
+	q := s.querier
+	if len(s.buffers) < len(q.blocks) {
+		s.buffers = make([][]series.Sample, len(q.blocks))
+	}
+
+	chunks := s.chunks[:0]
+	for i, block := range q.blocks {
+		if !q.mayContainSeries(block, ser.Hash) {
+			continue
+		}
+		var chunk *Chunk
+		ok := q.readBlock(block.ULID.String(), func() (err error) {
+			chunk, err = q.chunk(block, ser.Hash)
+			return err
+		})
+		if ok && chunk != nil && chunk.MinTime <= q.maxt && chunk.MaxTime >= q.mint {
+			chunks = append(chunks, blockChunk{index: i, chunk: chunk})
+		}
+	}
+	s.chunks = chunks
+
+	var head []series.Sample
+	if !q.skipHead {
+		var err error
+		if head, err = q.db.head.Query(ser.Hash, q.mint, q.maxt); err != nil {
+			return false, err
+		}
+		if len(head) > 0 {
+			q.trace.headRead()
+		}
+	}
+
+	if len(chunks) == 1 && len(head) == 0 {
+		c := chunks[0]
+		summary, ok := c.chunk.Summary()
+		if ok && summary.FirstTime >= q.mint && summary.LastTime <= q.maxt {
+			s.iter.resetSummary(summary, func() []series.Sample { return s.decode(c) })
+			return true, nil
+		}
+	}
+
+	sources := s.iter.sources[:0]
+	for _, c := range chunks {
+		if samples := s.decode(c); len(samples) > 0 {
+			sources = append(sources, samples)
+		}
+	}
+	if len(head) > 0 {
+		sources = append(sources, head)
+	}
+	if len(sources) == 0 {
+		return false, nil
+	}
+	s.iter.reset(sources)
+	return true, nil
+}
+
+// decode returns the sorted samples in range of a block's chunk, in the
+// block's reused buffer, or nil if the chunk can't be read
+func (s *querierBatchSet) decode(c blockChunk) []series.Sample {
+	q := s.querier
+	block := q.blocks[c.index]
+	var samples []series.Sample
+	ok := q.readBlock(block.ULID.String(), func() (err error) {
+		samples, err = q.appendChunk(s.buffers[c.index][:0], block, c.chunk)
+		return err
+	})
+	if !ok {
+		return nil
+	}
+	s.buffers[c.index] = samples
+	if !slices.IsSortedFunc(samples, compareTimestamps) {
+		sortSamples(samples)
+	}
+	return samples
+}
+
+func (s *querierBatchSet) At() (*series.Series, SampleIterator) {
+	return s.series[s.idx], &s.iter
+}
+
+func (s *querierBatchSet) Err() error { return s.err }
+
+// batchIterator is the SampleIterator of a querierBatchSet. A series found
+// in a single source with increasing timestamps is sliced into batches as
+// is; otherwise its sources are merged into a reused batch buffer.
+type batchIterator struct {
+	sources [][]series.Sample
+	merge   *mergeIterator // nil when sliced
+	buf     []series.Sample
+	batch   []series.Sample
+
+	// Summary of a single chunk whose samples decode returns on the
+	// first Next; decode is nil once they are, or for other series
+	summary ChunkSummary
+	decode  func() []series.Sample
+}
+
+// reset starts iterating over sources, which must be non-empty and sorted
+func (it *batchIterator) reset(sources [][]series.Sample) {
+	it.sources = sources
+	it.merge = nil
+	it.decode = nil
+	if len(sources) > 1 || !strictlyIncreasing(sources[0]) {
+		it.merge = newMergeIterator(sources...)
+	}
+}
+
+// resetSummary starts iterating over the samples decode returns, which
+// summary describes
+func (it *batchIterator) resetSummary(summary ChunkSummary, decode func() []series.Sample) {
+	it.summary, it.decode = summary, decode
+	it.merge = nil
+}
+
+// Compile-time check that batchIterator implements SummaryIterator
+var _ SummaryIterator = (*batchIterator)(nil)
+
+// Summary implements SummaryIterator
+func (it *batchIterator) Summary() (ChunkSummary, bool) {
+	return it.summary, it.decode != nil
+}
+
+func (it *batchIterator) Next() bool {
+	if it.decode != nil {
+		samples := it.decode()
+		if len(samples) == 0 {
+			it.decode = nil
+			return false
+		}
+		it.reset(append(it.sources[:0], samples))
+	}
+
+	if it.merge == nil {
+		rest := it.sources[0]
+		n := min(len(rest), DefaultBatchSize)
+		it.batch, it.sources[0] = rest[:n], rest[n:]
+		return n > 0
+	}
+
+	if it.buf == nil {
+		it.buf = make([]series.Sample, 0, DefaultBatchSize)
+	}
+	it.batch = it.buf[:0]
+	for len(it.batch) < DefaultBatchSize && it.merge.Next() {
+		it.batch = append(it.batch, it.merge.At())
+	}
+	return len(it.batch) > 0
+}
+
+func (it *batchIterator) At() []series.Sample { return it.batch }
+
+// strictlyIncreasing reports whether the timestamps of samples increase
+// from each sample to the next
+func strictlyIncreasing(samples []series.Sample) bool {
+	for i := 1; i < len(samples); i++ {
+		if samples[i].Timestamp <= samples[i-1].Timestamp {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,403 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestQuerier(t *testing.T) {
+	db, err := Open(DefaultOptions(t.TempDir()))
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	insert := func(labels map[string]string, samples ...series.Sample) {
+		if err := db.Insert(series.NewSeries(labels), samples); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	insert(map[string]string{"__name__": "cpu_usage", "host": "b"}, series.Sample{Timestamp: 3000, Value: 3}, series.Sample{Timestamp: 1000, Value: 1})
+	insert(map[string]string{"__name__": "cpu_usage", "host": "a"}, series.Sample{Timestamp: 2000, Value: 2})
+	insert(map[string]string{"__name__": "cpu_usage", "host": "c"}, series.Sample{Timestamp: 9000, Value: 9})
+	insert(map[string]string{"__name__": "mem_usage", "host": "a"}, series.Sample{Timestamp: 2000, Value: 2})
+
+	var s Storage = db
+	q, err := s.Querier(0, 5000)
+	if err != nil {
+		t.Fatalf("Querier failed: %v", err)
+	}
+	defer q.Close()
+
+	set, err := q.Select(index.MustNewMatcher(index.MatchEqual, "__name__", "cpu_usage"))
+	if err != nil {
+		t.Fatalf("Select failed: %v", err)
+	}
+
+	// host=c has no samples in range; the rest are ordered by labels
+	var hosts []string
+	for set.Next() {
+		s, samples := set.At()
+		hosts = append(hosts, s.Labels["host"])
+		for i := 1; i < len(samples); i++ {
+			if samples[i].Timestamp < samples[i-1].Timestamp {
+				t.Errorf("series %s: samples out of order: %v", s, samples)
+			}
+		}
+	}
+	if set.Err() != nil {
+		t.Fatalf("iteration failed: %v", set.Err())
+	}
+	if len(hosts) != 2 || hosts[0] != "a" || hosts[1] != "b" {
+		t.Errorf("got hosts %v, want [a b]", hosts)
+	}
+
+	values, err := q.LabelValues("__name__")
+	if err != nil {
+		t.Fatalf("LabelValues failed: %v", err)
+	}
+	if len(values) != 2 || values[0] != "cpu_usage" || values[1] != "mem_usage" {
+		t.Errorf("got values %v, want [cpu_usage mem_usage]", values)
+	}
+
+	names, err := q.LabelNames()
+	if err != nil {
+		t.Fatalf("LabelNames failed: %v", err)
+	}
+	if len(names) != 2 {
+		t.Errorf("got names %v, want [__name__ host]", names)
+	}
+
+	q.Close()
+	if _, err := q.Select(); !errors.Is(err, ErrQuerierClosed) {
+		t.Errorf("Select after Close: got %v, want ErrQuerierClosed", err)
+	}
+
+	if _, err := db.Querier(5000, 0); err == nil {
+		t.Error("Querier with mint after maxt succeeded")
+	}
+}
+
+func TestQuerierMergesBlocksAndHead(t *testing.T) {
+	dataDir := t.TempDir()
+
+	cpu := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "a"})
+	disk := series.NewSeries(map[string]string{"__name__": "disk_usage", "host": "a"})
+
+	// Two overlapping blocks, as left behind by a flush replayed from the WAL
+	for _, samples := range [][]series.Sample{
+		{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}},
+		{{Timestamp: 2000, Value: 20}, {Timestamp: 3000, Value: 30}},
+	} {
+		mt := NewMemTable()
+		if err := mt.Insert(cpu, samples); err != nil {
+			t.Fatal(err)
+		}
+		if err := mt.Insert(disk, samples[:1]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewBlockWriter(dataDir).WriteMemTable(mt); err != nil {
+			t.Fatalf("WriteMemTable failed: %v", err)
+		}
+	}
+
+	// Keep retention and compaction away from the blocks
+	opts := DefaultOptions(dataDir)
+	opts.EnableRetention = false
+	opts.EnableCompaction = false
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	// The head rewrites the newest sample and adds one
+	if err := db.Insert(cpu, []series.Sample{{Timestamp: 4000, Value: 4}, {Timestamp: 3000, Value: 300}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	q, err := db.Querier(0, 5000)
+	if err != nil {
+		t.Fatalf("Querier failed: %v", err)
+	}
+	defer q.Close()
+
+	set, err := q.Select()
+	if err != nil {
+		t.Fatalf("Select failed: %v", err)
+	}
+
+	got := make(map[string][]series.Sample)
+	var order []string
+	for set.Next() {
+		s, samples := set.At()
+		got[s.Labels["__name__"]] = samples
+		order = append(order, s.Labels["__name__"])
+	}
+	if set.Err() != nil {
+		t.Fatalf("iteration failed: %v", set.Err())
+	}
+
+	if len(order) != 2 || order[0] != "cpu_usage" || order[1] != "disk_usage" {
+		t.Fatalf("got series %v, want [cpu_usage disk_usage]", order)
+	}
+	want := []series.Sample{
+		{Timestamp: 1000, Value: 1},
+		{Timestamp: 2000, Value: 20},
+		{Timestamp: 3000, Value: 300},
+		{Timestamp: 4000, Value: 4},
+	}
+	if !reflect.DeepEqual(got["cpu_usage"], want) {
+		t.Errorf("cpu_usage: got %v, want %v", got["cpu_usage"], want)
+	}
+	if diskSamples := got["disk_usage"]; len(diskSamples) != 2 || diskSamples[1].Value != 20 {
+		t.Errorf("disk_usage: got %v, want a sample from each block", diskSamples)
+	}
+}
+
+func TestSelectBatchesMatchesSelect(t *testing.T) {
+	dataDir := t.TempDir()
+
+	merged := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "a"})
+	single := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "b"})
+	unsorted := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "c"})
+
+	// Two overlapping blocks holding more than a batch of samples; the
+	// second block's chunk of host=c is written out of order
+	for b := int64(0); b < 2; b++ {
+		mt := NewMemTable()
+		var samples []series.Sample
+		for i := int64(0); i < DefaultBatchSize+100; i++ {
+			samples = append(samples, series.Sample{Timestamp: (b*DefaultBatchSize + i) * 1000, Value: float64(b*10000 + i)})
+		}
+		if err := mt.Insert(merged, samples); err != nil {
+			t.Fatal(err)
+		}
+		if b == 0 {
+			if err := mt.Insert(single, samples); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			for _, i := range []int{5, 3, 4, 1, 2} {
+				if err := mt.Insert(unsorted, samples[i:i+1]); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if _, err := NewBlockWriter(dataDir).WriteMemTable(mt); err != nil {
+			t.Fatalf("WriteMemTable failed: %v", err)
+		}
+	}
+
+	opts := DefaultOptions(dataDir)
+	opts.EnableRetention = false
+	opts.EnableCompaction = false
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+	if err := db.Insert(merged, []series.Sample{{Timestamp: 1000, Value: -1}, {Timestamp: 5_000_000, Value: -2}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	q, err := db.BlockQuerier(0, 10_000_000, nil)
+	if err != nil {
+		t.Fatalf("BlockQuerier failed: %v", err)
+	}
+	defer q.Close()
+
+	want := make(map[string][]series.Sample)
+	set, err := q.Select()
+	if err != nil {
+		t.Fatalf("Select failed: %v", err)
+	}
+	for set.Next() {
+		s, samples := set.At()
+		want[s.Labels["host"]] = samples
+	}
+	if set.Err() != nil {
+		t.Fatalf("iteration failed: %v", set.Err())
+	}
+
+	batches, err := q.SelectBatches()
+	if err != nil {
+		t.Fatalf("SelectBatches failed: %v", err)
+	}
+	var hosts []string
+	for batches.Next() {
+		s, it := batches.At()
+		host := s.Labels["host"]
+		hosts = append(hosts, host)
+
+		var got []series.Sample
+		for it.Next() {
+			batch := it.At()
+			if len(batch) == 0 || len(batch) > DefaultBatchSize {
+				t.Errorf("host %s: batch of %d samples", host, len(batch))
+			}
+			got = append(got, batch...)
+		}
+		if !reflect.DeepEqual(got, want[host]) {
+			t.Errorf("host %s: batches hold %d samples differing from Select's %d", host, len(got), len(want[host]))
+		}
+	}
+	if batches.Err() != nil {
+		t.Fatalf("iteration failed: %v", batches.Err())
+	}
+	if !reflect.DeepEqual(hosts, []string{"a", "b", "c"}) {
+		t.Errorf("got hosts %v, want [a b c]", hosts)
+	}
+}
+
+func TestSelectBatchesSummary(t *testing.T) {
+	dataDir := t.TempDir()
+	flushed := series.NewSeries(map[string]string{"__name__": "temperature", "room": "a"})
+	mixed := series.NewSeries(map[string]string{"__name__": "temperature", "room": "b"})
+
+	mt := NewMemTable()
+	samples := []series.Sample{{Timestamp: 1000, Value: 3}, {Timestamp: 2000, Value: 1}, {Timestamp: 3000, Value: 2}}
+	for _, s := range []*series.Series{flushed, mixed} {
+		if err := mt.Insert(s, samples); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := NewBlockWriter(dataDir).WriteMemTable(mt); err != nil {
+		t.Fatalf("WriteMemTable failed: %v", err)
+	}
+
+	opts := DefaultOptions(dataDir)
+	opts.EnableRetention = false
+	opts.EnableCompaction = false
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+	if err := db.Insert(mixed, []series.Sample{{Timestamp: 4000, Value: 4}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	summaries := func(mint, maxt int64) map[string]bool {
+		q, err := db.BlockQuerier(mint, maxt, nil)
+		if err != nil {
+			t.Fatalf("BlockQuerier failed: %v", err)
+		}
+		defer q.Close()
+		set, err := q.SelectBatches()
+		if err != nil {
+			t.Fatalf("SelectBatches failed: %v", err)
+		}
+		found := make(map[string]bool)
+		for set.Next() {
+			s, it := set.At()
+			summary, ok := it.(SummaryIterator).Summary()
+			found[s.Labels["room"]] = ok
+			if !ok {
+				continue
+			}
+			if summary.Count != 3 || summary.Sum != 6 || summary.Min != 1 || summary.Max != 3 {
+				t.Errorf("room %s: summary = %+v, want 3 samples from 1 to 3 summing to 6", s.Labels["room"], summary)
+			}
+			// The chunk is still decoded if iterated
+			var n int
+			for it.Next() {
+				n += len(it.At())
+			}
+			if n != 3 {
+				t.Errorf("room %s: iterated %d samples, want 3", s.Labels["room"], n)
+			}
+		}
+		return found
+	}
+
+	// Only a chunk inside the range, not merged with the head, is summarized
+	if got := summaries(0, 10_000); !got["a"] || got["b"] {
+		t.Errorf("summarized rooms %v, want only a", got)
+	}
+	if got := summaries(1500, 10_000); got["a"] {
+		t.Error("summarized a chunk partly outside the range")
+	}
+}
+
+func TestQuerierSkipsUnreadableBlockData(t *testing.T) {
+	dataDir := t.TempDir()
+
+	cpu := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	disk := series.NewSeries(map[string]string{"__name__": "disk_usage"})
+	var blocks []*Block
+	for _, ts := range []int64{1000, 2000} {
+		mt := NewMemTable()
+		for _, s := range []*series.Series{cpu, disk} {
+			if err := mt.Insert(s, []series.Sample{{Timestamp: ts, Value: 1}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		block, err := NewBlockWriter(dataDir).WriteMemTable(mt)
+		if err != nil {
+			t.Fatalf("WriteMemTable failed: %v", err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	opts := DefaultOptions(dataDir)
+	opts.EnableRetention = false
+	opts.EnableCompaction = false
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	// The chunk of cpu_usage in the first block goes missing after open
+	bad := blocks[0]
+	if err := os.Remove(chunkPath(bad.Dir(), bad.seriesChunks[cpu.Hash])); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := db.BlockQuerier(0, 5000, nil)
+	if err != nil {
+		t.Fatalf("BlockQuerier failed: %v", err)
+	}
+	defer q.Close()
+
+	set, err := q.Select()
+	if err != nil {
+		t.Fatalf("Select failed: %v", err)
+	}
+	got := make(map[string]int)
+	for set.Next() {
+		s, samples := set.At()
+		got[s.Labels["__name__"]] = len(samples)
+	}
+	if set.Err() != nil {
+		t.Fatalf("iteration failed: %v", set.Err())
+	}
+	if got["cpu_usage"] != 1 || got["disk_usage"] != 2 {
+		t.Errorf("got %v samples, want cpu_usage without the bad block's sample and all of disk_usage", got)
+	}
+
+	batches, err := q.SelectBatches()
+	if err != nil {
+		t.Fatalf("SelectBatches failed: %v", err)
+	}
+	for batches.Next() {
+	}
+	if batches.Err() != nil {
+		t.Fatalf("batch iteration failed: %v", batches.Err())
+	}
+
+	warnings := q.Warnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], bad.ULID.String()) {
+		t.Errorf("got warnings %v, want one naming block %s", warnings, bad.ULID)
+	}
+	if n := db.GetStatsSnapshot().CorruptBlockReads; n != 2 {
+		t.Errorf("%d corrupt block reads counted, want 2", n)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package storage
+
+// raceEnabled reports whether the race detector is on; it instruments
+// allocations, so allocation counts don't hold under it
+const raceEnabled = true
@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+const (
+	// DefaultRateLimitWindow is the window per-series rate limits count
+	// samples over when SeriesRateLimit.Window is 0
+	DefaultRateLimitWindow = time.Minute
+
+	// rateSketchWidth and rateSketchDepth size the count-min sketch of
+	// per-series sample counts: 4 rows of 16K counters, 256KB whatever the
+	// number of series. A series sharing a counter in every row with a
+	// busier one can be limited early.
+	rateSketchWidth = 1 << 14
+	rateSketchDepth = 4
+)
+
+// RateLimitAction decides what happens to the samples a series writes over
+// its rate limit
+type RateLimitAction string
+
+const (
+	// RateLimitDrop silently drops the samples over the limit
+	RateLimitDrop RateLimitAction = "drop"
+
+	// RateLimitAggregate aligns the samples over the limit to a step of
+	// Window/MaxSamples, so the series keeps one sample per step; which one
+	// is decided by the duplicate policy (the last under overwrite)
+	RateLimitAggregate RateLimitAction = "aggregate"
+)
+
+// ParseRateLimitAction parses a rate limit action name
+func ParseRateLimitAction(s string) (RateLimitAction, error) {
+	switch a := RateLimitAction(s); a {
+	case RateLimitDrop, RateLimitAggregate:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown rate limit action %q (want drop or aggregate)", s)
+	}
+}
+
+// SeriesRateLimit caps how many samples a single series may write per
+// window, protecting the head from a runaway series, e.g. one client
+// writing 1-second data into a 60-second workload. Series are counted in a
+// fixed-size sketch on the write path, so the limit costs the same memory
+// however many series there are. The zero value disables the limit.
+type SeriesRateLimit struct {
+	// MaxSamples is the number of samples a series may write per Window;
+	// 0 disables the limit
+	MaxSamples int64
+
+	// Window is the period samples are counted over; 0 means
+	// DefaultRateLimitWindow. Counts start over at every window.
+	Window time.Duration
+
+	// Action is applied to the samples over the limit; empty means
+	// RateLimitDrop
+	Action RateLimitAction
+}
+
+// window returns the effective counting window
+func (l SeriesRateLimit) window() time.Duration {
+	if l.Window == 0 {
+		return DefaultRateLimitWindow
+	}
+	return l.Window
+}
+
+// action returns the effective action
+func (l SeriesRateLimit) action() RateLimitAction {
+	if l.Action == "" {
+		return RateLimitDrop
+	}
+	return l.Action
+}
+
+// Validate checks the limit
+func (l SeriesRateLimit) Validate() error {
+	if l.MaxSamples < 0 {
+		return fmt.Errorf("series rate limit %d cannot be negative", l.MaxSamples)
+	}
+	if l.Window < 0 {
+		return fmt.Errorf("series rate limit window %s cannot be negative", l.Window)
+	}
+	if l.Action != "" {
+		if _, err := ParseRateLimitAction(string(l.Action)); err != nil {
+			return err
+		}
+	}
+	if l.MaxSamples > 0 && l.window().Milliseconds()/l.MaxSamples == 0 {
+		return fmt.Errorf("series rate limit of %d samples per %s is finer than a millisecond", l.MaxSamples, l.window())
+	}
+	return nil
+}
+
+// rateLimiter applies a SeriesRateLimit, counting the samples each series
+// writes in the current window in a count-min sketch
+type rateLimiter struct {
+	limit  SeriesRateLimit
+	window time.Duration
+	step   int64 // Milliseconds between the samples kept by RateLimitAggregate
+	now    func() time.Time
+
+	mu          sync.Mutex // Serializes starting a new window
+	windowStart atomic.Int64
+	counts      [rateSketchDepth][rateSketchWidth]atomic.Uint32
+}
+
+// newRateLimiter returns a limiter for limit, or nil if it is disabled
+func newRateLimiter(limit SeriesRateLimit) *rateLimiter {
+	if limit.MaxSamples == 0 {
+		return nil
+	}
+	rl := &rateLimiter{
+		limit:  limit,
+		window: limit.window(),
+		now:    time.Now,
+	}
+	rl.step = rl.window.Milliseconds() / limit.MaxSamples
+	return rl
+}
+
+// rateCounts tallies what a rate limiter did to the samples of a write
+type rateCounts struct {
+	dropped    int64
+	aggregated int64
+}
+
+func (c *rateCounts) add(o rateCounts) {
+	c.dropped += o.dropped
+	c.aggregated += o.aggregated
+}
+
+// recordRate adds the counts to the TSDB statistics
+func (s *Stats) recordRate(c rateCounts) {
+	s.RateLimitedDropped.Add(c.dropped)
+	s.RateLimitedAggregated.Add(c.aggregated)
+}
+
+// apply counts samples against the series' limit and returns those to
+// write. The first samples fitting the limit are kept as they are; the rest
+// are dropped or aligned. samples is not modified.
+func (rl *rateLimiter) apply(s *series.Series, samples []series.Sample) ([]series.Sample, rateCounts) {
+	var counts rateCounts
+	if rl == nil {
+		return samples, counts
+	}
+
+	n := int64(len(samples))
+	before := rl.add(s.Hash, n) - n
+	allowed := max(rl.limit.MaxSamples-before, 0)
+	if allowed >= n {
+		return samples, counts
+	}
+
+	if rl.limit.action() == RateLimitDrop {
+		counts.dropped = n - allowed
+		return samples[:allowed], counts
+	}
+
+	// Align the samples over the limit, keeping the last value given for
+	// each step within the write
+	kept := make([]series.Sample, allowed, n)
+	copy(kept, samples[:allowed])
+	for _, sample := range samples[allowed:] {
+		sample.Timestamp -= sample.Timestamp % rl.step
+		if last := len(kept) - 1; last >= int(allowed) && kept[last].Timestamp == sample.Timestamp {
+			kept[last] = sample
+		} else {
+			kept = append(kept, sample)
+		}
+		counts.aggregated++
+	}
+	return kept, counts
+}
+
+// applyBatch applies the limit to each series of a batch, leaving out the
+// series with no samples left
+func (rl *rateLimiter) applyBatch(batch []*series.Series, samples [][]series.Sample) ([]*series.Series, [][]series.Sample, rateCounts) {
+	var counts rateCounts
+	if rl == nil {
+		return batch, samples, counts
+	}
+
+	keptSeries := make([]*series.Series, 0, len(batch))
+	keptSamples := make([][]series.Sample, 0, len(batch))
+	for i, s := range batch {
+		kept, c := rl.apply(s, samples[i])
+		counts.add(c)
+		if len(kept) > 0 {
+			keptSeries = append(keptSeries, s)
+			keptSamples = append(keptSamples, kept)
+		}
+	}
+	return keptSeries, keptSamples, counts
+}
+
+// add counts n samples of the series with hash in the current window and
+// returns its estimated count, which may be too high but never too low
+func (rl *rateLimiter) add(hash uint64, n int64) int64 {
+	rl.rotate()
+
+	h1, h2 := bloomHashes(hash)
+	estimate := int64(-1)
+	for i := range rl.counts {
+		c := int64(rl.counts[i][(h1+uint64(i)*h2)%rateSketchWidth].Add(uint32(n)))
+		if estimate < 0 || c < estimate {
+			estimate = c
+		}
+	}
+	return estimate
+}
+
+// rotate clears the sketch when the current window has passed. Samples
+// counted concurrently with the reset may be lost, letting a series over
+// its limit through for a few more samples.
+func (rl *rateLimiter) rotate() {
+	now := rl.now().UnixMilli()
+	window := rl.window.Milliseconds()
+	if now < rl.windowStart.Load()+window {
+		return
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if now < rl.windowStart.Load()+window {
+		return
+	}
+	for i := range rl.counts {
+		for j := range rl.counts[i] {
+			rl.counts[i][j].Store(0)
+		}
+	}
+	rl.windowStart.Store(now - now%window)
+}
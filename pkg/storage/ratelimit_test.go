@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// secondly returns n samples one second apart from ts, valued 1 to n
+func secondly(ts int64, n int) []series.Sample {
+	samples := make([]series.Sample, n)
+	for i := range samples {
+		samples[i] = series.Sample{Timestamp: ts + int64(i)*1000, Value: float64(i + 1)}
+	}
+	return samples
+}
+
+func TestSeriesRateLimitDrop(t *testing.T) {
+	now := time.UnixMilli(60_000)
+	db := openTestDB(t, func(opts *Options) { opts.SeriesRateLimit = SeriesRateLimit{MaxSamples: 2} })
+	db.rateLimiter.now = func() time.Time { return now }
+	hot := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "a"})
+	calm := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "b"})
+
+	if err := db.Insert(hot, secondly(0, 5)); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.Insert(hot, secondly(5000, 1)); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.Insert(calm, secondly(0, 2)); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	samples, _ := db.Query(hot.Hash, math.MinInt64, math.MaxInt64)
+	if len(samples) != 2 || samples[0].Timestamp != 0 || samples[1].Timestamp != 1000 {
+		t.Errorf("hot series kept %v, want its first 2 samples", samples)
+	}
+	if samples, _ := db.Query(calm.Hash, math.MinInt64, math.MaxInt64); len(samples) != 2 {
+		t.Errorf("series within the limit kept %d samples, want 2", len(samples))
+	}
+	if n := db.GetStatsSnapshot().RateLimitedDropped; n != 4 {
+		t.Errorf("%d samples dropped, want 4", n)
+	}
+
+	// Counts start over in the next window
+	now = now.Add(time.Minute)
+	if err := db.Insert(hot, secondly(10_000, 1)); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if samples, _ := db.Query(hot.Hash, math.MinInt64, math.MaxInt64); len(samples) != 3 {
+		t.Errorf("hot series kept %d samples after the window passed, want 3", len(samples))
+	}
+}
+
+func TestSeriesRateLimitAggregate(t *testing.T) {
+	now := time.UnixMilli(60_000)
+	// One sample per 30 seconds
+	db := openTestDB(t, func(opts *Options) {
+		opts.SeriesRateLimit = SeriesRateLimit{MaxSamples: 2, Action: RateLimitAggregate}
+	})
+	db.rateLimiter.now = func() time.Time { return now }
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+
+	// 1-second data: the first 2 samples fit, the rest collapse to the
+	// 30-second steps they fall in, overwriting the sample at 60s
+	if err := db.Insert(s, secondly(60_000, 40)); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	samples, _ := db.Query(s.Hash, math.MinInt64, math.MaxInt64)
+	want := []series.Sample{
+		{Timestamp: 60_000, Value: 30},
+		{Timestamp: 61_000, Value: 2},
+		{Timestamp: 90_000, Value: 40},
+	}
+	if len(samples) != len(want) {
+		t.Fatalf("got %v, want %v", samples, want)
+	}
+	for i := range want {
+		if samples[i] != want[i] {
+			t.Errorf("sample %d is %v, want %v", i, samples[i], want[i])
+		}
+	}
+	if n := db.GetStatsSnapshot().RateLimitedAggregated; n != 38 {
+		t.Errorf("%d samples aggregated, want 38", n)
+	}
+}
+
+func TestSeriesRateLimitBatch(t *testing.T) {
+	now := time.UnixMilli(60_000)
+	db := openTestDB(t, func(opts *Options) { opts.SeriesRateLimit = SeriesRateLimit{MaxSamples: 1} })
+	db.rateLimiter.now = func() time.Time { return now }
+	labels := map[string]string{"__name__": "cpu_usage"}
+
+	app := db.Appender()
+	for i := int64(0); i < 3; i++ {
+		if err := app.Append(labels, i*1000, float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.Commit(); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+
+	// A batch whose series are all over the limit writes nothing
+	app = db.Appender()
+	if err := app.Append(labels, 5000, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Commit(); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+
+	s := series.NewSeries(labels)
+	if samples, _ := db.Query(s.Hash, math.MinInt64, math.MaxInt64); len(samples) != 1 {
+		t.Errorf("got %d samples, want 1", len(samples))
+	}
+	if n := db.GetStatsSnapshot().RateLimitedDropped; n != 3 {
+		t.Errorf("%d samples dropped, want 3", n)
+	}
+}
+
+func TestSeriesRateLimitValidate(t *testing.T) {
+	tests := []struct {
+		limit   SeriesRateLimit
+		wantErr bool
+	}{
+		{SeriesRateLimit{}, false},
+		{SeriesRateLimit{MaxSamples: 60, Window: time.Minute, Action: RateLimitAggregate}, false},
+		{SeriesRateLimit{MaxSamples: -1}, true},
+		{SeriesRateLimit{MaxSamples: 1, Window: -time.Second}, true},
+		{SeriesRateLimit{MaxSamples: 1, Action: "sample"}, true},
+		{SeriesRateLimit{MaxSamples: 2000, Window: time.Second}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.limit.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%+v) = %v, want error %v", tt.limit, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"regexp"
+	"strings"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// RelabelAction is what a relabel rule does to a series
+type RelabelAction string
+
+const (
+	// RelabelReplace sets TargetLabel to Replacement, expanded with the
+	// groups of Regex, if Regex matches the joined SourceLabels values. An
+	// empty result removes TargetLabel.
+	RelabelReplace RelabelAction = "replace"
+
+	// RelabelKeep drops the series unless Regex matches the joined
+	// SourceLabels values
+	RelabelKeep RelabelAction = "keep"
+
+	// RelabelDrop drops the series if Regex matches the joined
+	// SourceLabels values
+	RelabelDrop RelabelAction = "drop"
+
+	// RelabelLabelDrop removes the labels whose name matches Regex
+	RelabelLabelDrop RelabelAction = "labeldrop"
+
+	// RelabelHashMod sets TargetLabel to the hash of the joined
+	// SourceLabels values modulo Modulus, e.g. to shard series
+	RelabelHashMod RelabelAction = "hashmod"
+)
+
+// Defaults of the relabel rule fields left empty
+const (
+	DefaultRelabelSeparator   = ";"
+	DefaultRelabelRegex       = "(.*)"
+	DefaultRelabelReplacement = "$1"
+)
+
+// RelabelConfig is a rule rewriting the labels of written series, with the
+// fields and semantics of Prometheus' metric_relabel_configs. Rules are
+// applied in order before a series is validated and stored; a dropped
+// series' samples are discarded without an error.
+type RelabelConfig struct {
+	// SourceLabels are the labels whose values, joined by Separator, are
+	// matched against Regex; a missing label is an empty value
+	SourceLabels []string `json:"source_labels,omitempty"`
+
+	// Separator joins the SourceLabels values; empty means ";"
+	Separator string `json:"separator,omitempty"`
+
+	// Regex is matched against the whole joined value (or the label name
+	// for labeldrop); empty means "(.*)"
+	Regex string `json:"regex,omitempty"`
+
+	// Modulus is the hashmod divisor
+	Modulus uint64 `json:"modulus,omitempty"`
+
+	// TargetLabel is the label set by replace and hashmod
+	TargetLabel string `json:"target_label,omitempty"`
+
+	// Replacement is the value set by replace, which may refer to Regex
+	// groups as $1 or ${name}; empty means "$1"
+	Replacement string `json:"replacement,omitempty"`
+
+	// Action is what the rule does; empty means replace
+	Action RelabelAction `json:"action,omitempty"`
+}
+
+// action returns the effective action
+func (c RelabelConfig) action() RelabelAction {
+	if c.Action == "" {
+		return RelabelReplace
+	}
+	return c.Action
+}
+
+// Validate checks the rule
+func (c RelabelConfig) Validate() error {
+	_, err := compileRelabel(c)
+	return err
+}
+
+// relabelRule is a validated relabel rule with its defaults filled in
+type relabelRule struct {
+	RelabelConfig
+	regex *regexp.Regexp
+}
+
+// compileRelabel validates c and fills in its defaults
+func compileRelabel(c RelabelConfig) (relabelRule, error) {
+	c.Action = c.action()
+	if c.Separator == "" {
+		c.Separator = DefaultRelabelSeparator
+	}
+	if c.Regex == "" {
+		c.Regex = DefaultRelabelRegex
+	}
+	if c.Replacement == "" {
+		c.Replacement = DefaultRelabelReplacement
+	}
+
+	regex, err := regexp.Compile("^(?:" + c.Regex + ")$")
+	if err != nil {
+		return relabelRule{}, fmt.Errorf("relabel regex %q: %w", c.Regex, err)
+	}
+
+	switch c.Action {
+	case RelabelReplace:
+		if c.TargetLabel == "" {
+			return relabelRule{}, fmt.Errorf("relabel action replace needs a target label")
+		}
+	case RelabelHashMod:
+		if c.TargetLabel == "" || c.Modulus == 0 {
+			return relabelRule{}, fmt.Errorf("relabel action hashmod needs a target label and a modulus")
+		}
+	case RelabelKeep, RelabelDrop:
+		if len(c.SourceLabels) == 0 {
+			return relabelRule{}, fmt.Errorf("relabel action %s needs source labels", c.Action)
+		}
+	case RelabelLabelDrop:
+		if len(c.SourceLabels) > 0 || c.TargetLabel != "" {
+			return relabelRule{}, fmt.Errorf("relabel action labeldrop matches label names and takes no source or target labels")
+		}
+	default:
+		return relabelRule{}, fmt.Errorf("unknown relabel action %q (want replace, keep, drop, labeldrop or hashmod)", c.Action)
+	}
+	return relabelRule{RelabelConfig: c, regex: regex}, nil
+}
+
+// relabeler applies relabel rules to written series
+type relabeler struct {
+	rules []relabelRule
+}
+
+// newRelabeler returns a relabeler applying configs, or nil if there are
+// none
+func newRelabeler(configs []RelabelConfig) (*relabeler, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+	r := &relabeler{rules: make([]relabelRule, 0, len(configs))}
+	for _, c := range configs {
+		rule, err := compileRelabel(c)
+		if err != nil {
+			return nil, err
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r, nil
+}
+
+// relabel returns s with the rules applied, or false if a rule dropped it.
+// s itself is returned when no rule changed its labels.
+func (r *relabeler) relabel(s *series.Series) (*series.Series, bool) {
+	if r == nil {
+		return s, true
+	}
+
+	labels := s.Labels
+	changed := false
+	// set copies the labels on the first change, leaving s untouched
+	set := func(name, value string) {
+		if old, ok := labels[name]; old == value && ok == (value != "") {
+			return
+		}
+		if !changed {
+			labels = maps.Clone(labels)
+			changed = true
+		}
+		if value == "" {
+			delete(labels, name)
+		} else {
+			labels[name] = value
+		}
+	}
+
+	for _, rule := range r.rules {
+		if rule.Action == RelabelLabelDrop {
+			for name := range labels {
+				if rule.regex.MatchString(name) {
+					set(name, "")
+				}
+			}
+			continue
+		}
+
+		value := joinLabels(labels, rule.SourceLabels, rule.Separator)
+		switch rule.Action {
+		case RelabelKeep:
+			if !rule.regex.MatchString(value) {
+				return nil, false
+			}
+		case RelabelDrop:
+			if rule.regex.MatchString(value) {
+				return nil, false
+			}
+		case RelabelReplace:
+			match := rule.regex.FindStringSubmatchIndex(value)
+			if match == nil {
+				continue
+			}
+			target := string(rule.regex.ExpandString(nil, rule.TargetLabel, value, match))
+			set(target, string(rule.regex.ExpandString(nil, rule.Replacement, value, match)))
+		case RelabelHashMod:
+			sum := md5.Sum([]byte(value))
+			set(rule.TargetLabel, fmt.Sprint(binary.BigEndian.Uint64(sum[8:])%rule.Modulus))
+		}
+	}
+
+	if !changed {
+		return s, true
+	}
+	return series.NewSeries(labels), true
+}
+
+// joinLabels joins the values of names in labels with sep
+func joinLabels(labels map[string]string, names []string, sep string) string {
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = labels[name]
+	}
+	return strings.Join(values, sep)
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestRelabel(t *testing.T) {
+	input := map[string]string{"__name__": "http_requests", "job": "api", "pod_uid": "a1b2", "env": "prod"}
+
+	tests := []struct {
+		name  string
+		rules []RelabelConfig
+		want  map[string]string // nil if the series is dropped
+	}{
+		{
+			name:  "no change",
+			rules: []RelabelConfig{{SourceLabels: []string{"job"}, Regex: "web", TargetLabel: "tier", Replacement: "front"}},
+			want:  input,
+		},
+		{
+			name:  "labeldrop",
+			rules: []RelabelConfig{{Regex: "pod_.*", Action: RelabelLabelDrop}},
+			want:  map[string]string{"__name__": "http_requests", "job": "api", "env": "prod"},
+		},
+		{
+			name:  "keep matching",
+			rules: []RelabelConfig{{SourceLabels: []string{"env"}, Regex: "prod|staging", Action: RelabelKeep}},
+			want:  input,
+		},
+		{
+			name:  "keep not matching",
+			rules: []RelabelConfig{{SourceLabels: []string{"env"}, Regex: "dev", Action: RelabelKeep}},
+		},
+		{
+			name:  "drop matching joined labels",
+			rules: []RelabelConfig{{SourceLabels: []string{"job", "env"}, Regex: "api;prod", Action: RelabelDrop}},
+		},
+		{
+			name: "replace with groups",
+			rules: []RelabelConfig{{
+				SourceLabels: []string{"__name__", "job"},
+				Separator:    "/",
+				Regex:        "http_(.*)/(.*)",
+				TargetLabel:  "kind",
+				Replacement:  "${2}_$1",
+			}},
+			want: map[string]string{"__name__": "http_requests", "job": "api", "pod_uid": "a1b2", "env": "prod", "kind": "api_requests"},
+		},
+		{
+			name:  "replace with empty value removes the label",
+			rules: []RelabelConfig{{SourceLabels: []string{"missing"}, TargetLabel: "env"}},
+			want:  map[string]string{"__name__": "http_requests", "job": "api", "pod_uid": "a1b2"},
+		},
+		{
+			name: "hashmod",
+			rules: []RelabelConfig{
+				{SourceLabels: []string{"pod_uid"}, Modulus: 1, TargetLabel: "shard", Action: RelabelHashMod},
+			},
+			want: map[string]string{"__name__": "http_requests", "job": "api", "pod_uid": "a1b2", "env": "prod", "shard": "0"},
+		},
+		{
+			name: "rules apply in order",
+			rules: []RelabelConfig{
+				{SourceLabels: []string{"pod_uid"}, TargetLabel: "pod"},
+				{Regex: "pod_uid", Action: RelabelLabelDrop},
+				{SourceLabels: []string{"pod_uid"}, Regex: ".+", Action: RelabelDrop},
+			},
+			want: map[string]string{"__name__": "http_requests", "job": "api", "pod": "a1b2", "env": "prod"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newRelabeler(tt.rules)
+			if err != nil {
+				t.Fatalf("invalid rules: %v", err)
+			}
+			s := series.NewSeries(input)
+			got, keep := r.relabel(s)
+			if tt.want == nil {
+				if keep {
+					t.Errorf("series kept as %v, want it dropped", got.Labels)
+				}
+				return
+			}
+			if !keep {
+				t.Fatalf("series dropped, want %v", tt.want)
+			}
+			if !reflect.DeepEqual(got.Labels, tt.want) {
+				t.Errorf("got %v, want %v", got.Labels, tt.want)
+			}
+			if got.Hash != series.NewSeries(tt.want).Hash {
+				t.Errorf("relabeled series has a stale hash")
+			}
+			if len(s.Labels) != len(input) {
+				t.Errorf("relabeling modified the input series: %v", s.Labels)
+			}
+		})
+	}
+}
+
+func TestRelabelConfigValidate(t *testing.T) {
+	tests := []struct {
+		config  RelabelConfig
+		wantErr bool
+	}{
+		{RelabelConfig{SourceLabels: []string{"a"}, TargetLabel: "b"}, false},
+		{RelabelConfig{Regex: "pod_.*", Action: RelabelLabelDrop}, false},
+		{RelabelConfig{SourceLabels: []string{"a"}}, true},
+		{RelabelConfig{SourceLabels: []string{"a"}, Regex: "(", TargetLabel: "b"}, true},
+		{RelabelConfig{SourceLabels: []string{"a"}, TargetLabel: "b", Action: RelabelHashMod}, true},
+		{RelabelConfig{Action: RelabelKeep}, true},
+		{RelabelConfig{SourceLabels: []string{"a"}, Action: RelabelLabelDrop}, true},
+		{RelabelConfig{SourceLabels: []string{"a"}, Action: "labelmap"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%+v) = %v, want error %v", tt.config, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRelabelOnWrite(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.Relabel = []RelabelConfig{
+		{SourceLabels: []string{"env"}, Regex: "dev", Action: RelabelDrop},
+		{Regex: "pod_uid", Action: RelabelLabelDrop},
+	}
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	// Series differing only in pod_uid are written as one
+	app := db.Appender()
+	for i, uid := range []string{"a", "b"} {
+		labels := map[string]string{"__name__": "cpu_usage", "pod_uid": uid}
+		if err := app.Append(labels, int64(i)*1000, float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.Append(map[string]string{"__name__": "cpu_usage", "env": "dev"}, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Commit(); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	dev := series.NewSeries(map[string]string{"__name__": "cpu_usage", "env": "dev"})
+	if err := db.Insert(dev, []series.Sample{{Timestamp: 0, Value: 1}}); err != nil {
+		t.Fatalf("insert of a dropped series failed: %v", err)
+	}
+
+	kept := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	if samples, _ := db.Query(kept.Hash, math.MinInt64, math.MaxInt64); len(samples) != 2 {
+		t.Errorf("relabeled series has %d samples, want 2", len(samples))
+	}
+	if samples, _ := db.Query(dev.Hash, math.MinInt64, math.MaxInt64); len(samples) != 0 {
+		t.Errorf("dropped series has %d samples", len(samples))
+	}
+	if n := db.GetStatsSnapshot().RelabelDropped; n != 2 {
+		t.Errorf("%d samples dropped by relabeling, want 2", n)
+	}
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+	"github.com/therealutkarshpriyadarshi/time/pkg/wal"
+)
+
+// ReplayProgress reports how far WAL replay on open has got
+type ReplayProgress struct {
+	SegmentsDone   int
+	SegmentsTotal  int
+	EntriesApplied int64 // Sample entries inserted into the head
+	SamplesSkipped int64 // Samples skipped by ReplaySkipFlushed
+	Bytes          int64 // Size of the WAL replayed
+
+	// SkippedThrough is the newest block's MaxTime when ReplaySkipFlushed
+	// applies: samples at or before it are skipped. 0 otherwise.
+	SkippedThrough int64
+
+	Elapsed time.Duration
+	Done    bool
+}
+
+// recover replays the WAL to rebuild in-memory state, reporting progress
+// to opts.ReplayProgress. quarantined tells whether corrupt blocks were
+// moved aside on open, in which case nothing is skipped: their samples
+// may only be left in the WAL.
+func (db *TSDB) recover(opts *Options, quarantined bool) error {
+	var progress ReplayProgress
+	if opts.ReplaySkipFlushed {
+		maxTime, ok, err := newestBlockMaxTime(db.dataDir, db.coldDir)
+		switch {
+		case err != nil:
+			return err
+		case quarantined:
+			fmt.Printf("tsdb: blocks were quarantined, replaying the whole WAL\n")
+		case ok:
+			progress.SkippedThrough = maxTime
+		}
+	}
+
+	size, err := db.walWriter.Size()
+	if err != nil {
+		return fmt.Errorf("WAL replay failed: %w", err)
+	}
+	progress.Bytes = size
+
+	start := time.Now()
+	report := func() {
+		progress.Elapsed = time.Since(start)
+		if opts.ReplayProgress != nil {
+			opts.ReplayProgress(progress)
+		}
+	}
+
+	err = db.walWriter.ReplaySegments(func(entries []wal.Entry, done, total int) error {
+		for _, entry := range entries {
+			if entry.Type != 1 || entry.Series == nil || len(entry.Samples) == 0 { // Sample entries only
+				continue
+			}
+
+			samples := entry.Samples
+			if progress.SkippedThrough != 0 {
+				samples = samplesAfter(samples, progress.SkippedThrough)
+				progress.SamplesSkipped += int64(len(entry.Samples) - len(samples))
+				if len(samples) == 0 {
+					continue
+				}
+			}
+
+			// Best effort recovery - ignore errors
+			if err := db.head.activeMemTable().Insert(entry.Series, samples); err == nil {
+				db.indexSeries(entry.Series)
+				progress.EntriesApplied++
+			}
+		}
+
+		progress.SegmentsDone, progress.SegmentsTotal = done, total
+		report()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("WAL replay failed: %w", err)
+	}
+
+	progress.Done = true
+	report()
+	db.replay = progress
+
+	if progress.EntriesApplied > 0 || progress.SamplesSkipped > 0 {
+		fmt.Printf("tsdb: recovered %d entries from WAL in %v, skipped %d flushed samples\n",
+			progress.EntriesApplied, progress.Elapsed.Round(time.Millisecond), progress.SamplesSkipped)
+	}
+	return nil
+}
+
+// samplesAfter returns the samples newer than t, reusing samples if none
+// are dropped
+func samplesAfter(samples []series.Sample, t int64) []series.Sample {
+	kept := 0
+	for _, s := range samples {
+		if s.Timestamp > t {
+			kept++
+		}
+	}
+	if kept == len(samples) {
+		return samples
+	}
+
+	after := make([]series.Sample, 0, kept)
+	for _, s := range samples {
+		if s.Timestamp > t {
+			after = append(after, s)
+		}
+	}
+	return after
+}
+
+// newestBlockMaxTime returns the largest MaxTime of the blocks in dataDirs,
+// reporting false if there are none
+func newestBlockMaxTime(dataDirs ...string) (int64, bool, error) {
+	dirs, err := blockDirs(dataDirs...)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var maxTime int64
+	found := false
+	for _, dir := range dirs {
+		meta, err := readBlockMeta(dir)
+		if err != nil {
+			return 0, false, fmt.Errorf("block %s: %w", filepath.Base(dir), err)
+		}
+		if !found || meta.MaxTime > maxTime {
+			maxTime, found = meta.MaxTime, true
+		}
+	}
+	return maxTime, found, nil
+}
@@ -0,0 +1,360 @@
+package storage
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/wal"
+)
+
+// restoreStagingPattern names the directories a restore stages blocks in.
+// They are not valid ULIDs, so the database ignores them.
+const restoreStagingPattern = ".restore-*"
+
+// RestoreOptions configures RestoreBackup
+type RestoreOptions struct {
+	// DryRun reports what would be restored without changing anything
+	DryRun bool
+}
+
+// RestoreResult describes what happened to one block of a backup
+type RestoreResult struct {
+	Block      string // Block ULID in the backup, or "wal" for WAL samples
+	RestoredAs string // Block ULID in the data directory; empty if skipped or not yet known in a dry run
+	MinTime    int64
+	MaxTime    int64
+
+	Skipped bool // An identical block is already in the data directory
+	Renamed bool // A different block already had the ULID; a new one was assigned
+
+	// Overlaps lists the ULIDs of blocks in the data directory overlapping
+	// this one. The restored block is then marked for vertical compaction,
+	// which merges it with them.
+	Overlaps []string
+}
+
+// restoreTarget is a block in the data directory, existing or restored
+type restoreTarget struct {
+	ulid    string
+	minTime int64
+	maxTime int64
+	stats   BlockStats
+}
+
+// RestoreBackup copies the blocks of the backup in backupDir into dataDir.
+// It is safe to run against the data directory of a running database: each
+// block is staged under a temporary name and renamed into place once
+// complete, so the database never sees a partial block.
+//
+// A block whose ULID is already taken is skipped if the existing block is
+// identical, and otherwise restored under a new ULID. A block overlapping
+// blocks already in dataDir is marked with OverlappingFile, so compaction
+// merges them instead of keeping duplicate samples side by side. Samples in
+// the backup's WAL are restored as one more block. Metric metadata is not
+// restored.
+func RestoreBackup(dataDir, backupDir string, opts RestoreOptions) ([]RestoreResult, error) {
+	sources, err := blockDirs(backupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := restoreTargets(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.DryRun {
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create data directory: %w", err)
+		}
+	}
+
+	// WAL samples are written to a block of their own first
+	walResult, walBlock, cleanup, err := stageWALBlock(dataDir, filepath.Join(backupDir, DefaultWALDir), opts.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	if walBlock != "" {
+		sources = append(sources, walBlock)
+	}
+
+	var results []RestoreResult
+	for _, src := range sources {
+		result, target, err := restoreBlock(dataDir, src, targets, opts.DryRun)
+		if err != nil {
+			return results, fmt.Errorf("block %s: %w", filepath.Base(src), err)
+		}
+		if src == walBlock {
+			result.Block = "wal"
+		}
+		if target != nil {
+			targets = append(targets, *target)
+		}
+		results = append(results, *result)
+	}
+	if walResult != nil {
+		results = append(results, *walResult)
+	}
+
+	if !opts.DryRun {
+		if err := syncDir(dataDir); err != nil {
+			return results, fmt.Errorf("failed to sync data directory: %w", err)
+		}
+	}
+	return results, nil
+}
+
+// RestoreArchive restores a backup archive, as served by the backup API,
+// into dataDir like RestoreBackup. The archive is unpacked into a staging
+// directory under dataDir first.
+func RestoreArchive(dataDir string, r io.Reader, opts RestoreOptions) ([]RestoreResult, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	staging, err := os.MkdirTemp(dataDir, restoreStagingPattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	if err := extractArchive(r, staging); err != nil {
+		return nil, fmt.Errorf("failed to unpack backup: %w", err)
+	}
+	return RestoreBackup(dataDir, staging, opts)
+}
+
+// extractArchive unpacks the regular files of a tar archive into dir
+func extractArchive(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := filepath.FromSlash(hdr.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid path %q in archive", hdr.Name)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+}
+
+// restoreTargets reads the metadata of the blocks in dataDir
+func restoreTargets(dataDir string) ([]restoreTarget, error) {
+	dirs, err := blockDirs(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]restoreTarget, 0, len(dirs))
+	for _, dir := range dirs {
+		meta, err := readBlockMeta(dir)
+		if err != nil {
+			return nil, fmt.Errorf("block %s: %w", filepath.Base(dir), err)
+		}
+		targets = append(targets, restoreTarget{
+			ulid:    filepath.Base(dir),
+			minTime: meta.MinTime,
+			maxTime: meta.MaxTime,
+			stats:   meta.Stats,
+		})
+	}
+	return targets, nil
+}
+
+// stageWALBlock replays the WAL in walDir into a block staged under
+// dataDir, returning its directory, or "" if the WAL holds no samples. In
+// a dry run nothing is written; the returned result describes the block
+// instead. cleanup removes the staging directory.
+func stageWALBlock(dataDir, walDir string, dryRun bool) (result *RestoreResult, blockDir string, cleanup func(), err error) {
+	cleanup = func() {}
+	if _, err := os.Stat(walDir); os.IsNotExist(err) {
+		return nil, "", cleanup, nil
+	}
+
+	w, err := wal.Open(walDir, nil)
+	if err != nil {
+		return nil, "", cleanup, fmt.Errorf("failed to open backup WAL: %w", err)
+	}
+	entries, err := w.Replay()
+	w.Close()
+	if err != nil {
+		return nil, "", cleanup, fmt.Errorf("failed to replay backup WAL: %w", err)
+	}
+
+	mt := NewMemTable()
+	for _, entry := range entries {
+		if entry.Type == 1 && entry.Series != nil && len(entry.Samples) > 0 { // Sample entry
+			// Best effort, as in WAL recovery
+			_ = mt.Insert(entry.Series, entry.Samples)
+		}
+	}
+	if mt.SampleCount() == 0 {
+		return nil, "", cleanup, nil
+	}
+
+	if dryRun {
+		minTime, maxTime := mt.TimeRange()
+		return &RestoreResult{Block: "wal", MinTime: minTime, MaxTime: maxTime}, "", cleanup, nil
+	}
+
+	staging, err := os.MkdirTemp(dataDir, restoreStagingPattern)
+	if err != nil {
+		return nil, "", cleanup, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	cleanup = func() { os.RemoveAll(staging) }
+
+	bw := NewBlockWriter(staging)
+	bw.SetLabels(BlockSourceBackfill, nil)
+	block, err := bw.WriteMemTable(mt)
+	if err != nil {
+		cleanup()
+		return nil, "", func() {}, fmt.Errorf("failed to write WAL samples: %w", err)
+	}
+	return nil, block.Dir(), cleanup, nil
+}
+
+// restoreBlock restores the block in src into dataDir, given the blocks
+// already there. It returns the block's target entry, or nil if it was
+// skipped.
+func restoreBlock(dataDir, src string, targets []restoreTarget, dryRun bool) (*RestoreResult, *restoreTarget, error) {
+	meta, err := readBlockMeta(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkBlockVersion(meta); err != nil {
+		return nil, nil, err
+	}
+
+	result := &RestoreResult{Block: meta.ULID, MinTime: meta.MinTime, MaxTime: meta.MaxTime}
+	for _, t := range targets {
+		if t.ulid != meta.ULID {
+			continue
+		}
+		if t.minTime == meta.MinTime && t.maxTime == meta.MaxTime && t.stats == meta.Stats {
+			result.Skipped = true
+			return result, nil, nil
+		}
+		result.Renamed = true
+	}
+
+	for _, t := range targets {
+		if t.minTime <= meta.MaxTime && t.maxTime >= meta.MinTime {
+			result.Overlaps = append(result.Overlaps, t.ulid)
+		}
+	}
+
+	if result.Renamed {
+		id, err := newBlockULID(uint64(meta.MinTime))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate ULID: %w", err)
+		}
+		meta.ULID = id.String()
+	}
+	target := &restoreTarget{ulid: meta.ULID, minTime: meta.MinTime, maxTime: meta.MaxTime, stats: meta.Stats}
+	if dryRun {
+		if !result.Renamed {
+			result.RestoredAs = meta.ULID
+		}
+		return result, target, nil
+	}
+
+	// Stage the copy under a name the database ignores
+	staging, err := os.MkdirTemp(dataDir, restoreStagingPattern)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	if err := copyBlockDir(src, staging); err != nil {
+		return nil, nil, err
+	}
+	if result.Renamed {
+		metaData, err := json.MarshalIndent(meta, "", "  ")
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to marshal metadata: %w", err)
+		}
+		if err := writeFileAtomic(filepath.Join(staging, MetaFile), metaData); err != nil {
+			return nil, nil, fmt.Errorf("failed to write metadata: %w", err)
+		}
+	}
+	if len(result.Overlaps) > 0 {
+		if err := writeFileAtomic(filepath.Join(staging, OverlappingFile), nil); err != nil {
+			return nil, nil, fmt.Errorf("failed to mark block as overlapping: %w", err)
+		}
+	}
+	if err := syncDir(staging); err != nil {
+		return nil, nil, fmt.Errorf("failed to sync block: %w", err)
+	}
+
+	// Renaming fails if the ULID was taken meanwhile
+	if err := os.Rename(staging, filepath.Join(dataDir, meta.ULID)); err != nil {
+		return nil, nil, fmt.Errorf("failed to move block into place: %w", err)
+	}
+	result.RestoredAs = meta.ULID
+	return result, target, nil
+}
+
+// copyBlockDir copies the files of the block in src into dst, syncing
+// each. Leftover temporary files are skipped.
+func copyBlockDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		if err := copyFile(path, target); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", rel, err)
+		}
+		return syncFile(target)
+	})
+}
+
+// syncFile syncs the file at path to disk
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
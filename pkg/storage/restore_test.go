@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+	"github.com/therealutkarshpriyadarshi/time/pkg/wal"
+)
+
+var restoreSeries = series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "a"})
+
+// writeRestoreBlock persists a block holding samples of restoreSeries
+func writeRestoreBlock(t *testing.T, dataDir string, samples ...series.Sample) *Block {
+	t.Helper()
+	block, err := NewBlock(samples[0].Timestamp, samples[len(samples)-1].Timestamp)
+	if err != nil {
+		t.Fatalf("NewBlock failed: %v", err)
+	}
+	if err := block.AddSeries(restoreSeries, samples); err != nil {
+		t.Fatalf("AddSeries failed: %v", err)
+	}
+	if err := block.Persist(dataDir); err != nil {
+		t.Fatalf("Persist failed: %v", err)
+	}
+	return block
+}
+
+func TestRestoreBackup(t *testing.T) {
+	backupDir := t.TempDir()
+	dataDir := t.TempDir()
+
+	a := writeRestoreBlock(t, backupDir, series.Sample{Timestamp: 1000, Value: 1}, series.Sample{Timestamp: 2000, Value: 1})
+	b := writeRestoreBlock(t, backupDir, series.Sample{Timestamp: 5000, Value: 1}, series.Sample{Timestamp: 6000, Value: 1})
+	d := writeRestoreBlock(t, backupDir, series.Sample{Timestamp: 8000, Value: 1}, series.Sample{Timestamp: 9000, Value: 1})
+
+	// The backup's WAL holds one sample inside b's time range
+	w, err := wal.Open(filepath.Join(backupDir, DefaultWALDir), nil)
+	if err != nil {
+		t.Fatalf("failed to open WAL: %v", err)
+	}
+	if err := w.Append(restoreSeries, []series.Sample{{Timestamp: 5500, Value: 1}}); err != nil {
+		t.Fatalf("failed to append to WAL: %v", err)
+	}
+	w.Close()
+
+	// c overlaps a; b is already restored; another block took d's ULID
+	c := writeRestoreBlock(t, dataDir, series.Sample{Timestamp: 2000, Value: 2}, series.Sample{Timestamp: 2500, Value: 2})
+	if err := copyBlockDir(b.Dir(), filepath.Join(dataDir, b.ULID.String())); err != nil {
+		t.Fatalf("failed to copy block: %v", err)
+	}
+	other := writeRestoreBlock(t, t.TempDir(), series.Sample{Timestamp: 8500, Value: 2})
+	meta, err := readBlockMeta(other.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.ULID = d.ULID.String()
+	metaData, _ := json.MarshalIndent(meta, "", "  ")
+	if err := writeFileAtomic(filepath.Join(other.Dir(), MetaFile), metaData); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(other.Dir(), filepath.Join(dataDir, d.ULID.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	// A dry run changes nothing
+	results, err := RestoreBackup(dataDir, backupDir, RestoreOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("dry run: expected 4 results, got %d", len(results))
+	}
+	if dirs, _ := blockDirs(dataDir); len(dirs) != 3 {
+		t.Fatalf("dry run changed the data directory: %d blocks", len(dirs))
+	}
+
+	results, err = RestoreBackup(dataDir, backupDir, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("RestoreBackup failed: %v", err)
+	}
+	byBlock := make(map[string]RestoreResult)
+	for _, r := range results {
+		byBlock[r.Block] = r
+	}
+
+	if r := byBlock[a.ULID.String()]; r.RestoredAs != a.ULID.String() || len(r.Overlaps) != 1 || r.Overlaps[0] != c.ULID.String() {
+		t.Errorf("unexpected result for overlapping block: %+v", r)
+	}
+	if r := byBlock[b.ULID.String()]; !r.Skipped {
+		t.Errorf("identical block not skipped: %+v", r)
+	}
+	r := byBlock[d.ULID.String()]
+	if !r.Renamed || r.RestoredAs == "" || r.RestoredAs == d.ULID.String() {
+		t.Errorf("conflicting block not restored under a new ULID: %+v", r)
+	}
+	if len(r.Overlaps) != 1 || r.Overlaps[0] != d.ULID.String() {
+		t.Errorf("conflicting block overlaps = %v, want [%s]", r.Overlaps, d.ULID)
+	}
+	if r := byBlock["wal"]; r.RestoredAs == "" || len(r.Overlaps) != 1 || r.Overlaps[0] != b.ULID.String() {
+		t.Errorf("unexpected result for WAL samples: %+v", r)
+	}
+
+	// Overlapping blocks are marked; nothing is left staged
+	for _, id := range []string{a.ULID.String(), byBlock["wal"].RestoredAs, byBlock[d.ULID.String()].RestoredAs} {
+		if _, err := os.Stat(filepath.Join(dataDir, id, OverlappingFile)); err != nil {
+			t.Errorf("block %s not marked as overlapping: %v", id, err)
+		}
+	}
+	entries, _ := os.ReadDir(dataDir)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".restore-") {
+			t.Errorf("staging directory %s left behind", entry.Name())
+		}
+	}
+
+	// Compaction merges each overlapping group into one block
+	compactor := NewCompactor(DefaultCompactorOptions(dataDir))
+	defer compactor.Stop()
+
+	plan, err := compactor.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	vertical := 0
+	for _, merge := range plan.Merges {
+		if merge.Vertical {
+			vertical++
+		}
+	}
+	if vertical != 3 {
+		t.Fatalf("expected 3 vertical merges, got %d: %+v", vertical, plan.Merges)
+	}
+
+	if err := compactor.CompactNow(); err != nil {
+		t.Fatalf("CompactNow failed: %v", err)
+	}
+	if n := compactor.GetStats().VerticalCompactions.Load(); n != 3 {
+		t.Errorf("VerticalCompactions = %d, want 3", n)
+	}
+
+	reader := NewBlockReader(dataDir)
+	if err := reader.LoadBlocks(); err != nil {
+		t.Fatalf("LoadBlocks failed: %v", err)
+	}
+	got := make(map[int64]float64)
+	for _, block := range reader.Blocks() {
+		if block.Overlapping() {
+			t.Errorf("compacted block %s still marked as overlapping", block.ULID)
+		}
+		samples, err := block.GetSeries(restoreSeries.Hash, 0, 10000)
+		if err != nil {
+			continue
+		}
+		for _, s := range samples {
+			if _, ok := got[s.Timestamp]; ok {
+				t.Errorf("timestamp %d in more than one block", s.Timestamp)
+			}
+			got[s.Timestamp] = s.Value
+		}
+	}
+	if len(got) != 9 {
+		t.Errorf("expected 9 distinct samples after compaction, got %v", got)
+	}
+
+	// The block with the later ULID wins duplicate timestamps
+	if got[2000] != 2 {
+		t.Errorf("value at 2000 = %v, want 2", got[2000])
+	}
+}
+
+func TestRestoreArchive(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.EnableRetention = false
+	opts.EnableCompaction = false
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	// One sample in a block, one only in the WAL
+	if err := db.Insert(restoreSeries, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	if err := db.Insert(restoreSeries, []series.Sample{{Timestamp: 2000, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	backup, err := db.Backup()
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	defer backup.Close()
+
+	dataDir := t.TempDir()
+	results, err := RestoreArchive(dataDir, io.NewSectionReader(backup, 0, backup.Size()), RestoreOptions{})
+	if err != nil {
+		t.Fatalf("RestoreArchive failed: %v", err)
+	}
+	if len(results) != 2 || results[1].Block != "wal" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	block, walBlock := results[0], results[1]
+	if block.RestoredAs == "" || len(block.Overlaps) != 0 {
+		t.Errorf("unexpected result for block: %+v", block)
+	}
+
+	// The WAL still holds the flushed sample, so its block overlaps
+	if walBlock.RestoredAs == "" || len(walBlock.Overlaps) != 1 || walBlock.Overlaps[0] != block.RestoredAs {
+		t.Errorf("unexpected result for WAL samples: %+v", walBlock)
+	}
+
+	entries, _ := os.ReadDir(dataDir)
+	if len(entries) != 2 {
+		t.Errorf("expected only the 2 restored blocks in the data directory, got %d entries", len(entries))
+	}
+}
@@ -3,9 +3,13 @@ package storage
 import (
 	"context"
 	"fmt"
+	"math"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
 )
 
 const (
@@ -27,6 +31,37 @@ type RetentionPolicy struct {
 
 	// Enabled indicates if retention policy is active
 	Enabled bool
+
+	// Rules override MaxAge for blocks whose labels match; the first
+	// matching rule applies
+	Rules []BlockRetentionRule
+
+	// Budgets cap the series and samples kept in matching blocks, e.g.
+	// per tenant; they apply to the blocks MaxAge and Rules keep
+	Budgets []RetentionBudget
+}
+
+// BlockRetentionRule keeps the blocks whose labels (see BlockLabelSource
+// and friends) match Matchers for MaxAge instead of the policy's MaxAge.
+// A zero MaxAge keeps them forever.
+type BlockRetentionRule struct {
+	Matchers index.Matchers
+	MaxAge   time.Duration
+}
+
+// cutoff returns the time before which the policy deletes block's data
+func (p RetentionPolicy) cutoff(block *Block, now time.Time) int64 {
+	maxAge := p.MaxAge
+	for _, rule := range p.Rules {
+		if matchBlockLabels(rule.Matchers, block.Labels) {
+			if rule.MaxAge == 0 {
+				return math.MinInt64
+			}
+			maxAge = rule.MaxAge
+			break
+		}
+	}
+	return now.Add(-maxAge).UnixMilli()
 }
 
 // RetentionManager manages data retention and garbage collection
@@ -37,10 +72,15 @@ type RetentionManager struct {
 
 	// State
 	mu      sync.RWMutex
+	cycleMu sync.Mutex // Held while a background cleanup runs
 	running atomic.Bool
+	paused  atomic.Bool // Background cleanups are skipped while set
 	ctx     context.Context
 	cancel  context.CancelFunc
 
+	// Cutoff of the most recent cleanup that deleted blocks (Unix milliseconds)
+	purgedBefore atomic.Int64
+
 	// Metrics
 	stats RetentionStats
 }
@@ -53,6 +93,7 @@ type RetentionStats struct {
 	CleanupErrors      atomic.Int64
 	TotalCleanups      atomic.Int64
 	SeriesGarbageCollected atomic.Int64
+	BlocksRewritten    atomic.Int64 // Blocks rewritten without the series over a budget
 }
 
 // RetentionManagerOptions configures the retention manager
@@ -97,7 +138,7 @@ func (rm *RetentionManager) Run() error {
 	}
 	defer rm.running.Store(false)
 
-	if !rm.policy.Enabled {
+	if !rm.IsEnabled() {
 		return fmt.Errorf("retention policy is disabled")
 	}
 
@@ -105,7 +146,7 @@ func (rm *RetentionManager) Run() error {
 	defer ticker.Stop()
 
 	// Run initial cleanup
-	if err := rm.cleanup(); err != nil {
+	if err := rm.backgroundCleanup(); err != nil {
 		rm.stats.CleanupErrors.Add(1)
 		// Log error but continue
 	}
@@ -113,7 +154,7 @@ func (rm *RetentionManager) Run() error {
 	for {
 		select {
 		case <-ticker.C:
-			if err := rm.cleanup(); err != nil {
+			if err := rm.backgroundCleanup(); err != nil {
 				rm.stats.CleanupErrors.Add(1)
 				// Log error but continue
 			}
@@ -129,26 +170,78 @@ func (rm *RetentionManager) Stop() error {
 	return nil
 }
 
+// backgroundCleanup runs a scheduled cleanup cycle unless retention is paused
+func (rm *RetentionManager) backgroundCleanup() error {
+	rm.cycleMu.Lock()
+	defer rm.cycleMu.Unlock()
+
+	if rm.paused.Load() {
+		return nil
+	}
+	return rm.cleanup()
+}
+
+// Pause stops background cleanups until Resume is called. It returns once
+// any cleanup in progress has finished, so no block is deleted by
+// retention afterwards. Explicit CleanupNow calls still run.
+func (rm *RetentionManager) Pause() {
+	rm.paused.Store(true)
+
+	// Wait out a running cleanup
+	rm.cycleMu.Lock()
+	rm.cycleMu.Unlock()
+}
+
+// Resume restarts background cleanups after Pause
+func (rm *RetentionManager) Resume() {
+	rm.paused.Store(false)
+}
+
+// Paused reports whether background cleanups are paused
+func (rm *RetentionManager) Paused() bool {
+	return rm.paused.Load()
+}
+
 // cleanup performs a single retention cleanup cycle
 func (rm *RetentionManager) cleanup() error {
 	rm.mu.RLock()
-	enabled := rm.policy.Enabled
-	maxAge := rm.policy.MaxAge
+	policy := rm.policy
 	rm.mu.RUnlock()
 
-	if !enabled {
+	if !policy.Enabled {
 		return nil
 	}
 
-	// Calculate cutoff time
-	cutoffTime := time.Now().Add(-maxAge).UnixMilli()
-
 	// Delete old blocks using the compactor
-	deletedCount, err := rm.compactor.CleanupOldBlocks(cutoffTime)
+	now := time.Now()
+	deletedCount, cutoffTime, err := rm.compactor.cleanupBlocks(func(block *Block) int64 {
+		return policy.cutoff(block, now)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to cleanup old blocks: %w", err)
 	}
 
+	if _, err := rm.compactor.purgeTrash(now); err != nil {
+		return fmt.Errorf("failed to empty trash: %w", err)
+	}
+
+	if deletedCount > 0 && cutoffTime > rm.purgedBefore.Load() {
+		rm.purgedBefore.Store(cutoffTime)
+	}
+
+	if len(policy.Budgets) > 0 {
+		result, err := rm.compactor.enforceBudgets(policy.Budgets)
+		if err != nil {
+			return fmt.Errorf("failed to enforce retention budgets: %w", err)
+		}
+		deletedCount += result.deleted
+		if result.purgedBefore > rm.purgedBefore.Load() {
+			rm.purgedBefore.Store(result.purgedBefore)
+		}
+		rm.stats.BlocksRewritten.Add(int64(result.rewritten))
+		rm.stats.SeriesGarbageCollected.Add(result.droppedSeries)
+	}
+
 	// Update metrics
 	rm.stats.BlocksDeleted.Add(int64(deletedCount))
 	rm.stats.TotalCleanups.Add(1)
@@ -172,52 +265,73 @@ func (rm *RetentionManager) GetPolicy() RetentionPolicy {
 }
 
 // GetStats returns a snapshot of retention statistics
-func (rm *RetentionManager) GetStats() RetentionStats {
+func (rm *RetentionManager) GetStats() *RetentionStats {
 	// Return a copy of the current stats
-	var stats RetentionStats
+	stats := &RetentionStats{}
 	stats.BlocksDeleted.Store(rm.stats.BlocksDeleted.Load())
 	stats.BytesReclaimed.Store(rm.stats.BytesReclaimed.Load())
 	stats.LastCleanupTime.Store(rm.stats.LastCleanupTime.Load())
 	stats.CleanupErrors.Store(rm.stats.CleanupErrors.Load())
 	stats.TotalCleanups.Store(rm.stats.TotalCleanups.Load())
 	stats.SeriesGarbageCollected.Store(rm.stats.SeriesGarbageCollected.Load())
+	stats.BlocksRewritten.Store(rm.stats.BlocksRewritten.Load())
 	return stats
 }
 
+// PurgedBefore returns the cutoff of the most recent cleanup that deleted
+// blocks, in Unix milliseconds. Data before it may have been removed.
+// Returns 0 if retention has not deleted anything yet.
+func (rm *RetentionManager) PurgedBefore() int64 {
+	return rm.purgedBefore.Load()
+}
+
 // CleanupNow triggers an immediate cleanup (for testing/debugging)
 func (rm *RetentionManager) CleanupNow() error {
 	return rm.cleanup()
 }
 
-// CalculateRetentionStats calculates statistics about data retention
+// CalculateRetentionStats calculates statistics about data retention,
+// listing the blocks the next cleanup would delete. Nothing is deleted.
 func (rm *RetentionManager) CalculateRetentionStats() (*RetentionStatsReport, error) {
 	rm.mu.RLock()
 	defer rm.mu.RUnlock()
 
-	// Load all blocks
+	// Load all blocks, excluding concurrent compactions and cleanups
+	rm.compactor.mu.Lock()
+	defer rm.compactor.mu.Unlock()
 	if err := rm.compactor.blockReader.LoadBlocks(); err != nil {
 		return nil, fmt.Errorf("failed to load blocks: %w", err)
 	}
 
 	blocks := rm.compactor.blockReader.Blocks()
-	cutoffTime := time.Now().Add(-rm.policy.MaxAge).UnixMilli()
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].MinTime < blocks[j].MinTime
+	})
+	now := time.Now()
+	cutoffTime := now.Add(-rm.policy.MaxAge).UnixMilli()
 
 	report := &RetentionStatsReport{
 		TotalBlocks:      len(blocks),
 		PolicyMaxAge:     rm.policy.MaxAge,
+		PolicyEnabled:    rm.policy.Enabled,
 		CutoffTime:       cutoffTime,
 	}
 
 	var totalSize int64
 	var eligibleForDeletionSize int64
+	var kept []*Block
 
 	for _, block := range blocks {
 		blockSize := block.Size()
 		totalSize += blockSize
 
-		if block.MaxTime < cutoffTime {
+		// Same rule as cleanup; a disabled policy deletes nothing
+		if rm.policy.Enabled && block.MaxTime < rm.policy.cutoff(block, now) && !block.Protected() {
 			report.BlocksEligibleForDeletion++
 			eligibleForDeletionSize += blockSize
+			report.EligibleBlocks = append(report.EligibleBlocks, summarizeBlock(block))
+		} else {
+			kept = append(kept, block)
 		}
 
 		// Calculate age
@@ -230,6 +344,25 @@ func (rm *RetentionManager) CalculateRetentionStats() (*RetentionStatsReport, er
 		}
 	}
 
+	// Budgets apply to the blocks left; those they delete are eligible too
+	if rm.policy.Enabled && len(rm.policy.Budgets) > 0 {
+		usages, actions, err := planBudgets(rm.policy.Budgets, kept)
+		if err != nil {
+			return nil, fmt.Errorf("failed to plan retention budgets: %w", err)
+		}
+		report.Budgets = usages
+		for _, action := range actions {
+			if action.drop == nil {
+				report.BlocksEligibleForDeletion++
+				eligibleForDeletionSize += action.block.Size()
+				report.EligibleBlocks = append(report.EligibleBlocks, summarizeBlock(action.block))
+			}
+		}
+		sort.Slice(report.EligibleBlocks, func(i, j int) bool {
+			return report.EligibleBlocks[i].MinTime < report.EligibleBlocks[j].MinTime
+		})
+	}
+
 	report.TotalDataSize = totalSize
 	report.ReclaimableSize = eligibleForDeletionSize
 
@@ -243,9 +376,18 @@ type RetentionStatsReport struct {
 	TotalDataSize             int64
 	ReclaimableSize           int64
 	PolicyMaxAge              time.Duration
+	PolicyEnabled             bool
 	CutoffTime                int64
 	OldestBlockAge            int64 // milliseconds
 	NewestBlockAge            int64 // milliseconds
+
+	// EligibleBlocks lists the blocks the next cleanup would delete,
+	// oldest first
+	EligibleBlocks []BlockSummary
+
+	// Budgets reports the usage of each of the policy's budgets and the
+	// blocks over it
+	Budgets []RetentionBudgetUsage
 }
 
 // String returns a human-readable representation of the retention stats
@@ -166,6 +166,49 @@ func TestRetentionManagerCleanup(t *testing.T) {
 	}
 }
 
+func TestRetentionManagerPauseResume(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	oldTime := time.Now().Add(-35 * 24 * time.Hour).UnixMilli()
+	oldBlock, _ := NewBlock(oldTime, oldTime+Level0Duration.Milliseconds())
+	testSeries := series.NewSeries(map[string]string{"__name__": "old_metric"})
+	oldBlock.AddSeries(testSeries, []series.Sample{{Timestamp: oldTime + 1000, Value: 1.0}})
+	if err := oldBlock.Persist(tmpDir); err != nil {
+		t.Fatalf("failed to persist block: %v", err)
+	}
+
+	compactor := NewCompactor(DefaultCompactorOptions(tmpDir))
+	defer compactor.Stop()
+
+	rm := NewRetentionManager(compactor, &RetentionManagerOptions{
+		Policy:   RetentionPolicy{MaxAge: 30 * 24 * time.Hour, Enabled: true},
+		Interval: time.Hour,
+	})
+	defer rm.Stop()
+
+	rm.Pause()
+	if !rm.Paused() {
+		t.Fatal("expected retention to be paused")
+	}
+	if err := rm.backgroundCleanup(); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if _, err := os.Stat(oldBlock.Dir()); err != nil {
+		t.Errorf("paused retention should not delete blocks: %v", err)
+	}
+
+	rm.Resume()
+	if rm.Paused() {
+		t.Fatal("expected retention to be resumed")
+	}
+	if err := rm.backgroundCleanup(); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if _, err := os.Stat(oldBlock.Dir()); !os.IsNotExist(err) {
+		t.Errorf("old block should have been deleted after resume, path: %s", oldBlock.Dir())
+	}
+}
+
 func TestRetentionManagerCalculateStats(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "retention_stats_test_*")
 	if err != nil {
@@ -229,6 +272,27 @@ func TestRetentionManagerCalculateStats(t *testing.T) {
 	if report.PolicyMaxAge != 30*24*time.Hour {
 		t.Errorf("expected max age 30 days, got %v", report.PolicyMaxAge)
 	}
+
+	// Eligible blocks are listed oldest first
+	if len(report.EligibleBlocks) != 2 {
+		t.Fatalf("expected 2 eligible blocks listed, got %d", len(report.EligibleBlocks))
+	}
+	for i, age := range []time.Duration{40 * 24 * time.Hour, 35 * 24 * time.Hour} {
+		block := report.EligibleBlocks[i]
+		if block.MinTime != now-age.Milliseconds() || block.MaxTime >= report.CutoffTime {
+			t.Errorf("unexpected eligible block %d: %+v", i, block)
+		}
+	}
+
+	// Nothing is deleted, and a disabled policy deletes nothing
+	rm.Disable()
+	report, err = rm.CalculateRetentionStats()
+	if err != nil {
+		t.Fatalf("failed to calculate stats: %v", err)
+	}
+	if report.TotalBlocks != 4 || report.BlocksEligibleForDeletion != 0 || len(report.EligibleBlocks) != 0 {
+		t.Errorf("unexpected report with retention disabled: %+v", report)
+	}
 }
 
 func TestRetentionManagerEnableDisable(t *testing.T) {
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// DefaultBlockScanInterval is how often the data directory is scanned for
+// blocks added by other tools, unless Options.BlockScanInterval says
+// otherwise
+const DefaultBlockScanInterval = time.Minute
+
+// blockScanner adopts blocks added by other tools every BlockScanInterval
+// until the TSDB is closed
+func (db *TSDB) blockScanner() {
+	defer close(db.blockScannerDone)
+
+	ticker := time.NewTicker(db.blockScanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := db.ScanBlocks(); err != nil && !errors.Is(err, ErrClosed) {
+				fmt.Printf("tsdb: block scan failed: %v\n", err)
+			}
+		}
+	}
+}
+
+// ScanBlocks looks for blocks added to the data and cold directories
+// since they were opened, by restores, backfills or other tools, and
+// starts serving them. Each new block is verified like on open first:
+// corrupt blocks are moved to quarantine, and blocks written by a newer
+// release are left unread. It returns the directories of the blocks
+// adopted. Tools must create a block under another name and rename it
+// into place once complete, as RestoreBackup does, or it may be
+// quarantined half-written.
+//
+// The block scanner calls it every Options.BlockScanInterval; without the
+// scanner, new blocks are read as soon as they appear and it does nothing.
+func (db *TSDB) ScanBlocks() ([]string, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+	db.scanMu.Lock()
+	defer db.scanMu.Unlock()
+
+	dirs, err := db.leases.unadopted(db.dataDir, db.coldDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var adopted []string
+	for _, dir := range dirs {
+		verifyErr := VerifyBlock(dir, db.verifyChunkSamples)
+		switch {
+		case errors.Is(verifyErr, ErrBlockCorrupt):
+			dest, err := QuarantineBlock(filepath.Dir(dir), dir)
+			if err != nil {
+				return adopted, err
+			}
+			db.stats.QuarantinedBlocks.Add(1)
+			fmt.Printf("tsdb: quarantined corrupt block %s to %s: %v\n", filepath.Base(dir), dest, verifyErr)
+		case verifyErr != nil:
+			if _, ok := db.refusedBlocks[dir]; !ok {
+				db.refusedBlocks[dir] = struct{}{}
+				fmt.Printf("tsdb: not serving block %s: %v\n", filepath.Base(dir), verifyErr)
+			}
+		default:
+			meta, err := readBlockMeta(dir)
+			if err != nil {
+				return adopted, err
+			}
+			if err := db.head.addBlockHashes(meta); err != nil {
+				return adopted, fmt.Errorf("block %s: %w", filepath.Base(dir), err)
+			}
+			db.leases.adopt(dir)
+			db.observeBlockMinTime(meta.MinTime)
+			db.stats.AdoptedBlocks.Add(1)
+			adopted = append(adopted, dir)
+			fmt.Printf("tsdb: adopted block %s\n", filepath.Base(dir))
+		}
+	}
+
+	if len(adopted) > 0 && db.compactor != nil {
+		db.compactor.blockReader.Invalidate()
+	}
+	return adopted, nil
+}
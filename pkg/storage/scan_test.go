@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// countSeries returns the number of cpu_usage series queries see
+func countSeries(t *testing.T, db *TSDB) int {
+	t.Helper()
+
+	q, err := db.Querier(0, 20000)
+	if err != nil {
+		t.Fatalf("Querier failed: %v", err)
+	}
+	defer q.Close()
+	set, err := q.Select(index.MustNewMatcher(index.MatchEqual, "__name__", "cpu_usage"))
+	if err != nil {
+		t.Fatalf("Select failed: %v", err)
+	}
+	n := 0
+	for set.Next() {
+		n++
+	}
+	return n
+}
+
+func TestScanBlocks(t *testing.T) {
+	dataDir := t.TempDir()
+	opts := DefaultOptions(dataDir)
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	opts.BlockScanInterval = time.Hour
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// Blocks dropped in by another tool aren't read before a scan
+	good := writeTestBlock(t, dataDir, 2)
+	bad := writeTestBlock(t, dataDir, 3)
+	if err := os.WriteFile(filepath.Join(bad.Dir(), MetaFile), []byte("{not json"), 0644); err != nil {
+		t.Fatalf("failed to corrupt meta: %v", err)
+	}
+	if n := countSeries(t, db); n != 0 {
+		t.Errorf("%d series visible before the scan, want 0", n)
+	}
+
+	adopted, err := db.ScanBlocks()
+	if err != nil {
+		t.Fatalf("ScanBlocks failed: %v", err)
+	}
+	if len(adopted) != 1 || adopted[0] != good.Dir() {
+		t.Errorf("adopted %v, want only %s", adopted, good.Dir())
+	}
+	if n := countSeries(t, db); n != 2 {
+		t.Errorf("%d series visible after the scan, want 2", n)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, QuarantineDir, bad.ULID.String())); err != nil {
+		t.Errorf("corrupt block not quarantined: %v", err)
+	}
+
+	stats := db.GetStatsSnapshot()
+	if stats.AdoptedBlocks != 1 || stats.QuarantinedBlocks != 1 {
+		t.Errorf("adopted %d, quarantined %d; want 1 each", stats.AdoptedBlocks, stats.QuarantinedBlocks)
+	}
+
+	// Blocks the TSDB writes itself are served without a scan
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "z"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 15000, Value: 1}}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	if n := countSeries(t, db); n != 3 {
+		t.Errorf("%d series visible after the flush, want 3", n)
+	}
+	if adopted, err := db.ScanBlocks(); err != nil || len(adopted) != 0 {
+		t.Errorf("second scan adopted %v, %v; want nothing", adopted, err)
+	}
+}
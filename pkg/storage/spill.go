@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+	"github.com/therealutkarshpriyadarshi/time/pkg/wal"
+)
+
+// SpillDir is the data directory subdirectory holding samples spilled
+// while the MemTable was full
+const SpillDir = "spill"
+
+// spill keeps samples that didn't fit in a full active MemTable on disk
+// until the next flush merges them into its block. The samples are in the
+// WAL already, so the spill is only a staging area: a spill left over from
+// a crash is deleted on open and its samples are replayed from the WAL.
+type spill struct {
+	mu      sync.Mutex
+	dir     string
+	log     *wal.WAL // nil while nothing is spilled
+	samples int64
+}
+
+// openSpill returns the spill of the data directory, deleting any left
+// over from a previous run
+func openSpill(dataDir string) (*spill, error) {
+	dir := filepath.Join(dataDir, SpillDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to remove stale spill: %w", err)
+	}
+	return &spill{dir: dir}, nil
+}
+
+// add spills the samples of records
+func (sp *spill) add(records []wal.Record) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.log == nil {
+		log, err := wal.Open(sp.dir, nil)
+		if err != nil {
+			return fmt.Errorf("failed to open spill: %w", err)
+		}
+		sp.log = log
+	}
+	if err := sp.log.AppendBatch(records); err != nil {
+		return fmt.Errorf("failed to spill samples: %w", err)
+	}
+	for _, r := range records {
+		sp.samples += int64(len(r.Samples))
+	}
+	return nil
+}
+
+// size returns the number of samples spilled
+func (sp *spill) size() int64 {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.samples
+}
+
+// take reads back the spilled samples, grouped by series in the order they
+// were spilled, and empties the spill
+func (sp *spill) take() ([]*series.Series, [][]series.Sample, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.log == nil {
+		return nil, nil, nil
+	}
+	entries, err := sp.log.Replay()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read spill: %w", err)
+	}
+
+	var batch []*series.Series
+	var samples [][]series.Sample
+	byHash := make(map[uint64]int)
+	for _, entry := range entries {
+		if entry.Series == nil {
+			continue
+		}
+		i, ok := byHash[entry.Series.Hash]
+		if !ok {
+			i = len(batch)
+			byHash[entry.Series.Hash] = i
+			batch = append(batch, entry.Series)
+			samples = append(samples, nil)
+		}
+		samples[i] = append(samples[i], entry.Samples...)
+	}
+
+	if err := sp.removeLocked(); err != nil {
+		return nil, nil, err
+	}
+	return batch, samples, nil
+}
+
+// remove deletes the spill's files
+func (sp *spill) remove() error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.removeLocked()
+}
+
+// removeLocked deletes the spill's files; sp.mu must be held
+func (sp *spill) removeLocked() error {
+	if sp.log != nil {
+		sp.log.Close()
+		sp.log = nil
+	}
+	sp.samples = 0
+	if err := os.RemoveAll(sp.dir); err != nil {
+		return fmt.Errorf("failed to remove spill: %w", err)
+	}
+	return nil
+}
+
+// spillSamples writes records to the spill instead of the full active
+// MemTable, once they are logged to the WAL, and makes the series known
+// to the head. The samples become queryable when the next flush merges
+// them.
+func (db *TSDB) spillSamples(records []wal.Record) error {
+	if err := db.spill.add(records); err != nil {
+		return fmt.Errorf("tsdb: %w", err)
+	}
+
+	total := 0
+	for _, r := range records {
+		if err := db.indexSeries(r.Series); err != nil {
+			return fmt.Errorf("tsdb: index update failed: %w", err)
+		}
+		db.metadata.Observe(r.Series.Labels[MetricNameLabel])
+		db.watchers.publish(r.Series, r.Samples)
+		total += len(r.Samples)
+	}
+	db.stats.TotalSamples.Add(int64(total))
+	db.stats.SpilledSamples.Add(int64(total))
+	return nil
+}
+
+// mergeSpill moves the spilled samples into the active MemTable, past its
+// size limit, so the flush about to cut it writes them to its block
+func (db *TSDB) mergeSpill() error {
+	if db.spill == nil {
+		return nil
+	}
+	batch, samples, err := db.spill.take()
+	if err != nil || len(batch) == 0 {
+		return err
+	}
+
+	active := db.head.activeMemTable()
+	active.size.Add(batchSize(samples))
+	db.stats.record(active.applyBatch(batch, samples))
+	db.stats.ActiveMemTableSize.Store(active.Size())
+	return nil
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestSpillOnFull(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultOptions(dir)
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	opts.FlushInterval = time.Hour
+	opts.MemTableSize = 10 * EstimatedBytesPerSample
+	opts.MemTableShards = 1
+	opts.SpillOnFull = true
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	// Hold flushes in their persist phase, so the MemTable taking writes
+	// fills up while the first one runs
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	unblock := func() { releaseOnce.Do(func() { close(release) }) }
+	defer unblock()
+	db.failpoints = func(phase flushPhase) error {
+		if phase == flushPhasePersist {
+			<-release
+		}
+		return nil
+	}
+
+	s := series.NewSeries(map[string]string{"__name__": "spill_test"})
+	const n = 30
+	for i := 1; i <= n; i++ {
+		if err := db.Insert(s, []series.Sample{{Timestamp: int64(i) * 1000, Value: float64(i)}}); err != nil {
+			t.Fatalf("insert %d failed: %v", i, err)
+		}
+	}
+	spilled := db.GetStatsSnapshot().SpillPending
+	if spilled == 0 {
+		t.Fatal("no samples spilled")
+	}
+	if _, err := os.Stat(filepath.Join(dir, SpillDir)); err != nil {
+		t.Errorf("spill directory missing: %v", err)
+	}
+
+	// The next flush writes the spilled samples to its block
+	unblock()
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	q, err := db.Querier(math.MinInt64, math.MaxInt64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	set, err := q.Select()
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	var samples []series.Sample
+	for set.Next() {
+		_, samples = set.At()
+	}
+	if len(samples) != n || samples[n-1].Value != n {
+		t.Errorf("got %v, want the %d samples written", samples, n)
+	}
+
+	stats := db.GetStatsSnapshot()
+	if stats.SpilledSamples != spilled || stats.SpillPending != 0 {
+		t.Errorf("spilled %d with %d pending, want %d and none pending", stats.SpilledSamples, stats.SpillPending, spilled)
+	}
+	if _, err := os.Stat(filepath.Join(dir, SpillDir)); !os.IsNotExist(err) {
+		t.Errorf("spill directory left behind: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// Storage is the embedding API of the database, modeled on Prometheus'
+// storage.Storage. Programs that use the TSDB as a storage backend should
+// depend on it rather than on *TSDB, so they can substitute a fake in tests.
+type Storage interface {
+	// Appender returns a new appender. Appenders are not safe for
+	// concurrent use; use one per goroutine.
+	Appender() Appender
+
+	// Querier returns a querier over samples in [mint, maxt] (Unix
+	// milliseconds, inclusive)
+	Querier(mint, maxt int64) (Querier, error)
+
+	Close() error
+}
+
+// Appender buffers samples and writes them on Commit. Once Commit or
+// Rollback has been called the appender cannot be reused.
+type Appender interface {
+	// Append buffers a sample for the series identified by labels. Labels
+	// and the timestamp bounds are checked immediately; the map is not
+	// retained.
+	Append(labels map[string]string, t int64, v float64) error
+
+	// Commit writes all buffered samples atomically: either all of them are
+	// written or none are
+	Commit() error
+
+	// Rollback discards all buffered samples
+	Rollback() error
+}
+
+// Querier reads series and labels within a fixed time range
+type Querier interface {
+	// Select returns the series matching all matchers that have samples in
+	// the querier's range, in the head or in persisted blocks, ordered by
+	// label set. No matchers select all series.
+	Select(matchers ...*index.Matcher) (SeriesSet, error)
+
+	// LabelNames returns the sorted label names of series in range
+	LabelNames() ([]string, error)
+
+	// LabelValues returns the sorted values of a label among series in range
+	LabelValues(name string) ([]string, error)
+
+	// Warnings describes the blocks whose data reads so far skipped. A
+	// block that can't be read doesn't fail the query: the series read
+	// from it are returned without its samples.
+	Warnings() []string
+
+	// Close releases the querier. Blocks replaced or deleted by
+	// compaction and retention while it was open are only removed from
+	// disk once every querier that can see them is closed.
+	Close() error
+}
+
+// BatchQuerier is a Querier with the lower-level reads the query engine
+// builds on: streaming samples in batches, so aggregations can be evaluated
+// without collecting every series' samples first, and reading the samples
+// of series one by one, so they can be read in parallel
+type BatchQuerier interface {
+	Querier
+
+	// SelectBatches returns the same series as Select, streaming their
+	// samples in batches of at most DefaultBatchSize
+	SelectBatches(matchers ...*index.Matcher) (BatchSeriesSet, error)
+
+	// Series returns the series Select would, ordered by label set,
+	// without reading their samples. Some may have no samples in range.
+	Series(matchers ...*index.Matcher) ([]*series.Series, error)
+
+	// Samples returns the samples of s in range, as the SeriesSet of
+	// Select does. Once Series has returned, it is safe to call from
+	// several goroutines at once.
+	Samples(s *series.Series) ([]series.Sample, error)
+}
+
+// SeriesSet iterates over the series returned by Select
+type SeriesSet interface {
+	// Next advances to the next series. Returns false when iteration is complete.
+	Next() bool
+
+	// At returns the current series and its samples, ordered by timestamp
+	// with one sample per timestamp; where the head and blocks disagree the
+	// last write wins. Only valid after Next returns true.
+	At() (*series.Series, []series.Sample)
+
+	// Err returns any error encountered during iteration
+	Err() error
+}
+
+// BatchSeriesSet iterates over the series returned by SelectBatches
+type BatchSeriesSet interface {
+	// Next advances to the next series. Returns false when iteration is complete.
+	Next() bool
+
+	// At returns the current series and an iterator over its samples,
+	// which yields at least one batch. Both are only valid until the next
+	// call to Next.
+	At() (*series.Series, SampleIterator)
+
+	// Err returns any error encountered during iteration
+	Err() error
+}
+
+// SampleIterator streams the samples of a series in batches, ordered by
+// timestamp with one sample per timestamp, as a SeriesSet returns them
+type SampleIterator interface {
+	// Next advances to the next batch. Returns false when iteration is complete.
+	Next() bool
+
+	// At returns the current batch. The slice is reused by the following
+	// batches, so it must not be retained or modified.
+	At() []series.Sample
+}
+
+// SummaryIterator is a SampleIterator that can describe its samples by a
+// ChunkSummary instead of decoding them
+type SummaryIterator interface {
+	SampleIterator
+
+	// Summary returns the summary of the series' samples and true when
+	// they are a single chunk lying entirely in the querier's range whose
+	// encoding records one. Only valid before the first call to Next;
+	// callers using the summary need not call Next at all.
+	Summary() (ChunkSummary, bool)
+}
+
+// Compile-time check that TSDB implements Storage
+var _ Storage = (*TSDB)(nil)
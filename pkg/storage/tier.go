@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// DefaultColdAfter is how old the data of a block must be before it is
+// moved to Options.ColdDir, unless Options.ColdAfter says otherwise
+const DefaultColdAfter = 7 * 24 * time.Hour
+
+// isCold reports whether block lives in the cold directory
+func (c *Compactor) isCold(block *Block) bool {
+	return c.coldDir != "" && filepath.Dir(block.Dir()) == filepath.Clean(c.coldDir)
+}
+
+// moveColdBlocks moves the blocks in the data directory whose data is all
+// older than the cold threshold to the cold directory, oldest first.
+// Protected blocks stay where they are. c.mu must be held.
+func (c *Compactor) moveColdBlocks(now time.Time) error {
+	if c.coldDir == "" {
+		return nil
+	}
+	// Merges since the blocks were loaded replaced some of them
+	if err := c.blockReader.LoadBlocks(); err != nil {
+		return fmt.Errorf("failed to load blocks: %w", err)
+	}
+
+	cutoff := now.Add(-c.coldAfter).UnixMilli()
+	for _, block := range c.blockReader.Blocks() {
+		if block.MaxTime >= cutoff || c.isCold(block) || block.Protected() {
+			continue
+		}
+		if err := c.moveBlock(block); err != nil {
+			return fmt.Errorf("block %s: %w", block.ULID, err)
+		}
+		c.stats.BlocksMovedCold.Add(1)
+		fmt.Printf("tsdb: moved block %s to cold storage %s\n", block.ULID, c.coldDir)
+	}
+	return nil
+}
+
+// moveBlock copies block into the cold directory under a temporary name,
+// then swaps the copy in for the original. Readers of the original keep
+// it until they are done. Rename can't cross filesystems, which the cold
+// directory usually is on.
+func (c *Compactor) moveBlock(block *Block) error {
+	defer c.blockReader.Invalidate()
+	id := block.ULID.String()
+	tmp := filepath.Join(c.coldDir, id+".tmp")
+	dst := filepath.Join(c.coldDir, id)
+
+	// Left behind by a move that was interrupted
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := c.io.wait(c.ctx, block.Size()); err != nil {
+		return err
+	}
+	if err := copyBlockDir(block.Dir(), tmp); err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("failed to copy block: %w", err)
+	}
+	if err := c.leases.move(block, tmp, dst); err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("failed to move block into place: %w", err)
+	}
+	return syncDir(c.coldDir)
+}
+
+// removeMovedBlocks cleans up after moves to coldDir interrupted by a
+// crash: blocks found in both directories were copied completely, so the
+// copy in dataDir is deleted, as are partial copies in coldDir
+func removeMovedBlocks(dataDir, coldDir string) error {
+	entries, err := os.ReadDir(coldDir)
+	if err != nil {
+		return fmt.Errorf("failed to read cold directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if id, ok := strings.CutSuffix(entry.Name(), ".tmp"); ok {
+			if _, err := ulid.Parse(id); err != nil {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(coldDir, entry.Name())); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := ulid.Parse(entry.Name()); err != nil {
+			continue // Skip non-ULID directories
+		}
+		hot := filepath.Join(dataDir, entry.Name())
+		if _, err := os.Stat(hot); err != nil {
+			continue
+		}
+		fmt.Printf("tsdb: removing block %s, already moved to cold storage\n", entry.Name())
+		if err := os.RemoveAll(hot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
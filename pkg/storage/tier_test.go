@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestMoveColdBlocks(t *testing.T) {
+	dataDir, coldDir := t.TempDir(), t.TempDir()
+	cpu := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	now := time.Now()
+
+	old := writeTenantBlock(t, dataDir, "a", now.Add(-3*time.Hour).UnixMilli(), cpu)
+	recent := writeTenantBlock(t, dataDir, "a", now.UnixMilli(), cpu)
+
+	opts := DefaultCompactorOptions(dataDir)
+	opts.ColdDir = coldDir
+	opts.ColdAfter = time.Hour
+	c := NewCompactor(opts)
+	defer c.Stop()
+
+	if err := c.compact(); err != nil {
+		t.Fatalf("compaction failed: %v", err)
+	}
+	if _, err := os.Stat(old.Dir()); !os.IsNotExist(err) {
+		t.Error("old block still in the data directory")
+	}
+	if _, err := os.Stat(filepath.Join(coldDir, old.ULID.String(), MetaFile)); err != nil {
+		t.Errorf("old block not in the cold directory: %v", err)
+	}
+	if _, err := os.Stat(recent.Dir()); err != nil {
+		t.Errorf("recent block was moved: %v", err)
+	}
+	if moved := c.stats.BlocksMovedCold.Load(); moved != 1 {
+		t.Errorf("%d blocks moved, want 1", moved)
+	}
+
+	// Emergency retention only frees space in the data directory
+	deleted, _, err := c.DeleteOldestBlocks(func() bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("emergency retention deleted %d blocks, want only the recent one", deleted)
+	}
+}
+
+func TestColdDirQueries(t *testing.T) {
+	dataDir, coldDir := t.TempDir(), t.TempDir()
+	cpu := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	now := time.Now()
+
+	old := writeTenantBlock(t, coldDir, "a", now.Add(-3*time.Hour).UnixMilli(), cpu)
+	writeTenantBlock(t, dataDir, "a", now.UnixMilli(), cpu)
+
+	// A move interrupted by a crash leaves a copy in both directories and
+	// a partial one
+	if err := copyBlockDir(old.Dir(), filepath.Join(dataDir, old.ULID.String())); err != nil {
+		t.Fatal(err)
+	}
+	partial := filepath.Join(coldDir, old.ULID.String()+".tmp")
+	if err := os.Mkdir(partial, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions(dataDir)
+	opts.ColdDir = coldDir
+	opts.CompactionInterval = time.Hour
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	for _, dir := range []string{filepath.Join(dataDir, old.ULID.String()), partial} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("%s left behind by the interrupted move", dir)
+		}
+	}
+
+	q, err := db.Querier(0, now.UnixMilli())
+	if err != nil {
+		t.Fatalf("Querier failed: %v", err)
+	}
+	defer q.Close()
+	set, err := q.Select(index.MustNewMatcher(index.MatchEqual, "__name__", "cpu_usage"))
+	if err != nil {
+		t.Fatalf("Select failed: %v", err)
+	}
+	if !set.Next() {
+		t.Fatalf("no series found: %v", set.Err())
+	}
+	if _, samples := set.At(); len(samples) != 2 {
+		t.Errorf("got %d samples, want one from each directory", len(samples))
+	}
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/observability"
+)
+
+const (
+	// DefaultSlowFlushThreshold is the flush duration above which a flush
+	// is logged
+	DefaultSlowFlushThreshold = 10 * time.Second
+
+	// DefaultSlowWALSyncThreshold is the WAL fsync duration above which a
+	// sync is logged
+	DefaultSlowWALSyncThreshold = 500 * time.Millisecond
+
+	// DefaultSlowCompactionThreshold is the duration above which a
+	// compaction merge is logged
+	DefaultSlowCompactionThreshold = time.Minute
+)
+
+// DurationStats summarizes the durations of an operation. Percentiles are
+// computed over the most recent observations.
+type DurationStats struct {
+	Count int64
+	P50   time.Duration
+	P99   time.Duration
+}
+
+// newDurationStats converts histogram statistics in seconds
+func newDurationStats(stats *observability.HistogramStats) DurationStats {
+	return DurationStats{
+		Count: stats.Count,
+		P50:   time.Duration(stats.P50 * float64(time.Second)),
+		P99:   time.Duration(stats.P99 * float64(time.Second)),
+	}
+}
+
+// Metrics returns the database's operational metrics: flush, WAL sync and
+// compaction durations among them
+func (db *TSDB) Metrics() *observability.Metrics {
+	return db.metrics
+}
+
+// walSyncObserver returns a wal.Options.OnSync hook recording sync
+// durations in metrics and logging syncs slower than threshold
+func walSyncObserver(metrics *observability.Metrics, threshold time.Duration) func(time.Duration) {
+	return func(d time.Duration) {
+		metrics.RecordWALSync(d)
+		if threshold > 0 && d > threshold {
+			fmt.Printf("tsdb: slow WAL sync took %s (threshold %s)\n", d, threshold)
+		}
+	}
+}
+
+// observeFlush records the duration of a flush that wrote block from mt,
+// logging it if it exceeded the slow flush threshold
+func (db *TSDB) observeFlush(d time.Duration, block *Block, mt *MemTable) {
+	db.metrics.RecordFlush(d)
+	if db.slowFlush > 0 && d > db.slowFlush {
+		fmt.Printf("tsdb: slow flush took %s (threshold %s): block=%s series=%d samples=%d bytes=%d\n",
+			d, db.slowFlush, block.ULID, mt.SeriesCount(), mt.SampleCount(), block.Size())
+	}
+}
+
+// observeMerge records the duration of a compaction merge, logging it if
+// it exceeded the slow merge threshold
+func (c *Compactor) observeMerge(d time.Duration, merge PlannedMerge, outputs []string, series int, bytes int64) {
+	if c.metrics != nil {
+		c.metrics.RecordCompaction(d, bytes)
+	}
+	if c.slowMerge > 0 && d > c.slowMerge {
+		fmt.Printf("tsdb: slow compaction merge took %s (threshold %s): level=%d sources=[%s] outputs=[%s] series=%d bytes=%d\n",
+			d, c.slowMerge, merge.FromLevel, strings.Join(merge.Sources, ","), strings.Join(outputs, ","), series, bytes)
+	}
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/observability"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestOperationDurations(t *testing.T) {
+	db, err := Open(DefaultOptions(t.TempDir()))
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	s := series.NewSeries(map[string]string{"__name__": "timing_test"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	stats := db.GetStatsSnapshot()
+	if stats.FlushDuration.Count != 1 || stats.FlushDuration.P99 <= 0 {
+		t.Errorf("unexpected flush durations %+v", stats.FlushDuration)
+	}
+	// The insert and the flush marker were both synced
+	if stats.WALSyncDuration.Count < 2 {
+		t.Errorf("unexpected WAL sync durations %+v", stats.WALSyncDuration)
+	}
+	if stats.CompactionDuration.Count != 0 {
+		t.Errorf("expected no compaction durations, got %+v", stats.CompactionDuration)
+	}
+}
+
+func TestCompactorRecordsMergeDuration(t *testing.T) {
+	dataDir := t.TempDir()
+	blocks := []*Block{writeTestBlock(t, dataDir, 2), writeTestBlock(t, dataDir, 3)}
+
+	metrics := observability.NewMetrics()
+	opts := DefaultCompactorOptions(dataDir)
+	opts.Metrics = metrics
+	opts.SlowMergeThreshold = 1 // Log every merge
+	compactor := NewCompactor(opts)
+	defer compactor.Stop()
+
+	if err := compactor.mergeBlocks(blocks); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+
+	if stats := metrics.CompactionDuration(); stats.Count != 1 {
+		t.Errorf("recorded %d merges, want 1", stats.Count)
+	}
+	if snapshot := metrics.Snapshot(); snapshot.CompactedBytesTotal <= 0 {
+		t.Errorf("expected compacted bytes to be recorded, got %d", snapshot.CompactedBytesTotal)
+	}
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Trace records what storage reads to answer a query: the blocks it
+// touches and, for each, the chunks it loads from disk, finds already in
+// memory and decodes. It explains why a query is slow, e.g. a panel whose
+// selector decodes thousands of chunks to return a handful of samples.
+//
+// A Trace is attached to a query through query.Query.Trace, or to a
+// request context with ContextWithTrace so code wrapping the HTTP API
+// can add it to its own spans (see Attributes). It is safe for concurrent
+// use, and a nil *Trace records nothing.
+type Trace struct {
+	mu         sync.Mutex
+	blocks     map[string]*BlockTrace // By ULID
+	headSeries int
+}
+
+// BlockTrace is what a traced query read from one block
+type BlockTrace struct {
+	ULID           string `json:"ulid"`
+	ChunksLoaded   int    `json:"chunksLoaded"`   // Read from disk
+	ChunkCacheHits int    `json:"chunkCacheHits"` // Already in memory, read earlier by the query
+	ChunksDecoded  int    `json:"chunksDecoded"`
+	SamplesDecoded int    `json:"samplesDecoded"` // Samples of decoded chunks within the query range
+	BloomSkips     int    `json:"bloomSkips"`     // Series the bloom filter ruled out without reading the block
+}
+
+// TraceSummary is a snapshot of a Trace, as returned in explain output
+type TraceSummary struct {
+	Blocks         []BlockTrace `json:"blocks"`     // In ULID order
+	HeadSeries     int          `json:"headSeries"` // Series whose unflushed samples were read
+	ChunksLoaded   int          `json:"chunksLoaded"`
+	ChunkCacheHits int          `json:"chunkCacheHits"`
+	ChunksDecoded  int          `json:"chunksDecoded"`
+	SamplesDecoded int          `json:"samplesDecoded"`
+	BloomSkips     int          `json:"bloomSkips"`
+}
+
+// NewTrace returns an empty trace
+func NewTrace() *Trace {
+	return &Trace{blocks: make(map[string]*BlockTrace)}
+}
+
+// traceKey is the context key of a Trace
+type traceKey struct{}
+
+// ContextWithTrace returns a copy of ctx carrying t
+func ContextWithTrace(ctx context.Context, t *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+// TraceFromContext returns the trace carried by ctx, or nil
+func TraceFromContext(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+// block returns the record of the block with ULID id. t.mu must be held.
+func (t *Trace) block(id string) *BlockTrace {
+	b, ok := t.blocks[id]
+	if !ok {
+		b = &BlockTrace{ULID: id}
+		t.blocks[id] = b
+	}
+	return b
+}
+
+// blockOpened records that the query reads the block with ULID id
+func (t *Trace) blockOpened(id string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.block(id)
+}
+
+// bloomSkip records a series the block's bloom filter ruled out
+func (t *Trace) bloomSkip(id string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.block(id).BloomSkips++
+}
+
+// chunkRead records a chunk read from the block, loaded from disk or not
+func (t *Trace) chunkRead(id string, loaded bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if b := t.block(id); loaded {
+		b.ChunksLoaded++
+	} else {
+		b.ChunkCacheHits++
+	}
+}
+
+// chunkDecoded records a chunk of the block decoded into samples
+func (t *Trace) chunkDecoded(id string, samples int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.block(id)
+	b.ChunksDecoded++
+	b.SamplesDecoded += samples
+}
+
+// headRead records a series whose samples were read from the head
+func (t *Trace) headRead() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.headSeries++
+}
+
+// Summary returns what the trace has recorded so far, with totals over
+// all blocks
+func (t *Trace) Summary() TraceSummary {
+	summary := TraceSummary{Blocks: []BlockTrace{}}
+	if t == nil {
+		return summary
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	summary.HeadSeries = t.headSeries
+	for _, b := range t.blocks {
+		summary.Blocks = append(summary.Blocks, *b)
+		summary.ChunksLoaded += b.ChunksLoaded
+		summary.ChunkCacheHits += b.ChunkCacheHits
+		summary.ChunksDecoded += b.ChunksDecoded
+		summary.SamplesDecoded += b.SamplesDecoded
+		summary.BloomSkips += b.BloomSkips
+	}
+	sort.Slice(summary.Blocks, func(i, j int) bool {
+		return summary.Blocks[i].ULID < summary.Blocks[j].ULID
+	})
+	return summary
+}
+
+// Attributes returns the summary as span attributes, named after the
+// OpenTelemetry conventions: counts as int64 and the ULIDs of the blocks
+// read as a []string, each of which maps onto an attribute.KeyValue
+func (t *Trace) Attributes() map[string]any {
+	summary := t.Summary()
+	ulids := make([]string, len(summary.Blocks))
+	for i, b := range summary.Blocks {
+		ulids[i] = b.ULID
+	}
+	return map[string]any{
+		"tsdb.blocks":            ulids,
+		"tsdb.head.series":       int64(summary.HeadSeries),
+		"tsdb.chunks.loaded":     int64(summary.ChunksLoaded),
+		"tsdb.chunks.cache_hits": int64(summary.ChunkCacheHits),
+		"tsdb.chunks.decoded":    int64(summary.ChunksDecoded),
+		"tsdb.samples.decoded":   int64(summary.SamplesDecoded),
+		"tsdb.bloom.skips":       int64(summary.BloomSkips),
+	}
+}
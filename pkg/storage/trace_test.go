@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestTracedQuerier(t *testing.T) {
+	dataDir := t.TempDir()
+	cpu := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	mem := series.NewSeries(map[string]string{"__name__": "mem_usage"})
+	first := writeTenantBlock(t, dataDir, "a", 1000, cpu, mem)
+	second := writeTenantBlock(t, dataDir, "a", 2000, cpu)
+
+	opts := DefaultOptions(dataDir)
+	opts.EnableRetention = false
+	opts.EnableCompaction = false
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+	if err := db.Insert(mem, []series.Sample{{Timestamp: 3000, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	trace := NewTrace()
+	q, err := db.TracedQuerier(0, 5000, nil, trace)
+	if err != nil {
+		t.Fatalf("TracedQuerier failed: %v", err)
+	}
+	defer q.Close()
+
+	set, err := q.Select()
+	if err != nil {
+		t.Fatalf("Select failed: %v", err)
+	}
+	for set.Next() {
+	}
+	if set.Err() != nil {
+		t.Fatalf("iteration failed: %v", set.Err())
+	}
+
+	summary := trace.Summary()
+	if len(summary.Blocks) != 2 || summary.Blocks[0].ULID != first.ULID.String() || summary.Blocks[1].ULID != second.ULID.String() {
+		t.Fatalf("traced blocks %+v, want %s and %s", summary.Blocks, first.ULID, second.ULID)
+	}
+	if summary.ChunksLoaded != 3 || summary.ChunkCacheHits != 0 {
+		t.Errorf("%d chunks loaded and %d cache hits, want 3 and 0", summary.ChunksLoaded, summary.ChunkCacheHits)
+	}
+	if summary.ChunksDecoded != 3 || summary.SamplesDecoded != 3 {
+		t.Errorf("%d chunks and %d samples decoded, want 3 of each", summary.ChunksDecoded, summary.SamplesDecoded)
+	}
+	if summary.HeadSeries != 1 {
+		t.Errorf("%d series read from the head, want 1", summary.HeadSeries)
+	}
+
+	// The chunks are in memory the second time
+	if _, err := q.Samples(cpu); err != nil {
+		t.Fatalf("Samples failed: %v", err)
+	}
+	if summary := trace.Summary(); summary.ChunkCacheHits != 2 || summary.ChunksLoaded != 3 {
+		t.Errorf("%d chunks loaded and %d cache hits after rereading a series, want 3 and 2", summary.ChunksLoaded, summary.ChunkCacheHits)
+	}
+
+	attrs := trace.Attributes()
+	if attrs["tsdb.chunks.decoded"] != int64(5) {
+		t.Errorf("tsdb.chunks.decoded = %v, want 5", attrs["tsdb.chunks.decoded"])
+	}
+	if blocks, _ := attrs["tsdb.blocks"].([]string); len(blocks) != 2 {
+		t.Errorf("tsdb.blocks = %v, want both blocks", attrs["tsdb.blocks"])
+	}
+}
+
+func TestTraceContext(t *testing.T) {
+	if trace := TraceFromContext(context.Background()); trace != nil {
+		t.Errorf("TraceFromContext of a context without a trace = %v", trace)
+	}
+	trace := NewTrace()
+	if got := TraceFromContext(ContextWithTrace(context.Background(), trace)); got != trace {
+		t.Errorf("TraceFromContext = %p, want %p", got, trace)
+	}
+
+	// A nil trace records nothing
+	var untraced *Trace
+	untraced.chunkRead("01H", true)
+	if summary := untraced.Summary(); len(summary.Blocks) != 0 || summary.ChunksLoaded != 0 {
+		t.Errorf("nil trace summary = %+v", summary)
+	}
+}
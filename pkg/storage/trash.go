@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+const (
+	// TrashDir is the subdirectory of the data directory, and of the cold
+	// directory, that blocks deleted by retention are moved to. It is not
+	// a ULID, so block listings ignore it.
+	TrashDir = "trash"
+
+	// DefaultTrashGracePeriod is how long blocks deleted by retention stay
+	// in TrashDir before they are removed for good
+	DefaultTrashGracePeriod = 24 * time.Hour
+)
+
+// moveToTrash moves the block in dir into the trash directory next to it,
+// as <ULID>.<Unix milliseconds of now>
+func moveToTrash(dir string, now time.Time) error {
+	trashDir := filepath.Join(filepath.Dir(dir), TrashDir)
+	if err := os.MkdirAll(trashDir, 0755); err != nil {
+		return fmt.Errorf("failed to create trash directory: %w", err)
+	}
+	dest := filepath.Join(trashDir, fmt.Sprintf("%s.%d", filepath.Base(dir), now.UnixMilli()))
+	if err := os.Rename(dir, dest); err != nil {
+		return fmt.Errorf("failed to move block %s to the trash: %w", filepath.Base(dir), err)
+	}
+	fmt.Printf("tsdb: moved block %s to %s\n", filepath.Base(dir), dest)
+	return nil
+}
+
+// deleteBlockDir deletes the block in dir, or moves it to the trash
+func deleteBlockDir(dir string, trash bool) error {
+	if trash {
+		return moveToTrash(dir, time.Now())
+	}
+	return os.RemoveAll(dir)
+}
+
+// parseTrashName returns the block ID and trash time of a trash directory
+// entry named by moveToTrash
+func parseTrashName(name string) (ulid.ULID, time.Time, bool) {
+	id, ms, ok := strings.Cut(name, ".")
+	if !ok {
+		return ulid.ULID{}, time.Time{}, false
+	}
+	blockID, err := ulid.Parse(id)
+	if err != nil {
+		return ulid.ULID{}, time.Time{}, false
+	}
+	n, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return ulid.ULID{}, time.Time{}, false
+	}
+	return blockID, time.UnixMilli(n), true
+}
+
+// purgeTrash deletes the blocks moved to the trash of dataDirs before
+// cutoff, returning the number deleted. Empty paths are skipped, and
+// entries moveToTrash didn't name are left alone.
+func purgeTrash(cutoff time.Time, dataDirs ...string) (int, error) {
+	purged := 0
+	for _, dataDir := range dataDirs {
+		if dataDir == "" {
+			continue
+		}
+		trashDir := filepath.Join(dataDir, TrashDir)
+		entries, err := os.ReadDir(trashDir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return purged, fmt.Errorf("failed to read trash directory: %w", err)
+		}
+		for _, entry := range entries {
+			id, trashed, ok := parseTrashName(entry.Name())
+			if !ok || !entry.IsDir() || !trashed.Before(cutoff) {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(trashDir, entry.Name())); err != nil {
+				return purged, err
+			}
+			fmt.Printf("tsdb: deleted block %s, in the trash since %s\n", id, trashed.UTC().Format(time.RFC3339))
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// trashBlock removes a block deleted by retention: it is moved to the
+// trash if there is a grace period, once the last query reading it is
+// done
+func (c *Compactor) trashBlock(block *Block) error {
+	defer c.blockReader.Invalidate()
+	return c.leases.remove(block, c.trashGracePeriod > 0)
+}
+
+// purgeTrash deletes the blocks that have been in the trash for longer
+// than the grace period, or all of them if there is none
+func (c *Compactor) purgeTrash(now time.Time) (int, error) {
+	return purgeTrash(now.Add(-c.trashGracePeriod), c.dataDir, c.coldDir)
+}
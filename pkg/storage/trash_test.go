@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// trashed returns the names of the entries in dataDir's trash
+func trashed(t *testing.T, dataDir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(dataDir, TrashDir))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestRetentionTrash(t *testing.T) {
+	dataDir := t.TempDir()
+	cpu := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	now := time.Now()
+
+	old := writeTenantBlock(t, dataDir, "a", now.Add(-3*time.Hour).UnixMilli(), cpu)
+	leased := writeTenantBlock(t, dataDir, "a", now.Add(-2*time.Hour).UnixMilli(), cpu)
+
+	opts := DefaultCompactorOptions(dataDir)
+	opts.TrashGracePeriod = time.Hour
+	c := NewCompactor(opts)
+	defer c.Stop()
+
+	// A query is still reading the second block
+	snap, err := c.leases.snapshot(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cutoff := now.Add(-time.Hour).UnixMilli()
+	if deleted, err := c.CleanupOldBlocks(cutoff); err != nil || deleted != 2 {
+		t.Fatalf("CleanupOldBlocks = %d, %v; want 2 blocks", deleted, err)
+	}
+	snap.Release()
+
+	for _, block := range []*Block{old, leased} {
+		if _, err := os.Stat(block.Dir()); !os.IsNotExist(err) {
+			t.Errorf("block %s still in the data directory", block.ULID)
+		}
+	}
+	names := trashed(t, dataDir)
+	if len(names) != 2 {
+		t.Fatalf("trash holds %v, want both blocks", names)
+	}
+	for _, name := range names {
+		if _, _, ok := parseTrashName(name); !ok {
+			t.Errorf("unexpected trash entry %q", name)
+		}
+	}
+
+	// Moving a block back undoes the deletion
+	id, _, _ := parseTrashName(names[0])
+	if err := os.Rename(filepath.Join(dataDir, TrashDir, names[0]), filepath.Join(dataDir, id.String())); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.blockReader.LoadBlocks(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(c.blockReader.Blocks()); n != 1 {
+		t.Errorf("%d blocks after moving one back, want 1", n)
+	}
+
+	// The other stays until the grace period is over
+	if purged, err := c.purgeTrash(now); err != nil || purged != 0 {
+		t.Errorf("purgeTrash within the grace period = %d, %v; want nothing", purged, err)
+	}
+	if purged, err := c.purgeTrash(now.Add(2 * time.Hour)); err != nil || purged != 1 {
+		t.Errorf("purgeTrash after the grace period = %d, %v; want 1 block", purged, err)
+	}
+	if names := trashed(t, dataDir); len(names) != 0 {
+		t.Errorf("trash holds %v after purging", names)
+	}
+}
+
+func TestRetentionWithoutTrash(t *testing.T) {
+	dataDir := t.TempDir()
+	cpu := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	now := time.Now()
+	writeTenantBlock(t, dataDir, "a", now.Add(-3*time.Hour).UnixMilli(), cpu)
+
+	c := NewCompactor(DefaultCompactorOptions(dataDir))
+	defer c.Stop()
+
+	if deleted, err := c.CleanupOldBlocks(now.Add(-time.Hour).UnixMilli()); err != nil || deleted != 1 {
+		t.Fatalf("CleanupOldBlocks = %d, %v; want 1 block", deleted, err)
+	}
+	if names := trashed(t, dataDir); len(names) != 0 {
+		t.Errorf("trash holds %v without a grace period", names)
+	}
+}
+
+func TestEmergencyRetentionEmptiesTrash(t *testing.T) {
+	dataDir := t.TempDir()
+	cpu := series.NewSeries(map[string]string{"__name__": "cpu_usage"})
+	now := time.Now()
+	writeTenantBlock(t, dataDir, "a", now.Add(-3*time.Hour).UnixMilli(), cpu)
+	recent := writeTenantBlock(t, dataDir, "a", now.UnixMilli(), cpu)
+
+	opts := DefaultCompactorOptions(dataDir)
+	opts.TrashGracePeriod = time.Hour
+	c := NewCompactor(opts)
+	defer c.Stop()
+
+	if _, err := c.CleanupOldBlocks(now.Add(-time.Hour).UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+	if names := trashed(t, dataDir); len(names) != 1 {
+		t.Fatalf("trash holds %v, want the old block", names)
+	}
+
+	// Emptying the trash is enough
+	calls := 0
+	deleted, _, err := c.DeleteOldestBlocks(func() bool {
+		calls++
+		return calls > 1
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 0 {
+		t.Errorf("emergency retention deleted %d blocks, want only the trash emptied", deleted)
+	}
+	if names := trashed(t, dataDir); len(names) != 0 {
+		t.Errorf("trash holds %v after emergency retention", names)
+	}
+	if _, err := os.Stat(recent.Dir()); err != nil {
+		t.Errorf("recent block deleted: %v", err)
+	}
+}
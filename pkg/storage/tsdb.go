@@ -6,22 +6,36 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
 	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/observability"
 	"github.com/therealutkarshpriyadarshi/time/pkg/series"
 	"github.com/therealutkarshpriyadarshi/time/pkg/wal"
 )
 
 var (
 	// ErrClosed indicates the TSDB is closed
-	ErrClosed = errors.New("tsdb: closed")
+	ErrClosed = tsdberrors.New(tsdberrors.Unavailable, "tsdb: closed")
 
 	// ErrReadOnly indicates the TSDB is in read-only mode
-	ErrReadOnly = errors.New("tsdb: read-only mode")
+	ErrReadOnly = tsdberrors.New(tsdberrors.Unavailable, "tsdb: read-only mode")
+
+	// ErrCompactionDisabled indicates compaction is not enabled
+	ErrCompactionDisabled = tsdberrors.New(tsdberrors.Unavailable, "tsdb: compaction not enabled")
+
+	// ErrRetentionDisabled indicates retention is not enabled
+	ErrRetentionDisabled = tsdberrors.New(tsdberrors.Unavailable, "tsdb: retention not enabled")
+
+	// ErrIngestStatsDisabled indicates ingest statistics are not enabled
+	ErrIngestStatsDisabled = tsdberrors.New(tsdberrors.Unavailable, "tsdb: ingest statistics not enabled")
+
+	// ErrInvalidOptions indicates Options failed validation
+	ErrInvalidOptions = tsdberrors.New(tsdberrors.Invalid, "tsdb: invalid options")
 )
 
 const (
@@ -30,6 +44,9 @@ const (
 
 	// DefaultWALDir is the default directory name for WAL files
 	DefaultWALDir = "wal"
+
+	// DefaultMaxWALSize is the WAL size that triggers a flush (512MB)
+	DefaultMaxWALSize = 512 * 1024 * 1024
 )
 
 // TSDB is the main time-series database orchestrator.
@@ -38,30 +55,101 @@ type TSDB struct {
 	// Configuration
 	dataDir       string
 	flushInterval time.Duration
+	maxSpan       time.Duration
+	maxWALSize    int64
+	validation    *ValidationOptions
+	samplePolicy  SamplePolicy
+	rateLimiter   *rateLimiter  // nil without a series rate limit
+	labelLimiter  *labelLimiter // nil without a label postings limit
+	relabeler     *relabeler    // nil without relabel rules
 
 	// Write path components
-	activeMemTable   *MemTable
-	flushingMemTable *MemTable
-	walWriter        *wal.WAL
-	blockWriter      *BlockWriter
+	head        *Head
+	walWriter   *wal.WAL
+	blockWriter *BlockWriter
+
+	// Metric metadata (type, help, unit)
+	metadata *MetadataStore
+
+	// Live subscriptions to ingested samples
+	watchers *watchHub
 
 	// Background operations (Phase 6)
 	compactor        *Compactor
 	retentionManager *RetentionManager
 
+	// Leases on blocks being read, shared with the compactor
+	leases *blockLeases
+
+	// Second block directory; see Options.ColdDir
+	coldDir string
+
+	// Block scanner; see Options.BlockScanInterval
+	blockScanInterval  time.Duration
+	verifyChunkSamples int
+	scanMu             sync.Mutex
+	refusedBlocks      map[string]struct{} // Logged as too new; guarded by scanMu
+	blockScannerDone   chan struct{}
+
+	// Paces compaction and retention I/O, shared with the compactor; nil
+	// if unlimited
+	io *ioScheduler
+
+	// Oldest timestamp in persisted blocks. Cached, and rescanned from block
+	// metadata when retention deletes blocks.
+	blockMinMu     sync.Mutex
+	blockMinTime   int64
+	blockMinValid  bool  // Whether any block exists
+	blockMinPurged int64 // PurgedBefore value the cache was computed at
+	blockMinLoaded bool
+
+	// Disk watchdog; see Options.MinFreeDiskBytes
+	minFreeDisk           int64
+	diskCheckInterval     time.Duration
+	emergencyRetention    bool
+	diskUsage             func(dir string) (free, total uint64, err error)
+	readOnly              atomic.Bool
+	emergencyPurgedBefore atomic.Int64 // Data before this time was deleted by emergency retention
+	diskWatchdogDone      chan struct{}
+
+	// Tenant the blocks belong to; see Options.Tenant
+	tenant string
+
+	// Writes per metric name and tenant; nil if disabled
+	ingest *ingestSketch
+
 	// Synchronization
-	mu          sync.RWMutex
 	flushMu     sync.Mutex
-	flushChan   chan struct{}
+	flushChan   chan chan error // Flush requests; a non-nil channel receives the result
 	flusherDone chan struct{}
 
 	// State
 	closed atomic.Bool
+	health healthTracker
 	ctx    context.Context
 	cancel context.CancelFunc
 
 	// Metrics
-	stats Stats
+	stats   Stats
+	metrics *observability.Metrics
+	replay  ReplayProgress // Set by Open, never changed afterwards
+
+	// Flushes taking longer than this are logged; 0 disables the log
+	slowFlush time.Duration
+
+	// Whether queriers retry a failed block read once before skipping it
+	retryBlockReads bool
+
+	// Timestamp bounds of written samples; nil accepts any timestamp
+	bounds *sampleBounds
+
+	// Samples written while the active MemTable was full; nil unless
+	// Options.SpillOnFull is set
+	spill *spill
+
+	// failpoints, if set, is called before each flush phase; an error
+	// aborts the flush there. Tests use it to simulate failures and crashes.
+	failpoints func(flushPhase) error
 }
 
 // Stats holds TSDB statistics
@@ -72,18 +160,194 @@ type Stats struct {
 	LastFlushTime    atomic.Int64 // Unix milliseconds
 	WALSize          atomic.Int64
 	ActiveMemTableSize atomic.Int64
+	QuarantinedBlocks atomic.Int64 // Corrupt blocks moved to quarantine on open or by a block scan
+	AdoptedBlocks     atomic.Int64 // Blocks added by other tools and found by a block scan
+	CorruptBlockReads atomic.Int64 // Block reads that failed and were skipped by queries
+
+	// Samples handled by the sample policy
+	DuplicatesOverwritten atomic.Int64
+	DuplicatesDropped     atomic.Int64 // Kept the first value (keep-first)
+	DuplicatesRejected    atomic.Int64
+	OutOfOrderSamples     atomic.Int64 // Accepted within the out-of-order window
+	OutOfOrderRejected    atomic.Int64
+
+	// Samples outside the timestamp bounds
+	TooOldRejected atomic.Int64
+	TooNewRejected atomic.Int64
+
+	// Samples of series dropped by relabel rules
+	RelabelDropped atomic.Int64
+
+	// Series found sharing a hash with another label set
+	HashCollisions atomic.Int64
+
+	// Samples spilled to disk while the active MemTable was full
+	SpilledSamples atomic.Int64
+
+	// Samples over the series rate limit
+	RateLimitedDropped    atomic.Int64
+	RateLimitedAggregated atomic.Int64
+
+	// New series rejected for a label over its postings limit
+	LabelLimitRejected atomic.Int64
+
+	// Disk watchdog
+	DiskFreeBytes          atomic.Int64
+	DiskTotalBytes         atomic.Int64
+	EmergencyBlocksDeleted atomic.Int64
 }
 
-// Options configures the TSDB
+// Options configures the TSDB. Open checks them with Validate.
 type Options struct {
 	DataDir            string
 	FlushInterval      time.Duration
 	WALOptions         *wal.Options
 	MemTableSize       int64
+	MemTableShards     int // Number of lock-striped MemTable shards; 0 means DefaultMemTableShards
 	EnableCompaction   bool
 	CompactionInterval time.Duration
 	EnableRetention    bool
 	RetentionPeriod    time.Duration
+
+	// TrashGracePeriod is how long blocks deleted by retention, rules and
+	// budgets stay in TrashDir, from where they can be moved back, before
+	// they are deleted for good. 0 deletes them at once. Emergency
+	// retention never uses the trash.
+	TrashGracePeriod time.Duration
+
+	// BlockRetention overrides RetentionPeriod for blocks whose labels
+	// match a rule; the first matching rule applies
+	BlockRetention []BlockRetentionRule
+
+	// PrecisionRules round the values of matching series to fewer
+	// significant digits when compaction merges data older than the rule's
+	// age, so cold data compresses better
+	PrecisionRules []PrecisionRule
+
+	// RetentionBudgets cap the series and samples kept in matching blocks,
+	// e.g. per tenant, deleting or rewriting the oldest blocks over them
+	RetentionBudgets []RetentionBudget
+
+	// Tenant, if set, is recorded as BlockLabelTenant in the blocks
+	// written, so queries and retention can tell them apart from blocks
+	// restored from elsewhere
+	Tenant string
+
+	// Caps on blocks produced by compaction; see CompactorOptions
+	CompactionMaxBlockBytes  int64
+	CompactionMaxBlockSeries int
+
+	// CompactionLevels is the compaction schedule: the block duration and
+	// fan-in of each level above level 0. nil means
+	// DefaultCompactionLevels().
+	CompactionLevels []CompactionLevelConfig
+
+	// BackgroundIOBytesPerSecond paces the block reads and writes of
+	// compaction and retention; 0 means no limit
+	BackgroundIOBytesPerSecond int64
+
+	// BackgroundIOPauseLatency holds compaction and retention I/O for
+	// BackgroundIOPause after a query takes longer than it; 0 disables
+	// the pause
+	BackgroundIOPauseLatency time.Duration
+
+	// ColdDir, if set, is a second directory for blocks, e.g. on slower
+	// and cheaper disks. Compaction moves blocks whose data is all older
+	// than ColdAfter there, and queries read both directories. Requires
+	// compaction. ColdAfter 0 means DefaultColdAfter.
+	ColdDir   string
+	ColdAfter time.Duration
+
+	// BlockScanInterval is how often the data and cold directories are
+	// scanned for blocks added by other tools, such as restores and
+	// backfills. New blocks are verified before queries read them; see
+	// TSDB.ScanBlocks. 0 disables scanning, and blocks added while open
+	// are then read unverified as soon as they appear.
+	BlockScanInterval time.Duration
+
+	// Validation limits applied to series labels on insert.
+	// If nil, DefaultValidationOptions is used.
+	Validation *ValidationOptions
+
+	// VerifyChunkSamples is the number of chunk files per block checksummed
+	// on open; 0 means DefaultVerifyChunkSamples and negative means all
+	VerifyChunkSamples int
+
+	// SamplePolicy controls duplicate and out-of-order samples. The zero
+	// value overwrites duplicates and accepts any out-of-order sample.
+	SamplePolicy SamplePolicy
+
+	// SeriesRateLimit caps the samples each series may write per window,
+	// dropping or aggregating those over it. The zero value disables it.
+	SeriesRateLimit SeriesRateLimit
+
+	// LabelPostingsLimit caps the head index memory of each label name,
+	// rejecting or warning about new series carrying a label over it. The
+	// zero value disables it.
+	LabelPostingsLimit LabelPostingsLimit
+
+	// RejectBeforeRetention rejects samples older than the retention
+	// horizon (RetentionPeriod before now), which retention would delete as
+	// soon as they reached a block; it requires EnableRetention.
+	// MaxFutureSkew, if positive, rejects samples further ahead of the
+	// clock, such as those of clients with skewed clocks. Rejected samples
+	// are left out of a write and reported by an OutOfBoundsError; the
+	// others are written.
+	RejectBeforeRetention bool
+	MaxFutureSkew         time.Duration
+
+	// Relabel rewrites or drops the labels of written series, applied in
+	// order before they are validated (see RelabelConfig)
+	Relabel []RelabelConfig
+
+	// IngestStatsCapacity is how many metric name and tenant pairs the
+	// ingest statistics (see TSDB.IngestStats) track at once; 0 disables
+	// them
+	IngestStatsCapacity int
+
+	// SpillOnFull writes samples that don't fit in the full active
+	// MemTable, while a flush is still running, to a spill file on disk
+	// instead of failing with ErrMemTableFull. The next flush merges them
+	// into its block; until then they are not queryable.
+	SpillOnFull bool
+
+	// RetryBlockReads retries a block read that fails during a query once
+	// before the query skips that block's data, to ride out transient I/O
+	// errors
+	RetryBlockReads bool
+
+	// Besides when it is full, the active MemTable is flushed on the next
+	// flush check once its samples span more than MaxMemTableSpan, or the
+	// WAL has grown past MaxWALSize bytes. 0 disables either trigger.
+	MaxMemTableSpan time.Duration
+	MaxWALSize      int64
+
+	// MinFreeDiskBytes enables the disk watchdog: once free space under
+	// DataDir drops below it, the TSDB turns read-only, rejecting writes
+	// with ErrReadOnly and writing no blocks, until space is freed. With
+	// EmergencyRetention (which needs compaction), the oldest unprotected
+	// blocks are deleted first to try to stay writable. 0 disables the
+	// watchdog; DiskCheckInterval defaults to DefaultDiskCheckInterval.
+	MinFreeDiskBytes   int64
+	DiskCheckInterval  time.Duration
+	EmergencyRetention bool
+
+	// Flushes, WAL syncs and compaction merges taking longer than these
+	// thresholds are logged with the blocks and series involved. Their
+	// durations are recorded in Metrics either way. 0 disables the log.
+	SlowFlushThreshold      time.Duration
+	SlowWALSyncThreshold    time.Duration
+	SlowCompactionThreshold time.Duration
+
+	// ReplayProgress, if set, is called from Open as the WAL is replayed:
+	// after each segment, and once more when replay is done.
+	ReplayProgress func(ReplayProgress)
+
+	// ReplaySkipFlushed skips WAL samples at or before the newest block's
+	// MaxTime on replay, as they were flushed already, unless blocks were
+	// quarantined on open. Samples older than that block but written after
+	// it was flushed, such as out-of-order ones, are lost.
+	ReplaySkipFlushed bool
 }
 
 // DefaultOptions returns default TSDB options
@@ -93,10 +357,23 @@ func DefaultOptions(dataDir string) *Options {
 		FlushInterval:      DefaultFlushInterval,
 		WALOptions:         wal.DefaultOptions(),
 		MemTableSize:       DefaultMaxSize,
+		MemTableShards:     DefaultMemTableShards,
 		EnableCompaction:   true,
 		CompactionInterval: DefaultCompactionInterval,
 		EnableRetention:    true,
 		RetentionPeriod:    DefaultRetentionPeriod,
+		TrashGracePeriod:   DefaultTrashGracePeriod,
+		Validation:         DefaultValidationOptions(),
+		MaxMemTableSpan:    DefaultBlockDuration,
+		MaxWALSize:         DefaultMaxWALSize,
+		ColdAfter:          DefaultColdAfter,
+		BlockScanInterval:  DefaultBlockScanInterval,
+
+		IngestStatsCapacity: DefaultIngestStatsCapacity,
+
+		SlowFlushThreshold:      DefaultSlowFlushThreshold,
+		SlowWALSyncThreshold:    DefaultSlowWALSyncThreshold,
+		SlowCompactionThreshold: DefaultSlowCompactionThreshold,
 	}
 }
 
@@ -106,46 +383,175 @@ func Open(opts *Options) (*TSDB, error) {
 		return nil, fmt.Errorf("tsdb: options cannot be nil")
 	}
 
+	// Validate a copy so the caller's options are left untouched
+	validated := *opts
+	if err := validated.Validate(); err != nil {
+		return nil, err
+	}
+	opts = &validated
+
+	relabeler, err := newRelabeler(opts.Relabel)
+	if err != nil {
+		return nil, fmt.Errorf("tsdb: %w", err)
+	}
+
 	// Create data directory
 	if err := os.MkdirAll(opts.DataDir, 0755); err != nil {
 		return nil, fmt.Errorf("tsdb: failed to create data directory: %w", err)
 	}
 
-	// Open WAL
+	// Move corrupt blocks aside rather than failing or serving bad data
+	quarantined, err := QuarantineCorruptBlocks(opts.DataDir, opts.VerifyChunkSamples)
+	for _, q := range quarantined {
+		fmt.Printf("tsdb: quarantined corrupt block %s to %s: %v\n", q.Block, q.Path, q.Reason)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tsdb: %w", err)
+	}
+
+	// Refuse to start on blocks written by a newer release
+	if err := CheckFormatVersions(opts.DataDir); err != nil {
+		return nil, fmt.Errorf("tsdb: %w", err)
+	}
+
+	// The cold directory gets the same checks, after finishing moves to
+	// it interrupted by a crash
+	if opts.ColdDir != "" {
+		if err := os.MkdirAll(opts.ColdDir, 0755); err != nil {
+			return nil, fmt.Errorf("tsdb: failed to create cold directory: %w", err)
+		}
+		if err := removeMovedBlocks(opts.DataDir, opts.ColdDir); err != nil {
+			return nil, fmt.Errorf("tsdb: %w", err)
+		}
+		coldQuarantined, err := QuarantineCorruptBlocks(opts.ColdDir, opts.VerifyChunkSamples)
+		for _, q := range coldQuarantined {
+			fmt.Printf("tsdb: quarantined corrupt block %s to %s: %v\n", q.Block, q.Path, q.Reason)
+		}
+		quarantined = append(quarantined, coldQuarantined...)
+		if err != nil {
+			return nil, fmt.Errorf("tsdb: %w", err)
+		}
+		if err := CheckFormatVersions(opts.ColdDir); err != nil {
+			return nil, fmt.Errorf("tsdb: %w", err)
+		}
+	}
+
+	// Open WAL, timing its syncs
+	metrics := observability.NewMetrics()
+	walOpts := *opts.WALOptions
+	walOpts.OnSync = walSyncObserver(metrics, opts.SlowWALSyncThreshold)
 	walDir := filepath.Join(opts.DataDir, DefaultWALDir)
-	walWriter, err := wal.Open(walDir, opts.WALOptions)
+	walWriter, err := wal.Open(walDir, &walOpts)
 	if err != nil {
 		return nil, fmt.Errorf("tsdb: failed to open WAL: %w", err)
 	}
 
+	var spill *spill
+	if opts.SpillOnFull {
+		if spill, err = openSpill(opts.DataDir); err != nil {
+			walWriter.Close()
+			return nil, fmt.Errorf("tsdb: %w", err)
+		}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	db := &TSDB{
-		dataDir:        opts.DataDir,
-		flushInterval:  opts.FlushInterval,
-		activeMemTable: NewMemTableWithSize(opts.MemTableSize),
-		walWriter:      walWriter,
-		blockWriter:    NewBlockWriter(opts.DataDir),
-		flushChan:      make(chan struct{}, 1),
-		flusherDone:    make(chan struct{}),
-		ctx:            ctx,
-		cancel:         cancel,
+		dataDir:            opts.DataDir,
+		coldDir:            opts.ColdDir,
+		blockScanInterval:  opts.BlockScanInterval,
+		verifyChunkSamples: opts.VerifyChunkSamples,
+		refusedBlocks:      make(map[string]struct{}),
+		blockScannerDone:   make(chan struct{}),
+		flushInterval:      opts.FlushInterval,
+		maxSpan:            opts.MaxMemTableSpan,
+		maxWALSize:         opts.MaxWALSize,
+		validation:         opts.Validation,
+		samplePolicy:       opts.SamplePolicy,
+		rateLimiter:        newRateLimiter(opts.SeriesRateLimit),
+		labelLimiter:       newLabelLimiter(opts.LabelPostingsLimit),
+		relabeler:          relabeler,
+		head:               NewHead(opts.MemTableSize, opts.MemTableShards, opts.SamplePolicy),
+		walWriter:          walWriter,
+		blockWriter:        NewBlockWriter(opts.DataDir),
+		metadata:           NewMetadataStore(opts.DataDir),
+		watchers:           newWatchHub(),
+		leases:             newBlockLeases(),
+		io:                 newIOScheduler(opts.BackgroundIOBytesPerSecond, opts.BackgroundIOPauseLatency),
+		flushChan:          make(chan chan error, 1),
+		flusherDone:        make(chan struct{}),
+		minFreeDisk:        opts.MinFreeDiskBytes,
+		diskCheckInterval:  opts.DiskCheckInterval,
+		emergencyRetention: opts.EmergencyRetention,
+		diskUsage:          diskUsage,
+		diskWatchdogDone:   make(chan struct{}),
+		tenant:             opts.Tenant,
+		ingest:             newIngestSketch(opts.IngestStatsCapacity),
+		metrics:            metrics,
+		slowFlush:          opts.SlowFlushThreshold,
+		retryBlockReads:    opts.RetryBlockReads,
+		bounds:             newSampleBounds(opts),
+		spill:              spill,
+		ctx:                ctx,
+		cancel:             cancel,
+	}
+
+	db.stats.QuarantinedBlocks.Store(int64(len(quarantined)))
+	if opts.Tenant != "" {
+		db.blockWriter.SetLabels(BlockSourceFlush, map[string]string{BlockLabelTenant: opts.Tenant})
+	}
+
+	// Blocks being flushed stay hidden until complete. With the block
+	// scanner, blocks that appear later are only read once verified.
+	db.blockWriter.mkdir = db.leases.create
+	if db.blockScanInterval > 0 {
+		if err := db.leases.enableAdoption(db.dataDir, db.coldDir); err != nil {
+			walWriter.Close()
+			return nil, fmt.Errorf("tsdb: %w", err)
+		}
+	}
+
+	// Load metric metadata
+	if err := db.metadata.Load(); err != nil {
+		walWriter.Close()
+		return nil, fmt.Errorf("tsdb: failed to load metadata: %w", err)
+	}
+
+	// Keep series stored under collision hashes on them
+	if err := db.loadBlockHashes(db.dataDir, db.coldDir); err != nil {
+		walWriter.Close()
+		return nil, fmt.Errorf("tsdb: failed to load series hashes: %w", err)
 	}
 
 	// Recover from WAL
-	if err := db.recover(); err != nil {
+	if err := db.recover(opts, len(quarantined) > 0); err != nil {
 		walWriter.Close()
 		return nil, fmt.Errorf("tsdb: failed to recover: %w", err)
 	}
+	db.head.churn.reset() // Replayed series weren't created now
 
 	// Initialize compactor (Phase 6)
 	if opts.EnableCompaction {
 		compactorOpts := &CompactorOptions{
-			DataDir:     opts.DataDir,
-			Interval:    opts.CompactionInterval,
-			Concurrency: 1,
+			DataDir:        opts.DataDir,
+			Interval:       opts.CompactionInterval,
+			Concurrency:    1,
+			MaxBlockBytes:  opts.CompactionMaxBlockBytes,
+			MaxBlockSeries: opts.CompactionMaxBlockSeries,
+			Levels:         opts.CompactionLevels,
+			PrecisionRules: opts.PrecisionRules,
+			ColdDir:        opts.ColdDir,
+			ColdAfter:      opts.ColdAfter,
+
+			TrashGracePeriod: opts.TrashGracePeriod,
+
+			Metrics:            metrics,
+			SlowMergeThreshold: opts.SlowCompactionThreshold,
 		}
 		db.compactor = NewCompactor(compactorOpts)
+		db.compactor.leases = db.leases
+		db.compactor.io = db.io
+		db.compactor.blockReader.visible = db.leases.visible
 		go db.compactor.Run()
 	}
 
@@ -156,6 +562,8 @@ func Open(opts *Options) (*TSDB, error) {
 				MaxAge:     opts.RetentionPeriod,
 				MinSamples: 0,
 				Enabled:    true,
+				Rules:      opts.BlockRetention,
+				Budgets:    opts.RetentionBudgets,
 			},
 			Interval: DefaultRetentionCheckInterval,
 		}
@@ -163,37 +571,105 @@ func Open(opts *Options) (*TSDB, error) {
 		go db.retentionManager.Run()
 	}
 
+	// Start the disk watchdog, checking once up front so a full disk is
+	// caught before any write
+	if db.minFreeDisk > 0 {
+		if err := db.checkDisk(); err != nil {
+			fmt.Printf("tsdb: disk check failed: %v\n", err)
+		}
+		go db.diskWatchdog()
+	} else {
+		close(db.diskWatchdogDone)
+	}
+
+	// Start the block scanner
+	if db.blockScanInterval > 0 {
+		go db.blockScanner()
+	} else {
+		close(db.blockScannerDone)
+	}
+
 	// Start background flusher
 	go db.backgroundFlusher()
 
 	return db, nil
 }
 
-// Insert adds samples for a series to the TSDB
+// Insert adds samples for a series to the TSDB, after applying the relabel
+// rules to its labels; a series dropped by them is discarded without an
+// error. Samples outside the timestamp bounds are left out and reported by
+// an *OutOfBoundsError.
 func (db *TSDB) Insert(s *series.Series, samples []series.Sample) error {
 	if db.closed.Load() {
 		return ErrClosed
 	}
+	if db.readOnly.Load() {
+		return ErrReadOnly
+	}
 
 	if s == nil || len(samples) == 0 {
 		return ErrInvalidSample
 	}
 
-	db.mu.RLock()
-	activeMemTable := db.activeMemTable
-	db.mu.RUnlock()
+	// Rewrite the labels, discarding the series if a rule drops it
+	s, keep := db.relabeler.relabel(s)
+	if !keep {
+		db.stats.RelabelDropped.Add(int64(len(samples)))
+		return nil
+	}
+
+	// Reject malformed labels before they reach the WAL and index
+	if err := db.validation.ValidateLabels(s.Labels); err != nil {
+		db.ingest.record(s, 0, int64(len(samples)), 0)
+		return err
+	}
+
+	// Keep the series apart from another label set sharing its hash
+	s = db.resolveHash(s, nil)
+
+	// Reject a new series carrying a label over its postings limit
+	if err := db.checkLabelLimit(s); err != nil {
+		db.ingest.record(s, 0, int64(len(samples)), 0)
+		return err
+	}
+
+	// Leave out samples outside the timestamp bounds, reporting them once
+	// the rest are written
+	samples, rejected := db.bounds.filter(s, samples, nil)
+	for _, r := range rejected {
+		db.stats.recordBounds(r.Err)
+	}
+
+	// Drop or aggregate the samples of a series writing too fast
+	samples, limited := db.rateLimiter.apply(s, samples)
+	db.stats.recordRate(limited)
+	if len(samples) == 0 {
+		db.ingest.record(s, 0, int64(len(rejected))+limited.dropped, 0)
+		return boundsError(rejected)
+	}
+
+	activeMemTable := db.head.activeMemTable()
+
+	// Reject duplicate and out-of-order samples before they are logged
+	if counts, err := activeMemTable.check(s, samples); err != nil {
+		db.stats.record(counts)
+		db.ingest.record(s, 0, int64(len(rejected)+len(samples))+limited.dropped, 0)
+		return err
+	}
 
 	// 1. Write to WAL first (durability)
-	if err := db.walWriter.Append(s, samples); err != nil {
+	err := db.walWriter.Append(s, samples)
+	db.health.appended(err)
+	if err != nil {
 		return fmt.Errorf("tsdb: WAL append failed: %w", err)
 	}
 
 	// 2. Insert into active MemTable
-	err := activeMemTable.Insert(s, samples)
+	counts, err := activeMemTable.insertSamples(s, samples)
 	if err == ErrMemTableFull {
 		// Trigger flush
 		select {
-		case db.flushChan <- struct{}{}:
+		case db.flushChan <- nil:
 		default:
 			// Flush already pending
 		}
@@ -201,56 +677,164 @@ func (db *TSDB) Insert(s *series.Series, samples []series.Sample) error {
 		// Wait a bit and retry
 		time.Sleep(10 * time.Millisecond)
 
-		db.mu.RLock()
-		activeMemTable = db.activeMemTable
-		db.mu.RUnlock()
+		activeMemTable = db.head.activeMemTable()
 
-		err = activeMemTable.Insert(s, samples)
+		counts, err = activeMemTable.insertSamples(s, samples)
+	}
+	if err == ErrMemTableFull && db.spill != nil {
+		if err := db.spillSamples([]wal.Record{{Series: s, Samples: samples}}); err != nil {
+			return err
+		}
+		db.ingest.record(s, int64(len(samples)), int64(len(rejected))+limited.dropped, 0)
+		return boundsError(rejected)
 	}
 
 	if err != nil {
 		return fmt.Errorf("tsdb: memtable insert failed: %w", err)
 	}
+	db.stats.record(counts)
+
+	// 3. Register new series in the head index
+	if err := db.indexSeries(s); err != nil {
+		return fmt.Errorf("tsdb: index update failed: %w", err)
+	}
+
+	// Track metric metadata (infers counter vs gauge for unknown metrics)
+	db.metadata.Observe(s.Labels[MetricNameLabel])
+
+	// Notify live watchers
+	db.watchers.publish(s, samples)
 
 	// Update stats
 	db.stats.TotalSamples.Add(int64(len(samples)))
 	db.stats.ActiveMemTableSize.Store(activeMemTable.Size())
+	db.ingest.record(s, int64(len(samples)), int64(len(rejected))+limited.dropped, 0)
 
-	return nil
+	return boundsError(rejected)
 }
 
-// Query retrieves samples for a series within a time range
-func (db *TSDB) Query(seriesHash uint64, start, end int64) ([]series.Sample, error) {
+// insertBatch atomically inserts samples for several series with
+// validated labels. The batch is logged as a single WAL entry and applied
+// to the active MemTable all at once; if either step fails, nothing is
+// written.
+func (db *TSDB) insertBatch(batch []*series.Series, samples [][]series.Sample) error {
 	if db.closed.Load() {
-		return nil, ErrClosed
+		return ErrClosed
+	}
+	if db.readOnly.Load() {
+		return ErrReadOnly
 	}
 
-	db.mu.RLock()
-	activeMemTable := db.activeMemTable
-	flushingMemTable := db.flushingMemTable
-	db.mu.RUnlock()
+	// Keep the series apart from other label sets sharing their hashes,
+	// in the head or the batch
+	batch = db.resolveBatch(batch)
 
-	// Query active MemTable
-	activeSamples, err := activeMemTable.Query(seriesHash, start, end)
+	// Reject the batch if a new series carries a label over its postings
+	// limit
+	for _, s := range batch {
+		if err := db.checkLabelLimit(s); err != nil {
+			for i, s := range batch {
+				db.ingest.record(s, 0, int64(len(samples[i])), 0)
+			}
+			return err
+		}
+	}
+
+	// Drop or aggregate the samples of series writing too fast
+	kept, keptSamples, limited := db.rateLimiter.applyBatch(batch, samples)
+	db.stats.recordRate(limited)
+	if limited.dropped > 0 {
+		db.ingest.recordDropped(batch, samples, kept, keptSamples)
+	}
+	batch, samples = kept, keptSamples
+	if len(batch) == 0 {
+		return nil
+	}
+
+	activeMemTable := db.head.activeMemTable()
+
+	// Reject duplicate and out-of-order samples before they are logged
+	if counts, err := activeMemTable.checkBatch(batch, samples); err != nil {
+		db.stats.record(counts)
+		for i, s := range batch {
+			db.ingest.record(s, 0, int64(len(samples[i])), 0)
+		}
+		return err
+	}
+
+	// Reserve MemTable space before logging, so a logged batch always fits
+	size := batchSize(samples)
+
+	if !activeMemTable.reserve(size) {
+		// Trigger flush
+		select {
+		case db.flushChan <- nil:
+		default:
+			// Flush already pending
+		}
+
+		// Wait a bit and retry
+		time.Sleep(10 * time.Millisecond)
+
+		activeMemTable = db.head.activeMemTable()
+
+		if !activeMemTable.reserve(size) {
+			if db.spill == nil {
+				return fmt.Errorf("tsdb: memtable insert failed: %w", ErrMemTableFull)
+			}
+			activeMemTable = nil
+		}
+	}
+
+	records := make([]wal.Record, len(batch))
+	for i, s := range batch {
+		records[i] = wal.Record{Series: s, Samples: samples[i]}
+	}
+	err := db.walWriter.AppendBatch(records)
+	db.health.appended(err)
 	if err != nil {
-		return nil, err
+		if activeMemTable != nil {
+			activeMemTable.release(size)
+		}
+		return fmt.Errorf("tsdb: WAL append failed: %w", err)
+	}
+	if activeMemTable == nil {
+		if err := db.spillSamples(records); err != nil {
+			return err
+		}
+		for i, s := range batch {
+			db.ingest.record(s, int64(len(samples[i])), 0, 0)
+		}
+		return nil
 	}
 
-	// Query flushing MemTable if it exists
-	var flushingSamples []series.Sample
-	if flushingMemTable != nil {
-		flushingSamples, err = flushingMemTable.Query(seriesHash, start, end)
-		if err != nil {
-			return nil, err
+	db.stats.record(activeMemTable.applyBatch(batch, samples))
+
+	total := 0
+	for i, s := range batch {
+		if err := db.indexSeries(s); err != nil {
+			return fmt.Errorf("tsdb: index update failed: %w", err)
 		}
+		db.metadata.Observe(s.Labels[MetricNameLabel])
+		db.watchers.publish(s, samples[i])
+		db.ingest.record(s, int64(len(samples[i])), 0, 0)
+		total += len(samples[i])
 	}
 
-	// Merge results (in Phase 3, we'll also query disk blocks)
-	result := make([]series.Sample, 0, len(activeSamples)+len(flushingSamples))
-	result = append(result, activeSamples...)
-	result = append(result, flushingSamples...)
+	db.stats.TotalSamples.Add(int64(total))
+	db.stats.ActiveMemTableSize.Store(activeMemTable.Size())
 
-	return result, nil
+	return nil
+}
+
+// Query retrieves samples for a series within a time range from the
+// head. They are sorted by timestamp, however they were inserted, and the
+// slice is the caller's own: inserts and flushes never modify it.
+func (db *TSDB) Query(seriesHash uint64, start, end int64) ([]series.Sample, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+	return db.head.Query(seriesHash, start, end)
 }
 
 // GetSeries retrieves series metadata
@@ -258,23 +842,12 @@ func (db *TSDB) GetSeries(seriesHash uint64) (*series.Series, bool) {
 	if db.closed.Load() {
 		return nil, false
 	}
+	return db.head.GetSeries(seriesHash)
+}
 
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-
-	// Check active MemTable first
-	if s, ok := db.activeMemTable.GetSeries(seriesHash); ok {
-		return s, true
-	}
-
-	// Check flushing MemTable
-	if db.flushingMemTable != nil {
-		if s, ok := db.flushingMemTable.GetSeries(seriesHash); ok {
-			return s, true
-		}
-	}
-
-	return nil, false
+// Head returns the in-memory head of the database
+func (db *TSDB) Head() *Head {
+	return db.head
 }
 
 // GetStats returns a snapshot of current TSDB statistics
@@ -292,6 +865,11 @@ func (db *TSDB) GetStats() Stats {
 
 // GetStatsSnapshot returns a simple snapshot of stats without atomic types
 func (db *TSDB) GetStatsSnapshot() StatsSnapshot {
+	var spillPending int64
+	if db.spill != nil {
+		spillPending = db.spill.size()
+	}
+	ioBytes, ioWait := db.io.stats()
 	return StatsSnapshot{
 		TotalSamples:       db.stats.TotalSamples.Load(),
 		TotalSeries:        db.stats.TotalSeries.Load(),
@@ -299,6 +877,48 @@ func (db *TSDB) GetStatsSnapshot() StatsSnapshot {
 		LastFlushTime:      db.stats.LastFlushTime.Load(),
 		WALSize:            db.stats.WALSize.Load(),
 		ActiveMemTableSize: db.stats.ActiveMemTableSize.Load(),
+		QuarantinedBlocks:  db.stats.QuarantinedBlocks.Load(),
+		AdoptedBlocks:      db.stats.AdoptedBlocks.Load(),
+		CorruptBlockReads:  db.stats.CorruptBlockReads.Load(),
+
+		DuplicatesOverwritten: db.stats.DuplicatesOverwritten.Load(),
+		DuplicatesDropped:     db.stats.DuplicatesDropped.Load(),
+		DuplicatesRejected:    db.stats.DuplicatesRejected.Load(),
+		OutOfOrderSamples:     db.stats.OutOfOrderSamples.Load(),
+		OutOfOrderRejected:    db.stats.OutOfOrderRejected.Load(),
+
+		TooOldRejected: db.stats.TooOldRejected.Load(),
+		TooNewRejected: db.stats.TooNewRejected.Load(),
+
+		RelabelDropped: db.stats.RelabelDropped.Load(),
+		HashCollisions: db.stats.HashCollisions.Load(),
+
+		SpilledSamples: db.stats.SpilledSamples.Load(),
+		SpillPending:   spillPending,
+
+		RateLimitedDropped:    db.stats.RateLimitedDropped.Load(),
+		RateLimitedAggregated: db.stats.RateLimitedAggregated.Load(),
+
+		LabelLimitRejected: db.stats.LabelLimitRejected.Load(),
+
+		DiskFreeBytes:          db.stats.DiskFreeBytes.Load(),
+		DiskTotalBytes:         db.stats.DiskTotalBytes.Load(),
+		EmergencyBlocksDeleted: db.stats.EmergencyBlocksDeleted.Load(),
+		ReadOnly:               db.readOnly.Load(),
+
+		CompactionPaused: db.compactor != nil && db.compactor.Paused(),
+		RetentionPaused:  db.retentionManager != nil && db.retentionManager.Paused(),
+
+		BackgroundIOBytes: ioBytes,
+		BackgroundIOWait:  ioWait,
+
+		FlushDuration:      newDurationStats(db.metrics.FlushDuration()),
+		WALSyncDuration:    newDurationStats(db.metrics.WALSyncDuration()),
+		CompactionDuration: newDurationStats(db.metrics.CompactionDuration()),
+
+		Replay: db.replay,
+
+		SeriesChurn: db.head.SeriesChurn(),
 	}
 }
 
@@ -310,6 +930,51 @@ type StatsSnapshot struct {
 	LastFlushTime      int64
 	WALSize            int64
 	ActiveMemTableSize int64
+	QuarantinedBlocks  int64
+	AdoptedBlocks      int64
+	CorruptBlockReads  int64
+
+	DuplicatesOverwritten int64
+	DuplicatesDropped     int64
+	DuplicatesRejected    int64
+	OutOfOrderSamples     int64
+	OutOfOrderRejected    int64
+
+	TooOldRejected int64
+	TooNewRejected int64
+
+	RelabelDropped int64
+	HashCollisions int64 // Series found sharing a hash with another label set
+
+	SpilledSamples int64 // Samples spilled since open
+	SpillPending   int64 // Samples spilled and not merged by a flush yet
+
+	RateLimitedDropped    int64
+	RateLimitedAggregated int64
+
+	LabelLimitRejected int64 // New series rejected for a label over its postings limit
+
+	DiskFreeBytes          int64
+	DiskTotalBytes         int64
+	EmergencyBlocksDeleted int64
+	ReadOnly               bool
+
+	CompactionPaused bool
+	RetentionPaused  bool
+
+	BackgroundIOBytes int64         // Bytes compaction and retention read and wrote
+	BackgroundIOWait  time.Duration // Time they were held by the I/O scheduler
+
+	// Durations of recent flushes, WAL syncs and compaction merges
+	FlushDuration      DurationStats
+	WALSyncDuration    DurationStats
+	CompactionDuration DurationStats
+
+	// WAL replay on open
+	Replay ReplayProgress
+
+	// Metric names churning the most series, per window
+	SeriesChurn []SeriesChurn
 }
 
 // Close closes the TSDB and all its components
@@ -329,45 +994,66 @@ func (db *TSDB) Close() error {
 	// Cancel background operations
 	db.cancel()
 
+	// End live watches
+	db.watchers.close()
+
 	// Wait for background flusher to complete
 	<-db.flusherDone
-
-	// Flush any remaining data
-	if err := db.flush(); err != nil {
+	<-db.diskWatchdogDone
+	<-db.blockScannerDone
+
+	// Flush any remaining data. Without disk space it stays in the WAL and
+	// is replayed on the next open.
+	if db.readOnly.Load() {
+		fmt.Printf("tsdb: read-only, skipping final flush\n")
+	} else if err := db.flush(); err != nil {
 		return fmt.Errorf("tsdb: final flush failed: %w", err)
 	}
 
+	// Anything still spilled is in the WAL
+	if db.spill != nil {
+		if err := db.spill.remove(); err != nil {
+			fmt.Printf("tsdb: %v\n", err)
+		}
+	}
+
+	// Persist metric metadata
+	if err := db.metadata.Persist(); err != nil {
+		return fmt.Errorf("tsdb: metadata persist failed: %w", err)
+	}
+
 	// Close WAL
 	if err := db.walWriter.Close(); err != nil {
 		return fmt.Errorf("tsdb: WAL close failed: %w", err)
 	}
 
+	// Delete blocks that were kept for readers
+	db.leases.close()
+
 	return nil
 }
 
-// recover replays the WAL to rebuild in-memory state
-func (db *TSDB) recover() error {
-	entries, err := db.walWriter.Replay()
-	if err != nil {
-		return fmt.Errorf("WAL replay failed: %w", err)
+// StopMaintenance stops background compaction and retention for good,
+// waiting for a cycle in progress to finish. Close does this too; a
+// shutdown calls it first to keep them from touching blocks while the
+// rest of the TSDB is closed.
+func (db *TSDB) StopMaintenance() {
+	if db.retentionManager != nil {
+		db.retentionManager.Pause()
+		db.retentionManager.Stop()
 	}
-
-	if len(entries) == 0 {
-		return nil
+	if db.compactor != nil {
+		db.compactor.Pause()
+		db.compactor.Stop()
 	}
+}
 
-	// Rebuild MemTable from WAL entries
-	for _, entry := range entries {
-		if entry.Type == 1 { // Sample entry
-			if entry.Series != nil && len(entry.Samples) > 0 {
-				// Best effort recovery - ignore errors
-				db.activeMemTable.Insert(entry.Series, entry.Samples)
-			}
-		}
+// SyncWAL syncs the WAL to disk
+func (db *TSDB) SyncWAL() error {
+	if db.closed.Load() {
+		return ErrClosed
 	}
-
-	fmt.Printf("tsdb: recovered %d entries from WAL\n", len(entries))
-	return nil
+	return db.walWriter.Sync()
 }
 
 // backgroundFlusher runs in the background and flushes MemTables periodically
@@ -384,116 +1070,245 @@ func (db *TSDB) backgroundFlusher() {
 
 		case <-ticker.C:
 			// Check if active MemTable should be flushed
-			db.mu.RLock()
-			shouldFlush := db.activeMemTable.IsFull()
-			db.mu.RUnlock()
-
-			if shouldFlush {
+			if reason := db.flushReason(); reason != "" {
+				fmt.Printf("tsdb: flushing MemTable: %s\n", reason)
 				if err := db.flush(); err != nil {
 					fmt.Printf("tsdb: background flush failed: %v\n", err)
 				}
 			}
 
-		case <-db.flushChan:
+		case done := <-db.flushChan:
 			// Explicit flush request
-			if err := db.flush(); err != nil {
+			err := db.flush()
+			if done != nil {
+				done <- err
+			} else if err != nil {
 				fmt.Printf("tsdb: explicit flush failed: %v\n", err)
 			}
 		}
 	}
 }
 
-// flush swaps the active MemTable and flushes it to disk
-func (db *TSDB) flush() error {
-	db.flushMu.Lock()
-	defer db.flushMu.Unlock()
+// flushReason reports why the active MemTable should be flushed now, or
+// "" if it shouldn't: a failed flush needs retrying, or it is full, spans
+// more than a block's worth of time, or the WAL has grown too large
+func (db *TSDB) flushReason() string {
+	active, flushing := db.head.memTables()
+	retained := flushing != nil
 
-	db.mu.Lock()
+	if db.readOnly.Load() {
+		return "" // No space for a block
+	}
+	if retained {
+		return "previous flush failed"
+	}
+	if active.IsFull() {
+		return "memtable full"
+	}
 
-	// Check if there's anything to flush
-	if db.activeMemTable.SeriesCount() == 0 {
-		db.mu.Unlock()
-		return nil
+	if db.maxSpan > 0 {
+		minTime, maxTime := active.TimeRange()
+		if minTime != -1 && maxTime-minTime > db.maxSpan.Milliseconds() {
+			return fmt.Sprintf("memtable spans %s, over %s", time.Duration(maxTime-minTime)*time.Millisecond, db.maxSpan)
+		}
+	}
+
+	size, err := db.walWriter.Size()
+	if err != nil {
+		fmt.Printf("tsdb: failed to get WAL size: %v\n", err)
+		return ""
+	}
+	db.stats.WALSize.Store(size)
+
+	if db.maxWALSize > 0 && size > db.maxWALSize && active.SeriesCount() > 0 {
+		return fmt.Sprintf("WAL size %d bytes, over %d", size, db.maxWALSize)
 	}
 
-	// Swap MemTables (double-buffering)
-	oldMemTable := db.activeMemTable
-	db.activeMemTable = NewMemTableWithSize(oldMemTable.MaxSize())
-	db.flushingMemTable = oldMemTable
+	return ""
+}
+
+// flushPhase names a step of flush, for failpoints
+type flushPhase string
+
+const (
+	flushPhasePersist  flushPhase = "persist"  // before the block is written
+	flushPhaseCommit   flushPhase = "commit"   // after the block is durable
+	flushPhaseTruncate flushPhase = "truncate" // after the flush is committed
+)
+
+// flush swaps the active MemTable and flushes it to disk. It runs in phases:
+//
+//  1. swap: the active MemTable becomes the flushing one and writes move
+//     to a fresh MemTable
+//  2. persist: the flushing MemTable is written and synced as a block
+//  3. commit: the flush is logged to the WAL and the flushing MemTable is
+//     dropped, its data now being served from the block
+//  4. truncate: WAL segments covered by the block are removed
+//
+// If persist fails, the partial block is removed and the flushing MemTable
+// is kept, so its data stays queryable and in the WAL; the next flush
+// retries it before swapping again.
+func (db *TSDB) flush() (err error) {
+	db.flushMu.Lock()
+	defer db.flushMu.Unlock()
+	defer func() { db.health.flushed(err) }()
+
+	// Retry a MemTable left over from a failed flush first
+	if retained := db.head.flushingMemTable(); retained != nil {
+		fmt.Printf("tsdb: retrying flush of retained MemTable\n")
+		if err := db.flushMemTable(retained); err != nil {
+			return err
+		}
+	}
 
-	db.mu.Unlock()
+	// Samples spilled while the MemTable was full belong to this flush
+	if err := db.mergeSpill(); err != nil {
+		return err
+	}
 
-	// At this point, new writes go to the new active MemTable
-	// We can safely flush the old one without blocking writes
+	// Swap: new writes go to a fresh MemTable while the old one is flushed
+	mt := db.head.cutMemTable()
+	if mt == nil {
+		return nil
+	}
+	return db.flushMemTable(mt)
+}
 
-	minTime, maxTime := oldMemTable.TimeRange()
+// flushMemTable runs the persist, commit and truncate phases for the
+// flushing MemTable mt
+func (db *TSDB) flushMemTable(mt *MemTable) error {
+	start := time.Now()
+	minTime, maxTime := mt.TimeRange()
 
 	fmt.Printf("tsdb: flushing MemTable (series=%d, samples=%d, timeRange=[%d, %d])\n",
-		oldMemTable.SeriesCount(),
-		oldMemTable.SampleCount(),
+		mt.SeriesCount(),
+		mt.SampleCount(),
 		minTime,
 		maxTime,
 	)
 
-	// Write MemTable to disk as a block
-	block, err := db.blockWriter.WriteMemTable(oldMemTable)
+	// Persist: write MemTable to disk as a block
+	if db.readOnly.Load() {
+		return ErrReadOnly
+	}
+	if err := db.failpoint(flushPhasePersist); err != nil {
+		return err
+	}
+	block, err := db.blockWriter.WriteMemTable(mt)
 	if err != nil {
 		return fmt.Errorf("failed to write block: %w", err)
 	}
+	db.leases.commit([]string{block.Dir()}, nil) // Complete; readers may see it now
 
 	fmt.Printf("tsdb: created block %s (size=%d bytes, compression=%.2fx)\n",
 		block.ULID.String(),
 		block.Size(),
-		float64(oldMemTable.SampleCount()*16)/float64(block.Size()),
+		float64(mt.SampleCount()*16)/float64(block.Size()),
 	)
 
+	// Commit: the block is durable, so the MemTable is no longer needed
+	if err := db.failpoint(flushPhaseCommit); err != nil {
+		return err
+	}
+
+	db.observeBlockMinTime(block.MinTime)
+	if db.compactor != nil {
+		db.compactor.blockReader.Invalidate()
+	}
+
+	// Persist metadata alongside the new block
+	if err := db.metadata.Persist(); err != nil {
+		fmt.Printf("tsdb: failed to persist metadata: %v\n", err)
+	}
+
 	// Log flush to WAL
 	if err := db.walWriter.LogFlush(maxTime); err != nil {
 		fmt.Printf("tsdb: failed to log flush: %v\n", err)
 	}
 
-	// Truncate old WAL entries
-	if err := db.walWriter.Truncate(maxTime); err != nil {
-		fmt.Printf("tsdb: failed to truncate WAL: %v\n", err)
-	}
-
 	// Clear the flushing MemTable
-	db.mu.Lock()
-	db.flushingMemTable = nil
-	db.mu.Unlock()
+	db.head.clearFlushing()
 
 	// Update stats
 	db.stats.FlushCount.Add(1)
 	db.stats.LastFlushTime.Store(time.Now().UnixMilli())
 
+	// Truncate: drop WAL entries now covered by the block
+	if err := db.failpoint(flushPhaseTruncate); err != nil {
+		return err
+	}
+	if err := db.walWriter.Truncate(maxTime); err != nil {
+		fmt.Printf("tsdb: failed to truncate WAL: %v\n", err)
+	}
+
+	db.observeFlush(time.Since(start), block, mt)
 	return nil
 }
 
-// TriggerFlush manually triggers a flush operation
-func (db *TSDB) TriggerFlush() error {
+// failpoint returns the error injected for a flush phase by tests, if any
+func (db *TSDB) failpoint(phase flushPhase) error {
+	if db.failpoints == nil {
+		return nil
+	}
+	return db.failpoints(phase)
+}
+
+// TriggerFlush flushes the active MemTable and waits until the flush has
+// completed or failed. It returns ctx's error if ctx is done first; the
+// flush itself still runs to completion in the background.
+func (db *TSDB) TriggerFlush(ctx context.Context) error {
 	if db.closed.Load() {
 		return ErrClosed
 	}
 
+	done := make(chan error, 1)
 	select {
-	case db.flushChan <- struct{}{}:
-		// Wait for flush to complete
-		time.Sleep(100 * time.Millisecond)
-		return nil
-	default:
-		return fmt.Errorf("tsdb: flush already in progress")
+	case db.flushChan <- done:
+	case <-db.flusherDone:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-db.flusherDone:
+		// The flusher stopped before picking up the request
+		select {
+		case err := <-done:
+			return err
+		default:
+			return ErrClosed
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkLabelLimit applies the label postings limit to s, counting the
+// series it rejects
+func (db *TSDB) checkLabelLimit(s *series.Series) error {
+	err := db.labelLimiter.check(db.head, s)
+	if err != nil {
+		db.stats.LabelLimitRejected.Add(1)
+	}
+	return err
+}
+
+// IndexStats returns statistics about the head index, including the
+// memory held by the posting lists of each label name
+func (db *TSDB) IndexStats() index.IndexStats {
+	return db.head.index.Stats()
 }
 
 // MemTableStats returns statistics about the current MemTables
 func (db *TSDB) MemTableStats() (active, flushing string) {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+	activeMT, flushingMT := db.head.memTables()
 
-	active = db.activeMemTable.Stats()
-
-	if db.flushingMemTable != nil {
-		flushing = db.flushingMemTable.Stats()
+	active = activeMT.Stats()
+	if flushingMT != nil {
+		flushing = flushingMT.Stats()
 	} else {
 		flushing = "None"
 	}
@@ -506,8 +1321,7 @@ func (db *TSDB) GetCompactionStats() *CompactionStats {
 	if db.compactor == nil {
 		return nil
 	}
-	stats := db.compactor.GetStats()
-	return &stats
+	return db.compactor.GetStats()
 }
 
 // GetRetentionStats returns retention statistics (Phase 6)
@@ -515,179 +1329,306 @@ func (db *TSDB) GetRetentionStats() *RetentionStats {
 	if db.retentionManager == nil {
 		return nil
 	}
-	stats := db.retentionManager.GetStats()
-	return &stats
+	return db.retentionManager.GetStats()
 }
 
-// TriggerCompaction manually triggers compaction (Phase 6)
-func (db *TSDB) TriggerCompaction() error {
+// TriggerCompaction runs a compaction cycle and waits until it has
+// completed or failed. It returns ctx's error if ctx is done first; the
+// cycle itself still runs to completion in the background.
+func (db *TSDB) TriggerCompaction(ctx context.Context) error {
 	if db.compactor == nil {
-		return fmt.Errorf("compaction not enabled")
+		return ErrCompactionDisabled
+	}
+	if db.closed.Load() {
+		return ErrClosed
 	}
-	return db.compactor.CompactNow()
-}
 
-// GetRetentionPolicy returns the current retention policy (Phase 6)
-func (db *TSDB) GetRetentionPolicy() *RetentionPolicy {
-	if db.retentionManager == nil {
-		return nil
+	done := make(chan error, 1)
+	go func() {
+		done <- db.compactor.CompactNow()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	policy := db.retentionManager.GetPolicy()
-	return &policy
 }
 
-// SetRetentionPolicy updates the retention policy (Phase 6)
-func (db *TSDB) SetRetentionPolicy(policy RetentionPolicy) error {
-	if db.retentionManager == nil {
-		return fmt.Errorf("retention not enabled")
+// PauseCompaction stops background compaction until ResumeCompaction is
+// called, e.g. while taking a backup. It returns once any compaction in
+// progress has finished.
+func (db *TSDB) PauseCompaction() error {
+	if db.compactor == nil {
+		return ErrCompactionDisabled
 	}
-	db.retentionManager.SetPolicy(policy)
+	db.compactor.Pause()
 	return nil
 }
 
-// GetAllLabels returns all unique label names across all series (Phase 7)
-func (db *TSDB) GetAllLabels() ([]string, error) {
-	if db.closed.Load() {
-		return nil, ErrClosed
+// ResumeCompaction restarts background compaction after PauseCompaction
+func (db *TSDB) ResumeCompaction() error {
+	if db.compactor == nil {
+		return ErrCompactionDisabled
 	}
+	db.compactor.Resume()
+	return nil
+}
 
-	db.mu.RLock()
-	activeMemTable := db.activeMemTable
-	flushingMemTable := db.flushingMemTable
-	db.mu.RUnlock()
+// CompactionPlan returns the merges the next compaction cycle would
+// perform, without changing anything on disk
+func (db *TSDB) CompactionPlan() (*CompactionPlan, error) {
+	if db.compactor == nil {
+		return nil, ErrCompactionDisabled
+	}
+	return db.compactor.Plan()
+}
 
-	labelSet := make(map[string]struct{})
+// RetentionPlan reports the blocks the next retention cleanup would
+// delete, without deleting anything
+func (db *TSDB) RetentionPlan() (*RetentionStatsReport, error) {
+	if db.retentionManager == nil {
+		return nil, ErrRetentionDisabled
+	}
+	return db.retentionManager.CalculateRetentionStats()
+}
 
-	// Collect labels from active MemTable
-	activeMemTable.mu.RLock()
-	for _, s := range activeMemTable.seriesMeta {
-		for labelName := range s.Labels {
-			labelSet[labelName] = struct{}{}
-		}
+// TriggerRetention manually runs a retention cleanup
+func (db *TSDB) TriggerRetention() error {
+	if db.retentionManager == nil {
+		return ErrRetentionDisabled
 	}
-	activeMemTable.mu.RUnlock()
+	return db.retentionManager.CleanupNow()
+}
 
-	// Collect labels from flushing MemTable
-	if flushingMemTable != nil {
-		flushingMemTable.mu.RLock()
-		for _, s := range flushingMemTable.seriesMeta {
-			for labelName := range s.Labels {
-				labelSet[labelName] = struct{}{}
-			}
-		}
-		flushingMemTable.mu.RUnlock()
+// PauseRetention stops background retention cleanups until
+// ResumeRetention is called. It returns once any cleanup in progress has
+// finished.
+func (db *TSDB) PauseRetention() error {
+	if db.retentionManager == nil {
+		return ErrRetentionDisabled
 	}
+	db.retentionManager.Pause()
+	return nil
+}
 
-	// Convert to sorted slice
-	labels := make([]string, 0, len(labelSet))
-	for label := range labelSet {
-		labels = append(labels, label)
+// ResumeRetention restarts background retention cleanups after
+// PauseRetention
+func (db *TSDB) ResumeRetention() error {
+	if db.retentionManager == nil {
+		return ErrRetentionDisabled
 	}
+	db.retentionManager.Resume()
+	return nil
+}
 
-	// Sort for consistent output
-	sort.Strings(labels)
+// PurgedBefore returns the time before which retention has deleted data,
+// in Unix milliseconds, or 0 if retention has not deleted anything.
+func (db *TSDB) PurgedBefore() int64 {
+	purged := db.emergencyPurgedBefore.Load()
+	if db.retentionManager != nil {
+		purged = max(purged, db.retentionManager.PurgedBefore())
+	}
+	return purged
+}
 
-	return labels, nil
+// MinTime returns the oldest retained timestamp across persisted blocks and
+// the in-memory head. ok is false if the database holds no samples.
+func (db *TSDB) MinTime() (minTime int64, ok bool) {
+	minTime, ok = db.blocksMinTime()
+	if headMin, headOK := db.head.MinTime(); headOK && (!ok || headMin < minTime) {
+		minTime, ok = headMin, true
+	}
+	return minTime, ok
 }
 
-// GetLabelValues returns all unique values for a specific label (Phase 7)
-func (db *TSDB) GetLabelValues(labelName string) ([]string, error) {
-	if db.closed.Load() {
-		return nil, ErrClosed
+// blocksMinTime returns the oldest timestamp in persisted blocks
+func (db *TSDB) blocksMinTime() (int64, bool) {
+	purged := db.PurgedBefore()
+
+	db.blockMinMu.Lock()
+	defer db.blockMinMu.Unlock()
+
+	if !db.blockMinLoaded || db.blockMinPurged != purged {
+		db.blockMinTime, db.blockMinValid = scanBlocksMinTime(db.dataDir, db.coldDir)
+		db.blockMinPurged = purged
+		db.blockMinLoaded = true
 	}
+	return db.blockMinTime, db.blockMinValid
+}
 
-	db.mu.RLock()
-	activeMemTable := db.activeMemTable
-	flushingMemTable := db.flushingMemTable
-	db.mu.RUnlock()
+// observeBlockMinTime folds a newly written block into the cached minimum
+func (db *TSDB) observeBlockMinTime(minTime int64) {
+	db.blockMinMu.Lock()
+	defer db.blockMinMu.Unlock()
 
-	valueSet := make(map[string]struct{})
+	if !db.blockMinLoaded {
+		return // The first lookup scans all blocks, including this one
+	}
+	if !db.blockMinValid || minTime < db.blockMinTime {
+		db.blockMinTime, db.blockMinValid = minTime, true
+	}
+}
 
-	// Collect values from active MemTable
-	activeMemTable.mu.RLock()
-	for _, s := range activeMemTable.seriesMeta {
-		if value, ok := s.Labels[labelName]; ok {
-			valueSet[value] = struct{}{}
-		}
+// scanBlocksMinTime reads block metadata in dataDirs and returns the oldest
+// block MinTime. Unreadable blocks are skipped.
+func scanBlocksMinTime(dataDirs ...string) (int64, bool) {
+	dirs, err := blockDirs(dataDirs...)
+	if err != nil {
+		return 0, false
 	}
-	activeMemTable.mu.RUnlock()
 
-	// Collect values from flushing MemTable
-	if flushingMemTable != nil {
-		flushingMemTable.mu.RLock()
-		for _, s := range flushingMemTable.seriesMeta {
-			if value, ok := s.Labels[labelName]; ok {
-				valueSet[value] = struct{}{}
-			}
+	var minTime int64
+	found := false
+	for _, dir := range dirs {
+		meta, err := readBlockMeta(dir)
+		if err != nil {
+			continue
+		}
+		if !found || meta.MinTime < minTime {
+			minTime, found = meta.MinTime, true
 		}
-		flushingMemTable.mu.RUnlock()
 	}
+	return minTime, found
+}
 
-	// Convert to sorted slice
-	values := make([]string, 0, len(valueSet))
-	for value := range valueSet {
-		values = append(values, value)
+// GetRetentionPolicy returns the current retention policy (Phase 6)
+func (db *TSDB) GetRetentionPolicy() *RetentionPolicy {
+	if db.retentionManager == nil {
+		return nil
 	}
+	policy := db.retentionManager.GetPolicy()
+	return &policy
+}
 
-	// Sort for consistent output
-	sort.Strings(values)
-
-	return values, nil
+// SetRetentionPolicy updates the retention policy (Phase 6)
+func (db *TSDB) SetRetentionPolicy(policy RetentionPolicy) error {
+	if db.retentionManager == nil {
+		return ErrRetentionDisabled
+	}
+	db.retentionManager.SetPolicy(policy)
+	return nil
 }
 
-// GetSeries returns all series that match the given label matchers (Phase 7)
-func (db *TSDB) GetSeries(matchers index.Matchers) ([]map[string]string, error) {
+// SetMetadata stores type, help and unit metadata for a metric
+func (db *TSDB) SetMetadata(metric string, md MetricMetadata) error {
 	if db.closed.Load() {
-		return nil, ErrClosed
+		return ErrClosed
+	}
+	if metric == "" {
+		return fmt.Errorf("tsdb: metric name cannot be empty")
 	}
 
-	db.mu.RLock()
-	activeMemTable := db.activeMemTable
-	flushingMemTable := db.flushingMemTable
-	db.mu.RUnlock()
+	db.metadata.Set(metric, md)
+	return nil
+}
 
-	seriesMap := make(map[uint64]map[string]string) // Use hash to deduplicate
+// GetMetadata returns the metadata for a metric
+func (db *TSDB) GetMetadata(metric string) (MetricMetadata, bool) {
+	return db.metadata.Get(metric)
+}
 
-	// Collect matching series from active MemTable
-	activeMemTable.mu.RLock()
-	for _, s := range activeMemTable.seriesMeta {
-		if matchLabels(s.Labels, matchers) {
-			seriesMap[s.Hash] = s.Labels
-		}
+// ListMetadata returns metadata for all known metrics.
+// If limit is positive, at most limit metrics are returned.
+func (db *TSDB) ListMetadata(limit int) map[string]MetricMetadata {
+	return db.metadata.List(limit)
+}
+
+// GetAllLabels returns all unique label names across all series (Phase 7)
+func (db *TSDB) GetAllLabels() ([]string, error) {
+	// Label names are sorted by the index for consistent output
+	names, _, err := db.ListLabelNames(ListOptions{})
+	return names, err
+}
+
+// GetLabelValues returns all unique values for a specific label (Phase 7)
+func (db *TSDB) GetLabelValues(labelName string) ([]string, error) {
+	values, _, err := db.ListLabelValues(labelName, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = []string{}
 	}
-	activeMemTable.mu.RUnlock()
+	return values, nil
+}
 
-	// Collect matching series from flushing MemTable
-	if flushingMemTable != nil {
-		flushingMemTable.mu.RLock()
-		for _, s := range flushingMemTable.seriesMeta {
-			if matchLabels(s.Labels, matchers) {
-				seriesMap[s.Hash] = s.Labels
-			}
-		}
-		flushingMemTable.mu.RUnlock()
+// GetSeriesByMatchers returns all series that match the given label matchers (Phase 7).
+// Series are resolved through the head index and sorted by label set; an empty matcher
+// set matches all series.
+func (db *TSDB) GetSeriesByMatchers(matchers index.Matchers) ([]map[string]string, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
 	}
 
-	// Convert to slice
-	result := make([]map[string]string, 0, len(seriesMap))
-	for _, labels := range seriesMap {
-		result = append(result, labels)
+	matched, err := db.head.Select(matchers)
+	if err != nil {
+		return nil, err
 	}
 
+	result := make([]map[string]string, 0, len(matched))
+	for _, s := range matched {
+		result = append(result, s.Labels)
+	}
 	return result, nil
 }
 
-// matchLabels checks if the given labels match all matchers
-func matchLabels(labels map[string]string, matchers index.Matchers) bool {
-	if len(matchers) == 0 {
-		return true // Empty matchers match everything
+// resolveHash returns s with a hash no other label set in the head,
+// pending or blocks holds (see Head.resolveHash), counting and logging collisions
+// the first time they are found
+func (db *TSDB) resolveHash(s *series.Series, pending map[uint64]*series.Series) *series.Series {
+	resolved, collided := db.head.resolveHash(s, pending)
+	if collided {
+		db.stats.HashCollisions.Add(1)
+		db.metrics.RecordSeriesHashCollision()
+		fmt.Printf("tsdb: series %s shares hash %d with another series, writing it under hash %d\n",
+			s, s.Hash, resolved.Hash)
 	}
+	return resolved
+}
 
-	for _, matcher := range matchers {
-		if !matcher.Matches(labels) {
-			return false
+// resolveBatch resolves the hashes of a batch like resolveHash, keeping
+// its series apart from each other too. The batch is copied if any
+// series is given another hash.
+func (db *TSDB) resolveBatch(batch []*series.Series) []*series.Series {
+	var pending map[uint64]*series.Series
+	if len(batch) > 1 {
+		pending = make(map[uint64]*series.Series, len(batch))
+	}
+	resolved := batch
+	for i, s := range batch {
+		r := db.resolveHash(s, pending)
+		if r != s {
+			if &resolved[0] == &batch[0] {
+				resolved = slices.Clone(batch)
+			}
+			resolved[i] = r
+		}
+		if pending != nil {
+			pending[r.Hash] = r
 		}
 	}
+	return resolved
+}
 
-	return true
+// indexSeries registers a series in the head index if it is not known yet.
+// A collision resolveHash missed is counted and logged; the samples of the
+// two series are already merged.
+func (db *TSDB) indexSeries(s *series.Series) error {
+	added, err := db.head.indexSeries(s)
+	if errors.Is(err, errHashCollision) {
+		db.stats.HashCollisions.Add(1)
+		db.metrics.RecordSeriesHashCollision()
+		fmt.Printf("tsdb: %v\n", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if added {
+		db.stats.TotalSeries.Add(1)
+		db.ingest.record(s, 0, 0, 1)
+	}
+	return nil
 }
@@ -1,12 +1,31 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
 	"github.com/therealutkarshpriyadarshi/time/pkg/series"
 )
 
+// openTestDB opens a TSDB in a temporary directory, closed when the test
+// ends. configure, if set, adjusts the default options first.
+func openTestDB(t *testing.T, configure func(opts *Options)) *TSDB {
+	t.Helper()
+	opts := DefaultOptions(t.TempDir())
+	if configure != nil {
+		configure(opts)
+	}
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
 func TestTSDBBasicOperations(t *testing.T) {
 	dir := t.TempDir()
 
@@ -212,8 +231,7 @@ func TestTSDBFlush(t *testing.T) {
 		if err := db.Insert(s, samples); err != nil {
 			// Expected to fail when MemTable is full
 			// Trigger manual flush
-			db.TriggerFlush()
-			time.Sleep(200 * time.Millisecond)
+			db.TriggerFlush(context.Background())
 
 			// Retry
 			if err := db.Insert(s, samples); err != nil {
@@ -249,20 +267,86 @@ func TestTSDBManualFlush(t *testing.T) {
 	db.Insert(s, samples)
 
 	// Trigger manual flush
-	if err := db.TriggerFlush(); err != nil {
+	if err := db.TriggerFlush(context.Background()); err != nil {
 		t.Fatalf("failed to trigger flush: %v", err)
 	}
 
-	// Wait for flush to complete
-	time.Sleep(200 * time.Millisecond)
-
-	// Verify flush occurred
+	// TriggerFlush returns only once the flush has completed
 	stats := db.GetStatsSnapshot()
 	if stats.FlushCount == 0 {
 		t.Error("expected at least one flush")
 	}
 }
 
+func TestTSDBTriggerFlushReportsFailure(t *testing.T) {
+	dir := t.TempDir()
+
+	opts := DefaultOptions(dir)
+	opts.FlushInterval = time.Hour
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	s := series.NewSeries(map[string]string{"__name__": "trigger_flush_test"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	errInjected := errors.New("injected")
+	db.failpoints = func(flushPhase) error { return errInjected }
+	if err := db.TriggerFlush(context.Background()); !errors.Is(err, errInjected) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+	db.failpoints = nil
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.TriggerFlush(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		t.Errorf("expected nil or context.Canceled, got %v", err)
+	}
+
+	db.Close()
+	if err := db.TriggerFlush(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed after close, got %v", err)
+	}
+}
+
+func TestTSDBTriggerCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	opts := DefaultOptions(dir)
+	opts.CompactionInterval = time.Hour
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	before := db.GetCompactionStats().TotalCompactions.Load()
+	if err := db.TriggerCompaction(context.Background()); err != nil {
+		t.Fatalf("failed to trigger compaction: %v", err)
+	}
+	if after := db.GetCompactionStats().TotalCompactions.Load(); after <= before {
+		t.Errorf("expected a completed compaction cycle, total went from %d to %d", before, after)
+	}
+
+	opts = DefaultOptions(t.TempDir())
+	opts.EnableCompaction = false
+	noCompaction, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer noCompaction.Close()
+
+	if err := noCompaction.TriggerCompaction(context.Background()); !errors.Is(err, ErrCompactionDisabled) {
+		t.Errorf("expected ErrCompactionDisabled, got %v", err)
+	}
+}
+
 func TestTSDBConcurrentWrites(t *testing.T) {
 	dir := t.TempDir()
 
@@ -358,6 +442,43 @@ func TestTSDBClose(t *testing.T) {
 	}
 }
 
+func TestTSDBShutdownSequence(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+
+	// A current sample, so background retention leaves its block alone
+	s := series.NewSeries(map[string]string{"__name__": "shutdown_test"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: time.Now().UnixMilli(), Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	if err := db.TriggerFlush(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	db.StopMaintenance()
+	if !db.compactor.Paused() || !db.retentionManager.Paused() {
+		t.Error("expected compaction and retention to be stopped")
+	}
+	if err := db.SyncWAL(); err != nil {
+		t.Fatalf("WAL sync failed: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close TSDB: %v", err)
+	}
+	if err := db.SyncWAL(); err != ErrClosed {
+		t.Errorf("expected ErrClosed after close, got %v", err)
+	}
+
+	dirs, _ := blockDirs(dir)
+	if len(dirs) != 1 {
+		t.Errorf("expected the head flushed to one block, got %d", len(dirs))
+	}
+}
+
 func TestTSDBGetSeries(t *testing.T) {
 	dir := t.TempDir()
 
@@ -487,6 +608,54 @@ func BenchmarkTSDBQuery(b *testing.B) {
 	}
 }
 
+func TestTSDBGetSeriesByMatchersUsesHeadIndex(t *testing.T) {
+	db, err := Open(DefaultOptions(t.TempDir()))
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	hosts := []string{"server1", "server2", "server3"}
+	for _, host := range hosts {
+		s := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": host})
+		if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	other := series.NewSeries(map[string]string{"__name__": "memory_usage", "host": "server1"})
+	if err := db.Insert(other, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	result, err := db.GetSeriesByMatchers(index.Matchers{
+		index.MustNewMatcher(index.MatchNotEqual, "host", "server2"),
+		index.MustNewMatcher(index.MatchEqual, "__name__", "cpu_usage"),
+	})
+	if err != nil {
+		t.Fatalf("GetSeriesByMatchers failed: %v", err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("expected 2 series, got %d", len(result))
+	}
+	for _, labels := range result {
+		if labels["__name__"] != "cpu_usage" || labels["host"] == "server2" {
+			t.Errorf("unexpected series %v", labels)
+		}
+	}
+
+	all, err := db.GetSeriesByMatchers(nil)
+	if err != nil {
+		t.Fatalf("GetSeriesByMatchers failed: %v", err)
+	}
+	if len(all) != 4 {
+		t.Errorf("expected 4 series for empty matchers, got %d", len(all))
+	}
+
+	if got := db.GetStatsSnapshot().TotalSeries; got != 4 {
+		t.Errorf("TotalSeries = %d, want 4", got)
+	}
+}
+
 func BenchmarkTSDBConcurrentInsert(b *testing.B) {
 	dir := b.TempDir()
 
@@ -515,3 +684,237 @@ func BenchmarkTSDBConcurrentInsert(b *testing.B) {
 		}
 	})
 }
+
+func TestTSDBMinTimeAndPurgedBefore(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UnixMilli()
+	oldTime := now - 48*time.Hour.Milliseconds()
+
+	// Persist a block older than the retention period set below
+	block, err := NewBlock(oldTime, oldTime+1000)
+	if err != nil {
+		t.Fatalf("failed to create block: %v", err)
+	}
+	old := series.NewSeries(map[string]string{"__name__": "old_metric"})
+	if err := block.AddSeries(old, []series.Sample{{Timestamp: oldTime, Value: 1}, {Timestamp: oldTime + 1000, Value: 2}}); err != nil {
+		t.Fatalf("failed to add series: %v", err)
+	}
+	if err := block.Persist(dir); err != nil {
+		t.Fatalf("failed to persist block: %v", err)
+	}
+
+	db, err := Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	headTime := now - time.Hour.Milliseconds()
+	s := series.NewSeries(map[string]string{"__name__": "new_metric"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: headTime, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	if minTime, ok := db.MinTime(); !ok || minTime != oldTime {
+		t.Errorf("MinTime() = %d, %v, want %d, true", minTime, ok, oldTime)
+	}
+	if purged := db.PurgedBefore(); purged != 0 {
+		t.Errorf("PurgedBefore() = %d before any deletion, want 0", purged)
+	}
+
+	if err := db.SetRetentionPolicy(RetentionPolicy{MaxAge: 24 * time.Hour, Enabled: true}); err != nil {
+		t.Fatalf("failed to set retention policy: %v", err)
+	}
+	if err := db.TriggerRetention(); err != nil {
+		t.Fatalf("retention failed: %v", err)
+	}
+
+	if purged := db.PurgedBefore(); purged <= oldTime+1000 {
+		t.Errorf("PurgedBefore() = %d, want after %d", purged, oldTime+1000)
+	}
+	if minTime, ok := db.MinTime(); !ok || minTime != headTime {
+		t.Errorf("MinTime() = %d, %v after retention, want %d, true", minTime, ok, headTime)
+	}
+}
+
+func TestTSDBFlushReason(t *testing.T) {
+	dir := t.TempDir()
+
+	opts := DefaultOptions(dir)
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	opts.FlushInterval = time.Hour // Check flushReason by hand
+	opts.MaxMemTableSpan = time.Hour
+	opts.MaxWALSize = 0
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	if reason := db.flushReason(); reason != "" {
+		t.Errorf("expected no flush for an empty MemTable, got %q", reason)
+	}
+
+	s := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "server1"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 0, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if reason := db.flushReason(); reason != "" {
+		t.Errorf("expected no flush within the span, got %q", reason)
+	}
+
+	// Low-volume data stretching past the span triggers a flush
+	if err := db.Insert(s, []series.Sample{{Timestamp: 2 * time.Hour.Milliseconds(), Value: 2}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if reason := db.flushReason(); reason == "" {
+		t.Error("expected a flush once the MemTable spans more than MaxMemTableSpan")
+	}
+
+	// So does the WAL growing past MaxWALSize
+	db.maxSpan = 0
+	db.maxWALSize = 1
+	if reason := db.flushReason(); reason == "" {
+		t.Error("expected a flush once the WAL exceeds MaxWALSize")
+	}
+	if db.GetStatsSnapshot().WALSize == 0 {
+		t.Error("expected WALSize stat to be updated")
+	}
+}
+
+// crash stops db without the final flush of Close, as if the process died
+func crash(t *testing.T, db *TSDB) {
+	t.Helper()
+	db.closed.Store(true)
+	db.cancel()
+	<-db.flusherDone
+	if err := db.walWriter.Close(); err != nil {
+		t.Fatalf("failed to close WAL: %v", err)
+	}
+}
+
+func TestTSDBFlushPersistFailureRetainsMemTable(t *testing.T) {
+	dir := t.TempDir()
+
+	opts := DefaultOptions(dir)
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	opts.FlushInterval = time.Hour
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	s := series.NewSeries(map[string]string{"__name__": "flush_test"})
+	if err := db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	errInjected := errors.New("injected")
+	db.failpoints = func(phase flushPhase) error {
+		if phase == flushPhasePersist {
+			return errInjected
+		}
+		return nil
+	}
+
+	if err := db.flush(); !errors.Is(err, errInjected) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+	if db.head.flushingMemTable() == nil {
+		t.Fatal("expected the flushing MemTable to be retained")
+	}
+	if blocks, _ := blockDirs(dir); len(blocks) != 0 {
+		t.Errorf("expected no blocks, got %v", blocks)
+	}
+
+	// Writes continue and everything stays queryable
+	if err := db.Insert(s, []series.Sample{{Timestamp: 2000, Value: 2}}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	samples, err := db.Query(s.Hash, 0, 3000)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(samples) != 2 {
+		t.Errorf("expected 2 samples, got %d", len(samples))
+	}
+	if reason := db.flushReason(); reason == "" {
+		t.Error("expected the failed flush to be retried")
+	}
+
+	// The retry flushes the retained MemTable, then the active one
+	db.failpoints = nil
+	if err := db.flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	if db.head.flushingMemTable() != nil {
+		t.Error("expected no flushing MemTable after a successful flush")
+	}
+	if blocks, _ := blockDirs(dir); len(blocks) != 2 {
+		t.Errorf("expected 2 blocks, got %v", blocks)
+	}
+}
+
+func TestTSDBFlushCrashConsistency(t *testing.T) {
+	for _, phase := range []flushPhase{flushPhasePersist, flushPhaseCommit, flushPhaseTruncate} {
+		t.Run(string(phase), func(t *testing.T) {
+			dir := t.TempDir()
+
+			opts := DefaultOptions(dir)
+			opts.EnableCompaction = false
+			opts.EnableRetention = false
+			opts.FlushInterval = time.Hour
+
+			db, err := Open(opts)
+			if err != nil {
+				t.Fatalf("failed to open TSDB: %v", err)
+			}
+
+			s := series.NewSeries(map[string]string{"__name__": "crash_test"})
+			samples := []series.Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}}
+			if err := db.Insert(s, samples); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+
+			// Crash at the phase
+			db.failpoints = func(p flushPhase) error {
+				if p == phase {
+					return errors.New("crash")
+				}
+				return nil
+			}
+			if err := db.flush(); err == nil {
+				t.Fatal("expected flush to stop at the failpoint")
+			}
+			crash(t, db)
+
+			wantBlocks := 1
+			if phase == flushPhasePersist {
+				wantBlocks = 0
+			}
+			if blocks, _ := blockDirs(dir); len(blocks) != wantBlocks {
+				t.Fatalf("expected %d blocks after crash, got %v", wantBlocks, blocks)
+			}
+
+			// No sample is lost: they are in the WAL until it is truncated
+			db, err = Open(opts)
+			if err != nil {
+				t.Fatalf("failed to reopen TSDB: %v", err)
+			}
+			defer db.Close()
+
+			got, err := db.Query(s.Hash, 0, 3000)
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			if len(got) != len(samples) {
+				t.Errorf("expected %d samples after recovery, got %d", len(samples), len(got))
+			}
+		})
+	}
+}
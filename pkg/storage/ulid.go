@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// maxULIDAttempts bounds how often a block picks a new ULID when its
+// directory already exists
+const maxULIDAttempts = 8
+
+// ulidEntropy is the entropy source for block ULIDs, shared by the block
+// writer and every compaction worker. It is safe for concurrent use, and
+// ULIDs generated for the same millisecond are strictly increasing, so two
+// blocks starting at the same time never get the same ID in one process.
+var ulidEntropy = &ulid.LockedMonotonicReader{
+	MonotonicReader: ulid.Monotonic(rand.Reader, 0),
+}
+
+// newBlockULID returns a new block ULID for a block starting at ms
+func newBlockULID(ms uint64) (ulid.ULID, error) {
+	return ulid.New(ms, ulidEntropy)
+}
+
+// mkdirNew creates dir, reporting false if it already exists
+func mkdirNew(dir string) (bool, error) {
+	if err := os.Mkdir(dir, 0755); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// createDir creates the block's directory under dataDir with mkdir, which
+// must fail for existing directories. If a directory with the block's ULID
+// already exists, from another process or an earlier run, the block takes
+// a new ULID for the same time rather than overwriting it.
+func (b *Block) createDir(dataDir string, mkdir func(dir string) (bool, error)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.createDirLocked(dataDir, mkdir)
+}
+
+// createDirLocked is createDir with b.mu held
+func (b *Block) createDirLocked(dataDir string, mkdir func(dir string) (bool, error)) error {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		dir := filepath.Join(dataDir, b.ULID.String())
+		created, err := mkdir(dir)
+		if err != nil {
+			return fmt.Errorf("failed to create block directory: %w", err)
+		}
+		if created {
+			b.dir = dir
+			return nil
+		}
+		if attempt == maxULIDAttempts {
+			return fmt.Errorf("block directory %s exists after %d attempts", dir, attempt)
+		}
+
+		id, err := newBlockULID(b.ULID.Time())
+		if err != nil {
+			return fmt.Errorf("failed to generate ULID: %w", err)
+		}
+		fmt.Printf("tsdb: block %s already exists, renaming new block to %s\n", b.ULID, id)
+		b.ULID = id
+	}
+}
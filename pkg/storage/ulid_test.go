@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/oklog/ulid/v2"
+)
+
+func TestNewBlockULIDUniqueAcrossGoroutines(t *testing.T) {
+	const workers, perWorker = 8, 200
+
+	var mu sync.Mutex
+	seen := make(map[ulid.ULID]bool)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				// Every block starts in the same millisecond
+				id, err := newBlockULID(1000)
+				if err != nil {
+					t.Errorf("newBlockULID failed: %v", err)
+					return
+				}
+				mu.Lock()
+				if seen[id] {
+					t.Errorf("duplicate ULID %s", id)
+				}
+				seen[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestBlockPersistRenamesOnCollision(t *testing.T) {
+	dataDir := t.TempDir()
+
+	block, err := NewBlock(1000, 2000)
+	if err != nil {
+		t.Fatalf("NewBlock failed: %v", err)
+	}
+	original := block.ULID
+
+	// Another block already holds the directory
+	existing := filepath.Join(dataDir, original.String())
+	if err := os.MkdirAll(existing, 0755); err != nil {
+		t.Fatal(err)
+	}
+	marker := filepath.Join(existing, "marker")
+	if err := os.WriteFile(marker, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := block.Persist(dataDir); err != nil {
+		t.Fatalf("Persist failed: %v", err)
+	}
+
+	if block.ULID == original {
+		t.Fatal("expected the colliding block to take a new ULID")
+	}
+	if block.ULID.Time() != original.Time() {
+		t.Errorf("renamed ULID time %d, want %d", block.ULID.Time(), original.Time())
+	}
+	if block.Dir() != filepath.Join(dataDir, block.ULID.String()) {
+		t.Errorf("unexpected block dir %s", block.Dir())
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("existing block directory was modified: %v", err)
+	}
+
+	reopened, err := OpenBlock(block.Dir())
+	if err != nil {
+		t.Fatalf("OpenBlock failed: %v", err)
+	}
+	defer reopened.Close()
+	if reopened.ULID != block.ULID {
+		t.Errorf("meta ULID %s, want %s", reopened.ULID, block.ULID)
+	}
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+var (
+	// ErrInvalidLabelName indicates a label name is empty or uses invalid characters
+	ErrInvalidLabelName = tsdberrors.New(tsdberrors.Invalid, "invalid label name")
+
+	// ErrInvalidLabelValue indicates a label value is not valid UTF-8
+	ErrInvalidLabelValue = tsdberrors.New(tsdberrors.Invalid, "invalid label value")
+
+	// ErrInvalidMetricName indicates the __name__ label is not a valid metric name
+	ErrInvalidMetricName = tsdberrors.New(tsdberrors.Invalid, "invalid metric name")
+
+	// ErrTooManyLabels indicates a series has more labels than allowed
+	ErrTooManyLabels = tsdberrors.New(tsdberrors.Invalid, "too many labels")
+
+	// ErrLabelNameTooLong indicates a label name exceeds the configured length
+	ErrLabelNameTooLong = tsdberrors.New(tsdberrors.Invalid, "label name too long")
+
+	// ErrLabelValueTooLong indicates a label value exceeds the configured length
+	ErrLabelValueTooLong = tsdberrors.New(tsdberrors.Invalid, "label value too long")
+
+	// ErrLabelsTooLarge indicates the combined size of all labels exceeds the limit
+	ErrLabelsTooLarge = tsdberrors.New(tsdberrors.Invalid, "labels too large")
+)
+
+const (
+	// DefaultMaxLabelNamesPerSeries is the default maximum number of labels per series
+	DefaultMaxLabelNamesPerSeries = 30
+
+	// DefaultMaxLabelNameLength is the default maximum label name length in bytes
+	DefaultMaxLabelNameLength = 1024
+
+	// DefaultMaxLabelValueLength is the default maximum label value length in bytes
+	DefaultMaxLabelValueLength = 2048
+
+	// DefaultMaxLabelsSize is the default maximum combined size of all label names and values
+	DefaultMaxLabelsSize = 16 * 1024
+)
+
+// ValidationOptions configures write-path validation of series labels.
+// A zero limit disables that particular check.
+type ValidationOptions struct {
+	MaxLabelNamesPerSeries int
+	MaxLabelNameLength     int
+	MaxLabelValueLength    int
+	MaxLabelsSize          int
+}
+
+// DefaultValidationOptions returns default validation limits
+func DefaultValidationOptions() *ValidationOptions {
+	return &ValidationOptions{
+		MaxLabelNamesPerSeries: DefaultMaxLabelNamesPerSeries,
+		MaxLabelNameLength:     DefaultMaxLabelNameLength,
+		MaxLabelValueLength:    DefaultMaxLabelValueLength,
+		MaxLabelsSize:          DefaultMaxLabelsSize,
+	}
+}
+
+// ValidationError describes why a series was rejected on the write path.
+// It wraps one of the Err* validation sentinels so callers can use errors.Is.
+type ValidationError struct {
+	Err    error  // Validation sentinel (e.g. ErrInvalidLabelName)
+	Label  string // Offending label name, if any
+	Detail string // Human-readable detail
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	if e.Label != "" {
+		return fmt.Sprintf("%v: label %q: %s", e.Err, e.Label, e.Detail)
+	}
+	return fmt.Sprintf("%v: %s", e.Err, e.Detail)
+}
+
+// Unwrap returns the underlying validation sentinel
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// IsValidationError reports whether err is (or wraps) a ValidationError
+func IsValidationError(err error) bool {
+	var ve *ValidationError
+	return errors.As(err, &ve)
+}
+
+// ValidateLabels checks label names and values against the validation options
+func (o *ValidationOptions) ValidateLabels(labels map[string]string) error {
+	if len(labels) == 0 {
+		return &ValidationError{Err: ErrInvalidLabelName, Detail: "series has no labels"}
+	}
+
+	if o.MaxLabelNamesPerSeries > 0 && len(labels) > o.MaxLabelNamesPerSeries {
+		return &ValidationError{
+			Err:    ErrTooManyLabels,
+			Detail: fmt.Sprintf("series has %d labels, limit is %d", len(labels), o.MaxLabelNamesPerSeries),
+		}
+	}
+
+	totalSize := 0
+	for name, value := range labels {
+		if !IsValidLabelName(name) {
+			return &ValidationError{Err: ErrInvalidLabelName, Label: name, Detail: "must match [a-zA-Z_][a-zA-Z0-9_]*"}
+		}
+		if o.MaxLabelNameLength > 0 && len(name) > o.MaxLabelNameLength {
+			return &ValidationError{
+				Err:    ErrLabelNameTooLong,
+				Label:  name[:o.MaxLabelNameLength],
+				Detail: fmt.Sprintf("length %d exceeds limit %d", len(name), o.MaxLabelNameLength),
+			}
+		}
+		if !utf8.ValidString(value) {
+			return &ValidationError{Err: ErrInvalidLabelValue, Label: name, Detail: "value is not valid UTF-8"}
+		}
+		if o.MaxLabelValueLength > 0 && len(value) > o.MaxLabelValueLength {
+			return &ValidationError{
+				Err:    ErrLabelValueTooLong,
+				Label:  name,
+				Detail: fmt.Sprintf("value length %d exceeds limit %d", len(value), o.MaxLabelValueLength),
+			}
+		}
+		if name == MetricNameLabel && !isValidMetricName(value) {
+			return &ValidationError{Err: ErrInvalidMetricName, Label: name, Detail: fmt.Sprintf("%q must match [a-zA-Z_:][a-zA-Z0-9_:]*", value)}
+		}
+		totalSize += len(name) + len(value)
+	}
+
+	if o.MaxLabelsSize > 0 && totalSize > o.MaxLabelsSize {
+		return &ValidationError{
+			Err:    ErrLabelsTooLarge,
+			Detail: fmt.Sprintf("labels size %d bytes exceeds limit %d", totalSize, o.MaxLabelsSize),
+		}
+	}
+
+	return nil
+}
+
+// ValidateSeries checks the labels of a series as a write would, after
+// relabeling, without writing anything. A series dropped by relabeling is
+// valid.
+func (db *TSDB) ValidateSeries(labels map[string]string) error {
+	relabeled, keep := db.relabeler.relabel(series.NewSeries(labels))
+	if !keep {
+		return nil
+	}
+	return db.validation.ValidateLabels(relabeled.Labels)
+}
+
+// IsValidLabelName reports whether name matches [a-zA-Z_][a-zA-Z0-9_]*
+func IsValidLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9' && i > 0)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isValidMetricName checks a metric name against [a-zA-Z_:][a-zA-Z0-9_:]*
+func isValidMetricName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		if !(c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9' && i > 0)) {
+			return false
+		}
+	}
+	return true
+}
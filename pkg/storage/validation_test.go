@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestValidateLabels(t *testing.T) {
+	opts := &ValidationOptions{
+		MaxLabelNamesPerSeries: 3,
+		MaxLabelNameLength:     16,
+		MaxLabelValueLength:    32,
+		MaxLabelsSize:          64,
+	}
+
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		wantErr error
+	}{
+		{"valid", map[string]string{"__name__": "cpu:usage", "host": "server1"}, nil},
+		{"no labels", map[string]string{}, ErrInvalidLabelName},
+		{"empty name", map[string]string{"": "value"}, ErrInvalidLabelName},
+		{"name starts with digit", map[string]string{"1host": "a"}, ErrInvalidLabelName},
+		{"name with dash", map[string]string{"host-name": "a"}, ErrInvalidLabelName},
+		{"invalid utf8 value", map[string]string{"host": "\xff\xfe"}, ErrInvalidLabelValue},
+		{"invalid metric name", map[string]string{"__name__": "cpu usage"}, ErrInvalidMetricName},
+		{"too many labels", map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}, ErrTooManyLabels},
+		{"name too long", map[string]string{strings.Repeat("a", 17): "1"}, ErrLabelNameTooLong},
+		{"value too long", map[string]string{"host": strings.Repeat("a", 33)}, ErrLabelValueTooLong},
+		{"labels too large", map[string]string{"aaaaaaaaaa": strings.Repeat("x", 30), "bbbbbbbbbb": strings.Repeat("y", 30)}, ErrLabelsTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := opts.ValidateLabels(tt.labels)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			if !IsValidationError(err) {
+				t.Errorf("expected a ValidationError, got %T", err)
+			}
+		})
+	}
+}
+
+func TestValidateLabelsZeroLimitsDisableChecks(t *testing.T) {
+	opts := &ValidationOptions{}
+
+	labels := map[string]string{"host": strings.Repeat("a", 100000)}
+	if err := opts.ValidateLabels(labels); err != nil {
+		t.Errorf("expected no error with zero limits, got %v", err)
+	}
+}
+
+func TestTSDBInsertRejectsInvalidLabels(t *testing.T) {
+	db, err := Open(DefaultOptions(t.TempDir()))
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	s := series.NewSeries(map[string]string{"__name__": "cpu", "bad-label": "x"})
+	err = db.Insert(s, []series.Sample{{Timestamp: 1000, Value: 1}})
+	if !errors.Is(err, ErrInvalidLabelName) {
+		t.Fatalf("expected ErrInvalidLabelName, got %v", err)
+	}
+
+	if count := db.head.activeMemTable().SeriesCount(); count != 0 {
+		t.Errorf("rejected series should not reach the memtable, got %d series", count)
+	}
+}
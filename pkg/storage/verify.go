@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+)
+
+const (
+	// QuarantineDir is the data directory subdirectory corrupt blocks are
+	// moved to. It is not a ULID, so block listings ignore it.
+	QuarantineDir = "quarantine"
+
+	// DefaultVerifyChunkSamples is the number of chunk files per block whose
+	// checksums are verified on open
+	DefaultVerifyChunkSamples = 4
+)
+
+// ErrBlockCorrupt indicates a block failed verification
+var ErrBlockCorrupt = tsdberrors.New(tsdberrors.Corruption, "block corrupt")
+
+// QuarantinedBlock describes a block moved out of the data directory
+type QuarantinedBlock struct {
+	Block  string // Block directory name
+	Path   string // Where the block was moved to
+	Reason error  // Why verification failed
+}
+
+// VerifyBlock checks that the block in dir is readable: its metadata parses
+// and is consistent, every chunk file it references exists, and a sample of
+// up to chunkSamples chunk files pass their checksums (all of them if
+// chunkSamples is negative). Corruption is reported wrapping
+// ErrBlockCorrupt; blocks that are intact but written by a newer release
+// return the version error unwrapped.
+func VerifyBlock(dir string, chunkSamples int) error {
+	meta, err := readBlockMeta(dir)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBlockCorrupt, err)
+	}
+	if err := checkBlockVersion(meta); err != nil {
+		return err
+	}
+
+	if _, err := ulid.Parse(meta.ULID); err != nil {
+		return fmt.Errorf("%w: invalid ULID %q in metadata", ErrBlockCorrupt, meta.ULID)
+	}
+	if meta.ULID != filepath.Base(dir) {
+		return fmt.Errorf("%w: metadata ULID %s does not match directory", ErrBlockCorrupt, meta.ULID)
+	}
+	if meta.MinTime > meta.MaxTime {
+		return fmt.Errorf("%w: minTime %d after maxTime %d", ErrBlockCorrupt, meta.MinTime, meta.MaxTime)
+	}
+
+	// An index newer than this build is a version problem, not corruption
+	if _, err := index.ReadFormatVersion(filepath.Join(dir, IndexFile)); errors.Is(err, index.ErrIndexVersionTooNew) {
+		return err
+	}
+
+	chunkNums := make([]int, 0, len(meta.SeriesChunks))
+	for hashStr, chunkNum := range meta.SeriesChunks {
+		if _, err := strconv.ParseUint(hashStr, 10, 64); err != nil {
+			return fmt.Errorf("%w: invalid series hash %q in metadata", ErrBlockCorrupt, hashStr)
+		}
+		chunkNums = append(chunkNums, chunkNum)
+	}
+	sort.Ints(chunkNums)
+	chunkNums = slices.Compact(chunkNums)
+
+	for _, chunkNum := range chunkNums {
+		if _, err := os.Stat(chunkPath(dir, chunkNum)); err != nil {
+			return fmt.Errorf("%w: chunk %06d: %v", ErrBlockCorrupt, chunkNum, err)
+		}
+	}
+
+	for _, chunkNum := range sampleChunks(chunkNums, chunkSamples) {
+		data, err := os.ReadFile(chunkPath(dir, chunkNum))
+		if err != nil {
+			return fmt.Errorf("%w: chunk %06d: %v", ErrBlockCorrupt, chunkNum, err)
+		}
+		if err := NewChunk().UnmarshalBinary(data); err != nil {
+			if errors.Is(err, ErrChunkEncodingTooNew) {
+				return fmt.Errorf("block %s: chunk %06d: %w", meta.ULID, chunkNum, err)
+			}
+			return fmt.Errorf("%w: chunk %06d: %v", ErrBlockCorrupt, chunkNum, err)
+		}
+	}
+
+	return nil
+}
+
+// chunkPath returns the path of a chunk file within a block
+func chunkPath(dir string, chunkNum int) string {
+	return filepath.Join(dir, ChunksDir, fmt.Sprintf("%06d", chunkNum))
+}
+
+// sampleChunks picks up to n evenly spaced chunk numbers, always including
+// the first and last. Negative n selects all of them.
+func sampleChunks(chunkNums []int, n int) []int {
+	if n < 0 || len(chunkNums) <= n {
+		return chunkNums
+	}
+	if n == 0 {
+		return nil
+	}
+	if n == 1 {
+		return chunkNums[:1]
+	}
+
+	sampled := make([]int, 0, n)
+	last := len(chunkNums) - 1
+	for i := 0; i < n; i++ {
+		sampled = append(sampled, chunkNums[i*last/(n-1)])
+	}
+	return sampled
+}
+
+// QuarantineBlock moves the block in dir into dataDir's quarantine
+// directory and returns its new path. A block quarantined before under the
+// same name is kept; the new one gets a timestamp suffix.
+func QuarantineBlock(dataDir, dir string) (string, error) {
+	quarantineDir := filepath.Join(dataDir, QuarantineDir)
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+
+	dest := filepath.Join(quarantineDir, filepath.Base(dir))
+	if _, err := os.Stat(dest); err == nil {
+		dest = fmt.Sprintf("%s.%d", dest, time.Now().UnixMilli())
+	}
+
+	if err := os.Rename(dir, dest); err != nil {
+		return "", fmt.Errorf("failed to quarantine block %s: %w", filepath.Base(dir), err)
+	}
+	return dest, nil
+}
+
+// QuarantineCorruptBlocks verifies every block in dataDir and moves the
+// corrupt ones into quarantine. chunkSamples is passed to VerifyBlock.
+// Blocks from a newer release are left in place; CheckFormatVersions
+// reports them.
+func QuarantineCorruptBlocks(dataDir string, chunkSamples int) ([]QuarantinedBlock, error) {
+	dirs, err := blockDirs(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var quarantined []QuarantinedBlock
+	for _, dir := range dirs {
+		verifyErr := VerifyBlock(dir, chunkSamples)
+		if !errors.Is(verifyErr, ErrBlockCorrupt) {
+			continue
+		}
+
+		dest, err := QuarantineBlock(dataDir, dir)
+		if err != nil {
+			return quarantined, err
+		}
+		quarantined = append(quarantined, QuarantinedBlock{
+			Block:  filepath.Base(dir),
+			Path:   dest,
+			Reason: verifyErr,
+		})
+	}
+	return quarantined, nil
+}
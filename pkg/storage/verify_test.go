@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// writeTestBlock persists a block with one chunk file per series
+func writeTestBlock(t *testing.T, dataDir string, numSeries int) *Block {
+	t.Helper()
+
+	block, err := NewBlock(1000, 10000)
+	if err != nil {
+		t.Fatalf("NewBlock failed: %v", err)
+	}
+	for i := 0; i < numSeries; i++ {
+		s := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": string(rune('a' + i))})
+		if err := block.AddSeries(s, []series.Sample{{Timestamp: 1000, Value: float64(i)}}); err != nil {
+			t.Fatalf("AddSeries failed: %v", err)
+		}
+	}
+	if err := block.Persist(dataDir); err != nil {
+		t.Fatalf("Persist failed: %v", err)
+	}
+	return block
+}
+
+func TestVerifyBlock(t *testing.T) {
+	dataDir := t.TempDir()
+	block := writeTestBlock(t, dataDir, 3)
+
+	if err := VerifyBlock(block.Dir(), -1); err != nil {
+		t.Fatalf("VerifyBlock on intact block: %v", err)
+	}
+
+	// Flip a byte in a chunk's data so its checksum no longer matches
+	chunkFile := chunkPath(block.Dir(), 1)
+	data, err := os.ReadFile(chunkFile)
+	if err != nil {
+		t.Fatalf("failed to read chunk: %v", err)
+	}
+	data[ChunkHeaderSize] ^= 0xff
+	if err := os.WriteFile(chunkFile, data, 0644); err != nil {
+		t.Fatalf("failed to write chunk: %v", err)
+	}
+
+	if err := VerifyBlock(block.Dir(), -1); !errors.Is(err, ErrBlockCorrupt) {
+		t.Errorf("VerifyBlock error = %v, want ErrBlockCorrupt", err)
+	}
+	if err := VerifyBlock(block.Dir(), 0); err != nil {
+		t.Errorf("VerifyBlock without chunk sampling = %v, want nil", err)
+	}
+
+	// A missing chunk file is caught even without sampling
+	if err := os.Remove(chunkFile); err != nil {
+		t.Fatalf("failed to remove chunk: %v", err)
+	}
+	if err := VerifyBlock(block.Dir(), 0); !errors.Is(err, ErrBlockCorrupt) {
+		t.Errorf("VerifyBlock error = %v, want ErrBlockCorrupt", err)
+	}
+}
+
+func TestVerifyBlockNewerVersionNotCorrupt(t *testing.T) {
+	block := writeTestBlock(t, t.TempDir(), 1)
+	setMetaVersion(t, block.Dir(), BlockVersion+1, 0)
+
+	err := VerifyBlock(block.Dir(), -1)
+	if !errors.Is(err, ErrBlockVersionTooNew) || errors.Is(err, ErrBlockCorrupt) {
+		t.Errorf("VerifyBlock error = %v, want ErrBlockVersionTooNew only", err)
+	}
+}
+
+func TestSampleChunks(t *testing.T) {
+	chunkNums := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+
+	tests := []struct {
+		n    int
+		want []int
+	}{
+		{n: -1, want: chunkNums},
+		{n: 0, want: nil},
+		{n: 1, want: []int{0}},
+		{n: 2, want: []int{0, 9}},
+		{n: 4, want: []int{0, 3, 6, 9}},
+		{n: 20, want: chunkNums},
+	}
+	for _, tt := range tests {
+		got := sampleChunks(chunkNums, tt.n)
+		if len(got) != len(tt.want) {
+			t.Errorf("sampleChunks(%d) = %v, want %v", tt.n, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("sampleChunks(%d) = %v, want %v", tt.n, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestOpenQuarantinesCorruptBlocks(t *testing.T) {
+	dataDir := t.TempDir()
+	good := writeTestBlock(t, dataDir, 2)
+	bad := writeTestBlock(t, dataDir, 2)
+
+	if err := os.WriteFile(filepath.Join(bad.Dir(), MetaFile), []byte("{not json"), 0644); err != nil {
+		t.Fatalf("failed to corrupt meta: %v", err)
+	}
+
+	opts := DefaultOptions(dataDir)
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	if got := db.GetStatsSnapshot().QuarantinedBlocks; got != 1 {
+		t.Errorf("QuarantinedBlocks = %d, want 1", got)
+	}
+
+	if _, err := os.Stat(good.Dir()); err != nil {
+		t.Errorf("intact block was moved: %v", err)
+	}
+	if _, err := os.Stat(bad.Dir()); !os.IsNotExist(err) {
+		t.Errorf("corrupt block still in data directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, QuarantineDir, filepath.Base(bad.Dir()))); err != nil {
+		t.Errorf("corrupt block not in quarantine: %v", err)
+	}
+
+	dirs, err := blockDirs(dataDir)
+	if err != nil {
+		t.Fatalf("blockDirs failed: %v", err)
+	}
+	if len(dirs) != 1 || dirs[0] != good.Dir() {
+		t.Errorf("blockDirs = %v, want [%s]", dirs, good.Dir())
+	}
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/wal"
+)
+
+// WALStats describes the WAL segments and how long replaying them would take
+type WALStats struct {
+	wal.Stats
+
+	// ReplayEstimate is how long replaying the WAL on a restart would
+	// take at the rate the WAL was replayed when the database was opened.
+	// Zero if that replay read nothing to measure a rate by.
+	ReplayEstimate time.Duration
+}
+
+// WALStats returns the segments of the WAL, their sizes and entry time
+// ranges, the last fsync and an estimate of the time to replay the WAL
+func (db *TSDB) WALStats() (WALStats, error) {
+	if db.closed.Load() {
+		return WALStats{}, ErrClosed
+	}
+
+	stats, err := db.walWriter.Stats()
+	if err != nil {
+		return WALStats{}, err
+	}
+
+	result := WALStats{Stats: stats}
+	if db.replay.Bytes > 0 && db.replay.Elapsed > 0 {
+		rate := float64(db.replay.Bytes) / db.replay.Elapsed.Seconds()
+		result.ReplayEstimate = time.Duration(float64(stats.Size) / rate * float64(time.Second))
+	}
+	return result, nil
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// DefaultWatchBufferSize is the number of updates buffered per subscription
+const DefaultWatchBufferSize = 256
+
+// SampleUpdate is a batch of samples ingested for one series
+type SampleUpdate struct {
+	Labels  map[string]string
+	Samples []series.Sample
+}
+
+// Subscription receives the samples ingested for series matching any of
+// its matcher sets. Writers never block on subscribers: if the buffer is
+// full the update is dropped and counted.
+type Subscription struct {
+	id          uint64
+	hub         *watchHub
+	matcherSets []index.Matchers
+	updates     chan SampleUpdate
+	dropped     atomic.Int64
+	closeOnce   sync.Once
+}
+
+// Updates returns the channel updates are delivered on. It is closed when
+// the subscription or the database is closed.
+func (s *Subscription) Updates() <-chan SampleUpdate {
+	return s.updates
+}
+
+// Dropped returns the number of updates dropped because the subscriber
+// fell behind
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close unsubscribes and closes the updates channel
+func (s *Subscription) Close() {
+	s.hub.remove(s)
+}
+
+// matches reports whether the series labels match any matcher set
+func (s *Subscription) matches(labels map[string]string) bool {
+	if len(s.matcherSets) == 0 {
+		return true
+	}
+	for _, matchers := range s.matcherSets {
+		if matchers.Matches(labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchHub fans ingested samples out to subscriptions
+type watchHub struct {
+	mu     sync.RWMutex
+	subs   map[uint64]*Subscription
+	nextID uint64
+	closed bool
+
+	// count lets publish skip locking when nobody is watching
+	count atomic.Int32
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{subs: make(map[uint64]*Subscription)}
+}
+
+// subscribe registers a new subscription
+func (h *watchHub) subscribe(matcherSets []index.Matchers, bufferSize int) (*Subscription, error) {
+	if bufferSize <= 0 {
+		bufferSize = DefaultWatchBufferSize
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, ErrClosed
+	}
+
+	h.nextID++
+	sub := &Subscription{
+		id:          h.nextID,
+		hub:         h,
+		matcherSets: matcherSets,
+		updates:     make(chan SampleUpdate, bufferSize),
+	}
+	h.subs[sub.id] = sub
+	h.count.Add(1)
+	return sub, nil
+}
+
+// remove unregisters a subscription and closes its channel
+func (h *watchHub) remove(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[sub.id]; ok {
+		delete(h.subs, sub.id)
+		h.count.Add(-1)
+	}
+	sub.closeOnce.Do(func() { close(sub.updates) })
+}
+
+// publish delivers samples to every matching subscription without blocking
+func (h *watchHub) publish(s *series.Series, samples []series.Sample) {
+	if h.count.Load() == 0 {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var update *SampleUpdate
+	for _, sub := range h.subs {
+		if !sub.matches(s.Labels) {
+			continue
+		}
+		if update == nil {
+			// Copy once, since callers may reuse their slices
+			update = &SampleUpdate{
+				Labels:  s.Clone().Labels,
+				Samples: append([]series.Sample(nil), samples...),
+			}
+		}
+		select {
+		case sub.updates <- *update:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// close closes every subscription and refuses new ones
+func (h *watchHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for id, sub := range h.subs {
+		delete(h.subs, id)
+		sub.closeOnce.Do(func() { close(sub.updates) })
+	}
+	h.count.Store(0)
+}
+
+// Watch subscribes to samples ingested for series matching any of the
+// matcher sets; with no matcher sets every series is watched. bufferSize
+// is the number of updates buffered before new ones are dropped (0 means
+// DefaultWatchBufferSize). The caller must Close the subscription.
+func (db *TSDB) Watch(bufferSize int, matcherSets ...index.Matchers) (*Subscription, error) {
+	if db.closed.Load() {
+		return nil, ErrClosed
+	}
+	return db.watchers.subscribe(matcherSets, bufferSize)
+}
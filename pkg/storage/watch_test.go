@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/index"
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+func TestWatchDeliversMatchingSamples(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.EnableCompaction = false
+	opts.EnableRetention = false
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open TSDB: %v", err)
+	}
+	defer db.Close()
+
+	sub, err := db.Watch(0, index.Matchers{index.MustNewMatcher(index.MatchEqual, "host", "server1")})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer sub.Close()
+
+	other := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "server2"})
+	watched := series.NewSeries(map[string]string{"__name__": "cpu_usage", "host": "server1"})
+	samples := []series.Sample{{Timestamp: 1000, Value: 1.5}}
+
+	if err := db.Insert(other, samples); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.Insert(watched, samples); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	samples[0].Value = 99 // Updates must not alias the caller's slice
+
+	select {
+	case update := <-sub.Updates():
+		if update.Labels["host"] != "server1" {
+			t.Errorf("got update for %v, want host=server1", update.Labels)
+		}
+		if len(update.Samples) != 1 || update.Samples[0].Value != 1.5 {
+			t.Errorf("unexpected samples %v", update.Samples)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no update received")
+	}
+
+	select {
+	case update := <-sub.Updates():
+		t.Errorf("unexpected extra update %v", update.Labels)
+	default:
+	}
+}
+
+func TestWatchDropsWhenFull(t *testing.T) {
+	hub := newWatchHub()
+	sub, err := hub.subscribe(nil, 1)
+	if err != nil {
+		t.Fatalf("subscribe failed: %v", err)
+	}
+
+	s := series.NewSeries(map[string]string{"__name__": "m"})
+	for i := 0; i < 3; i++ {
+		hub.publish(s, []series.Sample{{Timestamp: int64(i), Value: 1}})
+	}
+	if got := sub.Dropped(); got != 2 {
+		t.Errorf("Dropped() = %d, want 2", got)
+	}
+
+	// Closing the hub ends the subscription; closing it again is harmless
+	hub.close()
+	<-sub.Updates()
+	if _, ok := <-sub.Updates(); ok {
+		t.Error("updates channel still open after hub close")
+	}
+	sub.Close()
+
+	if _, err := hub.subscribe(nil, 1); err != ErrClosed {
+		t.Errorf("subscribe after close: got %v, want ErrClosed", err)
+	}
+}
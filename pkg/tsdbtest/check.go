@@ -0,0 +1,211 @@
+package tsdbtest
+
+import (
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
+	"github.com/therealutkarshpriyadarshi/time/pkg/wal"
+)
+
+// CheckChunkRoundTrip encodes samples into a chunk, marshals and unmarshals
+// it, and checks that iterating the result returns exactly the samples.
+// Values are compared bit for bit, so NaNs must survive too.
+func CheckChunkRoundTrip(t testing.TB, samples []series.Sample) {
+	t.Helper()
+	chunk := storage.NewChunk()
+	if err := chunk.Append(samples); err != nil {
+		t.Fatalf("chunk append failed: %v", err)
+	}
+	data, err := chunk.MarshalBinary()
+	if err != nil {
+		t.Fatalf("chunk marshal failed: %v", err)
+	}
+
+	decoded := &storage.Chunk{}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("chunk unmarshal failed: %v", err)
+	}
+	if decoded.MinTime != samples[0].Timestamp || decoded.MaxTime != samples[len(samples)-1].Timestamp {
+		t.Errorf("chunk time range [%d, %d], want [%d, %d]",
+			decoded.MinTime, decoded.MaxTime, samples[0].Timestamp, samples[len(samples)-1].Timestamp)
+	}
+
+	it, err := decoded.Iterator()
+	if err != nil {
+		t.Fatalf("chunk iterator failed: %v", err)
+	}
+	var got []series.Sample
+	for it.Next() {
+		s, err := it.At()
+		if err != nil {
+			t.Fatalf("chunk sample %d: %v", len(got), err)
+		}
+		got = append(got, s)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("chunk iteration failed: %v", err)
+	}
+	checkSamples(t, "chunk", samples, got)
+}
+
+// CheckWALReplay appends records to a new WAL in dir, one entry each, then
+// reopens it and checks that replay returns them in order. opts may be nil;
+// a small SegmentSize exercises rotation and entries split across segments.
+func CheckWALReplay(t testing.TB, dir string, opts *wal.Options, records []wal.Record) {
+	t.Helper()
+	w, err := wal.Open(dir, opts)
+	if err != nil {
+		t.Fatalf("WAL open failed: %v", err)
+	}
+	for i, rec := range records {
+		if err := w.Append(rec.Series, rec.Samples); err != nil {
+			w.Close()
+			t.Fatalf("WAL append %d failed: %v", i, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("WAL close failed: %v", err)
+	}
+
+	got := ReplayWAL(t, dir, opts)
+	if len(got) != len(records) {
+		t.Fatalf("WAL replayed %d records, want %d", len(got), len(records))
+	}
+	for i := range records {
+		checkRecord(t, i, records[i], got[i])
+	}
+}
+
+// CheckWALPrefix checks that replaying the WAL in dir returns a prefix of
+// records, in order: what a WAL holding them must replay after a torn write
+// or corruption of its only segment.
+func CheckWALPrefix(t testing.TB, dir string, opts *wal.Options, records []wal.Record) []wal.Record {
+	t.Helper()
+	got := ReplayWAL(t, dir, opts)
+	if len(got) > len(records) {
+		t.Fatalf("WAL replayed %d records, more than the %d written", len(got), len(records))
+	}
+	for i := range got {
+		checkRecord(t, i, records[i], got[i])
+	}
+	return got
+}
+
+// ReplayWAL opens the WAL in dir and returns the series and samples of the
+// sample entries it replays.
+func ReplayWAL(t testing.TB, dir string, opts *wal.Options) []wal.Record {
+	t.Helper()
+	w, err := wal.Open(dir, opts)
+	if err != nil {
+		t.Fatalf("WAL reopen failed: %v", err)
+	}
+	defer w.Close()
+
+	entries, err := w.Replay()
+	if err != nil {
+		t.Fatalf("WAL replay failed: %v", err)
+	}
+	var records []wal.Record
+	for _, entry := range entries {
+		if entry.Series != nil {
+			records = append(records, wal.Record{Series: entry.Series, Samples: entry.Samples})
+		}
+	}
+	return records
+}
+
+// SnapshotBlocks returns every sample in the blocks of dataDir by series
+// hash, in timestamp order. A timestamp held by several blocks takes the
+// value of the block with the latest ULID, as compaction does.
+func SnapshotBlocks(t testing.TB, dataDir string) map[uint64][]series.Sample {
+	t.Helper()
+	reader := storage.NewBlockReader(dataDir)
+	if err := reader.LoadBlocks(); err != nil {
+		t.Fatalf("loading blocks failed: %v", err)
+	}
+	blocks := reader.Blocks()
+	defer func() {
+		for _, block := range blocks {
+			block.Close()
+		}
+	}()
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].ULID.Compare(blocks[j].ULID) < 0
+	})
+
+	byTimestamp := make(map[uint64]map[int64]float64)
+	for _, block := range blocks {
+		blockSeries, err := block.Series()
+		if err != nil {
+			t.Fatalf("block %s: reading series failed: %v", block.ULID, err)
+		}
+		for _, s := range blockSeries {
+			labels, hash := s.Labels, s.Hash
+			samples, err := block.GetSeries(hash, math.MinInt64, math.MaxInt64)
+			if err != nil {
+				t.Fatalf("block %s: reading series %v failed: %v", block.ULID, labels, err)
+			}
+			if byTimestamp[hash] == nil {
+				byTimestamp[hash] = make(map[int64]float64)
+			}
+			for _, s := range samples {
+				byTimestamp[hash][s.Timestamp] = s.Value
+			}
+		}
+	}
+
+	snapshot := make(map[uint64][]series.Sample, len(byTimestamp))
+	for hash, values := range byTimestamp {
+		samples := make([]series.Sample, 0, len(values))
+		for ts, v := range values {
+			samples = append(samples, series.Sample{Timestamp: ts, Value: v})
+		}
+		sort.Slice(samples, func(i, j int) bool { return samples[i].Timestamp < samples[j].Timestamp })
+		snapshot[hash] = samples
+	}
+	return snapshot
+}
+
+// CheckCompactionPreservesSamples snapshots the blocks of dataDir, calls
+// compact, e.g. a compactor's CompactNow, and checks that the blocks left
+// hold exactly the same samples.
+func CheckCompactionPreservesSamples(t testing.TB, dataDir string, compact func() error) {
+	t.Helper()
+	before := SnapshotBlocks(t, dataDir)
+	if err := compact(); err != nil {
+		t.Fatalf("compaction failed: %v", err)
+	}
+	after := SnapshotBlocks(t, dataDir)
+
+	if len(after) != len(before) {
+		t.Errorf("compaction left %d series, want %d", len(after), len(before))
+	}
+	for hash, want := range before {
+		checkSamples(t, "compacted series", want, after[hash])
+	}
+}
+
+// checkRecord compares a replayed WAL record with the one written
+func checkRecord(t testing.TB, i int, want, got wal.Record) {
+	t.Helper()
+	if got.Series == nil || !got.Series.Equals(want.Series) {
+		t.Fatalf("WAL record %d: series %v, want %v", i, got.Series, want.Series)
+	}
+	checkSamples(t, "WAL record", want.Samples, got.Samples)
+}
+
+// checkSamples compares samples, values bit for bit
+func checkSamples(t testing.TB, what string, want, got []series.Sample) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %d samples, want %d", what, len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Timestamp != want[i].Timestamp || math.Float64bits(got[i].Value) != math.Float64bits(want[i].Value) {
+			t.Fatalf("%s: sample %d is %v, want %v", what, i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package tsdbtest
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// FlipBits returns a copy of data with n random bits flipped
+func FlipBits(r *rand.Rand, data []byte, n int) []byte {
+	out := append([]byte(nil), data...)
+	if len(out) == 0 {
+		return out
+	}
+	for ; n > 0; n-- {
+		out[r.Intn(len(out))] ^= 1 << r.Intn(8)
+	}
+	return out
+}
+
+// FlipFileBit flips one random bit of the file at path at or after offset
+// from, simulating bit rot, and returns the offset of the changed byte.
+func FlipFileBit(t testing.TB, r *rand.Rand, path string, from int64) int64 {
+	t.Helper()
+	data := readFile(t, path)
+	if from >= int64(len(data)) {
+		t.Fatalf("tsdbtest: %s has no byte at or after offset %d", path, from)
+	}
+
+	offset := from + r.Int63n(int64(len(data))-from)
+	data[offset] ^= 1 << r.Intn(8)
+	writeFile(t, path, data)
+	return offset
+}
+
+// TruncateFile cuts the file at path to a random length shorter than its
+// size but no shorter than minSize, simulating a torn write, and returns the
+// new length.
+func TruncateFile(t testing.TB, r *rand.Rand, path string, minSize int64) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("tsdbtest: %v", err)
+	}
+	if info.Size() <= minSize {
+		t.Fatalf("tsdbtest: %s is only %d bytes, can't truncate to at least %d", path, info.Size(), minSize)
+	}
+
+	size := minSize + r.Int63n(info.Size()-minSize)
+	if err := os.Truncate(path, size); err != nil {
+		t.Fatalf("tsdbtest: %v", err)
+	}
+	return size
+}
+
+// OverwriteFile replaces n bytes of the file at path from offset with
+// random data, simulating a misdirected write. The file is extended if
+// needed.
+func OverwriteFile(t testing.TB, r *rand.Rand, path string, offset int64, n int) {
+	t.Helper()
+	junk := make([]byte, n)
+	r.Read(junk)
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("tsdbtest: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt(junk, offset); err != nil {
+		t.Fatalf("tsdbtest: %v", err)
+	}
+}
+
+// readFile reads the file at path, failing t on error
+func readFile(t testing.TB, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("tsdbtest: %v", err)
+	}
+	return data
+}
+
+// writeFile replaces the contents of the file at path, failing t on error
+func writeFile(t testing.TB, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("tsdbtest: %v", err)
+	}
+}
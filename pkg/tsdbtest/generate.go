@@ -0,0 +1,115 @@
+// Package tsdbtest provides helpers for testing code built on the storage
+// engine: generators of random label sets and sample streams, injectors
+// that corrupt files on disk, and checkers for the invariants the engine
+// must keep, such as chunks and the WAL returning what was written and
+// compaction preserving every sample.
+//
+// Generators take a *rand.Rand so a failing case can be reproduced from its
+// seed. Checkers report failures through testing.TB, so they can be called
+// from tests, benchmarks and fuzz targets alike.
+package tsdbtest
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+const (
+	// labelChars are the characters of generated label names
+	labelChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_0123456789"
+
+	// valueChars are the characters of generated label values, including
+	// some outside ASCII
+	valueChars = "abcdefghijklmnopqrstuvwxyz0123456789-_.:/ äöü→日本"
+)
+
+// LabelSet returns a random label set that passes the default validation
+// options: a metric name and up to maxLabels other labels.
+func LabelSet(r *rand.Rand, maxLabels int) map[string]string {
+	labels := map[string]string{
+		"__name__": "metric_" + randomString(r, labelChars, 1+r.Intn(12)),
+	}
+	for n := r.Intn(maxLabels + 1); n > 0; n-- {
+		// Label names may not start with a digit
+		name := randomString(r, labelChars[:53], 1) + randomString(r, labelChars, r.Intn(16))
+		if name == "__name__" {
+			continue
+		}
+		labels[name] = randomString(r, valueChars, 1+r.Intn(24))
+	}
+	return labels
+}
+
+// SeriesSet returns n distinct random series with up to maxLabels labels
+// besides their metric name.
+func SeriesSet(r *rand.Rand, n, maxLabels int) []*series.Series {
+	result := make([]*series.Series, 0, n)
+	seen := make(map[uint64]bool, n)
+	for len(result) < n {
+		labels := LabelSet(r, maxLabels)
+		// A unique label keeps small label spaces from colliding
+		labels["series"] = fmt.Sprint(len(result))
+		s := series.NewSeries(labels)
+		if seen[s.Hash] {
+			continue
+		}
+		seen[s.Hash] = true
+		result = append(result, s)
+	}
+	return result
+}
+
+// Samples returns n samples with strictly increasing timestamps from start.
+// The stream mixes what compression has to cope with: regular and jittered
+// scrape intervals, gaps, repeated and integer values, large jumps, and the
+// occasional NaN, infinity and negative zero.
+func Samples(r *rand.Rand, n int, start int64) []series.Sample {
+	samples := make([]series.Sample, n)
+	ts := start
+	interval := int64(1 + r.Intn(60_000))
+	value := r.NormFloat64() * 100
+
+	for i := range samples {
+		if i > 0 {
+			switch p := r.Intn(100); {
+			case p < 60:
+				ts += interval
+			case p < 85:
+				ts += max(1, interval+int64(r.Intn(2001))-1000)
+			case p < 95:
+				ts += 1 + r.Int63n(1000)
+			default:
+				ts += 1 + r.Int63n(int64(24*3600*1000))
+			}
+		}
+
+		switch p := r.Intn(100); {
+		case p < 40:
+			value += r.NormFloat64()
+		case p < 60:
+			// Repeated value
+		case p < 80:
+			value = float64(r.Intn(1000))
+		case p < 95:
+			value = r.NormFloat64() * math.Pow(10, float64(r.Intn(40)-20))
+		default:
+			value = []float64{math.NaN(), math.Inf(1), math.Inf(-1), math.Copysign(0, -1)}[r.Intn(4)]
+		}
+
+		samples[i] = series.Sample{Timestamp: ts, Value: value}
+	}
+	return samples
+}
+
+// randomString returns n random characters of chars
+func randomString(r *rand.Rand, chars string, n int) string {
+	runes := []rune(chars)
+	out := make([]rune, n)
+	for i := range out {
+		out[i] = runes[r.Intn(len(runes))]
+	}
+	return string(out)
+}
@@ -0,0 +1,209 @@
+package tsdbtest
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/storage"
+	"github.com/therealutkarshpriyadarshi/time/pkg/wal"
+)
+
+func TestLabelSetIsValid(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	validation := storage.DefaultValidationOptions()
+	for i := 0; i < 1000; i++ {
+		labels := LabelSet(r, 10)
+		if err := validation.ValidateLabels(labels); err != nil {
+			t.Fatalf("generated invalid labels %v: %v", labels, err)
+		}
+	}
+
+	if got := SeriesSet(r, 50, 0); len(got) != 50 {
+		t.Errorf("SeriesSet returned %d series, want 50", len(got))
+	}
+}
+
+func TestSamplesIncreasing(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	samples := Samples(r, 10000, 1000)
+	for i := 1; i < len(samples); i++ {
+		if samples[i].Timestamp <= samples[i-1].Timestamp {
+			t.Fatalf("sample %d at %d not after %d", i, samples[i].Timestamp, samples[i-1].Timestamp)
+		}
+	}
+}
+
+func TestChunkRoundTrip(t *testing.T) {
+	for seed := int64(0); seed < 200; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		CheckChunkRoundTrip(t, Samples(r, 1+r.Intn(storage.DefaultMaxSamplesPerChunk*2), r.Int63n(1<<40)))
+	}
+}
+
+func FuzzChunkRoundTrip(f *testing.F) {
+	f.Add(int64(0), uint16(1))
+	f.Add(int64(1), uint16(120))
+	f.Add(int64(2), uint16(5000))
+	f.Fuzz(func(t *testing.T, seed int64, n uint16) {
+		r := rand.New(rand.NewSource(seed))
+		CheckChunkRoundTrip(t, Samples(r, 1+int(n), r.Int63n(1<<40)))
+	})
+}
+
+// FuzzChunkCorruption checks that decoding a corrupted chunk fails cleanly
+// rather than panicking
+func FuzzChunkCorruption(f *testing.F) {
+	f.Add(int64(0), uint8(1))
+	f.Add(int64(1), uint8(8))
+	f.Fuzz(func(t *testing.T, seed int64, flips uint8) {
+		r := rand.New(rand.NewSource(seed))
+		chunk := storage.NewChunk()
+		if err := chunk.Append(Samples(r, 1+r.Intn(storage.DefaultMaxSamplesPerChunk), 0)); err != nil {
+			t.Fatal(err)
+		}
+		data, err := chunk.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded := &storage.Chunk{}
+		if err := decoded.UnmarshalBinary(FlipBits(r, data, 1+int(flips))); err != nil {
+			return
+		}
+		it, err := decoded.Iterator()
+		if err != nil {
+			return
+		}
+		for it.Next() {
+			if _, err := it.At(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// walRecords generates n records of a few series
+func walRecords(r *rand.Rand, n int) []wal.Record {
+	set := SeriesSet(r, 1+r.Intn(8), 5)
+	records := make([]wal.Record, n)
+	for i := range records {
+		records[i] = wal.Record{
+			Series:  set[r.Intn(len(set))],
+			Samples: Samples(r, 1+r.Intn(200), int64(i)*1_000_000),
+		}
+	}
+	return records
+}
+
+func TestWALReplay(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		r := rand.New(rand.NewSource(seed))
+
+		// Small segments split the larger entries
+		opts := &wal.Options{SegmentSize: 256 + r.Int63n(4096)}
+		CheckWALReplay(t, t.TempDir(), opts, walRecords(r, 1+r.Intn(50)))
+	}
+}
+
+func TestWALTornWrite(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		dir := t.TempDir()
+		records := walRecords(r, 2+r.Intn(20))
+		CheckWALReplay(t, dir, nil, records)
+
+		TruncateFile(t, r, filepath.Join(dir, "wal-00000000"), 0)
+		if got := CheckWALPrefix(t, dir, nil, records); len(got) == len(records) {
+			t.Errorf("seed %d: torn WAL replayed every record", seed)
+		}
+	}
+}
+
+func TestWALBitFlip(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		dir := t.TempDir()
+		records := walRecords(r, 2+r.Intn(20))
+		CheckWALReplay(t, dir, nil, records)
+
+		// Flip a bit past the first entry's header, whose length field
+		// could otherwise claim a huge payload
+		FlipFileBit(t, r, filepath.Join(dir, "wal-00000000"), 20)
+		CheckWALPrefix(t, dir, nil, records)
+	}
+}
+
+func TestCompactionPreservesSamples(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	dataDir := t.TempDir()
+	set := SeriesSet(r, 10, 5)
+	base := time.Now().Add(-24 * time.Hour).Truncate(storage.Level1Duration).UnixMilli()
+	window := storage.Level0Duration.Milliseconds()
+
+	// Four adjacent level 0 blocks and one overlapping the second
+	persist := func(minTime int64, overlapping bool) {
+		block, err := storage.NewBlock(minTime, minTime+window-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range set[:1+r.Intn(len(set))] {
+			samples := Samples(r, 1+r.Intn(300), minTime)
+			n := 0
+			for n < len(samples) && samples[n].Timestamp < minTime+window {
+				n++
+			}
+			if err := block.AddSeries(s, samples[:n]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := block.Persist(dataDir); err != nil {
+			t.Fatal(err)
+		}
+		if overlapping {
+			if err := os.WriteFile(filepath.Join(block.Dir(), storage.OverlappingFile), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for i := int64(0); i < 4; i++ {
+		persist(base+i*window, false)
+	}
+	persist(base+window+window/2, true)
+
+	compactor := storage.NewCompactor(storage.DefaultCompactorOptions(dataDir))
+	defer compactor.Stop()
+	CheckCompactionPreservesSamples(t, dataDir, compactor.CompactNow)
+
+	if n := compactor.GetStats().BlocksMerged.Load(); n == 0 {
+		t.Error("compaction merged no blocks")
+	}
+}
+
+// The label set generators produce series usable in a database end to end
+func TestGeneratedSeriesInsert(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	opts := storage.DefaultOptions(t.TempDir())
+	opts.EnableRetention = false
+	opts.EnableCompaction = false
+	db, err := storage.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	start := time.Now().UnixMilli()
+	for _, s := range SeriesSet(r, 20, 8) {
+		samples := Samples(r, 50, start)
+		if err := db.Insert(s, samples); err != nil {
+			t.Fatalf("insert %v failed: %v", s.Labels, err)
+		}
+		got, err := db.Query(s.Hash, samples[0].Timestamp, samples[len(samples)-1].Timestamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSamples(t, "inserted series", samples, got)
+	}
+}
@@ -0,0 +1,145 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// segmentMetaSuffix is appended to a segment's file name to name its
+// metadata sidecar
+const segmentMetaSuffix = ".meta"
+
+// SegmentMeta describes the entries of a segment. It is written next to
+// the segment when the segment is sealed by a rotation, or when the WAL is
+// closed, so Truncate can tell whether a segment can go without reading it.
+//
+// Segment files keep their sequence numbers, which tailers and checkpoints
+// address them by; the ULID identifies a segment across WALs, whose
+// numbering starts again from zero once the directory is emptied.
+type SegmentMeta struct {
+	ULID    string `json:"ulid"`
+	Created int64  `json:"created"` // Unix milliseconds
+
+	// MinTime and MaxTime bound the timestamps of the segment's entries,
+	// the time they were appended at (Unix milliseconds). Both are zero
+	// for an empty segment.
+	MinTime int64 `json:"minTime"`
+	MaxTime int64 `json:"maxTime"`
+
+	// Entries counts the entries written, each fragment of a split entry
+	// counting as one
+	Entries int `json:"entries"`
+
+	// Size is the segment's size in bytes when the metadata was written.
+	// Metadata of a segment whose size differs is stale and ignored.
+	Size int64 `json:"size"`
+}
+
+// newSegmentMeta returns the metadata of a segment created now
+func newSegmentMeta() SegmentMeta {
+	now := time.Now()
+	return SegmentMeta{
+		ULID:    ulid.MustNew(ulid.Timestamp(now), ulid.DefaultEntropy()).String(),
+		Created: now.UnixMilli(),
+	}
+}
+
+// observe records an entry of size bytes written at timestamp
+func (m *SegmentMeta) observe(timestamp int64, size int) {
+	if m.Entries == 0 || timestamp < m.MinTime {
+		m.MinTime = timestamp
+	}
+	if m.Entries == 0 || timestamp > m.MaxTime {
+		m.MaxTime = timestamp
+	}
+	m.Entries++
+	m.Size += int64(size)
+}
+
+// segmentMetaPath returns the file path for a segment's metadata
+func (w *WAL) segmentMetaPath(segNum int) string {
+	return w.segmentPath(segNum) + segmentMetaSuffix
+}
+
+// writeSegmentMeta writes a segment's metadata, replacing any previous
+// metadata atomically
+func (w *WAL) writeSegmentMeta(segNum int, meta SegmentMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	path := w.segmentMetaPath(segNum)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// readSegmentMeta returns the metadata of a segment, read from its sidecar
+// if that matches the segment's size and rebuilt by scanning the segment
+// otherwise. The bool reports whether the segment was scanned.
+func (w *WAL) readSegmentMeta(segNum int) (SegmentMeta, bool, error) {
+	stat, err := os.Stat(w.segmentPath(segNum))
+	if err != nil {
+		return SegmentMeta{}, false, err
+	}
+
+	if data, err := os.ReadFile(w.segmentMetaPath(segNum)); err == nil {
+		var meta SegmentMeta
+		if err := json.Unmarshal(data, &meta); err == nil && meta.Size == stat.Size() {
+			return meta, false, nil
+		}
+	}
+
+	meta, err := w.scanSegmentMeta(segNum)
+	return meta, true, err
+}
+
+// scanSegmentMeta rebuilds a segment's metadata from its entries. The
+// segment is taken to have been created when its first entry was written.
+// If an entry can't be read, the metadata of the entries before it is
+// returned along with the error.
+func (w *WAL) scanSegmentMeta(segNum int) (SegmentMeta, error) {
+	file, err := os.Open(w.segmentPath(segNum))
+	if err != nil {
+		return SegmentMeta{}, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return SegmentMeta{}, err
+	}
+
+	meta := newSegmentMeta()
+	reader := bufio.NewReader(file)
+	for {
+		var entry *Entry
+		entry, err = decodeEntry(reader)
+		if err != nil {
+			break
+		}
+		meta.observe(entry.Timestamp, 0)
+	}
+	if meta.Entries > 0 {
+		meta.Created = meta.MinTime
+	}
+	meta.Size = stat.Size()
+
+	if err != io.EOF {
+		return meta, fmt.Errorf("wal: failed to scan segment %d: %w", segNum, err)
+	}
+	return meta, nil
+}
@@ -0,0 +1,76 @@
+package wal
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// SegmentStats describes a segment of the WAL
+type SegmentStats struct {
+	Number  int
+	Name    string // File name within the WAL directory
+	Current bool   // The segment being written
+	Meta    SegmentMeta
+}
+
+// Stats describes the segments of a WAL and its last fsync
+type Stats struct {
+	Segments []SegmentStats // In ascending order
+
+	Size    int64 // Bytes across all segments
+	Entries int   // Entries across all segments
+
+	// OldestEntry is the timestamp of the oldest entry (Unix
+	// milliseconds), 0 if the WAL is empty
+	OldestEntry int64
+
+	// LastSync is the duration of the last fsync, at LastSyncTime; both
+	// are zero until a segment has been synced
+	LastSync     time.Duration
+	LastSyncTime time.Time
+}
+
+// Stats returns the segments of the WAL with their metadata. Segments
+// without valid metadata are scanned; one that can't be read in full is
+// described by the entries before the first it can't read.
+func (w *WAL) Stats() (Stats, error) {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return Stats{}, ErrClosed
+	}
+	segments, err := w.listSegments()
+	current, currentMeta := w.currentSegment, w.meta
+	stats := Stats{LastSync: w.lastSync, LastSyncTime: w.lastSyncTime}
+	w.mu.Unlock()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	// Sealed segments don't change, so they are read without holding up
+	// writes
+	for _, segNum := range segments {
+		meta := currentMeta
+		if segNum != current {
+			if meta, _, err = w.readSegmentMeta(segNum); errors.Is(err, fs.ErrNotExist) {
+				continue // Truncated concurrently
+			}
+		}
+
+		stats.Segments = append(stats.Segments, SegmentStats{
+			Number:  segNum,
+			Name:    filepath.Base(w.segmentPath(segNum)),
+			Current: segNum == current,
+			Meta:    meta,
+		})
+		stats.Size += meta.Size
+		stats.Entries += meta.Entries
+		if meta.Entries > 0 && (stats.OldestEntry == 0 || meta.MinTime < stats.OldestEntry) {
+			stats.OldestEntry = meta.MinTime
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,259 @@
+package wal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
+)
+
+// ErrSegmentGap indicates segments were truncated before a tailer read
+// them, so the entries they held were missed
+var ErrSegmentGap = tsdberrors.New(tsdberrors.NotFound, "wal: segments truncated before they were read")
+
+// Position is a location in the WAL: a segment and a byte offset within it
+type Position struct {
+	Segment int   `json:"segment"`
+	Offset  int64 `json:"offset"`
+}
+
+// EndPosition returns the current end of the WAL in dir, so a new tailer
+// sees only entries appended from now on
+func EndPosition(dir string) (Position, error) {
+	segments, err := (&WAL{dir: dir}).listSegments()
+	if err != nil {
+		return Position{}, err
+	}
+	if len(segments) == 0 {
+		return Position{}, nil
+	}
+
+	last := segments[len(segments)-1]
+	stat, err := os.Stat((&WAL{dir: dir}).segmentPath(last))
+	if err != nil {
+		return Position{}, fmt.Errorf("wal: failed to stat segment: %w", err)
+	}
+	return Position{Segment: last, Offset: stat.Size()}, nil
+}
+
+// Tailer follows a WAL directory while it is being written, returning
+// sample entries in the order they were appended. It only reads segment
+// files, so it can run alongside the writer, including in another process.
+type Tailer struct {
+	dir string
+	pos Position
+
+	file   *os.File
+	reader *bufio.Reader
+	read   *countingReader
+
+	// rotated is set once a newer segment exists, meaning the current one
+	// is complete once it has been read to the end
+	rotated bool
+
+	// batch holds the records of a batch entry not returned yet, and
+	// batchEnd the position after the entry. The position stays at the
+	// start of the entry until its last record is returned.
+	batch    []Entry
+	batchEnd Position
+
+	// fragments holds the fragments of a split entry read so far, and
+	// fragmentsStart the position of the first. The position reported is
+	// fragmentsStart until the entry is complete.
+	fragments      reassembler
+	fragmentsStart Position
+}
+
+// NewTailer creates a tailer starting at pos
+func NewTailer(dir string, pos Position) *Tailer {
+	return &Tailer{dir: dir, pos: pos}
+}
+
+// Position returns the position just after the last entry returned by Next.
+// While the records of a batch entry are being returned, or the fragments
+// of a split entry read, it is the start of that entry, so resuming from
+// it may repeat some of them.
+func (t *Tailer) Position() Position {
+	if t.fragments.pending != nil {
+		return t.fragmentsStart
+	}
+	return t.pos
+}
+
+// Next returns the next samples entry. It returns io.EOF when no complete
+// entry follows the current position yet; call it again later. If segments
+// were truncated before being read, it returns ErrSegmentGap once and
+// continues with the oldest remaining segment. A corrupt or torn entry ends
+// its segment, as it does for Replay.
+func (t *Tailer) Next() (*Entry, error) {
+	for {
+		if len(t.batch) > 0 {
+			entry := t.batch[0]
+			t.batch = t.batch[1:]
+			if len(t.batch) == 0 {
+				t.pos = t.batchEnd
+			}
+			return &entry, nil
+		}
+
+		if t.reader == nil {
+			if err := t.open(); err != nil {
+				return nil, err
+			}
+		}
+
+		entry, err := decodeEntry(t.reader)
+		if err == nil {
+			start := t.pos
+			t.pos.Offset += t.read.n - int64(t.reader.Buffered())
+			t.resetCount()
+
+			// A split entry starts at its first fragment
+			switch entry.fragment {
+			case fragmentFirst:
+				t.fragmentsStart = start
+			case fragmentLast:
+				start = t.fragmentsStart
+			}
+			if entry = t.fragments.add(entry); entry == nil {
+				continue
+			}
+
+			if entry.Type == entryTypeSamples {
+				return entry, nil
+			}
+			if entry.Type == entryTypeBatch {
+				t.batch = entry.samplesEntries()
+				t.batchEnd = t.pos
+				if len(t.batch) > 0 {
+					t.pos = start
+				}
+			}
+			continue
+		}
+
+		incomplete := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if !incomplete && !errors.Is(err, ErrCorrupted) {
+			t.Close()
+			return nil, err
+		}
+
+		if t.rotated {
+			// Read to the end after rotation; the writer is done with it
+			t.advance(t.pos.Segment + 1)
+			continue
+		}
+
+		newer, err := t.newerSegmentExists()
+		if err != nil {
+			return nil, err
+		}
+		// Reopen at the last complete entry, either to re-read this segment
+		// to its end or to wait for more data
+		t.closeFile()
+		if !newer {
+			return nil, io.EOF
+		}
+		t.rotated = true
+	}
+}
+
+// Close releases the current segment file. The tailer can still be used;
+// it reopens the segment at its position.
+func (t *Tailer) Close() error {
+	t.pos = t.Position()
+	t.fragments.pending = nil
+	t.batch = nil
+	return t.closeFile()
+}
+
+// closeFile closes the current segment file, keeping the entries read
+// but not returned yet
+func (t *Tailer) closeFile() error {
+	t.reader = nil
+	t.read = nil
+	if t.file == nil {
+		return nil
+	}
+	err := t.file.Close()
+	t.file = nil
+	return err
+}
+
+// open opens the segment at the current position, skipping ahead past
+// truncated segments
+func (t *Tailer) open() error {
+	segments, err := (&WAL{dir: t.dir}).listSegments()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, segNum := range segments {
+		if segNum == t.pos.Segment {
+			found = true
+			break
+		}
+		if segNum > t.pos.Segment {
+			// Everything between the position and segNum is gone
+			t.fragments.pending = nil
+			t.advance(segNum)
+			return ErrSegmentGap
+		}
+	}
+	if !found {
+		// Not written yet
+		return io.EOF
+	}
+
+	file, err := os.Open((&WAL{dir: t.dir}).segmentPath(t.pos.Segment))
+	if err != nil {
+		return fmt.Errorf("wal: failed to open segment for tailing: %w", err)
+	}
+	if _, err := file.Seek(t.pos.Offset, io.SeekStart); err != nil {
+		file.Close()
+		return fmt.Errorf("wal: failed to seek segment: %w", err)
+	}
+
+	t.file = file
+	t.read = &countingReader{r: file}
+	t.reader = bufio.NewReader(t.read)
+	return nil
+}
+
+// advance moves the position to the start of a later segment
+func (t *Tailer) advance(segNum int) {
+	t.closeFile()
+	t.pos = Position{Segment: segNum}
+	t.rotated = false
+}
+
+// resetCount restarts byte counting at the current position
+func (t *Tailer) resetCount() {
+	t.read.n = int64(t.reader.Buffered())
+}
+
+// newerSegmentExists reports whether the writer has rotated past the
+// current segment
+func (t *Tailer) newerSegmentExists() (bool, error) {
+	segments, err := (&WAL{dir: t.dir}).listSegments()
+	if err != nil {
+		return false, err
+	}
+	return len(segments) > 0 && segments[len(segments)-1] > t.pos.Segment, nil
+}
+
+// countingReader counts the bytes read from the underlying reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
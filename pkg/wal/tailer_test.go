@@ -0,0 +1,249 @@
+package wal
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/therealutkarshpriyadarshi/time/pkg/series"
+)
+
+// tailAll reads entries until the tailer catches up, returning their first sample values
+func tailAll(t *testing.T, tailer *Tailer) []float64 {
+	t.Helper()
+	var values []float64
+	for {
+		entry, err := tailer.Next()
+		if err == io.EOF {
+			return values
+		}
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		values = append(values, entry.Samples[0].Value)
+	}
+}
+
+func TestTailer(t *testing.T) {
+	dir := t.TempDir()
+
+	// Small segments so the tailer crosses rotations
+	w, err := Open(dir, &Options{SegmentSize: 200})
+	if err != nil {
+		t.Fatalf("failed to open WAL: %v", err)
+	}
+	defer w.Close()
+
+	s := series.NewSeries(map[string]string{"__name__": "test_metric", "host": "server1"})
+	appendValue := func(v float64) {
+		if err := w.Append(s, []series.Sample{{Timestamp: 1000, Value: v}}); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+
+	pos, err := EndPosition(dir)
+	if err != nil {
+		t.Fatalf("EndPosition failed: %v", err)
+	}
+	tailer := NewTailer(dir, pos)
+	defer tailer.Close()
+
+	if values := tailAll(t, tailer); len(values) != 0 {
+		t.Fatalf("empty WAL returned %v", values)
+	}
+
+	appendValue(1)
+	appendValue(2)
+	if err := w.LogFlush(1000); err != nil {
+		t.Fatalf("LogFlush failed: %v", err)
+	}
+	appendValue(3)
+
+	if values := tailAll(t, tailer); len(values) != 3 || values[0] != 1 || values[2] != 3 {
+		t.Fatalf("got %v, want [1 2 3]", values)
+	}
+
+	for v := 4.0; v <= 8; v++ {
+		appendValue(v)
+	}
+	if values := tailAll(t, tailer); len(values) != 5 || values[0] != 4 || values[4] != 8 {
+		t.Fatalf("got %v, want [4 5 6 7 8]", values)
+	}
+	if tailer.Position().Segment == 0 {
+		t.Errorf("tailer did not follow segment rotation")
+	}
+
+	// A new tailer resumes from a saved position
+	appendValue(9)
+	resumed := NewTailer(dir, tailer.Position())
+	defer resumed.Close()
+	if values := tailAll(t, resumed); len(values) != 1 || values[0] != 9 {
+		t.Errorf("resumed tailer got %v, want [9]", values)
+	}
+}
+
+func TestTailerTornEntry(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("failed to open WAL: %v", err)
+	}
+	s := series.NewSeries(map[string]string{"__name__": "test_metric"})
+	if err := w.Append(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	w.Close()
+
+	// Half-written entry after the complete one
+	encoded, _ := encodeEntry(&Entry{Type: entryTypeSamples, Series: s, Samples: []series.Sample{{Timestamp: 2000, Value: 2}}})
+	f, err := os.OpenFile(w.segmentPath(0), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("failed to open segment: %v", err)
+	}
+	f.Write(encoded[:len(encoded)/2])
+
+	tailer := NewTailer(dir, Position{})
+	defer tailer.Close()
+	if values := tailAll(t, tailer); len(values) != 1 {
+		t.Fatalf("got %v, want [1]", values)
+	}
+	pos := tailer.Position()
+
+	// The rest arrives
+	f.Write(encoded[len(encoded)/2:])
+	f.Close()
+	if values := tailAll(t, tailer); len(values) != 1 || values[0] != 2 {
+		t.Errorf("got %v after completing the entry, want [2]", values)
+	}
+	if tailer.Position().Offset != pos.Offset+int64(len(encoded)) {
+		t.Errorf("position = %+v, want offset %d", tailer.Position(), pos.Offset+int64(len(encoded)))
+	}
+}
+
+func TestTailerSegmentGap(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(dir, &Options{SegmentSize: 100})
+	if err != nil {
+		t.Fatalf("failed to open WAL: %v", err)
+	}
+	defer w.Close()
+
+	s := series.NewSeries(map[string]string{"__name__": "test_metric"})
+	for v := 1.0; v <= 3; v++ {
+		if err := w.Append(s, []series.Sample{{Timestamp: 1000, Value: v}}); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+
+	// Truncated before the tailer got to it
+	if err := os.Remove(w.segmentPath(0)); err != nil {
+		t.Fatalf("failed to remove segment: %v", err)
+	}
+
+	tailer := NewTailer(dir, Position{})
+	defer tailer.Close()
+	if _, err := tailer.Next(); !errors.Is(err, ErrSegmentGap) {
+		t.Fatalf("Next error = %v, want ErrSegmentGap", err)
+	}
+	if values := tailAll(t, tailer); len(values) != 2 || values[0] != 2 {
+		t.Errorf("got %v after the gap, want [2 3]", values)
+	}
+}
+
+func TestTailerBatch(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("failed to open WAL: %v", err)
+	}
+	defer w.Close()
+
+	s := series.NewSeries(map[string]string{"__name__": "test_metric"})
+	record := func(v float64) Record {
+		return Record{Series: s, Samples: []series.Sample{{Timestamp: 1000, Value: v}}}
+	}
+	if err := w.AppendBatch([]Record{record(1), record(2), record(3)}); err != nil {
+		t.Fatalf("failed to append batch: %v", err)
+	}
+
+	tailer := NewTailer(dir, Position{})
+	defer tailer.Close()
+
+	// The position stays at the start of the batch until it is fully read
+	for i := 0; i < 2; i++ {
+		if _, err := tailer.Next(); err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		if tailer.Position().Offset != 0 {
+			t.Errorf("position = %+v after record %d, want start of batch", tailer.Position(), i+1)
+		}
+	}
+	entry, err := tailer.Next()
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	if entry.Samples[0].Value != 3 {
+		t.Errorf("last record value = %v, want 3", entry.Samples[0].Value)
+	}
+	end, _ := EndPosition(dir)
+	if tailer.Position() != end {
+		t.Errorf("position = %+v after the batch, want %+v", tailer.Position(), end)
+	}
+	if _, err := tailer.Next(); err != io.EOF {
+		t.Errorf("Next after the batch = %v, want io.EOF", err)
+	}
+}
+
+func TestTailerSplitEntry(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("failed to open WAL: %v", err)
+	}
+	w.Close()
+
+	s := series.NewSeries(map[string]string{"__name__": "test_metric"})
+	samples := make([]series.Sample, 100)
+	for i := range samples {
+		samples[i] = series.Sample{Timestamp: int64(i), Value: float64(i)}
+	}
+	fragments := splitEntry(&Entry{Type: entryTypeSamples, Series: s, Samples: samples}, 512)
+	if len(fragments) < 3 {
+		t.Fatalf("expected at least 3 fragments, got %d", len(fragments))
+	}
+
+	f, err := os.OpenFile(w.segmentPath(0), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("failed to open segment: %v", err)
+	}
+	defer f.Close()
+	for _, fragment := range fragments[:len(fragments)-1] {
+		encoded, _ := encodeEntry(fragment)
+		f.Write(encoded)
+	}
+
+	// Nothing is returned until the last fragment arrives
+	tailer := NewTailer(dir, Position{})
+	defer tailer.Close()
+	if values := tailAll(t, tailer); len(values) != 0 {
+		t.Fatalf("got %v from an incomplete entry", values)
+	}
+	if tailer.Position().Offset != 0 {
+		t.Errorf("position = %+v, want start of the entry", tailer.Position())
+	}
+
+	encoded, _ := encodeEntry(fragments[len(fragments)-1])
+	f.Write(encoded)
+	entry, err := tailer.Next()
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	if len(entry.Samples) != 100 || entry.Samples[99].Value != 99 {
+		t.Errorf("got %d samples, want 100", len(entry.Samples))
+	}
+	end, _ := EndPosition(dir)
+	if tailer.Position() != end {
+		t.Errorf("position = %+v, want %+v", tailer.Position(), end)
+	}
+}
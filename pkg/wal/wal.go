@@ -6,12 +6,14 @@ import (
 	"fmt"
 	"hash/crc32"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"sync"
 	"time"
 
+	tsdberrors "github.com/therealutkarshpriyadarshi/time/pkg/errors"
 	"github.com/therealutkarshpriyadarshi/time/pkg/series"
 )
 
@@ -19,22 +21,49 @@ const (
 	// DefaultSegmentSize is the default size for WAL segments (128MB)
 	DefaultSegmentSize = 128 * 1024 * 1024
 
-	// WAL file format constants
-	walVersion      = 1
-	entryHeaderSize = 20 // version(1) + type(1) + length(4) + checksum(4) + timestamp(8) + reserved(2)
+	// FormatVersion is the WAL format version written
+	FormatVersion = walVersion
+
+	// WAL file format constants. Version 2 stores sample values as IEEE 754
+	// bits; version 1 truncated them to integers and is still readable.
+	walVersion       = 2
+	walVersionLegacy = 1
+	entryHeaderSize = 20 // version(1) + type(1) + length(4) + checksum(4) + timestamp(8) + fragment(1) + reserved(1)
 
 	// Entry types
 	entryTypeSamples = 1
 	entryTypeFlush   = 2
 	entryTypeTruncate = 3
+	entryTypeBatch   = 4
+
+	// Fragment positions. Entries larger than a segment are written as
+	// several fragments, each an entry of the same type holding part of
+	// the samples, and joined again when read.
+	fragmentNone   = 0 // A whole entry
+	fragmentFirst  = 1
+	fragmentMiddle = 2
+	fragmentLast   = 3
+
+	// maxPooledBufferSize caps the encode buffers kept in entryBufPool so a
+	// single huge batch doesn't pin memory
+	maxPooledBufferSize = 1 << 20
 )
 
+// entryBufPool holds encode buffers for entries that don't fit in the
+// writer's free buffer space
+var entryBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 4096)
+		return &buf
+	},
+}
+
 var (
 	// ErrCorrupted indicates the WAL file is corrupted
-	ErrCorrupted = fmt.Errorf("wal: corrupted entry")
+	ErrCorrupted = tsdberrors.New(tsdberrors.Corruption, "wal: corrupted entry")
 
 	// ErrClosed indicates the WAL is closed
-	ErrClosed = fmt.Errorf("wal: closed")
+	ErrClosed = tsdberrors.New(tsdberrors.Unavailable, "wal: closed")
 )
 
 // Entry represents a single WAL entry
@@ -43,6 +72,26 @@ type Entry struct {
 	Timestamp int64
 	Series    *series.Series
 	Samples   []series.Sample
+
+	// Records holds the series of a batch entry
+	Records []Record
+
+	fragment uint8 // Position of the fragment within a split entry
+}
+
+// Record is one series' samples within a batch
+type Record struct {
+	Series  *series.Series
+	Samples []series.Sample
+}
+
+// samplesEntries expands a batch entry into one samples entry per record
+func (e *Entry) samplesEntries() []Entry {
+	entries := make([]Entry, len(e.Records))
+	for i, r := range e.Records {
+		entries[i] = Entry{Type: entryTypeSamples, Timestamp: e.Timestamp, Series: r.Series, Samples: r.Samples}
+	}
+	return entries
 }
 
 // WAL implements a write-ahead log for durability
@@ -55,11 +104,24 @@ type WAL struct {
 	size          int64
 	mu            sync.Mutex
 	closed        bool
+	onSync        func(time.Duration)
+
+	// meta describes the current segment, written as its sidecar once
+	// the segment is sealed
+	meta SegmentMeta
+
+	// Duration and time of the last fsync, reported by Stats
+	lastSync     time.Duration
+	lastSyncTime time.Time
 }
 
 // Options configures the WAL
 type Options struct {
 	SegmentSize int64
+
+	// OnSync, if set, is called with the duration of every successful
+	// fsync of a segment. It runs with the WAL locked, so it must be quick.
+	OnSync func(d time.Duration)
 }
 
 // DefaultOptions returns default WAL options
@@ -83,6 +145,7 @@ func Open(dir string, opts *Options) (*WAL, error) {
 	w := &WAL{
 		dir:         dir,
 		segmentSize: opts.SegmentSize,
+		onSync:      opts.OnSync,
 	}
 
 	// Find the latest segment or create a new one
@@ -121,25 +184,51 @@ func (w *WAL) Append(s *series.Series, samples []series.Sample) error {
 		Samples:   samples,
 	}
 
-	data, err := encodeEntry(entry)
-	if err != nil {
-		return fmt.Errorf("wal: failed to encode entry: %w", err)
-	}
+	return w.writeSync(entry)
+}
 
-	// Check if we need to rotate
-	if w.size+int64(len(data)) > w.segmentSize {
-		if err := w.rotate(); err != nil {
-			return err
-		}
+// AppendBatch writes samples for several series as a single entry, so
+// they are replayed all together or, if the entry is torn, not at all.
+// Replay and tailers return the records as individual samples entries.
+func (w *WAL) AppendBatch(records []Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
 	}
 
-	// Write to buffer
-	n, err := w.writer.Write(data)
-	if err != nil {
-		return fmt.Errorf("wal: failed to write entry: %w", err)
+	entry := &Entry{
+		Type:      entryTypeBatch,
+		Timestamp: time.Now().UnixMilli(),
+		Records:   records,
 	}
 
-	w.size += int64(n)
+	return w.writeSync(entry)
+}
+
+// writeSync writes an entry, rotating first if it doesn't fit in the
+// current segment, and syncs it to disk. An entry larger than a segment is
+// split into fragments, rotating between them as needed; the entry is only
+// replayed once all of them are written. w.mu must be held.
+func (w *WAL) writeSync(entry *Entry) error {
+	for _, fragment := range splitEntry(entry, w.segmentSize) {
+		// Check if we need to rotate
+		if w.size > 0 && w.size+int64(encodedSize(fragment)) > w.segmentSize {
+			if err := w.rotate(); err != nil {
+				return err
+			}
+		}
+
+		// Encode straight into the write buffer
+		n, err := writeEntry(w.writer, fragment)
+		if err != nil {
+			return fmt.Errorf("wal: failed to write entry: %w", err)
+		}
+
+		w.size += int64(n)
+		w.meta.observe(fragment.Timestamp, n)
+	}
 
 	// Flush to ensure durability
 	if err := w.writer.Flush(); err != nil {
@@ -147,7 +236,7 @@ func (w *WAL) Append(s *series.Series, samples []series.Sample) error {
 	}
 
 	// Sync to disk for durability
-	if err := w.file.Sync(); err != nil {
+	if err := w.syncFile(); err != nil {
 		return fmt.Errorf("wal: failed to sync: %w", err)
 	}
 
@@ -168,47 +257,96 @@ func (w *WAL) LogFlush(timestamp int64) error {
 		Timestamp: timestamp,
 	}
 
-	data, err := encodeEntry(entry)
+	n, err := writeEntry(w.writer, entry)
 	if err != nil {
-		return fmt.Errorf("wal: failed to encode flush entry: %w", err)
-	}
-
-	if _, err := w.writer.Write(data); err != nil {
 		return fmt.Errorf("wal: failed to write flush entry: %w", err)
 	}
+	w.size += int64(n)
+	w.meta.observe(entry.Timestamp, n)
 
 	if err := w.writer.Flush(); err != nil {
 		return fmt.Errorf("wal: failed to flush: %w", err)
 	}
 
-	if err := w.file.Sync(); err != nil {
+	if err := w.syncFile(); err != nil {
 		return fmt.Errorf("wal: failed to sync: %w", err)
 	}
 
 	return nil
 }
 
+// Size returns the total size in bytes of all WAL segments
+func (w *WAL) Size() (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	segments, err := w.listSegments()
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, segNum := range segments {
+		if segNum == w.currentSegment && !w.closed {
+			total += w.size
+			continue
+		}
+		stat, err := os.Stat(w.segmentPath(segNum))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // Truncated concurrently
+			}
+			return 0, fmt.Errorf("wal: failed to stat segment: %w", err)
+		}
+		total += stat.Size()
+	}
+
+	return total, nil
+}
+
 // Replay reads all WAL entries and returns them for recovery
 func (w *WAL) Replay() ([]Entry, error) {
-	segments, err := w.listSegments()
+	var entries []Entry
+	err := w.ReplaySegments(func(segmentEntries []Entry, done, total int) error {
+		entries = append(entries, segmentEntries...)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return entries, nil
+}
 
-	var entries []Entry
+// ReplaySegments reads the WAL one segment at a time, calling fn with the
+// entries of each segment read, the number of segments read so far and
+// their total, so a long replay can report progress without holding every
+// entry in memory. An entry split across segments is passed with the
+// segment holding its end. An error from fn stops the replay and is
+// returned.
+func (w *WAL) ReplaySegments(fn func(entries []Entry, done, total int) error) error {
+	segments, err := w.listSegments()
+	if err != nil {
+		return err
+	}
 
-	for _, segNum := range segments {
-		segmentEntries, err := w.replaySegment(segNum)
+	var fragments reassembler
+	for i, segNum := range segments {
+		entries, err := w.replaySegment(segNum, &fragments)
 		if err != nil {
-			return nil, fmt.Errorf("wal: failed to replay segment %d: %w", segNum, err)
+			return fmt.Errorf("wal: failed to replay segment %d: %w", segNum, err)
+		}
+		if err := fn(entries, i+1, len(segments)); err != nil {
+			return err
 		}
-		entries = append(entries, segmentEntries...)
 	}
 
-	return entries, nil
+	return nil
 }
 
-// Truncate removes WAL segments older than the specified timestamp
+// Truncate removes WAL segments older than the specified timestamp. Whether
+// a segment is older is read from its metadata; segments without valid
+// metadata are scanned, and the metadata of those kept is written for the
+// next call.
 func (w *WAL) Truncate(beforeTimestamp int64) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -228,21 +366,66 @@ func (w *WAL) Truncate(beforeTimestamp int64) error {
 			continue
 		}
 
-		// Check if this segment contains data before the timestamp
-		path := w.segmentPath(segNum)
-		lastEntry, err := w.getLastEntryTimestamp(path)
+		meta, scanned, err := w.readSegmentMeta(segNum)
 		if err != nil {
 			continue // Skip segments we can't read
 		}
 
 		// Only delete if all entries are older than the timestamp
-		if lastEntry < beforeTimestamp {
-			if err := os.Remove(path); err != nil {
-				return fmt.Errorf("wal: failed to remove segment %d: %w", segNum, err)
+		if meta.MaxTime >= beforeTimestamp {
+			if scanned {
+				if err := w.writeSegmentMeta(segNum, meta); err != nil {
+					fmt.Printf("wal: failed to write metadata of segment %d: %v\n", segNum, err)
+				}
 			}
+			continue
+		}
+
+		// Remove the metadata first, so a segment is never left behind
+		// without being scanned again
+		if err := os.Remove(w.segmentMetaPath(segNum)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("wal: failed to remove metadata of segment %d: %w", segNum, err)
 		}
+		if err := os.Remove(w.segmentPath(segNum)); err != nil {
+			return fmt.Errorf("wal: failed to remove segment %d: %w", segNum, err)
+		}
+	}
+
+	return nil
+}
+
+// Sync flushes buffered entries and syncs the current segment to disk.
+// Entries are already synced as they are written; Sync lets a shutdown
+// make sure of it before closing.
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+	if err := w.writer.Flush(); err != nil {
+		return fmt.Errorf("wal: failed to flush: %w", err)
 	}
+	if err := w.syncFile(); err != nil {
+		return fmt.Errorf("wal: failed to sync: %w", err)
+	}
+	return nil
+}
 
+// syncFile fsyncs the current segment, recording the duration for Stats
+// and reporting it to OnSync.
+// w.mu must be held.
+func (w *WAL) syncFile() error {
+	start := time.Now()
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.lastSyncTime = time.Now()
+	w.lastSync = w.lastSyncTime.Sub(start)
+	if w.onSync != nil {
+		w.onSync(w.lastSync)
+	}
 	return nil
 }
 
@@ -264,6 +447,7 @@ func (w *WAL) Close() error {
 	}
 
 	if w.file != nil {
+		w.sealSegment()
 		return w.file.Close()
 	}
 
@@ -272,16 +456,23 @@ func (w *WAL) Close() error {
 
 // rotate creates a new WAL segment
 func (w *WAL) rotate() error {
-	// Close current file
+	// Close current file, synced as it may end with fragments of an entry
+	// not synced yet
 	if w.writer != nil {
 		if err := w.writer.Flush(); err != nil {
 			return err
 		}
 	}
+	if w.file != nil {
+		if err := w.syncFile(); err != nil {
+			return err
+		}
+	}
 	if w.file != nil {
 		if err := w.file.Close(); err != nil {
 			return err
 		}
+		w.sealSegment()
 	}
 
 	// Increment segment number
@@ -311,15 +502,33 @@ func (w *WAL) openSegment(segNum int) error {
 	w.writer = bufio.NewWriter(file)
 	w.size = stat.Size()
 
+	// Pick up the metadata of a segment written before; entries that can't
+	// be read are left to replay to report
+	if w.size == 0 {
+		w.meta = newSegmentMeta()
+	} else {
+		w.meta, _, _ = w.readSegmentMeta(segNum)
+		w.meta.Size = w.size
+	}
+
 	return nil
 }
 
+// sealSegment writes the metadata of the current segment. The metadata
+// only saves Truncate a scan, so failing to write it is not an error.
+func (w *WAL) sealSegment() {
+	if err := w.writeSegmentMeta(w.currentSegment, w.meta); err != nil {
+		fmt.Printf("wal: failed to write metadata of segment %d: %v\n", w.currentSegment, err)
+	}
+}
+
 // segmentPath returns the file path for a segment
 func (w *WAL) segmentPath(segNum int) string {
 	return filepath.Join(w.dir, fmt.Sprintf("wal-%08d", segNum))
 }
 
-// listSegments returns all segment numbers in ascending order
+// listSegments returns all segment numbers in ascending order, skipping
+// metadata sidecars
 func (w *WAL) listSegments() ([]int, error) {
 	files, err := os.ReadDir(w.dir)
 	if err != nil {
@@ -336,7 +545,7 @@ func (w *WAL) listSegments() ([]int, error) {
 		}
 
 		var segNum int
-		if _, err := fmt.Sscanf(file.Name(), "wal-%08d", &segNum); err == nil {
+		if _, err := fmt.Sscanf(file.Name(), "wal-%08d", &segNum); err == nil && file.Name() == filepath.Base(w.segmentPath(segNum)) {
 			segments = append(segments, segNum)
 		}
 	}
@@ -345,8 +554,9 @@ func (w *WAL) listSegments() ([]int, error) {
 	return segments, nil
 }
 
-// replaySegment reads all entries from a specific segment
-func (w *WAL) replaySegment(segNum int) ([]Entry, error) {
+// replaySegment reads all entries from a specific segment, joining split
+// entries with the fragments that preceded them
+func (w *WAL) replaySegment(segNum int, fragments *reassembler) ([]Entry, error) {
 	path := w.segmentPath(segNum)
 
 	file, err := os.Open(path)
@@ -368,123 +578,246 @@ func (w *WAL) replaySegment(segNum int) ([]Entry, error) {
 			fmt.Printf("wal: corrupted entry in segment %d: %v\n", segNum, err)
 			break
 		}
+		if entry = fragments.add(entry); entry == nil {
+			continue
+		}
+		if entry.Type == entryTypeBatch {
+			entries = append(entries, entry.samplesEntries()...)
+			continue
+		}
 		entries = append(entries, *entry)
 	}
 
 	return entries, nil
 }
 
-// getLastEntryTimestamp returns the timestamp of the last entry in a segment
-func (w *WAL) getLastEntryTimestamp(path string) (int64, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return 0, err
-	}
-	defer file.Close()
-
-	reader := bufio.NewReader(file)
-	var lastTimestamp int64
+// splitEntry splits a samples or batch entry larger than limit bytes into
+// fragments of at most limit bytes. A series' labels are never split, so a
+// fragment holding a single sample may still be larger. Other entries are
+// returned as is.
+func splitEntry(entry *Entry, limit int64) []*Entry {
+	if int64(encodedSize(entry)) <= limit {
+		return []*Entry{entry}
+	}
+
+	var fragments []*Entry
+	switch entry.Type {
+	case entryTypeSamples:
+		perFragment := (limit - entryHeaderSize - int64(seriesSize(entry.Series)) - 4) / 16
+		for samples := entry.Samples; len(samples) > 0; {
+			n := int(min(max(perFragment, 1), int64(len(samples))))
+			fragments = append(fragments, &Entry{
+				Type:      entryTypeSamples,
+				Timestamp: entry.Timestamp,
+				Series:    entry.Series,
+				Samples:   samples[:n],
+			})
+			samples = samples[n:]
+		}
 
-	for {
-		entry, err := decodeEntry(reader)
-		if err == io.EOF {
-			break
+	case entryTypeBatch:
+		fragment := &Entry{Type: entryTypeBatch, Timestamp: entry.Timestamp}
+		size := int64(entryHeaderSize + 4)
+		for _, r := range entry.Records {
+			samples := r.Samples
+			for {
+				free := (limit - size - int64(seriesSize(r.Series)) - 4) / 16
+				if int64(len(samples)) <= free || (len(fragment.Records) == 0 && len(samples) <= 1) {
+					fragment.Records = append(fragment.Records, Record{Series: r.Series, Samples: samples})
+					size += int64(seriesSize(r.Series) + samplesSize(samples))
+					break
+				}
+
+				// Fill the fragment with what fits and start the next one
+				if free > 0 || len(fragment.Records) == 0 {
+					n := int(max(free, 1))
+					fragment.Records = append(fragment.Records, Record{Series: r.Series, Samples: samples[:n]})
+					samples = samples[n:]
+				}
+				fragments = append(fragments, fragment)
+				fragment = &Entry{Type: entryTypeBatch, Timestamp: entry.Timestamp}
+				size = entryHeaderSize + 4
+			}
 		}
-		if err != nil {
-			return 0, err
+		fragments = append(fragments, fragment)
+	}
+
+	if len(fragments) < 2 {
+		return []*Entry{entry}
+	}
+	for i, fragment := range fragments {
+		switch i {
+		case 0:
+			fragment.fragment = fragmentFirst
+		case len(fragments) - 1:
+			fragment.fragment = fragmentLast
+		default:
+			fragment.fragment = fragmentMiddle
 		}
-		lastTimestamp = entry.Timestamp
 	}
+	return fragments
+}
 
-	return lastTimestamp, nil
+// reassembler joins the fragments of split entries as they are read
+type reassembler struct {
+	pending *Entry
 }
 
-// encodeEntry serializes an entry to bytes
-func encodeEntry(entry *Entry) ([]byte, error) {
-	// Calculate payload size
+// add takes the next entry read and returns the complete entry it ends, or
+// nil if more fragments must be read first. Fragments that don't continue
+// an entry, such as the start of an entry torn by a crash, are dropped.
+func (a *reassembler) add(entry *Entry) *Entry {
+	switch entry.fragment {
+	case fragmentNone:
+		a.pending = nil
+		return entry
+	case fragmentFirst:
+		entry.fragment = fragmentNone
+		a.pending = entry
+		return nil
+	}
+
+	pending := a.pending
+	if pending == nil || pending.Type != entry.Type {
+		a.pending = nil
+		return nil
+	}
+	pending.Samples = append(pending.Samples, entry.Samples...)
+	pending.Records = append(pending.Records, entry.Records...)
+	if entry.fragment != fragmentLast {
+		return nil
+	}
+	a.pending = nil
+	return pending
+}
+
+// encodedSize returns the number of bytes encodeEntry produces for entry
+func encodedSize(entry *Entry) int {
 	payloadSize := 0
 
-	if entry.Series != nil {
-		// Series labels
-		payloadSize += 4 // number of labels
-		for k, v := range entry.Series.Labels {
-			payloadSize += 4 + len(k) + 4 + len(v)
+	if entry.Type == entryTypeBatch {
+		payloadSize += 4 // number of records
+		for _, r := range entry.Records {
+			payloadSize += seriesSize(r.Series) + samplesSize(r.Samples)
 		}
-		payloadSize += 8 // hash
+		return entryHeaderSize + payloadSize
+	}
+
+	if entry.Series != nil {
+		payloadSize += seriesSize(entry.Series)
 	}
 
 	if entry.Samples != nil {
-		// Samples
-		payloadSize += 4 // number of samples
-		payloadSize += len(entry.Samples) * 16 // timestamp(8) + value(8)
+		payloadSize += samplesSize(entry.Samples)
 	}
 
-	totalSize := entryHeaderSize + payloadSize
-	buf := make([]byte, totalSize)
+	return entryHeaderSize + payloadSize
+}
 
-	// Write header
-	offset := 0
-	buf[offset] = walVersion
-	offset++
-	buf[offset] = entry.Type
-	offset++
-	binary.BigEndian.PutUint32(buf[offset:], uint32(payloadSize))
-	offset += 4
-	// Checksum will be filled later
-	offset += 4
-	binary.BigEndian.PutUint64(buf[offset:], uint64(entry.Timestamp))
-	offset += 8
-	// Reserved
-	offset += 2
+// seriesSize returns the encoded size of a series' labels and hash
+func seriesSize(s *series.Series) int {
+	size := 4 // number of labels
+	for k, v := range s.Labels {
+		size += 4 + len(k) + 4 + len(v)
+	}
+	return size + 8 // hash
+}
 
-	// Write payload
-	if entry.Series != nil {
-		// Write labels
-		binary.BigEndian.PutUint32(buf[offset:], uint32(len(entry.Series.Labels)))
-		offset += 4
+// samplesSize returns the encoded size of samples
+func samplesSize(samples []series.Sample) int {
+	return 4 + len(samples)*16 // count + timestamp(8) + value(8) each
+}
 
-		// Sort labels for deterministic encoding
-		keys := make([]string, 0, len(entry.Series.Labels))
-		for k := range entry.Series.Labels {
-			keys = append(keys, k)
-		}
-		sort.Strings(keys)
+// encodeEntry serializes an entry to a newly allocated buffer
+func encodeEntry(entry *Entry) ([]byte, error) {
+	return appendEntry(make([]byte, 0, encodedSize(entry)), entry), nil
+}
 
-		for _, k := range keys {
-			v := entry.Series.Labels[k]
-			binary.BigEndian.PutUint32(buf[offset:], uint32(len(k)))
-			offset += 4
-			copy(buf[offset:], k)
-			offset += len(k)
-			binary.BigEndian.PutUint32(buf[offset:], uint32(len(v)))
-			offset += 4
-			copy(buf[offset:], v)
-			offset += len(v)
-		}
+// writeEntry encodes entry into w. Entries that fit in the writer's free
+// buffer space are encoded in place without an intermediate copy; larger
+// ones go through a pooled buffer. Returns the number of bytes written.
+func writeEntry(w *bufio.Writer, entry *Entry) (int, error) {
+	if encodedSize(entry) <= w.Available() {
+		return w.Write(appendEntry(w.AvailableBuffer(), entry))
+	}
 
-		// Write hash
-		binary.BigEndian.PutUint64(buf[offset:], entry.Series.Hash)
-		offset += 8
+	bp := entryBufPool.Get().(*[]byte)
+	buf := appendEntry((*bp)[:0], entry)
+	n, err := w.Write(buf)
+	if cap(buf) <= maxPooledBufferSize {
+		*bp = buf
+		entryBufPool.Put(bp)
 	}
+	return n, err
+}
 
-	if entry.Samples != nil {
-		// Write samples
-		binary.BigEndian.PutUint32(buf[offset:], uint32(len(entry.Samples)))
-		offset += 4
+// appendEntry appends the serialized entry to dst and returns the extended buffer
+func appendEntry(dst []byte, entry *Entry) []byte {
+	start := len(dst)
+	payloadSize := encodedSize(entry) - entryHeaderSize
+
+	// Write header
+	dst = append(dst, walVersion, entry.Type)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(payloadSize))
+	// Checksum will be filled later
+	dst = binary.BigEndian.AppendUint32(dst, 0)
+	dst = binary.BigEndian.AppendUint64(dst, uint64(entry.Timestamp))
+	// Fragment position and reserved
+	dst = append(dst, entry.fragment, 0)
 
-		for _, sample := range entry.Samples {
-			binary.BigEndian.PutUint64(buf[offset:], uint64(sample.Timestamp))
-			offset += 8
-			binary.BigEndian.PutUint64(buf[offset:], uint64(sample.Value))
-			offset += 8
+	// Write payload
+	if entry.Type == entryTypeBatch {
+		dst = binary.BigEndian.AppendUint32(dst, uint32(len(entry.Records)))
+		for _, r := range entry.Records {
+			dst = appendSeries(dst, r.Series)
+			dst = appendSamples(dst, r.Samples)
+		}
+	} else {
+		if entry.Series != nil {
+			dst = appendSeries(dst, entry.Series)
+		}
+		if entry.Samples != nil {
+			dst = appendSamples(dst, entry.Samples)
 		}
 	}
 
 	// Calculate and write checksum (skip version, type, length, and checksum fields)
-	checksum := crc32.ChecksumIEEE(buf[10:])
-	binary.BigEndian.PutUint32(buf[6:], checksum)
+	checksum := crc32.ChecksumIEEE(dst[start+10:])
+	binary.BigEndian.PutUint32(dst[start+6:], checksum)
+
+	return dst
+}
+
+// appendSeries appends a series' labels, sorted by name, and its hash
+func appendSeries(dst []byte, s *series.Series) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(s.Labels)))
+
+	// Sort labels for deterministic encoding
+	keys := make([]string, 0, len(s.Labels))
+	for k := range s.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
 
-	return buf, nil
+	for _, k := range keys {
+		v := s.Labels[k]
+		dst = binary.BigEndian.AppendUint32(dst, uint32(len(k)))
+		dst = append(dst, k...)
+		dst = binary.BigEndian.AppendUint32(dst, uint32(len(v)))
+		dst = append(dst, v...)
+	}
+
+	return binary.BigEndian.AppendUint64(dst, s.Hash)
+}
+
+// appendSamples appends a sample count followed by the samples
+func appendSamples(dst []byte, samples []series.Sample) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(samples)))
+	for _, sample := range samples {
+		dst = binary.BigEndian.AppendUint64(dst, uint64(sample.Timestamp))
+		dst = binary.BigEndian.AppendUint64(dst, math.Float64bits(sample.Value))
+	}
+	return dst
 }
 
 // decodeEntry deserializes an entry from a reader
@@ -497,7 +830,7 @@ func decodeEntry(r *bufio.Reader) (*Entry, error) {
 
 	// Parse header
 	version := header[0]
-	if version != walVersion {
+	if version != walVersion && version != walVersionLegacy {
 		return nil, fmt.Errorf("wal: unsupported version %d", version)
 	}
 
@@ -513,7 +846,7 @@ func decodeEntry(r *bufio.Reader) (*Entry, error) {
 	}
 
 	// Verify checksum
-	computedChecksum := crc32.ChecksumIEEE(append(header[10:], payload...))
+	computedChecksum := crc32.Update(crc32.ChecksumIEEE(header[10:]), crc32.IEEETable, payload)
 	if storedChecksum != computedChecksum {
 		return nil, ErrCorrupted
 	}
@@ -521,79 +854,121 @@ func decodeEntry(r *bufio.Reader) (*Entry, error) {
 	entry := &Entry{
 		Type:      entryType,
 		Timestamp: timestamp,
+		fragment:  header[18],
 	}
 
 	// Decode payload based on type
-	if entryType == entryTypeSamples {
-		offset := 0
+	switch entryType {
+	case entryTypeSamples:
+		d := payloadDecoder{payload: payload, version: version}
+		entry.Series = d.series()
+		entry.Samples = d.samples()
+		if d.err != nil {
+			return nil, d.err
+		}
 
-		// Read labels
-		if offset+4 > len(payload) {
+	case entryTypeBatch:
+		d := payloadDecoder{payload: payload, version: version}
+		n := d.uint32()
+		// Each record takes at least 16 bytes; don't trust a corrupt count
+		if d.err == nil && int(n) > len(payload)/16 {
 			return nil, ErrCorrupted
 		}
-		numLabels := binary.BigEndian.Uint32(payload[offset:])
-		offset += 4
+		entry.Records = make([]Record, 0, n)
+		for i := 0; i < int(n) && d.err == nil; i++ {
+			entry.Records = append(entry.Records, Record{Series: d.series(), Samples: d.samples()})
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+	}
 
-		labels := make(map[string]string, numLabels)
-		for i := 0; i < int(numLabels); i++ {
-			if offset+4 > len(payload) {
-				return nil, ErrCorrupted
-			}
-			keyLen := binary.BigEndian.Uint32(payload[offset:])
-			offset += 4
+	return entry, nil
+}
 
-			if offset+int(keyLen) > len(payload) {
-				return nil, ErrCorrupted
-			}
-			key := string(payload[offset : offset+int(keyLen)])
-			offset += int(keyLen)
+// payloadDecoder reads series and samples from an entry payload. Reads
+// past the end set err to ErrCorrupted and return zero values.
+type payloadDecoder struct {
+	payload []byte
+	offset  int
+	version uint8
+	err     error
+}
 
-			if offset+4 > len(payload) {
-				return nil, ErrCorrupted
-			}
-			valLen := binary.BigEndian.Uint32(payload[offset:])
-			offset += 4
+// need reports whether n more bytes are available
+func (d *payloadDecoder) need(n int) bool {
+	if d.err == nil && d.offset+n > len(d.payload) {
+		d.err = ErrCorrupted
+	}
+	return d.err == nil
+}
 
-			if offset+int(valLen) > len(payload) {
-				return nil, ErrCorrupted
-			}
-			val := string(payload[offset : offset+int(valLen)])
-			offset += int(valLen)
+func (d *payloadDecoder) uint32() uint32 {
+	if !d.need(4) {
+		return 0
+	}
+	v := binary.BigEndian.Uint32(d.payload[d.offset:])
+	d.offset += 4
+	return v
+}
 
-			labels[key] = val
-		}
+func (d *payloadDecoder) uint64() uint64 {
+	if !d.need(8) {
+		return 0
+	}
+	v := binary.BigEndian.Uint64(d.payload[d.offset:])
+	d.offset += 8
+	return v
+}
 
-		if offset+8 > len(payload) {
-			return nil, ErrCorrupted
-		}
-		hash := binary.BigEndian.Uint64(payload[offset:])
-		offset += 8
+func (d *payloadDecoder) string() string {
+	n := int(d.uint32())
+	if !d.need(n) {
+		return ""
+	}
+	s := string(d.payload[d.offset : d.offset+n])
+	d.offset += n
+	return s
+}
 
-		entry.Series = &series.Series{
-			Labels: labels,
-			Hash:   hash,
-		}
+// series reads labels and a hash
+func (d *payloadDecoder) series() *series.Series {
+	numLabels := d.uint32()
+	if d.err != nil {
+		return nil
+	}
 
-		// Read samples
-		if offset+4 > len(payload) {
-			return nil, ErrCorrupted
-		}
-		numSamples := binary.BigEndian.Uint32(payload[offset:])
-		offset += 4
+	labels := make(map[string]string, min(int(numLabels), len(d.payload)/8))
+	for i := 0; i < int(numLabels) && d.err == nil; i++ {
+		key := d.string()
+		labels[key] = d.string()
+	}
 
-		samples := make([]series.Sample, numSamples)
-		for i := 0; i < int(numSamples); i++ {
-			if offset+16 > len(payload) {
-				return nil, ErrCorrupted
-			}
-			samples[i].Timestamp = int64(binary.BigEndian.Uint64(payload[offset:]))
-			offset += 8
-			samples[i].Value = float64(binary.BigEndian.Uint64(payload[offset:]))
-			offset += 8
-		}
+	hash := d.uint64()
+	if d.err != nil {
+		return nil
+	}
+	return &series.Series{
+		Labels: labels,
+		Hash:   hash,
+	}
+}
 
-		entry.Samples = samples
+// samples reads a sample count followed by the samples
+func (d *payloadDecoder) samples() []series.Sample {
+	numSamples := int(d.uint32())
+	if !d.need(numSamples * 16) {
+		return nil
 	}
 
-	return entry, nil
+	samples := make([]series.Sample, numSamples)
+	for i := range samples {
+		samples[i].Timestamp = int64(d.uint64())
+		if d.version == walVersionLegacy {
+			samples[i].Value = float64(d.uint64())
+		} else {
+			samples[i].Value = math.Float64frombits(d.uint64())
+		}
+	}
+	return samples
 }
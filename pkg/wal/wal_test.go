@@ -1,9 +1,16 @@
 package wal
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/therealutkarshpriyadarshi/time/pkg/series"
 )
@@ -243,6 +250,125 @@ func TestWALTruncate(t *testing.T) {
 	w.Close()
 }
 
+func TestWALSegmentMeta(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{SegmentSize: 1024}
+
+	w, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to open WAL: %v", err)
+	}
+	defer w.Close()
+
+	start := time.Now().UnixMilli()
+	s := series.NewSeries(map[string]string{"__name__": "test_metric"})
+	for i := 0; i < 30; i++ {
+		if err := w.Append(s, []series.Sample{{Timestamp: int64(i), Value: 1}}); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+	end := time.Now().UnixMilli()
+
+	// Sealed segments have metadata covering their entries
+	segments, _ := w.listSegments()
+	if len(segments) < 3 {
+		t.Fatalf("expected at least 3 segments, got %d", len(segments))
+	}
+	entries := w.meta.Entries
+	for _, segNum := range segments[:len(segments)-1] {
+		meta, scanned, err := w.readSegmentMeta(segNum)
+		if err != nil || scanned {
+			t.Fatalf("segment %d: readSegmentMeta() scanned = %v, error = %v, want its sidecar", segNum, scanned, err)
+		}
+		if meta.ULID == "" || meta.Entries == 0 || meta.MinTime < start || meta.MaxTime > end || meta.MinTime > meta.MaxTime {
+			t.Errorf("segment %d: metadata %+v, want entries within [%d, %d]", segNum, meta, start, end)
+		}
+		entries += meta.Entries
+	}
+	if entries != 30 {
+		t.Errorf("metadata counts %d entries, want 30", entries)
+	}
+
+	// The current segment's metadata is picked up again on reopen
+	current := w.meta
+	w.Close()
+	w, err = Open(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen WAL: %v", err)
+	}
+	defer w.Close()
+	if w.meta != current {
+		t.Errorf("reopened segment metadata %+v, want %+v", w.meta, current)
+	}
+
+	// A segment with stale metadata is scanned, one with valid metadata
+	// isn't read at all, so corrupting it doesn't keep it
+	stale := SegmentMeta{MaxTime: math.MaxInt64}
+	if err := w.writeSegmentMeta(segments[0], stale); err != nil {
+		t.Fatal(err)
+	}
+	path := w.segmentPath(segments[1])
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[entryHeaderSize+4] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Truncate(end + 1); err != nil {
+		t.Fatalf("failed to truncate: %v", err)
+	}
+	if after, _ := w.listSegments(); len(after) != 1 || after[0] != segments[len(segments)-1] {
+		t.Errorf("segments after truncate %v, want only %d", after, segments[len(segments)-1])
+	}
+	sidecars, _ := filepath.Glob(filepath.Join(dir, "*"+segmentMetaSuffix))
+	if len(sidecars) != 1 || sidecars[0] != w.segmentMetaPath(w.currentSegment) {
+		t.Errorf("metadata after truncate %v, want only the current segment's", sidecars)
+	}
+}
+
+func TestWALStats(t *testing.T) {
+	w, err := Open(t.TempDir(), &Options{SegmentSize: 1024})
+	if err != nil {
+		t.Fatalf("failed to open WAL: %v", err)
+	}
+	defer w.Close()
+
+	stats, err := w.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if len(stats.Segments) != 1 || stats.Entries != 0 || stats.OldestEntry != 0 || !stats.LastSyncTime.IsZero() {
+		t.Errorf("empty WAL stats = %+v, want one empty segment", stats)
+	}
+
+	start := time.Now().UnixMilli()
+	s := series.NewSeries(map[string]string{"__name__": "test_metric"})
+	for i := 0; i < 30; i++ {
+		if err := w.Append(s, []series.Sample{{Timestamp: int64(i), Value: 1}}); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+
+	if stats, err = w.Stats(); err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	size, _ := w.Size()
+	if stats.Size != size || stats.Entries != 30 || stats.OldestEntry < start {
+		t.Errorf("stats = %+v, want 30 entries of %d bytes from %d on", stats, size, start)
+	}
+	if stats.LastSync <= 0 || stats.LastSyncTime.IsZero() {
+		t.Errorf("last sync %v at %v, want one recorded", stats.LastSync, stats.LastSyncTime)
+	}
+	for i, seg := range stats.Segments {
+		if last := i == len(stats.Segments)-1; seg.Current != last {
+			t.Errorf("segment %s current = %v, want %v", seg.Name, seg.Current, last)
+		}
+	}
+}
+
 func TestWALCrashRecovery(t *testing.T) {
 	dir := t.TempDir()
 
@@ -443,6 +569,123 @@ func TestWALConcurrentWrites(t *testing.T) {
 	}
 }
 
+func TestWALFloatValuesRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("failed to open WAL: %v", err)
+	}
+
+	s := series.NewSeries(map[string]string{"__name__": "float_metric"})
+	values := []float64{1.5, -0.25, 0, math.Copysign(0, -1), math.MaxFloat64, math.SmallestNonzeroFloat64, math.Inf(1), math.Inf(-1), math.NaN()}
+	samples := make([]series.Sample, len(values))
+	for i, v := range values {
+		samples[i] = series.Sample{Timestamp: int64(i), Value: v}
+	}
+
+	if err := w.Append(s, samples); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	w.Close()
+
+	w2, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("failed to reopen WAL: %v", err)
+	}
+	defer w2.Close()
+
+	entries, err := w2.Replay()
+	if err != nil {
+		t.Fatalf("failed to replay: %v", err)
+	}
+	if len(entries) != 1 || len(entries[0].Samples) != len(values) {
+		t.Fatalf("unexpected replay result: %+v", entries)
+	}
+
+	for i, want := range values {
+		got := entries[0].Samples[i].Value
+		if math.Float64bits(got) != math.Float64bits(want) {
+			t.Errorf("sample %d: expected %v, got %v", i, want, got)
+		}
+	}
+}
+
+func TestWALDecodeLegacyEntry(t *testing.T) {
+	entry := &Entry{
+		Type:      entryTypeSamples,
+		Timestamp: 1000,
+		Series:    series.NewSeries(map[string]string{"__name__": "legacy"}),
+		Samples:   []series.Sample{{Timestamp: 1000, Value: 42}},
+	}
+
+	data, err := encodeEntry(entry)
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+
+	// Rewrite as a version 1 entry, which stored values as truncated integers
+	data[0] = walVersionLegacy
+	valueOffset := len(data) - 8
+	binary.BigEndian.PutUint64(data[valueOffset:], uint64(42))
+	binary.BigEndian.PutUint32(data[6:10], crc32.ChecksumIEEE(data[10:]))
+
+	decoded, err := decodeEntry(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("failed to decode legacy entry: %v", err)
+	}
+	if got := decoded.Samples[0].Value; got != 42 {
+		t.Errorf("expected legacy value 42, got %v", got)
+	}
+}
+
+func TestWriteEntryLargerThanBuffer(t *testing.T) {
+	samples := make([]series.Sample, 1000)
+	for i := range samples {
+		samples[i] = series.Sample{Timestamp: int64(i), Value: float64(i) + 0.5}
+	}
+	entry := &Entry{
+		Type:      entryTypeSamples,
+		Timestamp: 1000,
+		Series:    series.NewSeries(map[string]string{"__name__": "large"}),
+		Samples:   samples,
+	}
+
+	// A 64-byte writer forces the pooled buffer path
+	var out bytes.Buffer
+	bw := bufio.NewWriterSize(&out, 64)
+	n, err := writeEntry(bw, entry)
+	if err != nil {
+		t.Fatalf("failed to write entry: %v", err)
+	}
+	if err := bw.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+
+	expected, _ := encodeEntry(entry)
+	if n != len(expected) || !bytes.Equal(out.Bytes(), expected) {
+		t.Fatalf("writeEntry output differs from encodeEntry (%d vs %d bytes)", n, len(expected))
+	}
+}
+
+func TestWriteEntryAllocs(t *testing.T) {
+	entry := &Entry{
+		Type:      entryTypeSamples,
+		Timestamp: 1000,
+		Samples:   []series.Sample{{Timestamp: 1000, Value: 1.5}},
+	}
+	bw := bufio.NewWriterSize(io.Discard, 4096)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := writeEntry(bw, entry); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations encoding into the write buffer, got %v", allocs)
+	}
+}
+
 func BenchmarkWALAppend(b *testing.B) {
 	dir := b.TempDir()
 
@@ -500,3 +743,300 @@ func BenchmarkWALReplay(b *testing.B) {
 		w2.Close()
 	}
 }
+
+func TestWALAppendBatch(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("failed to open WAL: %v", err)
+	}
+
+	s1 := series.NewSeries(map[string]string{"__name__": "batch_test", "host": "a"})
+	s2 := series.NewSeries(map[string]string{"__name__": "batch_test", "host": "b"})
+	if err := w.Append(s1, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	batch := []Record{
+		{Series: s1, Samples: []series.Sample{{Timestamp: 2000, Value: 2}}},
+		{Series: s2, Samples: []series.Sample{{Timestamp: 2000, Value: 3.5}, {Timestamp: 3000, Value: 4}}},
+	}
+	if err := w.AppendBatch(batch); err != nil {
+		t.Fatalf("failed to append batch: %v", err)
+	}
+	w.Close()
+
+	w, err = Open(dir, nil)
+	if err != nil {
+		t.Fatalf("failed to reopen WAL: %v", err)
+	}
+	entries, err := w.Replay()
+	w.Close()
+	if err != nil {
+		t.Fatalf("failed to replay: %v", err)
+	}
+
+	// Batch records replay as individual samples entries
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Type != entryTypeSamples {
+			t.Errorf("entry type = %d, want %d", entry.Type, entryTypeSamples)
+		}
+	}
+	last := entries[2]
+	if last.Series.Hash != s2.Hash || last.Series.Labels["host"] != "b" ||
+		len(last.Samples) != 2 || last.Samples[0].Value != 3.5 {
+		t.Errorf("unexpected batch record: %+v", last)
+	}
+
+	// A torn batch is dropped as a whole
+	path := filepath.Join(dir, "wal-00000000")
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat segment: %v", err)
+	}
+	if err := os.Truncate(path, stat.Size()-10); err != nil {
+		t.Fatalf("failed to truncate segment: %v", err)
+	}
+
+	w, err = Open(dir, nil)
+	if err != nil {
+		t.Fatalf("failed to reopen WAL: %v", err)
+	}
+	defer w.Close()
+	entries, err = w.Replay()
+	if err != nil {
+		t.Fatalf("failed to replay: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the entry before the torn batch, got %d entries", len(entries))
+	}
+}
+
+func TestWALSize(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := Open(dir, &Options{SegmentSize: 1024})
+	if err != nil {
+		t.Fatalf("failed to open WAL: %v", err)
+	}
+	defer w.Close()
+
+	s := series.NewSeries(map[string]string{"__name__": "test_metric"})
+	for i := 0; i < 50; i++ {
+		if err := w.Append(s, []series.Sample{{Timestamp: int64(i), Value: 1}}); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+
+	size, err := w.Size()
+	if err != nil {
+		t.Fatalf("failed to get size: %v", err)
+	}
+
+	// Size covers every segment on disk
+	segments, err := w.listSegments()
+	if err != nil {
+		t.Fatalf("failed to list segments: %v", err)
+	}
+	if len(segments) <= 1 {
+		t.Fatalf("expected multiple segments, got %d", len(segments))
+	}
+	var want int64
+	for _, segNum := range segments {
+		stat, err := os.Stat(w.segmentPath(segNum))
+		if err != nil {
+			t.Fatalf("failed to stat segment: %v", err)
+		}
+		want += stat.Size()
+	}
+	if size != want {
+		t.Errorf("expected size %d, got %d", want, size)
+	}
+}
+
+func TestWALOnSync(t *testing.T) {
+	var syncs int
+	w, err := Open(t.TempDir(), &Options{
+		SegmentSize: DefaultSegmentSize,
+		OnSync: func(d time.Duration) {
+			if d < 0 {
+				t.Errorf("negative sync duration %s", d)
+			}
+			syncs++
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to open WAL: %v", err)
+	}
+	defer w.Close()
+
+	s := series.NewSeries(map[string]string{"__name__": "test_metric"})
+	if err := w.Append(s, []series.Sample{{Timestamp: 1000, Value: 1}}); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := w.LogFlush(1000); err != nil {
+		t.Fatalf("failed to log flush: %v", err)
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+
+	if syncs != 3 {
+		t.Errorf("OnSync called %d times, want 3", syncs)
+	}
+}
+
+func TestWALCheckpoint(t *testing.T) {
+	w, err := Open(t.TempDir(), &Options{SegmentSize: 1024})
+	if err != nil {
+		t.Fatalf("failed to open WAL: %v", err)
+	}
+	defer w.Close()
+
+	s := series.NewSeries(map[string]string{"__name__": "test_metric"})
+	for i := 0; i < 30; i++ {
+		if err := w.Append(s, []series.Sample{{Timestamp: int64(i), Value: 1}}); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+
+	cp, err := w.Checkpoint()
+	if err != nil {
+		t.Fatalf("failed to checkpoint: %v", err)
+	}
+	defer cp.Close()
+	if len(cp.Segments) <= 1 {
+		t.Fatalf("expected multiple segments, got %d", len(cp.Segments))
+	}
+	size, err := w.Size()
+	if err != nil {
+		t.Fatalf("failed to get size: %v", err)
+	}
+	if cp.Size() != size {
+		t.Errorf("checkpoint size %d, want %d", cp.Size(), size)
+	}
+
+	// Later appends and truncation don't change the checkpoint
+	for i := 30; i < 40; i++ {
+		if err := w.Append(s, []series.Sample{{Timestamp: int64(i), Value: 1}}); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+	if err := w.Truncate(time.Now().Add(time.Hour).UnixMilli()); err != nil {
+		t.Fatalf("failed to truncate: %v", err)
+	}
+
+	restoreDir := t.TempDir()
+	for _, seg := range cp.Segments {
+		data, err := io.ReadAll(io.NewSectionReader(seg, 0, seg.Size))
+		if err != nil {
+			t.Fatalf("failed to read segment %s: %v", seg.Name, err)
+		}
+		if err := os.WriteFile(filepath.Join(restoreDir, seg.Name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restored, err := Open(restoreDir, nil)
+	if err != nil {
+		t.Fatalf("failed to open restored WAL: %v", err)
+	}
+	defer restored.Close()
+	entries, err := restored.Replay()
+	if err != nil {
+		t.Fatalf("failed to replay: %v", err)
+	}
+	if len(entries) != 30 {
+		t.Fatalf("expected 30 entries, got %d", len(entries))
+	}
+	if last := entries[len(entries)-1].Samples[0].Timestamp; last != 29 {
+		t.Errorf("last entry has timestamp %d, want 29", last)
+	}
+}
+
+func TestWALSplitEntry(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{SegmentSize: 1024}
+	w, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to open WAL: %v", err)
+	}
+
+	s1 := series.NewSeries(map[string]string{"__name__": "test_metric", "host": "server1"})
+	s2 := series.NewSeries(map[string]string{"__name__": "test_metric", "host": "server2"})
+	makeSamples := func(n int) []series.Sample {
+		samples := make([]series.Sample, n)
+		for i := range samples {
+			samples[i] = series.Sample{Timestamp: int64(i), Value: float64(i)}
+		}
+		return samples
+	}
+
+	// Both entries are many times the segment size
+	if err := w.Append(s1, makeSamples(1000)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := w.AppendBatch([]Record{{Series: s1, Samples: makeSamples(200)}, {Series: s2, Samples: makeSamples(200)}}); err != nil {
+		t.Fatalf("failed to append batch: %v", err)
+	}
+
+	segments, _ := w.listSegments()
+	if len(segments) < 10 {
+		t.Errorf("expected the entries spread over many segments, got %d", len(segments))
+	}
+	for _, segNum := range segments {
+		if stat, _ := os.Stat(w.segmentPath(segNum)); stat.Size() > opts.SegmentSize {
+			t.Errorf("segment %d is %d bytes, larger than the segment size", segNum, stat.Size())
+		}
+	}
+
+	// Only the whole of each entry is replayed
+	check := func() {
+		t.Helper()
+		entries, err := w.Replay()
+		if err != nil {
+			t.Fatalf("failed to replay: %v", err)
+		}
+		if len(entries) == 0 || len(entries[0].Samples) != 1000 || entries[0].Samples[999].Value != 999 {
+			t.Fatalf("split entry not reassembled: %d entries", len(entries))
+		}
+		counts := make(map[uint64]int)
+		for _, entry := range entries[1:] {
+			for i, sample := range entry.Samples {
+				if sample.Timestamp != int64(counts[entry.Series.Hash]+i) {
+					t.Fatalf("batch samples out of order for %v", entry.Series.Labels)
+				}
+			}
+			counts[entry.Series.Hash] += len(entry.Samples)
+		}
+		if counts[s1.Hash] != 200 || counts[s2.Hash] != 200 {
+			t.Errorf("batch replayed %v samples per series, want 200 each", counts)
+		}
+	}
+	check()
+
+	// An entry torn by a crash is dropped, and writing resumes after it
+	if err := w.Append(s2, makeSamples(1000)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	w.Close()
+	segments, _ = w.listSegments()
+	os.Remove(w.segmentPath(segments[len(segments)-1]))
+
+	w, err = Open(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen WAL: %v", err)
+	}
+	defer w.Close()
+	check()
+
+	if err := w.Append(s2, makeSamples(1)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	entries, _ := w.Replay()
+	if last := entries[len(entries)-1]; last.Series.Hash != s2.Hash || len(last.Samples) != 1 {
+		t.Errorf("entry after the torn one not replayed: %+v", last)
+	}
+}